@@ -0,0 +1,417 @@
+// Package apply applies the changes of a logical replication stream to another PostgreSQL
+// database, as a native logical replication subscription does.
+//
+// The progress of the applied stream is tracked with a replication origin in the target database.
+// Every target transaction records the end position of the last source transaction it applies as
+// the origin's position, so that progress is committed atomically with the data. After a restart
+// replication resumes from the origin's position, see Applier.StartLSN, and no transaction is
+// applied twice. The origin can be managed directly with CreateOrigin, EnsureOrigin, DropOrigin
+// and OriginProgress.
+//
+// Changes that conflict with the target data, such as an insert of a row that exists or an update
+// of a row that does not, are resolved by a ConflictResolver. A transaction that cannot be applied
+// can be skipped by setting Options.SkipLSN to its commit position, which is reported by
+// ConflictError, like ALTER SUBSCRIPTION ... SKIP does for a native subscription. Setting
+// sqlgen.Options.Upsert in Options.SQLOptions applies an insert of an existing row as an update
+// instead, which covers changes delivered again after a crash without a ConflictResolver.
+//
+// A ParallelApplier applies transactions on several connections concurrently and commits them in
+// the source commit order.
+//
+// Every source transaction is traced with the pglogrepl.Tracer of the context passed to
+// WriteChange, see pglogrepl.ContextWithTracer.
+//
+// Setting up a replication origin session requires superuser privileges or, since PostgreSQL 15,
+// the privileges granted on the pg_replication_origin functions.
+package apply
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/sqlgen"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Options configures an Applier.
+type Options struct {
+	// OriginName is the name of the replication origin tracking the progress. The origin is
+	// created if it does not exist. It is required.
+	OriginName string
+
+	// SQLOptions configures the generated statements.
+	SQLOptions sqlgen.Options
+
+	// MaxBatchTransactions is the number of source transactions after which the target
+	// transaction applying them is committed. If it is 0 then 1 is used, which commits every
+	// source transaction separately.
+	MaxBatchTransactions int
+	// MaxBatchChanges is the number of changes after which the target transaction is committed at
+	// the end of the current source transaction. If it is 0 there is no limit.
+	MaxBatchChanges int
+
+	// ConflictResolver resolves the conflicts of changes with the target data. If it is nil
+	// DefaultConflictResolver is used. Setting it wraps every insert and update in a savepoint so
+	// that the target transaction can continue after a unique violation.
+	ConflictResolver ConflictResolver
+	// SkipLSN is the commit position of a source transaction that is not applied. Its position
+	// is still recorded, so it is skipped once.
+	SkipLSN pglogrepl.LSN
+
+	// CopyMinRows is the number of consecutive inserts into the same relation from which they
+	// are applied with a single COPY FROM STDIN instead of an INSERT each, which speeds up initial
+	// loads and bulk imports. The values are copied in the format they were sent in, so the
+	// binary COPY format is used if the stream has the pgoutput binary option. If the COPY
+	// violates a unique constraint the inserts are applied one by one to resolve the conflict. If
+	// it is 0 every insert is applied with INSERT.
+	CopyMinRows int
+
+	// StatementCacheSize is the number of prepared statements the Applier keeps. The statements
+	// applying inserts, updates and deletes are prepared on first use and reused for the changes
+	// of the same relation and columns, instead of being parsed and planned for every change. If
+	// it is 0 statements are not prepared.
+	StatementCacheSize int
+
+	// TransactionAssemblerOptions configures the buffering of streamed transactions.
+	TransactionAssemblerOptions pglogrepl.TransactionAssemblerOptions
+}
+
+// Applier applies replicated transactions to a target database. It implements pglogrepl.Sink:
+// transactions are applied as they are committed and Flush commits the pending target
+// transaction and returns the position up to which the source transactions are applied.
+//
+// An Applier uses its connection exclusively: the connection is bound to the replication origin
+// and must not be used for anything else until Close is called. If WriteChange or Flush fails the
+// pending target transaction is rolled back and replication must be restarted from StartLSN.
+type Applier struct {
+	conn      *pgx.Conn
+	options   Options
+	relations *pglogrepl.RelationCache
+	assembler *pglogrepl.TransactionAssembler
+	// statements caches the prepared statements when the options have a StatementCacheSize.
+	statements *statementCache
+
+	tx           pgx.Tx
+	batchTxs     int
+	batchChanges int
+	pendingLSN   pglogrepl.LSN
+	pendingTime  time.Time
+	appliedLSN   pglogrepl.LSN
+}
+
+// New returns an Applier applying changes through conn, a regular connection to the target
+// database. It creates the replication origin if it does not exist, see EnsureOrigin, and binds
+// the session to it.
+func New(ctx context.Context, conn *pgx.Conn, options Options) (*Applier, error) {
+	if options.OriginName == "" {
+		return nil, fmt.Errorf("apply options have no origin name")
+	}
+	if options.MaxBatchTransactions <= 0 {
+		options.MaxBatchTransactions = 1
+	}
+
+	if err := EnsureOrigin(ctx, conn, options.OriginName); err != nil {
+		return nil, err
+	}
+	if err := SetupOriginSession(ctx, conn, options.OriginName); err != nil {
+		return nil, err
+	}
+
+	a := &Applier{
+		conn:      conn,
+		options:   options,
+		relations: pglogrepl.NewRelationCache(nil),
+		assembler: pglogrepl.NewTransactionAssembler(options.TransactionAssemblerOptions),
+	}
+	if options.StatementCacheSize > 0 {
+		a.statements = newStatementCache(conn.PgConn(), options.StatementCacheSize)
+	}
+	var err error
+	a.appliedLSN, err = a.StartLSN(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// StartLSN returns the position of the replication origin, which is the position to start
+// replication from. It is 0 if nothing has been applied yet.
+func (a *Applier) StartLSN(ctx context.Context) (pglogrepl.LSN, error) {
+	return OriginProgress(ctx, a.conn, a.options.OriginName, true)
+}
+
+// Relations returns the relations received from the source.
+func (a *Applier) Relations() *pglogrepl.RelationCache {
+	return a.relations
+}
+
+// WriteChange implements pglogrepl.Sink. It applies the transaction msg commits, if any, and
+// commits the target transaction once a batch threshold is reached.
+func (a *Applier) WriteChange(ctx context.Context, msg *pglogrepl.ReplicationMessage) error {
+	if msg.Message == nil {
+		return fmt.Errorf("stream does not decode pgoutput messages")
+	}
+	tx, err := a.assembler.Add(msg.Message)
+	if err != nil || tx == nil {
+		return err
+	}
+	if err := a.applyTransaction(ctx, tx); err != nil {
+		a.rollback(ctx)
+		return err
+	}
+	if a.batchTxs >= a.options.MaxBatchTransactions || (a.options.MaxBatchChanges > 0 && a.batchChanges >= a.options.MaxBatchChanges) {
+		return a.commit(ctx)
+	}
+	return nil
+}
+
+// Flush implements pglogrepl.Sink. It commits the pending target transaction and returns the end
+// position of the last applied source transaction.
+func (a *Applier) Flush(ctx context.Context) (pglogrepl.LSN, error) {
+	if err := a.commit(ctx); err != nil {
+		return a.appliedLSN, err
+	}
+	return a.appliedLSN, nil
+}
+
+// Close rolls back the pending target transaction, deallocates the prepared statements, reverts
+// the session from the replication origin and removes the spill files of streamed transactions in
+// progress. It does not close the connection.
+func (a *Applier) Close(ctx context.Context) error {
+	a.rollback(ctx)
+	a.assembler.Close()
+	if a.statements != nil {
+		if err := a.statements.close(ctx); err != nil {
+			return err
+		}
+	}
+	return ResetOriginSession(ctx, a.conn)
+}
+
+func (a *Applier) applyTransaction(ctx context.Context, tx *pglogrepl.Transaction) (err error) {
+	skip := a.skip(tx)
+	ctx, span := startTransactionSpan(ctx, tx, skip)
+	defer func() { span.End(err) }()
+
+	changes, err := prepareTransaction(a.relations, tx, skip, a.options.SQLOptions)
+	if err != nil {
+		return err
+	}
+	return a.applyPrepared(ctx, tx, changes)
+}
+
+// startTransactionSpan starts the span of applying tx with the pglogrepl.Tracer of ctx. The span
+// of an Applier ends when the changes are executed, before the batch is committed, while the span
+// of a ParallelApplier worker includes the commit.
+func startTransactionSpan(ctx context.Context, tx *pglogrepl.Transaction, skip bool) (context.Context, pglogrepl.Span) {
+	return pglogrepl.StartSpan(ctx, "pglogrepl.apply.Transaction",
+		pglogrepl.Int64Attribute(pglogrepl.AttributeXid, int64(tx.Xid)),
+		pglogrepl.LSNAttribute(pglogrepl.AttributeCommitLSN, tx.CommitLSN),
+		pglogrepl.LSNAttribute(pglogrepl.AttributeEndLSN, tx.EndLSN),
+		pglogrepl.Int64Attribute(pglogrepl.AttributeChanges, int64(len(tx.Changes))),
+		pglogrepl.BoolAttribute(pglogrepl.AttributeSkipped, skip),
+	)
+}
+
+// skip reports whether tx is not applied: it was applied before a restart or it is the
+// transaction of SkipLSN.
+func (a *Applier) skip(tx *pglogrepl.Transaction) bool {
+	return tx.EndLSN <= a.appliedLSN || (a.options.SkipLSN != 0 && tx.CommitLSN == a.options.SkipLSN)
+}
+
+// preparedChange is a change of a transaction with the statement applying it.
+type preparedChange struct {
+	change   pglogrepl.Message
+	relation *pglogrepl.RelationMessage
+	stmt     *sqlgen.Statement
+}
+
+// prepareTransaction records the relations of tx in relations and generates the statements
+// applying its changes, unless skip is set: a skipped transaction still records its relations.
+func prepareTransaction(relations *pglogrepl.RelationCache, tx *pglogrepl.Transaction, skip bool, options sqlgen.Options) ([]preparedChange, error) {
+	var changes []preparedChange
+	for _, change := range tx.Changes {
+		relations.Update(change)
+		if skip {
+			continue
+		}
+		stmt, err := sqlgen.Generate(relations, change, options)
+		if err != nil {
+			return nil, err
+		}
+		if stmt == nil {
+			continue
+		}
+		var rel *pglogrepl.RelationMessage
+		if id, ok := changeRelationID(change); ok {
+			rel, _ = relations.Relation(id)
+		}
+		changes = append(changes, preparedChange{change: change, relation: rel, stmt: stmt})
+	}
+	return changes, nil
+}
+
+// changeRelationID returns the relation of an insert, update or delete.
+func changeRelationID(change pglogrepl.Message) (uint32, bool) {
+	switch change := change.(type) {
+	case *pglogrepl.InsertMessage:
+		return change.RelationID, true
+	case *pglogrepl.UpdateMessage:
+		return change.RelationID, true
+	case *pglogrepl.DeleteMessage:
+		return change.RelationID, true
+	}
+	return 0, false
+}
+
+func (a *Applier) applyPrepared(ctx context.Context, tx *pglogrepl.Transaction, changes []preparedChange) error {
+	for i := 0; i < len(changes); {
+		if a.tx == nil {
+			var err error
+			if a.tx, err = a.conn.Begin(ctx); err != nil {
+				return fmt.Errorf("failed to begin transaction: %w", err)
+			}
+		}
+		if n := a.copyRun(changes[i:]); n > 0 {
+			if err := a.execCopy(ctx, tx, changes[i:i+n]); err != nil {
+				return err
+			}
+			a.batchChanges += n
+			i += n
+			continue
+		}
+		if err := a.execChange(ctx, tx, changes[i]); err != nil {
+			return err
+		}
+		a.batchChanges++
+		i++
+	}
+	if tx.EndLSN <= a.appliedLSN {
+		return nil
+	}
+	a.batchTxs++
+	a.pendingLSN = tx.EndLSN
+	a.pendingTime = tx.CommitTime
+	return nil
+}
+
+// copyRun returns the number of inserts starting changes that are applied with COPY, 0 if the
+// first change is not applied with COPY.
+func (a *Applier) copyRun(changes []preparedChange) int {
+	if a.options.CopyMinRows <= 0 || len(changes) < a.options.CopyMinRows {
+		return 0
+	}
+	first, ok := changes[0].change.(*pglogrepl.InsertMessage)
+	if !ok {
+		return 0
+	}
+	format, ok := sqlgen.CopyFormat(first.Tuple)
+	if !ok {
+		return 0
+	}
+	n := 1
+	for ; n < len(changes); n++ {
+		insert, ok := changes[n].change.(*pglogrepl.InsertMessage)
+		if !ok || insert.RelationID != first.RelationID {
+			break
+		}
+		if f, ok := sqlgen.CopyFormat(insert.Tuple); !ok || f != format {
+			break
+		}
+	}
+	if n < a.options.CopyMinRows {
+		return 0
+	}
+	return n
+}
+
+// execCopy applies changes, inserts into the same relation, with COPY. The COPY is wrapped in a
+// savepoint so that the inserts can be applied one by one if it violates a unique constraint.
+func (a *Applier) execCopy(ctx context.Context, tx *pglogrepl.Transaction, changes []preparedChange) error {
+	inserts := make([]*pglogrepl.InsertMessage, len(changes))
+	for i, change := range changes {
+		inserts[i] = change.change.(*pglogrepl.InsertMessage)
+	}
+	stmt, err := sqlgen.Copy(changes[0].relation, inserts, a.options.SQLOptions)
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.tx.Exec(ctx, "savepoint pglogrepl_apply_copy"); err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	if _, err := stmt.Exec(ctx, a.conn.PgConn()); err != nil {
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != uniqueViolation {
+			return fmt.Errorf("failed to copy changes of transaction %d: %w", tx.Xid, err)
+		}
+		if _, err := a.tx.Exec(ctx, "rollback to savepoint pglogrepl_apply_copy"); err != nil {
+			return fmt.Errorf("failed to roll back to savepoint: %w", err)
+		}
+		for _, change := range changes {
+			if err := a.execChange(ctx, tx, change); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if _, err := a.tx.Exec(ctx, "release savepoint pglogrepl_apply_copy"); err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return nil
+}
+
+// exec executes stmt, as a prepared statement if the options have a StatementCacheSize.
+func (a *Applier) exec(ctx context.Context, stmt *sqlgen.Statement) (pgconn.CommandTag, error) {
+	if a.statements != nil {
+		return a.statements.exec(ctx, stmt)
+	}
+	return stmt.Exec(ctx, a.conn.PgConn()).Close()
+}
+
+func (a *Applier) commit(ctx context.Context) error {
+	if a.pendingLSN <= a.appliedLSN {
+		return nil
+	}
+	if a.tx == nil {
+		// The transactions had no changes. Their position is still recorded so that it can be
+		// confirmed.
+		var err error
+		if a.tx, err = a.conn.Begin(ctx); err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+	}
+	if err := SetupOriginTransaction(ctx, a.tx, a.pendingLSN, a.pendingTime); err != nil {
+		a.rollback(ctx)
+		return err
+	}
+	if err := a.tx.Commit(ctx); err != nil {
+		a.tx = nil
+		a.resetBatch()
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	a.tx = nil
+	a.appliedLSN = a.pendingLSN
+	a.resetBatch()
+	return nil
+}
+
+func (a *Applier) rollback(ctx context.Context) {
+	if a.tx != nil {
+		a.tx.Rollback(ctx)
+		a.tx = nil
+	}
+	a.resetBatch()
+}
+
+func (a *Applier) resetBatch() {
+	a.batchTxs = 0
+	a.batchChanges = 0
+	a.pendingLSN = a.appliedLSN
+}
+
+var _ pglogrepl.Sink = (*Applier)(nil)
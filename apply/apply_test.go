@@ -0,0 +1,277 @@
+package apply_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/apply"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const originName = "pglogrepl_test"
+
+func connectTarget(t *testing.T, ctx context.Context) *pgx.Conn {
+	config, err := pgx.ParseConfig(os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	delete(config.RuntimeParams, "replication")
+
+	conn, err := pgx.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		conn.Exec(ctx, "drop table if exists pglogrepl_apply")
+		conn.Exec(ctx, "select pg_replication_origin_drop($1) from pg_replication_origin where roname = $1", originName)
+		require.NoError(t, conn.Close(ctx))
+	})
+
+	_, err = conn.Exec(ctx, "drop table if exists pglogrepl_apply")
+	require.NoError(t, err)
+	_, err = conn.Exec(ctx, "create table pglogrepl_apply (id int primary key, name text)")
+	require.NoError(t, err)
+	return conn
+}
+
+func TestApplier(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn := connectTarget(t, ctx)
+	applier, err := apply.New(ctx, conn, apply.Options{OriginName: originName})
+	require.NoError(t, err)
+	lsn, err := applier.StartLSN(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0), lsn)
+
+	rel := &pglogrepl.RelationMessage{
+		RelationID:      16384,
+		Namespace:       "public",
+		RelationName:    "pglogrepl_apply",
+		ReplicaIdentity: pglogrepl.ReplicaIdentityDefault,
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Flags: 1, Name: "id", DataType: 23},
+			{Name: "name", DataType: 25},
+		},
+		ColumnNum: 2,
+	}
+	insert := func(id, name string) *pglogrepl.InsertMessage {
+		return &pglogrepl.InsertMessage{RelationID: rel.RelationID, Tuple: &pglogrepl.TupleData{
+			ColumnNum: 2,
+			Columns: []*pglogrepl.TupleDataColumn{
+				{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(id)), Data: []byte(id)},
+				{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(name)), Data: []byte(name)},
+			},
+		}}
+	}
+	messages := []pglogrepl.Message{
+		&pglogrepl.BeginMessage{FinalLSN: 0x180, Xid: 700},
+		rel,
+		insert("1", "foo"),
+		insert("2", "bar"),
+		&pglogrepl.CommitMessage{CommitLSN: 0x180, TransactionEndLSN: 0x200},
+	}
+	write := func() {
+		for _, msg := range messages {
+			require.NoError(t, applier.WriteChange(ctx, &pglogrepl.ReplicationMessage{Message: msg}))
+		}
+	}
+	write()
+
+	lsn, err = applier.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x200), lsn)
+	lsn, err = applier.StartLSN(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x200), lsn)
+
+	// A transaction received again after a restart is skipped.
+	write()
+	_, err = applier.Flush(ctx)
+	require.NoError(t, err)
+	require.NoError(t, applier.Close(ctx))
+
+	var count int
+	require.NoError(t, conn.QueryRow(ctx, "select count(*) from pglogrepl_apply").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestApplierCopy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn := connectTarget(t, ctx)
+	applier, err := apply.New(ctx, conn, apply.Options{
+		OriginName:       originName,
+		CopyMinRows:      2,
+		ConflictResolver: apply.ResolutionOverwrite,
+	})
+	require.NoError(t, err)
+
+	rel := &pglogrepl.RelationMessage{
+		RelationID:   16384,
+		Namespace:    "public",
+		RelationName: "pglogrepl_apply",
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Flags: 1, Name: "id", DataType: 23},
+			{Name: "name", DataType: 25},
+		},
+		ColumnNum: 2,
+	}
+	insert := func(id, name string) *pglogrepl.InsertMessage {
+		return &pglogrepl.InsertMessage{RelationID: rel.RelationID, Tuple: &pglogrepl.TupleData{
+			ColumnNum: 2,
+			Columns: []*pglogrepl.TupleDataColumn{
+				{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(id)), Data: []byte(id)},
+				{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(name)), Data: []byte(name)},
+			},
+		}}
+	}
+	messages := []pglogrepl.Message{
+		&pglogrepl.BeginMessage{FinalLSN: 0x180, Xid: 700},
+		rel,
+		insert("1", "foo\tbar"),
+		insert("2", `back\slash`),
+		insert("3", "baz"),
+		&pglogrepl.CommitMessage{CommitLSN: 0x180, TransactionEndLSN: 0x200},
+		// The COPY of the second transaction violates the primary key, so its inserts are
+		// applied one by one and the conflict is overwritten.
+		&pglogrepl.BeginMessage{FinalLSN: 0x280, Xid: 701},
+		insert("3", "qux"),
+		insert("4", "quux"),
+		&pglogrepl.CommitMessage{CommitLSN: 0x280, TransactionEndLSN: 0x300},
+	}
+	for _, msg := range messages {
+		require.NoError(t, applier.WriteChange(ctx, &pglogrepl.ReplicationMessage{Message: msg}))
+	}
+	lsn, err := applier.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x300), lsn)
+	require.NoError(t, applier.Close(ctx))
+
+	rows, err := conn.Query(ctx, "select name from pglogrepl_apply order by id")
+	require.NoError(t, err)
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo\tbar", `back\slash`, "qux", "quux"}, names)
+}
+
+func TestApplierStatementCache(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn := connectTarget(t, ctx)
+	applier, err := apply.New(ctx, conn, apply.Options{OriginName: originName, StatementCacheSize: 2})
+	require.NoError(t, err)
+
+	rel := &pglogrepl.RelationMessage{
+		RelationID:   16384,
+		Namespace:    "public",
+		RelationName: "pglogrepl_apply",
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Flags: 1, Name: "id", DataType: 23},
+			{Name: "name", DataType: 25},
+		},
+		ColumnNum: 2,
+	}
+	tuple := func(id, name string) *pglogrepl.TupleData {
+		return &pglogrepl.TupleData{
+			ColumnNum: 2,
+			Columns: []*pglogrepl.TupleDataColumn{
+				{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(id)), Data: []byte(id)},
+				{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(name)), Data: []byte(name)},
+			},
+		}
+	}
+	messages := []pglogrepl.Message{
+		&pglogrepl.BeginMessage{FinalLSN: 0x180, Xid: 700},
+		rel,
+		&pglogrepl.InsertMessage{RelationID: rel.RelationID, Tuple: tuple("1", "foo")},
+		&pglogrepl.InsertMessage{RelationID: rel.RelationID, Tuple: tuple("2", "bar")},
+		&pglogrepl.InsertMessage{RelationID: rel.RelationID, Tuple: tuple("3", "baz")},
+		&pglogrepl.UpdateMessage{RelationID: rel.RelationID, NewTuple: tuple("2", "qux")},
+		&pglogrepl.CommitMessage{CommitLSN: 0x180, TransactionEndLSN: 0x200},
+		&pglogrepl.BeginMessage{FinalLSN: 0x280, Xid: 701},
+		&pglogrepl.DeleteMessage{RelationID: rel.RelationID, OldTupleType: pglogrepl.DeleteMessageTupleTypeKey, OldTuple: tuple("1", "")},
+		&pglogrepl.UpdateMessage{RelationID: rel.RelationID, NewTuple: tuple("3", "quux")},
+		&pglogrepl.CommitMessage{CommitLSN: 0x280, TransactionEndLSN: 0x300},
+	}
+	for _, msg := range messages {
+		require.NoError(t, applier.WriteChange(ctx, &pglogrepl.ReplicationMessage{Message: msg}))
+	}
+	_, err = applier.Flush(ctx)
+	require.NoError(t, err)
+
+	// The insert was evicted by the delete.
+	rows, err := conn.Query(ctx, "select statement from pg_prepared_statements where name like 'pglogrepl_apply_%' order by name")
+	require.NoError(t, err)
+	prepared, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	require.NoError(t, err)
+	require.Len(t, prepared, 2)
+	assert.Contains(t, prepared[0], "UPDATE")
+	assert.Contains(t, prepared[1], "DELETE")
+
+	require.NoError(t, applier.Close(ctx))
+	var count int
+	require.NoError(t, conn.QueryRow(ctx, "select count(*) from pg_prepared_statements where name like 'pglogrepl_apply_%'").Scan(&count))
+	assert.Equal(t, 0, count)
+
+	rows, err = conn.Query(ctx, "select name from pglogrepl_apply order by id")
+	require.NoError(t, err)
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"qux", "quux"}, names)
+}
+
+func TestApplierTruncate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn := connectTarget(t, ctx)
+	_, err := conn.Exec(ctx, "alter table pglogrepl_apply add column seq serial")
+	require.NoError(t, err)
+	_, err = conn.Exec(ctx, "insert into pglogrepl_apply (id, name) values (1, 'foo'), (2, 'bar')")
+	require.NoError(t, err)
+
+	applier, err := apply.New(ctx, conn, apply.Options{OriginName: originName})
+	require.NoError(t, err)
+	rel := &pglogrepl.RelationMessage{
+		RelationID:   16384,
+		Namespace:    "public",
+		RelationName: "pglogrepl_apply",
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Flags: 1, Name: "id", DataType: 23},
+			{Name: "name", DataType: 25},
+		},
+		ColumnNum: 2,
+	}
+	messages := []pglogrepl.Message{
+		&pglogrepl.BeginMessage{FinalLSN: 0x180, Xid: 700},
+		rel,
+		&pglogrepl.TruncateMessage{RelationNum: 1, Option: pglogrepl.TruncateOptionRestartIdentity, RelationIDs: []uint32{rel.RelationID}},
+		&pglogrepl.InsertMessage{RelationID: rel.RelationID, Tuple: &pglogrepl.TupleData{
+			ColumnNum: 2,
+			Columns: []*pglogrepl.TupleDataColumn{
+				{DataType: pglogrepl.TupleDataTypeText, Length: 1, Data: []byte("3")},
+				{DataType: pglogrepl.TupleDataTypeText, Length: 3, Data: []byte("baz")},
+			},
+		}},
+		&pglogrepl.CommitMessage{CommitLSN: 0x180, TransactionEndLSN: 0x200},
+	}
+	for _, msg := range messages {
+		require.NoError(t, applier.WriteChange(ctx, &pglogrepl.ReplicationMessage{Message: msg}))
+	}
+	_, err = applier.Flush(ctx)
+	require.NoError(t, err)
+	require.NoError(t, applier.Close(ctx))
+
+	// The rows were removed and the sequence of seq restarted.
+	var id, seq int
+	require.NoError(t, conn.QueryRow(ctx, "select id, seq from pglogrepl_apply").Scan(&id, &seq))
+	assert.Equal(t, 3, id)
+	assert.Equal(t, 1, seq)
+}
@@ -0,0 +1,252 @@
+package apply
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/sqlgen"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// uniqueViolation is the SQLSTATE of a unique constraint violation.
+const uniqueViolation = "23505"
+
+// ConflictType is the kind of a conflict between a replicated change and the target database.
+// The names follow the conflict types logged by PostgreSQL 18 subscriptions.
+type ConflictType int
+
+const (
+	// ConflictInsertExists is an insert violating a unique constraint.
+	ConflictInsertExists ConflictType = iota + 1
+	// ConflictUpdateExists is an update violating a unique constraint.
+	ConflictUpdateExists
+	// ConflictUpdateMissing is an update of a row that does not exist.
+	ConflictUpdateMissing
+	// ConflictDeleteMissing is a delete of a row that does not exist.
+	ConflictDeleteMissing
+)
+
+func (t ConflictType) String() string {
+	switch t {
+	case ConflictInsertExists:
+		return "insert_exists"
+	case ConflictUpdateExists:
+		return "update_exists"
+	case ConflictUpdateMissing:
+		return "update_missing"
+	case ConflictDeleteMissing:
+		return "delete_missing"
+	}
+	return fmt.Sprintf("ConflictType(%d)", int(t))
+}
+
+// Conflict describes a change that could not be applied as is.
+type Conflict struct {
+	Type ConflictType
+	// Relation is the relation of the change.
+	Relation *pglogrepl.RelationMessage
+	// Change is the insert, update or delete message.
+	Change pglogrepl.Message
+	// Xid and CommitLSN identify the source transaction of the change. CommitLSN can be used as
+	// Options.SkipLSN to skip the transaction.
+	Xid       uint32
+	CommitLSN pglogrepl.LSN
+	// Err is the unique violation error of ConflictInsertExists and ConflictUpdateExists.
+	Err error
+	// Tx is the target transaction. A custom ConflictResolver may use it to resolve the conflict
+	// itself and return ResolutionSkip.
+	Tx pgx.Tx
+}
+
+// Resolution is the way a conflict is resolved. A Resolution is a ConflictResolver resolving
+// every conflict the same way.
+type Resolution int
+
+const (
+	// ResolutionError fails the apply with a *ConflictError.
+	ResolutionError Resolution = iota
+	// ResolutionSkip skips the change.
+	ResolutionSkip
+	// ResolutionOverwrite applies the change anyway: an existing row is updated with the values
+	// of an insert and a missing row is inserted with the values of an update. There is nothing
+	// to overwrite for a missing row of a delete, which is skipped. ConflictUpdateExists cannot be
+	// overwritten and fails the apply.
+	ResolutionOverwrite
+)
+
+// ResolveConflict implements ConflictResolver.
+func (r Resolution) ResolveConflict(ctx context.Context, conflict *Conflict) (Resolution, error) {
+	return r, nil
+}
+
+// ConflictResolver decides how conflicts are resolved.
+type ConflictResolver interface {
+	// ResolveConflict returns the resolution of conflict. If it returns an error the apply fails
+	// with it.
+	ResolveConflict(ctx context.Context, conflict *Conflict) (Resolution, error)
+}
+
+// ConflictResolverFunc is an adapter to use a function as ConflictResolver.
+type ConflictResolverFunc func(ctx context.Context, conflict *Conflict) (Resolution, error)
+
+// ResolveConflict implements ConflictResolver.
+func (f ConflictResolverFunc) ResolveConflict(ctx context.Context, conflict *Conflict) (Resolution, error) {
+	return f(ctx, conflict)
+}
+
+// DefaultConflictResolver resolves conflicts like a native subscription: changes of missing rows
+// are skipped and unique violations fail the apply.
+var DefaultConflictResolver ConflictResolver = ConflictResolverFunc(func(ctx context.Context, conflict *Conflict) (Resolution, error) {
+	if conflict.Type == ConflictUpdateMissing || conflict.Type == ConflictDeleteMissing {
+		return ResolutionSkip, nil
+	}
+	return ResolutionError, nil
+})
+
+// TableConflictResolver resolves the conflicts of some tables differently.
+type TableConflictResolver struct {
+	// Tables are the resolvers of tables by schema qualified name, such as "public.users". The
+	// names are not quoted.
+	Tables map[string]ConflictResolver
+	// Default resolves the conflicts of other tables. If it is nil DefaultConflictResolver is
+	// used.
+	Default ConflictResolver
+}
+
+// ResolveConflict implements ConflictResolver.
+func (r *TableConflictResolver) ResolveConflict(ctx context.Context, conflict *Conflict) (Resolution, error) {
+	if resolver, ok := r.Tables[conflict.Relation.Namespace+"."+conflict.Relation.RelationName]; ok {
+		return resolver.ResolveConflict(ctx, conflict)
+	}
+	if r.Default != nil {
+		return r.Default.ResolveConflict(ctx, conflict)
+	}
+	return DefaultConflictResolver.ResolveConflict(ctx, conflict)
+}
+
+// ConflictError is returned when a conflict is resolved with ResolutionError.
+type ConflictError struct {
+	Conflict *Conflict
+}
+
+func (e *ConflictError) Error() string {
+	c := e.Conflict
+	msg := fmt.Sprintf("%s conflict on %s in transaction %d committed at %s", c.Type, sqlgen.QuoteTable(c.Relation), c.Xid, c.CommitLSN)
+	if c.Err != nil {
+		msg += ": " + c.Err.Error()
+	}
+	return msg
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Conflict.Err
+}
+
+// execChange executes the statement applying change and resolves the conflicts it causes.
+func (a *Applier) execChange(ctx context.Context, tx *pglogrepl.Transaction, prepared preparedChange) error {
+	change, stmt := prepared.change, prepared.stmt
+	var existsType, missingType ConflictType
+	switch change.(type) {
+	case *pglogrepl.InsertMessage:
+		existsType = ConflictInsertExists
+	case *pglogrepl.UpdateMessage:
+		existsType, missingType = ConflictUpdateExists, ConflictUpdateMissing
+	case *pglogrepl.DeleteMessage:
+		missingType = ConflictDeleteMissing
+	}
+
+	// A unique violation aborts the target transaction, so the statement is wrapped in a
+	// savepoint when the resolver may want to continue.
+	savepoint := existsType != 0 && a.options.ConflictResolver != nil
+	if savepoint {
+		if _, err := a.tx.Exec(ctx, "savepoint pglogrepl_apply"); err != nil {
+			return fmt.Errorf("failed to create savepoint: %w", err)
+		}
+	}
+
+	var conflictType ConflictType
+	tag, err := a.exec(ctx, stmt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if existsType == 0 || !errors.As(err, &pgErr) || pgErr.Code != uniqueViolation {
+			return fmt.Errorf("failed to apply change of transaction %d: %w", tx.Xid, err)
+		}
+		conflictType = existsType
+	} else {
+		if savepoint {
+			if _, err := a.tx.Exec(ctx, "release savepoint pglogrepl_apply"); err != nil {
+				return fmt.Errorf("failed to release savepoint: %w", err)
+			}
+		}
+		if missingType == 0 || tag.RowsAffected() > 0 {
+			return nil
+		}
+		conflictType = missingType
+	}
+
+	conflict := &Conflict{Type: conflictType, Relation: prepared.relation, Change: change, Xid: tx.Xid, CommitLSN: tx.CommitLSN, Err: err, Tx: a.tx}
+	if err != nil {
+		if !savepoint {
+			return &ConflictError{Conflict: conflict}
+		}
+		if _, err := a.tx.Exec(ctx, "rollback to savepoint pglogrepl_apply"); err != nil {
+			return fmt.Errorf("failed to roll back to savepoint: %w", err)
+		}
+	}
+
+	resolver := a.options.ConflictResolver
+	if resolver == nil {
+		resolver = DefaultConflictResolver
+	}
+	resolution, err := resolver.ResolveConflict(ctx, conflict)
+	if err != nil {
+		return err
+	}
+	switch resolution {
+	case ResolutionSkip:
+		return nil
+	case ResolutionOverwrite:
+		return a.overwrite(ctx, conflict)
+	}
+	return &ConflictError{Conflict: conflict}
+}
+
+// overwrite applies the change of conflict with ResolutionOverwrite.
+func (a *Applier) overwrite(ctx context.Context, conflict *Conflict) error {
+	var stmt *sqlgen.Statement
+	var err error
+	switch change := conflict.Change.(type) {
+	case *pglogrepl.InsertMessage:
+		update := &pglogrepl.UpdateMessage{RelationID: change.RelationID, NewTuple: change.Tuple}
+		stmt, err = sqlgen.Update(conflict.Relation, update, a.options.SQLOptions)
+	case *pglogrepl.UpdateMessage:
+		if conflict.Type != ConflictUpdateMissing {
+			return &ConflictError{Conflict: conflict}
+		}
+		for _, col := range change.NewTuple.Columns {
+			if col.DataType == pglogrepl.TupleDataTypeToast {
+				return fmt.Errorf("cannot insert missing row of %s: the update has unchanged TOAST values", sqlgen.QuoteTable(conflict.Relation))
+			}
+		}
+		insert := &pglogrepl.InsertMessage{RelationID: change.RelationID, Tuple: change.NewTuple}
+		stmt, err = sqlgen.Insert(conflict.Relation, insert, a.options.SQLOptions)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	tag, err := a.exec(ctx, stmt)
+	if err != nil {
+		return fmt.Errorf("failed to overwrite %s conflict: %w", conflict.Type, err)
+	}
+	if tag.RowsAffected() == 0 {
+		// The insert violated a unique constraint other than the replica identity.
+		return &ConflictError{Conflict: conflict}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package apply_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/apply"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableConflictResolver(t *testing.T) {
+	users := &pglogrepl.RelationMessage{Namespace: "public", RelationName: "users"}
+	orders := &pglogrepl.RelationMessage{Namespace: "public", RelationName: "orders"}
+	resolver := &apply.TableConflictResolver{
+		Tables: map[string]apply.ConflictResolver{"public.users": apply.ResolutionOverwrite},
+	}
+
+	for _, tt := range []struct {
+		conflict *apply.Conflict
+		expected apply.Resolution
+	}{
+		{&apply.Conflict{Type: apply.ConflictInsertExists, Relation: users}, apply.ResolutionOverwrite},
+		{&apply.Conflict{Type: apply.ConflictInsertExists, Relation: orders}, apply.ResolutionError},
+		{&apply.Conflict{Type: apply.ConflictUpdateMissing, Relation: orders}, apply.ResolutionSkip},
+		{&apply.Conflict{Type: apply.ConflictDeleteMissing, Relation: orders}, apply.ResolutionSkip},
+	} {
+		resolution, err := resolver.ResolveConflict(context.Background(), tt.conflict)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, resolution, "%s on %s", tt.conflict.Type, tt.conflict.Relation.RelationName)
+	}
+
+	resolver.Default = apply.ResolutionSkip
+	resolution, err := resolver.ResolveConflict(context.Background(), &apply.Conflict{Type: apply.ConflictInsertExists, Relation: orders})
+	require.NoError(t, err)
+	assert.Equal(t, apply.ResolutionSkip, resolution)
+}
+
+func TestConflictError(t *testing.T) {
+	uniqueErr := errors.New("duplicate key value violates unique constraint")
+	err := &apply.ConflictError{Conflict: &apply.Conflict{
+		Type:      apply.ConflictInsertExists,
+		Relation:  &pglogrepl.RelationMessage{Namespace: "public", RelationName: "users"},
+		Xid:       700,
+		CommitLSN: 0x180,
+		Err:       uniqueErr,
+	}}
+	assert.Equal(t, `insert_exists conflict on "public"."users" in transaction 700 committed at 0/180: duplicate key value violates unique constraint`, err.Error())
+	assert.ErrorIs(t, err, uniqueErr)
+}
+
+func TestApplierConflicts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn := connectTarget(t, ctx)
+	_, err := conn.Exec(ctx, "insert into pglogrepl_apply values (1, 'existing')")
+	require.NoError(t, err)
+
+	rel := &pglogrepl.RelationMessage{
+		RelationID:   16384,
+		Namespace:    "public",
+		RelationName: "pglogrepl_apply",
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Flags: 1, Name: "id", DataType: 23},
+			{Name: "name", DataType: 25},
+		},
+		ColumnNum: 2,
+	}
+	tuple := func(id, name string) *pglogrepl.TupleData {
+		return &pglogrepl.TupleData{ColumnNum: 2, Columns: []*pglogrepl.TupleDataColumn{
+			{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(id)), Data: []byte(id)},
+			{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(name)), Data: []byte(name)},
+		}}
+	}
+	write := func(applier *apply.Applier, commitLSN pglogrepl.LSN, changes ...pglogrepl.Message) error {
+		messages := []pglogrepl.Message{&pglogrepl.BeginMessage{FinalLSN: commitLSN, Xid: uint32(commitLSN)}, rel}
+		messages = append(messages, changes...)
+		messages = append(messages, &pglogrepl.CommitMessage{CommitLSN: commitLSN, TransactionEndLSN: commitLSN + 8})
+		for _, msg := range messages {
+			if err := applier.WriteChange(ctx, &pglogrepl.ReplicationMessage{Message: msg}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// By default the insert of an existing row fails and reports the transaction to skip.
+	applier, err := apply.New(ctx, conn, apply.Options{OriginName: originName})
+	require.NoError(t, err)
+	err = write(applier, 0x100, &pglogrepl.InsertMessage{RelationID: rel.RelationID, Tuple: tuple("1", "inserted")})
+	var conflictErr *apply.ConflictError
+	require.ErrorAs(t, err, &conflictErr)
+	assert.Equal(t, apply.ConflictInsertExists, conflictErr.Conflict.Type)
+	assert.Equal(t, pglogrepl.LSN(0x100), conflictErr.Conflict.CommitLSN)
+	require.NoError(t, applier.Close(ctx))
+
+	applier, err = apply.New(ctx, conn, apply.Options{
+		OriginName: originName,
+		SkipLSN:    0x100,
+		ConflictResolver: &apply.TableConflictResolver{
+			Tables: map[string]apply.ConflictResolver{"public.pglogrepl_apply": apply.ResolutionOverwrite},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, write(applier, 0x100, &pglogrepl.InsertMessage{RelationID: rel.RelationID, Tuple: tuple("1", "skipped")}))
+	require.NoError(t, write(applier, 0x200,
+		&pglogrepl.InsertMessage{RelationID: rel.RelationID, Tuple: tuple("1", "overwritten")},
+		&pglogrepl.UpdateMessage{RelationID: rel.RelationID, NewTuple: tuple("2", "missing")},
+		&pglogrepl.DeleteMessage{RelationID: rel.RelationID, OldTupleType: pglogrepl.DeleteMessageTupleTypeKey, OldTuple: tuple("3", "")},
+	))
+	lsn, err := applier.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x208), lsn)
+	require.NoError(t, applier.Close(ctx))
+
+	rows, err := conn.Query(ctx, "select name from pglogrepl_apply order by id")
+	require.NoError(t, err)
+	var names []string
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{"overwritten", "missing"}, names)
+}
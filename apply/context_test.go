@@ -0,0 +1,108 @@
+package apply_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/apply"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// insertTransaction returns the messages of a source transaction inserting a row into
+// pglogrepl_apply and ending at endLSN.
+func insertTransaction(endLSN pglogrepl.LSN) []pglogrepl.Message {
+	rel := &pglogrepl.RelationMessage{
+		RelationID:      16384,
+		Namespace:       "public",
+		RelationName:    "pglogrepl_apply",
+		ReplicaIdentity: pglogrepl.ReplicaIdentityDefault,
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Flags: 1, Name: "id", DataType: 23},
+			{Name: "name", DataType: 25},
+		},
+		ColumnNum: 2,
+	}
+	return []pglogrepl.Message{
+		&pglogrepl.BeginMessage{FinalLSN: endLSN - 0x80, Xid: 700},
+		rel,
+		&pglogrepl.InsertMessage{RelationID: rel.RelationID, Tuple: &pglogrepl.TupleData{
+			ColumnNum: 2,
+			Columns: []*pglogrepl.TupleDataColumn{
+				{DataType: pglogrepl.TupleDataTypeText, Length: 1, Data: []byte("1")},
+				{DataType: pglogrepl.TupleDataTypeText, Length: 3, Data: []byte("foo")},
+			},
+		}},
+		&pglogrepl.CommitMessage{CommitLSN: endLSN - 0x80, TransactionEndLSN: endLSN},
+	}
+}
+
+func TestCancelApplierFlush(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn := connectTarget(t, ctx)
+	// The connection of the Applier may not survive the failed commit.
+	check := connectTarget(t, ctx)
+	applier, err := apply.New(ctx, conn, apply.Options{OriginName: originName, MaxBatchTransactions: 2})
+	require.NoError(t, err)
+	for _, msg := range insertTransaction(0x200) {
+		require.NoError(t, applier.WriteChange(ctx, &pglogrepl.ReplicationMessage{Message: msg}))
+	}
+
+	done, cancelDone := context.WithCancel(ctx)
+	cancelDone()
+	lsn, err := applier.Flush(done)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, pglogrepl.LSN(0), lsn)
+
+	var count int
+	require.NoError(t, check.QueryRow(ctx, "select count(*) from pglogrepl_apply").Scan(&count))
+	assert.Equal(t, 0, count)
+}
+
+func TestCancelParallelApplierFlush(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn := connectTarget(t, ctx)
+	other, err := pgx.ConnectConfig(ctx, conn.Config())
+	require.NoError(t, err)
+	locker, err := pgx.ConnectConfig(ctx, conn.Config())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		locker.Close(ctx)
+		other.Exec(ctx, "select pg_replication_origin_drop(roname) from pg_replication_origin where starts_with(roname, $1)", originName+"_")
+		other.Close(ctx)
+	})
+
+	applier, err := apply.NewParallel(ctx, []*pgx.Conn{conn, other}, apply.ParallelOptions{Options: apply.Options{OriginName: originName}})
+	require.NoError(t, err)
+
+	// The worker blocks on the lock until it is released.
+	lock, err := locker.Begin(ctx)
+	require.NoError(t, err)
+	_, err = lock.Exec(ctx, "lock table pglogrepl_apply")
+	require.NoError(t, err)
+	for _, msg := range insertTransaction(0x200) {
+		require.NoError(t, applier.WriteChange(ctx, &pglogrepl.ReplicationMessage{Message: msg}))
+	}
+
+	flushCtx, cancelFlush := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelFlush()
+	lsn, err := applier.Flush(flushCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, pglogrepl.LSN(0), lsn)
+
+	// The transaction is still applied once the lock is released.
+	require.NoError(t, lock.Rollback(ctx))
+	lsn, err = applier.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x200), lsn)
+	require.NoError(t, applier.Close(ctx))
+}
@@ -0,0 +1,111 @@
+package apply
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5"
+)
+
+// The functions below wrap the pg_replication_origin functions the Applier tracks its progress
+// with. They take regular connections to the target database.
+
+// CreateOrigin creates the replication origin name. It fails if the origin exists.
+func CreateOrigin(ctx context.Context, conn *pgx.Conn, name string) error {
+	if _, err := conn.Exec(ctx, "select pg_replication_origin_create($1)", name); err != nil {
+		return fmt.Errorf("failed to create replication origin: %w", err)
+	}
+	return nil
+}
+
+// EnsureOrigin creates the replication origin name if it does not exist. It is safe to call
+// concurrently from several sessions: the lookup and the creation are made in a transaction
+// holding an advisory lock derived from name, so that only one of them creates the origin.
+func EnsureOrigin(ctx context.Context, conn *pgx.Conn, name string) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "select pg_advisory_xact_lock($1)", originLockKey(name)); err != nil {
+		return fmt.Errorf("failed to lock replication origin: %w", err)
+	}
+	var exists bool
+	err = tx.QueryRow(ctx, "select exists (select from pg_replication_origin where roname = $1)", name).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to look up replication origin: %w", err)
+	}
+	if !exists {
+		if _, err := tx.Exec(ctx, "select pg_replication_origin_create($1)", name); err != nil {
+			return fmt.Errorf("failed to create replication origin: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// originLockKey returns the advisory lock key of the replication origin name.
+func originLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("pglogrepl.origin:"))
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// DropOrigin drops the replication origin name if it exists. It fails if a session is set up
+// with the origin.
+func DropOrigin(ctx context.Context, conn *pgx.Conn, name string) error {
+	_, err := conn.Exec(ctx, "select pg_replication_origin_drop(roname) from pg_replication_origin where roname = $1", name)
+	if err != nil {
+		return fmt.Errorf("failed to drop replication origin: %w", err)
+	}
+	return nil
+}
+
+// SetupOriginSession binds the session of conn to the replication origin name, so that the
+// transactions it commits are marked as replayed from the origin and, once their position is set
+// with SetupOriginTransaction, advance its progress. An origin can be bound to one session at a
+// time.
+func SetupOriginSession(ctx context.Context, conn *pgx.Conn, name string) error {
+	if _, err := conn.Exec(ctx, "select pg_replication_origin_session_setup($1)", name); err != nil {
+		return fmt.Errorf("failed to set up replication origin session: %w", err)
+	}
+	return nil
+}
+
+// ResetOriginSession reverts the session of conn from its replication origin.
+func ResetOriginSession(ctx context.Context, conn *pgx.Conn) error {
+	if _, err := conn.Exec(ctx, "select pg_replication_origin_session_reset()"); err != nil {
+		return fmt.Errorf("failed to reset replication origin session: %w", err)
+	}
+	return nil
+}
+
+// SetupOriginTransaction records lsn and commitTime, the end position and the commit time of the
+// source transaction, as the progress of the replication origin of the session when tx commits.
+func SetupOriginTransaction(ctx context.Context, tx pgx.Tx, lsn pglogrepl.LSN, commitTime time.Time) error {
+	if _, err := tx.Exec(ctx, "select pg_replication_origin_xact_setup($1, $2)", lsn.String(), commitTime); err != nil {
+		return fmt.Errorf("failed to set up replication origin transaction: %w", err)
+	}
+	return nil
+}
+
+// OriginProgress returns the position of the replication origin name, 0 if nothing has been
+// recorded. If flush is set only the position of transactions flushed to disk is returned.
+func OriginProgress(ctx context.Context, conn *pgx.Conn, name string, flush bool) (pglogrepl.LSN, error) {
+	var lsn *string
+	err := conn.QueryRow(ctx, "select pg_replication_origin_progress($1, $2)::text", name, flush).Scan(&lsn)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read replication origin progress: %w", err)
+	}
+	if lsn == nil {
+		return 0, nil
+	}
+	return pglogrepl.ParseLSN(*lsn)
+}
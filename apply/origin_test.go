@@ -0,0 +1,52 @@
+package apply_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/apply"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrigin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn := connectTarget(t, ctx)
+	conn2 := connectTarget(t, ctx)
+
+	// Concurrent setups create the origin once.
+	errs := make(chan error, 2)
+	go func() { errs <- apply.EnsureOrigin(ctx, conn, originName) }()
+	go func() { errs <- apply.EnsureOrigin(ctx, conn2, originName) }()
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+	assert.Error(t, apply.CreateOrigin(ctx, conn, originName))
+
+	lsn, err := apply.OriginProgress(ctx, conn, originName, false)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0), lsn)
+
+	require.NoError(t, apply.SetupOriginSession(ctx, conn, originName))
+	// The origin is bound to the session of conn.
+	assert.Error(t, apply.SetupOriginSession(ctx, conn2, originName))
+
+	tx, err := conn.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, apply.SetupOriginTransaction(ctx, tx, pglogrepl.LSN(0x16B2470), time.Now()))
+	require.NoError(t, tx.Commit(ctx))
+
+	lsn, err = apply.OriginProgress(ctx, conn2, originName, false)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x16B2470), lsn)
+
+	assert.Error(t, apply.DropOrigin(ctx, conn2, originName))
+	require.NoError(t, apply.ResetOriginSession(ctx, conn))
+	require.NoError(t, apply.DropOrigin(ctx, conn2, originName))
+	require.NoError(t, apply.DropOrigin(ctx, conn2, originName))
+	_, err = apply.OriginProgress(ctx, conn, originName, false)
+	assert.Error(t, err)
+}
@@ -0,0 +1,306 @@
+package apply
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5"
+)
+
+// ParallelOptions configures a ParallelApplier.
+type ParallelOptions struct {
+	// Options configures the workers. OriginName is the prefix of the replication origins of the
+	// workers, which are named OriginName_0, OriginName_1 and so on. MaxBatchTransactions and
+	// MaxBatchChanges are ignored: every source transaction is committed separately.
+	Options
+
+	// Serialize reports whether a transaction changing rel waits until the earlier transactions
+	// changing it are committed before it is applied. If it is nil every relation is serialized,
+	// so that only transactions changing disjoint relations are applied concurrently. Relations
+	// related by foreign keys should be serialized together, or the constraints should be
+	// deferred, since a transaction does not see the uncommitted rows of another. Transactions
+	// changing the same rows of a relation that is not serialized can deadlock, as the earlier
+	// transaction waits for the row lock of the later one, which waits to commit after it.
+	Serialize func(rel *pglogrepl.RelationMessage) bool
+}
+
+// ParallelApplier applies replicated transactions on several worker connections concurrently
+// while committing them in the order they were committed on the source, like the parallel apply
+// workers of PostgreSQL 16. This speeds up the apply of large transactions, in particular
+// transactions streamed with protocol version 4 and streaming 'parallel', which are assembled by
+// the TransactionAssembler while they are in progress.
+//
+// Every worker tracks its progress with its own replication origin. Since a transaction is only
+// committed after the transactions committed before it, the greatest position of the workers'
+// origins is the position up to which the stream is applied.
+//
+// ParallelApplier implements pglogrepl.Sink. If a transaction fails to apply, the transactions
+// after it are rolled back and WriteChange and Flush return the error; replication must then be
+// restarted from StartLSN.
+type ParallelApplier struct {
+	options   ParallelOptions
+	workers   []*Applier
+	relations *pglogrepl.RelationCache
+	assembler *pglogrepl.TransactionAssembler
+	startLSN  pglogrepl.LSN
+
+	idle chan *Applier
+	// last is the last dispatched job and byRelation the last dispatched job changing each
+	// relation, the jobs a new job waits for.
+	last       *parallelJob
+	byRelation map[uint32]*parallelJob
+
+	mu         sync.Mutex
+	appliedLSN pglogrepl.LSN
+	err        error
+}
+
+// parallelJob is a source transaction applied by a worker.
+type parallelJob struct {
+	tx      *pglogrepl.Transaction
+	changes []preparedChange
+	// prev is the job of the previous transaction and deps the jobs of earlier transactions
+	// changing the same serialized relations.
+	prev *parallelJob
+	deps []*parallelJob
+
+	// done is closed once the transaction is committed or has failed, err is then set if it
+	// failed.
+	done chan struct{}
+	err  error
+}
+
+// NewParallel returns a ParallelApplier applying changes through conns, regular connections to
+// the target database. There is one worker per connection and each connection is used
+// exclusively by its worker until Close is called.
+func NewParallel(ctx context.Context, conns []*pgx.Conn, options ParallelOptions) (*ParallelApplier, error) {
+	if options.OriginName == "" {
+		return nil, fmt.Errorf("apply options have no origin name")
+	}
+	if len(conns) == 0 {
+		return nil, fmt.Errorf("parallel applier has no connections")
+	}
+
+	p := &ParallelApplier{
+		options:    options,
+		relations:  pglogrepl.NewRelationCache(nil),
+		assembler:  pglogrepl.NewTransactionAssembler(options.TransactionAssemblerOptions),
+		idle:       make(chan *Applier, len(conns)),
+		byRelation: map[uint32]*parallelJob{},
+	}
+	for i, conn := range conns {
+		workerOptions := options.Options
+		workerOptions.OriginName = options.OriginName + "_" + strconv.Itoa(i)
+		workerOptions.MaxBatchTransactions = 1
+		workerOptions.MaxBatchChanges = 0
+		w, err := New(ctx, conn, workerOptions)
+		if err != nil {
+			p.Close(ctx)
+			return nil, err
+		}
+		p.workers = append(p.workers, w)
+		p.idle <- w
+	}
+
+	var err error
+	if p.startLSN, err = p.StartLSN(ctx); err != nil {
+		p.Close(ctx)
+		return nil, err
+	}
+	p.appliedLSN = p.startLSN
+	return p, nil
+}
+
+// StartLSN returns the position to start replication from, the greatest position of the
+// replication origins of the workers, including origins of workers of a previous run with more
+// connections. It is 0 if nothing has been applied yet. Only the origins named OriginName_<n>
+// are considered, not those of an applier whose OriginName has this one as a prefix.
+func (p *ParallelApplier) StartLSN(ctx context.Context) (pglogrepl.LSN, error) {
+	var lsn *string
+	err := p.workers[0].conn.QueryRow(ctx, "select max(remote_lsn)::text from pg_replication_origin_status where starts_with(external_id, $1) and substr(external_id, length($1) + 1) ~ '^[0-9]+$'", p.options.OriginName+"_").Scan(&lsn)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read replication origin progress: %w", err)
+	}
+	if lsn == nil {
+		return 0, nil
+	}
+	return pglogrepl.ParseLSN(*lsn)
+}
+
+// Relations returns the relations received from the source.
+func (p *ParallelApplier) Relations() *pglogrepl.RelationCache {
+	return p.relations
+}
+
+// WriteChange implements pglogrepl.Sink. Once msg commits a transaction, WriteChange waits for an
+// idle worker and hands the transaction over to it. The worker applies the transaction with ctx,
+// which must therefore remain valid until the transaction is flushed.
+func (p *ParallelApplier) WriteChange(ctx context.Context, msg *pglogrepl.ReplicationMessage) error {
+	if msg.Message == nil {
+		return fmt.Errorf("stream does not decode pgoutput messages")
+	}
+	if err := p.failure(); err != nil {
+		return err
+	}
+	tx, err := p.assembler.Add(msg.Message)
+	if err != nil || tx == nil {
+		return err
+	}
+
+	skip := tx.EndLSN <= p.startLSN || (p.options.SkipLSN != 0 && tx.CommitLSN == p.options.SkipLSN)
+	changes, err := prepareTransaction(p.relations, tx, skip, p.options.SQLOptions)
+	if err != nil {
+		return err
+	}
+	if tx.EndLSN <= p.startLSN {
+		return nil
+	}
+
+	var w *Applier
+	select {
+	case w = <-p.idle:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	job := &parallelJob{tx: tx, changes: changes, prev: p.last, done: make(chan struct{})}
+	seen := map[*parallelJob]bool{}
+	for _, change := range changes {
+		for _, id := range changedRelationIDs(change.change) {
+			rel, ok := p.relations.Relation(id)
+			if !ok || (p.options.Serialize != nil && !p.options.Serialize(rel)) {
+				continue
+			}
+			if dep := p.byRelation[id]; dep != nil && dep != job && !seen[dep] {
+				seen[dep] = true
+				job.deps = append(job.deps, dep)
+			}
+			p.byRelation[id] = job
+		}
+	}
+	p.last = job
+
+	go p.run(ctx, w, job)
+	return nil
+}
+
+// changedRelationIDs returns the relations changed by change.
+func changedRelationIDs(change pglogrepl.Message) []uint32 {
+	if truncate, ok := change.(*pglogrepl.TruncateMessage); ok {
+		return truncate.RelationIDs
+	}
+	if id, ok := changeRelationID(change); ok {
+		return []uint32{id}
+	}
+	return nil
+}
+
+// run applies job with w and commits it after the previous job.
+func (p *ParallelApplier) run(ctx context.Context, w *Applier, job *parallelJob) {
+	skip := p.options.SkipLSN != 0 && job.tx.CommitLSN == p.options.SkipLSN
+	ctx, span := startTransactionSpan(ctx, job.tx, skip)
+	defer func() {
+		span.End(job.err)
+		// Only the outcome is kept for the jobs waiting for this one.
+		job.tx, job.changes, job.prev, job.deps = nil, nil, nil, nil
+		close(job.done)
+		p.idle <- w
+	}()
+
+	for _, dep := range job.deps {
+		<-dep.done
+		if dep.err != nil {
+			job.err = dep.err
+			return
+		}
+	}
+	err := w.applyPrepared(ctx, job.tx, job.changes)
+	if job.prev != nil {
+		<-job.prev.done
+		if job.prev.err != nil && err == nil {
+			err = job.prev.err
+		}
+	}
+	if err == nil {
+		err = w.commit(ctx)
+	}
+	if err != nil {
+		w.rollback(ctx)
+		job.err = err
+		p.fail(err)
+		return
+	}
+
+	p.mu.Lock()
+	p.appliedLSN = job.tx.EndLSN
+	p.mu.Unlock()
+}
+
+func (p *ParallelApplier) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *ParallelApplier) failure() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Flush implements pglogrepl.Sink. It waits until the transactions handed over to the workers are
+// committed and returns the end position of the last one.
+func (p *ParallelApplier) Flush(ctx context.Context) (pglogrepl.LSN, error) {
+	if p.last != nil {
+		select {
+		case <-p.last.done:
+		case <-ctx.Done():
+			return p.flushedLSN(), ctx.Err()
+		}
+	}
+	if err := p.failure(); err != nil {
+		return p.flushedLSN(), err
+	}
+	return p.flushedLSN(), nil
+}
+
+func (p *ParallelApplier) flushedLSN() pglogrepl.LSN {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.appliedLSN
+}
+
+// Close waits for the workers to finish, then closes them and removes the spill files of streamed
+// transactions in progress. It does not close the connections. If ctx is done before the workers
+// finish it returns ctx.Err() without closing them; the transactions they are applying fail once
+// the context passed to WriteChange is done.
+func (p *ParallelApplier) Close(ctx context.Context) error {
+	var idle []*Applier
+	for len(idle) < len(p.workers) {
+		select {
+		case w := <-p.idle:
+			idle = append(idle, w)
+		case <-ctx.Done():
+			// The idle workers are put back so that a later Close waits for the others.
+			for _, w := range idle {
+				p.idle <- w
+			}
+			return ctx.Err()
+		}
+	}
+	p.assembler.Close()
+	var err error
+	for _, w := range p.workers {
+		if closeErr := w.Close(ctx); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+var _ pglogrepl.Sink = (*ParallelApplier)(nil)
@@ -0,0 +1,110 @@
+package apply_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/apply"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelApplier(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn := connectTarget(t, ctx)
+	other, err := pgx.ConnectConfig(ctx, conn.Config())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		other.Exec(ctx, "select pg_replication_origin_drop(roname) from pg_replication_origin where starts_with(roname, $1)", originName+"_")
+		other.Close(ctx)
+	})
+
+	applier, err := apply.NewParallel(ctx, []*pgx.Conn{conn, other}, apply.ParallelOptions{Options: apply.Options{OriginName: originName}})
+	require.NoError(t, err)
+
+	rel := &pglogrepl.RelationMessage{
+		RelationID:      16384,
+		Namespace:       "public",
+		RelationName:    "pglogrepl_apply",
+		ReplicaIdentity: pglogrepl.ReplicaIdentityDefault,
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Flags: 1, Name: "id", DataType: 23},
+			{Name: "name", DataType: 25},
+		},
+		ColumnNum: 2,
+	}
+	tuple := func(id, name string) *pglogrepl.TupleData {
+		return &pglogrepl.TupleData{
+			ColumnNum: 2,
+			Columns: []*pglogrepl.TupleDataColumn{
+				{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(id)), Data: []byte(id)},
+				{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(name)), Data: []byte(name)},
+			},
+		}
+	}
+	// The second transaction changes the row inserted by the first, so it must be applied after it.
+	messages := []pglogrepl.Message{
+		&pglogrepl.BeginMessage{FinalLSN: 0x180, Xid: 700},
+		rel,
+		&pglogrepl.InsertMessage{RelationID: rel.RelationID, Tuple: tuple("1", "foo")},
+		&pglogrepl.CommitMessage{CommitLSN: 0x180, TransactionEndLSN: 0x200},
+		&pglogrepl.BeginMessage{FinalLSN: 0x280, Xid: 701},
+		&pglogrepl.UpdateMessage{RelationID: rel.RelationID, NewTuple: tuple("1", "bar")},
+		&pglogrepl.CommitMessage{CommitLSN: 0x280, TransactionEndLSN: 0x300},
+	}
+	for _, msg := range messages {
+		require.NoError(t, applier.WriteChange(ctx, &pglogrepl.ReplicationMessage{Message: msg}))
+	}
+	lsn, err := applier.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x300), lsn)
+	lsn, err = applier.StartLSN(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x300), lsn)
+	require.NoError(t, applier.Close(ctx))
+
+	var name string
+	require.NoError(t, conn.QueryRow(ctx, "select name from pglogrepl_apply where id = 1").Scan(&name))
+	assert.Equal(t, "bar", name)
+}
+
+func TestParallelApplierOriginPrefix(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn := connectTarget(t, ctx)
+	other, err := pgx.ConnectConfig(ctx, conn.Config())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		other.Exec(ctx, "select pg_replication_origin_drop(roname) from pg_replication_origin where starts_with(roname, $1)", originName+"_")
+		other.Close(ctx)
+	})
+
+	// The origin pglogrepl_test_a_0 of the first applier starts with the prefix of the origins
+	// of the second one.
+	prefixed, err := apply.NewParallel(ctx, []*pgx.Conn{other}, apply.ParallelOptions{Options: apply.Options{OriginName: originName + "_a"}})
+	require.NoError(t, err)
+	for _, msg := range insertTransaction(0x200) {
+		require.NoError(t, prefixed.WriteChange(ctx, &pglogrepl.ReplicationMessage{Message: msg}))
+	}
+	lsn, err := prefixed.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x200), lsn)
+	require.NoError(t, prefixed.Close(ctx))
+
+	applier, err := apply.NewParallel(ctx, []*pgx.Conn{conn}, apply.ParallelOptions{Options: apply.Options{OriginName: originName}})
+	require.NoError(t, err)
+	lsn, err = applier.StartLSN(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0), lsn)
+	require.NoError(t, applier.Close(ctx))
+}
@@ -0,0 +1,73 @@
+package apply
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pglogrepl/sqlgen"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// statementCache prepares the statements applying changes on a connection and keeps the most
+// recently used ones. The statements are keyed by their SQL, which only depends on the relation
+// of a change and on the columns it sets and compares, so the changes of a relation share a few
+// statements that are parsed once. Generating the SQL of every change is cheap next to parsing
+// and planning it on the server.
+type statementCache struct {
+	conn     *pgconn.PgConn
+	capacity int
+
+	// lru holds the cached statements, most recently used first, and bySQL their elements.
+	lru   *list.List
+	bySQL map[string]*list.Element
+	seq   int
+}
+
+type cachedStatement struct {
+	sql  string
+	name string
+}
+
+func newStatementCache(conn *pgconn.PgConn, capacity int) *statementCache {
+	return &statementCache{conn: conn, capacity: capacity, lru: list.New(), bySQL: map[string]*list.Element{}}
+}
+
+// exec executes stmt as a prepared statement, preparing it on first use. The least recently used
+// statement is deallocated when the cache is full.
+func (c *statementCache) exec(ctx context.Context, stmt *sqlgen.Statement) (pgconn.CommandTag, error) {
+	var name string
+	if e, ok := c.bySQL[stmt.SQL]; ok {
+		c.lru.MoveToFront(e)
+		name = e.Value.(*cachedStatement).name
+	} else {
+		if c.lru.Len() >= c.capacity {
+			oldest := c.lru.Back().Value.(*cachedStatement)
+			if err := c.conn.Deallocate(ctx, oldest.name); err != nil {
+				return pgconn.CommandTag{}, fmt.Errorf("failed to deallocate statement: %w", err)
+			}
+			c.lru.Remove(c.lru.Back())
+			delete(c.bySQL, oldest.sql)
+		}
+		c.seq++
+		name = "pglogrepl_apply_" + strconv.Itoa(c.seq)
+		if _, err := c.conn.Prepare(ctx, name, stmt.SQL, nil); err != nil {
+			return pgconn.CommandTag{}, err
+		}
+		c.bySQL[stmt.SQL] = c.lru.PushFront(&cachedStatement{sql: stmt.SQL, name: name})
+	}
+	return c.conn.ExecPrepared(ctx, name, stmt.Params, stmt.ParamFormats, nil).Close()
+}
+
+// close deallocates the cached statements.
+func (c *statementCache) close(ctx context.Context) error {
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		if err := c.conn.Deallocate(ctx, e.Value.(*cachedStatement).name); err != nil {
+			return fmt.Errorf("failed to deallocate statement: %w", err)
+		}
+	}
+	c.lru.Init()
+	c.bySQL = map[string]*list.Element{}
+	return nil
+}
@@ -0,0 +1,384 @@
+// Package avro encodes the row changes of a logical replication stream in Apache Avro, with a
+// schema derived from the relation messages of the stream, so that they can be loaded into data
+// lakes and consumed with the Avro tooling of Kafka.
+//
+// Every relation gets a record schema, see Schema, whose before and after fields hold the row with
+// one nullable field per column. Column types map to Avro types by OID: booleans, integers and
+// floating point numbers to the matching primitive types, bytea to bytes, date to the date
+// logical type, timestamps to the timestamp-micros logical types and uuid to the uuid logical
+// type. All other types, numeric and json included, are encoded as strings holding their text
+// representation, so that no precision is lost.
+//
+// Events are encoded in the Avro binary encoding, without the schema. If the Encoder has a
+// SchemaRegistry, such as a ConfluentRegistry, every schema is registered on first use and the
+// events are framed in the Confluent wire format: a zero magic byte and the 4 byte schema ID
+// followed by the Avro data.
+package avro
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Type OIDs of the types with a specific Avro mapping.
+const (
+	boolOID        = 16
+	byteaOID       = 17
+	int8OID        = 20
+	int2OID        = 21
+	int4OID        = 23
+	oidOID         = 26
+	float4OID      = 700
+	float8OID      = 701
+	dateOID        = 1082
+	timestampOID   = 1114
+	timestamptzOID = 1184
+	uuidOID        = 2950
+)
+
+// EncoderOptions configures an Encoder.
+type EncoderOptions struct {
+	// Namespace is the namespace of the schemas, to which the schema of the relation is appended.
+	// It defaults to "pglogrepl".
+	Namespace string
+	// Registry, if set, registers the schemas and makes the events use the Confluent wire format.
+	Registry SchemaRegistry
+	// Subject returns the registry subject of the schema of a relation. If it is nil the subject
+	// is "<schema>.<table>-value", the Kafka topic name strategy for a topic per table.
+	Subject func(schema, table string) string
+	// TypeMap decodes the column values. If it is nil pgtype.NewMap() is used.
+	TypeMap *pgtype.Map
+}
+
+// Event is an encoded row change.
+type Event struct {
+	Schema string
+	Table  string
+	// SchemaID is the ID the registry assigned to the schema of the event, or 0 without registry.
+	SchemaID int
+	// Value is the Avro encoding of the change, framed in the Confluent wire format if the
+	// encoder has a registry.
+	Value []byte
+}
+
+// Encoder converts the messages of a replication stream into Avro encoded events. The messages
+// are decoded into change events by a pglogrepl.ChangeEventDecoder, which tracks the relations
+// and the transaction in progress, so every message of the stream must be passed to Encode in
+// order. An Encoder is not safe for concurrent use.
+type Encoder struct {
+	options EncoderOptions
+	decoder *pglogrepl.ChangeEventDecoder
+	// schemaIDs are the registered schema IDs of the relations, by relation ID. They are cleared
+	// when the relation changes.
+	schemaIDs map[uint32]int
+}
+
+// NewEncoder returns a new Encoder.
+func NewEncoder(options EncoderOptions) *Encoder {
+	if options.Namespace == "" {
+		options.Namespace = "pglogrepl"
+	}
+	if options.Subject == nil {
+		options.Subject = func(schema, table string) string {
+			return schema + "." + table + "-value"
+		}
+	}
+	return &Encoder{
+		options:   options,
+		decoder:   pglogrepl.NewChangeEventDecoder(options.TypeMap),
+		schemaIDs: map[uint32]int{},
+	}
+}
+
+// Schema returns the JSON schema of the relation schema.table, which must have been received.
+func (e *Encoder) Schema(schema, table string) (string, bool) {
+	rel, ok := e.decoder.Relations().RelationByName(schema, table)
+	if !ok {
+		return "", false
+	}
+	return Schema(rel, e.options.Namespace), true
+}
+
+// Encode returns the events for msg, which was received at lsn. It returns no events for
+// messages that do not change rows. A truncate message returns an event for every truncated
+// relation.
+func (e *Encoder) Encode(ctx context.Context, lsn pglogrepl.LSN, msg pglogrepl.Message) ([]*Event, error) {
+	// The decoder caches the relation as well; a changed definition needs a new schema.
+	if change := e.decoder.Relations().Update(msg); change != nil {
+		delete(e.schemaIDs, change.New.RelationID)
+	}
+	changes, err := e.decoder.Decode(lsn, msg)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*Event, 0, len(changes))
+	for _, change := range changes {
+		event, err := e.event(ctx, change)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (e *Encoder) event(ctx context.Context, change *pglogrepl.ChangeEvent) (*Event, error) {
+	rel, ok := e.decoder.Relations().RelationByName(change.Schema, change.Table)
+	if !ok {
+		return nil, fmt.Errorf("unknown relation %s.%s", change.Schema, change.Table)
+	}
+	event := &Event{Schema: rel.Namespace, Table: rel.RelationName}
+
+	var buf []byte
+	if e.options.Registry != nil {
+		id, ok := e.schemaIDs[rel.RelationID]
+		if !ok {
+			var err error
+			subject := e.options.Subject(rel.Namespace, rel.RelationName)
+			if id, err = e.options.Registry.Register(ctx, subject, Schema(rel, e.options.Namespace)); err != nil {
+				return nil, fmt.Errorf("failed to register schema of %s.%s: %w", rel.Namespace, rel.RelationName, err)
+			}
+			e.schemaIDs[rel.RelationID] = id
+		}
+		event.SchemaID = id
+		buf = append(buf, 0)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(id))
+	}
+
+	buf = appendString(buf, string(change.Operation))
+	var err error
+	for _, row := range []map[string]interface{}{change.Before, change.After} {
+		if row == nil {
+			buf = appendLong(buf, 0)
+			continue
+		}
+		buf = appendLong(buf, 1)
+		if buf, err = appendRow(buf, rel, row); err != nil {
+			return nil, fmt.Errorf("failed to encode row of %s.%s: %w", rel.Namespace, rel.RelationName, err)
+		}
+	}
+	buf = appendLong(buf, int64(change.LSN))
+	buf = appendLong(buf, int64(change.Xid))
+	if change.CommitTime.IsZero() {
+		buf = appendLong(buf, 0)
+	} else {
+		buf = appendLong(buf, 1)
+		buf = appendLong(buf, change.CommitTime.UnixMicro())
+	}
+	event.Value = buf
+	return event, nil
+}
+
+// Schema returns the JSON Avro schema of the events of rel: a record named after the relation,
+// in namespace followed by the schema of the relation, with the fields
+//
+//   - op, the operation, a string among "insert", "update", "delete" and "truncate",
+//   - before, the old row of an update or delete as sent by the server, or null,
+//   - after, the new row of an insert or update, or null,
+//   - lsn, the position of the change,
+//   - xid, the ID of the transaction, and
+//   - commit_time, the commit time of the transaction, null for the changes of a streamed
+//     transaction.
+//
+// Every column of the row is nullable. NULL columns, the columns of an old row outside the replica
+// identity and unchanged TOAST columns, whose value is not sent, are null. Names are made valid
+// Avro names by replacing the invalid characters with underscores.
+func Schema(rel *pglogrepl.RelationMessage, namespace string) string {
+	fields := make([]interface{}, len(rel.Columns))
+	for i, col := range rel.Columns {
+		fields[i] = map[string]interface{}{
+			"name":    avroName(col.Name),
+			"type":    []interface{}{"null", columnType(col.DataType)},
+			"default": nil,
+		}
+	}
+	row := map[string]interface{}{"type": "record", "name": "Row", "fields": fields}
+	schema := map[string]interface{}{
+		"type":      "record",
+		"name":      avroName(rel.RelationName),
+		"namespace": namespace + "." + avroName(rel.Namespace),
+		"fields": []interface{}{
+			map[string]interface{}{"name": "op", "type": "string"},
+			map[string]interface{}{"name": "before", "type": []interface{}{"null", row}, "default": nil},
+			map[string]interface{}{"name": "after", "type": []interface{}{"null", "Row"}, "default": nil},
+			map[string]interface{}{"name": "lsn", "type": "long"},
+			map[string]interface{}{"name": "xid", "type": "long"},
+			map[string]interface{}{
+				"name":    "commit_time",
+				"type":    []interface{}{"null", map[string]interface{}{"type": "long", "logicalType": "timestamp-micros"}},
+				"default": nil,
+			},
+		},
+	}
+	// Marshaling maps sorts the keys, which keeps the schema stable.
+	data, _ := json.Marshal(schema)
+	return string(data)
+}
+
+// columnType returns the Avro type of the columns of type oid.
+func columnType(oid uint32) interface{} {
+	switch oid {
+	case boolOID:
+		return "boolean"
+	case int2OID, int4OID:
+		return "int"
+	case int8OID, oidOID:
+		return "long"
+	case float4OID:
+		return "float"
+	case float8OID:
+		return "double"
+	case byteaOID:
+		return "bytes"
+	case dateOID:
+		return map[string]interface{}{"type": "int", "logicalType": "date"}
+	case timestampOID:
+		return map[string]interface{}{"type": "long", "logicalType": "local-timestamp-micros"}
+	case timestamptzOID:
+		return map[string]interface{}{"type": "long", "logicalType": "timestamp-micros"}
+	case uuidOID:
+		return map[string]interface{}{"type": "string", "logicalType": "uuid"}
+	}
+	return "string"
+}
+
+// avroName replaces the characters of name that are not valid in an Avro name with underscores.
+func avroName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r == '_', r >= '0' && r <= '9' && i > 0:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// appendRow appends the Avro encoding of row, a decoded row of rel. The columns missing from row,
+// such as unchanged TOAST columns, are null.
+func appendRow(buf []byte, rel *pglogrepl.RelationMessage, row map[string]interface{}) ([]byte, error) {
+	for _, col := range rel.Columns {
+		v := row[col.Name]
+		if v == nil {
+			buf = appendLong(buf, 0)
+			continue
+		}
+		buf = appendLong(buf, 1)
+		var err error
+		if buf, err = appendValue(buf, col.DataType, v); err != nil {
+			return nil, fmt.Errorf("column %s: %w", col.Name, err)
+		}
+	}
+	return buf, nil
+}
+
+// appendValue appends the Avro encoding of v, a value of type oid decoded by a pgtype.Map.
+func appendValue(buf []byte, oid uint32, v interface{}) ([]byte, error) {
+	switch oid {
+	case boolOID:
+		if b, ok := v.(bool); ok {
+			if b {
+				return append(buf, 1), nil
+			}
+			return append(buf, 0), nil
+		}
+	case int2OID, int4OID, int8OID, oidOID:
+		switch n := v.(type) {
+		case int16:
+			return appendLong(buf, int64(n)), nil
+		case int32:
+			return appendLong(buf, int64(n)), nil
+		case int64:
+			return appendLong(buf, n), nil
+		case uint32:
+			return appendLong(buf, int64(n)), nil
+		}
+	case float4OID:
+		if f, ok := v.(float32); ok {
+			return binary.LittleEndian.AppendUint32(buf, math.Float32bits(f)), nil
+		}
+	case float8OID:
+		if f, ok := v.(float64); ok {
+			return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f)), nil
+		}
+	case byteaOID:
+		if data, ok := v.([]byte); ok {
+			return appendBytes(buf, data), nil
+		}
+	case dateOID:
+		if t, ok := v.(time.Time); ok {
+			days := t.Unix() / (24 * 60 * 60)
+			if t.Unix()%(24*60*60) < 0 {
+				days--
+			}
+			return appendLong(buf, days), nil
+		}
+	case timestampOID, timestamptzOID:
+		if t, ok := v.(time.Time); ok {
+			return appendLong(buf, t.UnixMicro()), nil
+		}
+	case uuidOID:
+		if u, ok := v.([16]byte); ok {
+			return appendString(buf, fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])), nil
+		}
+	default:
+		text, err := textValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return appendString(buf, text), nil
+	}
+	return nil, fmt.Errorf("unexpected %T value for type %d", v, oid)
+}
+
+// textValue returns the text representation of v, a value decoded by a pgtype.Map of a type
+// encoded as a string.
+func textValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case driver.Valuer:
+		value, err := v.Value()
+		if err != nil {
+			return "", err
+		}
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return textValue(value)
+	case fmt.Stringer:
+		return v.String(), nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// appendLong appends the zig-zag variable length encoding of n, used for Avro ints and longs.
+func appendLong(buf []byte, n int64) []byte {
+	return binary.AppendUvarint(buf, uint64(n<<1)^uint64(n>>63))
+}
+
+func appendBytes(buf []byte, data []byte) []byte {
+	return append(appendLong(buf, int64(len(data))), data...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(appendLong(buf, int64(len(s))), s...)
+}
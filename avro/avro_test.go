@@ -0,0 +1,168 @@
+package avro_test
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/avro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func text(s string) *pglogrepl.TupleDataColumn {
+	return &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(s)), Data: []byte(s)}
+}
+
+func tuple(cols ...*pglogrepl.TupleDataColumn) *pglogrepl.TupleData {
+	return &pglogrepl.TupleData{ColumnNum: uint16(len(cols)), Columns: cols}
+}
+
+var testRelation = &pglogrepl.RelationMessage{
+	RelationID:   1,
+	Namespace:    "public",
+	RelationName: "my-table",
+	Columns: []*pglogrepl.RelationMessageColumn{
+		{Flags: 1, Name: "id", DataType: 20},
+		{Name: "ok", DataType: 16},
+		{Name: "ratio", DataType: 701},
+		{Name: "data", DataType: 17},
+		{Name: "day", DataType: 1082},
+		{Name: "at", DataType: 1184},
+		{Name: "amount", DataType: 1700},
+	},
+}
+
+// reader decodes the Avro binary encoding.
+type reader struct {
+	t   *testing.T
+	buf []byte
+}
+
+func (r *reader) long() int64 {
+	u, n := binary.Uvarint(r.buf)
+	require.Positive(r.t, n)
+	r.buf = r.buf[n:]
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func (r *reader) bytes() []byte {
+	n := r.long()
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) double() float64 {
+	f := math.Float64frombits(binary.LittleEndian.Uint64(r.buf))
+	r.buf = r.buf[8:]
+	return f
+}
+
+func TestSchema(t *testing.T) {
+	rel := &pglogrepl.RelationMessage{
+		Namespace:    "public",
+		RelationName: "t",
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Name: "id", DataType: 23},
+			{Name: "2nd col", DataType: 1184},
+		},
+	}
+	assert.JSONEq(t, `{
+		"type": "record",
+		"name": "t",
+		"namespace": "cdc.public",
+		"fields": [
+			{"name": "op", "type": "string"},
+			{"name": "before", "type": ["null", {"type": "record", "name": "Row", "fields": [
+				{"name": "id", "type": ["null", "int"], "default": null},
+				{"name": "_nd_col", "type": ["null", {"type": "long", "logicalType": "timestamp-micros"}], "default": null}
+			]}], "default": null},
+			{"name": "after", "type": ["null", "Row"], "default": null},
+			{"name": "lsn", "type": "long"},
+			{"name": "xid", "type": "long"},
+			{"name": "commit_time", "type": ["null", {"type": "long", "logicalType": "timestamp-micros"}], "default": null}
+		]
+	}`, avro.Schema(rel, "cdc"))
+}
+
+func TestEncoder(t *testing.T) {
+	ctx := context.Background()
+	e := avro.NewEncoder(avro.EncoderOptions{})
+	commitTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, msg := range []pglogrepl.Message{testRelation, &pglogrepl.BeginMessage{Xid: 42, CommitTime: commitTime}} {
+		events, err := e.Encode(ctx, 0x100, msg)
+		require.NoError(t, err)
+		assert.Empty(t, events)
+	}
+	schema, ok := e.Schema("public", "my-table")
+	require.True(t, ok)
+	assert.Equal(t, avro.Schema(testRelation, "pglogrepl"), schema)
+
+	events, err := e.Encode(ctx, 0x200, &pglogrepl.InsertMessage{RelationID: 1, Tuple: tuple(
+		text("-3"), text("t"), text("0.5"), text(`\x0102`), text("2024-01-02"),
+		text("2024-01-02 03:04:05.5+02"), text("12345678901234567890.5"),
+	)})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "public", events[0].Schema)
+	assert.Equal(t, "my-table", events[0].Table)
+	assert.Zero(t, events[0].SchemaID)
+
+	r := &reader{t: t, buf: events[0].Value}
+	assert.Equal(t, "insert", string(r.bytes()))
+	assert.EqualValues(t, 0, r.long(), "before is null")
+	assert.EqualValues(t, 1, r.long(), "after is set")
+	assert.EqualValues(t, 1, r.long())
+	assert.EqualValues(t, -3, r.long())
+	assert.EqualValues(t, 1, r.long())
+	assert.Equal(t, byte(1), r.buf[0])
+	r.buf = r.buf[1:]
+	assert.EqualValues(t, 1, r.long())
+	assert.Equal(t, 0.5, r.double())
+	assert.EqualValues(t, 1, r.long())
+	assert.Equal(t, []byte{1, 2}, r.bytes())
+	assert.EqualValues(t, 1, r.long())
+	assert.EqualValues(t, 19724, r.long())
+	assert.EqualValues(t, 1, r.long())
+	assert.Equal(t, time.Date(2024, 1, 2, 1, 4, 5, 500000000, time.UTC).UnixMicro(), r.long())
+	assert.EqualValues(t, 1, r.long())
+	assert.Equal(t, "12345678901234567890.5", string(r.bytes()))
+	assert.EqualValues(t, 0x200, r.long())
+	assert.EqualValues(t, 42, r.long())
+	assert.EqualValues(t, 1, r.long())
+	assert.Equal(t, commitTime.UnixMicro(), r.long())
+	assert.Empty(t, r.buf)
+
+	null := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeNull}
+	events, err = e.Encode(ctx, 0x300, &pglogrepl.DeleteMessageV2{DeleteMessage: pglogrepl.DeleteMessage{
+		RelationID:   1,
+		OldTupleType: pglogrepl.DeleteMessageTupleTypeKey,
+		OldTuple:     tuple(text("7"), null, null, null, null, null, null),
+	}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	r = &reader{t: t, buf: events[0].Value}
+	assert.Equal(t, "delete", string(r.bytes()))
+	assert.EqualValues(t, 1, r.long())
+	assert.EqualValues(t, 1, r.long())
+	assert.EqualValues(t, 7, r.long())
+	for i := 0; i < 6; i++ {
+		assert.EqualValues(t, 0, r.long())
+	}
+	assert.EqualValues(t, 0, r.long(), "after is null")
+
+	events, err = e.Encode(ctx, 0x400, &pglogrepl.TruncateMessage{RelationNum: 1, RelationIDs: []uint32{1}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	r = &reader{t: t, buf: events[0].Value}
+	assert.Equal(t, "truncate", string(r.bytes()))
+
+	_, err = e.Encode(ctx, 0x500, &pglogrepl.InsertMessage{RelationID: 1, Tuple: tuple(text("x"), null, null, null, null, null, null)})
+	assert.Error(t, err)
+	_, err = e.Encode(ctx, 0x500, &pglogrepl.InsertMessage{RelationID: 2, Tuple: tuple()})
+	assert.Error(t, err)
+}
@@ -0,0 +1,84 @@
+package avro
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// SchemaRegistry registers the schemas of the events of an Encoder.
+type SchemaRegistry interface {
+	// Register registers schema under subject and returns its ID. Registering a schema that is
+	// already registered returns its existing ID.
+	Register(ctx context.Context, subject, schema string) (int, error)
+}
+
+// ConfluentRegistry is a SchemaRegistry using the REST API of the Confluent Schema Registry. It
+// caches the IDs of the schemas it registered and is safe for concurrent use.
+type ConfluentRegistry struct {
+	url    string
+	client *http.Client
+
+	mu  sync.Mutex
+	ids map[string]int
+}
+
+// NewConfluentRegistry returns a ConfluentRegistry for the registry at baseURL, such as
+// "http://localhost:8081", sending its requests with client. If client is nil
+// http.DefaultClient is used.
+func NewConfluentRegistry(baseURL string, client *http.Client) *ConfluentRegistry {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &ConfluentRegistry{url: strings.TrimSuffix(baseURL, "/"), client: client, ids: map[string]int{}}
+}
+
+// Register implements SchemaRegistry.
+func (r *ConfluentRegistry) Register(ctx context.Context, subject, schema string) (int, error) {
+	key := subject + "\x00" + schema
+	r.mu.Lock()
+	id, ok := r.ids[key]
+	r.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url+"/subjects/"+url.PathEscape(subject)+"/versions", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("schema registry returned %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+
+	var result struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return 0, fmt.Errorf("failed to parse schema registry response: %w", err)
+	}
+	r.mu.Lock()
+	r.ids[key] = result.ID
+	r.mu.Unlock()
+	return result.ID, nil
+}
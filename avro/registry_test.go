@@ -0,0 +1,56 @@
+package avro_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/avro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfluentRegistry(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.EscapedPath())
+		var body struct {
+			Schema string `json:"schema"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Schema == "" {
+			http.Error(w, `{"error_code":42201,"message":"Invalid schema"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		w.Write([]byte(`{"id":7}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	e := avro.NewEncoder(avro.EncoderOptions{Registry: avro.NewConfluentRegistry(server.URL+"/", nil)})
+	_, err := e.Encode(ctx, 0x100, testRelation)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		events, err := e.Encode(ctx, 0x200, &pglogrepl.TruncateMessage{RelationNum: 1, RelationIDs: []uint32{1}})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, 7, events[0].SchemaID)
+		assert.Equal(t, byte(0), events[0].Value[0])
+		assert.Equal(t, uint32(7), binary.BigEndian.Uint32(events[0].Value[1:]))
+	}
+	// The schema is registered once, and again if the relation changes.
+	assert.Equal(t, []string{"/subjects/public.my-table-value/versions"}, requests)
+	changed := *testRelation
+	changed.Columns = changed.Columns[:1]
+	_, err = e.Encode(ctx, 0x300, &changed)
+	require.NoError(t, err)
+	_, err = e.Encode(ctx, 0x400, &pglogrepl.TruncateMessage{RelationNum: 1, RelationIDs: []uint32{1}})
+	require.NoError(t, err)
+	assert.Len(t, requests, 2)
+
+	_, err = avro.NewConfluentRegistry(server.URL, server.Client()).Register(ctx, "s", "")
+	assert.ErrorContains(t, err, "Invalid schema")
+}
@@ -0,0 +1,272 @@
+package pglogrepl
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// BaseBackupArchive describes an archive of a base backup. Its content is the tar data of a
+// tablespace or, for the backup manifest, a JSON document.
+type BaseBackupArchive struct {
+	// Name is the file name pg_basebackup would use for the archive, e.g. "base.tar",
+	// "16385.tar" or "backup_manifest".
+	Name string
+	// Tablespace is the tablespace contained in the archive. It is nil for the main data directory
+	// and the backup manifest.
+	Tablespace *BaseBackupTablespace
+	// Manifest reports whether this is the backup manifest.
+	Manifest bool
+}
+
+// BaseBackupStream reads the archives sent in response to the BASE_BACKUP command. It supports
+// both the format used up to PostgreSQL 14, where every archive is a separate COPY, and the
+// format used since PostgreSQL 15, where all archives are sent in a single COPY.
+//
+// The archives are read in order with NextArchive and ReadChunk. Finish must be called once the
+// archives have been read to receive the end position of the backup.
+type BaseBackupStream struct {
+	conn          *pgconn.PgConn
+	serverVersion int
+	start         BaseBackupResult
+
+	// Up to PostgreSQL 14 the archives are not announced, so they are named after the tablespaces
+	// in the order the server sends them.
+	archives []BaseBackupArchive
+	next     int
+
+	inArchive bool
+	inCopy    bool
+	copyDone  bool
+	pending   *BaseBackupArchive
+	progress  int64
+}
+
+// StartBaseBackupStream executes the BASE_BACKUP command and returns a BaseBackupStream to read
+// the backup from.
+func StartBaseBackupStream(ctx context.Context, conn *pgconn.PgConn, options BaseBackupOptions) (*BaseBackupStream, error) {
+	serverVersion, err := serverMajorVersion(conn)
+	if err != nil {
+		return nil, err
+	}
+	start, err := StartBaseBackup(ctx, conn, options)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &BaseBackupStream{conn: conn, serverVersion: serverVersion, start: start}
+	if serverVersion < 15 {
+		for i := range start.Tablespaces {
+			tbs := &start.Tablespaces[i]
+			s.archives = append(s.archives, BaseBackupArchive{Name: fmt.Sprintf("%d.tar", tbs.OID), Tablespace: tbs})
+		}
+		s.archives = append(s.archives, BaseBackupArchive{Name: "base.tar"})
+		if options.Manifest != "" && serverVersion >= 13 {
+			s.archives = append(s.archives, BaseBackupArchive{Name: "backup_manifest", Manifest: true})
+		}
+	}
+	return s, nil
+}
+
+// Start returns the start position, timeline and tablespaces of the backup.
+func (s *BaseBackupStream) Start() BaseBackupResult {
+	return s.start
+}
+
+// Progress returns the number of bytes of archive data received so far. Since PostgreSQL 15 it is
+// the progress reported by the server. Together with the tablespace sizes reported when the
+// Progress option is requested it can be used to estimate the completion of the backup.
+func (s *BaseBackupStream) Progress() int64 {
+	return s.progress
+}
+
+// NextArchive skips the rest of the current archive and returns the next one. It returns io.EOF
+// when all archives have been read.
+func (s *BaseBackupStream) NextArchive(ctx context.Context) (*BaseBackupArchive, error) {
+	for s.inArchive {
+		if _, err := s.ReadChunk(ctx); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+
+	if s.serverVersion < 15 {
+		if s.next == len(s.archives) {
+			return nil, io.EOF
+		}
+		if err := s.receiveCopyOutResponse(ctx); err != nil {
+			return nil, err
+		}
+		archive := &s.archives[s.next]
+		s.next++
+		s.inArchive = true
+		return archive, nil
+	}
+
+	if s.pending == nil {
+		if s.copyDone {
+			return nil, io.EOF
+		}
+		if !s.inCopy {
+			if err := s.receiveCopyOutResponse(ctx); err != nil {
+				return nil, err
+			}
+			s.inCopy = true
+		}
+		// The archive header is the first message of the COPY.
+		s.inArchive = true
+		if _, err := s.ReadChunk(ctx); err != io.EOF {
+			if err == nil {
+				err = fmt.Errorf("received archive data before archive header")
+			}
+			return nil, err
+		}
+		if s.pending == nil {
+			return nil, io.EOF
+		}
+	}
+
+	archive := s.pending
+	s.pending = nil
+	s.inArchive = true
+	return archive, nil
+}
+
+// ReadChunk returns the next chunk of data of the current archive. It returns io.EOF at the end of
+// the archive. The returned slice is only valid until the next call to a BaseBackupStream method.
+func (s *BaseBackupStream) ReadChunk(ctx context.Context) ([]byte, error) {
+	if !s.inArchive {
+		return nil, io.EOF
+	}
+
+	for {
+		msg, err := s.conn.ReceiveMessage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive message: %w", err)
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			if s.serverVersion < 15 {
+				s.progress += int64(len(msg.Data))
+				return msg.Data, nil
+			}
+			data, err := s.handleCopyData(msg.Data)
+			if err != nil {
+				return nil, err
+			}
+			if s.pending != nil {
+				s.inArchive = false
+				return nil, io.EOF
+			}
+			if data != nil {
+				return data, nil
+			}
+		case *pgproto3.CopyDone:
+			s.inArchive = false
+			s.copyDone = true
+			return nil, io.EOF
+		case *pgproto3.ErrorResponse:
+			return nil, pgconn.ErrorResponseToPgError(msg)
+		case *pgproto3.NoticeResponse:
+		default:
+			return nil, fmt.Errorf("unexpected response type: %T", msg)
+		}
+	}
+}
+
+// handleCopyData handles a CopyData message in the PostgreSQL 15 format. It returns the contained
+// archive data or nil if it was a progress report or the start of the next archive.
+func (s *BaseBackupStream) handleCopyData(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("received empty CopyData message")
+	}
+	switch data[0] {
+	case 'n':
+		fields := bytes.SplitN(data[1:], []byte{0}, 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid new archive message")
+		}
+		archive := &BaseBackupArchive{Name: string(fields[0])}
+		if location := string(fields[1]); location != "" {
+			for i := range s.start.Tablespaces {
+				if s.start.Tablespaces[i].Location == location {
+					archive.Tablespace = &s.start.Tablespaces[i]
+					break
+				}
+			}
+		}
+		s.pending = archive
+		return nil, nil
+	case 'm':
+		s.pending = &BaseBackupArchive{Name: "backup_manifest", Manifest: true}
+		return nil, nil
+	case 'd':
+		return data[1:], nil
+	case 'p':
+		if len(data) != 9 {
+			return nil, fmt.Errorf("invalid progress message length %d", len(data))
+		}
+		s.progress = int64(binary.BigEndian.Uint64(data[1:]))
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected CopyData message type: %c", data[0])
+	}
+}
+
+func (s *BaseBackupStream) receiveCopyOutResponse(ctx context.Context) error {
+	for {
+		msg, err := s.conn.ReceiveMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to receive message: %w", err)
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.CopyOutResponse:
+			return nil
+		case *pgproto3.ErrorResponse:
+			return pgconn.ErrorResponseToPgError(msg)
+		case *pgproto3.NoticeResponse:
+		default:
+			return fmt.Errorf("unexpected response type: %T", msg)
+		}
+	}
+}
+
+// WriteArchiveTo writes the rest of the current archive to w.
+func (s *BaseBackupStream) WriteArchiveTo(ctx context.Context, w io.Writer) (int64, error) {
+	var n int64
+	for {
+		chunk, err := s.ReadChunk(ctx)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		written, err := w.Write(chunk)
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Finish skips any archives that have not been read and returns the end position and timeline of
+// the backup.
+func (s *BaseBackupStream) Finish(ctx context.Context) (BaseBackupResult, error) {
+	for {
+		_, err := s.NextArchive(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return BaseBackupResult{}, err
+		}
+	}
+	return FinishBaseBackup(ctx, s.conn)
+}
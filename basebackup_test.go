@@ -0,0 +1,174 @@
+package pglogrepl_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func baseBackupHeader(lsn, tli string) []pgproto3.BackendMessage {
+	return []pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("recptr")}, {Name: []byte("tli")}}},
+		&pgproto3.DataRow{Values: [][]byte{[]byte(lsn), []byte(tli)}},
+		&pgproto3.CommandComplete{CommandTag: []byte("SELECT")},
+	}
+}
+
+func baseBackupTablespaces() []pgproto3.BackendMessage {
+	return []pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("spcoid")}, {Name: []byte("spclocation")}, {Name: []byte("size")}}},
+		&pgproto3.DataRow{Values: [][]byte{[]byte("16385"), []byte("/tbs"), []byte("300")}},
+		&pgproto3.DataRow{Values: [][]byte{nil, nil, []byte("40000")}},
+		&pgproto3.CommandComplete{CommandTag: []byte("SELECT")},
+	}
+}
+
+func baseBackupTrailer() []pgproto3.BackendMessage {
+	msgs := baseBackupHeader("0/3000100", "1")
+	return append(msgs,
+		&pgproto3.CommandComplete{CommandTag: []byte("BASE_BACKUP")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	)
+}
+
+func readArchives(t *testing.T, stream *pglogrepl.BaseBackupStream) map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	archives := map[string]string{}
+	for {
+		archive, err := stream.NextArchive(ctx)
+		if err == io.EOF {
+			return archives
+		}
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		_, err = stream.WriteArchiveTo(ctx, &buf)
+		require.NoError(t, err)
+		archives[archive.Name] = buf.String()
+		if archive.Name == "16385.tar" {
+			require.NotNil(t, archive.Tablespace)
+			assert.Equal(t, "/tbs", archive.Tablespace.Location)
+		} else {
+			assert.Nil(t, archive.Tablespace)
+		}
+		assert.Equal(t, archive.Name == "backup_manifest", archive.Manifest)
+	}
+}
+
+func TestBaseBackupStream(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	var msgs []pgproto3.BackendMessage
+	msgs = append(msgs, baseBackupHeader("0/3000028", "1")...)
+	msgs = append(msgs, baseBackupTablespaces()...)
+	progress := make([]byte, 9)
+	progress[0] = 'p'
+	binary.BigEndian.PutUint64(progress[1:], 11)
+	msgs = append(msgs,
+		&pgproto3.CopyOutResponse{},
+		&pgproto3.CopyData{Data: []byte("n16385.tar\x00/tbs\x00")},
+		&pgproto3.CopyData{Data: []byte("dtbs ")},
+		&pgproto3.CopyData{Data: []byte("ddata")},
+		&pgproto3.CopyData{Data: progress},
+		&pgproto3.CopyData{Data: []byte("nbase.tar\x00\x00")},
+		&pgproto3.CopyData{Data: []byte("dbase data")},
+		&pgproto3.CopyData{Data: []byte("m")},
+		&pgproto3.CopyData{Data: []byte("d{}")},
+		&pgproto3.CopyDone{},
+	)
+	msgs = append(msgs, baseBackupTrailer()...)
+	queries := ws.serveQuery(msgs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := pglogrepl.StartBaseBackupStream(ctx, conn, pglogrepl.BaseBackupOptions{Progress: true, Manifest: "yes", ManifestChecksums: "SHA256"})
+	require.NoError(t, err)
+	assert.Equal(t, "BASE_BACKUP(PROGRESS, MANIFEST 'yes', MANIFEST_CHECKSUMS 'SHA256')", <-queries)
+	assert.Equal(t, pglogrepl.LSN(0x3000028), stream.Start().LSN)
+	assert.Equal(t, []pglogrepl.BaseBackupTablespace{{OID: 16385, Location: "/tbs", Size: 300}}, stream.Start().Tablespaces)
+
+	assert.Equal(t, map[string]string{
+		"16385.tar":       "tbs data",
+		"base.tar":        "base data",
+		"backup_manifest": "{}",
+	}, readArchives(t, stream))
+	assert.Equal(t, int64(11), stream.Progress())
+
+	end, err := stream.Finish(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x3000100), end.LSN)
+	assert.Equal(t, int32(1), end.TimelineID)
+}
+
+func TestBaseBackupStreamPG14(t *testing.T) {
+	conn, ws := newFakeWalSenderVersion(t, "14.10")
+
+	var msgs []pgproto3.BackendMessage
+	msgs = append(msgs, baseBackupHeader("0/3000028", "1")...)
+	msgs = append(msgs, baseBackupTablespaces()...)
+	msgs = append(msgs,
+		&pgproto3.CopyOutResponse{},
+		&pgproto3.CopyData{Data: []byte("tbs data")},
+		&pgproto3.CopyDone{},
+		&pgproto3.CopyOutResponse{},
+		&pgproto3.CopyData{Data: []byte("base ")},
+		&pgproto3.CopyData{Data: []byte("data")},
+		&pgproto3.CopyDone{},
+		&pgproto3.CopyOutResponse{},
+		&pgproto3.CopyData{Data: []byte("{}")},
+		&pgproto3.CopyDone{},
+	)
+	msgs = append(msgs, baseBackupTrailer()...)
+	queries := ws.serveQuery(msgs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := pglogrepl.StartBaseBackupStream(ctx, conn, pglogrepl.BaseBackupOptions{Label: "test", Manifest: "yes"})
+	require.NoError(t, err)
+	assert.Equal(t, "BASE_BACKUP LABEL 'test' MANIFEST 'yes'", <-queries)
+
+	assert.Equal(t, map[string]string{
+		"16385.tar":       "tbs data",
+		"base.tar":        "base data",
+		"backup_manifest": "{}",
+	}, readArchives(t, stream))
+	assert.Equal(t, int64(len("tbs database data{}")), stream.Progress())
+
+	end, err := stream.Finish(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x3000100), end.LSN)
+}
+
+func TestBaseBackupStreamFinishSkipsArchives(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	var msgs []pgproto3.BackendMessage
+	msgs = append(msgs, baseBackupHeader("0/3000028", "1")...)
+	msgs = append(msgs, baseBackupTablespaces()...)
+	msgs = append(msgs,
+		&pgproto3.CopyOutResponse{},
+		&pgproto3.CopyData{Data: []byte("nbase.tar\x00\x00")},
+		&pgproto3.CopyData{Data: []byte("dbase data")},
+		&pgproto3.CopyDone{},
+	)
+	msgs = append(msgs, baseBackupTrailer()...)
+	ws.serveQuery(msgs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := pglogrepl.StartBaseBackupStream(ctx, conn, pglogrepl.BaseBackupOptions{})
+	require.NoError(t, err)
+
+	end, err := stream.Finish(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x3000100), end.LSN)
+}
@@ -0,0 +1,85 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+)
+
+// BatchParser parses the WAL data of logical replication messages in batches. Like a Decoder it
+// tracks whether it is inside a streamed transaction, so it must be given every message of the
+// stream in order, but the messages it returns are allocated and copied as by Parse and remain
+// valid after the WAL data is reused.
+//
+// A BatchParser is not safe for concurrent use.
+type BatchParser struct {
+	decoder Decoder
+}
+
+// NewBatchParser returns a BatchParser for the pgoutput protocol version protoVersion.
+func NewBatchParser(protoVersion int) (*BatchParser, error) {
+	if protoVersion < 1 || protoVersion > 4 {
+		return nil, fmt.Errorf("unsupported pgoutput protocol version %d", protoVersion)
+	}
+	return &BatchParser{decoder: Decoder{protoVersion: protoVersion, alloc: true, aliasMin: aliasNone}}, nil
+}
+
+// ParseBatch parses every element of walData and appends the messages to msgs[:0], so that a
+// slice preallocated by the caller is reused. On error it returns the messages parsed before the
+// failing element, whose index is the length of the returned slice.
+func (p *BatchParser) ParseBatch(walData [][]byte, msgs []Message) ([]Message, error) {
+	msgs = msgs[:0]
+	for _, data := range walData {
+		p.decoder.Reset(data)
+		msg, err := p.decoder.Decode()
+		if err != nil {
+			return msgs, fmt.Errorf("failed to parse message %d of batch: %w", len(msgs), err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// ParsedBatch is a batch parsed by BatchParser.Pipeline.
+type ParsedBatch struct {
+	WALData  [][]byte
+	Messages []Message
+	// Err is the error that ended the pipeline. Messages holds the messages parsed before it.
+	Err error
+}
+
+// Pipeline parses the batches received from in on a separate goroutine and sends them to the
+// returned channel, which is closed once in is closed, the context is done or a batch fails to
+// parse. The channel buffers up to depth parsed batches; when the consumer falls behind, parsing
+// blocks and stops receiving from in, which applies backpressure to the producer of the WAL data.
+//
+// The BatchParser must not be used otherwise while the pipeline runs.
+func (p *BatchParser) Pipeline(ctx context.Context, in <-chan [][]byte, depth int) <-chan ParsedBatch {
+	out := make(chan ParsedBatch, depth)
+	go func() {
+		defer close(out)
+		for {
+			var walData [][]byte
+			var ok bool
+			select {
+			case walData, ok = <-in:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+
+			batch := ParsedBatch{WALData: walData}
+			batch.Messages, batch.Err = p.ParseBatch(walData, make([]Message, 0, len(walData)))
+			select {
+			case out <- batch:
+			case <-ctx.Done():
+				return
+			}
+			if batch.Err != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,91 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func insertMessageData(t *testing.T, value string) []byte {
+	insert := &pglogrepl.InsertMessage{RelationID: 1, Tuple: &pglogrepl.TupleData{ColumnNum: 1, Columns: []*pglogrepl.TupleDataColumn{
+		{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(value)), Data: []byte(value)},
+	}}}
+	data, err := insert.Encode(nil)
+	require.NoError(t, err)
+	return data
+}
+
+func TestBatchParserParseBatch(t *testing.T) {
+	p, err := pglogrepl.NewBatchParser(2)
+	require.NoError(t, err)
+
+	walData := [][]byte{
+		beginMessageData(0x300, 42),
+		insertMessageData(t, "foo"),
+		streamStartMessageData(7),
+		streamLogicalMessageData(7, "prefix", "content"),
+		{'E'},
+		insertMessageData(t, "bar"),
+	}
+	msgs := make([]pglogrepl.Message, 0, len(walData))
+	msgs, err = p.ParseBatch(walData, msgs)
+	require.NoError(t, err)
+	require.Len(t, msgs, len(walData))
+	assert.Equal(t, uint32(42), msgs[0].(*pglogrepl.BeginMessage).Xid)
+	assert.Equal(t, "foo", string(msgs[1].(*pglogrepl.InsertMessageV2).Tuple.Columns[0].Data))
+	assert.Equal(t, uint32(7), msgs[3].(*pglogrepl.LogicalDecodingMessageV2).Xid)
+	assert.IsType(t, &pglogrepl.StreamStopMessageV2{}, msgs[4])
+
+	// The messages do not refer to the WAL data.
+	walData[5][len(walData[5])-1] = 'x'
+	assert.Equal(t, "bar", string(msgs[5].(*pglogrepl.InsertMessageV2).Tuple.Columns[0].Data))
+
+	msgs, err = p.ParseBatch([][]byte{beginMessageData(0x400, 43), {'I', 0}}, msgs)
+	require.Error(t, err)
+	assert.Len(t, msgs, 1)
+}
+
+func TestBatchParserPipeline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p, err := pglogrepl.NewBatchParser(1)
+	require.NoError(t, err)
+	in := make(chan [][]byte)
+	out := p.Pipeline(ctx, in, 1)
+
+	batches := [][][]byte{{insertMessageData(t, "a")}, {insertMessageData(t, "b")}, {insertMessageData(t, "c")}}
+	go func() {
+		defer close(in)
+		for _, walData := range batches {
+			in <- walData
+		}
+	}()
+	var values []string
+	for batch := range out {
+		require.NoError(t, batch.Err)
+		values = append(values, string(batch.Messages[0].(*pglogrepl.InsertMessage).Tuple.Columns[0].Data))
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, values)
+}
+
+func TestBatchParserPipelineError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p, err := pglogrepl.NewBatchParser(1)
+	require.NoError(t, err)
+	in := make(chan [][]byte, 2)
+	in <- [][]byte{{'I', 0}}
+	in <- [][]byte{insertMessageData(t, "a")}
+	out := p.Pipeline(ctx, in, 2)
+
+	batch := <-out
+	require.Error(t, batch.Err)
+	_, ok := <-out
+	assert.False(t, ok)
+}
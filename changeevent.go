@@ -0,0 +1,219 @@
+package pglogrepl
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Operation is the operation of a ChangeEvent.
+type Operation string
+
+// List of operations.
+const (
+	OperationInsert   Operation = "insert"
+	OperationUpdate   Operation = "update"
+	OperationDelete   Operation = "delete"
+	OperationTruncate Operation = "truncate"
+)
+
+// ChangeEvent is a row change in a model independent of the output plugin, so that sinks and
+// filters can be written once for pgoutput, wal2json and test_decoding. ChangeEventDecoder
+// produces them from pgoutput messages, and the wal2json and testdecoding packages from the
+// output of their plugins.
+//
+// The column values are those of the plugin: values decoded with a type map for pgoutput, JSON
+// values for wal2json and text for test_decoding. NULL columns are nil and unchanged TOAST
+// columns, whose value the plugins do not send, are left out of the maps.
+type ChangeEvent struct {
+	Operation Operation
+	Schema    string
+	Table     string
+	// Key holds the replica identity columns of the row, or is nil if the output of the plugin
+	// does not identify them, such as for truncates.
+	Key map[string]interface{}
+	// Before is the old row of an update or delete as sent by the plugin: only the replica
+	// identity columns unless the table has REPLICA IDENTITY FULL. It is nil if the plugin did not
+	// send it, such as for an update that did not change the replica identity.
+	Before map[string]interface{}
+	// After is the new row of an insert or update.
+	After map[string]interface{}
+
+	// LSN is the position of the change, or of its transaction if the plugin does not report
+	// the position of changes.
+	LSN LSN
+	// CommitTime is the commit time of the transaction, zero if it is not known yet, such as for
+	// the changes of a streamed transaction, or if the plugin does not report it.
+	CommitTime time.Time
+	// Xid is the ID of the transaction, 0 if the plugin does not report it.
+	Xid uint32
+}
+
+// ChangeEventDecoder converts pgoutput messages into change events. Every message of the stream
+// must be passed to Decode in order, as the decoder tracks the relations and the transaction in
+// progress. It is not safe for concurrent use.
+type ChangeEventDecoder struct {
+	typeMap   *pgtype.Map
+	relations *RelationCache
+
+	// xid and commitTime are those of the transaction in progress.
+	xid        uint32
+	commitTime time.Time
+	inStream   bool
+}
+
+// NewChangeEventDecoder returns a ChangeEventDecoder decoding the column values with m. If m is
+// nil pgtype.NewMap() is used.
+func NewChangeEventDecoder(m *pgtype.Map) *ChangeEventDecoder {
+	if m == nil {
+		m = pgtype.NewMap()
+	}
+	return &ChangeEventDecoder{typeMap: m, relations: NewRelationCache(nil)}
+}
+
+// Relations returns the relation cache of the decoder. Loading the column attributes of the
+// relations into it with LoadColumnAttributes makes the decoder leave out generated columns and
+// detect stale relation definitions, see RelationCache.DecodeTuple.
+func (d *ChangeEventDecoder) Relations() *RelationCache {
+	return d.relations
+}
+
+// Decode returns the change events for msg, which was received at lsn. It returns no events for
+// messages that do not change rows. A truncate message returns an event for every truncated
+// relation.
+func (d *ChangeEventDecoder) Decode(lsn LSN, msg Message) ([]*ChangeEvent, error) {
+	xid := d.xid
+	switch m := msg.(type) {
+	case *InsertMessageV2:
+		xid, msg = d.streamXid(m.Xid), &m.InsertMessage
+	case *UpdateMessageV2:
+		xid, msg = d.streamXid(m.Xid), &m.UpdateMessage
+	case *DeleteMessageV2:
+		xid, msg = d.streamXid(m.Xid), &m.DeleteMessage
+	case *TruncateMessageV2:
+		xid, msg = d.streamXid(m.Xid), &m.TruncateMessage
+	}
+
+	switch msg := msg.(type) {
+	case *RelationMessage, *RelationMessageV2:
+		d.relations.Update(msg)
+	case *BeginMessage:
+		d.xid = msg.Xid
+		d.commitTime = msg.CommitTime
+	case *StreamStartMessageV2:
+		d.inStream = true
+	case *StreamStopMessageV2:
+		d.inStream = false
+
+	case *InsertMessage:
+		rel, err := d.relation(msg.RelationID)
+		if err != nil {
+			return nil, err
+		}
+		after, err := d.relations.decodeTuple(rel, msg.Tuple, d.typeMap)
+		if err != nil {
+			return nil, err
+		}
+		return []*ChangeEvent{d.event(rel, OperationInsert, lsn, xid, identityOf(rel, after), nil, after)}, nil
+	case *UpdateMessage:
+		rel, err := d.relation(msg.RelationID)
+		if err != nil {
+			return nil, err
+		}
+		after, err := d.relations.decodeTuple(rel, msg.NewTuple, d.typeMap)
+		if err != nil {
+			return nil, err
+		}
+		before, err := d.oldRow(rel, msg.OldTupleType, msg.OldTuple)
+		if err != nil {
+			return nil, err
+		}
+		return []*ChangeEvent{d.event(rel, OperationUpdate, lsn, xid, identityOf(rel, after), before, after)}, nil
+	case *DeleteMessage:
+		rel, err := d.relation(msg.RelationID)
+		if err != nil {
+			return nil, err
+		}
+		before, err := d.oldRow(rel, msg.OldTupleType, msg.OldTuple)
+		if err != nil {
+			return nil, err
+		}
+		return []*ChangeEvent{d.event(rel, OperationDelete, lsn, xid, identityOf(rel, before), before, nil)}, nil
+	case *TruncateMessage:
+		events := make([]*ChangeEvent, 0, len(msg.RelationIDs))
+		for _, relationID := range msg.RelationIDs {
+			rel, err := d.relation(relationID)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, d.event(rel, OperationTruncate, lsn, xid, nil, nil, nil))
+		}
+		return events, nil
+	}
+	return nil, nil
+}
+
+func (d *ChangeEventDecoder) streamXid(xid uint32) uint32 {
+	if d.inStream {
+		return xid
+	}
+	return d.xid
+}
+
+func (d *ChangeEventDecoder) relation(relationID uint32) (*RelationMessage, error) {
+	rel, ok := d.relations.Relation(relationID)
+	if !ok {
+		return nil, fmt.Errorf("unknown relation ID %d", relationID)
+	}
+	return rel, nil
+}
+
+// oldRow decodes the old tuple of an update or delete. A key tuple carries NULL for the columns
+// outside the replica identity, which are left out.
+func (d *ChangeEventDecoder) oldRow(rel *RelationMessage, tupleType uint8, tuple *TupleData) (map[string]interface{}, error) {
+	if tuple == nil {
+		return nil, nil
+	}
+	row, err := d.relations.decodeTuple(rel, tuple, d.typeMap)
+	if err != nil {
+		return nil, err
+	}
+	if tupleType == UpdateMessageTupleTypeKey {
+		return identityOf(rel, row), nil
+	}
+	return row, nil
+}
+
+func (d *ChangeEventDecoder) event(rel *RelationMessage, op Operation, lsn LSN, xid uint32, key, before, after map[string]interface{}) *ChangeEvent {
+	event := &ChangeEvent{
+		Operation: op,
+		Schema:    rel.Namespace,
+		Table:     rel.RelationName,
+		Key:       key,
+		Before:    before,
+		After:     after,
+		LSN:       lsn,
+		Xid:       xid,
+	}
+	// The commit time of a streamed transaction is not known until it is committed.
+	if !d.inStream {
+		event.CommitTime = d.commitTime
+	}
+	return event
+}
+
+// identityOf returns the replica identity columns of row, or nil if rel has none.
+func identityOf(rel *RelationMessage, row map[string]interface{}) map[string]interface{} {
+	var key map[string]interface{}
+	for _, col := range rel.Columns {
+		if col.Flags&1 == 0 {
+			continue
+		}
+		if key == nil {
+			key = map[string]interface{}{}
+		}
+		key[col.Name] = row[col.Name]
+	}
+	return key
+}
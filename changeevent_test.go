@@ -0,0 +1,94 @@
+package pglogrepl_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeEventDecoder(t *testing.T) {
+	d := pglogrepl.NewChangeEventDecoder(nil)
+	commitTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, msg := range []pglogrepl.Message{
+		&pglogrepl.RelationMessage{
+			RelationID:   1,
+			Namespace:    "public",
+			RelationName: "t",
+			Columns: []*pglogrepl.RelationMessageColumn{
+				{Flags: 1, Name: "id", DataType: 23},
+				{Name: "name", DataType: 25},
+			},
+		},
+		&pglogrepl.BeginMessage{FinalLSN: 0x400, Xid: 42, CommitTime: commitTime},
+	} {
+		events, err := d.Decode(0x100, msg)
+		require.NoError(t, err)
+		assert.Empty(t, events)
+	}
+
+	null := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeNull}
+	events, err := d.Decode(0x200, &pglogrepl.InsertMessage{RelationID: 1, Tuple: &pglogrepl.TupleData{
+		Columns: []*pglogrepl.TupleDataColumn{textColumn("1"), textColumn("a")},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, []*pglogrepl.ChangeEvent{{
+		Operation:  pglogrepl.OperationInsert,
+		Schema:     "public",
+		Table:      "t",
+		Key:        map[string]interface{}{"id": int32(1)},
+		After:      map[string]interface{}{"id": int32(1), "name": "a"},
+		LSN:        0x200,
+		CommitTime: commitTime,
+		Xid:        42,
+	}}, events)
+
+	events, err = d.Decode(0x300, &pglogrepl.UpdateMessage{
+		RelationID:   1,
+		OldTupleType: pglogrepl.UpdateMessageTupleTypeKey,
+		OldTuple:     &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{textColumn("1"), null}},
+		NewTuple: &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{
+			textColumn("2"), {DataType: pglogrepl.TupleDataTypeToast},
+		}},
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, pglogrepl.OperationUpdate, events[0].Operation)
+	assert.Equal(t, map[string]interface{}{"id": int32(1)}, events[0].Before)
+	assert.Equal(t, map[string]interface{}{"id": int32(2)}, events[0].After)
+	assert.Equal(t, map[string]interface{}{"id": int32(2)}, events[0].Key)
+
+	events, err = d.Decode(0x380, &pglogrepl.DeleteMessage{
+		RelationID:   1,
+		OldTupleType: pglogrepl.DeleteMessageTupleTypeOld,
+		OldTuple:     &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{textColumn("2"), null}},
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, pglogrepl.OperationDelete, events[0].Operation)
+	assert.Equal(t, map[string]interface{}{"id": int32(2), "name": nil}, events[0].Before)
+	assert.Nil(t, events[0].After)
+
+	// The changes of a streamed transaction have its xid and no commit time.
+	for _, msg := range []pglogrepl.Message{&pglogrepl.CommitMessage{}, &pglogrepl.StreamStartMessageV2{Xid: 43}} {
+		_, err := d.Decode(0x400, msg)
+		require.NoError(t, err)
+	}
+	events, err = d.Decode(0x500, &pglogrepl.TruncateMessageV2{
+		TruncateMessage:          pglogrepl.TruncateMessage{RelationNum: 1, RelationIDs: []uint32{1}},
+		InStreamMessageV2WithXid: pglogrepl.InStreamMessageV2WithXid{Xid: 43},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []*pglogrepl.ChangeEvent{{
+		Operation: pglogrepl.OperationTruncate,
+		Schema:    "public",
+		Table:     "t",
+		LSN:       0x500,
+		Xid:       43,
+	}}, events)
+
+	_, err = d.Decode(0x600, &pglogrepl.InsertMessage{RelationID: 2})
+	assert.Error(t, err)
+}
@@ -0,0 +1,163 @@
+package pglogrepl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// CheckpointStore persists the position up to which a replication slot has been processed so
+// that a restarted consumer can resume from it.
+type CheckpointStore interface {
+	// Load returns the stored position for slotName. It returns 0 if no position has been stored.
+	Load(ctx context.Context, slotName string) (LSN, error)
+	// Store stores lsn as the position of slotName.
+	Store(ctx context.Context, slotName string, lsn LSN) error
+}
+
+// FileCheckpointStore is a CheckpointStore keeping the positions of all slots in a JSON file. The
+// file is replaced atomically on every Store.
+type FileCheckpointStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileCheckpointStore returns a FileCheckpointStore using the file at path. The file is created
+// by the first Store.
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{path: path}
+}
+
+// Load implements CheckpointStore.
+func (s *FileCheckpointStore) Load(_ context.Context, slotName string) (LSN, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoints, err := s.read()
+	if err != nil {
+		return 0, err
+	}
+	return checkpoints[slotName], nil
+}
+
+// Store implements CheckpointStore.
+func (s *FileCheckpointStore) Store(_ context.Context, slotName string, lsn LSN) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoints, err := s.read()
+	if err != nil {
+		return err
+	}
+	checkpoints[slotName] = lsn
+	data, err := json.Marshal(checkpoints)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to store checkpoint: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to store checkpoint: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to store checkpoint: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to store checkpoint: %w", err)
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to store checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (s *FileCheckpointStore) read() (map[string]LSN, error) {
+	checkpoints := map[string]LSN{}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoints, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoints: %w", err)
+	}
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoints: %w", err)
+	}
+	return checkpoints, nil
+}
+
+// PgCheckpointStore is a CheckpointStore keeping the positions in a PostgreSQL table with the
+// columns slot_name and lsn. The table is created if it does not exist.
+//
+// The connection must be a regular connection, not a replication connection, as it uses the
+// extended query protocol.
+type PgCheckpointStore struct {
+	conn  *pgconn.PgConn
+	table string
+
+	mu      sync.Mutex
+	created bool
+}
+
+// NewPgCheckpointStore returns a PgCheckpointStore using table, which may be schema qualified.
+func NewPgCheckpointStore(conn *pgconn.PgConn, table string) *PgCheckpointStore {
+	return &PgCheckpointStore{conn: conn, table: quoteQualifiedIdentifier(table)}
+}
+
+// Load implements CheckpointStore.
+func (s *PgCheckpointStore) Load(ctx context.Context, slotName string) (LSN, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.createTable(ctx); err != nil {
+		return 0, err
+	}
+	result := s.conn.ExecParams(ctx, "select lsn from "+s.table+" where slot_name = $1", [][]byte{[]byte(slotName)}, nil, nil, nil).Read()
+	if result.Err != nil {
+		return 0, fmt.Errorf("failed to load checkpoint: %w", result.Err)
+	}
+	if len(result.Rows) == 0 {
+		return 0, nil
+	}
+	return ParseLSN(string(result.Rows[0][0]))
+}
+
+// Store implements CheckpointStore.
+func (s *PgCheckpointStore) Store(ctx context.Context, slotName string, lsn LSN) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.createTable(ctx); err != nil {
+		return err
+	}
+	sql := "insert into " + s.table + " (slot_name, lsn) values ($1, $2) on conflict (slot_name) do update set lsn = excluded.lsn"
+	result := s.conn.ExecParams(ctx, sql, [][]byte{[]byte(slotName), []byte(lsn.String())}, nil, nil, nil).Read()
+	if result.Err != nil {
+		return fmt.Errorf("failed to store checkpoint: %w", result.Err)
+	}
+	return nil
+}
+
+func (s *PgCheckpointStore) createTable(ctx context.Context) error {
+	if s.created {
+		return nil
+	}
+	_, err := s.conn.Exec(ctx, "create table if not exists "+s.table+" (slot_name text primary key, lsn pg_lsn not null)").ReadAll()
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint table: %w", err)
+	}
+	s.created = true
+	return nil
+}
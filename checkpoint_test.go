@@ -0,0 +1,77 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCheckpointStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	store := pglogrepl.NewFileCheckpointStore(path)
+	lsn, err := store.Load(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0), lsn)
+
+	require.NoError(t, store.Store(ctx, "a", 0x100))
+	require.NoError(t, store.Store(ctx, "b", 0x200))
+	require.NoError(t, store.Store(ctx, "a", 0x300))
+
+	// A new store reads what the previous one wrote.
+	store = pglogrepl.NewFileCheckpointStore(path)
+	lsn, err = store.Load(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x300), lsn)
+	lsn, err = store.Load(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x200), lsn)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files are cleaned up")
+}
+
+func TestFileCheckpointStoreInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+
+	_, err := pglogrepl.NewFileCheckpointStore(path).Load(context.Background(), "a")
+	assert.Error(t, err)
+}
+
+func TestPgCheckpointStore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	delete(config.RuntimeParams, "replication")
+
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	_, err = conn.Exec(ctx, "drop table if exists pglogrepl_checkpoints").ReadAll()
+	require.NoError(t, err)
+	defer conn.Exec(context.Background(), "drop table if exists pglogrepl_checkpoints").ReadAll()
+
+	store := pglogrepl.NewPgCheckpointStore(conn, "pglogrepl_checkpoints")
+	lsn, err := store.Load(ctx, slotName)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0), lsn)
+
+	require.NoError(t, store.Store(ctx, slotName, 0x100))
+	require.NoError(t, store.Store(ctx, slotName, 0x300))
+	lsn, err = store.Load(ctx, slotName)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x300), lsn)
+}
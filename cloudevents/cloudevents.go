@@ -0,0 +1,142 @@
+// Package cloudevents wraps change events in CloudEvents 1.0 envelopes, so that they can be
+// delivered to Knative, EventBridge and other CloudEvents consumers without custom glue.
+//
+// Every pglogrepl.ChangeEvent becomes a CloudEvent whose type is the operation, whose source is
+// the table and whose ID is the LSN of the change. The data is the JSON object
+// {"key": ..., "before": ..., "after": ...} holding the rows of the change, and the LSN and the
+// xid of the transaction are the pglsn and pgxid extension attributes.
+package cloudevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+)
+
+// SpecVersion is the version of the CloudEvents specification of the events.
+const SpecVersion = "1.0"
+
+// Options configures a Converter.
+type Options struct {
+	// TypePrefix is the prefix of the event types, followed by a dot and the operation. It
+	// defaults to "io.github.jackc.pglogrepl", which gives types such as
+	// "io.github.jackc.pglogrepl.insert".
+	TypePrefix string
+	// SourcePrefix is the URI reference the schema and the table are appended to as path
+	// segments to make the source of the events. It defaults to "/pglogrepl", which gives sources
+	// such as "/pglogrepl/public/users". Including the database or server name makes the sources
+	// of several databases distinct.
+	SourcePrefix string
+}
+
+// Event is a CloudEvent.
+type Event struct {
+	ID              string
+	Source          string
+	Type            string
+	Time            time.Time
+	DataContentType string
+	Data            json.RawMessage
+	// LSN and Xid are the pglsn and pgxid extension attributes.
+	LSN pglogrepl.LSN
+	Xid uint32
+}
+
+// MarshalJSON returns the event in the JSON event format, the structured content mode.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	attrs := map[string]interface{}{
+		"specversion":     SpecVersion,
+		"id":              e.ID,
+		"source":          e.Source,
+		"type":            e.Type,
+		"datacontenttype": e.DataContentType,
+		"data":            e.Data,
+		"pglsn":           e.LSN.String(),
+	}
+	if !e.Time.IsZero() {
+		attrs["time"] = e.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if e.Xid != 0 {
+		attrs["pgxid"] = e.Xid
+	}
+	return json.Marshal(attrs)
+}
+
+// HTTPHeaders returns the attributes of the event as the headers of an HTTP request in the binary
+// content mode, whose body is Data.
+func (e *Event) HTTPHeaders() http.Header {
+	h := http.Header{}
+	h.Set("ce-specversion", SpecVersion)
+	h.Set("ce-id", e.ID)
+	h.Set("ce-source", e.Source)
+	h.Set("ce-type", e.Type)
+	if !e.Time.IsZero() {
+		h.Set("ce-time", e.Time.UTC().Format(time.RFC3339Nano))
+	}
+	h.Set("ce-pglsn", e.LSN.String())
+	if e.Xid != 0 {
+		h.Set("ce-pgxid", fmt.Sprint(e.Xid))
+	}
+	h.Set("Content-Type", e.DataContentType)
+	return h
+}
+
+// Converter converts change events into CloudEvents. Events of the same source and LSN, such as
+// the changes of a wal2json format version 1 transaction, which all have the position of the
+// transaction, get their sequence number in the LSN appended to their ID, as in "0/16B2470-2",
+// so that IDs stay unique; they must therefore be converted in order. A Converter is not safe
+// for concurrent use.
+type Converter struct {
+	options Options
+
+	lastSource string
+	lastLSN    pglogrepl.LSN
+	seq        int
+}
+
+// NewConverter returns a new Converter.
+func NewConverter(options Options) *Converter {
+	if options.TypePrefix == "" {
+		options.TypePrefix = "io.github.jackc.pglogrepl"
+	}
+	if options.SourcePrefix == "" {
+		options.SourcePrefix = "/pglogrepl"
+	}
+	return &Converter{options: options}
+}
+
+// Convert wraps event in a CloudEvent.
+func (c *Converter) Convert(event *pglogrepl.ChangeEvent) (*Event, error) {
+	data, err := json.Marshal(struct {
+		Key    map[string]interface{} `json:"key"`
+		Before map[string]interface{} `json:"before"`
+		After  map[string]interface{} `json:"after"`
+	}{event.Key, event.Before, event.After})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode change event data: %w", err)
+	}
+
+	source := c.options.SourcePrefix + "/" + url.PathEscape(event.Schema) + "/" + url.PathEscape(event.Table)
+	id := event.LSN.String()
+	if source == c.lastSource && event.LSN == c.lastLSN {
+		c.seq++
+		id = fmt.Sprintf("%s-%d", id, c.seq)
+	} else {
+		c.lastSource, c.lastLSN, c.seq = source, event.LSN, 0
+	}
+
+	return &Event{
+		ID:              id,
+		Source:          source,
+		Type:            c.options.TypePrefix + "." + string(event.Operation),
+		Time:            event.CommitTime,
+		DataContentType: "application/json",
+		Data:            data,
+		LSN:             event.LSN,
+		Xid:             event.Xid,
+	}, nil
+}
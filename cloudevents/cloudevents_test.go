@@ -0,0 +1,68 @@
+package cloudevents_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/cloudevents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConverter(t *testing.T) {
+	c := cloudevents.NewConverter(cloudevents.Options{})
+	commitTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	event, err := c.Convert(&pglogrepl.ChangeEvent{
+		Operation:  pglogrepl.OperationInsert,
+		Schema:     "public",
+		Table:      "my table",
+		Key:        map[string]interface{}{"id": 1},
+		After:      map[string]interface{}{"id": 1, "name": "a"},
+		LSN:        0x16B2470,
+		CommitTime: commitTime,
+		Xid:        42,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "0/16B2470", event.ID)
+	assert.Equal(t, "/pglogrepl/public/my%20table", event.Source)
+	assert.Equal(t, "io.github.jackc.pglogrepl.insert", event.Type)
+
+	data, err := json.Marshal(event)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"specversion": "1.0",
+		"id": "0/16B2470",
+		"source": "/pglogrepl/public/my%20table",
+		"type": "io.github.jackc.pglogrepl.insert",
+		"time": "2024-01-02T03:04:05Z",
+		"datacontenttype": "application/json",
+		"data": {"key": {"id": 1}, "before": null, "after": {"id": 1, "name": "a"}},
+		"pglsn": "0/16B2470",
+		"pgxid": 42
+	}`, string(data))
+
+	h := event.HTTPHeaders()
+	assert.Equal(t, "1.0", h.Get("ce-specversion"))
+	assert.Equal(t, "0/16B2470", h.Get("ce-id"))
+	assert.Equal(t, "2024-01-02T03:04:05Z", h.Get("ce-time"))
+	assert.Equal(t, "42", h.Get("ce-pgxid"))
+	assert.Equal(t, "application/json", h.Get("Content-Type"))
+
+	// Events sharing the position of their transaction get distinct IDs.
+	c = cloudevents.NewConverter(cloudevents.Options{TypePrefix: "com.example.cdc", SourcePrefix: "//db.example.com/app"})
+	var ids []string
+	for _, table := range []string{"a", "a", "a", "b"} {
+		event, err := c.Convert(&pglogrepl.ChangeEvent{Operation: pglogrepl.OperationTruncate, Schema: "public", Table: table, LSN: 0x100})
+		require.NoError(t, err)
+		assert.Equal(t, "com.example.cdc.truncate", event.Type)
+		ids = append(ids, event.Source+" "+event.ID)
+	}
+	assert.Equal(t, []string{
+		"//db.example.com/app/public/a 0/100",
+		"//db.example.com/app/public/a 0/100-1",
+		"//db.example.com/app/public/a 0/100-2",
+		"//db.example.com/app/public/b 0/100",
+	}, ids)
+}
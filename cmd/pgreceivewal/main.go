@@ -0,0 +1,434 @@
+// Command pgreceivewal streams the write-ahead log of a PostgreSQL server to WAL segment files,
+// like PostgreSQL's pg_receivewal. It is built on the physical replication API of pglogrepl.
+//
+// Usage:
+//
+//	pgreceivewal -d "host=localhost" -D /var/lib/wal-archive -S archiver --create-slot
+//	pgreceivewal -d "host=localhost" -D /var/lib/wal-archive -S archiver -Z gzip:5
+//
+// Segments are written with the .partial suffix, which is removed once they are complete. When
+// it starts, pgreceivewal resumes from the last segment in the directory: the one following it
+// if it is complete, the beginning of it if it is partial. Otherwise it starts from the restart
+// position of the slot or the current position of the server. Timeline switches are followed and
+// the timeline history files written. The position written and the position synced are reported
+// to the server every status interval; the latter advances whenever a segment is complete, or
+// after every write with --synchronous, which also reports it right away.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		log.SetFlags(0)
+		log.Fatalf("pgreceivewal: %v", err)
+	}
+}
+
+// options are the command line options, named as those of pg_receivewal.
+type options struct {
+	directory      string
+	dbname         string
+	slot           string
+	createSlot     bool
+	dropSlot       bool
+	ifNotExists    bool
+	endPos         pglogrepl.LSN
+	statusInterval time.Duration
+	synchronous    bool
+	compressLevel  int
+	noLoop         bool
+	verbose        bool
+	// retryInterval is the delay before reconnecting after the connection is lost.
+	retryInterval time.Duration
+}
+
+// compressValue is a flag.Value parsing the compression method: a gzip level from 0 to 9, where 0
+// is no compression, "gzip", "gzip:level" or "none".
+type compressValue struct{ level *int }
+
+func (v compressValue) String() string {
+	if v.level == nil || *v.level == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("gzip:%d", *v.level)
+}
+
+func (v compressValue) Set(s string) error {
+	method, level, hasLevel := strings.Cut(s, ":")
+	switch {
+	case method == "none" && !hasLevel:
+		*v.level = 0
+		return nil
+	case method == "gzip" && !hasLevel:
+		*v.level = 6
+		return nil
+	case method != "gzip":
+		if hasLevel {
+			return fmt.Errorf("unsupported compression method %q", method)
+		}
+		level = method
+	}
+	n, err := strconv.Atoi(level)
+	if err != nil || n < 0 || n > 9 {
+		return fmt.Errorf("invalid compression level %q", level)
+	}
+	*v.level = n
+	return nil
+}
+
+// secondsValue is a flag.Value parsing a number of seconds, as pg_receivewal does.
+type secondsValue struct{ d *time.Duration }
+
+func (v secondsValue) String() string {
+	if v.d == nil {
+		return ""
+	}
+	return fmt.Sprint(int64(*v.d / time.Second))
+}
+
+func (v secondsValue) Set(s string) error {
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || seconds < 0 {
+		return fmt.Errorf("invalid number of seconds %q", s)
+	}
+	*v.d = time.Duration(seconds) * time.Second
+	return nil
+}
+
+func parseOptions(args []string, stderr io.Writer) (*options, error) {
+	o := &options{statusInterval: 10 * time.Second, retryInterval: 5 * time.Second}
+	fs := flag.NewFlagSet("pgreceivewal", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	stringVar := func(p *string, short, long, usage string) {
+		fs.StringVar(p, short, "", usage)
+		fs.StringVar(p, long, "", usage)
+	}
+	boolVar := func(p *bool, short, long, usage string) {
+		if short != "" {
+			fs.BoolVar(p, short, false, usage)
+		}
+		fs.BoolVar(p, long, false, usage)
+	}
+	value := func(v flag.Value, short, long, usage string) {
+		fs.Var(v, short, usage)
+		fs.Var(v, long, usage)
+	}
+
+	stringVar(&o.directory, "D", "directory", "receive write-ahead log files into this directory")
+	stringVar(&o.dbname, "d", "dbname", "connection string")
+	stringVar(&o.slot, "S", "slot", "replication slot to use")
+	boolVar(&o.createSlot, "", "create-slot", "create a new replication slot")
+	boolVar(&o.dropSlot, "", "drop-slot", "drop the replication slot")
+	boolVar(&o.ifNotExists, "", "if-not-exists", "do not error if the slot already exists when creating it")
+	value(&o.endPos, "E", "endpos", "exit after receiving the specified LSN")
+	value(secondsValue{&o.statusInterval}, "s", "status-interval", "time between status packets sent to server (default 10)")
+	boolVar(&o.synchronous, "", "synchronous", "flush write-ahead log immediately after writing")
+	value(compressValue{&o.compressLevel}, "Z", "compress", "compress as specified: none, gzip[:level] or a level from 0 to 9")
+	boolVar(&o.noLoop, "n", "no-loop", "do not loop on connection lost")
+	boolVar(&o.verbose, "v", "verbose", "output verbose messages")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("too many command-line arguments (first is %q)", fs.Arg(0))
+	}
+	if o.dropSlot && o.createSlot {
+		return nil, fmt.Errorf("cannot use --create-slot together with --drop-slot")
+	}
+	if (o.createSlot || o.dropSlot) && o.slot == "" {
+		return nil, fmt.Errorf("--create-slot and --drop-slot need a slot to be specified using --slot")
+	}
+	if !o.dropSlot && o.directory == "" {
+		return nil, fmt.Errorf("no target directory specified")
+	}
+	if o.synchronous && o.compressLevel > 0 {
+		// Every flush ends a gzip block, which defeats the compression.
+		return nil, fmt.Errorf("cannot use --synchronous together with compression")
+	}
+	return o, nil
+}
+
+// errEndPosReached is returned by a session that reached the end position.
+var errEndPosReached = errors.New("end position reached")
+
+// run runs pgreceivewal with the command line arguments args until ctx is canceled.
+func run(ctx context.Context, args []string, stderr io.Writer) error {
+	o, err := parseOptions(args, stderr)
+	if err != nil {
+		return err
+	}
+	logger := log.New(io.Discard, "pgreceivewal: ", 0)
+	if o.verbose {
+		logger.SetOutput(stderr)
+	}
+
+	config, err := pgconn.ParseConfig(o.dbname)
+	if err != nil {
+		return err
+	}
+	config.RuntimeParams["replication"] = "true"
+	connect := func(ctx context.Context) (*pgconn.PgConn, error) {
+		return pgconn.ConnectConfig(ctx, config)
+	}
+
+	// Like pg_receivewal, --create-slot and --drop-slot exit once the slot is created or dropped.
+	if o.dropSlot || o.createSlot {
+		conn, err := connect(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close(context.Background())
+		if o.dropSlot {
+			logger.Printf("dropping replication slot %q", o.slot)
+			return pglogrepl.DropReplicationSlot(ctx, conn, o.slot, pglogrepl.DropReplicationSlotOptions{})
+		}
+		logger.Printf("creating replication slot %q", o.slot)
+		_, err = pglogrepl.CreateReplicationSlot(ctx, conn, o.slot, "", pglogrepl.CreateReplicationSlotOptions{
+			Mode:       pglogrepl.PhysicalReplication,
+			ReserveWAL: true,
+		})
+		var pgErr *pgconn.PgError
+		if o.ifNotExists && errors.As(err, &pgErr) && pgErr.Code == "42710" {
+			logger.Printf("replication slot %q already exists", o.slot)
+			return nil
+		}
+		return err
+	}
+
+	for {
+		s := &session{options: o, logger: logger}
+		err := s.run(ctx, connect)
+		switch {
+		case ctx.Err() != nil || errors.Is(err, errEndPosReached):
+			return nil
+		case o.noLoop:
+			return err
+		}
+		logger.Printf("disconnected: %v", err)
+		logger.Printf("waiting for %s to try again", o.retryInterval)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(o.retryInterval):
+		}
+	}
+}
+
+// session streams the WAL on a connection until it is lost, ctx is canceled or the end position
+// is reached.
+type session struct {
+	options *options
+	logger  *log.Logger
+	writer  *walWriter
+}
+
+func (s *session) run(ctx context.Context, connect func(context.Context) (*pgconn.PgConn, error)) error {
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { conn.Close(context.Background()) }()
+
+	results, err := conn.Exec(ctx, "SHOW wal_segment_size").ReadAll()
+	if err != nil {
+		return fmt.Errorf("could not fetch WAL segment size: %w", err)
+	}
+	if len(results) != 1 || len(results[0].Rows) != 1 || len(results[0].Rows[0]) != 1 {
+		return fmt.Errorf("could not fetch WAL segment size: unexpected result")
+	}
+	segmentSize, err := parseSegmentSize(string(results[0].Rows[0][0]))
+	if err != nil {
+		return err
+	}
+
+	pos, err := s.startPosition(ctx, conn, segmentSize)
+	if err != nil {
+		return err
+	}
+	s.writer = &walWriter{dir: s.options.directory, segmentSize: segmentSize, compressLevel: s.options.compressLevel}
+	defer s.writer.close()
+
+	for {
+		if pos.timeline > 1 {
+			history, err := pglogrepl.TimelineHistory(ctx, conn, pos.timeline)
+			if err != nil {
+				return fmt.Errorf("could not fetch timeline history: %w", err)
+			}
+			if err := writeHistoryFile(s.options.directory, history.FileName, history.Content); err != nil {
+				return err
+			}
+		}
+
+		s.logger.Printf("starting log streaming at %s (timeline %d)", pos.lsn, pos.timeline)
+		s.writer.timeline = pos.timeline
+		stream, err := pglogrepl.StartReplicationStream(ctx, conn, s.options.slot, pos.lsn, pglogrepl.ReplicationStreamOptions{
+			StartReplicationOptions: pglogrepl.StartReplicationOptions{Mode: pglogrepl.PhysicalReplication, Timeline: pos.timeline},
+			StandbyMessageTimeout:   s.options.statusInterval,
+			AckPolicy:               pglogrepl.AckOnApply,
+		})
+		if timeline, lsn, ok := pglogrepl.IsErrEndTimeline(err); ok {
+			// The timeline requested ended right at the start position.
+			pos = walPosition{timeline: int32(timeline), lsn: lsn - lsn%pglogrepl.LSN(segmentSize)}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.receive(ctx, stream); err != io.EOF {
+			return err
+		}
+
+		// The server reached the end of the timeline.
+		if err := s.writer.close(); err != nil {
+			return err
+		}
+		cdr, err := pglogrepl.DrainStream(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if cdr.Timeline == 0 {
+			return fmt.Errorf("replication stream was terminated before stop point")
+		}
+		s.logger.Printf("switched to timeline %d at %s", cdr.Timeline, cdr.LSN)
+		// Streaming always starts at the beginning of a segment.
+		pos = walPosition{timeline: cdr.Timeline, lsn: cdr.LSN - cdr.LSN%pglogrepl.LSN(segmentSize)}
+	}
+}
+
+// startPosition returns the position following the segments in the directory or, if there is
+// none, the restart position of the slot or the current position of the server.
+func (s *session) startPosition(ctx context.Context, conn *pgconn.PgConn, segmentSize uint64) (walPosition, error) {
+	pos, ok, err := scanDirectory(s.options.directory, segmentSize)
+	if err != nil || ok {
+		return pos, err
+	}
+
+	if s.options.slot != "" {
+		// READ_REPLICATION_SLOT is only supported since PostgreSQL 15.
+		slot, err := pglogrepl.ReadReplicationSlot(ctx, conn, s.options.slot)
+		if err == nil && slot.RestartLSN != 0 {
+			pos = walPosition{timeline: slot.RestartTimeline, lsn: slot.RestartLSN}
+		}
+	}
+	if pos.lsn == 0 {
+		sysident, err := pglogrepl.IdentifySystem(ctx, conn)
+		if err != nil {
+			return pos, err
+		}
+		pos = walPosition{timeline: sysident.Timeline, lsn: sysident.XLogPos}
+	}
+	pos.lsn -= pos.lsn % pglogrepl.LSN(segmentSize)
+	return pos, nil
+}
+
+// receive writes the WAL received from stream until the server ends the timeline, which it
+// reports with io.EOF.
+func (s *session) receive(ctx context.Context, stream *pglogrepl.ReplicationStream) error {
+	flush := func() error {
+		lsn, err := s.writer.flush()
+		if err != nil {
+			return err
+		}
+		if lsn > 0 {
+			stream.SetFlushedLSN(lsn)
+		}
+		return nil
+	}
+
+	// A status interval of 0 only flushes when a segment is complete.
+	interval := s.options.statusInterval
+	flushDeadline := time.Now().Add(interval)
+	for {
+		nextCtx, cancel := ctx, context.CancelFunc(func() {})
+		if interval > 0 {
+			nextCtx, cancel = context.WithDeadline(ctx, flushDeadline)
+		}
+		msg, err := stream.Next(nextCtx)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return s.stop(stream, flush)
+		case errors.Is(err, context.DeadlineExceeded):
+			if err := flush(); err != nil {
+				return err
+			}
+			flushDeadline = time.Now().Add(interval)
+			continue
+		case err != nil:
+			return err
+		}
+
+		data := msg.WALData
+		endPos := s.options.endPos
+		endPosReached := endPos != 0 && msg.WALStart+pglogrepl.LSN(len(data)) >= endPos
+		if endPosReached {
+			if msg.WALStart < endPos {
+				data = data[:endPos-msg.WALStart]
+			} else {
+				data = nil
+			}
+		}
+		if err := s.writer.write(msg.WALStart, data); err != nil {
+			return err
+		}
+		if len(data) > 0 {
+			stream.SetWrittenLSN(s.writer.position())
+			if s.writer.written == 0 {
+				// A segment was completed.
+				stream.SetFlushedLSN(s.writer.position())
+			}
+		}
+		if endPosReached {
+			s.logger.Printf("end position %s reached", endPos)
+			if err := s.stop(stream, flush); err != nil {
+				return err
+			}
+			return errEndPosReached
+		}
+		if s.options.synchronous {
+			// The position is reported right away, so that the server can use pgreceivewal as a
+			// synchronous standby.
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := stream.ReportPositions(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// stop flushes the WAL written and stops streaming, confirming the position flushed.
+func (s *session) stop(stream *pglogrepl.ReplicationStream, flush func() error) error {
+	if err := flush(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return stream.Stop(ctx)
+}
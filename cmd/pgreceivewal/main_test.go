@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/pglogrepltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	srv, err := pglogrepltest.NewServer(pglogrepltest.ServerOptions{WALSegmentSize: "1MB"})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dir := t.TempDir()
+	var stderr bytes.Buffer
+	runArgs := func(args ...string) error {
+		return run(ctx, append([]string{"-d", srv.ConnString(), "-S", "archive", "-D", dir}, args...), &stderr)
+	}
+
+	require.NoError(t, runArgs("--create-slot"))
+	assert.Equal(t, []string{"archive"}, srv.Slots())
+	require.NoError(t, runArgs("--create-slot", "--if-not-exists"))
+
+	done := make(chan error, 1)
+	go func() { done <- runArgs("-E", "0/1C0000", "-n") }()
+	const chunk = 256 << 10
+	for lsn := pglogrepl.LSN(0); lsn < 0x180000; lsn += chunk {
+		require.NoError(t, srv.SendXLogData(lsn, bytes.Repeat([]byte{1}, chunk)))
+	}
+	// Timeline 2 branches off in the middle of the second segment, which is streamed again.
+	require.NoError(t, srv.EndTimeline(0x180000))
+	for lsn := pglogrepl.LSN(0x100000); lsn < 0x200000; lsn += chunk {
+		require.NoError(t, srv.SendXLogData(lsn, bytes.Repeat([]byte{2}, chunk)))
+	}
+	require.NoError(t, <-done)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{
+		"000000010000000000000000",
+		"000000010000000000000001.partial",
+		"00000002.history",
+		"000000020000000000000001.partial",
+	}, names)
+
+	data, err := os.ReadFile(filepath.Join(dir, "000000010000000000000000"))
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{1}, 1<<20), data)
+	data, err = os.ReadFile(filepath.Join(dir, "000000020000000000000001.partial"))
+	require.NoError(t, err)
+	require.Len(t, data, 1<<20)
+	assert.Equal(t, bytes.Repeat([]byte{2}, 0xC0000), data[:0xC0000])
+	assert.Equal(t, make([]byte, 1<<20-0xC0000), data[0xC0000:])
+	history, err := os.ReadFile(filepath.Join(dir, "00000002.history"))
+	require.NoError(t, err)
+	assert.Equal(t, "1\t0/180000\tpglogrepltest timeline switch\n", string(history))
+
+	assert.Contains(t, srv.Queries(), "START_REPLICATION SLOT archive PHYSICAL 0/0 TIMELINE 1")
+	assert.Contains(t, srv.Queries(), "START_REPLICATION SLOT archive PHYSICAL 0/100000 TIMELINE 2")
+	updates := srv.StandbyStatusUpdates()
+	require.NotEmpty(t, updates)
+	assert.Equal(t, pglogrepl.LSN(0x1C0000), updates[len(updates)-1].WALFlushPosition)
+
+	require.NoError(t, runArgs("--drop-slot"))
+	assert.Empty(t, srv.Slots())
+}
+
+func TestRunCompress(t *testing.T) {
+	srv, err := pglogrepltest.NewServer(pglogrepltest.ServerOptions{WALSegmentSize: "1MB", XLogPos: 0x100123})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dir := t.TempDir()
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, []string{"-d", srv.ConnString(), "-D", dir, "-Z", "gzip:1", "-E", "0/200000", "-n"}, &bytes.Buffer{})
+	}()
+	require.NoError(t, srv.SendXLogData(0x100000, bytes.Repeat([]byte{1}, 1<<20)))
+	require.NoError(t, <-done)
+
+	_, err = os.Stat(filepath.Join(dir, "000000010000000000000001.gz"))
+	assert.NoError(t, err)
+	assert.Contains(t, srv.Queries(), "START_REPLICATION PHYSICAL 0/100000 TIMELINE 1")
+}
+
+func TestScanDirectory(t *testing.T) {
+	dir := t.TempDir()
+	_, ok, err := scanDirectory(dir, 16<<20)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	for _, name := range []string{"00000001000000000000003F", "000000010000000100000000.partial", "00000002.history", "unrelated"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+	}
+	pos, ok, err := scanDirectory(dir, 1<<26)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, walPosition{timeline: 1, lsn: 0x100000000}, pos)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "000000020000000100000000.gz"), nil, 0o600))
+	pos, ok, err = scanDirectory(dir, 1<<26)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, walPosition{timeline: 2, lsn: 0x104000000}, pos)
+}
+
+func TestParseSegmentSize(t *testing.T) {
+	for s, want := range map[string]uint64{"16MB": 16 << 20, "1GB": 1 << 30, "1024kB": 1 << 20} {
+		size, err := parseSegmentSize(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, size, s)
+	}
+	for _, s := range []string{"", "MB", "16", "15MB", "512kB", "2GB", "16 MB"} {
+		_, err := parseSegmentSize(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-S", "s", "--create-slot", "--drop-slot"},
+		{"--drop-slot"},
+		{"-D", "dir", "-Z", "zstd"},
+		{"-D", "dir", "-Z", "gzip:10"},
+		{"-D", "dir", "-Z", "1", "--synchronous"},
+		{"-D", "dir", "-s", "-1"},
+		{"-D", "dir", "extra"},
+	} {
+		_, err := parseOptions(args, &bytes.Buffer{})
+		assert.Error(t, err, args)
+	}
+
+	o, err := parseOptions([]string{"--directory", "dir", "--slot", "s", "--endpos", "0/16B3748", "-s", "5", "-Z", "gzip"}, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x16B3748), o.endPos)
+	assert.Equal(t, 5*time.Second, o.statusInterval)
+	assert.Equal(t, 6, o.compressLevel)
+
+	o, err = parseOptions([]string{"-D", "dir", "-Z", "0"}, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, o.compressLevel)
+}
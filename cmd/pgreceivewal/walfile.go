@@ -0,0 +1,248 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pglogrepl"
+)
+
+// segmentName returns the WAL file name of segment segno of timeline, such as
+// 000000010000000000000003.
+func segmentName(timeline int32, segno, segmentSize uint64) string {
+	segmentsPerID := 0x100000000 / segmentSize
+	return fmt.Sprintf("%08X%08X%08X", timeline, segno/segmentsPerID, segno%segmentsPerID)
+}
+
+var segmentFileName = regexp.MustCompile(`^([0-9A-F]{8})([0-9A-F]{8})([0-9A-F]{8})(\.gz)?(\.partial)?$`)
+
+// walPosition is where streaming is resumed from.
+type walPosition struct {
+	timeline int32
+	lsn      pglogrepl.LSN
+}
+
+// scanDirectory returns the position following the last segment in dir: the end of the last
+// complete segment or, if the last one is partial, its start, since a partial segment is
+// streamed again. ok is false if dir has no segment.
+func scanDirectory(dir string, segmentSize uint64) (pos walPosition, ok bool, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return pos, false, fmt.Errorf("could not read directory %q: %w", dir, err)
+	}
+	var lastSegno uint64
+	for _, entry := range entries {
+		m := segmentFileName.FindStringSubmatch(entry.Name())
+		if m == nil || !entry.Type().IsRegular() {
+			continue
+		}
+		timeline, _ := strconv.ParseUint(m[1], 16, 32)
+		high, _ := strconv.ParseUint(m[2], 16, 32)
+		low, _ := strconv.ParseUint(m[3], 16, 32)
+		segno := high*(0x100000000/segmentSize) + low
+		if m[5] == "" {
+			// The next segment is streamed after a complete one.
+			segno++
+		}
+		if !ok || segno > lastSegno || (segno == lastSegno && int32(timeline) > pos.timeline) {
+			lastSegno = segno
+			pos = walPosition{timeline: int32(timeline), lsn: pglogrepl.LSN(segno * segmentSize)}
+			ok = true
+		}
+	}
+	return pos, ok, nil
+}
+
+// parseSegmentSize parses the wal_segment_size setting, such as 16MB.
+func parseSegmentSize(s string) (uint64, error) {
+	units := map[string]uint64{"B": 1, "kB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30}
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i <= 0 {
+		return 0, fmt.Errorf("invalid wal_segment_size %q", s)
+	}
+	n, err := strconv.ParseUint(s[:i], 10, 64)
+	unit, ok := units[s[i:]]
+	if err != nil || !ok {
+		return 0, fmt.Errorf("invalid wal_segment_size %q", s)
+	}
+	size := n * unit
+	if size < 1<<20 || size > 1<<30 || size&(size-1) != 0 {
+		return 0, fmt.Errorf("WAL segment size must be a power of two between 1 MB and 1 GB, got %q", s)
+	}
+	return size, nil
+}
+
+// walWriter writes the WAL received to segment files. A segment is written as name.partial, with
+// name.gz.partial when compressed, and renamed once complete.
+type walWriter struct {
+	dir           string
+	segmentSize   uint64
+	compressLevel int
+
+	timeline int32
+	segno    uint64
+	// written is the number of bytes of the segment written.
+	written uint64
+	file    *os.File
+	gz      *gzip.Writer
+	name    string
+}
+
+// write writes the WAL data starting at lsn, which must follow the WAL written so far unless it
+// starts a segment.
+func (w *walWriter) write(lsn pglogrepl.LSN, data []byte) error {
+	for len(data) > 0 {
+		segno := uint64(lsn) / w.segmentSize
+		offset := uint64(lsn) % w.segmentSize
+		if w.file == nil {
+			if offset != 0 {
+				return fmt.Errorf("received WAL at %s, which does not start a segment", lsn)
+			}
+			if err := w.open(segno); err != nil {
+				return err
+			}
+		}
+		if segno != w.segno || offset != w.written {
+			return fmt.Errorf("received WAL at %s, expected %s", lsn, w.position())
+		}
+
+		n := w.segmentSize - offset
+		if n > uint64(len(data)) {
+			n = uint64(len(data))
+		}
+		var err error
+		if w.gz != nil {
+			_, err = w.gz.Write(data[:n])
+		} else {
+			_, err = w.file.Write(data[:n])
+		}
+		if err != nil {
+			return fmt.Errorf("could not write to file %q: %w", w.file.Name(), err)
+		}
+		w.written += n
+		lsn += pglogrepl.LSN(n)
+		data = data[n:]
+
+		if w.written == w.segmentSize {
+			if err := w.complete(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// position returns the position of the WAL written.
+func (w *walWriter) position() pglogrepl.LSN {
+	return pglogrepl.LSN(w.segno*w.segmentSize + w.written)
+}
+
+func (w *walWriter) open(segno uint64) error {
+	w.segno = segno
+	w.written = 0
+	w.name = filepath.Join(w.dir, segmentName(w.timeline, segno, w.segmentSize))
+	if w.compressLevel > 0 {
+		w.name += ".gz"
+	}
+	f, err := os.OpenFile(w.name+".partial", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open WAL file: %w", err)
+	}
+	w.file = f
+	if w.compressLevel > 0 {
+		if w.gz, err = gzip.NewWriterLevel(f, w.compressLevel); err != nil {
+			f.Close()
+			return err
+		}
+		return nil
+	}
+	// An uncompressed partial segment has the size of a complete segment, as the server's.
+	if err := f.Truncate(int64(w.segmentSize)); err != nil {
+		f.Close()
+		return fmt.Errorf("could not pad WAL file: %w", err)
+	}
+	return nil
+}
+
+// flush makes the WAL written durable and returns its end.
+func (w *walWriter) flush() (pglogrepl.LSN, error) {
+	if w.file == nil {
+		return 0, nil
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			return 0, fmt.Errorf("could not compress WAL file %q: %w", w.file.Name(), err)
+		}
+	}
+	if err := w.file.Sync(); err != nil {
+		return 0, fmt.Errorf("could not fsync file %q: %w", w.file.Name(), err)
+	}
+	return w.position(), nil
+}
+
+// complete closes the complete segment and removes its .partial suffix.
+func (w *walWriter) complete() error {
+	if err := w.close(); err != nil {
+		return err
+	}
+	if err := os.Rename(w.name+".partial", w.name); err != nil {
+		return fmt.Errorf("could not rename WAL file: %w", err)
+	}
+	return syncDir(w.dir)
+}
+
+// close closes the segment being written, which keeps its .partial suffix if it is not complete.
+func (w *walWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		err = w.gz.Close()
+		w.gz = nil
+	}
+	if serr := w.file.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+	if err != nil {
+		return fmt.Errorf("could not close WAL file: %w", err)
+	}
+	return nil
+}
+
+// writeHistoryFile writes the timeline history file name with content, unless it exists.
+func writeHistoryFile(dir, name string, content []byte) error {
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return fmt.Errorf("could not write timeline history file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("could not rename timeline history file: %w", err)
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("could not fsync directory %q: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,376 @@
+// Command pgrecvlogical controls logical decoding replication slots and streams data from them,
+// like PostgreSQL's pg_recvlogical. It is built on pglogrepl and serves as a reference consumer.
+//
+// Usage:
+//
+//	pgrecvlogical -d "host=localhost dbname=postgres" -S slot --create-slot -P pgoutput
+//	pgrecvlogical -d "host=localhost dbname=postgres" -S slot --start -f out.txt \
+//		-o proto_version=1 -o publication_names=pub
+//	pgrecvlogical -d "host=localhost dbname=postgres" -S slot --drop-slot
+//
+// The connection string is a libpq keyword/value string or URL; the PG* environment variables
+// are used for what it does not set, and replication=database is added. Every message received
+// is written to the output file followed by a newline. The file is synced, and the position
+// written confirmed to the server, every fsync interval; SIGHUP reopens it, for log rotation.
+// SIGINT and SIGTERM end streaming after confirming the position synced.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reopen := make(chan os.Signal, 1)
+	signal.Notify(reopen, syscall.SIGHUP)
+
+	err := run(ctx, os.Args[1:], os.Stdout, os.Stderr, reopen)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		log.SetFlags(0)
+		log.Fatalf("pgrecvlogical: %v", err)
+	}
+}
+
+// options are the command line options, named as those of pg_recvlogical.
+type options struct {
+	dbname         string
+	slot           string
+	createSlot     bool
+	dropSlot       bool
+	start          bool
+	ifNotExists    bool
+	plugin         string
+	twoPhase       bool
+	file           string
+	startPos       pglogrepl.LSN
+	endPos         pglogrepl.LSN
+	pluginOptions  []string
+	fsyncInterval  time.Duration
+	statusInterval time.Duration
+	noLoop         bool
+	verbose        bool
+}
+
+// pluginOption is a flag.Value collecting the -o name[=value] plugin options.
+type pluginOption struct{ options *[]string }
+
+func (v pluginOption) String() string {
+	return ""
+}
+
+func (v pluginOption) Set(s string) error {
+	name, value, hasValue := strings.Cut(s, "=")
+	if name == "" {
+		return fmt.Errorf("empty plugin option name")
+	}
+	option := `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	if hasValue {
+		option += " '" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	*v.options = append(*v.options, option)
+	return nil
+}
+
+// secondsValue is a flag.Value parsing a number of seconds, as pg_recvlogical does.
+type secondsValue struct{ d *time.Duration }
+
+func (v secondsValue) String() string {
+	if v.d == nil {
+		return ""
+	}
+	return fmt.Sprint(int64(*v.d / time.Second))
+}
+
+func (v secondsValue) Set(s string) error {
+	var seconds int64
+	if _, err := fmt.Sscan(s, &seconds); err != nil || seconds < 0 {
+		return fmt.Errorf("invalid number of seconds %q", s)
+	}
+	*v.d = time.Duration(seconds) * time.Second
+	return nil
+}
+
+func parseOptions(args []string, stderr io.Writer) (*options, error) {
+	o := &options{plugin: "test_decoding", fsyncInterval: 10 * time.Second, statusInterval: 10 * time.Second}
+	fs := flag.NewFlagSet("pgrecvlogical", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	stringVar := func(p *string, short, long, value, usage string) {
+		fs.StringVar(p, short, value, usage)
+		fs.StringVar(p, long, value, usage)
+	}
+	boolVar := func(p *bool, short, long, usage string) {
+		if short != "" {
+			fs.BoolVar(p, short, false, usage)
+		}
+		fs.BoolVar(p, long, false, usage)
+	}
+	value := func(v flag.Value, short, long, usage string) {
+		fs.Var(v, short, usage)
+		fs.Var(v, long, usage)
+	}
+
+	stringVar(&o.dbname, "d", "dbname", "", "connection string")
+	stringVar(&o.slot, "S", "slot", "", "name of the logical replication slot")
+	boolVar(&o.createSlot, "", "create-slot", "create a new replication slot")
+	boolVar(&o.dropSlot, "", "drop-slot", "drop the replication slot")
+	boolVar(&o.start, "", "start", "start streaming in a replication slot")
+	boolVar(&o.ifNotExists, "", "if-not-exists", "do not error if the slot already exists when creating it")
+	stringVar(&o.plugin, "P", "plugin", o.plugin, "output plugin of the slot created")
+	boolVar(&o.twoPhase, "t", "two-phase", "enable decoding of prepared transactions when creating a slot")
+	stringVar(&o.file, "f", "file", "", "receive log into this file, - for stdout")
+	value(&o.startPos, "I", "startpos", "where in an existing slot should the streaming start")
+	value(&o.endPos, "E", "endpos", "exit after receiving the specified LSN")
+	value(pluginOption{&o.pluginOptions}, "o", "option", "pass option name with optional value to the output plugin")
+	value(secondsValue{&o.fsyncInterval}, "F", "fsync-interval", "time between fsyncs to the output file, 0 to fsync every message (default 10)")
+	value(secondsValue{&o.statusInterval}, "s", "status-interval", "time between status packets sent to server (default 10)")
+	boolVar(&o.noLoop, "n", "no-loop", "do not loop on connection lost")
+	boolVar(&o.verbose, "v", "verbose", "output verbose messages")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("too many command-line arguments (first is %q)", fs.Arg(0))
+	}
+	if o.slot == "" {
+		return nil, fmt.Errorf("no slot specified")
+	}
+	if !o.createSlot && !o.dropSlot && !o.start {
+		return nil, fmt.Errorf("at least one action needs to be specified")
+	}
+	if o.dropSlot && (o.createSlot || o.start) {
+		return nil, fmt.Errorf("cannot use --create-slot or --start together with --drop-slot")
+	}
+	if o.start && o.file == "" {
+		return nil, fmt.Errorf("no target file specified")
+	}
+	if o.startPos != 0 && (o.createSlot || o.dropSlot) {
+		return nil, fmt.Errorf("cannot use --startpos together with --create-slot or --drop-slot")
+	}
+	if o.endPos != 0 && !o.start {
+		return nil, fmt.Errorf("--endpos may only be specified with --start")
+	}
+	return o, nil
+}
+
+// run runs pgrecvlogical with the command line arguments args until ctx is canceled. A value
+// received from reopen makes it reopen the output file.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer, reopen <-chan os.Signal) error {
+	o, err := parseOptions(args, stderr)
+	if err != nil {
+		return err
+	}
+	logger := log.New(io.Discard, "pgrecvlogical: ", 0)
+	if o.verbose {
+		logger.SetOutput(stderr)
+	}
+
+	config, err := pgconn.ParseConfig(o.dbname)
+	if err != nil {
+		return err
+	}
+	config.RuntimeParams["replication"] = "database"
+	connect := func(ctx context.Context) (*pgconn.PgConn, error) {
+		return pgconn.ConnectConfig(ctx, config)
+	}
+	conn, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { conn.Close(context.Background()) }()
+
+	if o.dropSlot {
+		logger.Printf("dropping replication slot %q", o.slot)
+		return pglogrepl.DropReplicationSlot(ctx, conn, o.slot, pglogrepl.DropReplicationSlotOptions{})
+	}
+	if o.createSlot {
+		logger.Printf("creating replication slot %q", o.slot)
+		_, err := pglogrepl.CreateReplicationSlot(ctx, conn, o.slot, o.plugin, pglogrepl.CreateReplicationSlotOptions{
+			Mode:           pglogrepl.LogicalReplication,
+			SnapshotAction: pglogrepl.SnapshotActionNoExport,
+			TwoPhase:       o.twoPhase,
+		})
+		var pgErr *pgconn.PgError
+		if o.ifNotExists && errors.As(err, &pgErr) && pgErr.Code == "42710" {
+			logger.Printf("replication slot %q already exists", o.slot)
+		} else if err != nil {
+			return err
+		}
+	}
+	if !o.start {
+		return nil
+	}
+
+	r := &receiver{options: o, logger: logger, stdout: stdout}
+	if err := r.openFile(); err != nil {
+		return err
+	}
+	defer r.closeFile()
+
+	streamOptions := pglogrepl.ReplicationStreamOptions{
+		StartReplicationOptions: pglogrepl.StartReplicationOptions{PluginArgs: o.pluginOptions},
+		StandbyMessageTimeout:   o.statusInterval,
+		// Nothing is confirmed before it is written.
+		AckPolicy: pglogrepl.AckOnApply,
+	}
+	if !o.noLoop {
+		streamOptions.Reconnect = &pglogrepl.ReconnectPolicy{
+			Connect:        connect,
+			InitialBackoff: 5 * time.Second,
+			MaxBackoff:     5 * time.Second,
+			OnReconnect: func(attempt int, err error) {
+				logger.Printf("reconnecting after error: %v", err)
+			},
+		}
+	}
+	logger.Printf("starting log streaming at %s (slot %s)", o.startPos, o.slot)
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, o.slot, o.startPos, streamOptions)
+	if err != nil {
+		return err
+	}
+	// The stream reconnects with new connections.
+	defer func() { conn = stream.Conn() }()
+	return r.receive(ctx, stream, reopen)
+}
+
+// receiver writes the messages of a stream to the output file.
+type receiver struct {
+	options *options
+	logger  *log.Logger
+	stdout  io.Writer
+
+	out  io.Writer
+	file *os.File
+	// written is the position of the last message written and synced the position synced.
+	written pglogrepl.LSN
+	synced  pglogrepl.LSN
+}
+
+func (r *receiver) openFile() error {
+	if r.options.file == "-" {
+		r.out = r.stdout
+		return nil
+	}
+	f, err := os.OpenFile(r.options.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+	r.file = f
+	r.out = f
+	return nil
+}
+
+func (r *receiver) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// sync syncs the output file and confirms the position written.
+func (r *receiver) sync(stream *pglogrepl.ReplicationStream) error {
+	if r.written == r.synced {
+		return nil
+	}
+	if r.file != nil {
+		if err := r.file.Sync(); err != nil {
+			return fmt.Errorf("could not fsync file %q: %w", r.options.file, err)
+		}
+	}
+	r.synced = r.written
+	stream.SetAppliedLSN(r.synced)
+	r.logger.Printf("confirming write up to %s, flush to %s", r.written, r.synced)
+	return nil
+}
+
+func (r *receiver) receive(ctx context.Context, stream *pglogrepl.ReplicationStream, reopen <-chan os.Signal) error {
+	fsyncDeadline := time.Now().Add(r.options.fsyncInterval)
+	for {
+		select {
+		case <-reopen:
+			r.logger.Printf("reopening output file %q", r.options.file)
+			if err := r.sync(stream); err != nil {
+				return err
+			}
+			if err := r.closeFile(); err != nil {
+				return err
+			}
+			if err := r.openFile(); err != nil {
+				return err
+			}
+		default:
+		}
+
+		// With a 0 fsync interval every message is synced when it is written.
+		nextCtx, cancel := ctx, context.CancelFunc(func() {})
+		if r.options.fsyncInterval > 0 {
+			nextCtx, cancel = context.WithDeadline(ctx, fsyncDeadline)
+		}
+		msg, err := stream.Next(nextCtx)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return r.stop(stream)
+		case errors.Is(err, context.DeadlineExceeded):
+			if err := r.sync(stream); err != nil {
+				return err
+			}
+			fsyncDeadline = time.Now().Add(r.options.fsyncInterval)
+			if r.options.endPos != 0 && stream.ClientXLogPos() >= r.options.endPos {
+				r.logger.Printf("end position %s reached by keepalive", r.options.endPos)
+				return r.stop(stream)
+			}
+			continue
+		case err != nil:
+			return err
+		}
+
+		if r.options.endPos != 0 && msg.WALStart > r.options.endPos {
+			r.logger.Printf("end position %s reached by WAL record at %s", r.options.endPos, msg.WALStart)
+			return r.stop(stream)
+		}
+		if _, err := r.out.Write(append(msg.WALData, '\n')); err != nil {
+			return fmt.Errorf("could not write to log file %q: %w", r.options.file, err)
+		}
+		r.written = msg.WALStart
+		if r.file == nil || r.options.fsyncInterval == 0 || !time.Now().Before(fsyncDeadline) {
+			if err := r.sync(stream); err != nil {
+				return err
+			}
+			fsyncDeadline = time.Now().Add(r.options.fsyncInterval)
+		}
+		if r.options.endPos != 0 && msg.WALStart == r.options.endPos {
+			r.logger.Printf("end position %s reached by WAL record at %s", r.options.endPos, msg.WALStart)
+			return r.stop(stream)
+		}
+	}
+}
+
+// stop syncs the output file and stops streaming, confirming the position synced.
+func (r *receiver) stop(stream *pglogrepl.ReplicationStream) error {
+	if err := r.sync(stream); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return stream.Stop(ctx)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/pglogrepltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	srv, err := pglogrepltest.NewServer(pglogrepltest.ServerOptions{})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var stderr bytes.Buffer
+	runArgs := func(args ...string) error {
+		return run(ctx, append([]string{"-d", srv.ConnString(), "-S", "test_slot"}, args...), nil, &stderr, nil)
+	}
+
+	require.NoError(t, runArgs("--create-slot", "-P", "pgoutput"))
+	assert.Equal(t, []string{"test_slot"}, srv.Slots())
+	assert.Error(t, runArgs("--create-slot"))
+	require.NoError(t, runArgs("--create-slot", "--if-not-exists"))
+
+	path := filepath.Join(t.TempDir(), "out.txt")
+	done := make(chan error, 1)
+	go func() {
+		done <- runArgs("--start", "-f", path, "-F", "0", "-E", "0/300", "-o", "proto_version=1", "-o", "skip_empty_xacts")
+	}()
+	require.NoError(t, srv.SendXLogData(0x200, []byte("first")))
+	require.NoError(t, srv.SendXLogData(0x300, []byte("second")))
+	require.NoError(t, <-done)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(data))
+	assert.Contains(t, srv.Queries(), `START_REPLICATION SLOT test_slot LOGICAL 0/0 ("proto_version" '1', "skip_empty_xacts")`)
+	updates := srv.StandbyStatusUpdates()
+	require.NotEmpty(t, updates)
+	assert.Equal(t, pglogrepl.LSN(0x300), updates[len(updates)-1].WALFlushPosition)
+
+	require.NoError(t, runArgs("--drop-slot"))
+	assert.Empty(t, srv.Slots())
+}
+
+func TestParseOptions(t *testing.T) {
+	for _, args := range [][]string{
+		{"--start", "-f", "-"},
+		{"-S", "s"},
+		{"-S", "s", "--start"},
+		{"-S", "s", "--drop-slot", "--start", "-f", "-"},
+		{"-S", "s", "--create-slot", "-E", "0/100"},
+		{"-S", "s", "--create-slot", "-I", "0/100"},
+		{"-S", "s", "--start", "-f", "-", "-F", "soon"},
+		{"-S", "s", "--start", "-f", "-", "extra"},
+	} {
+		_, err := parseOptions(args, &bytes.Buffer{})
+		assert.Error(t, err, args)
+	}
+
+	o, err := parseOptions([]string{"--slot", "s", "--start", "--file", "-", "--startpos", "0/16B3748", "-s", "5", "-o", `a"b=it's`}, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x16B3748), o.startPos)
+	assert.Equal(t, 5*time.Second, o.statusInterval)
+	assert.Equal(t, []string{`"a""b" 'it''s'`}, o.pluginOptions)
+	assert.Equal(t, "test_decoding", o.plugin)
+}
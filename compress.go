@@ -0,0 +1,99 @@
+package pglogrepl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Codec compresses the WAL data the package writes to disk, such as the messages spilled by a
+// Pipeline. Every message is compressed on its own into a frame whose LSN is stored uncompressed,
+// so the files can still be read from any message boundary.
+//
+// The package only provides GzipCodec. A zstd codec is deliberately left to the user so that
+// the module does not depend on a compression library; it is a thin wrapper around an Encoder
+// and a Decoder of github.com/klauspost/compress/zstd:
+//
+//	type zstdCodec struct {
+//		enc *zstd.Encoder
+//		dec *zstd.Decoder
+//	}
+//
+//	func (zstdCodec) Name() string { return "zstd" }
+//
+//	func (c zstdCodec) Compress(dst, src []byte) ([]byte, error) {
+//		return c.enc.EncodeAll(src, dst), nil
+//	}
+//
+//	func (c zstdCodec) Decompress(dst, src []byte) ([]byte, error) {
+//		return c.dec.DecodeAll(src, dst)
+//	}
+//
+// Codecs must be safe for concurrent use.
+type Codec interface {
+	// Name identifies the compression method, such as "gzip" or "zstd".
+	Name() string
+	// Compress appends the compressed src to dst and returns the result.
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress appends the decompressed src to dst and returns the result.
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+// GzipCodec returns a Codec compressing with gzip at level, one of the levels of package
+// compress/gzip.
+func GzipCodec(level int) (Codec, error) {
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		return nil, err
+	}
+	c := &gzipCodec{}
+	c.writers.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, level)
+		return w
+	}
+	return c, nil
+}
+
+type gzipCodec struct {
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func (c *gzipCodec) Name() string {
+	return "gzip"
+}
+
+func (c *gzipCodec) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w := c.writers.Get().(*gzip.Writer)
+	defer c.writers.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(src); err != nil {
+		return dst, fmt.Errorf("failed to compress: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return dst, fmt.Errorf("failed to compress: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *gzipCodec) Decompress(dst, src []byte) ([]byte, error) {
+	var r *gzip.Reader
+	var err error
+	if pooled, ok := c.readers.Get().(*gzip.Reader); ok {
+		r = pooled
+		err = r.Reset(bytes.NewReader(src))
+	} else {
+		r, err = gzip.NewReader(bytes.NewReader(src))
+	}
+	if err != nil {
+		return dst, fmt.Errorf("failed to decompress: %w", err)
+	}
+	defer c.readers.Put(r)
+	buf := bytes.NewBuffer(dst)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return dst, fmt.Errorf("failed to decompress: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,38 @@
+package pglogrepl_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGzipCodec(t *testing.T) {
+	codec, err := pglogrepl.GzipCodec(gzip.BestSpeed)
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", codec.Name())
+
+	data := bytes.Repeat([]byte("pglogrepl"), 1000)
+	compressed, err := codec.Compress([]byte("prefix"), data)
+	require.NoError(t, err)
+	assert.Equal(t, "prefix", string(compressed[:6]))
+	assert.Less(t, len(compressed), len(data))
+
+	decompressed, err := codec.Decompress([]byte("prefix"), compressed[6:])
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte("prefix"), data...), decompressed)
+	// The pooled reader and writer are reused.
+	compressed, err = codec.Compress(nil, []byte("again"))
+	require.NoError(t, err)
+	decompressed, err = codec.Decompress(nil, compressed)
+	require.NoError(t, err)
+	assert.Equal(t, "again", string(decompressed))
+
+	_, err = codec.Decompress(nil, []byte("not gzip"))
+	assert.Error(t, err)
+	_, err = pglogrepl.GzipCodec(10)
+	assert.Error(t, err)
+}
@@ -0,0 +1,52 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ConnectFunc establishes a new replication connection. Taking a ConnectFunc instead of a
+// connection lets the application control how every connection is made: custom TLS
+// configurations, dialing through SSH tunnels or proxies, or authentication tokens that expire,
+// such as the IAM tokens of RDS or Cloud SQL, that must be refreshed at each reconnect.
+type ConnectFunc func(ctx context.Context) (*pgconn.PgConn, error)
+
+// ConnectWithConfig returns a ConnectFunc connecting with a copy of config, which must be created
+// by pgconn.ParseConfig and have the replication runtime parameter set. If beforeConnect is not
+// nil it is called with the copy before every connection and can modify it, for instance to set
+// a freshly generated password, a TLSConfig or a DialFunc; an error it returns is returned by the
+// ConnectFunc.
+func ConnectWithConfig(config *pgconn.Config, beforeConnect func(ctx context.Context, config *pgconn.Config) error) ConnectFunc {
+	return func(ctx context.Context) (*pgconn.PgConn, error) {
+		c := config.Copy()
+		if beforeConnect != nil {
+			if err := beforeConnect(ctx, c); err != nil {
+				return nil, fmt.Errorf("failed to prepare connection: %w", err)
+			}
+		}
+		return pgconn.ConnectConfig(ctx, c)
+	}
+}
+
+// ConnectReplicationStream is StartReplicationStream on a connection established with connect.
+// If the options have a ReconnectPolicy without a Connect function, connect is also used to
+// reconnect. The connection is closed if replication cannot be started.
+func ConnectReplicationStream(ctx context.Context, connect ConnectFunc, slotName string, startLSN LSN, options ReplicationStreamOptions) (*ReplicationStream, error) {
+	if options.Reconnect != nil && options.Reconnect.Connect == nil {
+		policy := *options.Reconnect
+		policy.Connect = connect
+		options.Reconnect = &policy
+	}
+	conn, err := connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	stream, err := StartReplicationStream(ctx, conn, slotName, startLSN, options)
+	if err != nil {
+		conn.Close(ctx)
+		return nil, err
+	}
+	return stream, nil
+}
@@ -0,0 +1,80 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectReplicationStream(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	conn2, ws2 := newFakeWalSender(t)
+	conns := []*pgconn.PgConn{conn, conn2}
+	connects := 0
+	connect := func(ctx context.Context) (*pgconn.PgConn, error) {
+		connects++
+		return conns[connects-1], nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	queries := ws.serveStartReplication()
+	stream, err := pglogrepl.ConnectReplicationStream(ctx, connect, slotName, 0x100, pglogrepl.ReplicationStreamOptions{
+		ProtoVersion: 1,
+		Reconnect:    &pglogrepl.ReconnectPolicy{InitialBackoff: time.Millisecond},
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(<-queries, "START_REPLICATION SLOT "+slotName+" LOGICAL 0/100"))
+	assert.Same(t, conn, stream.Conn())
+
+	// The connect function is used to reconnect.
+	queries2 := ws2.serveStartReplication()
+	go func() {
+		<-queries2
+		ws2.sendXLogData(0x200, beginMessageData(0x300, 42))
+	}()
+	ws.conn.Close()
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x200), rm.WALStart)
+	assert.Equal(t, 2, connects)
+	assert.Same(t, conn2, stream.Conn())
+
+	_, err = pglogrepl.ConnectReplicationStream(ctx, func(ctx context.Context) (*pgconn.PgConn, error) {
+		return nil, errors.New("connection refused")
+	}, slotName, 0x100, pglogrepl.ReplicationStreamOptions{})
+	assert.EqualError(t, err, "failed to connect: connection refused")
+}
+
+func TestConnectWithConfig(t *testing.T) {
+	config, err := pgconn.ParseConfig("host=127.0.0.1 port=1 user=u password=old replication=database connect_timeout=1")
+	require.NoError(t, err)
+
+	var passwords []string
+	connect := pglogrepl.ConnectWithConfig(config, func(ctx context.Context, c *pgconn.Config) error {
+		passwords = append(passwords, c.Password)
+		c.Password = "token"
+		if len(passwords) == 2 {
+			return errors.New("token expired")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = connect(ctx)
+	assert.Error(t, err)
+	_, err = connect(ctx)
+	assert.EqualError(t, err, "failed to prepare connection: token expired")
+	// Every connection starts from the original configuration.
+	assert.Equal(t, []string{"old", "old"}, passwords)
+	assert.Equal(t, "old", config.Password)
+}
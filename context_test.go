@@ -0,0 +1,155 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/pglogrepltest"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkGoroutines fails t if goroutines started by the test are still running once its cleanup
+// functions registered later have run.
+func checkGoroutines(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > baseline {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<20)
+				t.Fatalf("goroutines leaked:\n%s", buf[:runtime.Stack(buf, true)])
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+// assertPrompt asserts that f returns an error wrapping ctx.Err() soon after ctx is done.
+func assertPrompt(t *testing.T, ctx context.Context, f func() error) {
+	done := make(chan error, 1)
+	go func() { done <- f() }()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ctx.Err())
+	case <-time.After(2 * time.Second):
+		t.Fatal("did not return after the context was done")
+	}
+}
+
+func TestCancelNext(t *testing.T) {
+	checkGoroutines(t)
+	stream, _ := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{StandbyMessageTimeout: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	assertPrompt(t, ctx, func() error {
+		_, err := stream.Next(ctx)
+		return err
+	})
+}
+
+func TestCancelBlockedWrite(t *testing.T) {
+	checkGoroutines(t)
+	conn, _ := newFakeWalSender(t)
+
+	// The server does not read, so sending the large command blocks once the socket buffers are
+	// full.
+	options := pglogrepl.StartReplicationOptions{PluginArgs: []string{"arg '" + strings.Repeat("x", 64<<20) + "'"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	assertPrompt(t, ctx, func() error {
+		return pglogrepl.StartReplication(ctx, conn, slotName, 0, options)
+	})
+	assert.True(t, conn.IsClosed())
+}
+
+func TestCancelSendStandbyCopyDone(t *testing.T) {
+	checkGoroutines(t)
+	conn, _ := newFakeWalSender(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assertPrompt(t, ctx, func() error {
+		_, err := pglogrepl.SendStandbyCopyDone(ctx, conn)
+		return err
+	})
+}
+
+func TestCancelPipeline(t *testing.T) {
+	checkGoroutines(t)
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{StandbyMessageTimeout: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := pglogrepl.StartPipeline(ctx, stream, pglogrepl.PipelineOptions{QueueSize: 1, SpillDir: t.TempDir()})
+	ws.sendXLogData(0x200, []byte("queued"))
+	waitQueueLen(t, p, 1)
+	cancel()
+	assertPrompt(t, ctx, func() error {
+		for {
+			if _, err := p.Next(context.Background()); err != nil {
+				return err
+			}
+		}
+	})
+	assert.NoError(t, p.Close())
+}
+
+func TestCancelManager(t *testing.T) {
+	checkGoroutines(t)
+	srv, err := pglogrepltest.NewServer(pglogrepltest.ServerOptions{})
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := pglogrepl.NewManager(pglogrepl.ManagerOptions{RestartDelay: time.Hour})
+	require.NoError(t, m.Add("sub", func(ctx context.Context) (*pgconn.PgConn, error) {
+		return pgconn.Connect(ctx, srv.ConnString())
+	}, pglogrepl.SubscriptionOptions{
+		SlotName:        "slot",
+		PublicationName: "pub",
+		Handler:         func(context.Context, *pglogrepl.ReplicationMessage) error { return nil },
+	}))
+	m.Start(ctx)
+	require.NoError(t, srv.SendKeepalive(0x100, false))
+	require.Eventually(t, func() bool {
+		return m.Status()[0].State == pglogrepl.SubscriptionStreaming
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	assert.NoError(t, m.Stop())
+	assert.Equal(t, pglogrepl.SubscriptionStopped, m.Status()[0].State)
+}
+
+func TestCancelInitialCopy(t *testing.T) {
+	checkGoroutines(t)
+	replConn, replWS := newFakeWalSender(t)
+	conn, _ := newFakeWalSender(t)
+
+	replWS.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("slot_name")}, {Name: []byte("consistent_point")}, {Name: []byte("snapshot_name")}, {Name: []byte("output_plugin")}}},
+		&pgproto3.DataRow{Values: [][]byte{[]byte(slotName), []byte("0/1500"), []byte("00000003-00000002-1"), []byte("pgoutput")}},
+		&pgproto3.CommandComplete{CommandTag: []byte("CREATE_REPLICATION_SLOT")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	// The server does not answer the snapshot transaction.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assertPrompt(t, ctx, func() error {
+		_, err := pglogrepl.InitialCopy(ctx, replConn, conn, pglogrepl.InitialCopyOptions{
+			SlotName: slotName,
+			Tables:   []pglogrepl.InitialCopyTable{{Name: "public.users"}},
+			Writer: func(context.Context, pglogrepl.InitialCopyTable) (io.Writer, error) {
+				return io.Discard, nil
+			},
+		})
+		return err
+	})
+}
@@ -0,0 +1,151 @@
+package pglogrepl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DDLMessagePrefix is the prefix of the logical decoding messages carrying the DDL events emitted
+// by the event triggers installed by InstallDDLCapture.
+const DDLMessagePrefix = "pglogrepl.ddl"
+
+// DDL events.
+const (
+	// DDLCommandEnd is the event of a command creating or altering objects.
+	DDLCommandEnd = "ddl_command_end"
+	// DDLSQLDrop is the event of a command dropping objects.
+	DDLSQLDrop = "sql_drop"
+)
+
+// DDLCaptureOptions configures the DDL capture installed by InstallDDLCapture.
+type DDLCaptureOptions struct {
+	// Schema is the schema of the function of the event triggers. It defaults to public.
+	Schema string
+}
+
+// InstallSQL returns the SQL commands InstallDDLCapture executes, so that they can be part of the
+// migrations of a database instead.
+func (o DDLCaptureOptions) InstallSQL() string {
+	function := o.function()
+	return `CREATE OR REPLACE FUNCTION ` + function + `() RETURNS event_trigger
+LANGUAGE plpgsql SECURITY DEFINER SET search_path = pg_catalog AS $pglogrepl$
+DECLARE
+	objects json;
+BEGIN
+	IF TG_EVENT = 'sql_drop' THEN
+		SELECT json_agg(json_build_object('object_type', object_type, 'schema_name', schema_name, 'object_identity', object_identity))
+		INTO objects FROM pg_event_trigger_dropped_objects() WHERE original;
+	ELSE
+		SELECT json_agg(json_build_object('object_type', object_type, 'schema_name', schema_name, 'object_identity', object_identity))
+		INTO objects FROM pg_event_trigger_ddl_commands();
+	END IF;
+	IF objects IS NULL THEN
+		RETURN;
+	END IF;
+	PERFORM pg_logical_emit_message(true, ` + quoteLiteral(DDLMessagePrefix) + `,
+		json_build_object('event', TG_EVENT, 'command_tag', TG_TAG, 'query', current_query(), 'objects', objects)::text);
+END
+$pglogrepl$;
+DROP EVENT TRIGGER IF EXISTS pglogrepl_ddl_command_end;
+CREATE EVENT TRIGGER pglogrepl_ddl_command_end ON ddl_command_end EXECUTE FUNCTION ` + function + `();
+DROP EVENT TRIGGER IF EXISTS pglogrepl_ddl_sql_drop;
+CREATE EVENT TRIGGER pglogrepl_ddl_sql_drop ON sql_drop EXECUTE FUNCTION ` + function + `();`
+}
+
+// UninstallSQL returns the SQL commands UninstallDDLCapture executes.
+func (o DDLCaptureOptions) UninstallSQL() string {
+	return `DROP EVENT TRIGGER IF EXISTS pglogrepl_ddl_command_end;
+DROP EVENT TRIGGER IF EXISTS pglogrepl_ddl_sql_drop;
+DROP FUNCTION IF EXISTS ` + o.function() + `();`
+}
+
+func (o DDLCaptureOptions) function() string {
+	schema := o.Schema
+	if schema == "" {
+		schema = "public"
+	}
+	return quoteIdentifier(schema) + ".pglogrepl_ddl_capture"
+}
+
+// InstallDDLCapture installs event triggers shipping the DDL commands executed in the database of
+// conn through the replication stream: at the end of every command creating, altering or dropping
+// objects they emit a transactional logical decoding message with prefix DDLMessagePrefix, which
+// ParseDDLEvent decodes. The messages are only sent to subscriptions started with the pgoutput
+// messages option, see PgoutputOptions.Messages.
+//
+// Event triggers require superuser privileges, and the triggers are created with EXECUTE
+// FUNCTION, which requires PostgreSQL 11. The function of the triggers is a security definer so
+// that the commands of users without the privilege to emit logical decoding messages are
+// captured too. Installing again replaces the triggers.
+func InstallDDLCapture(ctx context.Context, conn *pgconn.PgConn, options DDLCaptureOptions) error {
+	if _, err := conn.Exec(ctx, options.InstallSQL()).ReadAll(); err != nil {
+		return fmt.Errorf("failed to install DDL capture: %w", err)
+	}
+	return nil
+}
+
+// UninstallDDLCapture drops the event triggers installed by InstallDDLCapture and their function.
+func UninstallDDLCapture(ctx context.Context, conn *pgconn.PgConn, options DDLCaptureOptions) error {
+	if _, err := conn.Exec(ctx, options.UninstallSQL()).ReadAll(); err != nil {
+		return fmt.Errorf("failed to uninstall DDL capture: %w", err)
+	}
+	return nil
+}
+
+// DDLEvent is a DDL command captured by the event triggers installed by InstallDDLCapture.
+//
+// A command dropping objects as part of an alteration, such as ALTER TABLE DROP COLUMN, produces a
+// DDLSQLDrop event followed by a DDLCommandEnd event.
+type DDLEvent struct {
+	// LSN is the position of the message carrying the event.
+	LSN LSN `json:"-"`
+	// Event is DDLCommandEnd or DDLSQLDrop.
+	Event string `json:"event"`
+	// CommandTag is the tag of the command, such as "CREATE TABLE".
+	CommandTag string `json:"command_tag"`
+	// Query is the query that executed the command. It holds every command of a query made of
+	// several.
+	Query string `json:"query"`
+	// Objects lists the objects created, altered or dropped by the command.
+	Objects []DDLObject `json:"objects"`
+}
+
+// DDLObject is an object affected by a DDL command.
+type DDLObject struct {
+	// ObjectType is the type of the object, such as "table" or "index".
+	ObjectType string `json:"object_type"`
+	// SchemaName is the schema of the object, empty if it does not belong to a schema.
+	SchemaName string `json:"schema_name"`
+	// ObjectIdentity is the schema qualified identity of the object, such as "public.t".
+	ObjectIdentity string `json:"object_identity"`
+}
+
+// ParseDDLEvent decodes the DDL event carried by msg, a *LogicalDecodingMessage or a
+// *LogicalDecodingMessageV2 with prefix DDLMessagePrefix. It returns nil without error for any
+// other message, so that it can be called on every message of the stream.
+func ParseDDLEvent(msg Message) (*DDLEvent, error) {
+	var ldm *LogicalDecodingMessage
+	switch msg := msg.(type) {
+	case *LogicalDecodingMessage:
+		ldm = msg
+	case *LogicalDecodingMessageV2:
+		ldm = &msg.LogicalDecodingMessage
+	default:
+		return nil, nil
+	}
+	if ldm.Prefix != DDLMessagePrefix {
+		return nil, nil
+	}
+
+	event := &DDLEvent{LSN: ldm.LSN}
+	if err := json.Unmarshal(ldm.Content, event); err != nil {
+		return nil, fmt.Errorf("failed to parse DDL event: %w", err)
+	}
+	if event.Event != DDLCommandEnd && event.Event != DDLSQLDrop {
+		return nil, fmt.Errorf("failed to parse DDL event: unknown event %q", event.Event)
+	}
+	return event, nil
+}
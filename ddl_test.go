@@ -0,0 +1,63 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstallDDLCapture(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	options := pglogrepl.DDLCaptureOptions{Schema: "cdc"}
+	assert.Contains(t, options.InstallSQL(), `CREATE OR REPLACE FUNCTION "cdc".pglogrepl_ddl_capture() RETURNS event_trigger`)
+	assert.Contains(t, options.InstallSQL(), `pg_logical_emit_message(true, 'pglogrepl.ddl',`)
+	assert.Contains(t, options.InstallSQL(), `CREATE EVENT TRIGGER pglogrepl_ddl_sql_drop ON sql_drop EXECUTE FUNCTION "cdc".pglogrepl_ddl_capture();`)
+	assert.Contains(t, pglogrepl.DDLCaptureOptions{}.UninstallSQL(), `DROP FUNCTION IF EXISTS "public".pglogrepl_ddl_capture();`)
+
+	queries := ws.serveQuery(commandCompleteResponse("CREATE EVENT TRIGGER"))
+	require.NoError(t, pglogrepl.InstallDDLCapture(ctx, conn, options))
+	assert.Equal(t, options.InstallSQL(), <-queries)
+
+	queries = ws.serveQuery(commandCompleteResponse("DROP FUNCTION"))
+	require.NoError(t, pglogrepl.UninstallDDLCapture(ctx, conn, options))
+	assert.Equal(t, options.UninstallSQL(), <-queries)
+}
+
+func TestParseDDLEvent(t *testing.T) {
+	content := `{"event" : "ddl_command_end", "command_tag" : "ALTER TABLE", "query" : "ALTER TABLE t ADD c int", ` +
+		`"objects" : [{"object_type" : "table", "schema_name" : "public", "object_identity" : "public.t"}]}`
+	msg := &pglogrepl.LogicalDecodingMessage{LSN: 0x100, Transactional: true, Prefix: pglogrepl.DDLMessagePrefix, Content: []byte(content)}
+
+	event, err := pglogrepl.ParseDDLEvent(msg)
+	require.NoError(t, err)
+	assert.Equal(t, &pglogrepl.DDLEvent{
+		LSN:        0x100,
+		Event:      pglogrepl.DDLCommandEnd,
+		CommandTag: "ALTER TABLE",
+		Query:      "ALTER TABLE t ADD c int",
+		Objects:    []pglogrepl.DDLObject{{ObjectType: "table", SchemaName: "public", ObjectIdentity: "public.t"}},
+	}, event)
+
+	v2, err := pglogrepl.ParseDDLEvent(&pglogrepl.LogicalDecodingMessageV2{LogicalDecodingMessage: *msg})
+	require.NoError(t, err)
+	assert.Equal(t, event, v2)
+
+	event, err = pglogrepl.ParseDDLEvent(&pglogrepl.LogicalDecodingMessage{Prefix: "other", Content: []byte("{}")})
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+	event, err = pglogrepl.ParseDDLEvent(&pglogrepl.BeginMessage{})
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+
+	_, err = pglogrepl.ParseDDLEvent(&pglogrepl.LogicalDecodingMessage{Prefix: pglogrepl.DDLMessagePrefix, Content: []byte("{")})
+	assert.Error(t, err)
+	_, err = pglogrepl.ParseDDLEvent(&pglogrepl.LogicalDecodingMessage{Prefix: pglogrepl.DDLMessagePrefix, Content: []byte(`{"event": "login"}`)})
+	assert.Error(t, err)
+}
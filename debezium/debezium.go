@@ -0,0 +1,268 @@
+// Package debezium converts decoded pgoutput messages into change events in the JSON format of
+// the Debezium PostgreSQL connector, so that consumers written for Debezium can process the
+// changes of a pglogrepl client.
+//
+// The events follow the Debezium envelope with schemas disabled: the value holds the before and
+// after state of the row, the source metadata, the operation and a timestamp, and the key holds
+// the replica identity columns. Column values are the JSON encoding of the values decoded with the
+// type map, which differs from the Debezium logical type encoding for some types such as
+// timestamps and numerics.
+package debezium
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Debezium operation codes.
+const (
+	OpCreate   = "c"
+	OpUpdate   = "u"
+	OpDelete   = "d"
+	OpTruncate = "t"
+)
+
+// DefaultUnavailableValuePlaceholder is the value Debezium uses for unchanged TOAST columns.
+const DefaultUnavailableValuePlaceholder = "__debezium_unavailable_value"
+
+// Source is the source metadata of a change event.
+type Source struct {
+	Version   string `json:"version"`
+	Connector string `json:"connector"`
+	Name      string `json:"name"`
+	TsMs      int64  `json:"ts_ms"`
+	Snapshot  string `json:"snapshot"`
+	DB        string `json:"db"`
+	Schema    string `json:"schema"`
+	Table     string `json:"table"`
+	TxID      uint32 `json:"txId"`
+	LSN       uint64 `json:"lsn"`
+	Xmin      *int64 `json:"xmin"`
+}
+
+// Envelope is the value of a change event.
+type Envelope struct {
+	Before map[string]interface{} `json:"before"`
+	After  map[string]interface{} `json:"after"`
+	Source Source                 `json:"source"`
+	Op     string                 `json:"op"`
+	TsMs   int64                  `json:"ts_ms"`
+}
+
+// Event is a change event.
+type Event struct {
+	// Topic is the topic Debezium would publish the event to, <server name>.<schema>.<table>.
+	Topic string
+	// Key holds the replica identity columns of the row. It is nil for truncate events and for
+	// relations without replica identity.
+	Key   map[string]interface{}
+	Value Envelope
+}
+
+// KeyJSON returns the JSON encoding of the event key, or nil if the event has no key.
+func (e *Event) KeyJSON() ([]byte, error) {
+	if e.Key == nil {
+		return nil, nil
+	}
+	return json.Marshal(e.Key)
+}
+
+// ValueJSON returns the JSON encoding of the event value.
+func (e *Event) ValueJSON() ([]byte, error) {
+	return json.Marshal(e.Value)
+}
+
+// EncoderOptions configures an Encoder.
+type EncoderOptions struct {
+	// ServerName is the logical server name used as topic prefix and source name.
+	ServerName string
+	// Database is the name of the replicated database.
+	Database string
+	// TypeMap decodes the column values. If it is nil pgtype.NewMap() is used.
+	TypeMap *pgtype.Map
+	// UnavailableValuePlaceholder is the value of unchanged TOAST columns. If it is empty
+	// DefaultUnavailableValuePlaceholder is used.
+	UnavailableValuePlaceholder string
+	// Now returns the processing time of the events. If it is nil time.Now is used.
+	Now func() time.Time
+}
+
+// Encoder converts the messages of a replication stream into change events. Every message of the
+// stream must be passed to Encode in order, as the encoder tracks the relations and the
+// transaction in progress.
+type Encoder struct {
+	options   EncoderOptions
+	relations *pglogrepl.RelationCache
+
+	// xid and commitTime are those of the transaction in progress.
+	xid        uint32
+	commitTime time.Time
+	inStream   bool
+}
+
+// NewEncoder returns a new Encoder.
+func NewEncoder(options EncoderOptions) *Encoder {
+	if options.TypeMap == nil {
+		options.TypeMap = pgtype.NewMap()
+	}
+	if options.UnavailableValuePlaceholder == "" {
+		options.UnavailableValuePlaceholder = DefaultUnavailableValuePlaceholder
+	}
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+	return &Encoder{options: options, relations: pglogrepl.NewRelationCache(nil)}
+}
+
+// Encode returns the change events for msg, which was received at lsn. It returns no events for
+// messages that do not change rows. A truncate message returns an event for every truncated
+// relation.
+func (e *Encoder) Encode(lsn pglogrepl.LSN, msg pglogrepl.Message) ([]*Event, error) {
+	xid := e.xid
+	switch m := msg.(type) {
+	case *pglogrepl.InsertMessageV2:
+		xid, msg = e.streamXid(m.Xid), &m.InsertMessage
+	case *pglogrepl.UpdateMessageV2:
+		xid, msg = e.streamXid(m.Xid), &m.UpdateMessage
+	case *pglogrepl.DeleteMessageV2:
+		xid, msg = e.streamXid(m.Xid), &m.DeleteMessage
+	case *pglogrepl.TruncateMessageV2:
+		xid, msg = e.streamXid(m.Xid), &m.TruncateMessage
+	}
+
+	switch msg := msg.(type) {
+	case *pglogrepl.RelationMessage, *pglogrepl.RelationMessageV2:
+		e.relations.Update(msg)
+	case *pglogrepl.BeginMessage:
+		e.xid = msg.Xid
+		e.commitTime = msg.CommitTime
+	case *pglogrepl.StreamStartMessageV2:
+		e.inStream = true
+	case *pglogrepl.StreamStopMessageV2:
+		e.inStream = false
+
+	case *pglogrepl.InsertMessage:
+		rel, err := e.relation(msg.RelationID)
+		if err != nil {
+			return nil, err
+		}
+		after, err := e.row(rel, msg.Tuple)
+		if err != nil {
+			return nil, err
+		}
+		return []*Event{e.event(rel, lsn, xid, OpCreate, nil, after, keyOf(rel, after))}, nil
+	case *pglogrepl.UpdateMessage:
+		rel, err := e.relation(msg.RelationID)
+		if err != nil {
+			return nil, err
+		}
+		after, err := e.row(rel, msg.NewTuple)
+		if err != nil {
+			return nil, err
+		}
+		var before map[string]interface{}
+		if msg.OldTuple != nil {
+			if before, err = e.row(rel, msg.OldTuple); err != nil {
+				return nil, err
+			}
+		}
+		return []*Event{e.event(rel, lsn, xid, OpUpdate, before, after, keyOf(rel, after))}, nil
+	case *pglogrepl.DeleteMessage:
+		rel, err := e.relation(msg.RelationID)
+		if err != nil {
+			return nil, err
+		}
+		before, err := e.row(rel, msg.OldTuple)
+		if err != nil {
+			return nil, err
+		}
+		return []*Event{e.event(rel, lsn, xid, OpDelete, before, nil, keyOf(rel, before))}, nil
+	case *pglogrepl.TruncateMessage:
+		events := make([]*Event, 0, len(msg.RelationIDs))
+		for _, relationID := range msg.RelationIDs {
+			rel, err := e.relation(relationID)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, e.event(rel, lsn, xid, OpTruncate, nil, nil, nil))
+		}
+		return events, nil
+	}
+	return nil, nil
+}
+
+func (e *Encoder) streamXid(xid uint32) uint32 {
+	if e.inStream {
+		return xid
+	}
+	return e.xid
+}
+
+func (e *Encoder) relation(relationID uint32) (*pglogrepl.RelationMessage, error) {
+	rel, ok := e.relations.Relation(relationID)
+	if !ok {
+		return nil, fmt.Errorf("unknown relation ID %d", relationID)
+	}
+	return rel, nil
+}
+
+func (e *Encoder) row(rel *pglogrepl.RelationMessage, tuple *pglogrepl.TupleData) (map[string]interface{}, error) {
+	row, err := pglogrepl.DecodeTuple(rel, tuple, e.options.TypeMap)
+	if err != nil {
+		return nil, err
+	}
+	for i, col := range tuple.Columns {
+		if col.DataType == pglogrepl.TupleDataTypeToast {
+			row[rel.Columns[i].Name] = e.options.UnavailableValuePlaceholder
+		}
+	}
+	return row, nil
+}
+
+func (e *Encoder) event(rel *pglogrepl.RelationMessage, lsn pglogrepl.LSN, xid uint32, op string, before, after, key map[string]interface{}) *Event {
+	source := Source{
+		Version:   "pglogrepl",
+		Connector: "postgresql",
+		Name:      e.options.ServerName,
+		Snapshot:  "false",
+		DB:        e.options.Database,
+		Schema:    rel.Namespace,
+		Table:     rel.RelationName,
+		TxID:      xid,
+		LSN:       uint64(lsn),
+	}
+	// The commit time of a streamed transaction is not known until it is committed.
+	if !e.inStream && !e.commitTime.IsZero() {
+		source.TsMs = e.commitTime.UnixMilli()
+	}
+	return &Event{
+		Topic: e.options.ServerName + "." + rel.Namespace + "." + rel.RelationName,
+		Key:   key,
+		Value: Envelope{
+			Before: before,
+			After:  after,
+			Source: source,
+			Op:     op,
+			TsMs:   e.options.Now().UnixMilli(),
+		},
+	}
+}
+
+// keyOf returns the replica identity columns of row.
+func keyOf(rel *pglogrepl.RelationMessage, row map[string]interface{}) map[string]interface{} {
+	var key map[string]interface{}
+	for _, col := range rel.Columns {
+		if col.Flags&1 == 0 {
+			continue
+		}
+		if key == nil {
+			key = map[string]interface{}{}
+		}
+		key[col.Name] = row[col.Name]
+	}
+	return key
+}
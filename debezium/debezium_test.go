@@ -0,0 +1,146 @@
+package debezium_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/debezium"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func text(s string) *pglogrepl.TupleDataColumn {
+	return &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(s)), Data: []byte(s)}
+}
+
+func tuple(cols ...*pglogrepl.TupleDataColumn) *pglogrepl.TupleData {
+	return &pglogrepl.TupleData{ColumnNum: uint16(len(cols)), Columns: cols}
+}
+
+func newTestEncoder(t *testing.T) *debezium.Encoder {
+	now := time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)
+	e := debezium.NewEncoder(debezium.EncoderOptions{
+		ServerName: "server",
+		Database:   "db",
+		Now:        func() time.Time { return now },
+	})
+	for _, msg := range []pglogrepl.Message{
+		&pglogrepl.RelationMessage{
+			RelationID:   1,
+			Namespace:    "public",
+			RelationName: "t",
+			Columns: []*pglogrepl.RelationMessageColumn{
+				{Flags: 1, Name: "id", DataType: 23},
+				{Name: "name", DataType: 25},
+			},
+		},
+		&pglogrepl.BeginMessage{Xid: 42, CommitTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+	} {
+		events, err := e.Encode(0x100, msg)
+		require.NoError(t, err)
+		assert.Empty(t, events)
+	}
+	return e
+}
+
+func TestEncoderInsert(t *testing.T) {
+	e := newTestEncoder(t)
+	events, err := e.Encode(0x200, &pglogrepl.InsertMessage{RelationID: 1, Tuple: tuple(text("1"), text("a"))})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "server.public.t", events[0].Topic)
+
+	key, err := events[0].KeyJSON()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id": 1}`, string(key))
+
+	value, err := events[0].ValueJSON()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"before": null,
+		"after": {"id": 1, "name": "a"},
+		"source": {
+			"version": "pglogrepl",
+			"connector": "postgresql",
+			"name": "server",
+			"ts_ms": 1704164645000,
+			"snapshot": "false",
+			"db": "db",
+			"schema": "public",
+			"table": "t",
+			"txId": 42,
+			"lsn": 512,
+			"xmin": null
+		},
+		"op": "c",
+		"ts_ms": 1704164646000
+	}`, string(value))
+}
+
+func TestEncoderUpdateAndDelete(t *testing.T) {
+	e := newTestEncoder(t)
+	events, err := e.Encode(0x200, &pglogrepl.UpdateMessage{
+		RelationID:   1,
+		OldTupleType: pglogrepl.UpdateMessageTupleTypeKey,
+		OldTuple:     tuple(text("1"), &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeNull}),
+		NewTuple:     tuple(text("2"), &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeToast}),
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, debezium.OpUpdate, events[0].Value.Op)
+	assert.Equal(t, map[string]interface{}{"id": int32(1), "name": nil}, events[0].Value.Before)
+	assert.Equal(t, map[string]interface{}{"id": int32(2), "name": debezium.DefaultUnavailableValuePlaceholder}, events[0].Value.After)
+	assert.Equal(t, map[string]interface{}{"id": int32(2)}, events[0].Key)
+
+	events, err = e.Encode(0x300, &pglogrepl.DeleteMessageV2{DeleteMessage: pglogrepl.DeleteMessage{
+		RelationID:   1,
+		OldTupleType: pglogrepl.UpdateMessageTupleTypeKey,
+		OldTuple:     tuple(text("2"), &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeNull}),
+	}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, debezium.OpDelete, events[0].Value.Op)
+	assert.Nil(t, events[0].Value.After)
+	assert.Equal(t, map[string]interface{}{"id": int32(2)}, events[0].Key)
+	assert.Equal(t, uint32(42), events[0].Value.Source.TxID)
+}
+
+func TestEncoderTruncate(t *testing.T) {
+	e := newTestEncoder(t)
+	events, err := e.Encode(0x200, &pglogrepl.TruncateMessage{RelationNum: 1, RelationIDs: []uint32{1}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, debezium.OpTruncate, events[0].Value.Op)
+	assert.Nil(t, events[0].Key)
+
+	value, err := events[0].ValueJSON()
+	require.NoError(t, err)
+	var envelope map[string]interface{}
+	require.NoError(t, json.Unmarshal(value, &envelope))
+	assert.Nil(t, envelope["before"])
+	assert.Nil(t, envelope["after"])
+
+	_, err = e.Encode(0x200, &pglogrepl.TruncateMessage{RelationNum: 1, RelationIDs: []uint32{2}})
+	assert.Error(t, err)
+}
+
+func TestEncoderStreamedChange(t *testing.T) {
+	e := newTestEncoder(t)
+	for _, msg := range []pglogrepl.Message{
+		&pglogrepl.CommitMessage{},
+		&pglogrepl.StreamStartMessageV2{Xid: 50},
+	} {
+		_, err := e.Encode(0x200, msg)
+		require.NoError(t, err)
+	}
+	events, err := e.Encode(0x300, &pglogrepl.InsertMessageV2{
+		InStreamMessageV2WithXid: pglogrepl.InStreamMessageV2WithXid{Xid: 51},
+		InsertMessage:            pglogrepl.InsertMessage{RelationID: 1, Tuple: tuple(text("1"), text("a"))},
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, uint32(51), events[0].Value.Source.TxID)
+	assert.Zero(t, events[0].Value.Source.TsMs)
+}
@@ -0,0 +1,162 @@
+package pglogrepl
+
+import (
+	"fmt"
+)
+
+// Decoder decodes pgoutput messages while avoiding allocations for the most frequent ones.
+//
+// Begin, commit, insert, update and delete messages are decoded into message structs owned by
+// the Decoder, which are reused by the next call to Decode, and the column data of their tuples
+// are sub-slices of the WAL data instead of copies. A decoded message is therefore only valid
+// until the next call to Reset and as long as the WAL data is not modified; pgconn reuses the
+// buffer of a received message when the next message is received. Messages that are needed for
+// longer, such as relation messages, are allocated as by Parse.
+//
+// A Decoder tracks whether it is inside a streamed transaction, so it must be given every message
+// of the stream in order. It is not safe for concurrent use.
+type Decoder struct {
+	protoVersion int
+	inStream     bool
+	walData      []byte
+	// alloc makes the decoder allocate new messages instead of reusing its own, and aliasMin is
+	// the size from which column data are sub-slices of the WAL data rather than copies.
+	alloc    bool
+	aliasMin int
+
+	begin    BeginMessage
+	commit   CommitMessage
+	insert   InsertMessageV2
+	update   UpdateMessageV2
+	delete   DeleteMessageV2
+	oldTuple TupleData
+	newTuple TupleData
+}
+
+// NewDecoder returns a Decoder for the pgoutput protocol version protoVersion. Messages are
+// returned with the types Parse or ParseV2, ParseV3 and ParseV4 return for that version.
+func NewDecoder(protoVersion int) (*Decoder, error) {
+	if protoVersion < 1 || protoVersion > 4 {
+		return nil, fmt.Errorf("unsupported pgoutput protocol version %d", protoVersion)
+	}
+	return &Decoder{protoVersion: protoVersion}, nil
+}
+
+// Reset sets the WAL data of the next message to decode.
+func (d *Decoder) Reset(walData []byte) {
+	d.walData = walData
+}
+
+// InStream reports whether the decoder is inside a streamed transaction.
+func (d *Decoder) InStream() bool {
+	return d.inStream
+}
+
+// Decode decodes the WAL data given to Reset.
+func (d *Decoder) Decode() (Message, error) {
+	data := d.walData
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no WAL data to decode")
+	}
+	src := data[1:]
+
+	var err error
+	switch MessageType(data[0]) {
+	case MessageTypeBegin:
+		if d.alloc {
+			break
+		}
+		if err = d.begin.Decode(src); err != nil {
+			return nil, messageError(err, data)
+		}
+		return &d.begin, nil
+	case MessageTypeCommit:
+		if d.alloc {
+			break
+		}
+		if err = d.commit.Decode(src); err != nil {
+			return nil, messageError(err, data)
+		}
+		return &d.commit, nil
+	case MessageTypeInsert:
+		insert, tuple := &d.insert, &d.newTuple
+		if d.alloc {
+			insert, tuple = new(InsertMessageV2), new(TupleData)
+		}
+		if src, err = d.readXid(src, &insert.InStreamMessageV2WithXid, "InsertMessageV2", 11); err != nil {
+			return nil, messageError(err, data)
+		}
+		if err = insert.InsertMessage.decode(src, tuple, d.aliasMin); err != nil {
+			return nil, messageError(nestedError(err, "", "", len(data)-1-len(src)), data)
+		}
+		if d.protoVersion == 1 {
+			return &insert.InsertMessage, nil
+		}
+		return insert, nil
+	case MessageTypeUpdate:
+		update, oldTuple, newTuple := &d.update, &d.oldTuple, &d.newTuple
+		if d.alloc {
+			update, oldTuple, newTuple = new(UpdateMessageV2), new(TupleData), new(TupleData)
+		}
+		if src, err = d.readXid(src, &update.InStreamMessageV2WithXid, "UpdateMessageV2", 10); err != nil {
+			return nil, messageError(err, data)
+		}
+		if err = update.UpdateMessage.decode(src, oldTuple, newTuple, d.aliasMin); err != nil {
+			return nil, messageError(nestedError(err, "", "", len(data)-1-len(src)), data)
+		}
+		if d.protoVersion == 1 {
+			return &update.UpdateMessage, nil
+		}
+		return update, nil
+	case MessageTypeDelete:
+		del, oldTuple := &d.delete, &d.oldTuple
+		if d.alloc {
+			del, oldTuple = new(DeleteMessageV2), new(TupleData)
+		}
+		if src, err = d.readXid(src, &del.InStreamMessageV2WithXid, "DeleteMessageV2", 8); err != nil {
+			return nil, messageError(err, data)
+		}
+		if err = del.DeleteMessage.decode(src, oldTuple, d.aliasMin); err != nil {
+			return nil, messageError(nestedError(err, "", "", len(data)-1-len(src)), data)
+		}
+		if d.protoVersion == 1 {
+			return &del.DeleteMessage, nil
+		}
+		return del, nil
+	}
+
+	var msg Message
+	switch d.protoVersion {
+	case 1:
+		msg, err = Parse(data)
+	case 2:
+		msg, err = ParseV2(data, d.inStream)
+	case 3:
+		msg, err = ParseV3(data, d.inStream)
+	default:
+		msg, err = ParseV4(data, d.inStream)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch msg.(type) {
+	case *StreamStartMessageV2:
+		d.inStream = true
+	case *StreamStopMessageV2:
+		d.inStream = false
+	}
+	return msg, nil
+}
+
+// readXid reads the Xid of a change inside a streamed transaction, whose V2 message name must have
+// at least minLen bytes.
+func (d *Decoder) readXid(src []byte, xid *InStreamMessageV2WithXid, name string, minLen int) ([]byte, error) {
+	xid.Xid = 0
+	if d.protoVersion == 1 || !d.inStream {
+		return src, nil
+	}
+	if len(src) < minLen {
+		return nil, truncatedError(name, minLen, len(src))
+	}
+	return readXidAndAdvance(src, xid, true), nil
+}
@@ -0,0 +1,109 @@
+package pglogrepl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDecoderSuite(t *testing.T) {
+	suite.Run(t, new(decoderSuite))
+}
+
+type decoderSuite struct {
+	messageSuite
+}
+
+func (s *decoderSuite) newDecoder(protoVersion int) *Decoder {
+	d, err := NewDecoder(protoVersion)
+	s.NoError(err)
+	return d
+}
+
+func (s *decoderSuite) decode(d *Decoder, msg []byte) Message {
+	d.Reset(msg)
+	m, err := d.Decode()
+	s.NoError(err)
+	return m
+}
+
+func (s *decoderSuite) TestV1() {
+	d := s.newDecoder(1)
+
+	insert, expectedInsert := s.createInsertTestData()
+	s.Equal(expectedInsert, s.decode(d, insert))
+
+	update, expectedUpdate := s.createUpdateTestDataTypeO()
+	s.Equal(expectedUpdate, s.decode(d, update))
+	update, expectedUpdate = s.createUpdateTestDataWithoutOldTuple()
+	s.Equal(expectedUpdate, s.decode(d, update))
+
+	del, expectedDelete := s.createDeleteTestDataTypeK()
+	s.Equal(expectedDelete, s.decode(d, del))
+
+	relation, expectedRelation := s.createRelationTestData()
+	s.Equal(expectedRelation, s.decode(d, relation))
+}
+
+func (s *decoderSuite) TestV2Stream() {
+	d := s.newDecoder(2)
+
+	insert, expectedInsert := s.createInsertTestData()
+	m := s.decode(d, insert)
+	s.Equal(&InsertMessageV2{InsertMessage: *expectedInsert}, m)
+
+	start := []byte{'S', 0, 0, 0, 1, 1}
+	s.decode(d, start)
+	s.True(d.InStream())
+
+	msg, xid := s.insertXid(insert)
+	s.Equal(&InsertMessageV2{InsertMessage: *expectedInsert, InStreamMessageV2WithXid: InStreamMessageV2WithXid{Xid: xid}}, s.decode(d, msg))
+
+	update, expectedUpdate := s.createUpdateTestDataTypeK()
+	msg, xid = s.insertXid(update)
+	s.Equal(&UpdateMessageV2{UpdateMessage: *expectedUpdate, InStreamMessageV2WithXid: InStreamMessageV2WithXid{Xid: xid}}, s.decode(d, msg))
+
+	del, expectedDelete := s.createDeleteTestDataTypeO()
+	msg, xid = s.insertXid(del)
+	s.Equal(&DeleteMessageV2{DeleteMessage: *expectedDelete, InStreamMessageV2WithXid: InStreamMessageV2WithXid{Xid: xid}}, s.decode(d, msg))
+
+	s.decode(d, []byte{'E'})
+	s.False(d.InStream())
+	s.Equal(&DeleteMessageV2{DeleteMessage: *expectedDelete}, s.decode(d, del))
+}
+
+func (s *decoderSuite) TestAliasesWALData() {
+	d := s.newDecoder(1)
+
+	insert, _ := s.createInsertTestData()
+	m := s.decode(d, insert).(*InsertMessage)
+	data := m.Tuple.Columns[0].Data
+	for i := range data {
+		data[i] = 'x'
+	}
+	s.Contains(string(insert), string(data))
+
+	// The message reuses the columns it decoded before, so no allocation is needed.
+	allocs := testing.AllocsPerRun(100, func() {
+		d.Reset(insert)
+		if _, err := d.Decode(); err != nil {
+			panic(err)
+		}
+	})
+	s.Equal(float64(0), allocs)
+}
+
+func (s *decoderSuite) TestErrors() {
+	_, err := NewDecoder(5)
+	s.Error(err)
+
+	d := s.newDecoder(1)
+	d.Reset(nil)
+	_, err = d.Decode()
+	s.Error(err)
+
+	insert, _ := s.createInsertTestData()
+	d.Reset(insert[:len(insert)-1])
+	_, err = d.Decode()
+	s.Error(err)
+}
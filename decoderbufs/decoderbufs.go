@@ -0,0 +1,347 @@
+// Package decoderbufs parses the output of the decoderbufs logical decoding output plugin, which
+// Debezium uses as an alternative to pgoutput. Every output message is a RowMessage of the
+// pg_logicaldec.proto schema of the plugin, encoded in protocol buffers.
+package decoderbufs
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/internal/protowire"
+)
+
+// Op is the operation of a RowMessage.
+type Op int32
+
+// List of operations.
+const (
+	OpUnknown Op = -1
+	OpInsert  Op = 0
+	OpUpdate  Op = 1
+	OpDelete  Op = 2
+	OpBegin   Op = 3
+	OpCommit  Op = 4
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpUnknown:
+		return "UNKNOWN"
+	case OpInsert:
+		return "INSERT"
+	case OpUpdate:
+		return "UPDATE"
+	case OpDelete:
+		return "DELETE"
+	case OpBegin:
+		return "BEGIN"
+	case OpCommit:
+		return "COMMIT"
+	}
+	return fmt.Sprintf("Op(%d)", int32(op))
+}
+
+// Point is the value of a point column.
+type Point struct {
+	X float64
+	Y float64
+}
+
+// Datum is a column of a row.
+type Datum struct {
+	ColumnName string
+	// ColumnType is the type OID of the column.
+	ColumnType int64
+	// Value is an int32, int64, float32, float64, bool, string, []byte or Point value, or nil for
+	// NULL and when Missing is set.
+	Value interface{}
+	// Missing reports that the value is a TOASTed value that was not changed and therefore not
+	// included in the output.
+	Missing bool
+}
+
+// TypeInfo describes the type of a column of the new row.
+type TypeInfo struct {
+	// Modifier is the type name with its modifier, such as "character varying(255)".
+	Modifier      string
+	ValueOptional bool
+}
+
+// RowMessage is a transaction boundary or a row change.
+type RowMessage struct {
+	TransactionID uint32
+	// CommitTime is the commit time of the transaction.
+	CommitTime time.Time
+	// Table is the quoted, schema qualified name of the table, such as `public.t` or `"S"."T"`.
+	Table       string
+	Op          Op
+	NewTuple    []Datum
+	OldTuple    []Datum
+	NewTypeInfo []TypeInfo
+}
+
+// Field numbers of RowMessage, DatumMessage, Point and TypeInfo.
+const (
+	rowTransactionID = 1
+	rowCommitTime    = 2
+	rowTable         = 3
+	rowOp            = 4
+	rowNewTuple      = 5
+	rowOldTuple      = 6
+	rowNewTypeInfo   = 7
+
+	datumColumnName = 1
+	datumColumnType = 2
+	datumInt32      = 3
+	datumInt64      = 4
+	datumFloat      = 5
+	datumDouble     = 6
+	datumBool       = 7
+	datumString     = 8
+	datumBytes      = 9
+	datumPoint      = 10
+	datumMissing    = 11
+)
+
+// Parse parses a RowMessage as received in the WALData of a XLogData message.
+func Parse(data []byte) (*RowMessage, error) {
+	m := &RowMessage{Op: OpUnknown}
+	r := protowire.NewReader(data)
+	for !r.Done() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse decoderbufs message: %w", err)
+		}
+		var v uint64
+		switch {
+		case num == rowTransactionID && typ == protowire.VarintType:
+			v, err = r.Varint()
+			m.TransactionID = uint32(v)
+		case num == rowCommitTime && typ == protowire.VarintType:
+			v, err = r.Varint()
+			m.CommitTime = time.UnixMicro(int64(v)).UTC()
+		case num == rowTable && typ == protowire.BytesType:
+			var s []byte
+			s, err = r.Bytes()
+			m.Table = string(s)
+		case num == rowOp && typ == protowire.VarintType:
+			v, err = r.Varint()
+			m.Op = Op(int32(v))
+		case (num == rowNewTuple || num == rowOldTuple) && typ == protowire.BytesType:
+			var d Datum
+			if d, err = parseDatum(r); err == nil {
+				if num == rowNewTuple {
+					m.NewTuple = append(m.NewTuple, d)
+				} else {
+					m.OldTuple = append(m.OldTuple, d)
+				}
+			}
+		case num == rowNewTypeInfo && typ == protowire.BytesType:
+			var ti TypeInfo
+			if ti, err = parseTypeInfo(r); err == nil {
+				m.NewTypeInfo = append(m.NewTypeInfo, ti)
+			}
+		default:
+			err = r.Skip(typ)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse decoderbufs message: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func parseDatum(pr *protowire.Reader) (Datum, error) {
+	var d Datum
+	data, err := pr.Bytes()
+	if err != nil {
+		return d, err
+	}
+	r := protowire.NewReader(data)
+	for !r.Done() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return d, err
+		}
+		var v uint64
+		switch {
+		case num == datumColumnName && typ == protowire.BytesType:
+			var s []byte
+			s, err = r.Bytes()
+			d.ColumnName = string(s)
+		case num == datumColumnType && typ == protowire.VarintType:
+			v, err = r.Varint()
+			d.ColumnType = int64(v)
+		case num == datumInt32 && typ == protowire.VarintType:
+			v, err = r.Varint()
+			d.Value = int32(v)
+		case num == datumInt64 && typ == protowire.VarintType:
+			v, err = r.Varint()
+			d.Value = int64(v)
+		case num == datumFloat && typ == protowire.Fixed32Type:
+			var f uint32
+			f, err = r.Fixed32()
+			d.Value = math.Float32frombits(f)
+		case num == datumDouble && typ == protowire.Fixed64Type:
+			v, err = r.Fixed64()
+			d.Value = math.Float64frombits(v)
+		case num == datumBool && typ == protowire.VarintType:
+			v, err = r.Varint()
+			d.Value = v != 0
+		case num == datumString && typ == protowire.BytesType:
+			var s []byte
+			s, err = r.Bytes()
+			d.Value = string(s)
+		case num == datumBytes && typ == protowire.BytesType:
+			var s []byte
+			s, err = r.Bytes()
+			d.Value = append([]byte{}, s...)
+		case num == datumPoint && typ == protowire.BytesType:
+			d.Value, err = parsePoint(r)
+		case num == datumMissing && typ == protowire.VarintType:
+			v, err = r.Varint()
+			d.Missing = v != 0
+		default:
+			err = r.Skip(typ)
+		}
+		if err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+func parsePoint(pr *protowire.Reader) (Point, error) {
+	var p Point
+	data, err := pr.Bytes()
+	if err != nil {
+		return p, err
+	}
+	r := protowire.NewReader(data)
+	for !r.Done() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return p, err
+		}
+		var v uint64
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			v, err = r.Fixed64()
+			p.X = math.Float64frombits(v)
+		case num == 2 && typ == protowire.Fixed64Type:
+			v, err = r.Fixed64()
+			p.Y = math.Float64frombits(v)
+		default:
+			err = r.Skip(typ)
+		}
+		if err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+func parseTypeInfo(pr *protowire.Reader) (TypeInfo, error) {
+	var ti TypeInfo
+	data, err := pr.Bytes()
+	if err != nil {
+		return ti, err
+	}
+	r := protowire.NewReader(data)
+	for !r.Done() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return ti, err
+		}
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			var s []byte
+			s, err = r.Bytes()
+			ti.Modifier = string(s)
+		case num == 2 && typ == protowire.VarintType:
+			var v uint64
+			v, err = r.Varint()
+			ti.ValueOptional = v != 0
+		default:
+			err = r.Skip(typ)
+		}
+		if err != nil {
+			return ti, err
+		}
+	}
+	return ti, nil
+}
+
+// ChangeEvent converts m into a pglogrepl.ChangeEvent received at lsn. It returns nil for begin
+// and commit messages. decoderbufs does not identify the key columns, so the key of an update or
+// delete is its old tuple, and an insert has no key.
+func (m *RowMessage) ChangeEvent(lsn pglogrepl.LSN) *pglogrepl.ChangeEvent {
+	var op pglogrepl.Operation
+	switch m.Op {
+	case OpInsert:
+		op = pglogrepl.OperationInsert
+	case OpUpdate:
+		op = pglogrepl.OperationUpdate
+	case OpDelete:
+		op = pglogrepl.OperationDelete
+	default:
+		return nil
+	}
+	schema, table := splitTable(m.Table)
+	event := &pglogrepl.ChangeEvent{
+		Operation:  op,
+		Schema:     schema,
+		Table:      table,
+		Before:     datumValues(m.OldTuple),
+		After:      datumValues(m.NewTuple),
+		LSN:        lsn,
+		CommitTime: m.CommitTime,
+		Xid:        m.TransactionID,
+	}
+	event.Key = event.Before
+	return event
+}
+
+func datumValues(datums []Datum) map[string]interface{} {
+	if datums == nil {
+		return nil
+	}
+	values := make(map[string]interface{}, len(datums))
+	for _, d := range datums {
+		if !d.Missing {
+			values[d.ColumnName] = d.Value
+		}
+	}
+	return values
+}
+
+// splitTable splits the quoted, schema qualified name of a table into its unquoted schema and
+// name.
+func splitTable(s string) (string, string) {
+	var parts []string
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' && quoted && i+1 < len(s) && s[i+1] == '"':
+			b.WriteByte('"')
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	parts = append(parts, b.String())
+	if len(parts) == 1 {
+		return "", parts[0]
+	}
+	return parts[0], strings.Join(parts[1:], ".")
+}
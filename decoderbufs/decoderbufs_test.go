@@ -0,0 +1,92 @@
+package decoderbufs_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/decoderbufs"
+	"github.com/jackc/pglogrepl/internal/protowire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func message(fields ...func([]byte) []byte) []byte {
+	var b []byte
+	for _, field := range fields {
+		b = field(b)
+	}
+	return b
+}
+
+func varint(num int, v uint64) func([]byte) []byte {
+	return func(b []byte) []byte {
+		return protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), v)
+	}
+}
+
+func bytes(num int, data []byte) func([]byte) []byte {
+	return func(b []byte) []byte {
+		return protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), data)
+	}
+}
+
+func double(num int, f float64) func([]byte) []byte {
+	return func(b []byte) []byte {
+		return protowire.AppendDouble(protowire.AppendTag(b, num, protowire.Fixed64Type), f)
+	}
+}
+
+func TestParse(t *testing.T) {
+	commitTime := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	data := message(
+		varint(1, 771),
+		varint(2, uint64(commitTime.UnixMicro())),
+		bytes(3, []byte(`public."My ""T"""`)),
+		varint(4, uint64(decoderbufs.OpUpdate)),
+		bytes(5, message(bytes(1, []byte("id")), varint(2, 23), varint(3, uint64(math.MaxUint64)))),
+		bytes(5, message(bytes(1, []byte("pos")), varint(2, 600), bytes(10, message(double(1, 1.5), double(2, -2))))),
+		bytes(5, message(bytes(1, []byte("doc")), varint(2, 25), varint(11, 1))),
+		bytes(5, message(bytes(1, []byte("note")), varint(2, 25))),
+		bytes(6, message(bytes(1, []byte("id")), varint(2, 23), varint(3, 7))),
+		bytes(7, message(bytes(1, []byte("integer")), varint(2, 0))),
+		varint(99, 1),
+	)
+	m, err := decoderbufs.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, &decoderbufs.RowMessage{
+		TransactionID: 771,
+		CommitTime:    commitTime,
+		Table:         `public."My ""T"""`,
+		Op:            decoderbufs.OpUpdate,
+		NewTuple: []decoderbufs.Datum{
+			{ColumnName: "id", ColumnType: 23, Value: int32(-1)},
+			{ColumnName: "pos", ColumnType: 600, Value: decoderbufs.Point{X: 1.5, Y: -2}},
+			{ColumnName: "doc", ColumnType: 25, Missing: true},
+			{ColumnName: "note", ColumnType: 25},
+		},
+		OldTuple:    []decoderbufs.Datum{{ColumnName: "id", ColumnType: 23, Value: int32(7)}},
+		NewTypeInfo: []decoderbufs.TypeInfo{{Modifier: "integer"}},
+	}, m)
+
+	assert.Equal(t, &pglogrepl.ChangeEvent{
+		Operation:  pglogrepl.OperationUpdate,
+		Schema:     "public",
+		Table:      `My "T"`,
+		Key:        map[string]interface{}{"id": int32(7)},
+		Before:     map[string]interface{}{"id": int32(7)},
+		After:      map[string]interface{}{"id": int32(-1), "pos": decoderbufs.Point{X: 1.5, Y: -2}, "note": nil},
+		LSN:        0x100,
+		CommitTime: commitTime,
+		Xid:        771,
+	}, m.ChangeEvent(0x100))
+
+	begin, err := decoderbufs.Parse(message(varint(1, 772), varint(4, uint64(decoderbufs.OpBegin))))
+	require.NoError(t, err)
+	assert.Equal(t, decoderbufs.OpBegin, begin.Op)
+	assert.Nil(t, begin.ChangeEvent(0x200))
+
+	_, err = decoderbufs.Parse(data[:len(data)-1])
+	assert.Error(t, err)
+}
@@ -0,0 +1,93 @@
+package pglogrepl
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Deduplicator turns the at least once delivery of a stream into exactly once delivery to a Sink
+// that records its progress atomically with its data.
+//
+// The pattern is the one of the apply package: the sink stores the end position of every
+// transaction it writes, CommitMessage.TransactionEndLSN, in the same target transaction as the
+// changes, such as a row of a checkpoint table updated with the data or the offset of a
+// transactional producer. On restart the application reads that position back from the target,
+// starts replication from it and gives it to NewDeduplicator. Transactions the server sends again
+// because the slot had not been confirmed that far are then dropped before they reach the sink,
+// so the target never sees a transaction twice even when the confirmation to the server lags.
+//
+// A transaction is a duplicate if its commit position, BeginMessage.FinalLSN, is before the durable
+// position. Non-transactional logical decoding messages, such as markers written with
+// EmitLogicalMessage, are deduplicated by their own LSN. Relation and type messages are always
+// passed on as the sink needs them to decode later changes. Streamed and prepared transactions
+// are passed on as is: their commit position is only known at the end, so a Deduplicator should
+// be given assembled transactions, see TransactionAssembler, or a stream without the streaming
+// option.
+//
+// The durable position advances with the positions returned by the Flush of the sink. A
+// Deduplicator is not safe for concurrent use, except for Skipped.
+type Deduplicator struct {
+	sink     Sink
+	durable  LSN
+	skipping bool
+	skipped  int64
+}
+
+// NewDeduplicator returns a Deduplicator writing to sink the changes of the transactions ending
+// after durableLSN, the position recorded by the sink with its data.
+func NewDeduplicator(sink Sink, durableLSN LSN) *Deduplicator {
+	return &Deduplicator{sink: sink, durable: durableLSN}
+}
+
+// WriteChange implements Sink. It drops msg if it belongs to a transaction that is already
+// durable.
+func (d *Deduplicator) WriteChange(ctx context.Context, msg *ReplicationMessage) error {
+	if d.skip(msg.Message) {
+		atomic.AddInt64(&d.skipped, 1)
+		return nil
+	}
+	return d.sink.WriteChange(ctx, msg)
+}
+
+// Flush implements Sink.
+func (d *Deduplicator) Flush(ctx context.Context) (LSN, error) {
+	lsn, err := d.sink.Flush(ctx)
+	if err == nil && lsn > d.durable {
+		d.durable = lsn
+	}
+	return lsn, err
+}
+
+// DurableLSN returns the position before which transactions are dropped.
+func (d *Deduplicator) DurableLSN() LSN {
+	return d.durable
+}
+
+// Skipped returns the number of messages dropped as duplicates.
+func (d *Deduplicator) Skipped() int64 {
+	return atomic.LoadInt64(&d.skipped)
+}
+
+// skip reports whether msg is a duplicate.
+func (d *Deduplicator) skip(msg Message) bool {
+	switch msg := msg.(type) {
+	case *BeginMessage:
+		d.skipping = msg.FinalLSN < d.durable
+		return d.skipping
+	case *CommitMessage:
+		skipping := d.skipping
+		d.skipping = false
+		return skipping
+	case *RelationMessage, *RelationMessageV2, *TypeMessage, *TypeMessageV2:
+		return false
+	case *LogicalDecodingMessage:
+		if !msg.Transactional {
+			return msg.LSN < d.durable
+		}
+	case *LogicalDecodingMessageV2:
+		if !msg.Transactional {
+			return msg.LSN < d.durable
+		}
+	}
+	return d.skipping
+}
@@ -0,0 +1,50 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeduplicator(t *testing.T) {
+	ctx := context.Background()
+	sink := newTestSink()
+	// The sink recorded the end of the transaction committed at 0x200 with its data.
+	dedup := pglogrepl.NewDeduplicator(sink, 0x210)
+
+	relation := &pglogrepl.RelationMessage{RelationID: 1, Namespace: "public", RelationName: "t"}
+	resent := []pglogrepl.Message{
+		&pglogrepl.BeginMessage{FinalLSN: 0x200, Xid: 1},
+		relation,
+		&pglogrepl.InsertMessage{RelationID: 1},
+		&pglogrepl.CommitMessage{CommitLSN: 0x200, TransactionEndLSN: 0x210},
+		&pglogrepl.LogicalDecodingMessage{LSN: 0x180, Prefix: "marker"},
+	}
+	fresh := []pglogrepl.Message{
+		&pglogrepl.LogicalDecodingMessage{LSN: 0x220, Prefix: "marker"},
+		&pglogrepl.BeginMessage{FinalLSN: 0x300, Xid: 2},
+		&pglogrepl.InsertMessage{RelationID: 1},
+		&pglogrepl.CommitMessage{CommitLSN: 0x300, TransactionEndLSN: 0x310},
+	}
+	for _, msg := range append(resent, fresh...) {
+		require.NoError(t, dedup.WriteChange(ctx, &pglogrepl.ReplicationMessage{Message: msg}))
+	}
+	assert.Equal(t, append([]pglogrepl.Message{relation}, fresh...), sink.written)
+	assert.EqualValues(t, 4, dedup.Skipped())
+
+	lsn, err := dedup.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x310), lsn)
+	assert.Equal(t, pglogrepl.LSN(0x310), dedup.DurableLSN())
+
+	// After a reconnect resuming from an older position the flushed transaction is dropped.
+	written := len(sink.written)
+	for _, msg := range fresh[1:] {
+		require.NoError(t, dedup.WriteChange(ctx, &pglogrepl.ReplicationMessage{Message: msg}))
+	}
+	assert.Len(t, sink.written, written)
+	assert.EqualValues(t, 7, dedup.Skipped())
+}
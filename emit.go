@@ -0,0 +1,70 @@
+package pglogrepl
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// LogicalMessage is a logical decoding message written to the WAL by EmitLogicalMessages.
+type LogicalMessage struct {
+	// Transactional messages are decoded as part of the transaction they were emitted in, when it
+	// commits, others immediately, even if the transaction is rolled back.
+	Transactional bool
+	// Prefix identifies the kind of message, such as "myapp.checkpoint".
+	Prefix  string
+	Content []byte
+}
+
+func (m LogicalMessage) sql() string {
+	return fmt.Sprintf("SELECT pg_logical_emit_message(%t, %s, decode('%s', 'hex'))",
+		m.Transactional, quoteLiteral(m.Prefix), hex.EncodeToString(m.Content))
+}
+
+// EmitLogicalMessage writes a logical decoding message to the WAL with pg_logical_emit_message and
+// returns its LSN. Consumers receive it as a LogicalDecodingMessage, if the pgoutput messages
+// option is enabled, see PgoutputOptions.Messages. Messages let applications inject markers into
+// the stream, such as application checkpoints or requests to flush. conn is a regular
+// connection or a logical replication connection; emitting requires the REPLICATION attribute or
+// superuser privileges.
+func EmitLogicalMessage(ctx context.Context, conn *pgconn.PgConn, transactional bool, prefix string, content []byte) (LSN, error) {
+	lsns, err := EmitLogicalMessages(ctx, conn, []LogicalMessage{{Transactional: transactional, Prefix: prefix, Content: content}})
+	if err != nil {
+		return 0, err
+	}
+	return lsns[0], nil
+}
+
+// EmitLogicalMessages writes messages to the WAL in a single query and returns their LSNs. The
+// query is executed in an implicit transaction, so that the transactional messages are part of
+// one transaction, unless conn is inside a transaction.
+func EmitLogicalMessages(ctx context.Context, conn *pgconn.PgConn, messages []LogicalMessage) ([]LSN, error) {
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	sqls := make([]string, len(messages))
+	for i, m := range messages {
+		sqls[i] = m.sql()
+	}
+	results, err := conn.Exec(ctx, strings.Join(sqls, "; ")).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to emit logical message: %w", err)
+	}
+	if len(results) != len(messages) {
+		return nil, fmt.Errorf("expected %d result sets, got %d", len(messages), len(results))
+	}
+
+	lsns := make([]LSN, len(messages))
+	for i, result := range results {
+		if len(result.Rows) != 1 || len(result.Rows[0]) != 1 {
+			return nil, fmt.Errorf("expected 1 result row and column")
+		}
+		if lsns[i], err = ParseLSN(string(result.Rows[0][0])); err != nil {
+			return nil, fmt.Errorf("failed to parse pg_logical_emit_message result as LSN: %w", err)
+		}
+	}
+	return lsns, nil
+}
@@ -0,0 +1,51 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func emitResult(lsn string) []pgproto3.BackendMessage {
+	return []pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("pg_logical_emit_message"), DataTypeOID: 3220}}},
+		&pgproto3.DataRow{Values: [][]byte{[]byte(lsn)}},
+		&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")},
+	}
+}
+
+func TestEmitLogicalMessage(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	queries := ws.serveQuery(append(emitResult("0/1A2B"), &pgproto3.ReadyForQuery{TxStatus: 'I'}))
+	lsn, err := pglogrepl.EmitLogicalMessage(ctx, conn, true, "app's.checkpoint", []byte("flush"))
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x1A2B), lsn)
+	assert.Equal(t, `SELECT pg_logical_emit_message(true, 'app''s.checkpoint', decode('666c757368', 'hex'))`, <-queries)
+
+	msgs := append(emitResult("0/100"), emitResult("0/180")...)
+	queries = ws.serveQuery(append(msgs, &pgproto3.ReadyForQuery{TxStatus: 'I'}))
+	lsns, err := pglogrepl.EmitLogicalMessages(ctx, conn, []pglogrepl.LogicalMessage{
+		{Transactional: true, Prefix: "a", Content: []byte{1}},
+		{Prefix: "b"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []pglogrepl.LSN{0x100, 0x180}, lsns)
+	assert.Equal(t, `SELECT pg_logical_emit_message(true, 'a', decode('01', 'hex')); `+
+		`SELECT pg_logical_emit_message(false, 'b', decode('', 'hex'))`, <-queries)
+
+	queries = ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42501", Message: "permission denied"},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	_, err = pglogrepl.EmitLogicalMessage(ctx, conn, false, "a", nil)
+	assert.Error(t, err)
+	<-queries
+}
@@ -0,0 +1,130 @@
+package pglogrepl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The errors wrapped by a ParseError, to be tested with errors.Is. They report corrupted or
+// truncated protocol data, while an UnknownMessageTypeError usually reports that the server uses
+// a protocol version the parser does not support.
+var (
+	// ErrTruncatedMessage reports a message ending before all its fields are parsed.
+	ErrTruncatedMessage = errors.New("truncated message")
+	// ErrUnknownTupleKind reports an unknown kind of tuple, such as the 'K', 'O' or 'N' preceding
+	// the tuples of an update, or of tuple column, such as 't' for a text value.
+	ErrUnknownTupleKind = errors.New("unknown tuple kind")
+)
+
+// ErrUnknownMessageType is matched by every UnknownMessageTypeError with errors.Is.
+var ErrUnknownMessageType = errors.New("unknown message type")
+
+// UnknownMessageTypeError is the error of parsing a message whose type is not supported by the
+// parser, such as a stream message parsed with Parse rather than ParseV2.
+type UnknownMessageTypeError struct {
+	// Byte is the message type byte.
+	Byte byte
+}
+
+func (e *UnknownMessageTypeError) Error() string {
+	return fmt.Sprintf("replication message type %q not supported", e.Byte)
+}
+
+// Is reports whether target is ErrUnknownMessageType.
+func (e *UnknownMessageTypeError) Is(target error) bool {
+	return target == ErrUnknownMessageType
+}
+
+// ParseError is the error of parsing a malformed message.
+type ParseError struct {
+	// MessageType is the type of the message. It is 0 if the error is returned by the Decode
+	// method of a message rather than by a Parse function, and for the messages of the streaming
+	// protocol such as XLogData.
+	MessageType MessageType
+	// Message is the name of the message, such as "InsertMessage", and Field the field that could
+	// not be parsed, if known, such as "Tuple.Columns[2]".
+	Message string
+	Field   string
+	// Offset is the offset in bytes of the error in the data parsed. The Parse functions count the
+	// message type byte, the Decode methods of messages count from the start of the data they are
+	// given.
+	Offset int
+	// Err wraps ErrTruncatedMessage or ErrUnknownTupleKind, with details.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	name := e.Message
+	if e.Field != "" {
+		name += "." + e.Field
+	}
+	return fmt.Sprintf("%s at offset %d: %v", name, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// truncatedError returns the ParseError of message name requiring expectedLen bytes out of the
+// actualLen bytes available.
+func truncatedError(name string, expectedLen, actualLen int) error {
+	return &ParseError{
+		Message: name,
+		Offset:  actualLen,
+		Err:     fmt.Errorf("%w: need %d more bytes", ErrTruncatedMessage, expectedLen-actualLen),
+	}
+}
+
+// nestedError returns err, the error of decoding a part of message name at offset, as the error of
+// field of the message. It is used for the tuples of changes and for the messages following the
+// Xid of streamed V2 messages. An empty name or field keeps the message or field of err.
+func nestedError(err error, name, field string, offset int) error {
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		return err
+	}
+	pe.Offset += offset
+	if name != "" {
+		pe.Message = name
+	}
+	switch {
+	case field == "":
+	case pe.Field == "":
+		pe.Field = field
+	default:
+		pe.Field = field + "." + pe.Field
+	}
+	return pe
+}
+
+// messageError sets the message type of the ParseError err of the message parsed from data, whose
+// first byte is the message type, and counts that byte in its offset.
+func messageError(err error, data []byte) error {
+	var pe *ParseError
+	if errors.As(err, &pe) && pe.MessageType == 0 {
+		pe.MessageType = MessageType(data[0])
+		pe.Offset++
+	}
+	return err
+}
+
+// ErrSystemIDMismatch is matched by every SystemIDMismatchError with errors.Is.
+var ErrSystemIDMismatch = errors.New("system identifier mismatch")
+
+// SystemIDMismatchError is the error of a ReplicationStream whose ReconnectPolicy has
+// VerifySystemID or FollowTimeline when it reconnected to a server of another cluster.
+type SystemIDMismatchError struct {
+	// Expected is the system identifier of the server the stream was started on, Actual that of
+	// the server it reconnected to.
+	Expected string
+	Actual   string
+}
+
+func (e *SystemIDMismatchError) Error() string {
+	return fmt.Sprintf("reconnected to system %s, expected %s", e.Actual, e.Expected)
+}
+
+// Is reports whether target is ErrSystemIDMismatch.
+func (e *SystemIDMismatchError) Is(target error) bool {
+	return target == ErrSystemIDMismatch
+}
@@ -0,0 +1,115 @@
+package pglogrepl
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testInsertData(t *testing.T) []byte {
+	insert := &InsertMessage{RelationID: 16384, Tuple: &TupleData{ColumnNum: 2, Columns: []*TupleDataColumn{
+		{DataType: TupleDataTypeText, Length: 2, Data: []byte("42")},
+		{DataType: TupleDataTypeText, Length: 5, Data: []byte("alice")},
+	}}}
+	data, err := insert.Encode(nil)
+	require.NoError(t, err)
+	return data
+}
+
+func requireParseError(t *testing.T, err error) *ParseError {
+	var pe *ParseError
+	require.True(t, errors.As(err, &pe), "%v is not a ParseError", err)
+	return pe
+}
+
+func TestParseErrorTruncated(t *testing.T) {
+	data := testInsertData(t)
+	// The message ends in the value of the second column.
+	data = data[:len(data)-2]
+	_, err := Parse(data)
+	assert.ErrorIs(t, err, ErrTruncatedMessage)
+	pe := requireParseError(t, err)
+	assert.Equal(t, MessageTypeInsert, pe.MessageType)
+	assert.Equal(t, "InsertMessage", pe.Message)
+	assert.Equal(t, "Tuple.Columns[1]", pe.Field)
+	assert.Equal(t, len(data), pe.Offset)
+	assert.EqualError(t, err, "InsertMessage.Tuple.Columns[1] at offset 23: truncated message: need 2 more bytes")
+
+	d, err := NewDecoder(1)
+	require.NoError(t, err)
+	d.Reset(data)
+	_, err = d.Decode()
+	assert.Equal(t, pe, requireParseError(t, err))
+
+	_, err = Parse(nil)
+	assert.ErrorIs(t, err, ErrTruncatedMessage)
+}
+
+func TestParseErrorUnknownTupleKind(t *testing.T) {
+	data := testInsertData(t)
+	// The kind of the second column follows the type, relation ID, tuple type, column count and
+	// first column.
+	offset := 1 + 4 + 1 + 2 + 1 + 4 + 2
+	require.Equal(t, TupleDataTypeText, data[offset])
+	data[offset] = 'x'
+	_, err := Parse(data)
+	assert.ErrorIs(t, err, ErrUnknownTupleKind)
+	pe := requireParseError(t, err)
+	assert.Equal(t, "Tuple.Columns[1]", pe.Field)
+	assert.Equal(t, offset, pe.Offset)
+
+	data = testInsertData(t)
+	data[5] = 'K'
+	_, err = Parse(data)
+	assert.ErrorIs(t, err, ErrUnknownTupleKind)
+	assert.Equal(t, 5, requireParseError(t, err).Offset)
+}
+
+func TestParseErrorInStream(t *testing.T) {
+	data, err := (&InsertMessageV2{InsertMessage: InsertMessage{RelationID: 16384, Tuple: &TupleData{ColumnNum: 1, Columns: []*TupleDataColumn{
+		{DataType: TupleDataTypeText, Length: 2, Data: []byte("42")},
+	}}}, InStreamMessageV2WithXid: InStreamMessageV2WithXid{Xid: 731}}).Encode(nil)
+	require.NoError(t, err)
+	data = data[:len(data)-1]
+
+	_, err = ParseV2(data, true)
+	assert.ErrorIs(t, err, ErrTruncatedMessage)
+	pe := requireParseError(t, err)
+	assert.Equal(t, "InsertMessageV2", pe.Message)
+	assert.Equal(t, len(data), pe.Offset)
+
+	d, err := NewDecoder(2)
+	require.NoError(t, err)
+	d.inStream = true
+	d.Reset(data)
+	_, err = d.Decode()
+	assert.Equal(t, len(data), requireParseError(t, err).Offset)
+}
+
+func TestParseErrorUnknownMessageType(t *testing.T) {
+	data, err := (&StreamStartMessageV2{Xid: 731, FirstSegment: 1}).Encode(nil)
+	require.NoError(t, err)
+	_, err = Parse(data)
+	assert.ErrorIs(t, err, ErrUnknownMessageType)
+	var ue *UnknownMessageTypeError
+	require.True(t, errors.As(err, &ue))
+	assert.Equal(t, byte(MessageTypeStreamStart), ue.Byte)
+	assert.NotErrorIs(t, err, ErrTruncatedMessage)
+
+	_, err = ParseV2(data, false)
+	assert.NoError(t, err)
+}
+
+func TestParseErrorStreamingProtocol(t *testing.T) {
+	_, err := ParseXLogData(make([]byte, 10))
+	assert.ErrorIs(t, err, ErrTruncatedMessage)
+	assert.Equal(t, "XLogData", requireParseError(t, err).Message)
+
+	_, err = ParsePrimaryKeepaliveMessage(make([]byte, 16))
+	assert.ErrorIs(t, err, ErrTruncatedMessage)
+	_, err = ParsePrimaryKeepaliveMessage(make([]byte, 18))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrTruncatedMessage)
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/sqlgen"
+	"github.com/jackc/pglogrepl/wal2json"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgproto3"
 	"github.com/jackc/pgx/v5/pgtype"
@@ -22,14 +24,12 @@ func main() {
 	}
 	defer conn.Close(context.Background())
 
-	result := conn.Exec(context.Background(), "DROP PUBLICATION IF EXISTS pglogrepl_demo;")
-	_, err = result.ReadAll()
+	err = pglogrepl.DropPublication(context.Background(), conn, "pglogrepl_demo", pglogrepl.DropPublicationOptions{IfExists: true})
 	if err != nil {
 		log.Fatalln("drop publication if exists error", err)
 	}
 
-	result = conn.Exec(context.Background(), "CREATE PUBLICATION pglogrepl_demo FOR ALL TABLES;")
-	_, err = result.ReadAll()
+	err = pglogrepl.CreatePublication(context.Background(), conn, "pglogrepl_demo", pglogrepl.PublicationOptions{AllTables: true})
 	if err != nil {
 		log.Fatalln("create publication error", err)
 	}
@@ -90,6 +90,11 @@ func main() {
 
 	for {
 		if time.Now().After(nextStandbyMessageDeadline) {
+			// The demo only prints the messages, so everything received is reported as flushed and
+			// applied too. A consumer that persists the changes must report the position it has
+			// durably processed as WALFlushPosition and WALApplyPosition instead, or use a
+			// ReplicationStream with AckPolicy AckOnApply, otherwise the server discards WAL that
+			// could still be lost.
 			err = pglogrepl.SendStandbyStatusUpdate(context.Background(), conn, pglogrepl.StandbyStatusUpdate{WALWritePosition: clientXLogPos})
 			if err != nil {
 				log.Fatalln("SendStandbyStatusUpdate failed:", err)
@@ -139,7 +144,13 @@ func main() {
 			}
 
 			if outputPlugin == "wal2json" {
-				log.Printf("wal2json data: %s\n", string(xld.WALData))
+				tx, err := wal2json.ParseV1(xld.WALData)
+				if err != nil {
+					log.Fatalln("wal2json.ParseV1 failed:", err)
+				}
+				for _, change := range tx.Changes {
+					log.Printf("wal2json change: %s %s.%s columns %v old keys %v\n", change.Kind, change.Table.Schema, change.Table.Name, change.Columns, change.OldKeys)
+				}
 			} else {
 				log.Printf("XLogData => WALStart %s ServerWALEnd %s ServerTime %s WALData:\n", xld.WALStart, xld.ServerWALEnd, xld.ServerTime)
 				if v2 {
@@ -184,8 +195,8 @@ func processV2(walData []byte, relations map[uint32]*pglogrepl.RelationMessageV2
 				values[colName] = nil
 			case 'u': // unchanged toast
 				// This TOAST value was not changed. TOAST values are not stored in the tuple, and logical replication doesn't want to spend a disk read to fetch its value for you.
-			case 't': //text
-				val, err := decodeTextColumnData(typeMap, col.Data, rel.Columns[idx].DataType)
+			case 't', 'b': // text or binary (when started with binary 'true')
+				val, err := col.DecodeValue(typeMap, rel.Columns[idx].DataType)
 				if err != nil {
 					log.Fatalln("error decoding column data:", err)
 				}
@@ -193,7 +204,11 @@ func processV2(walData []byte, relations map[uint32]*pglogrepl.RelationMessageV2
 			}
 		}
 		log.Printf("insert for xid %d\n", logicalMsg.Xid)
-		log.Printf("INSERT INTO %s.%s: %v", rel.Namespace, rel.RelationName, values)
+		stmt, err := sqlgen.Insert(&rel.RelationMessage, &logicalMsg.InsertMessage, sqlgen.Options{})
+		if err != nil {
+			log.Fatalln("error generating insert statement:", err)
+		}
+		log.Printf("%s: %v", stmt.SQL, values)
 
 	case *pglogrepl.UpdateMessageV2:
 		log.Printf("update for xid %d\n", logicalMsg.Xid)
@@ -203,7 +218,19 @@ func processV2(walData []byte, relations map[uint32]*pglogrepl.RelationMessageV2
 		// ...
 	case *pglogrepl.TruncateMessageV2:
 		log.Printf("truncate for xid %d\n", logicalMsg.Xid)
-		// ...
+		rels := make([]*pglogrepl.RelationMessage, len(logicalMsg.RelationIDs))
+		for i, relationID := range logicalMsg.RelationIDs {
+			rel, ok := relations[relationID]
+			if !ok {
+				log.Fatalf("unknown relation ID %d", relationID)
+			}
+			rels[i] = &rel.RelationMessage
+		}
+		stmt, err := sqlgen.Truncate(rels, &logicalMsg.TruncateMessage, sqlgen.Options{})
+		if err != nil {
+			log.Fatalln("error generating truncate statement:", err)
+		}
+		log.Print(stmt.SQL)
 
 	case *pglogrepl.TypeMessageV2:
 	case *pglogrepl.OriginMessage:
@@ -254,22 +281,30 @@ func processV1(walData []byte, relations map[uint32]*pglogrepl.RelationMessage,
 				values[colName] = nil
 			case 'u': // unchanged toast
 				// This TOAST value was not changed. TOAST values are not stored in the tuple, and logical replication doesn't want to spend a disk read to fetch its value for you.
-			case 't': //text
-				val, err := decodeTextColumnData(typeMap, col.Data, rel.Columns[idx].DataType)
+			case 't', 'b': // text or binary (when started with binary 'true')
+				val, err := col.DecodeValue(typeMap, rel.Columns[idx].DataType)
 				if err != nil {
 					log.Fatalln("error decoding column data:", err)
 				}
 				values[colName] = val
 			}
 		}
-		log.Printf("INSERT INTO %s.%s: %v", rel.Namespace, rel.RelationName, values)
+		stmt, err := sqlgen.Insert(rel, logicalMsg, sqlgen.Options{})
+		if err != nil {
+			log.Fatalln("error generating insert statement:", err)
+		}
+		log.Printf("%s: %v", stmt.SQL, values)
 
 	case *pglogrepl.UpdateMessage:
 		// ...
 	case *pglogrepl.DeleteMessage:
 		// ...
 	case *pglogrepl.TruncateMessage:
-		// ...
+		stmt, err := sqlgen.Generate(sqlgen.RelationMap(relations), logicalMsg, sqlgen.Options{})
+		if err != nil {
+			log.Fatalln("error generating truncate statement:", err)
+		}
+		log.Print(stmt.SQL)
 
 	case *pglogrepl.TypeMessage:
 	case *pglogrepl.OriginMessage:
@@ -289,10 +324,3 @@ func processV1(walData []byte, relations map[uint32]*pglogrepl.RelationMessage,
 		log.Printf("Unknown message type in pgoutput stream: %T", logicalMsg)
 	}
 }
-
-func decodeTextColumnData(mi *pgtype.Map, data []byte, dataType uint32) (interface{}, error) {
-	if dt, ok := mi.TypeForOID(dataType); ok {
-		return dt.Codec.DecodeValue(mi, dataType, pgtype.TextFormatCode, data)
-	}
-	return string(data), nil
-}
@@ -0,0 +1,164 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Switchover describes a change of the server a ReplicationStream reconnected to, as detected by
+// comparing the results of IDENTIFY_SYSTEM before and after the reconnect.
+type Switchover struct {
+	// Previous identifies the server the stream was connected to.
+	Previous IdentifySystemResult
+	// Current identifies the server the stream reconnected to.
+	Current IdentifySystemResult
+}
+
+// Promoted reports whether the new server is a promoted standby of the previous one: it belongs to
+// the same cluster and has switched to a later timeline.
+func (s Switchover) Promoted() bool {
+	return s.Previous.SystemID == s.Current.SystemID && s.Current.Timeline > s.Previous.Timeline
+}
+
+// SystemChanged reports whether the new server belongs to a different cluster than the previous
+// one. Replication slots are not synchronized across clusters, so resuming from the confirmed
+// position is usually wrong.
+func (s Switchover) SystemChanged() bool {
+	return s.Previous.SystemID != s.Current.SystemID
+}
+
+// ReplicationSlotStatus is the state of a logical replication slot relevant to failover, as
+// reported by pg_replication_slots.
+type ReplicationSlotStatus struct {
+	// Failover reports whether the slot is synchronized to standbys.
+	Failover bool
+	// Synced reports whether the slot was synchronized from a primary, which makes it a slot
+	// of a promoted standby when it is read on a primary.
+	Synced bool
+	// InvalidationReason is the reason the slot was invalidated, or empty if it is usable.
+	InvalidationReason string
+	// ConfirmedFlushLSN is the position up to which the consumer of the slot has confirmed
+	// receiving data.
+	ConfirmedFlushLSN LSN
+}
+
+// ReadReplicationSlotStatus reads the failover state of a logical replication slot. It is only
+// supported since PG17. conn must be a logical replication connection, which accepts SQL queries.
+func ReadReplicationSlotStatus(ctx context.Context, conn *pgconn.PgConn, slotName string) (ReplicationSlotStatus, error) {
+	var status ReplicationSlotStatus
+	sql := fmt.Sprintf("SELECT failover, synced, coalesce(invalidation_reason, ''), coalesce(confirmed_flush_lsn, '0/0') FROM pg_replication_slots WHERE slot_type = 'logical' AND slot_name = '%s'", strings.ReplaceAll(slotName, "'", "''"))
+	results, err := conn.Exec(ctx, sql).ReadAll()
+	if err != nil {
+		return status, err
+	}
+	if len(results) != 1 {
+		return status, fmt.Errorf("expected 1 result set, got %d", len(results))
+	}
+	result := results[0]
+	if len(result.Rows) == 0 {
+		return status, fmt.Errorf("logical replication slot %s does not exist", slotName)
+	}
+	row := result.Rows[0]
+	if len(row) != 4 {
+		return status, fmt.Errorf("expected 4 result columns, got %d", len(row))
+	}
+
+	status.Failover = string(row[0]) == "t"
+	status.Synced = string(row[1]) == "t"
+	status.InvalidationReason = string(row[2])
+	status.ConfirmedFlushLSN, err = ParseLSN(string(row[3]))
+	if err != nil {
+		return status, fmt.Errorf("failed to parse confirmed_flush_lsn as LSN: %w", err)
+	}
+	return status, nil
+}
+
+// ValidateFailoverSlot checks that replication can continue from slotName on conn after a
+// failover: the slot must exist, be a failover slot and not be invalidated. It is meant to be
+// called from ReconnectPolicy.OnSwitchover.
+func ValidateFailoverSlot(ctx context.Context, conn *pgconn.PgConn, slotName string) error {
+	status, err := ReadReplicationSlotStatus(ctx, conn, slotName)
+	if err != nil {
+		return err
+	}
+	if !status.Failover {
+		return fmt.Errorf("replication slot %s is not a failover slot", slotName)
+	}
+	if status.InvalidationReason != "" {
+		return fmt.Errorf("replication slot %s is invalidated: %s", slotName, status.InvalidationReason)
+	}
+	return nil
+}
+
+// identifySwitchover identifies the server of conn and reports a switchover to the policy if it
+// differs from the server the stream was connected to. The returned bool is true if the policy
+// rejected the switchover.
+func (s *ReplicationStream) identifySwitchover(ctx context.Context, conn *pgconn.PgConn) (bool, error) {
+	current, err := IdentifySystem(ctx, conn)
+	if err != nil {
+		return false, fmt.Errorf("failed to identify system: %w", err)
+	}
+	policy := s.options.Reconnect
+	if (policy.VerifySystemID || policy.FollowTimeline) && current.SystemID != s.systemID {
+		s.logger.Error("server belongs to another cluster", "system_id", current.SystemID, "expected_system_id", s.systemID)
+		return true, &SystemIDMismatchError{Expected: s.systemID, Actual: current.SystemID}
+	}
+	switchover := Switchover{Previous: s.system, Current: current}
+	if policy.FollowTimeline && current.Timeline != s.system.Timeline {
+		if err := s.followTimeline(ctx, conn, switchover); err != nil {
+			return true, err
+		}
+	}
+	if policy.OnSwitchover != nil && (current.SystemID != s.system.SystemID || current.Timeline != s.system.Timeline) {
+		s.logger.Info("server switched over", "system_id", current.SystemID, "timeline", current.Timeline,
+			"previous_system_id", s.system.SystemID, "previous_timeline", s.system.Timeline)
+		if err := policy.OnSwitchover(ctx, conn, switchover); err != nil {
+			return true, fmt.Errorf("switchover rejected: %w", err)
+		}
+	}
+	s.system = current
+	return false, nil
+}
+
+// TimelineSwitch records a ReplicationStream following the promotion of a standby with
+// ReconnectPolicy.FollowTimeline.
+type TimelineSwitch struct {
+	// PreviousTimeline is the timeline of the server the stream was connected to, Timeline that of
+	// the promoted server.
+	PreviousTimeline int32
+	Timeline         int32
+	// LSN is the position replication resumed from on the promoted server.
+	LSN  LSN
+	Time time.Time
+}
+
+// followTimeline checks that replication can resume on the promoted server of conn after
+// switchover, and records the switch.
+func (s *ReplicationStream) followTimeline(ctx context.Context, conn *pgconn.PgConn, switchover Switchover) error {
+	if !switchover.Promoted() {
+		s.logger.Error("server is on an earlier timeline", "timeline", switchover.Current.Timeline,
+			"previous_timeline", switchover.Previous.Timeline)
+		return fmt.Errorf("reconnected to timeline %d, earlier than timeline %d", switchover.Current.Timeline, switchover.Previous.Timeline)
+	}
+	if s.options.Mode == LogicalReplication {
+		if err := ValidateFailoverSlot(ctx, conn, s.slotName); err != nil {
+			return fmt.Errorf("cannot follow timeline %d: %w", switchover.Current.Timeline, err)
+		}
+	} else if s.options.Timeline != 0 {
+		s.options.Timeline = switchover.Current.Timeline
+	}
+	lsn := s.resumeLSN()
+	s.logger.Info("following timeline", "timeline", switchover.Current.Timeline,
+		"previous_timeline", switchover.Previous.Timeline, "lsn", lsn)
+	s.timelineSwitches = append(s.timelineSwitches, TimelineSwitch{
+		PreviousTimeline: switchover.Previous.Timeline,
+		Timeline:         switchover.Current.Timeline,
+		LSN:              lsn,
+		Time:             time.Now(),
+	})
+	return nil
+}
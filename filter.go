@@ -0,0 +1,202 @@
+package pglogrepl
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// MessageFilter selects the data changes delivered by a ReplicationStream. Messages of other types,
+// including the relation messages of excluded tables, are always delivered.
+//
+// Tables are named "schema.table" or "table", which matches the table in every schema. An empty
+// include list includes everything, and an exclude list takes precedence over include lists.
+type MessageFilter struct {
+	IncludeSchemas []string
+	ExcludeSchemas []string
+	IncludeTables  []string
+	ExcludeTables  []string
+
+	// Operations are the types of the delivered data changes, among MessageTypeInsert,
+	// MessageTypeUpdate, MessageTypeDelete and MessageTypeTruncate. If it is empty all are
+	// delivered.
+	Operations []MessageType
+
+	// Origin selects the transactions whose data changes are delivered by the name of the
+	// replication origin they were replayed from, as reported by the OriginMessage following their
+	// begin message, so that changes a bidirectional setup applied from this client are not
+	// replicated back. The changes of transactions without an origin are always delivered, as are
+	// all changes if Origin is nil. With PostgreSQL 16 the pgoutput Origin option "none" drops the
+	// changes of all origins on the server instead.
+	Origin func(name string) bool
+}
+
+// MatchRelation reports whether the changes of rel are delivered.
+func (f *MessageFilter) MatchRelation(rel *RelationMessage) bool {
+	return f.matchTable(rel.Namespace, rel.RelationName)
+}
+
+func (f *MessageFilter) matchTable(schema, table string) bool {
+	if containsString(f.ExcludeSchemas, schema) || matchTableName(f.ExcludeTables, schema, table) {
+		return false
+	}
+	if len(f.IncludeSchemas) > 0 && !containsString(f.IncludeSchemas, schema) {
+		return false
+	}
+	if len(f.IncludeTables) > 0 && !matchTableName(f.IncludeTables, schema, table) {
+		return false
+	}
+	return true
+}
+
+// MatchOperation reports whether changes of type t are delivered.
+func (f *MessageFilter) MatchOperation(t MessageType) bool {
+	if len(f.Operations) == 0 {
+		return true
+	}
+	for _, op := range f.Operations {
+		if op == t {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func matchTableName(names []string, schema, table string) bool {
+	for _, name := range names {
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			if name[:i] == schema && name[i+1:] == table {
+				return true
+			}
+		} else if name == table {
+			return true
+		}
+	}
+	return false
+}
+
+// relationFilter applies a MessageFilter to the WAL data of a stream. It remembers whether the
+// relations received are included, so that inserts, updates and deletes of excluded relations are
+// dropped without decoding their tuples.
+type relationFilter struct {
+	filter   *MessageFilter
+	included map[uint32]bool
+
+	// originExcluded reports whether the origin of the current transaction is excluded.
+	// excludedStreams are the Xids of the streamed transactions whose origin is excluded, and
+	// streamXid the Xid of the current stream, if any.
+	originExcluded  bool
+	excludedStreams map[uint32]bool
+	streamXid       uint32
+	inStream        bool
+}
+
+func newRelationFilter(filter *MessageFilter) *relationFilter {
+	return &relationFilter{filter: filter, included: map[uint32]bool{}, excludedStreams: map[uint32]bool{}}
+}
+
+// drop reports whether the data change in walData, which is not decoded yet, is not delivered.
+// hasXid reports whether the message starts with the Xid of a streamed transaction.
+func (f *relationFilter) drop(walData []byte, hasXid bool) bool {
+	if len(walData) == 0 {
+		return false
+	}
+	msgType := MessageType(walData[0])
+	switch msgType {
+	case MessageTypeInsert, MessageTypeUpdate, MessageTypeDelete:
+	case MessageTypeTruncate:
+		return !f.filter.MatchOperation(msgType) || f.excludedOrigin(walData, hasXid)
+	default:
+		return false
+	}
+	if !f.filter.MatchOperation(msgType) {
+		return true
+	}
+	offset := 1
+	if hasXid {
+		offset += 4
+	}
+	if len(walData) < offset+4 {
+		return false
+	}
+	if f.excludedOrigin(walData, hasXid) {
+		return true
+	}
+	included, ok := f.included[binary.BigEndian.Uint32(walData[offset:])]
+	// Changes of unknown relations are delivered, decoding reports them if they are invalid.
+	return ok && !included
+}
+
+// excludedOrigin reports whether the data change in walData belongs to a transaction whose origin
+// is excluded.
+func (f *relationFilter) excludedOrigin(walData []byte, hasXid bool) bool {
+	if !hasXid {
+		return f.originExcluded
+	}
+	return len(walData) >= 5 && f.excludedStreams[binary.BigEndian.Uint32(walData[1:])]
+}
+
+// update records the relation of msg and removes the excluded relations from a truncate. It
+// reports whether msg is dropped, which is the case for a truncate of excluded relations only.
+func (f *relationFilter) update(msg Message) bool {
+	switch msg := msg.(type) {
+	case *RelationMessage:
+		f.included[msg.RelationID] = f.filter.MatchRelation(msg)
+	case *RelationMessageV2:
+		f.included[msg.RelationID] = f.filter.MatchRelation(&msg.RelationMessage)
+	case *TruncateMessage:
+		return f.truncate(msg)
+	case *TruncateMessageV2:
+		return f.truncate(&msg.TruncateMessage)
+	case *BeginMessage, *CommitMessage,
+		*BeginPrepareMessageV3, *PrepareMessageV3, *CommitPreparedMessageV3, *RollbackPreparedMessageV3:
+		// The origin of a transaction follows its Begin or BeginPrepare message.
+		f.originExcluded = false
+	case *OriginMessage:
+		excluded := f.filter.Origin != nil && !f.filter.Origin(msg.Name)
+		if f.inStream {
+			if excluded {
+				f.excludedStreams[f.streamXid] = true
+			}
+		} else {
+			f.originExcluded = excluded
+		}
+	case *StreamStartMessageV2:
+		f.inStream, f.streamXid = true, msg.Xid
+	case *StreamStopMessageV2:
+		f.inStream = false
+	case *StreamCommitMessageV2:
+		delete(f.excludedStreams, msg.Xid)
+	case *StreamAbortMessageV2:
+		if msg.Xid == msg.SubXid {
+			delete(f.excludedStreams, msg.Xid)
+		}
+	case *StreamAbortMessageV4:
+		if msg.Xid == msg.SubXid {
+			delete(f.excludedStreams, msg.Xid)
+		}
+	case *StreamPrepareMessageV3:
+		delete(f.excludedStreams, msg.Xid)
+	}
+	return false
+}
+
+func (f *relationFilter) truncate(msg *TruncateMessage) bool {
+	ids := msg.RelationIDs[:0]
+	for _, id := range msg.RelationIDs {
+		if included, ok := f.included[id]; !ok || included {
+			ids = append(ids, id)
+		}
+	}
+	msg.RelationIDs = ids
+	msg.RelationNum = uint32(len(ids))
+	return len(ids) == 0
+}
@@ -0,0 +1,166 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeMessage(t *testing.T, msg pglogrepl.MessageEncoder) []byte {
+	data, err := msg.Encode(nil)
+	require.NoError(t, err)
+	return data
+}
+
+func TestMessageFilterMatchRelation(t *testing.T) {
+	filter := &pglogrepl.MessageFilter{
+		IncludeSchemas: []string{"public", "sales"},
+		ExcludeTables:  []string{"public.audit", "tmp"},
+	}
+	match := func(schema, table string) bool {
+		return filter.MatchRelation(&pglogrepl.RelationMessage{Namespace: schema, RelationName: table})
+	}
+	assert.True(t, match("public", "users"))
+	assert.True(t, match("sales", "audit"))
+	assert.False(t, match("public", "audit"))
+	assert.False(t, match("sales", "tmp"))
+	assert.False(t, match("private", "users"))
+
+	filter = &pglogrepl.MessageFilter{IncludeTables: []string{"public.users"}, Operations: []pglogrepl.MessageType{pglogrepl.MessageTypeInsert}}
+	assert.True(t, match("public", "users"))
+	assert.False(t, match("public", "orders"))
+	assert.True(t, filter.MatchOperation(pglogrepl.MessageTypeInsert))
+	assert.False(t, filter.MatchOperation(pglogrepl.MessageTypeDelete))
+}
+
+func TestReplicationStreamFilter(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{
+		ProtoVersion: 1,
+		Filter:       &pglogrepl.MessageFilter{ExcludeTables: []string{"public.audit"}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	users := &pglogrepl.RelationMessage{RelationID: 1, Namespace: "public", RelationName: "users"}
+	audit := &pglogrepl.RelationMessage{RelationID: 2, Namespace: "public", RelationName: "audit"}
+	ws.sendXLogData(0x200, encodeMessage(t, users))
+	ws.sendXLogData(0x210, encodeMessage(t, audit))
+	// The tuple of the excluded insert is invalid, so it fails if it is decoded.
+	ws.sendXLogData(0x220, []byte{'I', 0, 0, 0, 2, 'N', 0xff})
+	ws.sendXLogData(0x230, encodeMessage(t, &pglogrepl.TruncateMessage{RelationNum: 1, RelationIDs: []uint32{2}}))
+	ws.sendXLogData(0x240, encodeMessage(t, &pglogrepl.TruncateMessage{RelationNum: 2, RelationIDs: []uint32{1, 2}}))
+	ws.sendXLogData(0x250, insertMessageData(t, "foo"))
+
+	var msgs []pglogrepl.Message
+	for i := 0; i < 4; i++ {
+		rm, err := stream.Next(ctx)
+		require.NoError(t, err)
+		msgs = append(msgs, rm.Message)
+	}
+	require.IsType(t, &pglogrepl.RelationMessage{}, msgs[0])
+	require.IsType(t, &pglogrepl.RelationMessage{}, msgs[1])
+	truncate, ok := msgs[2].(*pglogrepl.TruncateMessage)
+	require.True(t, ok)
+	assert.Equal(t, []uint32{1}, truncate.RelationIDs)
+	insert, ok := msgs[3].(*pglogrepl.InsertMessage)
+	require.True(t, ok)
+	assert.Equal(t, uint32(1), insert.RelationID)
+	assert.Equal(t, pglogrepl.LSN(0x250), stream.ClientXLogPos())
+}
+
+func TestReplicationStreamFilterOrigin(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{
+		ProtoVersion: 2,
+		Filter: &pglogrepl.MessageFilter{Origin: func(name string) bool {
+			return name != "pg_remote"
+		}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	streamedInsert := func(xid uint32) []byte {
+		return encodeMessage(t, &pglogrepl.InsertMessageV2{
+			InsertMessage:            pglogrepl.InsertMessage{RelationID: 1, Tuple: &pglogrepl.TupleData{}},
+			InStreamMessageV2WithXid: pglogrepl.InStreamMessageV2WithXid{Xid: xid},
+		})
+	}
+	ws.sendXLogData(0x200, beginMessageData(0x240, 10))
+	ws.sendXLogData(0x210, encodeMessage(t, &pglogrepl.OriginMessage{CommitLSN: 0x100, Name: "pg_remote"}))
+	ws.sendXLogData(0x220, insertMessageData(t, "remote"))
+	ws.sendXLogData(0x240, commitMessageData(0x240, 0x250))
+	ws.sendXLogData(0x250, beginMessageData(0x270, 11))
+	ws.sendXLogData(0x260, insertMessageData(t, "local"))
+	ws.sendXLogData(0x270, commitMessageData(0x270, 0x280))
+	// A streamed transaction of the excluded origin is interleaved with one without origin.
+	ws.sendXLogData(0x280, encodeMessage(t, &pglogrepl.StreamStartMessageV2{Xid: 12, FirstSegment: 1}))
+	ws.sendXLogData(0x290, encodeMessage(t, &pglogrepl.OriginMessage{CommitLSN: 0x100, Name: "pg_remote"}))
+	ws.sendXLogData(0x2a0, streamedInsert(12))
+	ws.sendXLogData(0x2b0, encodeMessage(t, &pglogrepl.StreamStopMessageV2{}))
+	ws.sendXLogData(0x2c0, encodeMessage(t, &pglogrepl.StreamStartMessageV2{Xid: 13, FirstSegment: 1}))
+	ws.sendXLogData(0x2d0, streamedInsert(13))
+	ws.sendXLogData(0x2e0, encodeMessage(t, &pglogrepl.StreamStopMessageV2{}))
+
+	var types []pglogrepl.MessageType
+	var inserts []*pglogrepl.InsertMessageV2
+	for i := 0; i < 11; i++ {
+		rm, err := stream.Next(ctx)
+		require.NoError(t, err)
+		types = append(types, rm.Message.Type())
+		if insert, ok := rm.Message.(*pglogrepl.InsertMessageV2); ok {
+			inserts = append(inserts, insert)
+		}
+	}
+	assert.Equal(t, []pglogrepl.MessageType{
+		pglogrepl.MessageTypeBegin, pglogrepl.MessageTypeOrigin, pglogrepl.MessageTypeCommit,
+		pglogrepl.MessageTypeBegin, pglogrepl.MessageTypeInsert, pglogrepl.MessageTypeCommit,
+		pglogrepl.MessageTypeStreamStart, pglogrepl.MessageTypeOrigin, pglogrepl.MessageTypeStreamStop,
+		pglogrepl.MessageTypeStreamStart, pglogrepl.MessageTypeInsert,
+	}, types)
+	require.Len(t, inserts, 2)
+	assert.Equal(t, []byte("local"), inserts[0].Tuple.Columns[0].Data)
+	assert.Equal(t, uint32(13), inserts[1].Xid)
+}
+
+func TestReplicationStreamFilterOriginPrepared(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{
+		ProtoVersion: 3,
+		Filter: &pglogrepl.MessageFilter{Origin: func(name string) bool {
+			return name != "pg_remote"
+		}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A prepared transaction of the excluded origin is followed by one without origin.
+	ws.sendXLogData(0x200, encodeMessage(t, &pglogrepl.BeginPrepareMessageV3{PrepareLSN: 0x230, EndPrepareLSN: 0x240, Xid: 10, UserGID: "remote"}))
+	ws.sendXLogData(0x210, encodeMessage(t, &pglogrepl.OriginMessage{CommitLSN: 0x100, Name: "pg_remote"}))
+	ws.sendXLogData(0x220, insertMessageData(t, "remote"))
+	ws.sendXLogData(0x230, encodeMessage(t, &pglogrepl.PrepareMessageV3{PrepareLSN: 0x230, EndPrepareLSN: 0x240, Xid: 10, UserGID: "remote"}))
+	ws.sendXLogData(0x240, encodeMessage(t, &pglogrepl.BeginPrepareMessageV3{PrepareLSN: 0x260, EndPrepareLSN: 0x270, Xid: 11, UserGID: "local"}))
+	ws.sendXLogData(0x250, insertMessageData(t, "local"))
+	ws.sendXLogData(0x260, encodeMessage(t, &pglogrepl.PrepareMessageV3{PrepareLSN: 0x260, EndPrepareLSN: 0x270, Xid: 11, UserGID: "local"}))
+
+	var types []pglogrepl.MessageType
+	var inserts []*pglogrepl.InsertMessageV2
+	for i := 0; i < 6; i++ {
+		rm, err := stream.Next(ctx)
+		require.NoError(t, err)
+		types = append(types, rm.Message.Type())
+		if insert, ok := rm.Message.(*pglogrepl.InsertMessageV2); ok {
+			inserts = append(inserts, insert)
+		}
+	}
+	assert.Equal(t, []pglogrepl.MessageType{
+		pglogrepl.MessageTypeBeginPrepare, pglogrepl.MessageTypeOrigin, pglogrepl.MessageTypePrepare,
+		pglogrepl.MessageTypeBeginPrepare, pglogrepl.MessageTypeInsert, pglogrepl.MessageTypePrepare,
+	}, types)
+	require.Len(t, inserts, 1)
+	assert.Equal(t, []byte("local"), inserts[0].Tuple.Columns[0].Data)
+}
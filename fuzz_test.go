@@ -0,0 +1,118 @@
+package pglogrepl
+
+import (
+	"testing"
+	"time"
+)
+
+// fuzzSeedMessages are encoded pgoutput messages of every type, the seeds of the parser fuzz
+// targets beside the corpus in testdata/fuzz.
+func fuzzSeedMessages(t testing.TB) [][]byte {
+	commitTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tuple := &TupleData{ColumnNum: 3, Columns: []*TupleDataColumn{
+		{DataType: TupleDataTypeText, Length: 1, Data: []byte("1")},
+		{DataType: TupleDataTypeNull},
+		{DataType: TupleDataTypeToast},
+	}}
+	msgs := []MessageEncoder{
+		&BeginMessage{FinalLSN: 0x16B3748, CommitTime: commitTime, Xid: 731},
+		&CommitMessage{CommitLSN: 0x16B3748, TransactionEndLSN: 0x16B3778, CommitTime: commitTime},
+		&OriginMessage{CommitLSN: 0x16B3748, Name: "pg_16385"},
+		&RelationMessage{RelationID: 16384, Namespace: "public", RelationName: "t", ReplicaIdentity: ReplicaIdentityDefault, ColumnNum: 2, Columns: []*RelationMessageColumn{
+			{Flags: 1, Name: "id", DataType: 23, TypeModifier: -1},
+			{Name: "name", DataType: 25, TypeModifier: -1},
+		}},
+		&TypeMessage{DataType: 16390, Namespace: "public", Name: "mood"},
+		&InsertMessage{RelationID: 16384, Tuple: tuple},
+		&UpdateMessage{RelationID: 16384, OldTupleType: UpdateMessageTupleTypeKey, OldTuple: tuple, NewTuple: tuple},
+		&DeleteMessage{RelationID: 16384, OldTupleType: DeleteMessageTupleTypeOld, OldTuple: tuple},
+		&TruncateMessage{RelationNum: 2, Option: TruncateOptionCascade, RelationIDs: []uint32{16384, 16390}},
+		&LogicalDecodingMessage{LSN: 0x16B3748, Transactional: true, Prefix: "prefix", Content: []byte("content")},
+		&StreamStartMessageV2{Xid: 731, FirstSegment: 1},
+		&StreamStopMessageV2{},
+		&StreamCommitMessageV2{Xid: 731, CommitLSN: 0x16B3748, TransactionEndLSN: 0x16B3778, CommitTime: commitTime},
+		&StreamAbortMessageV2{Xid: 731, SubXid: 732},
+		&SequenceMessage{LSN: 0x16B3748, Namespace: "public", Name: "t_id_seq", LastValue: 33, LogCount: 32, IsCalled: true},
+	}
+	var seeds [][]byte
+	for _, msg := range msgs {
+		data, err := msg.Encode(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seeds = append(seeds, data)
+		// Truncated messages are the most common malformed input.
+		seeds = append(seeds, data[:len(data)/2])
+	}
+	return seeds
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range fuzzSeedMessages(f) {
+		f.Add(seed)
+	}
+	// Parse must return an error rather than panic on malformed input, and the messages it accepts
+	// must be encoded to data it accepts again.
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := Parse(data)
+		if err != nil {
+			return
+		}
+		encoded, err := msg.(MessageEncoder).Encode(nil)
+		if err != nil {
+			return
+		}
+		if _, err := Parse(encoded); err != nil {
+			t.Fatalf("failed to parse encoded %T: %v", msg, err)
+		}
+	})
+}
+
+func FuzzParseV2(f *testing.F) {
+	for _, seed := range fuzzSeedMessages(f) {
+		f.Add(seed, false)
+		f.Add(seed, true)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, inStream bool) {
+		_, _ = ParseV2(data, inStream)
+	})
+}
+
+func FuzzParseV3(f *testing.F) {
+	for _, seed := range fuzzSeedMessages(f) {
+		f.Add(seed, false)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, inStream bool) {
+		_, _ = ParseV3(data, inStream)
+	})
+}
+
+func FuzzParseV4(f *testing.F) {
+	for _, seed := range fuzzSeedMessages(f) {
+		f.Add(seed, false)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, inStream bool) {
+		_, _ = ParseV4(data, inStream)
+	})
+}
+
+func FuzzParsePrimaryKeepaliveMessage(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 0, 1, 0x6B, 0x37, 0x48, 0, 2, 0xB1, 0x5C, 0x2E, 0x81, 0xD4, 0x40, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = ParsePrimaryKeepaliveMessage(data)
+	})
+}
+
+func FuzzParseXLogData(f *testing.F) {
+	header := []byte{0, 0, 0, 0, 1, 0x6B, 0x37, 0x48, 0, 0, 0, 0, 1, 0x6B, 0x37, 0x78, 0, 2, 0xB1, 0x5C, 0x2E, 0x81, 0xD4, 0x40}
+	for _, seed := range fuzzSeedMessages(f) {
+		f.Add(append(append([]byte(nil), header...), seed...))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		xld, err := ParseXLogData(data)
+		if err != nil {
+			return
+		}
+		_, _ = Parse(xld.WALData)
+	})
+}
@@ -0,0 +1,136 @@
+package pglogrepl
+
+import (
+	"strconv"
+)
+
+// XID is a PostgreSQL transaction ID.
+//
+// The message and result fields holding IDs keep their integer types so that existing code
+// continues to compile. The types are returned by accessors instead, such as BeginMessage.XID and
+// InsertMessage.RelationOID, so that functions of consumer code taking several IDs can be
+// declared with distinct parameter types.
+type XID uint32
+
+// The special transaction IDs. Normal transaction IDs start at FirstNormalXID.
+const (
+	InvalidXID     XID = 0
+	BootstrapXID   XID = 1
+	FrozenXID      XID = 2
+	FirstNormalXID XID = 3
+)
+
+// String returns the decimal representation of the transaction ID.
+func (xid XID) String() string {
+	return strconv.FormatUint(uint64(xid), 10)
+}
+
+// IsNormal reports whether xid is an ordinary transaction ID rather than a special one.
+func (xid XID) IsNormal() bool {
+	return xid >= FirstNormalXID
+}
+
+// Precedes reports whether xid is logically before other. Transaction IDs wrap around, so normal
+// IDs are compared modulo 2^32 like PostgreSQL's TransactionIdPrecedes does: an ID precedes the
+// 2^31 IDs following it. Special IDs precede all normal IDs.
+func (xid XID) Precedes(other XID) bool {
+	if !xid.IsNormal() || !other.IsNormal() {
+		return xid < other
+	}
+	return int32(xid-other) < 0
+}
+
+// Follows reports whether xid is logically after other, see Precedes.
+func (xid XID) Follows(other XID) bool {
+	return other.Precedes(xid)
+}
+
+// TimelineID is a PostgreSQL timeline ID.
+type TimelineID uint32
+
+// String returns the decimal representation of the timeline ID.
+func (tli TimelineID) String() string {
+	return strconv.FormatUint(uint64(tli), 10)
+}
+
+// OID is a PostgreSQL object ID, such as the ID of a relation or a data type.
+type OID uint32
+
+// InvalidOID is the OID of no object.
+const InvalidOID OID = 0
+
+// String returns the decimal representation of the OID.
+func (oid OID) String() string {
+	return strconv.FormatUint(uint64(oid), 10)
+}
+
+// IsValid reports whether oid is not InvalidOID.
+func (oid OID) IsValid() bool {
+	return oid != InvalidOID
+}
+
+// XID returns the Xid of the transaction.
+func (m *BeginMessage) XID() XID { return XID(m.Xid) }
+
+// XID returns the Xid of the transaction.
+func (m *StreamStartMessageV2) XID() XID { return XID(m.Xid) }
+
+// XID returns the Xid of the transaction.
+func (m *StreamCommitMessageV2) XID() XID { return XID(m.Xid) }
+
+// XID returns the Xid of the transaction.
+func (m *StreamAbortMessageV2) XID() XID { return XID(m.Xid) }
+
+// SubXID returns the SubXid of the aborted subtransaction.
+func (m *StreamAbortMessageV2) SubXID() XID { return XID(m.SubXid) }
+
+// XID returns the Xid of the streamed transaction, or InvalidXID outside of a stream.
+func (m *InStreamMessageV2WithXid) XID() XID { return XID(m.Xid) }
+
+// XID returns the Xid of the transaction.
+func (m *BeginPrepareMessageV3) XID() XID { return XID(m.Xid) }
+
+// XID returns the Xid of the transaction.
+func (m *PrepareMessageV3) XID() XID { return XID(m.Xid) }
+
+// XID returns the Xid of the transaction.
+func (m *CommitPreparedMessageV3) XID() XID { return XID(m.Xid) }
+
+// XID returns the Xid of the transaction.
+func (m *RollbackPreparedMessageV3) XID() XID { return XID(m.Xid) }
+
+// XID returns the Xid of the transaction.
+func (m *StreamPrepareMessageV3) XID() XID { return XID(m.Xid) }
+
+// XID returns the Xid of the transaction.
+func (tx *Transaction) XID() XID { return XID(tx.Xid) }
+
+// RelationOID returns the RelationID of the relation.
+func (m *RelationMessage) RelationOID() OID { return OID(m.RelationID) }
+
+// RelationOID returns the RelationID of the inserted relation.
+func (m *InsertMessage) RelationOID() OID { return OID(m.RelationID) }
+
+// RelationOID returns the RelationID of the updated relation.
+func (m *UpdateMessage) RelationOID() OID { return OID(m.RelationID) }
+
+// RelationOID returns the RelationID of the relation deleted from.
+func (m *DeleteMessage) RelationOID() OID { return OID(m.RelationID) }
+
+// RelationOIDs returns the RelationIDs of the truncated relations.
+func (m *TruncateMessage) RelationOIDs() []OID {
+	oids := make([]OID, len(m.RelationIDs))
+	for i, id := range m.RelationIDs {
+		oids[i] = OID(id)
+	}
+	return oids
+}
+
+// TypeOID returns the DataType of the column.
+func (c *RelationMessageColumn) TypeOID() OID { return OID(c.DataType) }
+
+// TypeOID returns the DataType of the type.
+func (m *TypeMessage) TypeOID() OID { return OID(m.DataType) }
+
+// TimelineID returns the Timeline of the server.
+func (isr IdentifySystemResult) TimelineID() TimelineID { return TimelineID(isr.Timeline) }
@@ -0,0 +1,51 @@
+package pglogrepl_test
+
+import (
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXIDPrecedes(t *testing.T) {
+	assert.True(t, pglogrepl.XID(100).Precedes(200))
+	assert.False(t, pglogrepl.XID(200).Precedes(100))
+	assert.False(t, pglogrepl.XID(100).Precedes(100))
+
+	// Transaction IDs wrap around after 2^32.
+	assert.True(t, pglogrepl.XID(0xFFFFFFF0).Precedes(10))
+	assert.True(t, pglogrepl.XID(10).Follows(0xFFFFFFF0))
+
+	// Special transaction IDs precede all normal ones.
+	assert.True(t, pglogrepl.FrozenXID.Precedes(0xFFFFFFF0))
+	assert.False(t, pglogrepl.FrozenXID.IsNormal())
+	assert.True(t, pglogrepl.FirstNormalXID.IsNormal())
+}
+
+func TestIDStrings(t *testing.T) {
+	assert.Equal(t, "4242", pglogrepl.XID(4242).String())
+	assert.Equal(t, "3", pglogrepl.TimelineID(3).String())
+	assert.Equal(t, "16384", pglogrepl.OID(16384).String())
+	assert.False(t, pglogrepl.InvalidOID.IsValid())
+}
+
+func TestIDAccessors(t *testing.T) {
+	begin := &pglogrepl.BeginMessage{Xid: 42}
+	assert.Equal(t, pglogrepl.XID(42), begin.XID())
+
+	insert := &pglogrepl.InsertMessageV2{
+		InsertMessage:            pglogrepl.InsertMessage{RelationID: 16384},
+		InStreamMessageV2WithXid: pglogrepl.InStreamMessageV2WithXid{Xid: 43},
+	}
+	assert.Equal(t, pglogrepl.OID(16384), insert.RelationOID())
+	assert.Equal(t, pglogrepl.XID(43), insert.XID())
+
+	truncate := &pglogrepl.TruncateMessage{RelationIDs: []uint32{16384, 16385}}
+	assert.Equal(t, []pglogrepl.OID{16384, 16385}, truncate.RelationOIDs())
+
+	abort := &pglogrepl.StreamAbortMessageV2{Xid: 44, SubXid: 45}
+	assert.Equal(t, pglogrepl.XID(45), abort.SubXID())
+
+	isr := pglogrepl.IdentifySystemResult{Timeline: 2}
+	assert.Equal(t, pglogrepl.TimelineID(2), isr.TimelineID())
+}
@@ -0,0 +1,172 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// InitialCopyTable is a table copied by InitialCopy.
+type InitialCopyTable struct {
+	// Name is the optionally schema qualified name of the table, such as "public.users".
+	Name string
+	// Columns are the columns to copy. If it is empty all columns are copied.
+	Columns []string
+	// Where, if set, is an SQL condition restricting the rows to copy.
+	Where string
+}
+
+// InitialCopyProgress is the progress of the copy of a table.
+type InitialCopyProgress struct {
+	Table string
+	// Bytes is the amount of COPY data received so far.
+	Bytes int64
+	// Rows is the number of rows copied. It is only known once Done is true.
+	Rows int64
+	Done bool
+}
+
+// InitialCopyOptions configures InitialCopy.
+type InitialCopyOptions struct {
+	// SlotName is the name of the logical replication slot to create. It is required.
+	SlotName string
+	// OutputPlugin is the output plugin of the slot. If it is empty "pgoutput" is used.
+	OutputPlugin string
+	// TemporarySlot creates the slot as a temporary slot, which is dropped when the replication
+	// connection is closed.
+	TemporarySlot bool
+
+	// Tables are the tables to copy, in order.
+	Tables []InitialCopyTable
+	// Format is the COPY format: "text", "csv" or "binary". If it is empty "text" is used.
+	Format string
+	// Writer returns the destination of the COPY data of a table. If the returned writer is an
+	// io.Closer it is closed once the table is copied. It is required.
+	Writer func(ctx context.Context, table InitialCopyTable) (io.Writer, error)
+
+	// OnProgress, if set, is called when the copy of a table starts and ends and at most every
+	// ProgressInterval in between.
+	OnProgress func(progress InitialCopyProgress)
+	// ProgressInterval is the minimum interval between progress reports of a table. If it is 0
+	// then 1 second is used.
+	ProgressInterval time.Duration
+}
+
+// InitialCopyResult is the result of InitialCopy.
+type InitialCopyResult struct {
+	// Slot is the result of the creation of the slot.
+	Slot CreateReplicationSlotResult
+	// StartLSN is the consistent point of the slot, the position to start streaming from so that
+	// every change after the copy is received exactly once.
+	StartLSN LSN
+	// Tables are the final progress of every copied table.
+	Tables []InitialCopyProgress
+}
+
+const defaultInitialCopyProgressInterval = time.Second
+
+// InitialCopy creates a logical replication slot on replConn exporting its snapshot and copies
+// the tables through conn, a regular connection to the same database, under that snapshot. This
+// is the initial synchronization of CREATE SUBSCRIPTION: once it returns, streaming replConn from
+// the slot at the result's StartLSN, for example with StartReplicationStream or a Subscription,
+// continues exactly where the copy ended.
+//
+// replConn must not be used between the creation of the slot and the end of the copy, as that
+// would release the exported snapshot.
+func InitialCopy(ctx context.Context, replConn, conn *pgconn.PgConn, options InitialCopyOptions) (InitialCopyResult, error) {
+	var result InitialCopyResult
+	if options.SlotName == "" {
+		return result, fmt.Errorf("initial copy has no slot name")
+	}
+	if options.Writer == nil {
+		return result, fmt.Errorf("initial copy has no writer")
+	}
+	if options.OutputPlugin == "" {
+		options.OutputPlugin = "pgoutput"
+	}
+	if options.ProgressInterval <= 0 {
+		options.ProgressInterval = defaultInitialCopyProgressInterval
+	}
+
+	slot, err := CreateReplicationSlot(ctx, replConn, options.SlotName, options.OutputPlugin, CreateReplicationSlotOptions{
+		Temporary:      options.TemporarySlot,
+		SnapshotAction: SnapshotActionExport,
+		Mode:           LogicalReplication,
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to create replication slot: %w", err)
+	}
+	result.Slot = slot
+	result.StartLSN, err = ParseLSN(slot.ConsistentPoint)
+	if err != nil {
+		return result, fmt.Errorf("failed to parse consistent point: %w", err)
+	}
+
+	tx, err := BeginSnapshot(ctx, conn, slot.SnapshotName)
+	if err != nil {
+		return result, err
+	}
+	for _, table := range options.Tables {
+		progress, err := copyInitialTable(ctx, tx, table, options)
+		if err != nil {
+			tx.conn.Exec(ctx, "ROLLBACK").ReadAll()
+			return result, err
+		}
+		result.Tables = append(result.Tables, progress)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return result, fmt.Errorf("failed to commit snapshot transaction: %w", err)
+	}
+	return result, nil
+}
+
+func copyInitialTable(ctx context.Context, tx *SnapshotTx, table InitialCopyTable, options InitialCopyOptions) (InitialCopyProgress, error) {
+	w, err := options.Writer(ctx, table)
+	if err != nil {
+		return InitialCopyProgress{}, fmt.Errorf("failed to open writer for %s: %w", table.Name, err)
+	}
+	pw := &progressWriter{w: w, options: options, progress: InitialCopyProgress{Table: table.Name}}
+	pw.report()
+
+	rows, err := tx.CopyTable(ctx, pw, table.Name, CopyTableOptions{Columns: table.Columns, Where: table.Where, Format: options.Format})
+	if closer, ok := w.(io.Closer); ok {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close writer for %s: %w", table.Name, closeErr)
+		}
+	}
+	if err != nil {
+		return pw.progress, err
+	}
+
+	pw.progress.Rows = rows
+	pw.progress.Done = true
+	pw.report()
+	return pw.progress, nil
+}
+
+// progressWriter counts the bytes written to w and reports the progress.
+type progressWriter struct {
+	w          io.Writer
+	options    InitialCopyOptions
+	progress   InitialCopyProgress
+	nextReport time.Time
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.progress.Bytes += int64(n)
+	if !time.Now().Before(pw.nextReport) {
+		pw.report()
+	}
+	return n, err
+}
+
+func (pw *progressWriter) report() {
+	if pw.options.OnProgress != nil {
+		pw.options.OnProgress(pw.progress)
+	}
+	pw.nextReport = time.Now().Add(pw.options.ProgressInterval)
+}
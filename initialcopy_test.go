@@ -0,0 +1,89 @@
+package pglogrepl_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func copyResponse(rows ...string) []pgproto3.BackendMessage {
+	msgs := []pgproto3.BackendMessage{&pgproto3.CopyOutResponse{}}
+	for _, row := range rows {
+		msgs = append(msgs, &pgproto3.CopyData{Data: []byte(row)})
+	}
+	return append(msgs,
+		&pgproto3.CopyDone{},
+		&pgproto3.CommandComplete{CommandTag: []byte("COPY " + strconv.Itoa(len(rows)))},
+		&pgproto3.ReadyForQuery{TxStatus: 'T'},
+	)
+}
+
+func TestInitialCopy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	replConn, replWS := newFakeWalSender(t)
+	conn, ws := newFakeWalSender(t)
+
+	slotQueries := replWS.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("slot_name")}, {Name: []byte("consistent_point")}, {Name: []byte("snapshot_name")}, {Name: []byte("output_plugin")}}},
+		&pgproto3.DataRow{Values: [][]byte{[]byte(slotName), []byte("0/1500"), []byte("00000003-00000002-1"), []byte("pgoutput")}},
+		&pgproto3.CommandComplete{CommandTag: []byte("CREATE_REPLICATION_SLOT")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	queries := make(chan string, 4)
+	go func() {
+		for _, response := range [][]pgproto3.BackendMessage{
+			{&pgproto3.CommandComplete{CommandTag: []byte("BEGIN")}, &pgproto3.CommandComplete{CommandTag: []byte("SET")}, &pgproto3.ReadyForQuery{TxStatus: 'T'}},
+			copyResponse("1\tfoo\n", "2\tbar\n"),
+			copyResponse("3\n"),
+			{&pgproto3.CommandComplete{CommandTag: []byte("COMMIT")}, &pgproto3.ReadyForQuery{TxStatus: 'I'}},
+		} {
+			queries <- <-ws.serveQuery(response)
+		}
+	}()
+
+	outputs := map[string]*bytes.Buffer{}
+	var progress []pglogrepl.InitialCopyProgress
+	result, err := pglogrepl.InitialCopy(ctx, replConn, conn, pglogrepl.InitialCopyOptions{
+		SlotName: slotName,
+		Tables: []pglogrepl.InitialCopyTable{
+			{Name: "public.users"},
+			{Name: "public.orders", Columns: []string{"id"}, Where: "tenant_id = 42"},
+		},
+		Writer: func(ctx context.Context, table pglogrepl.InitialCopyTable) (io.Writer, error) {
+			outputs[table.Name] = &bytes.Buffer{}
+			return outputs[table.Name], nil
+		},
+		OnProgress: func(p pglogrepl.InitialCopyProgress) { progress = append(progress, p) },
+		// Only the start and end of a table are reported.
+		ProgressInterval: time.Hour,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "CREATE_REPLICATION_SLOT "+slotName+" LOGICAL pgoutput (SNAPSHOT 'export')", <-slotQueries)
+	assert.Equal(t, "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY; SET TRANSACTION SNAPSHOT '00000003-00000002-1'", <-queries)
+	assert.Equal(t, `COPY "public"."users" TO STDOUT`, <-queries)
+	assert.Equal(t, `COPY (SELECT "id" FROM "public"."orders" WHERE tenant_id = 42) TO STDOUT`, <-queries)
+	assert.Equal(t, "COMMIT", <-queries)
+
+	assert.Equal(t, pglogrepl.LSN(0x1500), result.StartLSN)
+	assert.Equal(t, "00000003-00000002-1", result.Slot.SnapshotName)
+	assert.Equal(t, "1\tfoo\n2\tbar\n", outputs["public.users"].String())
+	assert.Equal(t, "3\n", outputs["public.orders"].String())
+	assert.Equal(t, []pglogrepl.InitialCopyProgress{
+		{Table: "public.users"},
+		{Table: "public.users", Bytes: 12, Rows: 2, Done: true},
+		{Table: "public.orders"},
+		{Table: "public.orders", Bytes: 2, Rows: 1, Done: true},
+	}, progress)
+	assert.Equal(t, progress[1:2], result.Tables[:1])
+}
@@ -0,0 +1,137 @@
+// Package protowire implements the parts of the protocol buffers wire format the protobuf and
+// decoderbufs packages need, so that they do not depend on a protobuf library.
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Type is the wire type of a field.
+type Type int
+
+// List of wire types.
+const (
+	VarintType  Type = 0
+	Fixed64Type Type = 1
+	BytesType   Type = 2
+	Fixed32Type Type = 5
+)
+
+// AppendTag appends the tag of field num of wire type typ.
+func AppendTag(b []byte, num int, typ Type) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(typ))
+}
+
+// AppendVarint appends v as a varint.
+func AppendVarint(b []byte, v uint64) []byte {
+	return binary.AppendUvarint(b, v)
+}
+
+// AppendBytes appends data prefixed by its length.
+func AppendBytes(b []byte, data []byte) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(data))), data...)
+}
+
+// AppendString appends s prefixed by its length.
+func AppendString(b []byte, s string) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+}
+
+// AppendDouble appends f as a fixed 64 bit value.
+func AppendDouble(b []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(f))
+}
+
+// Reader reads the fields of an encoded message.
+type Reader struct {
+	buf []byte
+}
+
+// NewReader returns a Reader of the message data.
+func NewReader(data []byte) *Reader {
+	return &Reader{buf: data}
+}
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// Done reports whether all fields were read.
+func (r *Reader) Done() bool {
+	return len(r.buf) == 0
+}
+
+// Next reads the tag of the next field.
+func (r *Reader) Next() (int, Type, error) {
+	tag, err := r.Varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	if tag>>3 == 0 || tag>>3 > math.MaxInt32 {
+		return 0, 0, fmt.Errorf("invalid protobuf field number %d", tag>>3)
+	}
+	return int(tag >> 3), Type(tag & 7), nil
+}
+
+// Varint reads a varint value.
+func (r *Reader) Varint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.buf = r.buf[n:]
+	return v, nil
+}
+
+// Bytes reads a length-delimited value. The returned slice aliases the message data.
+func (r *Reader) Bytes() ([]byte, error) {
+	n, err := r.Varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.buf)) {
+		return nil, errTruncated
+	}
+	data := r.buf[:n]
+	r.buf = r.buf[n:]
+	return data, nil
+}
+
+// Fixed64 reads a fixed 64 bit value.
+func (r *Reader) Fixed64() (uint64, error) {
+	if len(r.buf) < 8 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint64(r.buf)
+	r.buf = r.buf[8:]
+	return v, nil
+}
+
+// Fixed32 reads a fixed 32 bit value.
+func (r *Reader) Fixed32() (uint32, error) {
+	if len(r.buf) < 4 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v, nil
+}
+
+// Skip skips the value of a field of wire type typ.
+func (r *Reader) Skip(typ Type) error {
+	var err error
+	switch typ {
+	case VarintType:
+		_, err = r.Varint()
+	case Fixed64Type:
+		_, err = r.Fixed64()
+	case BytesType:
+		_, err = r.Bytes()
+	case Fixed32Type:
+		_, err = r.Fixed32()
+	default:
+		err = fmt.Errorf("unsupported protobuf wire type %d", typ)
+	}
+	return err
+}
@@ -0,0 +1,169 @@
+// Package kafkasink publishes the changes of a logical replication stream to Kafka.
+//
+// Every committed transaction is converted into Debezium-style change events, see package
+// debezium, which are published to a topic per table keyed by the replica identity of the row.
+// The sink does not depend on a Kafka client library: the events are handed to a Producer, which
+// is typically a thin wrapper around the WriteMessages method of a kafka-go Writer or a
+// synchronous franz-go or sarama producer.
+//
+// Delivery is at least once. The end position of a transaction is only confirmed to the server
+// once the producer has acknowledged all of its events, so after a crash the unconfirmed
+// transactions are replicated and published again; the LSN header allows consumers to detect the
+// duplicates.
+package kafkasink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/debezium"
+)
+
+// LSNHeader is the header holding the commit LSN of the transaction of a message.
+const LSNHeader = "pglogrepl.lsn"
+
+// Header is a Kafka message header.
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Message is a Kafka message.
+type Message struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers []Header
+}
+
+// Producer publishes messages to Kafka.
+type Producer interface {
+	// WriteMessages publishes msgs in order. It must only return nil once all messages have been
+	// acknowledged by the brokers.
+	WriteMessages(ctx context.Context, msgs ...Message) error
+}
+
+// Options configures a Sink.
+type Options struct {
+	// Producer publishes the messages. It is required.
+	Producer Producer
+	// Encoder converts the changes into events. If it is nil an encoder with default options is
+	// used.
+	Encoder *debezium.Encoder
+	// Topic returns the topic of an event. If it is nil the Debezium topic name
+	// <server name>.<schema>.<table> is used.
+	Topic func(event *debezium.Event) string
+
+	// BatchSize is the number of buffered messages above which they are published. If it is 0
+	// then 1000 is used.
+	BatchSize int
+	// FlushInterval is the maximum time Run buffers messages before publishing them. If it is 0
+	// then 1 second is used.
+	FlushInterval time.Duration
+
+	// TransactionAssemblerOptions configures the buffering of streamed transactions.
+	TransactionAssemblerOptions pglogrepl.TransactionAssemblerOptions
+}
+
+const (
+	defaultBatchSize     = 1000
+	defaultFlushInterval = time.Second
+)
+
+// Sink buffers the changes of committed transactions as Kafka messages and publishes them.
+type Sink struct {
+	options   Options
+	assembler *pglogrepl.TransactionAssembler
+
+	pending    []Message
+	pendingLSN pglogrepl.LSN
+	flushedLSN pglogrepl.LSN
+}
+
+// New returns a new Sink.
+func New(options Options) *Sink {
+	if options.Encoder == nil {
+		options.Encoder = debezium.NewEncoder(debezium.EncoderOptions{})
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultBatchSize
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = defaultFlushInterval
+	}
+	return &Sink{options: options, assembler: pglogrepl.NewTransactionAssembler(options.TransactionAssemblerOptions)}
+}
+
+// WriteChange implements pglogrepl.Sink. It adds msg to the transaction in progress and buffers
+// the events of the transaction once msg commits it. The buffered messages are published when
+// there are more than BatchSize of them.
+func (s *Sink) WriteChange(ctx context.Context, msg *pglogrepl.ReplicationMessage) error {
+	if msg.Message == nil {
+		return fmt.Errorf("stream does not decode pgoutput messages")
+	}
+	tx, err := s.assembler.Add(msg.Message)
+	if err != nil || tx == nil {
+		return err
+	}
+
+	batch := []pglogrepl.Message{&pglogrepl.BeginMessage{FinalLSN: tx.CommitLSN, CommitTime: tx.CommitTime, Xid: tx.Xid}}
+	batch = append(batch, tx.Changes...)
+	lsnHeader := Header{Key: LSNHeader, Value: []byte(tx.CommitLSN.String())}
+	for _, change := range batch {
+		events, err := s.options.Encoder.Encode(tx.CommitLSN, change)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			key, err := event.KeyJSON()
+			if err != nil {
+				return fmt.Errorf("failed to encode event key: %w", err)
+			}
+			value, err := event.ValueJSON()
+			if err != nil {
+				return fmt.Errorf("failed to encode event value: %w", err)
+			}
+			topic := event.Topic
+			if s.options.Topic != nil {
+				topic = s.options.Topic(event)
+			}
+			s.pending = append(s.pending, Message{Topic: topic, Key: key, Value: value, Headers: []Header{lsnHeader}})
+		}
+	}
+	s.pendingLSN = tx.EndLSN
+	if len(s.pending) >= s.options.BatchSize {
+		_, err := s.Flush(ctx)
+		return err
+	}
+	return nil
+}
+
+// Flush implements pglogrepl.Sink. It publishes the buffered messages and returns the end
+// position of the last transaction whose messages have all been acknowledged.
+func (s *Sink) Flush(ctx context.Context) (pglogrepl.LSN, error) {
+	if len(s.pending) > 0 {
+		if err := s.options.Producer.WriteMessages(ctx, s.pending...); err != nil {
+			return s.flushedLSN, fmt.Errorf("failed to publish messages: %w", err)
+		}
+		s.pending = s.pending[:0]
+	}
+	if s.pendingLSN > s.flushedLSN {
+		s.flushedLSN = s.pendingLSN
+	}
+	return s.flushedLSN, nil
+}
+
+// Close removes the spill files of the streamed transactions in progress.
+func (s *Sink) Close() error {
+	return s.assembler.Close()
+}
+
+// Run publishes the changes received from stream with pglogrepl.RunSink until ctx is canceled or
+// an error occurs. The stream must decode pgoutput messages.
+func (s *Sink) Run(ctx context.Context, stream *pglogrepl.ReplicationStream) error {
+	return pglogrepl.RunSink(ctx, stream, s, pglogrepl.RunSinkOptions{FlushInterval: s.options.FlushInterval})
+}
+
+var _ pglogrepl.Sink = (*Sink)(nil)
@@ -0,0 +1,85 @@
+package kafkasink_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/kafkasink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProducer struct {
+	err  error
+	msgs []kafkasink.Message
+}
+
+func (p *fakeProducer) WriteMessages(_ context.Context, msgs ...kafkasink.Message) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.msgs = append(p.msgs, msgs...)
+	return nil
+}
+
+func writeTransaction(t *testing.T, sink *kafkasink.Sink, xid uint32, commitLSN, endLSN pglogrepl.LSN) {
+	for _, msg := range []pglogrepl.Message{
+		&pglogrepl.BeginMessage{FinalLSN: commitLSN, Xid: xid},
+		&pglogrepl.RelationMessage{
+			RelationID:   1,
+			Namespace:    "public",
+			RelationName: "t",
+			Columns:      []*pglogrepl.RelationMessageColumn{{Flags: 1, Name: "id", DataType: 23}},
+		},
+		&pglogrepl.InsertMessage{RelationID: 1, Tuple: &pglogrepl.TupleData{ColumnNum: 1, Columns: []*pglogrepl.TupleDataColumn{
+			{DataType: pglogrepl.TupleDataTypeText, Length: 1, Data: []byte("1")},
+		}}},
+		&pglogrepl.CommitMessage{CommitLSN: commitLSN, TransactionEndLSN: endLSN},
+	} {
+		require.NoError(t, sink.WriteChange(context.Background(), &pglogrepl.ReplicationMessage{Message: msg}))
+	}
+}
+
+func TestSink(t *testing.T) {
+	producer := &fakeProducer{}
+	sink := kafkasink.New(kafkasink.Options{Producer: producer})
+	defer sink.Close()
+
+	writeTransaction(t, sink, 42, 0x300, 0x310)
+	assert.Empty(t, producer.msgs)
+
+	lsn, err := sink.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x310), lsn)
+	require.Len(t, producer.msgs, 1)
+	msg := producer.msgs[0]
+	assert.Equal(t, ".public.t", msg.Topic)
+	assert.JSONEq(t, `{"id": 1}`, string(msg.Key))
+	assert.Contains(t, string(msg.Value), `"op":"c"`)
+	assert.Equal(t, []kafkasink.Header{{Key: kafkasink.LSNHeader, Value: []byte("0/300")}}, msg.Headers)
+}
+
+func TestSinkFlushError(t *testing.T) {
+	producer := &fakeProducer{}
+	sink := kafkasink.New(kafkasink.Options{Producer: producer})
+	defer sink.Close()
+
+	writeTransaction(t, sink, 42, 0x300, 0x310)
+	_, err := sink.Flush(context.Background())
+	require.NoError(t, err)
+
+	// The second transaction is not confirmed until it has been published.
+	writeTransaction(t, sink, 43, 0x400, 0x410)
+	producer.err = errors.New("broker unavailable")
+	lsn, err := sink.Flush(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x310), lsn)
+
+	producer.err = nil
+	lsn, err = sink.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x410), lsn)
+	assert.Len(t, producer.msgs, 2)
+}
@@ -0,0 +1,167 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SlotLag is the lag of a replication slot measured by a LagMonitor.
+type SlotLag struct {
+	// Slot is the state of the slot.
+	Slot ReplicationSlotInfo
+	// CurrentLSN is the WAL write position of the server when the slot was read.
+	CurrentLSN LSN
+	// Bytes is the amount of WAL between the confirmed position of the slot and CurrentLSN.
+	Bytes uint64
+	// Time is the approximate time since the server wrote the WAL at the confirmed position of
+	// the slot. It is estimated from the WAL positions sampled by the monitor, so it is never
+	// more precise than the sampling interval and is a lower bound until the monitor has sampled
+	// the position the slot is at.
+	Time time.Duration
+	// Exceeded reports whether Bytes or Time is above the thresholds of the monitor.
+	Exceeded bool
+	// MeasuredAt is the time of the measurement.
+	MeasuredAt time.Time
+}
+
+// LagMonitorOptions configures a LagMonitor.
+type LagMonitorOptions struct {
+	// SlotName is the name of the monitored slot. It is required.
+	SlotName string
+	// Interval is the interval between measurements of Run. If it is 0 then 10 seconds is used.
+	Interval time.Duration
+
+	// MaxBytes and MaxTime are the thresholds above which the lag is exceeded. A threshold of 0
+	// is not checked.
+	MaxBytes uint64
+	MaxTime  time.Duration
+
+	// OnLag, if set, is called with every measurement.
+	OnLag func(lag SlotLag)
+	// OnExceeded is called when the lag goes above a threshold and OnRecovered when it goes back
+	// below all thresholds.
+	OnExceeded  func(lag SlotLag)
+	OnRecovered func(lag SlotLag)
+	// OnError, if set, is called when a measurement of Run fails, and Run continues. Otherwise
+	// Run returns the error.
+	OnError func(err error)
+}
+
+const defaultLagMonitorInterval = 10 * time.Second
+
+// LagMonitor periodically measures how far the consumer of a replication slot is behind the
+// server, in bytes of WAL (pg_current_wal_lsn - confirmed_flush_lsn) and in time, and reports when
+// the lag crosses thresholds so that alerts can be wired directly from the replication client.
+//
+// A LagMonitor uses a regular connection to the primary, not the replication connection.
+// Measure and Run must not be called concurrently, but Last is safe for concurrent use.
+type LagMonitor struct {
+	conn    *pgconn.PgConn
+	options LagMonitorOptions
+
+	// samples are WAL positions of the server at increasing times, from the latest one at or
+	// before the confirmed position of the slot.
+	samples  []walSample
+	exceeded bool
+
+	mu   sync.Mutex
+	last SlotLag
+}
+
+type walSample struct {
+	lsn LSN
+	at  time.Time
+}
+
+// NewLagMonitor returns a LagMonitor measuring the lag of a slot through conn.
+func NewLagMonitor(conn *pgconn.PgConn, options LagMonitorOptions) (*LagMonitor, error) {
+	if options.SlotName == "" {
+		return nil, fmt.Errorf("lag monitor has no slot name")
+	}
+	if options.Interval <= 0 {
+		options.Interval = defaultLagMonitorInterval
+	}
+	return &LagMonitor{conn: conn, options: options}, nil
+}
+
+// Measure measures the lag once and calls the callbacks.
+func (m *LagMonitor) Measure(ctx context.Context) (SlotLag, error) {
+	slot, err := ReadReplicationSlotInfo(ctx, m.conn, m.options.SlotName)
+	if err != nil {
+		return SlotLag{}, err
+	}
+	current, err := CurrentWALLSN(ctx, m.conn)
+	if err != nil {
+		return SlotLag{}, err
+	}
+	return m.record(slot, current, time.Now()), nil
+}
+
+func (m *LagMonitor) record(slot ReplicationSlotInfo, current LSN, now time.Time) SlotLag {
+	lag := SlotLag{Slot: slot, CurrentLSN: current, Bytes: slot.Lag(current), MeasuredAt: now}
+
+	m.samples = append(m.samples, walSample{lsn: current, at: now})
+	confirmed := current - LSN(lag.Bytes)
+	// Keep the latest sample at or before the confirmed position, which dates it.
+	i := 0
+	for i+1 < len(m.samples) && m.samples[i+1].lsn <= confirmed {
+		i++
+	}
+	m.samples = append(m.samples[:0], m.samples[i:]...)
+	if lag.Bytes > 0 {
+		lag.Time = now.Sub(m.samples[0].at)
+	}
+
+	lag.Exceeded = (m.options.MaxBytes > 0 && lag.Bytes > m.options.MaxBytes) ||
+		(m.options.MaxTime > 0 && lag.Time > m.options.MaxTime)
+
+	m.mu.Lock()
+	m.last = lag
+	m.mu.Unlock()
+
+	if m.options.OnLag != nil {
+		m.options.OnLag(lag)
+	}
+	if lag.Exceeded && !m.exceeded && m.options.OnExceeded != nil {
+		m.options.OnExceeded(lag)
+	}
+	if !lag.Exceeded && m.exceeded && m.options.OnRecovered != nil {
+		m.options.OnRecovered(lag)
+	}
+	m.exceeded = lag.Exceeded
+	return lag
+}
+
+// Last returns the last measurement. It is the zero SlotLag if the lag has not been measured.
+func (m *LagMonitor) Last() SlotLag {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last
+}
+
+// Run measures the lag every Interval until ctx is done, and then returns the error of the
+// context.
+func (m *LagMonitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.options.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := m.Measure(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if m.options.OnError == nil {
+				return err
+			}
+			m.options.OnError(err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
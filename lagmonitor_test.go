@@ -0,0 +1,52 @@
+package pglogrepl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLagMonitorRecord(t *testing.T) {
+	var exceeded, recovered []SlotLag
+	m, err := NewLagMonitor(nil, LagMonitorOptions{
+		SlotName:    "slot",
+		MaxBytes:    0x1000,
+		MaxTime:     time.Minute,
+		OnExceeded:  func(lag SlotLag) { exceeded = append(exceeded, lag) },
+		OnRecovered: func(lag SlotLag) { recovered = append(recovered, lag) },
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	slot := ReplicationSlotInfo{SlotType: "logical", ConfirmedFlushLSN: 0x1000}
+	lag := m.record(slot, 0x1000, start)
+	assert.Equal(t, uint64(0), lag.Bytes)
+	assert.Equal(t, time.Duration(0), lag.Time)
+
+	// The server writes WAL while the consumer does not confirm anything.
+	lag = m.record(slot, 0x1800, start.Add(30*time.Second))
+	assert.Equal(t, uint64(0x800), lag.Bytes)
+	assert.Equal(t, 30*time.Second, lag.Time)
+	assert.False(t, lag.Exceeded)
+
+	lag = m.record(slot, 0x2000, start.Add(90*time.Second))
+	assert.Equal(t, 90*time.Second, lag.Time)
+	assert.True(t, lag.Exceeded)
+	require.Len(t, exceeded, 1)
+
+	// The consumer confirms the WAL written at the second sample.
+	slot.ConfirmedFlushLSN = 0x1800
+	lag = m.record(slot, 0x2000, start.Add(100*time.Second))
+	assert.Equal(t, uint64(0x800), lag.Bytes)
+	assert.Equal(t, 70*time.Second, lag.Time)
+	assert.True(t, lag.Exceeded)
+	assert.Len(t, exceeded, 1)
+
+	slot.ConfirmedFlushLSN = 0x2000
+	lag = m.record(slot, 0x2000, start.Add(110*time.Second))
+	assert.Equal(t, SlotLag{Slot: slot, CurrentLSN: 0x2000, MeasuredAt: start.Add(110 * time.Second)}, lag)
+	assert.Len(t, recovered, 1)
+	assert.Equal(t, lag, m.Last())
+}
@@ -0,0 +1,191 @@
+package pglogrepl
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLargeObjectPageSize is the size of the pages of large objects of a server built with the
+// default block size, LOBLKSIZE.
+const DefaultLargeObjectPageSize = 2048
+
+// LargeObjectOptions configures a LargeObjectAssembler.
+type LargeObjectOptions struct {
+	// Namespace and Table name the table of the pages, with the columns loid, pageno and data of
+	// pg_largeobject. They default to pg_catalog and pg_largeobject.
+	Namespace string
+	Table     string
+	// PageSize is the size of the pages. It defaults to DefaultLargeObjectPageSize.
+	PageSize int
+}
+
+// LargeObjectChange is a change of the contents of a large object.
+type LargeObjectChange struct {
+	LOID uint32
+	// Offset is the position of the change in the large object.
+	Offset int64
+	// Data is the data written at Offset, nil for a removal.
+	Data []byte
+	// Removed is the length of the range removed at Offset, such as by lo_truncate or lo_unlink.
+	Removed int64
+}
+
+// LargeObjectAssembler reassembles the page writes of large objects into changes of their
+// contents. A large object is stored as rows of pg_largeobject holding pages of at most PageSize
+// bytes, so a single lo_write appears as inserts and updates of many rows; the assembler buffers
+// the page changes of a transaction and returns them at its commit as contiguous writes and
+// removals per large object, in the order of the large object IDs and offsets.
+//
+// PostgreSQL does not decode the changes of system catalogs, pg_largeobject included, so they are
+// never sent by the in-core output plugins. The assembler serves the tables sharing its layout,
+// such as a user table the pages are copied to, or plugins that do decode catalog changes; the
+// table is set by LargeObjectOptions.
+//
+// Every message of the stream must be passed to Add in order. The tuples must be in text format.
+// Streamed transactions are not supported: their messages must be assembled first, see
+// TransactionAssembler. A LargeObjectAssembler is not safe for concurrent use.
+type LargeObjectAssembler struct {
+	options   LargeObjectOptions
+	relations *RelationCache
+	// pages are the page changes of the transaction in progress, a nil data being a removal.
+	pages map[largeObjectPage][]byte
+}
+
+type largeObjectPage struct {
+	loid   uint32
+	pageno int32
+}
+
+// NewLargeObjectAssembler returns a new LargeObjectAssembler.
+func NewLargeObjectAssembler(options LargeObjectOptions) *LargeObjectAssembler {
+	if options.Namespace == "" && options.Table == "" {
+		options.Namespace, options.Table = "pg_catalog", "pg_largeobject"
+	}
+	if options.PageSize <= 0 {
+		options.PageSize = DefaultLargeObjectPageSize
+	}
+	return &LargeObjectAssembler{
+		options:   options,
+		relations: NewRelationCache(nil),
+		pages:     map[largeObjectPage][]byte{},
+	}
+}
+
+// Add adds msg to the assembler. It returns the large object changes of a transaction when msg is
+// its commit message, and nil otherwise.
+func (a *LargeObjectAssembler) Add(msg Message) ([]LargeObjectChange, error) {
+	switch m := msg.(type) {
+	case *InsertMessageV2:
+		msg = &m.InsertMessage
+	case *UpdateMessageV2:
+		msg = &m.UpdateMessage
+	case *DeleteMessageV2:
+		msg = &m.DeleteMessage
+	}
+
+	switch msg := msg.(type) {
+	case *RelationMessage, *RelationMessageV2:
+		a.relations.Update(msg)
+	case *BeginMessage:
+		a.pages = map[largeObjectPage][]byte{}
+	case *InsertMessage:
+		return nil, a.write(msg.RelationID, msg.Tuple, false)
+	case *UpdateMessage:
+		return nil, a.write(msg.RelationID, msg.NewTuple, false)
+	case *DeleteMessage:
+		return nil, a.write(msg.RelationID, msg.OldTuple, true)
+	case *CommitMessage:
+		changes := a.changes()
+		a.pages = map[largeObjectPage][]byte{}
+		return changes, nil
+	}
+	return nil, nil
+}
+
+// write records the page change of tuple if relationID is the table of the pages.
+func (a *LargeObjectAssembler) write(relationID uint32, tuple *TupleData, removed bool) error {
+	rel, ok := a.relations.Relation(relationID)
+	if !ok || rel.Namespace != a.options.Namespace || rel.RelationName != a.options.Table {
+		return nil
+	}
+	if tuple == nil {
+		return fmt.Errorf("large object page change without tuple")
+	}
+	values := make(map[string]string, 3)
+	for i, col := range tuple.Columns {
+		if i < len(rel.Columns) && col.DataType == TupleDataTypeText {
+			values[rel.Columns[i].Name] = string(col.Data)
+		}
+	}
+
+	loid, err := strconv.ParseUint(values["loid"], 10, 32)
+	if err != nil {
+		return fmt.Errorf("failed to parse large object ID: %w", err)
+	}
+	pageno, err := strconv.ParseInt(values["pageno"], 10, 32)
+	if err != nil {
+		return fmt.Errorf("failed to parse large object page number: %w", err)
+	}
+	page := largeObjectPage{loid: uint32(loid), pageno: int32(pageno)}
+	if removed {
+		a.pages[page] = nil
+		return nil
+	}
+	text, ok := values["data"]
+	if !ok || !strings.HasPrefix(text, `\x`) {
+		return fmt.Errorf("large object %d page %d has no data in hex format", loid, pageno)
+	}
+	data, err := hex.DecodeString(text[2:])
+	if err != nil {
+		return fmt.Errorf("failed to parse large object data: %w", err)
+	}
+	a.pages[page] = data
+	return nil
+}
+
+// changes merges the page changes of the transaction into contiguous changes.
+func (a *LargeObjectAssembler) changes() []LargeObjectChange {
+	if len(a.pages) == 0 {
+		return nil
+	}
+	pages := make([]largeObjectPage, 0, len(a.pages))
+	for page := range a.pages {
+		pages = append(pages, page)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].loid != pages[j].loid {
+			return pages[i].loid < pages[j].loid
+		}
+		return pages[i].pageno < pages[j].pageno
+	})
+
+	pageSize := int64(a.options.PageSize)
+	var changes []LargeObjectChange
+	for _, page := range pages {
+		data := a.pages[page]
+		offset := int64(page.pageno) * pageSize
+		if n := len(changes); n > 0 {
+			last := &changes[n-1]
+			switch {
+			case last.LOID != page.loid:
+			case data == nil && last.Data == nil && last.Offset+last.Removed == offset:
+				last.Removed += pageSize
+				continue
+			case data != nil && last.Data != nil && last.Offset+int64(len(last.Data)) == offset:
+				last.Data = append(last.Data, data...)
+				continue
+			}
+		}
+		change := LargeObjectChange{LOID: page.loid, Offset: offset}
+		if data == nil {
+			change.Removed = pageSize
+		} else {
+			change.Data = append([]byte{}, data...)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
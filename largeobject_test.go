@@ -0,0 +1,57 @@
+package pglogrepl_test
+
+import (
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func largeObjectPage(loid, pageno, data string) *pglogrepl.TupleData {
+	return &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{
+		textColumn(loid), textColumn(pageno), textColumn(data),
+	}}
+}
+
+func TestLargeObjectAssembler(t *testing.T) {
+	a := pglogrepl.NewLargeObjectAssembler(pglogrepl.LargeObjectOptions{PageSize: 4})
+	for _, msg := range []pglogrepl.Message{
+		relationMessage("pg_catalog", "pg_largeobject", "loid", "pageno", "data"),
+		&pglogrepl.BeginMessage{Xid: 1},
+		&pglogrepl.InsertMessage{RelationID: 1, Tuple: largeObjectPage("16400", "1", `\x05060708`)},
+		&pglogrepl.InsertMessage{RelationID: 1, Tuple: largeObjectPage("16400", "0", `\x01020304`)},
+		&pglogrepl.UpdateMessage{RelationID: 1, NewTuple: largeObjectPage("16400", "2", `\x09`)},
+		&pglogrepl.InsertMessage{RelationID: 1, Tuple: largeObjectPage("16400", "5", `\x0a0b`)},
+		&pglogrepl.DeleteMessage{RelationID: 1, OldTuple: largeObjectPage("16399", "0", "")},
+		&pglogrepl.DeleteMessage{RelationID: 1, OldTuple: largeObjectPage("16399", "1", "")},
+	} {
+		changes, err := a.Add(msg)
+		require.NoError(t, err)
+		assert.Nil(t, changes)
+	}
+
+	changes, err := a.Add(&pglogrepl.CommitMessage{})
+	require.NoError(t, err)
+	assert.Equal(t, []pglogrepl.LargeObjectChange{
+		{LOID: 16399, Offset: 0, Removed: 8},
+		{LOID: 16400, Offset: 0, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{LOID: 16400, Offset: 20, Data: []byte{10, 11}},
+	}, changes)
+
+	// Changes of other tables are ignored.
+	for _, msg := range []pglogrepl.Message{
+		&pglogrepl.RelationMessage{RelationID: 2, Namespace: "public", RelationName: "t"},
+		&pglogrepl.BeginMessage{Xid: 2},
+		&pglogrepl.InsertMessage{RelationID: 2, Tuple: largeObjectPage("1", "0", `\x01`)},
+	} {
+		_, err := a.Add(msg)
+		require.NoError(t, err)
+	}
+	changes, err = a.Add(&pglogrepl.CommitMessage{})
+	require.NoError(t, err)
+	assert.Nil(t, changes)
+
+	_, err = a.Add(&pglogrepl.InsertMessage{RelationID: 1, Tuple: largeObjectPage("16400", "0", "bad")})
+	assert.Error(t, err)
+}
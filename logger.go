@@ -0,0 +1,24 @@
+package pglogrepl
+
+// Logger receives the log records of a ReplicationStream or a Subscription configured with it.
+// The arguments following the message are alternating keys and values, as for the methods of
+// log/slog's Logger, so that a *slog.Logger can be used as a Logger directly. The package does not
+// log anything without a Logger.
+//
+// Debug records are written for every keepalive and standby status update, Info records for
+// reconnects and the setup of subscriptions, Warn records for server notices and failed reconnect
+// attempts, and Error records for messages that cannot be parsed before Next returns the error.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// nopLogger is the Logger of components configured without one.
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
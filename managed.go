@@ -0,0 +1,143 @@
+package pglogrepl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ManagedService identifies a managed PostgreSQL service with replication quirks.
+type ManagedService int
+
+const (
+	// ManagedServiceNone is a server that is not run by a known managed service.
+	ManagedServiceNone ManagedService = iota
+	// ManagedServiceRDS is Amazon RDS for PostgreSQL.
+	ManagedServiceRDS
+	// ManagedServiceAurora is Amazon Aurora PostgreSQL.
+	ManagedServiceAurora
+)
+
+func (s ManagedService) String() string {
+	switch s {
+	case ManagedServiceNone:
+		return "none"
+	case ManagedServiceRDS:
+		return "rds"
+	case ManagedServiceAurora:
+		return "aurora"
+	}
+	return fmt.Sprintf("ManagedService(%d)", int(s))
+}
+
+// amazon reports whether s is run by AWS, where logical replication is configured with the
+// rds.logical_replication parameter and granted with the rds_replication role.
+func (s ManagedService) amazon() bool {
+	return s == ManagedServiceRDS || s == ManagedServiceAurora
+}
+
+// Errors wrapped by the error of ServerEnvironment.CheckLogicalReplication.
+var (
+	// ErrWALLevelNotLogical reports a server whose wal_level is not logical.
+	ErrWALLevelNotLogical = errors.New("wal_level is not logical")
+	// ErrMissingReplicationPrivilege reports a user that may not use replication.
+	ErrMissingReplicationPrivilege = errors.New("user lacks the replication privilege")
+)
+
+// ServerEnvironment describes what the server and the user of a connection allow for logical
+// replication.
+type ServerEnvironment struct {
+	Service ManagedService
+	User    string
+	// Superuser reports whether the user is a superuser. On RDS and Aurora no user is, the
+	// rds_superuser role grants a subset of the privileges.
+	Superuser bool
+	// Replication reports whether the user has the REPLICATION attribute or, on RDS and Aurora,
+	// is a member of the rds_replication role. Membership is only detected when it is granted
+	// directly.
+	Replication bool
+	WALLevel    string
+}
+
+// DetectServerEnvironment identifies the service running the server of conn, a regular or
+// replication connection, and the replication privileges of its user.
+func DetectServerEnvironment(ctx context.Context, conn *pgconn.PgConn) (ServerEnvironment, error) {
+	var env ServerEnvironment
+	rows, err := queryRows(ctx, conn, "SELECT to_regproc('aurora_version') IS NOT NULL, "+
+		"EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'rds_superuser'), "+
+		"r.rolname, r.rolsuper, r.rolreplication OR EXISTS (SELECT 1 FROM pg_auth_members m "+
+		"JOIN pg_roles g ON g.oid = m.roleid WHERE m.member = r.oid AND g.rolname = 'rds_replication'), "+
+		"current_setting('wal_level') FROM pg_roles r WHERE r.rolname = current_user", 6)
+	if err != nil {
+		return env, fmt.Errorf("failed to detect server environment: %w", err)
+	}
+	if len(rows) != 1 {
+		return env, fmt.Errorf("expected 1 row, got %d", len(rows))
+	}
+	row := rows[0]
+	switch {
+	case string(row[0]) == "t":
+		env.Service = ManagedServiceAurora
+	case string(row[1]) == "t":
+		env.Service = ManagedServiceRDS
+	}
+	env.User = string(row[2])
+	env.Superuser = string(row[3]) == "t"
+	env.Replication = string(row[4]) == "t"
+	env.WALLevel = string(row[5])
+	return env, nil
+}
+
+// CheckLogicalReplication returns an error wrapping ErrWALLevelNotLogical or
+// ErrMissingReplicationPrivilege, with the fix for the service of the server, if logical
+// replication cannot be used.
+func (e ServerEnvironment) CheckLogicalReplication() error {
+	if e.WALLevel != "logical" {
+		return fmt.Errorf("%w (it is %q): %s", ErrWALLevelNotLogical, e.WALLevel, e.walLevelHint())
+	}
+	if !e.Superuser && !e.Replication {
+		return fmt.Errorf("%w: %s", ErrMissingReplicationPrivilege, e.privilegeHint())
+	}
+	return nil
+}
+
+func (e ServerEnvironment) walLevelHint() string {
+	if e.Service.amazon() {
+		return "set rds.logical_replication to 1 in the parameter group of the instance or cluster and reboot it"
+	}
+	return "set wal_level = logical in postgresql.conf and restart the server"
+}
+
+func (e ServerEnvironment) privilegeHint() string {
+	user := e.User
+	if user == "" {
+		user = "the user"
+	} else {
+		user = quoteIdentifier(user)
+	}
+	if e.Service.amazon() {
+		return fmt.Sprintf("run GRANT rds_replication TO %s as a member of rds_superuser", user)
+	}
+	return fmt.Sprintf("run ALTER ROLE %s WITH REPLICATION as a superuser", user)
+}
+
+// ExplainReplicationError returns err with the fix for the service of the server if it reports
+// that the user lacks a privilege or that wal_level is not logical, such as an error of
+// CreateReplicationSlot or StartReplication, and err unchanged otherwise. The returned error
+// wraps err.
+func (e ServerEnvironment) ExplainReplicationError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	switch {
+	case pgErr.Code == "42501":
+		return fmt.Errorf("%w (%s)", err, e.privilegeHint())
+	case pgErr.Code == "55000" && strings.Contains(pgErr.Message, "wal_level"):
+		return fmt.Errorf("%w (%s)", err, e.walLevelHint())
+	}
+	return err
+}
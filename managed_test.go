@@ -0,0 +1,89 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serverEnvironmentResponse(aurora, rds, superuser, replication bool, walLevel string) []pgproto3.BackendMessage {
+	flag := func(b bool) []byte {
+		if b {
+			return []byte("t")
+		}
+		return []byte("f")
+	}
+	msgs := []pgproto3.BackendMessage{&pgproto3.RowDescription{Fields: make([]pgproto3.FieldDescription, 6)}}
+	msgs = append(msgs, &pgproto3.DataRow{Values: [][]byte{
+		flag(aurora), flag(rds), []byte("app"), flag(superuser), flag(replication), []byte(walLevel),
+	}})
+	return append(msgs, commandCompleteResponse("SELECT 1")...)
+}
+
+func TestDetectServerEnvironment(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	queries := ws.serveQuery(serverEnvironmentResponse(true, true, false, false, "logical"))
+	env, err := pglogrepl.DetectServerEnvironment(ctx, conn)
+	require.NoError(t, err)
+	assert.Contains(t, <-queries, "to_regproc('aurora_version')")
+	assert.Equal(t, pglogrepl.ServerEnvironment{Service: pglogrepl.ManagedServiceAurora, User: "app", WALLevel: "logical"}, env)
+	err = env.CheckLogicalReplication()
+	assert.ErrorIs(t, err, pglogrepl.ErrMissingReplicationPrivilege)
+	assert.ErrorContains(t, err, `GRANT rds_replication TO "app"`)
+
+	queries = ws.serveQuery(serverEnvironmentResponse(false, true, false, true, "replica"))
+	env, err = pglogrepl.DetectServerEnvironment(ctx, conn)
+	require.NoError(t, err)
+	<-queries
+	assert.Equal(t, pglogrepl.ManagedServiceRDS, env.Service)
+	err = env.CheckLogicalReplication()
+	assert.ErrorIs(t, err, pglogrepl.ErrWALLevelNotLogical)
+	assert.ErrorContains(t, err, "rds.logical_replication")
+
+	env = pglogrepl.ServerEnvironment{User: "app", Replication: true, WALLevel: "logical"}
+	assert.NoError(t, env.CheckLogicalReplication())
+	env.Replication = false
+	assert.ErrorContains(t, env.CheckLogicalReplication(), `ALTER ROLE "app" WITH REPLICATION`)
+}
+
+func TestServerEnvironmentExplainReplicationError(t *testing.T) {
+	env := pglogrepl.ServerEnvironment{Service: pglogrepl.ManagedServiceRDS, User: "app"}
+
+	denied := &pgconn.PgError{Severity: "ERROR", Code: "42501", Message: "must be superuser or replication role to use replication slots"}
+	err := env.ExplainReplicationError(fmt.Errorf("failed to create replication slot: %w", denied))
+	assert.ErrorIs(t, err, denied)
+	assert.EqualError(t, err, `failed to create replication slot: ERROR: must be superuser or replication role to use replication slots (SQLSTATE 42501) (run GRANT rds_replication TO "app" as a member of rds_superuser)`)
+
+	walLevel := &pgconn.PgError{Code: "55000", Message: `logical decoding requires wal_level >= logical`}
+	assert.ErrorContains(t, env.ExplainReplicationError(walLevel), "rds.logical_replication")
+
+	other := &pgconn.PgError{Code: "42704", Message: "replication slot does not exist"}
+	assert.Same(t, other, env.ExplainReplicationError(other))
+}
+
+func TestSubscriptionCheckEnvironment(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	queries := ws.serveQuery(serverEnvironmentResponse(false, true, false, false, "logical"))
+	sub := pglogrepl.NewSubscription(conn, pglogrepl.SubscriptionOptions{
+		SlotName:         slotName,
+		PublicationName:  "pub",
+		CheckEnvironment: true,
+		Handler:          func(ctx context.Context, msg *pglogrepl.ReplicationMessage) error { return nil },
+	})
+	err := sub.Run(ctx)
+	<-queries
+	assert.ErrorIs(t, err, pglogrepl.ErrMissingReplicationPrivilege)
+}
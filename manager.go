@@ -0,0 +1,353 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SubscriptionState is the health state of a subscription run by a Manager.
+type SubscriptionState int
+
+const (
+	// SubscriptionStarting is the state of a subscription connecting and starting replication.
+	SubscriptionStarting SubscriptionState = iota
+	// SubscriptionStreaming is the state of a subscription receiving from the server.
+	SubscriptionStreaming
+	// SubscriptionReconnecting is the state of a subscription whose stream is reconnecting with
+	// the ReconnectPolicy of the subscription.
+	SubscriptionReconnecting
+	// SubscriptionRestarting is the state of a failed subscription waiting for the RestartDelay
+	// of the manager to be restarted.
+	SubscriptionRestarting
+	// SubscriptionFailed is the state of a subscription that failed and is not restarted.
+	SubscriptionFailed
+	// SubscriptionStopped is the state of a subscription after Stop.
+	SubscriptionStopped
+)
+
+func (s SubscriptionState) String() string {
+	switch s {
+	case SubscriptionStarting:
+		return "starting"
+	case SubscriptionStreaming:
+		return "streaming"
+	case SubscriptionReconnecting:
+		return "reconnecting"
+	case SubscriptionRestarting:
+		return "restarting"
+	case SubscriptionFailed:
+		return "failed"
+	case SubscriptionStopped:
+		return "stopped"
+	}
+	return fmt.Sprintf("SubscriptionState(%d)", int(s))
+}
+
+// SubscriptionStatus is the health state and the counters of a subscription run by a Manager.
+type SubscriptionStatus struct {
+	Name     string
+	SlotName string
+	State    SubscriptionState
+	// Err is the error the subscription last failed with. It is kept once the subscription is
+	// restarted.
+	Err error
+	// Restarts is the number of times the manager restarted the subscription and Reconnects the
+	// number of times its stream reconnected.
+	Restarts   int
+	Reconnects int
+	// ConfirmedLSN is the position confirmed to the server and ClientXLogPos the position
+	// received.
+	ConfirmedLSN  LSN
+	ClientXLogPos LSN
+	// Messages and WALDataSize are the number of XLogData messages received and the size of
+	// their WAL data, and LastReceive the time of the last message, keepalives included.
+	Messages    int64
+	WALDataSize int64
+	LastReceive time.Time
+}
+
+// ManagerOptions configures a Manager.
+type ManagerOptions struct {
+	// RestartDelay, if positive, makes the manager restart a subscription that failed, with a new
+	// connection, after RestartDelay. Otherwise a failed subscription stays in the
+	// SubscriptionFailed state.
+	RestartDelay time.Duration
+	// Metrics, if set, receives the measurements of the streams of all subscriptions.
+	Metrics StreamMetrics
+	// Logger, if set, receives the log records of the manager.
+	Logger Logger
+}
+
+// Manager runs several subscriptions, to different databases or of different publications, in
+// one process. A replication connection streams from a single database and slot, so a service
+// replicating many tenants needs one subscription per database; the manager gives them a shared
+// lifecycle, tracks the health of each and aggregates their metrics.
+type Manager struct {
+	options ManagerOptions
+	logger  Logger
+
+	mu      sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	entries []*managedSubscription
+	wg      sync.WaitGroup
+}
+
+// managedSubscription is a subscription added to a Manager.
+type managedSubscription struct {
+	connect func(ctx context.Context) (*pgconn.PgConn, error)
+	options SubscriptionOptions
+	// sub is the subscription being run. status and sub are guarded by the mutex of the manager.
+	sub    *Subscription
+	status SubscriptionStatus
+}
+
+// NewManager returns a Manager without subscriptions.
+func NewManager(options ManagerOptions) *Manager {
+	logger := options.Logger
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	return &Manager{options: options, logger: logger}
+}
+
+// Add adds a subscription named name, whose replication connections are established by connect.
+// The subscription is run when Start is called, or immediately if the manager is started. The
+// Metrics of options still receive the measurements of its stream, and the OnReconnect of its
+// ReconnectPolicy is still called.
+func (m *Manager) Add(name string, connect func(ctx context.Context) (*pgconn.PgConn, error), options SubscriptionOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		if e.status.Name == name {
+			return fmt.Errorf("subscription %q already exists", name)
+		}
+	}
+	e := &managedSubscription{connect: connect, options: options}
+	e.status = SubscriptionStatus{Name: name, SlotName: options.SlotName}
+	m.entries = append(m.entries, e)
+	if m.ctx != nil {
+		m.start(e)
+	}
+	return nil
+}
+
+// Start runs the subscriptions until ctx is canceled or Stop is called.
+func (m *Manager) Start(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx != nil {
+		return
+	}
+	m.ctx, m.cancel = context.WithCancel(ctx)
+	for _, e := range m.entries {
+		m.start(e)
+	}
+}
+
+// Stop stops the subscriptions and waits for them to return. It returns the error of the first
+// subscription found in the SubscriptionFailed state, if any.
+func (m *Manager) Stop() error {
+	m.mu.Lock()
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		if e.status.State == SubscriptionFailed {
+			return fmt.Errorf("subscription %q failed: %w", e.status.Name, e.status.Err)
+		}
+	}
+	return nil
+}
+
+// Status returns the status of every subscription, in the order they were added.
+func (m *Manager) Status() []SubscriptionStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]SubscriptionStatus, len(m.entries))
+	for i, e := range m.entries {
+		statuses[i] = e.status
+		if e.sub != nil {
+			statuses[i].ConfirmedLSN = e.sub.ConfirmedLSN()
+		}
+	}
+	return statuses
+}
+
+// start starts running e. m.mu must be held.
+func (m *Manager) start(e *managedSubscription) {
+	options := e.options
+	metrics := &managedMetrics{m: m, e: e, next: options.Metrics}
+	options.Metrics = metrics
+	if options.Reconnect != nil {
+		reconnect := *options.Reconnect
+		onReconnect := reconnect.OnReconnect
+		reconnect.OnReconnect = func(attempt int, err error) {
+			m.mu.Lock()
+			e.status.State = SubscriptionReconnecting
+			m.mu.Unlock()
+			if onReconnect != nil {
+				onReconnect(attempt, err)
+			}
+		}
+		options.Reconnect = &reconnect
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.run(m.ctx, e, options)
+	}()
+}
+
+// run runs e, restarting it after failures if the options have a RestartDelay.
+func (m *Manager) run(ctx context.Context, e *managedSubscription, options SubscriptionOptions) {
+	for {
+		err := m.runOnce(ctx, e, options)
+		if ctx.Err() != nil {
+			m.setState(e, SubscriptionStopped, nil)
+			return
+		}
+		m.logger.Error("subscription failed", "name", e.status.Name, "error", err)
+		if m.options.RestartDelay <= 0 {
+			m.setState(e, SubscriptionFailed, err)
+			return
+		}
+		m.setState(e, SubscriptionRestarting, err)
+
+		timer := time.NewTimer(m.options.RestartDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			m.setState(e, SubscriptionStopped, err)
+			return
+		case <-timer.C:
+		}
+		m.mu.Lock()
+		e.status.Restarts++
+		m.mu.Unlock()
+		m.logger.Info("restarting subscription", "name", e.status.Name)
+	}
+}
+
+func (m *Manager) runOnce(ctx context.Context, e *managedSubscription, options SubscriptionOptions) error {
+	m.mu.Lock()
+	e.status.State = SubscriptionStarting
+	m.mu.Unlock()
+
+	conn, err := e.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	sub := NewSubscription(conn, options)
+	m.mu.Lock()
+	e.sub = sub
+	m.mu.Unlock()
+	err = sub.Run(ctx)
+
+	// The confirmed position outlives the subscription in the status.
+	m.mu.Lock()
+	e.status.ConfirmedLSN = sub.ConfirmedLSN()
+	e.sub = nil
+	m.mu.Unlock()
+	if sub.stream != nil {
+		conn = sub.stream.Conn()
+	}
+	conn.Close(context.Background())
+	return err
+}
+
+// setState sets the state of e and, if err is not nil, its error.
+func (m *Manager) setState(e *managedSubscription, state SubscriptionState, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.status.State = state
+	if err != nil {
+		e.status.Err = err
+	}
+}
+
+// managedMetrics updates the status of a managed subscription from the measurements of its
+// stream and forwards them to the metrics of the manager and of the subscription.
+type managedMetrics struct {
+	m    *Manager
+	e    *managedSubscription
+	next StreamMetrics
+}
+
+// received updates the status when something is received. m.m.mu must be held.
+func (mm *managedMetrics) received() {
+	mm.e.status.LastReceive = time.Now()
+	if mm.e.status.State == SubscriptionStarting || mm.e.status.State == SubscriptionReconnecting {
+		mm.e.status.State = SubscriptionStreaming
+	}
+}
+
+func (mm *managedMetrics) MessageReceived(msgType MessageType, walDataSize int) {
+	mm.m.mu.Lock()
+	mm.received()
+	mm.e.status.Messages++
+	mm.e.status.WALDataSize += int64(walDataSize)
+	mm.m.mu.Unlock()
+	if mm.m.options.Metrics != nil {
+		mm.m.options.Metrics.MessageReceived(msgType, walDataSize)
+	}
+	if mm.next != nil {
+		mm.next.MessageReceived(msgType, walDataSize)
+	}
+}
+
+func (mm *managedMetrics) KeepaliveReceived() {
+	mm.m.mu.Lock()
+	mm.received()
+	mm.m.mu.Unlock()
+	if mm.m.options.Metrics != nil {
+		mm.m.options.Metrics.KeepaliveReceived()
+	}
+	if mm.next != nil {
+		mm.next.KeepaliveReceived()
+	}
+}
+
+func (mm *managedMetrics) ClientXLogPos(lsn LSN) {
+	mm.m.mu.Lock()
+	mm.e.status.ClientXLogPos = lsn
+	mm.m.mu.Unlock()
+	if mm.m.options.Metrics != nil {
+		mm.m.options.Metrics.ClientXLogPos(lsn)
+	}
+	if mm.next != nil {
+		mm.next.ClientXLogPos(lsn)
+	}
+}
+
+func (mm *managedMetrics) Reconnected() {
+	mm.m.mu.Lock()
+	mm.e.status.Reconnects++
+	mm.e.status.State = SubscriptionStreaming
+	mm.m.mu.Unlock()
+	if mm.m.options.Metrics != nil {
+		mm.m.options.Metrics.Reconnected()
+	}
+	if mm.next != nil {
+		mm.next.Reconnected()
+	}
+}
+
+func (mm *managedMetrics) StandbyStatusUpdateSent(latency time.Duration) {
+	if mm.m.options.Metrics != nil {
+		mm.m.options.Metrics.StandbyStatusUpdateSent(latency)
+	}
+	if mm.next != nil {
+		mm.next.StandbyStatusUpdateSent(latency)
+	}
+}
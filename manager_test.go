@@ -0,0 +1,118 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/pglogrepltest"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type messageCountMetrics struct {
+	pglogrepl.NopStreamMetrics
+	mu       sync.Mutex
+	messages int
+}
+
+func (m *messageCountMetrics) MessageReceived(pglogrepl.MessageType, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages++
+}
+
+func TestManager(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	metrics := &messageCountMetrics{}
+	m := pglogrepl.NewManager(pglogrepl.ManagerOptions{RestartDelay: 10 * time.Millisecond, Metrics: metrics})
+	var mu sync.Mutex
+	handled := map[string][]pglogrepl.LSN{}
+	var servers []*pglogrepltest.Server
+	for _, name := range []string{"tenant1", "tenant2"} {
+		srv, err := pglogrepltest.NewServer(pglogrepltest.ServerOptions{})
+		require.NoError(t, err)
+		defer srv.Close()
+		servers = append(servers, srv)
+		name := name
+		require.NoError(t, m.Add(name, func(ctx context.Context) (*pgconn.PgConn, error) {
+			return pgconn.Connect(ctx, srv.ConnString())
+		}, pglogrepl.SubscriptionOptions{
+			SlotName:        name + "_slot",
+			PublicationName: "pub",
+			Handler: func(ctx context.Context, msg *pglogrepl.ReplicationMessage) error {
+				mu.Lock()
+				defer mu.Unlock()
+				handled[name] = append(handled[name], msg.WALStart)
+				return nil
+			},
+		}))
+	}
+	assert.Error(t, m.Add("tenant1", nil, pglogrepl.SubscriptionOptions{}))
+	m.Start(ctx)
+
+	for _, srv := range servers {
+		require.NoError(t, srv.SendXLogData(0x200, beginMessageData(0x300, 1)))
+		require.NoError(t, srv.SendXLogData(0x300, commitMessageData(0x300, 0x340)))
+	}
+	require.Eventually(t, func() bool {
+		statuses := m.Status()
+		return statuses[0].ConfirmedLSN == 0x340 && statuses[1].ConfirmedLSN == 0x340
+	}, 5*time.Second, time.Millisecond)
+	status := m.Status()[1]
+	assert.Equal(t, "tenant2", status.Name)
+	assert.Equal(t, "tenant2_slot", status.SlotName)
+	assert.Equal(t, pglogrepl.SubscriptionStreaming, status.State)
+	assert.Equal(t, int64(2), status.Messages)
+	assert.Equal(t, pglogrepl.LSN(0x300), status.ClientXLogPos)
+	metrics.mu.Lock()
+	assert.Equal(t, 4, metrics.messages)
+	metrics.mu.Unlock()
+
+	// A subscription that fails is restarted, the others keep streaming.
+	servers[0].Disconnect()
+	require.Eventually(t, func() bool {
+		return m.Status()[0].Restarts == 1
+	}, 5*time.Second, time.Millisecond)
+	assert.Error(t, m.Status()[0].Err)
+	require.NoError(t, servers[0].SendXLogData(0x400, beginMessageData(0x500, 2)))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled["tenant1"]) == 3
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, pglogrepl.SubscriptionStreaming, m.Status()[0].State)
+	assert.Equal(t, pglogrepl.SubscriptionStreaming, m.Status()[1].State)
+
+	require.NoError(t, m.Stop())
+	for _, status := range m.Status() {
+		assert.Equal(t, pglogrepl.SubscriptionStopped, status.State, status.Name)
+	}
+	assert.Equal(t, "stopped", pglogrepl.SubscriptionStopped.String())
+}
+
+func TestManagerFailed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	m := pglogrepl.NewManager(pglogrepl.ManagerOptions{})
+	m.Start(ctx)
+	// A subscription added to a started manager runs immediately.
+	connectErr := errors.New("unreachable")
+	require.NoError(t, m.Add("broken", func(ctx context.Context) (*pgconn.PgConn, error) {
+		return nil, connectErr
+	}, pglogrepl.SubscriptionOptions{SlotName: "slot", PublicationName: "pub", Handler: func(context.Context, *pglogrepl.ReplicationMessage) error { return nil }}))
+	require.Eventually(t, func() bool {
+		return m.Status()[0].State == pglogrepl.SubscriptionFailed
+	}, 5*time.Second, time.Millisecond)
+
+	err := m.Stop()
+	assert.ErrorIs(t, err, connectErr)
+	assert.Equal(t, pglogrepl.SubscriptionFailed, m.Status()[0].State)
+}
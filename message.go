@@ -3,14 +3,13 @@ package pglogrepl
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
 	"time"
-)
 
-var (
-	errMsgNotSupported = errors.New("replication message not supported")
+	"github.com/jackc/pgio"
 )
 
 // MessageType indicates the type of a logical replication message.
@@ -46,6 +45,18 @@ func (t MessageType) String() string {
 		return "StreamCommit"
 	case MessageTypeStreamAbort:
 		return "StreamAbort"
+	case MessageTypeBeginPrepare:
+		return "BeginPrepare"
+	case MessageTypePrepare:
+		return "Prepare"
+	case MessageTypeCommitPrepared:
+		return "CommitPrepared"
+	case MessageTypeRollbackPrepared:
+		return "RollbackPrepared"
+	case MessageTypeStreamPrepare:
+		return "StreamPrepare"
+	case MessageTypeSequence:
+		return "Sequence"
 	default:
 		return "Unknown"
 	}
@@ -67,6 +78,15 @@ const (
 	MessageTypeStreamStop   MessageType = 'E'
 	MessageTypeStreamCommit MessageType = 'c'
 	MessageTypeStreamAbort  MessageType = 'A'
+
+	MessageTypeBeginPrepare     MessageType = 'b'
+	MessageTypePrepare          MessageType = 'P'
+	MessageTypeCommitPrepared   MessageType = 'K'
+	MessageTypeRollbackPrepared MessageType = 'r'
+	MessageTypeStreamPrepare    MessageType = 'p'
+
+	// MessageTypeSequence is the type of SequenceMessage, which no released server sends yet.
+	MessageTypeSequence MessageType = 'Q'
 )
 
 // Message is a message received from server.
@@ -79,6 +99,12 @@ type MessageDecoder interface {
 	Decode([]byte) error
 }
 
+// MessageEncoder encodes struct into message. Encode appends the complete message, including
+// the first message type byte, to dst in the format expected by the Parse functions.
+type MessageEncoder interface {
+	Encode(dst []byte) ([]byte, error)
+}
+
 type baseMessage struct {
 	msgType MessageType
 }
@@ -102,19 +128,19 @@ func (m *baseMessage) Decode(_ []byte) error {
 }
 
 func (m *baseMessage) lengthError(name string, expectedLen, actualLen int) error {
-	return fmt.Errorf("%s must have %d bytes, got %d bytes", name, expectedLen, actualLen)
+	return truncatedError(name, expectedLen, actualLen)
 }
 
-func (m *baseMessage) decodeStringError(name, field string) error {
-	return fmt.Errorf("%s.%s decode string error", name, field)
+func (m *baseMessage) decodeStringError(name, field string, offset int) error {
+	return &ParseError{Message: name, Field: field, Offset: offset, Err: fmt.Errorf("%w: unterminated string", ErrTruncatedMessage)}
 }
 
-func (m *baseMessage) decodeTupleDataError(name, field string, e error) error {
-	return fmt.Errorf("%s.%s decode tuple error: %s", name, field, e.Error())
+func (m *baseMessage) decodeTupleDataError(name, field string, e error, offset int) error {
+	return nestedError(e, name, field, offset)
 }
 
-func (m *baseMessage) invalidTupleTypeError(name, field string, e string, a byte) error {
-	return fmt.Errorf("%s.%s invalid tuple type value, expect %s, actual %c", name, field, e, a)
+func (m *baseMessage) invalidTupleTypeError(name, field string, e string, a byte, offset int) error {
+	return &ParseError{Message: name, Field: field, Offset: offset, Err: fmt.Errorf("%w %q, expect %s", ErrUnknownTupleKind, a, e)}
 }
 
 // decodeString decode a string from src and returns the length of bytes being parsed.
@@ -137,6 +163,15 @@ func (m *baseMessage) decodeString(src []byte) (string, int) {
 	return string(src[:end]), end + 1
 }
 
+// encodeString appends s as a null-terminated string to dst.
+func encodeString(dst []byte, name, field, s string) ([]byte, error) {
+	if strings.IndexByte(s, 0) != -1 {
+		return nil, fmt.Errorf("%s.%s encode string error: contains null byte", name, field)
+	}
+	dst = append(dst, s...)
+	return append(dst, 0), nil
+}
+
 func (m *baseMessage) decodeLSN(src []byte) (LSN, int) {
 	return LSN(binary.BigEndian.Uint64(src)), 8
 }
@@ -186,6 +221,20 @@ func (m *BeginMessage) Decode(src []byte) error {
 	return nil
 }
 
+// Encode appends the wire format of the message to dst.
+func (m *BeginMessage) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, byte(MessageTypeBegin))
+	dst = pgio.AppendUint64(dst, uint64(m.FinalLSN))
+	dst = pgio.AppendInt64(dst, timeToPgTime(m.CommitTime))
+	dst = pgio.AppendUint32(dst, m.Xid)
+	return dst, nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *BeginMessage) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // CommitMessage is a commit message.
 type CommitMessage struct {
 	baseMessage
@@ -218,6 +267,20 @@ func (m *CommitMessage) Decode(src []byte) error {
 	return nil
 }
 
+// Encode appends the wire format of the message to dst.
+func (m *CommitMessage) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, byte(MessageTypeCommit), m.Flags)
+	dst = pgio.AppendUint64(dst, uint64(m.CommitLSN))
+	dst = pgio.AppendUint64(dst, uint64(m.TransactionEndLSN))
+	dst = pgio.AppendInt64(dst, timeToPgTime(m.CommitTime))
+	return dst, nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *CommitMessage) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // OriginMessage is an origin message.
 type OriginMessage struct {
 	baseMessage
@@ -237,7 +300,7 @@ func (m *OriginMessage) Decode(src []byte) error {
 	low += used
 	m.Name, used = m.decodeString(src[low:])
 	if used < 0 {
-		return m.decodeStringError("OriginMessage", "Name")
+		return m.decodeStringError("OriginMessage", "Name", low)
 	}
 
 	m.SetType(MessageTypeOrigin)
@@ -245,6 +308,18 @@ func (m *OriginMessage) Decode(src []byte) error {
 	return nil
 }
 
+// Encode appends the wire format of the message to dst.
+func (m *OriginMessage) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, byte(MessageTypeOrigin))
+	dst = pgio.AppendUint64(dst, uint64(m.CommitLSN))
+	return encodeString(dst, "OriginMessage", "Name", m.Name)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *OriginMessage) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // RelationMessageColumn is one column in a RelationMessage.
 type RelationMessageColumn struct {
 	// Flags for the column. Currently, it can be either 0 for no flags or 1 which marks the column as part of the key.
@@ -259,6 +334,14 @@ type RelationMessageColumn struct {
 	TypeModifier int32
 }
 
+// List of relation replica identity settings.
+const (
+	ReplicaIdentityDefault = uint8('d')
+	ReplicaIdentityNothing = uint8('n')
+	ReplicaIdentityFull    = uint8('f')
+	ReplicaIdentityIndex   = uint8('i')
+)
+
 // RelationMessage is a relation message.
 type RelationMessage struct {
 	baseMessage
@@ -282,39 +365,60 @@ func (m *RelationMessage) Decode(src []byte) error {
 
 	m.Namespace, used = m.decodeString(src[low:])
 	if used < 0 {
-		return m.decodeStringError("RelationMessage", "Namespace")
+		return m.decodeStringError("RelationMessage", "Namespace", low)
 	}
 	low += used
 
 	m.RelationName, used = m.decodeString(src[low:])
 	if used < 0 {
-		return m.decodeStringError("RelationMessage", "RelationName")
+		return m.decodeStringError("RelationMessage", "RelationName", low)
 	}
 	low += used
 
+	if len(src)-low < 3 {
+		return m.lengthError("RelationMessage", low+3, len(src))
+	}
 	m.ReplicaIdentity = src[low]
 	low++
 
 	m.ColumnNum, used = m.decodeUint16(src[low:])
 	low += used
 
-	for i := 0; i < int(m.ColumnNum); i++ {
-		column := new(RelationMessageColumn)
+	// The columns and their names are allocated at once rather than one by one: names are
+	// substrings of a single string holding the rest of the message.
+	columns := make([]RelationMessageColumn, m.ColumnNum)
+	m.Columns = nil
+	if m.ColumnNum > 0 {
+		m.Columns = make([]*RelationMessageColumn, m.ColumnNum)
+	}
+	header := low
+	rest := string(src[low:])
+	src = src[low:]
+	low = 0
+	for i := range columns {
+		column := &columns[i]
+		if low >= len(src) {
+			return relationColumnError(m.lengthError("RelationMessage", low+1, len(src)), i, header)
+		}
 		column.Flags = src[low]
 		low++
-		column.Name, used = m.decodeString(src[low:])
-		if used < 0 {
-			return m.decodeStringError("RelationMessage", fmt.Sprintf("Column[%d].Name", i))
+		end := strings.IndexByte(rest[low:], 0)
+		if end < 0 {
+			return relationColumnError(m.decodeStringError("RelationMessage", "Name", low), i, header)
 		}
-		low += used
+		column.Name = rest[low : low+end]
+		low += end + 1
 
+		if len(src)-low < 8 {
+			return relationColumnError(m.lengthError("RelationMessage", low+8, len(src)), i, header)
+		}
 		column.DataType, used = m.decodeUint32(src[low:])
 		low += used
 
 		column.TypeModifier, used = m.decodeInt32(src[low:])
 		low += used
 
-		m.Columns = append(m.Columns, column)
+		m.Columns[i] = column
 	}
 
 	m.SetType(MessageTypeRelation)
@@ -322,6 +426,43 @@ func (m *RelationMessage) Decode(src []byte) error {
 	return nil
 }
 
+// relationColumnError returns err, the error of decoding column i of a relation message whose
+// columns start at offset.
+func relationColumnError(err error, i, offset int) error {
+	return nestedError(err, "RelationMessage", fmt.Sprintf("Columns[%d]", i), offset)
+}
+
+// Encode appends the wire format of the message to dst.
+func (m *RelationMessage) Encode(dst []byte) (_ []byte, err error) {
+	if len(m.Columns) > math.MaxUint16 {
+		return nil, fmt.Errorf("RelationMessage has too many columns: %d", len(m.Columns))
+	}
+	dst = append(dst, byte(MessageTypeRelation))
+	dst = pgio.AppendUint32(dst, m.RelationID)
+	if dst, err = encodeString(dst, "RelationMessage", "Namespace", m.Namespace); err != nil {
+		return nil, err
+	}
+	if dst, err = encodeString(dst, "RelationMessage", "RelationName", m.RelationName); err != nil {
+		return nil, err
+	}
+	dst = append(dst, m.ReplicaIdentity)
+	dst = pgio.AppendUint16(dst, uint16(len(m.Columns)))
+	for i, column := range m.Columns {
+		dst = append(dst, column.Flags)
+		if dst, err = encodeString(dst, "RelationMessage", fmt.Sprintf("Column[%d].Name", i), column.Name); err != nil {
+			return nil, err
+		}
+		dst = pgio.AppendUint32(dst, column.DataType)
+		dst = pgio.AppendInt32(dst, column.TypeModifier)
+	}
+	return dst, nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *RelationMessage) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // TypeMessage is a type message.
 type TypeMessage struct {
 	baseMessage
@@ -342,13 +483,13 @@ func (m *TypeMessage) Decode(src []byte) error {
 
 	m.Namespace, used = m.decodeString(src[low:])
 	if used < 0 {
-		return m.decodeStringError("TypeMessage", "Namespace")
+		return m.decodeStringError("TypeMessage", "Namespace", low)
 	}
 	low += used
 
 	m.Name, used = m.decodeString(src[low:])
 	if used < 0 {
-		return m.decodeStringError("TypeMessage", "Name")
+		return m.decodeStringError("TypeMessage", "Name", low)
 	}
 
 	m.SetType(MessageTypeType)
@@ -356,6 +497,21 @@ func (m *TypeMessage) Decode(src []byte) error {
 	return nil
 }
 
+// Encode appends the wire format of the message to dst.
+func (m *TypeMessage) Encode(dst []byte) (_ []byte, err error) {
+	dst = append(dst, byte(MessageTypeType))
+	dst = pgio.AppendUint32(dst, m.DataType)
+	if dst, err = encodeString(dst, "TypeMessage", "Namespace", m.Namespace); err != nil {
+		return nil, err
+	}
+	return encodeString(dst, "TypeMessage", "Name", m.Name)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *TypeMessage) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // List of types of data in a tuple.
 const (
 	TupleDataTypeNull   = uint8('n')
@@ -376,18 +532,33 @@ type TupleDataColumn struct {
 	//	 Byte1('b') Identifies the data as binary value.
 	DataType uint8
 	Length   uint32
-	// Data is th value of the column, in text format. (A future release might support additional formats.) n is the above length.
+	// Data is the value of the column, in text format for 't' or in the binary format of the
+	// column's data type for 'b'. Binary format is only sent when pgoutput is started with
+	// binary 'true'. n is the above length.
 	Data []byte
 }
 
-// Int64 parse column data as an int64 integer.
+// Int64 parse column data as an int64 integer. Binary data is accepted for the int2, int4 and
+// int8 binary formats.
 func (c *TupleDataColumn) Int64() (int64, error) {
-	if c.DataType != TupleDataTypeText {
-		return 0, fmt.Errorf("invalid column's data type, expect %c, actual %c",
-			TupleDataTypeText, c.DataType)
+	switch c.DataType {
+	case TupleDataTypeText:
+		return strconv.ParseInt(string(c.Data), 10, 64)
+	case TupleDataTypeBinary:
+		switch len(c.Data) {
+		case 2:
+			return int64(int16(binary.BigEndian.Uint16(c.Data))), nil
+		case 4:
+			return int64(int32(binary.BigEndian.Uint32(c.Data))), nil
+		case 8:
+			return int64(binary.BigEndian.Uint64(c.Data)), nil
+		default:
+			return 0, fmt.Errorf("invalid binary integer length %d", len(c.Data))
+		}
+	default:
+		return 0, fmt.Errorf("invalid column's data type, expect %c or %c, actual %c",
+			TupleDataTypeText, TupleDataTypeBinary, c.DataType)
 	}
-
-	return strconv.ParseInt(string(c.Data), 10, 64)
 }
 
 // TupleData contains row change information.
@@ -399,35 +570,112 @@ type TupleData struct {
 
 // Decode decodes to message from src.
 func (m *TupleData) Decode(src []byte) (int, error) {
+	return m.decode(src, aliasNone)
+}
+
+// aliasNone makes decode copy the data of all columns.
+const aliasNone = math.MaxInt
+
+// decode decodes the tuple data from src, reusing the columns of m. The data of columns of at
+// least aliasMin bytes are sub-slices of src, the others are copied.
+func (m *TupleData) decode(src []byte, aliasMin int) (int, error) {
+	if len(src) < 2 {
+		return 0, m.lengthError("TupleData", 2, len(src))
+	}
+
 	var low, used int
 
 	m.ColumnNum, used = m.decodeUint16(src)
 	low += used
 
-	for i := 0; i < int(m.ColumnNum); i++ {
-		column := new(TupleDataColumn)
+	if cap(m.Columns) < int(m.ColumnNum) {
+		columns := make([]*TupleDataColumn, len(m.Columns), m.ColumnNum)
+		copy(columns, m.Columns)
+		m.Columns = columns
+	}
+	reused := len(m.Columns)
+	m.Columns = m.Columns[:m.ColumnNum]
+	if reused < len(m.Columns) {
+		// Allocate the missing columns at once rather than one by one.
+		block := make([]TupleDataColumn, len(m.Columns)-reused)
+		for i := range block {
+			m.Columns[reused+i] = &block[i]
+		}
+	}
+
+	var dataLen int
+	for i, column := range m.Columns {
+		if low >= len(src) {
+			return 0, tupleColumnError(m.lengthError("TupleData", low+1, len(src)), i)
+		}
 		column.DataType = src[low]
+		column.Length = 0
+		column.Data = nil
 		low += 1
 
 		switch column.DataType {
 		case TupleDataTypeText, TupleDataTypeBinary:
+			if len(src)-low < 4 {
+				return 0, tupleColumnError(m.lengthError("TupleData", low+4, len(src)), i)
+			}
 			column.Length, used = m.decodeUint32(src[low:])
 			low += used
 
-			column.Data = make([]byte, int(column.Length))
-			for j := 0; j < int(column.Length); j++ {
-				column.Data[j] = src[low+j]
+			if uint64(len(src)-low) < uint64(column.Length) {
+				return 0, tupleColumnError(m.lengthError("TupleData", low+int(column.Length), len(src)), i)
+			}
+			end := low + int(column.Length)
+			column.Data = src[low:end:end]
+			if int(column.Length) < aliasMin {
+				dataLen += int(column.Length)
 			}
-			low += int(column.Length)
+			low = end
 		case TupleDataTypeNull, TupleDataTypeToast:
+		default:
+			return 0, tupleColumnError(m.invalidTupleTypeError("TupleData", "", "n/u/t/b", column.DataType, low-1), i)
 		}
+	}
 
-		m.Columns = append(m.Columns, column)
+	if aliasMin > 0 {
+		// Copy the data of the columns into a single buffer.
+		buf := make([]byte, 0, dataLen)
+		for _, column := range m.Columns {
+			if column.Data != nil && int(column.Length) < aliasMin {
+				low := len(buf)
+				buf = append(buf, column.Data...)
+				column.Data = buf[low:len(buf):len(buf)]
+			}
+		}
 	}
 
 	return low, nil
 }
 
+// tupleColumnError returns err, the error of decoding column i of a tuple.
+func tupleColumnError(err error, i int) error {
+	return nestedError(err, "TupleData", fmt.Sprintf("Columns[%d]", i), 0)
+}
+
+// Encode appends the wire format of the tuple data to dst.
+func (m *TupleData) Encode(dst []byte) ([]byte, error) {
+	if len(m.Columns) > math.MaxUint16 {
+		return nil, fmt.Errorf("TupleData has too many columns: %d", len(m.Columns))
+	}
+	dst = pgio.AppendUint16(dst, uint16(len(m.Columns)))
+	for i, column := range m.Columns {
+		dst = append(dst, column.DataType)
+		switch column.DataType {
+		case TupleDataTypeText, TupleDataTypeBinary:
+			dst = pgio.AppendUint32(dst, uint32(len(column.Data)))
+			dst = append(dst, column.Data...)
+		case TupleDataTypeNull, TupleDataTypeToast:
+		default:
+			return nil, fmt.Errorf("TupleData.Columns[%d] invalid data type %c", i, column.DataType)
+		}
+	}
+	return dst, nil
+}
+
 // InsertMessage is a insert message
 type InsertMessage struct {
 	baseMessage
@@ -438,8 +686,12 @@ type InsertMessage struct {
 
 // Decode decodes to message from src.
 func (m *InsertMessage) Decode(src []byte) error {
-	if len(src) < 8 {
-		return m.lengthError("InsertMessage", 8, len(src))
+	return m.decode(src, new(TupleData), aliasNone)
+}
+
+func (m *InsertMessage) decode(src []byte, tuple *TupleData, aliasMin int) error {
+	if len(src) < 7 {
+		return m.lengthError("InsertMessage", 7, len(src))
 	}
 
 	var low, used int
@@ -450,13 +702,13 @@ func (m *InsertMessage) Decode(src []byte) error {
 	tupleType := src[low]
 	low += 1
 	if tupleType != 'N' {
-		return m.invalidTupleTypeError("InsertMessage", "TupleType", "N", tupleType)
+		return m.invalidTupleTypeError("InsertMessage", "TupleType", "N", tupleType, low-1)
 	}
 
-	m.Tuple = new(TupleData)
-	_, err := m.Tuple.Decode(src[low:])
+	m.Tuple = tuple
+	_, err := m.Tuple.decode(src[low:], aliasMin)
 	if err != nil {
-		return m.decodeTupleDataError("InsertMessage", "TupleData", err)
+		return m.decodeTupleDataError("InsertMessage", "Tuple", err, low)
 	}
 
 	m.SetType(MessageTypeInsert)
@@ -464,6 +716,22 @@ func (m *InsertMessage) Decode(src []byte) error {
 	return nil
 }
 
+// Encode appends the wire format of the message to dst.
+func (m *InsertMessage) Encode(dst []byte) ([]byte, error) {
+	if m.Tuple == nil {
+		return nil, fmt.Errorf("InsertMessage.Tuple is nil")
+	}
+	dst = append(dst, byte(MessageTypeInsert))
+	dst = pgio.AppendUint32(dst, m.RelationID)
+	dst = append(dst, 'N')
+	return m.Tuple.Encode(dst)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *InsertMessage) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // List of types of UpdateMessage tuples.
 const (
 	UpdateMessageTupleTypeNone = uint8(0)
@@ -500,8 +768,12 @@ type UpdateMessage struct {
 
 // Decode decodes to message from src.
 func (m *UpdateMessage) Decode(src []byte) (err error) {
-	if len(src) < 6 {
-		return m.lengthError("UpdateMessage", 6, len(src))
+	return m.decode(src, new(TupleData), new(TupleData), aliasNone)
+}
+
+func (m *UpdateMessage) decode(src []byte, oldTuple, newTuple *TupleData, aliasMin int) (err error) {
+	if len(src) < 7 {
+		return m.lengthError("UpdateMessage", 7, len(src))
 	}
 
 	var low, used int
@@ -512,25 +784,33 @@ func (m *UpdateMessage) Decode(src []byte) (err error) {
 	tupleType := src[low]
 	low++
 
+	m.OldTupleType = UpdateMessageTupleTypeNone
+	m.OldTuple = nil
 	switch tupleType {
 	case UpdateMessageTupleTypeKey, UpdateMessageTupleTypeOld:
 		m.OldTupleType = tupleType
-		m.OldTuple = new(TupleData)
-		used, err = m.OldTuple.Decode(src[low:])
+		m.OldTuple = oldTuple
+		used, err = m.OldTuple.decode(src[low:], aliasMin)
 		if err != nil {
-			return m.decodeTupleDataError("UpdateMessage", "OldTuple", err)
+			return m.decodeTupleDataError("UpdateMessage", "OldTuple", err, low)
 		}
 		low += used
+		if low >= len(src) {
+			return m.lengthError("UpdateMessage", low+1, len(src))
+		}
+		if src[low] != UpdateMessageTupleTypeNew {
+			return m.invalidTupleTypeError("UpdateMessage", "NewTupleType", "N", src[low], low)
+		}
 		low++
 		fallthrough
 	case UpdateMessageTupleTypeNew:
-		m.NewTuple = new(TupleData)
-		_, err = m.NewTuple.Decode(src[low:])
+		m.NewTuple = newTuple
+		_, err = m.NewTuple.decode(src[low:], aliasMin)
 		if err != nil {
-			return m.decodeTupleDataError("UpdateMessage", "NewTuple", err)
+			return m.decodeTupleDataError("UpdateMessage", "NewTuple", err, low)
 		}
 	default:
-		return m.invalidTupleTypeError("UpdateMessage", "Tuple", "K/O/N", tupleType)
+		return m.invalidTupleTypeError("UpdateMessage", "OldTupleType", "K/O/N", tupleType, low-1)
 	}
 
 	m.SetType(MessageTypeUpdate)
@@ -538,6 +818,35 @@ func (m *UpdateMessage) Decode(src []byte) (err error) {
 	return nil
 }
 
+// Encode appends the wire format of the message to dst.
+func (m *UpdateMessage) Encode(dst []byte) (_ []byte, err error) {
+	if m.NewTuple == nil {
+		return nil, fmt.Errorf("UpdateMessage.NewTuple is nil")
+	}
+	dst = append(dst, byte(MessageTypeUpdate))
+	dst = pgio.AppendUint32(dst, m.RelationID)
+	switch m.OldTupleType {
+	case UpdateMessageTupleTypeKey, UpdateMessageTupleTypeOld:
+		if m.OldTuple == nil {
+			return nil, fmt.Errorf("UpdateMessage.OldTuple is nil")
+		}
+		dst = append(dst, m.OldTupleType)
+		if dst, err = m.OldTuple.Encode(dst); err != nil {
+			return nil, err
+		}
+	case UpdateMessageTupleTypeNone:
+	default:
+		return nil, fmt.Errorf("UpdateMessage.OldTupleType invalid tuple type value, expect K/O, actual %c", m.OldTupleType)
+	}
+	dst = append(dst, UpdateMessageTupleTypeNew)
+	return m.NewTuple.Encode(dst)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *UpdateMessage) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // List of types of DeleteMessage tuples.
 const (
 	DeleteMessageTupleTypeKey = uint8('K')
@@ -567,8 +876,12 @@ type DeleteMessage struct {
 
 // Decode decodes a message from src.
 func (m *DeleteMessage) Decode(src []byte) (err error) {
-	if len(src) < 4 {
-		return m.lengthError("DeleteMessage", 4, len(src))
+	return m.decode(src, new(TupleData), aliasNone)
+}
+
+func (m *DeleteMessage) decode(src []byte, oldTuple *TupleData, aliasMin int) (err error) {
+	if len(src) < 7 {
+		return m.lengthError("DeleteMessage", 7, len(src))
 	}
 
 	var low, used int
@@ -581,13 +894,13 @@ func (m *DeleteMessage) Decode(src []byte) (err error) {
 
 	switch m.OldTupleType {
 	case DeleteMessageTupleTypeKey, DeleteMessageTupleTypeOld:
-		m.OldTuple = new(TupleData)
-		_, err = m.OldTuple.Decode(src[low:])
+		m.OldTuple = oldTuple
+		_, err = m.OldTuple.decode(src[low:], aliasMin)
 		if err != nil {
-			return m.decodeTupleDataError("DeleteMessage", "OldTuple", err)
+			return m.decodeTupleDataError("DeleteMessage", "OldTuple", err, low)
 		}
 	default:
-		return m.invalidTupleTypeError("DeleteMessage", "OldTupleType", "K/O", m.OldTupleType)
+		return m.invalidTupleTypeError("DeleteMessage", "OldTupleType", "K/O", m.OldTupleType, low-1)
 	}
 
 	m.SetType(MessageTypeDelete)
@@ -595,6 +908,27 @@ func (m *DeleteMessage) Decode(src []byte) (err error) {
 	return nil
 }
 
+// Encode appends the wire format of the message to dst.
+func (m *DeleteMessage) Encode(dst []byte) ([]byte, error) {
+	switch m.OldTupleType {
+	case DeleteMessageTupleTypeKey, DeleteMessageTupleTypeOld:
+	default:
+		return nil, fmt.Errorf("DeleteMessage.OldTupleType invalid tuple type value, expect K/O, actual %c", m.OldTupleType)
+	}
+	if m.OldTuple == nil {
+		return nil, fmt.Errorf("DeleteMessage.OldTuple is nil")
+	}
+	dst = append(dst, byte(MessageTypeDelete))
+	dst = pgio.AppendUint32(dst, m.RelationID)
+	dst = append(dst, m.OldTupleType)
+	return m.OldTuple.Encode(dst)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *DeleteMessage) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // List of truncate options.
 const (
 	TruncateOptionCascade = uint8(1) << iota
@@ -611,8 +945,8 @@ type TruncateMessage struct {
 
 // Decode decodes to message from src.
 func (m *TruncateMessage) Decode(src []byte) (err error) {
-	if len(src) < 9 {
-		return m.lengthError("TruncateMessage", 9, len(src))
+	if len(src) < 5 {
+		return m.lengthError("TruncateMessage", 5, len(src))
 	}
 
 	var low, used int
@@ -622,6 +956,9 @@ func (m *TruncateMessage) Decode(src []byte) (err error) {
 	m.Option = src[low]
 	low++
 
+	if uint64(len(src)-low) < 4*uint64(m.RelationNum) {
+		return m.lengthError("TruncateMessage", low+4*int(m.RelationNum), len(src))
+	}
 	m.RelationIDs = make([]uint32, m.RelationNum)
 	for i := 0; i < int(m.RelationNum); i++ {
 		m.RelationIDs[i], used = m.decodeUint32(src[low:])
@@ -633,6 +970,22 @@ func (m *TruncateMessage) Decode(src []byte) (err error) {
 	return nil
 }
 
+// Encode appends the wire format of the message to dst.
+func (m *TruncateMessage) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, byte(MessageTypeTruncate))
+	dst = pgio.AppendUint32(dst, uint32(len(m.RelationIDs)))
+	dst = append(dst, m.Option)
+	for _, relationID := range m.RelationIDs {
+		dst = pgio.AppendUint32(dst, relationID)
+	}
+	return dst, nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *TruncateMessage) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // LogicalDecodingMessage is a logical decoding message.
 type LogicalDecodingMessage struct {
 	baseMessage
@@ -659,11 +1012,20 @@ func (m *LogicalDecodingMessage) Decode(src []byte) (err error) {
 	low += used
 
 	m.Prefix, used = m.decodeString(src[low:])
+	if used < 0 {
+		return m.decodeStringError("LogicalDecodingMessage", "Prefix", low)
+	}
 	low += used
 
+	if len(src)-low < 4 {
+		return m.lengthError("LogicalDecodingMessage", low+4, len(src))
+	}
 	contentLength, used := m.decodeUint32(src[low:])
 	low += used
 
+	if uint64(len(src)-low) < uint64(contentLength) {
+		return m.lengthError("LogicalDecodingMessage", low+int(contentLength), len(src))
+	}
 	m.Content = src[low : low+int(contentLength)]
 
 	m.SetType(MessageTypeMessage)
@@ -671,8 +1033,32 @@ func (m *LogicalDecodingMessage) Decode(src []byte) (err error) {
 	return nil
 }
 
+// Encode appends the wire format of the message to dst.
+func (m *LogicalDecodingMessage) Encode(dst []byte) (_ []byte, err error) {
+	dst = append(dst, byte(MessageTypeMessage))
+	if m.Transactional {
+		dst = append(dst, 1)
+	} else {
+		dst = append(dst, 0)
+	}
+	dst = pgio.AppendUint64(dst, uint64(m.LSN))
+	if dst, err = encodeString(dst, "LogicalDecodingMessage", "Prefix", m.Prefix); err != nil {
+		return nil, err
+	}
+	dst = pgio.AppendUint32(dst, uint32(len(m.Content)))
+	return append(dst, m.Content...), nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *LogicalDecodingMessage) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // Parse parse a logical replication message.
 func Parse(data []byte) (m Message, err error) {
+	if len(data) == 0 {
+		return nil, truncatedError("Message", 1, 0)
+	}
 	var decoder MessageDecoder
 	msgType := MessageType(data[0])
 	switch msgType {
@@ -690,16 +1076,18 @@ func Parse(data []byte) (m Message, err error) {
 		decoder = new(TruncateMessage)
 	case MessageTypeMessage:
 		decoder = new(LogicalDecodingMessage)
+	case MessageTypeSequence:
+		decoder = new(SequenceMessage)
 	default:
 		decoder = getCommonDecoder(msgType)
 	}
 
 	if decoder == nil {
-		return nil, errMsgNotSupported
+		return nil, &UnknownMessageTypeError{Byte: data[0]}
 	}
 
 	if err = decoder.Decode(data[1:]); err != nil {
-		return nil, err
+		return nil, messageError(err, data)
 	}
 
 	return decoder.(Message), nil
@@ -3,6 +3,8 @@ package pglogrepl
 import (
 	"encoding/binary"
 	"time"
+
+	"github.com/jackc/pgio"
 )
 
 // MessageDecoderV2 decodes message from V2 protocol into struct.
@@ -36,6 +38,18 @@ func (m *StreamStartMessageV2) DecodeV2(src []byte, _ bool) (err error) {
 	return nil
 }
 
+// Encode appends the wire format of the message to dst.
+func (m *StreamStartMessageV2) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, byte(MessageTypeStreamStart))
+	dst = pgio.AppendUint32(dst, m.Xid)
+	return append(dst, m.FirstSegment), nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *StreamStartMessageV2) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // StreamStopMessageV2 is a stream stop message.
 type StreamStopMessageV2 struct {
 	baseMessage
@@ -49,6 +63,16 @@ func (m *StreamStopMessageV2) DecodeV2(_ []byte, _ bool) (err error) {
 	return nil
 }
 
+// Encode appends the wire format of the message to dst.
+func (m *StreamStopMessageV2) Encode(dst []byte) ([]byte, error) {
+	return append(dst, byte(MessageTypeStreamStop)), nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *StreamStopMessageV2) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // StreamCommitMessageV2 is a stream commit message.
 type StreamCommitMessageV2 struct {
 	baseMessage
@@ -81,6 +105,22 @@ func (m *StreamCommitMessageV2) DecodeV2(src []byte, _ bool) (err error) {
 	return nil
 }
 
+// Encode appends the wire format of the message to dst.
+func (m *StreamCommitMessageV2) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, byte(MessageTypeStreamCommit))
+	dst = pgio.AppendUint32(dst, m.Xid)
+	dst = append(dst, m.Flags)
+	dst = pgio.AppendUint64(dst, uint64(m.CommitLSN))
+	dst = pgio.AppendUint64(dst, uint64(m.TransactionEndLSN))
+	dst = pgio.AppendInt64(dst, timeToPgTime(m.CommitTime))
+	return dst, nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *StreamCommitMessageV2) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // StreamAbortMessageV2 is a stream abort message.
 type StreamAbortMessageV2 struct {
 	baseMessage
@@ -106,11 +146,27 @@ func (m *StreamAbortMessageV2) DecodeV2(src []byte, _ bool) (err error) {
 	return nil
 }
 
+// Encode appends the wire format of the message to dst.
+func (m *StreamAbortMessageV2) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, byte(MessageTypeStreamAbort))
+	dst = pgio.AppendUint32(dst, m.Xid)
+	dst = pgio.AppendUint32(dst, m.SubXid)
+	return dst, nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *StreamAbortMessageV2) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
 // ParseV2 parse a logical replication message from protocol version #2
 // it accepts a slice of bytes read from PG and inStream parameter
 // inStream must be true when StreamStartMessageV2 has been read
 // it must be false after StreamStopMessageV2 has been read
 func ParseV2(data []byte, inStream bool) (m Message, err error) {
+	if len(data) == 0 {
+		return nil, truncatedError("Message", 1, 0)
+	}
 	var decoder MessageDecoder
 	msgType := MessageType(data[0])
 
@@ -137,20 +193,22 @@ func ParseV2(data []byte, inStream bool) (m Message, err error) {
 		decoder = new(DeleteMessageV2)
 	case MessageTypeTruncate:
 		decoder = new(TruncateMessageV2)
+	case MessageTypeSequence:
+		decoder = new(SequenceMessageV2)
 	default:
 		decoder = getCommonDecoder(msgType)
 	}
 
 	if decoder == nil {
-		return nil, errMsgNotSupported
+		return nil, &UnknownMessageTypeError{Byte: data[0]}
 	}
 
 	if v2, ok := decoder.(MessageDecoderV2); ok {
 		if err = v2.DecodeV2(data[1:], inStream); err != nil {
-			return nil, err
+			return nil, messageError(err, data)
 		}
 	} else if err = decoder.Decode(data[1:]); err != nil {
-		return nil, err
+		return nil, messageError(err, data)
 	}
 
 	return decoder.(Message), nil
@@ -180,7 +238,18 @@ func (m *LogicalDecodingMessageV2) DecodeV2(src []byte, inStream bool) (err erro
 
 	src = readXidAndAdvance(src, &m.InStreamMessageV2WithXid, inStream)
 
-	return m.LogicalDecodingMessage.Decode(src)
+	return nestedError(m.LogicalDecodingMessage.Decode(src), "LogicalDecodingMessageV2", "", 4)
+}
+
+// Encode appends the wire format of the message to dst. The Xid is only encoded if it is not 0,
+// which is the case for messages sent inside a streamed transaction.
+func (m *LogicalDecodingMessageV2) Encode(dst []byte) ([]byte, error) {
+	return encodeWithXid(dst, &m.LogicalDecodingMessage, m.Xid)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *LogicalDecodingMessageV2) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
 }
 
 // RelationMessageV2 is a relation message.
@@ -201,7 +270,18 @@ func (m *RelationMessageV2) DecodeV2(src []byte, inStream bool) (err error) {
 
 	src = readXidAndAdvance(src, &m.InStreamMessageV2WithXid, inStream)
 
-	return m.RelationMessage.Decode(src)
+	return nestedError(m.RelationMessage.Decode(src), "RelationMessageV2", "", 4)
+}
+
+// Encode appends the wire format of the message to dst. The Xid is only encoded if it is not 0,
+// which is the case for messages sent inside a streamed transaction.
+func (m *RelationMessageV2) Encode(dst []byte) ([]byte, error) {
+	return encodeWithXid(dst, &m.RelationMessage, m.Xid)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *RelationMessageV2) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
 }
 
 // TypeMessageV2 is a type message.
@@ -222,7 +302,18 @@ func (m *TypeMessageV2) DecodeV2(src []byte, inStream bool) (err error) {
 
 	src = readXidAndAdvance(src, &m.InStreamMessageV2WithXid, inStream)
 
-	return m.TypeMessage.Decode(src)
+	return nestedError(m.TypeMessage.Decode(src), "TypeMessageV2", "", 4)
+}
+
+// Encode appends the wire format of the message to dst. The Xid is only encoded if it is not 0,
+// which is the case for messages sent inside a streamed transaction.
+func (m *TypeMessageV2) Encode(dst []byte) ([]byte, error) {
+	return encodeWithXid(dst, &m.TypeMessage, m.Xid)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *TypeMessageV2) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
 }
 
 // InsertMessageV2 is an insert message.
@@ -237,13 +328,24 @@ func (m *InsertMessageV2) DecodeV2(src []byte, inStream bool) (err error) {
 		return m.InsertMessage.Decode(src)
 	}
 
-	if len(src) < 12 {
-		return m.lengthError("InsertMessageV2", 12, len(src))
+	if len(src) < 11 {
+		return m.lengthError("InsertMessageV2", 11, len(src))
 	}
 
 	src = readXidAndAdvance(src, &m.InStreamMessageV2WithXid, inStream)
 
-	return m.InsertMessage.Decode(src)
+	return nestedError(m.InsertMessage.Decode(src), "InsertMessageV2", "", 4)
+}
+
+// Encode appends the wire format of the message to dst. The Xid is only encoded if it is not 0,
+// which is the case for messages sent inside a streamed transaction.
+func (m *InsertMessageV2) Encode(dst []byte) ([]byte, error) {
+	return encodeWithXid(dst, &m.InsertMessage, m.Xid)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *InsertMessageV2) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
 }
 
 // UpdateMessageV2 is an update message.
@@ -264,7 +366,18 @@ func (m *UpdateMessageV2) DecodeV2(src []byte, inStream bool) (err error) {
 
 	src = readXidAndAdvance(src, &m.InStreamMessageV2WithXid, inStream)
 
-	return m.UpdateMessage.Decode(src)
+	return nestedError(m.UpdateMessage.Decode(src), "UpdateMessageV2", "", 4)
+}
+
+// Encode appends the wire format of the message to dst. The Xid is only encoded if it is not 0,
+// which is the case for messages sent inside a streamed transaction.
+func (m *UpdateMessageV2) Encode(dst []byte) ([]byte, error) {
+	return encodeWithXid(dst, &m.UpdateMessage, m.Xid)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *UpdateMessageV2) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
 }
 
 // DeleteMessageV2 is a delete message.
@@ -285,7 +398,18 @@ func (m *DeleteMessageV2) DecodeV2(src []byte, inStream bool) (err error) {
 
 	src = readXidAndAdvance(src, &m.InStreamMessageV2WithXid, inStream)
 
-	return m.DeleteMessage.Decode(src)
+	return nestedError(m.DeleteMessage.Decode(src), "DeleteMessageV2", "", 4)
+}
+
+// Encode appends the wire format of the message to dst. The Xid is only encoded if it is not 0,
+// which is the case for messages sent inside a streamed transaction.
+func (m *DeleteMessageV2) Encode(dst []byte) ([]byte, error) {
+	return encodeWithXid(dst, &m.DeleteMessage, m.Xid)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *DeleteMessageV2) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
 }
 
 // TruncateMessageV2 is a truncate message.
@@ -300,13 +424,24 @@ func (m *TruncateMessageV2) DecodeV2(src []byte, inStream bool) (err error) {
 		return m.TruncateMessage.Decode(src)
 	}
 
-	if len(src) < 13 {
-		return m.lengthError("TruncateMessageV2", 13, len(src))
+	if len(src) < 9 {
+		return m.lengthError("TruncateMessageV2", 9, len(src))
 	}
 
 	src = readXidAndAdvance(src, &m.InStreamMessageV2WithXid, inStream)
 
-	return m.TruncateMessage.Decode(src)
+	return nestedError(m.TruncateMessage.Decode(src), "TruncateMessageV2", "", 4)
+}
+
+// Encode appends the wire format of the message to dst. The Xid is only encoded if it is not 0,
+// which is the case for messages sent inside a streamed transaction.
+func (m *TruncateMessageV2) Encode(dst []byte) ([]byte, error) {
+	return encodeWithXid(dst, &m.TruncateMessage, m.Xid)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *TruncateMessageV2) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
 }
 
 func readXidAndAdvance(src []byte, mXid *InStreamMessageV2WithXid, inStream bool) []byte {
@@ -321,6 +456,20 @@ func readXidAndAdvance(src []byte, mXid *InStreamMessageV2WithXid, inStream bool
 	return src[used:]
 }
 
+// encodeWithXid encodes m and inserts xid after the message type byte if it is not 0.
+func encodeWithXid(dst []byte, m MessageEncoder, xid uint32) ([]byte, error) {
+	start := len(dst)
+	dst, err := m.Encode(dst)
+	if err != nil || xid == 0 {
+		return dst, err
+	}
+
+	dst = append(dst, 0, 0, 0, 0)
+	copy(dst[start+5:], dst[start+1:len(dst)-4])
+	binary.BigEndian.PutUint32(dst[start+1:], xid)
+	return dst, nil
+}
+
 func decodeUint32(src []byte) (uint32, int) {
 	return binary.BigEndian.Uint32(src), 4
 }
@@ -34,6 +34,7 @@ func (s *logicalDecodingMessageSuiteV2) Test() {
 	s.True(ok)
 
 	s.Equal(expectedV2, logicalDecodingMsg)
+	s.assertEncoded(msg, logicalDecodingMsg)
 }
 
 func (s *logicalDecodingMessageSuiteV2) TestNoStream() {
@@ -48,6 +49,53 @@ func (s *logicalDecodingMessageSuiteV2) TestNoStream() {
 
 	s.Equal(uint32(0), logicalDecodingMsg.Xid)
 	s.Equal(expected, &logicalDecodingMsg.LogicalDecodingMessage)
+	s.assertEncoded(msg, logicalDecodingMsg)
+}
+
+func TestSequenceMessageV2Suite(t *testing.T) {
+	suite.Run(t, new(sequenceMessageSuiteV2))
+}
+
+type sequenceMessageSuiteV2 struct {
+	messageSuite
+}
+
+func (s *sequenceMessageSuiteV2) Test() {
+	msg := make([]byte, 1+4+1+8+7+9+1+8+8+1)
+	msg[0] = 'Q'
+	xid := s.newXid()
+	bigEndian.PutUint32(msg[1:], xid)
+
+	expected := s.putSequenceTestData(msg[5:])
+
+	expectedV2 := &SequenceMessageV2{
+		SequenceMessage:          *expected,
+		InStreamMessageV2WithXid: InStreamMessageV2WithXid{Xid: xid},
+	}
+	expectedV2.msgType = MessageTypeSequence
+
+	m, err := ParseV2(msg, true)
+	s.NoError(err)
+	sequenceMsg, ok := m.(*SequenceMessageV2)
+	s.True(ok)
+
+	s.Equal(expectedV2, sequenceMsg)
+	s.assertEncoded(msg, sequenceMsg)
+}
+
+func (s *sequenceMessageSuiteV2) TestNoStream() {
+	msg := make([]byte, 1+1+8+7+9+1+8+8+1)
+	msg[0] = 'Q'
+	expected := s.putSequenceTestData(msg[1:])
+	expected.msgType = MessageTypeSequence
+	m, err := ParseV2(msg, false)
+	s.NoError(err)
+	sequenceMsg, ok := m.(*SequenceMessageV2)
+	s.True(ok)
+
+	s.Equal(uint32(0), sequenceMsg.Xid)
+	s.Equal(expected, &sequenceMsg.SequenceMessage)
+	s.assertEncoded(msg, sequenceMsg)
 }
 
 func TestStreamStartV2Suite(t *testing.T) {
@@ -79,6 +127,7 @@ func (s *streamStartSuite) Test() {
 	s.True(ok)
 
 	s.Equal(expected, startMsg)
+	s.assertEncoded(msg, startMsg)
 }
 
 func TestStreamStopV2Suite(t *testing.T) {
@@ -103,6 +152,7 @@ func (s *streamStopSuite) Test() {
 	s.True(ok)
 
 	s.Equal(expected, stopMsg)
+	s.assertEncoded(msg, stopMsg)
 }
 
 func TestStreamCommitV2Suite(t *testing.T) {
@@ -145,6 +195,7 @@ func (s *streamCommitSuite) Test() {
 	streamCommitMsg, ok := m.(*StreamCommitMessageV2)
 	s.True(ok)
 	s.Equal(expected, streamCommitMsg)
+	s.assertEncoded(msg, streamCommitMsg)
 }
 
 func TestStreamAbortV2Suite(t *testing.T) {
@@ -178,6 +229,7 @@ func (s *streamAbortSuite) Test() {
 	streamAbortMsg, ok := m.(*StreamAbortMessageV2)
 	s.True(ok)
 	s.Equal(expected, streamAbortMsg)
+	s.assertEncoded(msg, streamAbortMsg)
 }
 
 func TestRelationMessageV2Suite(t *testing.T) {
@@ -199,6 +251,7 @@ func (s *relationMessageV2Suite) Test() {
 	s.True(ok)
 	s.Equal(xid, relMsg.Xid)
 	s.Equal(expected, &relMsg.RelationMessage)
+	s.assertEncoded(msgV2, relMsg)
 }
 
 func (s *relationMessageV2Suite) TestNoStream() {
@@ -209,6 +262,7 @@ func (s *relationMessageV2Suite) TestNoStream() {
 	s.True(ok)
 	s.Equal(uint32(0), relMsg.Xid)
 	s.Equal(expected, &relMsg.RelationMessage)
+	s.assertEncoded(msg, relMsg)
 }
 
 func TestTypeMessageV2Suite(t *testing.T) {
@@ -229,6 +283,7 @@ func (s *typeMessageV2Suite) Test() {
 	s.True(ok)
 	s.Equal(xid, typeMsg.Xid)
 	s.Equal(expected, &typeMsg.TypeMessage)
+	s.assertEncoded(msgV2, typeMsg)
 }
 
 func (s *typeMessageV2Suite) TestNoStream() {
@@ -239,6 +294,7 @@ func (s *typeMessageV2Suite) TestNoStream() {
 	s.True(ok)
 	s.Equal(uint32(0), typeMsg.Xid)
 	s.Equal(expected, &typeMsg.TypeMessage)
+	s.assertEncoded(msg, typeMsg)
 }
 
 func TestInsertMessageV2Suite(t *testing.T) {
@@ -259,6 +315,7 @@ func (s *insertMessageV2Suite) Test() {
 	s.True(ok)
 	s.Equal(xid, insertMsg.Xid)
 	s.Equal(expected, &insertMsg.InsertMessage)
+	s.assertEncoded(msgV2, insertMsg)
 }
 
 func (s *insertMessageV2Suite) TestNoStream() {
@@ -270,6 +327,7 @@ func (s *insertMessageV2Suite) TestNoStream() {
 	s.True(ok)
 	s.Equal(uint32(0), insertMsg.Xid)
 	s.Equal(expected, &insertMsg.InsertMessage)
+	s.assertEncoded(msg, insertMsg)
 }
 
 func TestUpdateMessageV2Suite(t *testing.T) {
@@ -290,6 +348,7 @@ func (s *updateMessageV2Suite) TestUpdateV2WithOldTupleTypeK() {
 	s.True(ok)
 	s.Equal(xid, updateMsg.Xid)
 	s.Equal(expected, &updateMsg.UpdateMessage)
+	s.assertEncoded(msgV2, updateMsg)
 }
 
 func (s *updateMessageV2Suite) TestUpdateV2WithOldTupleTypeKNoStream() {
@@ -300,6 +359,7 @@ func (s *updateMessageV2Suite) TestUpdateV2WithOldTupleTypeKNoStream() {
 	s.True(ok)
 	s.Equal(uint32(0), updateMsg.Xid)
 	s.Equal(expected, &updateMsg.UpdateMessage)
+	s.assertEncoded(msg, updateMsg)
 }
 
 func (s *updateMessageV2Suite) TestUpdateV2WithOldTupleTypeO() {
@@ -312,6 +372,7 @@ func (s *updateMessageV2Suite) TestUpdateV2WithOldTupleTypeO() {
 	s.True(ok)
 	s.Equal(xid, updateMsg.Xid)
 	s.Equal(expected, &updateMsg.UpdateMessage)
+	s.assertEncoded(msgV2, updateMsg)
 }
 
 func (s *updateMessageV2Suite) TestUpdateV2WithOldTupleTypeONoStream() {
@@ -322,6 +383,7 @@ func (s *updateMessageV2Suite) TestUpdateV2WithOldTupleTypeONoStream() {
 	s.True(ok)
 	s.Equal(uint32(0), updateMsg.Xid)
 	s.Equal(expected, &updateMsg.UpdateMessage)
+	s.assertEncoded(msg, updateMsg)
 }
 
 func (s *updateMessageV2Suite) TestUpdateV2WithoutOldTuple() {
@@ -334,6 +396,7 @@ func (s *updateMessageV2Suite) TestUpdateV2WithoutOldTuple() {
 	s.True(ok)
 	s.Equal(xid, updateMsg.Xid)
 	s.Equal(expected, &updateMsg.UpdateMessage)
+	s.assertEncoded(msgV2, updateMsg)
 }
 
 func (s *updateMessageV2Suite) TestUpdateV2WithoutOldTupleNoStream() {
@@ -344,6 +407,7 @@ func (s *updateMessageV2Suite) TestUpdateV2WithoutOldTupleNoStream() {
 	s.True(ok)
 	s.Equal(uint32(0), updateMsg.Xid)
 	s.Equal(expected, &updateMsg.UpdateMessage)
+	s.assertEncoded(msg, updateMsg)
 }
 
 func TestDeleteMessageV2Suite(t *testing.T) {
@@ -364,6 +428,7 @@ func (s *deleteMessageV2Suite) TestV2WithOldTupleTypeK() {
 	s.True(ok)
 	s.Equal(xid, deleteMsg.Xid)
 	s.Equal(expected, &deleteMsg.DeleteMessage)
+	s.assertEncoded(msgV2, deleteMsg)
 }
 
 func (s *deleteMessageV2Suite) TestV2WithOldTupleTypeKNoStream() {
@@ -374,6 +439,7 @@ func (s *deleteMessageV2Suite) TestV2WithOldTupleTypeKNoStream() {
 	s.True(ok)
 	s.Equal(uint32(0), deleteMsg.Xid)
 	s.Equal(expected, &deleteMsg.DeleteMessage)
+	s.assertEncoded(msg, deleteMsg)
 }
 
 func (s *deleteMessageV2Suite) TestV2WithOldTupleTypeO() {
@@ -386,6 +452,7 @@ func (s *deleteMessageV2Suite) TestV2WithOldTupleTypeO() {
 	s.True(ok)
 	s.Equal(xid, deleteMsg.Xid)
 	s.Equal(expected, &deleteMsg.DeleteMessage)
+	s.assertEncoded(msgV2, deleteMsg)
 }
 
 func (s *deleteMessageV2Suite) TestV2WithOldTupleTypeONoStream() {
@@ -396,6 +463,7 @@ func (s *deleteMessageV2Suite) TestV2WithOldTupleTypeONoStream() {
 	s.True(ok)
 	s.Equal(uint32(0), deleteMsg.Xid)
 	s.Equal(expected, &deleteMsg.DeleteMessage)
+	s.assertEncoded(msg, deleteMsg)
 }
 
 func TestTruncateMessageV2Suite(t *testing.T) {
@@ -416,6 +484,7 @@ func (s *truncateMessageSuiteV2) Test() {
 	s.True(ok)
 	s.Equal(xid, truncateMsg.Xid)
 	s.Equal(expected, &truncateMsg.TruncateMessage)
+	s.assertEncoded(msgV2, truncateMsg)
 }
 
 func (s *truncateMessageSuiteV2) TestNoStream() {
@@ -426,4 +495,5 @@ func (s *truncateMessageSuiteV2) TestNoStream() {
 	s.True(ok)
 	s.Equal(uint32(0), truncateMsg.Xid)
 	s.Equal(expected, &truncateMsg.TruncateMessage)
+	s.assertEncoded(msg, truncateMsg)
 }
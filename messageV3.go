@@ -0,0 +1,331 @@
+package pglogrepl
+
+import (
+	"time"
+
+	"github.com/jackc/pgio"
+)
+
+// BeginPrepareMessageV3 is a begin prepare message.
+type BeginPrepareMessageV3 struct {
+	baseMessage
+	// PrepareLSN is the LSN of the prepare.
+	PrepareLSN LSN
+	// EndPrepareLSN is the end LSN of the prepared transaction.
+	EndPrepareLSN LSN
+	// PrepareTime is the prepare timestamp of the transaction.
+	PrepareTime time.Time
+	// Xid of the transaction.
+	Xid uint32
+	// UserGID is the user defined GID of the prepared transaction.
+	UserGID string
+}
+
+// Decode decodes the message from src.
+func (m *BeginPrepareMessageV3) Decode(src []byte) error {
+	if len(src) < 29 {
+		return m.lengthError("BeginPrepareMessageV3", 29, len(src))
+	}
+	var low, used int
+	m.PrepareLSN, used = m.decodeLSN(src)
+	low += used
+	m.EndPrepareLSN, used = m.decodeLSN(src[low:])
+	low += used
+	m.PrepareTime, used = m.decodeTime(src[low:])
+	low += used
+	m.Xid, used = m.decodeUint32(src[low:])
+	low += used
+	m.UserGID, used = m.decodeString(src[low:])
+	if used < 0 {
+		return m.decodeStringError("BeginPrepareMessageV3", "UserGID", low)
+	}
+
+	m.SetType(MessageTypeBeginPrepare)
+
+	return nil
+}
+
+// Encode appends the wire format of the message to dst.
+func (m *BeginPrepareMessageV3) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, byte(MessageTypeBeginPrepare))
+	dst = pgio.AppendUint64(dst, uint64(m.PrepareLSN))
+	dst = pgio.AppendUint64(dst, uint64(m.EndPrepareLSN))
+	dst = pgio.AppendInt64(dst, timeToPgTime(m.PrepareTime))
+	dst = pgio.AppendUint32(dst, m.Xid)
+	return encodeString(dst, "BeginPrepareMessageV3", "UserGID", m.UserGID)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *BeginPrepareMessageV3) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
+// PrepareMessageV3 is a prepare message.
+type PrepareMessageV3 struct {
+	baseMessage
+	// Flags currently unused (must be 0).
+	Flags uint8
+	// PrepareLSN is the LSN of the prepare.
+	PrepareLSN LSN
+	// EndPrepareLSN is the end LSN of the prepared transaction.
+	EndPrepareLSN LSN
+	// PrepareTime is the prepare timestamp of the transaction.
+	PrepareTime time.Time
+	// Xid of the transaction.
+	Xid uint32
+	// UserGID is the user defined GID of the prepared transaction.
+	UserGID string
+}
+
+// Decode decodes the message from src.
+func (m *PrepareMessageV3) Decode(src []byte) error {
+	if len(src) < 30 {
+		return m.lengthError("PrepareMessageV3", 30, len(src))
+	}
+	var low, used int
+	m.Flags = src[0]
+	low += 1
+	m.PrepareLSN, used = m.decodeLSN(src[low:])
+	low += used
+	m.EndPrepareLSN, used = m.decodeLSN(src[low:])
+	low += used
+	m.PrepareTime, used = m.decodeTime(src[low:])
+	low += used
+	m.Xid, used = m.decodeUint32(src[low:])
+	low += used
+	m.UserGID, used = m.decodeString(src[low:])
+	if used < 0 {
+		return m.decodeStringError("PrepareMessageV3", "UserGID", low)
+	}
+
+	m.SetType(MessageTypePrepare)
+
+	return nil
+}
+
+// Encode appends the wire format of the message to dst.
+func (m *PrepareMessageV3) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, byte(MessageTypePrepare), m.Flags)
+	dst = pgio.AppendUint64(dst, uint64(m.PrepareLSN))
+	dst = pgio.AppendUint64(dst, uint64(m.EndPrepareLSN))
+	dst = pgio.AppendInt64(dst, timeToPgTime(m.PrepareTime))
+	dst = pgio.AppendUint32(dst, m.Xid)
+	return encodeString(dst, "PrepareMessageV3", "UserGID", m.UserGID)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *PrepareMessageV3) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
+// CommitPreparedMessageV3 is a commit prepared message.
+type CommitPreparedMessageV3 struct {
+	baseMessage
+	// Flags currently unused (must be 0).
+	Flags uint8
+	// CommitLSN is the LSN of the commit of the prepared transaction.
+	CommitLSN LSN
+	// EndCommitLSN is the end LSN of the commit of the prepared transaction.
+	EndCommitLSN LSN
+	// CommitTime is the commit timestamp of the transaction.
+	CommitTime time.Time
+	// Xid of the transaction.
+	Xid uint32
+	// UserGID is the user defined GID of the prepared transaction.
+	UserGID string
+}
+
+// Decode decodes the message from src.
+func (m *CommitPreparedMessageV3) Decode(src []byte) error {
+	if len(src) < 30 {
+		return m.lengthError("CommitPreparedMessageV3", 30, len(src))
+	}
+	var low, used int
+	m.Flags = src[0]
+	low += 1
+	m.CommitLSN, used = m.decodeLSN(src[low:])
+	low += used
+	m.EndCommitLSN, used = m.decodeLSN(src[low:])
+	low += used
+	m.CommitTime, used = m.decodeTime(src[low:])
+	low += used
+	m.Xid, used = m.decodeUint32(src[low:])
+	low += used
+	m.UserGID, used = m.decodeString(src[low:])
+	if used < 0 {
+		return m.decodeStringError("CommitPreparedMessageV3", "UserGID", low)
+	}
+
+	m.SetType(MessageTypeCommitPrepared)
+
+	return nil
+}
+
+// Encode appends the wire format of the message to dst.
+func (m *CommitPreparedMessageV3) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, byte(MessageTypeCommitPrepared), m.Flags)
+	dst = pgio.AppendUint64(dst, uint64(m.CommitLSN))
+	dst = pgio.AppendUint64(dst, uint64(m.EndCommitLSN))
+	dst = pgio.AppendInt64(dst, timeToPgTime(m.CommitTime))
+	dst = pgio.AppendUint32(dst, m.Xid)
+	return encodeString(dst, "CommitPreparedMessageV3", "UserGID", m.UserGID)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *CommitPreparedMessageV3) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
+// RollbackPreparedMessageV3 is a rollback prepared message.
+type RollbackPreparedMessageV3 struct {
+	baseMessage
+	// Flags currently unused (must be 0).
+	Flags uint8
+	// EndPrepareLSN is the end LSN of the prepared transaction.
+	EndPrepareLSN LSN
+	// EndRollbackLSN is the end LSN of the rollback of the prepared transaction.
+	EndRollbackLSN LSN
+	// PrepareTime is the prepare timestamp of the transaction.
+	PrepareTime time.Time
+	// RollbackTime is the rollback timestamp of the transaction.
+	RollbackTime time.Time
+	// Xid of the transaction.
+	Xid uint32
+	// UserGID is the user defined GID of the prepared transaction.
+	UserGID string
+}
+
+// Decode decodes the message from src.
+func (m *RollbackPreparedMessageV3) Decode(src []byte) error {
+	if len(src) < 38 {
+		return m.lengthError("RollbackPreparedMessageV3", 38, len(src))
+	}
+	var low, used int
+	m.Flags = src[0]
+	low += 1
+	m.EndPrepareLSN, used = m.decodeLSN(src[low:])
+	low += used
+	m.EndRollbackLSN, used = m.decodeLSN(src[low:])
+	low += used
+	m.PrepareTime, used = m.decodeTime(src[low:])
+	low += used
+	m.RollbackTime, used = m.decodeTime(src[low:])
+	low += used
+	m.Xid, used = m.decodeUint32(src[low:])
+	low += used
+	m.UserGID, used = m.decodeString(src[low:])
+	if used < 0 {
+		return m.decodeStringError("RollbackPreparedMessageV3", "UserGID", low)
+	}
+
+	m.SetType(MessageTypeRollbackPrepared)
+
+	return nil
+}
+
+// Encode appends the wire format of the message to dst.
+func (m *RollbackPreparedMessageV3) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, byte(MessageTypeRollbackPrepared), m.Flags)
+	dst = pgio.AppendUint64(dst, uint64(m.EndPrepareLSN))
+	dst = pgio.AppendUint64(dst, uint64(m.EndRollbackLSN))
+	dst = pgio.AppendInt64(dst, timeToPgTime(m.PrepareTime))
+	dst = pgio.AppendInt64(dst, timeToPgTime(m.RollbackTime))
+	dst = pgio.AppendUint32(dst, m.Xid)
+	return encodeString(dst, "RollbackPreparedMessageV3", "UserGID", m.UserGID)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *RollbackPreparedMessageV3) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
+// StreamPrepareMessageV3 is a stream prepare message.
+type StreamPrepareMessageV3 struct {
+	baseMessage
+	// Flags currently unused (must be 0).
+	Flags uint8
+	// PrepareLSN is the LSN of the prepare.
+	PrepareLSN LSN
+	// EndPrepareLSN is the end LSN of the prepared transaction.
+	EndPrepareLSN LSN
+	// PrepareTime is the prepare timestamp of the transaction.
+	PrepareTime time.Time
+	// Xid of the transaction.
+	Xid uint32
+	// UserGID is the user defined GID of the prepared transaction.
+	UserGID string
+}
+
+// Decode decodes the message from src.
+func (m *StreamPrepareMessageV3) Decode(src []byte) error {
+	if len(src) < 30 {
+		return m.lengthError("StreamPrepareMessageV3", 30, len(src))
+	}
+	var low, used int
+	m.Flags = src[0]
+	low += 1
+	m.PrepareLSN, used = m.decodeLSN(src[low:])
+	low += used
+	m.EndPrepareLSN, used = m.decodeLSN(src[low:])
+	low += used
+	m.PrepareTime, used = m.decodeTime(src[low:])
+	low += used
+	m.Xid, used = m.decodeUint32(src[low:])
+	low += used
+	m.UserGID, used = m.decodeString(src[low:])
+	if used < 0 {
+		return m.decodeStringError("StreamPrepareMessageV3", "UserGID", low)
+	}
+
+	m.SetType(MessageTypeStreamPrepare)
+
+	return nil
+}
+
+// Encode appends the wire format of the message to dst.
+func (m *StreamPrepareMessageV3) Encode(dst []byte) ([]byte, error) {
+	dst = append(dst, byte(MessageTypeStreamPrepare), m.Flags)
+	dst = pgio.AppendUint64(dst, uint64(m.PrepareLSN))
+	dst = pgio.AppendUint64(dst, uint64(m.EndPrepareLSN))
+	dst = pgio.AppendInt64(dst, timeToPgTime(m.PrepareTime))
+	dst = pgio.AppendUint32(dst, m.Xid)
+	return encodeString(dst, "StreamPrepareMessageV3", "UserGID", m.UserGID)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *StreamPrepareMessageV3) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
+// ParseV3 parse a logical replication message from protocol version #3.
+// Protocol version #3 is used with two_phase 'true' and adds the two-phase commit
+// messages on top of protocol version #2. The inStream parameter has the same meaning
+// as for ParseV2.
+func ParseV3(data []byte, inStream bool) (m Message, err error) {
+	if len(data) == 0 {
+		return nil, truncatedError("Message", 1, 0)
+	}
+	var decoder MessageDecoder
+	msgType := MessageType(data[0])
+
+	switch msgType {
+	case MessageTypeBeginPrepare:
+		decoder = new(BeginPrepareMessageV3)
+	case MessageTypePrepare:
+		decoder = new(PrepareMessageV3)
+	case MessageTypeCommitPrepared:
+		decoder = new(CommitPreparedMessageV3)
+	case MessageTypeRollbackPrepared:
+		decoder = new(RollbackPreparedMessageV3)
+	case MessageTypeStreamPrepare:
+		decoder = new(StreamPrepareMessageV3)
+	default:
+		return ParseV2(data, inStream)
+	}
+
+	if err = decoder.Decode(data[1:]); err != nil {
+		return nil, messageError(err, data)
+	}
+
+	return decoder.(Message), nil
+}
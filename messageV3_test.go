@@ -0,0 +1,266 @@
+package pglogrepl
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func (s *messageSuite) assertV2NotSupported(msg []byte) {
+	_, err := ParseV2(msg, false)
+	s.Error(err)
+	s.True(errors.Is(err, ErrUnknownMessageType))
+}
+
+func TestBeginPrepareV3Suite(t *testing.T) {
+	suite.Run(t, new(beginPrepareSuite))
+}
+
+type beginPrepareSuite struct {
+	messageSuite
+}
+
+func (s *beginPrepareSuite) Test() {
+	gid := "pg_gid_1"
+	msg := make([]byte, 1+8+8+8+4+len(gid)+1)
+	prepareLSN := s.newLSN()
+	endPrepareLSN := s.newLSN()
+	prepareTime, pgPrepareTime := s.newTime()
+	xid := s.newXid()
+
+	msg[0] = 'b'
+	bigEndian.PutUint64(msg[1:], uint64(prepareLSN))
+	bigEndian.PutUint64(msg[9:], uint64(endPrepareLSN))
+	bigEndian.PutUint64(msg[17:], pgPrepareTime)
+	bigEndian.PutUint32(msg[25:], xid)
+	s.putString(msg[29:], gid)
+
+	expected := &BeginPrepareMessageV3{
+		PrepareLSN:    prepareLSN,
+		EndPrepareLSN: endPrepareLSN,
+		PrepareTime:   prepareTime,
+		Xid:           xid,
+		UserGID:       gid,
+	}
+	expected.msgType = MessageTypeBeginPrepare
+
+	s.assertV1NotSupported(msg)
+	s.assertV2NotSupported(msg)
+
+	m, err := ParseV3(msg, false)
+	s.NoError(err)
+	beginPrepareMsg, ok := m.(*BeginPrepareMessageV3)
+	s.True(ok)
+	s.Equal(expected, beginPrepareMsg)
+	s.assertEncoded(msg, beginPrepareMsg)
+}
+
+func TestPrepareV3Suite(t *testing.T) {
+	suite.Run(t, new(prepareSuite))
+}
+
+type prepareSuite struct {
+	messageSuite
+}
+
+func (s *prepareSuite) Test() {
+	gid := "pg_gid_2"
+	msg := make([]byte, 1+1+8+8+8+4+len(gid)+1)
+	prepareLSN := s.newLSN()
+	endPrepareLSN := s.newLSN()
+	prepareTime, pgPrepareTime := s.newTime()
+	xid := s.newXid()
+
+	msg[0] = 'P'
+	msg[1] = 0
+	bigEndian.PutUint64(msg[2:], uint64(prepareLSN))
+	bigEndian.PutUint64(msg[10:], uint64(endPrepareLSN))
+	bigEndian.PutUint64(msg[18:], pgPrepareTime)
+	bigEndian.PutUint32(msg[26:], xid)
+	s.putString(msg[30:], gid)
+
+	expected := &PrepareMessageV3{
+		PrepareLSN:    prepareLSN,
+		EndPrepareLSN: endPrepareLSN,
+		PrepareTime:   prepareTime,
+		Xid:           xid,
+		UserGID:       gid,
+	}
+	expected.msgType = MessageTypePrepare
+
+	s.assertV1NotSupported(msg)
+	s.assertV2NotSupported(msg)
+
+	m, err := ParseV3(msg, false)
+	s.NoError(err)
+	prepareMsg, ok := m.(*PrepareMessageV3)
+	s.True(ok)
+	s.Equal(expected, prepareMsg)
+	s.assertEncoded(msg, prepareMsg)
+}
+
+func TestCommitPreparedV3Suite(t *testing.T) {
+	suite.Run(t, new(commitPreparedSuite))
+}
+
+type commitPreparedSuite struct {
+	messageSuite
+}
+
+func (s *commitPreparedSuite) Test() {
+	gid := "pg_gid_3"
+	msg := make([]byte, 1+1+8+8+8+4+len(gid)+1)
+	commitLSN := s.newLSN()
+	endCommitLSN := s.newLSN()
+	commitTime, pgCommitTime := s.newTime()
+	xid := s.newXid()
+
+	msg[0] = 'K'
+	msg[1] = 0
+	bigEndian.PutUint64(msg[2:], uint64(commitLSN))
+	bigEndian.PutUint64(msg[10:], uint64(endCommitLSN))
+	bigEndian.PutUint64(msg[18:], pgCommitTime)
+	bigEndian.PutUint32(msg[26:], xid)
+	s.putString(msg[30:], gid)
+
+	expected := &CommitPreparedMessageV3{
+		CommitLSN:    commitLSN,
+		EndCommitLSN: endCommitLSN,
+		CommitTime:   commitTime,
+		Xid:          xid,
+		UserGID:      gid,
+	}
+	expected.msgType = MessageTypeCommitPrepared
+
+	s.assertV1NotSupported(msg)
+	s.assertV2NotSupported(msg)
+
+	m, err := ParseV3(msg, false)
+	s.NoError(err)
+	commitPreparedMsg, ok := m.(*CommitPreparedMessageV3)
+	s.True(ok)
+	s.Equal(expected, commitPreparedMsg)
+	s.assertEncoded(msg, commitPreparedMsg)
+}
+
+func TestRollbackPreparedV3Suite(t *testing.T) {
+	suite.Run(t, new(rollbackPreparedSuite))
+}
+
+type rollbackPreparedSuite struct {
+	messageSuite
+}
+
+func (s *rollbackPreparedSuite) Test() {
+	gid := "pg_gid_4"
+	msg := make([]byte, 1+1+8+8+8+8+4+len(gid)+1)
+	endPrepareLSN := s.newLSN()
+	endRollbackLSN := s.newLSN()
+	prepareTime, pgPrepareTime := s.newTime()
+	rollbackTime, pgRollbackTime := s.newTime()
+	xid := s.newXid()
+
+	msg[0] = 'r'
+	msg[1] = 0
+	bigEndian.PutUint64(msg[2:], uint64(endPrepareLSN))
+	bigEndian.PutUint64(msg[10:], uint64(endRollbackLSN))
+	bigEndian.PutUint64(msg[18:], pgPrepareTime)
+	bigEndian.PutUint64(msg[26:], pgRollbackTime)
+	bigEndian.PutUint32(msg[34:], xid)
+	s.putString(msg[38:], gid)
+
+	expected := &RollbackPreparedMessageV3{
+		EndPrepareLSN:  endPrepareLSN,
+		EndRollbackLSN: endRollbackLSN,
+		PrepareTime:    prepareTime,
+		RollbackTime:   rollbackTime,
+		Xid:            xid,
+		UserGID:        gid,
+	}
+	expected.msgType = MessageTypeRollbackPrepared
+
+	s.assertV1NotSupported(msg)
+	s.assertV2NotSupported(msg)
+
+	m, err := ParseV3(msg, false)
+	s.NoError(err)
+	rollbackPreparedMsg, ok := m.(*RollbackPreparedMessageV3)
+	s.True(ok)
+	s.Equal(expected, rollbackPreparedMsg)
+	s.assertEncoded(msg, rollbackPreparedMsg)
+}
+
+func TestStreamPrepareV3Suite(t *testing.T) {
+	suite.Run(t, new(streamPrepareSuite))
+}
+
+type streamPrepareSuite struct {
+	messageSuite
+}
+
+func (s *streamPrepareSuite) Test() {
+	gid := "pg_gid_5"
+	msg := make([]byte, 1+1+8+8+8+4+len(gid)+1)
+	prepareLSN := s.newLSN()
+	endPrepareLSN := s.newLSN()
+	prepareTime, pgPrepareTime := s.newTime()
+	xid := s.newXid()
+
+	msg[0] = 'p'
+	msg[1] = 0
+	bigEndian.PutUint64(msg[2:], uint64(prepareLSN))
+	bigEndian.PutUint64(msg[10:], uint64(endPrepareLSN))
+	bigEndian.PutUint64(msg[18:], pgPrepareTime)
+	bigEndian.PutUint32(msg[26:], xid)
+	s.putString(msg[30:], gid)
+
+	expected := &StreamPrepareMessageV3{
+		PrepareLSN:    prepareLSN,
+		EndPrepareLSN: endPrepareLSN,
+		PrepareTime:   prepareTime,
+		Xid:           xid,
+		UserGID:       gid,
+	}
+	expected.msgType = MessageTypeStreamPrepare
+
+	s.assertV1NotSupported(msg)
+	s.assertV2NotSupported(msg)
+
+	m, err := ParseV3(msg, false)
+	s.NoError(err)
+	streamPrepareMsg, ok := m.(*StreamPrepareMessageV3)
+	s.True(ok)
+	s.Equal(expected, streamPrepareMsg)
+	s.assertEncoded(msg, streamPrepareMsg)
+}
+
+func (s *streamPrepareSuite) TestMissingGID() {
+	msg := make([]byte, 1+1+8+8+8+4+1)
+	msg[0] = 'p'
+	msg[len(msg)-1] = 'x'
+
+	_, err := ParseV3(msg, false)
+	s.Error(err)
+}
+
+func TestParseV3FallbackSuite(t *testing.T) {
+	suite.Run(t, new(parseV3FallbackSuite))
+}
+
+type parseV3FallbackSuite struct {
+	messageSuite
+}
+
+func (s *parseV3FallbackSuite) Test() {
+	msg, expected := s.createInsertTestData()
+	msgV2, xid := s.insertXid(msg)
+
+	m, err := ParseV3(msgV2, true)
+	s.NoError(err)
+	insertMsg, ok := m.(*InsertMessageV2)
+	s.True(ok)
+	s.Equal(xid, insertMsg.Xid)
+	s.Equal(expected, &insertMsg.InsertMessage)
+	s.assertEncoded(msgV2, insertMsg)
+}
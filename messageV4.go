@@ -0,0 +1,77 @@
+package pglogrepl
+
+import (
+	"time"
+
+	"github.com/jackc/pgio"
+)
+
+// StreamAbortMessageV4 is a stream abort message from protocol version #4.
+type StreamAbortMessageV4 struct {
+	StreamAbortMessageV2
+
+	// AbortLSN is the LSN of the abort operation. It is only sent when streaming is set to
+	// parallel, otherwise it is 0.
+	AbortLSN LSN
+	// AbortTime is the abort timestamp of the transaction. It is only sent when streaming is
+	// set to parallel, otherwise it is the zero value.
+	AbortTime time.Time
+}
+
+// DecodeV2 decodes to message from V4 src.
+func (m *StreamAbortMessageV4) DecodeV2(src []byte, inStream bool) (err error) {
+	if err = m.StreamAbortMessageV2.DecodeV2(src, inStream); err != nil {
+		return err
+	}
+	if len(src) == 8 {
+		return nil
+	}
+	if len(src) < 24 {
+		return m.lengthError("StreamAbortMessageV4", 24, len(src))
+	}
+
+	low := 8
+	var used int
+	m.AbortLSN, used = m.decodeLSN(src[low:])
+	low += used
+	m.AbortTime, _ = m.decodeTime(src[low:])
+
+	return nil
+}
+
+// Encode appends the wire format of the message to dst. The abort LSN and timestamp are only
+// encoded if AbortLSN is not 0, as they are only sent when streaming is set to parallel.
+func (m *StreamAbortMessageV4) Encode(dst []byte) ([]byte, error) {
+	dst, err := m.StreamAbortMessageV2.Encode(dst)
+	if err != nil || m.AbortLSN == 0 {
+		return dst, err
+	}
+	dst = pgio.AppendUint64(dst, uint64(m.AbortLSN))
+	dst = pgio.AppendInt64(dst, timeToPgTime(m.AbortTime))
+	return dst, nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *StreamAbortMessageV4) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
+// ParseV4 parse a logical replication message from protocol version #4.
+// Protocol version #4 is used with streaming 'parallel' and extends the stream abort
+// message with the abort LSN and timestamp. The inStream parameter has the same
+// meaning as for ParseV2.
+func ParseV4(data []byte, inStream bool) (m Message, err error) {
+	if len(data) == 0 {
+		return nil, truncatedError("Message", 1, 0)
+	}
+	switch MessageType(data[0]) {
+	case MessageTypeStreamAbort:
+		msg := new(StreamAbortMessageV4)
+		if err = msg.DecodeV2(data[1:], inStream); err != nil {
+			return nil, messageError(err, data)
+		}
+		return msg, nil
+	default:
+		return ParseV3(data, inStream)
+	}
+}
@@ -0,0 +1,90 @@
+package pglogrepl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestStreamAbortV4Suite(t *testing.T) {
+	suite.Run(t, new(streamAbortV4Suite))
+}
+
+type streamAbortV4Suite struct {
+	messageSuite
+}
+
+func (s *streamAbortV4Suite) Test() {
+	msg := make([]byte, 1+4+4+8+8)
+
+	xid := s.newXid()
+	subXid := s.newXid()
+	abortLSN := s.newLSN()
+	abortTime, pgAbortTime := s.newTime()
+
+	msg[0] = 'A'
+	bigEndian.PutUint32(msg[1:], xid)
+	bigEndian.PutUint32(msg[5:], subXid)
+	bigEndian.PutUint64(msg[9:], uint64(abortLSN))
+	bigEndian.PutUint64(msg[17:], pgAbortTime)
+
+	expected := &StreamAbortMessageV4{
+		StreamAbortMessageV2: StreamAbortMessageV2{
+			Xid:    xid,
+			SubXid: subXid,
+		},
+		AbortLSN:  abortLSN,
+		AbortTime: abortTime,
+	}
+	expected.msgType = MessageTypeStreamAbort
+
+	m, err := ParseV4(msg, false)
+	s.NoError(err)
+	streamAbortMsg, ok := m.(*StreamAbortMessageV4)
+	s.True(ok)
+	s.Equal(expected, streamAbortMsg)
+	s.assertEncoded(msg, streamAbortMsg)
+}
+
+func (s *streamAbortV4Suite) TestWithoutParallel() {
+	msg := make([]byte, 1+4+4)
+
+	xid := s.newXid()
+	subXid := s.newXid()
+
+	msg[0] = 'A'
+	bigEndian.PutUint32(msg[1:], xid)
+	bigEndian.PutUint32(msg[5:], subXid)
+
+	m, err := ParseV4(msg, false)
+	s.NoError(err)
+	streamAbortMsg, ok := m.(*StreamAbortMessageV4)
+	s.True(ok)
+	s.Equal(xid, streamAbortMsg.Xid)
+	s.Equal(subXid, streamAbortMsg.SubXid)
+	s.Equal(LSN(0), streamAbortMsg.AbortLSN)
+	s.Equal(time.Time{}, streamAbortMsg.AbortTime)
+}
+
+func (s *streamAbortV4Suite) TestTruncated() {
+	msg := make([]byte, 1+4+4+8)
+	msg[0] = 'A'
+
+	_, err := ParseV4(msg, false)
+	s.Error(err)
+}
+
+func (s *streamAbortV4Suite) TestFallback() {
+	msg := make([]byte, 1+4+1)
+	msg[0] = 'S'
+	xid := s.newXid()
+	bigEndian.PutUint32(msg[1:], xid)
+	msg[5] = 1
+
+	m, err := ParseV4(msg, false)
+	s.NoError(err)
+	startMsg, ok := m.(*StreamStartMessageV2)
+	s.True(ok)
+	s.Equal(xid, startMsg.Xid)
+}
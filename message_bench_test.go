@@ -0,0 +1,74 @@
+package pglogrepl
+
+import (
+	"fmt"
+	"testing"
+)
+
+// benchColumnNum is the number of columns of the table of the benchmarks, a wide table.
+const benchColumnNum = 50
+
+func benchTuple() *TupleData {
+	tuple := &TupleData{ColumnNum: benchColumnNum}
+	for i := 0; i < benchColumnNum; i++ {
+		data := []byte(fmt.Sprintf("value of column %d", i))
+		tuple.Columns = append(tuple.Columns, &TupleDataColumn{DataType: TupleDataTypeText, Length: uint32(len(data)), Data: data})
+	}
+	return tuple
+}
+
+func benchEncode(b *testing.B, m interface{ Encode([]byte) ([]byte, error) }) []byte {
+	data, err := m.Encode(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+func benchParseV2(b *testing.B, data []byte) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseV2(data, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseV2Insert(b *testing.B) {
+	benchParseV2(b, benchEncode(b, &InsertMessage{RelationID: 1, Tuple: benchTuple()}))
+}
+
+func BenchmarkParseV2Update(b *testing.B) {
+	benchParseV2(b, benchEncode(b, &UpdateMessage{RelationID: 1, OldTupleType: UpdateMessageTupleTypeOld, OldTuple: benchTuple(), NewTuple: benchTuple()}))
+}
+
+func BenchmarkParseV2Delete(b *testing.B) {
+	benchParseV2(b, benchEncode(b, &DeleteMessage{RelationID: 1, OldTupleType: DeleteMessageTupleTypeOld, OldTuple: benchTuple()}))
+}
+
+func BenchmarkParseV2Relation(b *testing.B) {
+	relation := &RelationMessage{RelationID: 1, Namespace: "public", RelationName: "wide", ReplicaIdentity: 'd', ColumnNum: benchColumnNum}
+	for i := 0; i < benchColumnNum; i++ {
+		relation.Columns = append(relation.Columns, &RelationMessageColumn{Name: fmt.Sprintf("column_%d", i), DataType: 25, TypeModifier: -1})
+	}
+	benchParseV2(b, benchEncode(b, relation))
+}
+
+func BenchmarkDecoderInsert(b *testing.B) {
+	data := benchEncode(b, &InsertMessage{RelationID: 1, Tuple: benchTuple()})
+	d, err := NewDecoder(2)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Reset(data)
+		if _, err := d.Decode(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -61,7 +61,7 @@ func (s *messageSuite) tupleColumnLength(dataType uint8, data []byte) int {
 	switch dataType {
 	case uint8('n'), uint8('u'):
 		return 1
-	case uint8('t'):
+	case uint8('t'), uint8('b'):
 		return 1 + 4 + len(data)
 	default:
 		s.FailNow("invalid data type of a tuple: %c", dataType)
@@ -75,7 +75,7 @@ func (s *messageSuite) putTupleColumn(dst []byte, dataType uint8, data []byte) i
 	switch dataType {
 	case uint8('n'), uint8('u'):
 		return 1
-	case uint8('t'):
+	case uint8('t'), uint8('b'):
 		bigEndian.PutUint32(dst[1:], uint32(len(data)))
 		copy(dst[5:], data)
 		return 5 + len(data)
@@ -113,10 +113,49 @@ func (s *messageSuite) putMessageTestData(msg []byte) *LogicalDecodingMessage {
 	}
 }
 
+func (s *messageSuite) putSequenceTestData(msg []byte) *SequenceMessage {
+	// flags
+	msg[0] = 0
+	off := 1
+
+	lsn := s.newLSN()
+	bigEndian.PutUint64(msg[off:], uint64(lsn))
+	off += 8
+
+	off += s.putString(msg[off:], "public")
+	off += s.putString(msg[off:], "t_id_seq")
+
+	// transactional
+	msg[off] = 0
+	off++
+	bigEndian.PutUint64(msg[off:], 33)
+	off += 8
+	bigEndian.PutUint64(msg[off:], 32)
+	off += 8
+	// is_called
+	msg[off] = 1
+	return &SequenceMessage{
+		LSN:       lsn,
+		Namespace: "public",
+		Name:      "t_id_seq",
+		LastValue: 33,
+		LogCount:  32,
+		IsCalled:  true,
+	}
+}
+
 func (s *messageSuite) assertV1NotSupported(msg []byte) {
 	_, err := Parse(msg)
 	s.Error(err)
-	s.True(errors.Is(err, errMsgNotSupported))
+	s.True(errors.Is(err, ErrUnknownMessageType))
+}
+
+func (s *messageSuite) assertEncoded(expected []byte, m Message) {
+	encoder, ok := m.(MessageEncoder)
+	s.True(ok)
+	data, err := encoder.Encode(nil)
+	s.NoError(err)
+	s.Equal(expected, data)
 }
 
 func (s *messageSuite) createRelationTestData() ([]byte, *RelationMessage) {
@@ -609,6 +648,7 @@ func (s *beginMessageSuite) Test() {
 	}
 	expected.msgType = 'B'
 	s.Equal(expected, beginMsg)
+	s.assertEncoded(msg, beginMsg)
 }
 
 func TestCommitMessage(t *testing.T) {
@@ -645,6 +685,7 @@ func (s *commitMessageSuite) Test() {
 	}
 	expected.msgType = 'C'
 	s.Equal(expected, commitMsg)
+	s.assertEncoded(msg, commitMsg)
 }
 
 func TestOriginMessage(t *testing.T) {
@@ -675,6 +716,7 @@ func (s *originMessageSuite) Test() {
 	}
 	expected.msgType = 'O'
 	s.Equal(expected, originMsg)
+	s.assertEncoded(msg, originMsg)
 }
 
 func TestRelationMessageSuite(t *testing.T) {
@@ -695,6 +737,15 @@ func (s *relationMessageSuite) Test() {
 	s.True(ok)
 
 	s.Equal(expected, relationMsg)
+	s.assertEncoded(msg, relationMsg)
+}
+
+func (s *relationMessageSuite) TestTruncated() {
+	msg, _ := s.createRelationTestData()
+	for i := 1; i < len(msg); i++ {
+		_, err := Parse(msg[:i])
+		s.Error(err, i)
+	}
 }
 
 func TestTypeMessageSuite(t *testing.T) {
@@ -714,6 +765,7 @@ func (s *typeMessageSuite) Test() {
 	s.True(ok)
 
 	s.Equal(expected, typeMsg)
+	s.assertEncoded(msg, typeMsg)
 }
 
 func TestInsertMessageSuite(t *testing.T) {
@@ -734,6 +786,55 @@ func (s *insertMessageSuite) Test() {
 	s.True(ok)
 
 	s.Equal(expected, insertMsg)
+	s.assertEncoded(msg, insertMsg)
+}
+
+func (s *insertMessageSuite) TestBinary() {
+	relationID := s.newRelationID()
+
+	col1Data := bigEndian.AppendUint64(nil, 1)
+	col2Data := []byte("myname")
+	col1Length := s.tupleColumnLength('b', col1Data)
+	col2Length := s.tupleColumnLength('b', col2Data)
+
+	msg := make([]byte, 1+4+1+2+col1Length+col2Length)
+	msg[0] = 'I'
+	off := 1
+	bigEndian.PutUint32(msg[off:], relationID)
+	off += 4
+	msg[off] = 'N'
+	off++
+	bigEndian.PutUint16(msg[off:], 2)
+	off += 2
+	off += s.putTupleColumn(msg[off:], 'b', col1Data)
+	s.putTupleColumn(msg[off:], 'b', col2Data)
+
+	expected := &InsertMessage{
+		RelationID: relationID,
+		Tuple: &TupleData{
+			ColumnNum: 2,
+			Columns: []*TupleDataColumn{
+				{
+					DataType: TupleDataTypeBinary,
+					Length:   uint32(len(col1Data)),
+					Data:     col1Data,
+				},
+				{
+					DataType: TupleDataTypeBinary,
+					Length:   uint32(len(col2Data)),
+					Data:     col2Data,
+				},
+			},
+		},
+	}
+	expected.msgType = 'I'
+
+	m, err := Parse(msg)
+	s.NoError(err)
+	insertMsg, ok := m.(*InsertMessage)
+	s.True(ok)
+	s.Equal(expected, insertMsg)
+	s.assertEncoded(msg, insertMsg)
 }
 
 func TestUpdateMessageSuite(t *testing.T) {
@@ -752,6 +853,7 @@ func (s *updateMessageSuite) TestWithOldTupleTypeK() {
 	s.True(ok)
 
 	s.Equal(expected, updateMsg)
+	s.assertEncoded(msg, updateMsg)
 }
 
 func (s *updateMessageSuite) TestWithOldTupleTypeO() {
@@ -762,6 +864,7 @@ func (s *updateMessageSuite) TestWithOldTupleTypeO() {
 	s.True(ok)
 
 	s.Equal(expected, updateMsg)
+	s.assertEncoded(msg, updateMsg)
 }
 
 func (s *updateMessageSuite) TestWithoutOldTuple() {
@@ -772,6 +875,7 @@ func (s *updateMessageSuite) TestWithoutOldTuple() {
 	s.True(ok)
 
 	s.Equal(expected, updateMsg)
+	s.assertEncoded(msg, updateMsg)
 }
 
 func TestDeleteMessageSuite(t *testing.T) {
@@ -791,6 +895,7 @@ func (s *deleteMessageSuite) TestWithOldTupleTypeK() {
 	s.True(ok)
 
 	s.Equal(expected, deleteMsg)
+	s.assertEncoded(msg, deleteMsg)
 }
 
 func (s *deleteMessageSuite) TestWithOldTupleTypeO() {
@@ -802,6 +907,7 @@ func (s *deleteMessageSuite) TestWithOldTupleTypeO() {
 	s.True(ok)
 
 	s.Equal(expected, deleteMsg)
+	s.assertEncoded(msg, deleteMsg)
 }
 
 func TestTruncateMessageSuite(t *testing.T) {
@@ -821,6 +927,7 @@ func (s *truncateMessageSuite) Test() {
 	s.True(ok)
 
 	s.Equal(expected, truncateMsg)
+	s.assertEncoded(msg, truncateMsg)
 }
 
 func TestLogicalDecodingMessageSuite(t *testing.T) {
@@ -845,4 +952,76 @@ func (s *logicalDecodingMessageSuite) Test() {
 	s.True(ok)
 
 	s.Equal(expected, logicalDecodingMsg)
+	s.assertEncoded(msg, logicalDecodingMsg)
+}
+
+func TestSequenceMessageSuite(t *testing.T) {
+	suite.Run(t, new(sequenceMessageSuite))
+}
+
+type sequenceMessageSuite struct {
+	messageSuite
+}
+
+func (s *sequenceMessageSuite) Test() {
+	msg := make([]byte, 1+1+8+7+9+1+8+8+1)
+	msg[0] = 'Q'
+
+	expected := s.putSequenceTestData(msg[1:])
+
+	expected.msgType = MessageTypeSequence
+
+	m, err := Parse(msg)
+	s.NoError(err)
+	sequenceMsg, ok := m.(*SequenceMessage)
+	s.True(ok)
+
+	s.Equal(expected, sequenceMsg)
+	s.assertEncoded(msg, sequenceMsg)
+}
+
+func (s *sequenceMessageSuite) TestTruncated() {
+	msg := make([]byte, 1+1+8+7+9+1+8+8+1)
+	msg[0] = 'Q'
+	s.putSequenceTestData(msg[1:])
+
+	_, err := Parse(msg[:len(msg)-1])
+	s.True(errors.Is(err, ErrTruncatedMessage))
+}
+
+func TestEncodeErrorsSuite(t *testing.T) {
+	suite.Run(t, new(encodeErrorsSuite))
+}
+
+type encodeErrorsSuite struct {
+	messageSuite
+}
+
+func (s *encodeErrorsSuite) TestNullByteInString() {
+	m := &OriginMessage{CommitLSN: s.newLSN(), Name: "bad\x00name"}
+	_, err := m.Encode(nil)
+	s.Error(err)
+}
+
+func (s *encodeErrorsSuite) TestNilTuple() {
+	m := &InsertMessage{RelationID: s.newRelationID()}
+	_, err := m.Encode(nil)
+	s.Error(err)
+}
+
+func (s *encodeErrorsSuite) TestInvalidTupleDataType() {
+	m := &InsertMessage{
+		RelationID: s.newRelationID(),
+		Tuple:      &TupleData{Columns: []*TupleDataColumn{{DataType: 'x'}}},
+	}
+	_, err := m.Encode(nil)
+	s.Error(err)
+}
+
+func (s *encodeErrorsSuite) TestAppend() {
+	msg, expected := s.createTypeTestData()
+	prefix := []byte{1, 2, 3}
+	data, err := expected.Encode(prefix)
+	s.NoError(err)
+	s.Equal(append([]byte{1, 2, 3}, msg...), data)
 }
@@ -0,0 +1,37 @@
+package pglogrepl
+
+import (
+	"time"
+)
+
+// StreamMetrics receives the measurements of a ReplicationStream configured with it. The package
+// does not depend on a metrics library: an implementation typically increments the counters and
+// sets the gauges of a Prometheus registry, for example a CounterVec of messages labeled by
+// MessageType.String() and a Histogram of standby status update latencies.
+//
+// The methods are called synchronously by Next and must be fast.
+type StreamMetrics interface {
+	// MessageReceived is called for every XLogData message with the type of the decoded message,
+	// or 0 if the stream does not decode the WAL data, and the size of the WAL data.
+	MessageReceived(msgType MessageType, walDataSize int)
+	// KeepaliveReceived is called for every primary keepalive message.
+	KeepaliveReceived()
+	// ClientXLogPos is called with the new position when the position received by the stream
+	// advances.
+	ClientXLogPos(lsn LSN)
+	// Reconnected is called after every successful reconnect.
+	Reconnected()
+	// StandbyStatusUpdateSent is called after a standby status update is sent with the time it took
+	// to send it.
+	StandbyStatusUpdateSent(latency time.Duration)
+}
+
+// NopStreamMetrics is a StreamMetrics ignoring all measurements. Implementations can embed it to
+// only implement the methods they need.
+type NopStreamMetrics struct{}
+
+func (NopStreamMetrics) MessageReceived(MessageType, int)      {}
+func (NopStreamMetrics) KeepaliveReceived()                    {}
+func (NopStreamMetrics) ClientXLogPos(LSN)                     {}
+func (NopStreamMetrics) Reconnected()                          {}
+func (NopStreamMetrics) StandbyStatusUpdateSent(time.Duration) {}
@@ -0,0 +1,184 @@
+// Package natssink publishes the changes of a logical replication stream to NATS JetStream.
+//
+// Every committed transaction is converted into Debezium-style change events, see package
+// debezium, which are published to a subject per table. The sink does not depend on a NATS client
+// library: the messages are handed to a Publisher, which is typically a thin wrapper around the
+// PublishMsg or PublishMsgAsync methods of a JetStream context.
+//
+// Delivery is at least once. The end position of a transaction is only confirmed to the server
+// once JetStream has acknowledged all of its messages. Every message carries a Nats-Msg-Id
+// derived from the commit LSN of its transaction, so messages published again after a crash are
+// dropped by the JetStream duplicate window.
+package natssink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/debezium"
+)
+
+// MsgIDHeader is the JetStream message deduplication header.
+const MsgIDHeader = "Nats-Msg-Id"
+
+// LSNHeader is the header holding the commit LSN of the transaction of a message.
+const LSNHeader = "Pglogrepl-Lsn"
+
+// Message is a NATS message.
+type Message struct {
+	Subject string
+	Data    []byte
+	// Header holds the MsgIDHeader and LSNHeader headers.
+	Header map[string][]string
+}
+
+// MsgID returns the deduplication ID of the message.
+func (m *Message) MsgID() string {
+	if values := m.Header[MsgIDHeader]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Publisher publishes messages to JetStream.
+type Publisher interface {
+	// Publish publishes msgs. It must only return nil once all messages have been acknowledged by
+	// JetStream.
+	Publish(ctx context.Context, msgs ...Message) error
+}
+
+// Options configures a Sink.
+type Options struct {
+	// Publisher publishes the messages. It is required.
+	Publisher Publisher
+	// Encoder converts the changes into events. If it is nil an encoder with default options is
+	// used.
+	Encoder *debezium.Encoder
+	// Subject returns the subject of an event. If it is nil <SubjectPrefix>.<schema>.<table> is
+	// used.
+	Subject func(event *debezium.Event) string
+	// SubjectPrefix is the prefix of the default subjects. If it is empty "pglogrepl" is used.
+	SubjectPrefix string
+
+	// BatchSize is the number of buffered messages above which they are published. If it is 0
+	// then 1000 is used.
+	BatchSize int
+	// FlushInterval is the maximum time Run buffers messages before publishing them. If it is 0
+	// then 1 second is used.
+	FlushInterval time.Duration
+
+	// TransactionAssemblerOptions configures the buffering of streamed transactions.
+	TransactionAssemblerOptions pglogrepl.TransactionAssemblerOptions
+}
+
+const (
+	defaultSubjectPrefix = "pglogrepl"
+	defaultBatchSize     = 1000
+	defaultFlushInterval = time.Second
+)
+
+// Sink buffers the changes of committed transactions as NATS messages and publishes them.
+type Sink struct {
+	options   Options
+	assembler *pglogrepl.TransactionAssembler
+
+	pending    []Message
+	pendingLSN pglogrepl.LSN
+	flushedLSN pglogrepl.LSN
+}
+
+// New returns a new Sink.
+func New(options Options) *Sink {
+	if options.Encoder == nil {
+		options.Encoder = debezium.NewEncoder(debezium.EncoderOptions{})
+	}
+	if options.SubjectPrefix == "" {
+		options.SubjectPrefix = defaultSubjectPrefix
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultBatchSize
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = defaultFlushInterval
+	}
+	return &Sink{options: options, assembler: pglogrepl.NewTransactionAssembler(options.TransactionAssemblerOptions)}
+}
+
+// WriteChange implements pglogrepl.Sink. It adds msg to the transaction in progress and buffers
+// the events of the transaction once msg commits it. The buffered messages are published when
+// there are more than BatchSize of them.
+func (s *Sink) WriteChange(ctx context.Context, msg *pglogrepl.ReplicationMessage) error {
+	if msg.Message == nil {
+		return fmt.Errorf("stream does not decode pgoutput messages")
+	}
+	tx, err := s.assembler.Add(msg.Message)
+	if err != nil || tx == nil {
+		return err
+	}
+
+	batch := []pglogrepl.Message{&pglogrepl.BeginMessage{FinalLSN: tx.CommitLSN, CommitTime: tx.CommitTime, Xid: tx.Xid}}
+	batch = append(batch, tx.Changes...)
+	lsn := tx.CommitLSN.String()
+	var n int
+	for _, change := range batch {
+		events, err := s.options.Encoder.Encode(tx.CommitLSN, change)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			data, err := event.ValueJSON()
+			if err != nil {
+				return fmt.Errorf("failed to encode event: %w", err)
+			}
+			subject := s.options.SubjectPrefix + "." + event.Value.Source.Schema + "." + event.Value.Source.Table
+			if s.options.Subject != nil {
+				subject = s.options.Subject(event)
+			}
+			n++
+			s.pending = append(s.pending, Message{
+				Subject: subject,
+				Data:    data,
+				Header: map[string][]string{
+					MsgIDHeader: {fmt.Sprintf("%s-%d", lsn, n)},
+					LSNHeader:   {lsn},
+				},
+			})
+		}
+	}
+	s.pendingLSN = tx.EndLSN
+	if len(s.pending) >= s.options.BatchSize {
+		_, err := s.Flush(ctx)
+		return err
+	}
+	return nil
+}
+
+// Flush implements pglogrepl.Sink. It publishes the buffered messages and returns the end
+// position of the last transaction whose messages have all been acknowledged.
+func (s *Sink) Flush(ctx context.Context) (pglogrepl.LSN, error) {
+	if len(s.pending) > 0 {
+		if err := s.options.Publisher.Publish(ctx, s.pending...); err != nil {
+			return s.flushedLSN, fmt.Errorf("failed to publish messages: %w", err)
+		}
+		s.pending = s.pending[:0]
+	}
+	if s.pendingLSN > s.flushedLSN {
+		s.flushedLSN = s.pendingLSN
+	}
+	return s.flushedLSN, nil
+}
+
+// Close removes the spill files of the streamed transactions in progress.
+func (s *Sink) Close() error {
+	return s.assembler.Close()
+}
+
+// Run publishes the changes received from stream with pglogrepl.RunSink until ctx is canceled or
+// an error occurs. The stream must decode pgoutput messages.
+func (s *Sink) Run(ctx context.Context, stream *pglogrepl.ReplicationStream) error {
+	return pglogrepl.RunSink(ctx, stream, s, pglogrepl.RunSinkOptions{FlushInterval: s.options.FlushInterval})
+}
+
+var _ pglogrepl.Sink = (*Sink)(nil)
@@ -0,0 +1,83 @@
+package natssink_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/natssink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePublisher struct {
+	err  error
+	msgs []natssink.Message
+}
+
+func (p *fakePublisher) Publish(_ context.Context, msgs ...natssink.Message) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.msgs = append(p.msgs, msgs...)
+	return nil
+}
+
+func writeTransaction(t *testing.T, sink *natssink.Sink, xid uint32, commitLSN, endLSN pglogrepl.LSN, ids ...string) {
+	msgs := []pglogrepl.Message{
+		&pglogrepl.BeginMessage{FinalLSN: commitLSN, Xid: xid},
+		&pglogrepl.RelationMessage{
+			RelationID:   1,
+			Namespace:    "public",
+			RelationName: "t",
+			Columns:      []*pglogrepl.RelationMessageColumn{{Flags: 1, Name: "id", DataType: 23}},
+		},
+	}
+	for _, id := range ids {
+		msgs = append(msgs, &pglogrepl.InsertMessage{RelationID: 1, Tuple: &pglogrepl.TupleData{ColumnNum: 1, Columns: []*pglogrepl.TupleDataColumn{
+			{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(id)), Data: []byte(id)},
+		}}})
+	}
+	msgs = append(msgs, &pglogrepl.CommitMessage{CommitLSN: commitLSN, TransactionEndLSN: endLSN})
+	for _, msg := range msgs {
+		require.NoError(t, sink.WriteChange(context.Background(), &pglogrepl.ReplicationMessage{Message: msg}))
+	}
+}
+
+func TestSink(t *testing.T) {
+	publisher := &fakePublisher{}
+	sink := natssink.New(natssink.Options{Publisher: publisher})
+	defer sink.Close()
+
+	writeTransaction(t, sink, 42, 0x300, 0x310, "1", "2")
+	lsn, err := sink.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x310), lsn)
+
+	require.Len(t, publisher.msgs, 2)
+	assert.Equal(t, "pglogrepl.public.t", publisher.msgs[0].Subject)
+	assert.Equal(t, "0/300-1", publisher.msgs[0].MsgID())
+	assert.Equal(t, "0/300-2", publisher.msgs[1].MsgID())
+	assert.Equal(t, []string{"0/300"}, publisher.msgs[0].Header[natssink.LSNHeader])
+	assert.Contains(t, string(publisher.msgs[1].Data), `"after":{"id":2}`)
+}
+
+func TestSinkFlushError(t *testing.T) {
+	publisher := &fakePublisher{err: errors.New("no responders")}
+	sink := natssink.New(natssink.Options{Publisher: publisher, SubjectPrefix: "cdc"})
+	defer sink.Close()
+
+	writeTransaction(t, sink, 42, 0x300, 0x310, "1")
+	lsn, err := sink.Flush(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, pglogrepl.LSN(0), lsn)
+
+	publisher.err = nil
+	lsn, err = sink.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x310), lsn)
+	require.Len(t, publisher.msgs, 1)
+	assert.Equal(t, "cdc.public.t", publisher.msgs[0].Subject)
+	assert.Equal(t, "0/300-1", publisher.msgs[0].MsgID())
+}
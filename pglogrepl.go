@@ -6,12 +6,17 @@
 //
 // Proper use of this package requires understanding the underlying PostgreSQL concepts.
 // See https://www.postgresql.org/docs/current/protocol-replication.html.
+//
+// The package depends on pgx and the standard library only. WAL data written to disk is
+// compressed with a Codec; only GzipCodec is provided, and zstd or other methods are
+// deliberately left to the user, see Codec.
 package pglogrepl
 
 import (
 	"context"
 	"database/sql/driver"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -26,6 +31,7 @@ const (
 	XLogDataByteID                = 'w'
 	PrimaryKeepaliveMessageByteID = 'k'
 	StandbyStatusUpdateByteID     = 'r'
+	HotStandbyFeedbackByteID      = 'h'
 )
 
 type ReplicationMode int
@@ -91,6 +97,70 @@ func (lsn LSN) Value() (driver.Value, error) {
 	return driver.Value(lsn.String()), nil
 }
 
+// Add returns the position n bytes after lsn.
+func (lsn LSN) Add(n uint64) LSN {
+	return lsn + LSN(n)
+}
+
+// Sub returns the position n bytes before lsn, or 0 if lsn is less than n bytes from the start of
+// the WAL.
+func (lsn LSN) Sub(n uint64) LSN {
+	if LSN(n) > lsn {
+		return 0
+	}
+	return lsn - LSN(n)
+}
+
+// Diff returns the number of bytes of WAL from other to lsn, which is negative if other is after
+// lsn, like pg_wal_lsn_diff does.
+func (lsn LSN) Diff(other LSN) int64 {
+	return int64(lsn - other)
+}
+
+// Set implements the flag.Value interface, so that an LSN can be a command line flag.
+func (lsn *LSN) Set(s string) error {
+	return lsn.decodeText(s)
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (lsn LSN) MarshalText() ([]byte, error) {
+	return []byte(lsn.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (lsn *LSN) UnmarshalText(text []byte) error {
+	return lsn.decodeText(string(text))
+}
+
+// MarshalJSON implements the json.Marshaler interface. An LSN is encoded as a string in the
+// XXX/XXX format.
+func (lsn LSN) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + lsn.String() + `"`), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. It accepts a string in the XXX/XXX
+// format as well as a number, and leaves the LSN unchanged for null.
+func (lsn *LSN) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	switch {
+	case s == "null":
+		return nil
+	case strings.HasPrefix(s, `"`):
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return fmt.Errorf("failed to parse LSN: %w", err)
+		}
+		return lsn.decodeText(unquoted)
+	default:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse LSN: %w", err)
+		}
+		*lsn = LSN(n)
+		return nil
+	}
+}
+
 // ParseLSN parses the given XXX/XXX text format LSN used by PostgreSQL.
 func ParseLSN(s string) (LSN, error) {
 	var upperHalf uint64
@@ -118,7 +188,17 @@ type IdentifySystemResult struct {
 
 // IdentifySystem executes the IDENTIFY_SYSTEM command.
 func IdentifySystem(ctx context.Context, conn *pgconn.PgConn) (IdentifySystemResult, error) {
-	return ParseIdentifySystem(conn.Exec(ctx, "IDENTIFY_SYSTEM"))
+	ctx, span := StartSpan(ctx, "pglogrepl.IdentifySystem")
+	isr, err := ParseIdentifySystem(conn.Exec(ctx, "IDENTIFY_SYSTEM"))
+	if err == nil {
+		span.SetAttributes(
+			StringAttribute(AttributeSystemID, isr.SystemID),
+			Int64Attribute(AttributeTimeline, int64(isr.Timeline)),
+			LSNAttribute(AttributeEndLSN, isr.XLogPos),
+		)
+	}
+	span.End(err)
+	return isr, err
 }
 
 // ParseIdentifySystem parses the result of the IDENTIFY_SYSTEM command.
@@ -164,6 +244,16 @@ func ParseIdentifySystem(mrr *pgconn.MultiResultReader) (IdentifySystemResult, e
 type TimelineHistoryResult struct {
 	FileName string
 	Content  []byte
+	// Entries is Content parsed with ParseTimelineHistoryFile.
+	Entries []TimelineHistoryEntry
+}
+
+// TimelineHistoryEntry is an entry of a timeline history file. It records that Timeline ended
+// at SwitchPoint, where the next timeline branched off of it.
+type TimelineHistoryEntry struct {
+	Timeline    int32
+	SwitchPoint LSN
+	Reason      string
 }
 
 // TimelineHistory executes the TIMELINE_HISTORY command.
@@ -196,13 +286,126 @@ func ParseTimelineHistory(mrr *pgconn.MultiResultReader) (TimelineHistoryResult,
 
 	thr.FileName = string(row[0])
 	thr.Content = row[1]
+	thr.Entries, err = ParseTimelineHistoryFile(thr.Content)
+	if err != nil {
+		return thr, err
+	}
 	return thr, nil
 }
 
+// ParseTimelineHistoryFile parses the content of a timeline history file such as the one
+// returned by the TIMELINE_HISTORY command. Entries are returned in file order, which is the
+// order of increasing timeline IDs. Blank lines and comments are ignored.
+func ParseTimelineHistoryFile(content []byte) ([]TimelineHistoryEntry, error) {
+	var entries []TimelineHistoryEntry
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("timeline history line %d: expected timeline and switch point", i+1)
+		}
+		timeline, err := strconv.ParseInt(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("timeline history line %d: invalid timeline: %w", i+1, err)
+		}
+		switchPoint, err := ParseLSN(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("timeline history line %d: invalid switch point: %w", i+1, err)
+		}
+		if len(entries) > 0 && int32(timeline) <= entries[len(entries)-1].Timeline {
+			return nil, fmt.Errorf("timeline history line %d: timeline IDs must be in increasing sequence", i+1)
+		}
+
+		reason := strings.TrimSpace(line[strings.Index(line, fields[1])+len(fields[1]):])
+		entries = append(entries, TimelineHistoryEntry{Timeline: int32(timeline), SwitchPoint: switchPoint, Reason: reason})
+	}
+	return entries, nil
+}
+
 type CreateReplicationSlotOptions struct {
-	Temporary      bool
+	Temporary bool
+	// SnapshotAction is what to do with the snapshot of a logical slot, one of the SnapshotAction
+	// constants. If it is empty the server default, SnapshotActionExport, is used.
 	SnapshotAction string
 	Mode           ReplicationMode
+	// ReserveWAL makes a physical replication slot reserve WAL immediately instead of on the
+	// first connection of a streaming replication client. It is ignored for logical slots.
+	ReserveWAL bool
+	// TwoPhase enables the decoding of prepared transactions for a logical slot. It requires
+	// PostgreSQL 14 or newer.
+	TwoPhase bool
+	// Failover enables the synchronization of a logical slot to standbys. It requires PostgreSQL
+	// 17 or newer.
+	Failover bool
+}
+
+// Snapshot actions of CreateReplicationSlotOptions.
+const (
+	// SnapshotActionExport exports the snapshot of the slot's consistent point until the
+	// replication connection runs another command. Its name is returned in
+	// CreateReplicationSlotResult.SnapshotName and can be imported with BeginSnapshot.
+	SnapshotActionExport = "EXPORT_SNAPSHOT"
+	// SnapshotActionNoExport does not export the snapshot.
+	SnapshotActionNoExport = "NOEXPORT_SNAPSHOT"
+	// SnapshotActionUse uses the snapshot for the current transaction of the replication
+	// connection, which must be a REPEATABLE READ transaction that has not run a query yet.
+	SnapshotActionUse = "USE_SNAPSHOT"
+)
+
+// snapshotOptions maps the snapshot actions of the pre-15 syntax to the SNAPSHOT option.
+var snapshotOptions = map[string]string{
+	SnapshotActionExport:   "export",
+	SnapshotActionNoExport: "nothing",
+	SnapshotActionUse:      "use",
+}
+
+func (o CreateReplicationSlotOptions) sql(slotName, outputPlugin string, serverVersion int) (string, error) {
+	parts := []string{"CREATE_REPLICATION_SLOT", slotName}
+	if o.Temporary {
+		parts = append(parts, "TEMPORARY")
+	}
+	parts = append(parts, o.Mode.String())
+
+	var options []string
+	if o.Mode == PhysicalReplication {
+		if o.ReserveWAL {
+			options = append(options, "RESERVE_WAL")
+		}
+	} else {
+		parts = append(parts, outputPlugin)
+		if o.SnapshotAction != "" {
+			if serverVersion < 15 {
+				options = append(options, o.SnapshotAction)
+			} else if snapshot, ok := snapshotOptions[o.SnapshotAction]; ok {
+				options = append(options, "SNAPSHOT '"+snapshot+"'")
+			} else {
+				return "", fmt.Errorf("unknown snapshot action %s", o.SnapshotAction)
+			}
+		}
+		if o.TwoPhase {
+			if serverVersion < 14 {
+				return "", fmt.Errorf("two-phase replication slots require PostgreSQL 14 or newer")
+			}
+			options = append(options, "TWO_PHASE")
+		}
+		if o.Failover {
+			if serverVersion < 17 {
+				return "", fmt.Errorf("failover replication slots require PostgreSQL 17 or newer")
+			}
+			options = append(options, "FAILOVER")
+		}
+	}
+
+	if serverVersion < 15 {
+		parts = append(parts, options...)
+	} else if len(options) > 0 {
+		parts = append(parts, "("+strings.Join(options, ", ")+")")
+	}
+	return strings.Join(parts, " "), nil
 }
 
 // CreateReplicationSlotResult is the parsed results the CREATE_REPLICATION_SLOT command.
@@ -213,20 +416,35 @@ type CreateReplicationSlotResult struct {
 	OutputPlugin    string
 }
 
-// CreateReplicationSlot creates a logical replication slot.
+// CreateReplicationSlot creates a logical replication slot. From PostgreSQL 15 the options are
+// sent in the parenthesized syntax, before that in the space separated one.
 func CreateReplicationSlot(
 	ctx context.Context,
 	conn *pgconn.PgConn,
 	slotName string,
 	outputPlugin string,
 	options CreateReplicationSlotOptions,
-) (CreateReplicationSlotResult, error) {
-	var temporaryString string
-	if options.Temporary {
-		temporaryString = "TEMPORARY"
+) (result CreateReplicationSlotResult, err error) {
+	ctx, span := StartSpan(ctx, "pglogrepl.CreateReplicationSlot",
+		StringAttribute(AttributeSlotName, slotName),
+		StringAttribute(AttributeOutputPlugin, outputPlugin),
+		StringAttribute(AttributeMode, options.Mode.String()),
+	)
+	defer func() { span.End(err) }()
+
+	serverVersion, err := serverMajorVersion(conn)
+	if err != nil {
+		return CreateReplicationSlotResult{}, err
+	}
+	sql, err := options.sql(slotName, outputPlugin, serverVersion)
+	if err != nil {
+		return CreateReplicationSlotResult{}, err
+	}
+	result, err = ParseCreateReplicationSlot(conn.Exec(ctx, sql))
+	if err == nil && result.ConsistentPoint != "" {
+		span.SetAttributes(StringAttribute(AttributeStartLSN, result.ConsistentPoint))
 	}
-	sql := fmt.Sprintf("CREATE_REPLICATION_SLOT %s %s %s %s %s", slotName, temporaryString, options.Mode, outputPlugin, options.SnapshotAction)
-	return ParseCreateReplicationSlot(conn.Exec(ctx, sql))
+	return result, err
 }
 
 // ParseCreateReplicationSlot parses the result of the CREATE_REPLICATION_SLOT command.
@@ -260,11 +478,33 @@ func ParseCreateReplicationSlot(mrr *pgconn.MultiResultReader) (CreateReplicatio
 }
 
 type DropReplicationSlotOptions struct {
+	// Wait makes the command wait until an active slot becomes inactive instead of failing.
 	Wait bool
+	// TerminateConn, if set, is a regular connection to the database of the slot used to
+	// terminate the backend of the active consumer of the slot with pg_terminate_backend before
+	// the slot is dropped. The drop then waits for the slot to be released.
+	TerminateConn *pgconn.PgConn
+}
+
+// terminateSlotConsumer terminates the backend of the active consumer of the slot slotName, if
+// any, with pg_terminate_backend on conn, a regular connection to the database of the slot.
+func terminateSlotConsumer(ctx context.Context, conn *pgconn.PgConn, slotName string) error {
+	sql := fmt.Sprintf("SELECT pg_terminate_backend(active_pid) FROM pg_replication_slots WHERE slot_name = %s AND active_pid IS NOT NULL", quoteLiteral(slotName))
+	if _, err := conn.Exec(ctx, sql).ReadAll(); err != nil {
+		return fmt.Errorf("failed to terminate consumer of replication slot: %w", err)
+	}
+	return nil
 }
 
 // DropReplicationSlot drops a logical replication slot.
 func DropReplicationSlot(ctx context.Context, conn *pgconn.PgConn, slotName string, options DropReplicationSlotOptions) error {
+	if options.TerminateConn != nil {
+		if err := terminateSlotConsumer(ctx, options.TerminateConn, slotName); err != nil {
+			return err
+		}
+		options.Wait = true
+	}
+
 	var waitString string
 	if options.Wait {
 		waitString = "WAIT"
@@ -274,10 +514,211 @@ func DropReplicationSlot(ctx context.Context, conn *pgconn.PgConn, slotName stri
 	return err
 }
 
+// DropReplicationSlotIfExists drops a replication slot like DropReplicationSlot, but does not
+// fail if the slot does not exist.
+func DropReplicationSlotIfExists(ctx context.Context, conn *pgconn.PgConn, slotName string, options DropReplicationSlotOptions) error {
+	err := DropReplicationSlot(ctx, conn, slotName, options)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42704" {
+		return nil
+	}
+	return err
+}
+
+// CreateReplicationSlotSQL creates a replication slot like CreateReplicationSlot, but with the
+// pg_create_logical_replication_slot or pg_create_physical_replication_slot function on conn,
+// a regular connection to the database of the slot, for environments where the
+// CREATE_REPLICATION_SLOT command is not available but streaming is. The connection of a pgx.Conn
+// is returned by its PgConn method.
+//
+// The functions do not export snapshots, so the SnapshotAction must be empty or
+// SnapshotActionNoExport, and the result has no SnapshotName. A temporary slot is dropped when
+// conn is closed, not the replication connection. The ConsistentPoint of a physical slot that
+// does not reserve WAL is empty.
+func CreateReplicationSlotSQL(ctx context.Context, conn *pgconn.PgConn, slotName string, outputPlugin string, options CreateReplicationSlotOptions) (CreateReplicationSlotResult, error) {
+	var result CreateReplicationSlotResult
+	if options.SnapshotAction != "" && options.SnapshotAction != SnapshotActionNoExport {
+		return result, fmt.Errorf("snapshot action %s is not supported by the replication slot functions", options.SnapshotAction)
+	}
+
+	var sql string
+	if options.Mode == PhysicalReplication {
+		sql = fmt.Sprintf("SELECT slot_name, lsn FROM pg_create_physical_replication_slot(%s, immediately_reserve => %t, temporary => %t)",
+			quoteLiteral(slotName), options.ReserveWAL, options.Temporary)
+	} else {
+		args := []string{quoteLiteral(slotName), quoteLiteral(outputPlugin), fmt.Sprintf("temporary => %t", options.Temporary)}
+		// The twophase and failover arguments only exist from PostgreSQL 14 and 17.
+		if options.TwoPhase {
+			args = append(args, "twophase => true")
+		}
+		if options.Failover {
+			args = append(args, "failover => true")
+		}
+		sql = "SELECT slot_name, lsn FROM pg_create_logical_replication_slot(" + strings.Join(args, ", ") + ")"
+		result.OutputPlugin = outputPlugin
+	}
+	rows, err := queryRows(ctx, conn, sql, 2)
+	if err != nil {
+		return result, err
+	}
+	if len(rows) != 1 {
+		return result, fmt.Errorf("expected 1 row, got %d", len(rows))
+	}
+	result.SlotName = string(rows[0][0])
+	result.ConsistentPoint = string(rows[0][1])
+	return result, nil
+}
+
+// dropReplicationSlotRetryInterval is the interval at which DropReplicationSlotSQL retries to drop
+// an active slot when waiting.
+const dropReplicationSlotRetryInterval = 100 * time.Millisecond
+
+// DropReplicationSlotSQL drops a replication slot like DropReplicationSlot, but with the
+// pg_drop_replication_slot function on conn, a regular connection to the database of the slot.
+// The function fails if the slot is active; with Wait the drop is retried until the slot is
+// released or ctx is done. A TerminateConn is used as by DropReplicationSlot and may be conn.
+func DropReplicationSlotSQL(ctx context.Context, conn *pgconn.PgConn, slotName string, options DropReplicationSlotOptions) error {
+	if options.TerminateConn != nil {
+		if err := terminateSlotConsumer(ctx, options.TerminateConn, slotName); err != nil {
+			return err
+		}
+		options.Wait = true
+	}
+
+	sql := fmt.Sprintf("SELECT pg_drop_replication_slot(%s)", quoteLiteral(slotName))
+	for {
+		_, err := conn.Exec(ctx, sql).ReadAll()
+		var pgErr *pgconn.PgError
+		if !options.Wait || !errors.As(err, &pgErr) || pgErr.Code != "55006" {
+			return err
+		}
+		// The slot is still active.
+		timer := time.NewTimer(dropReplicationSlotRetryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// AdvanceReplicationSlot moves the confirmed position of the replication slot slotName forward to
+// lsn with pg_replication_slot_advance, without decoding the changes in between for the client,
+// and returns the position the slot was advanced to. The slot must not be active. It is the
+// equivalent of skipping everything before lsn. conn must be a regular connection to the database
+// of the slot.
+func AdvanceReplicationSlot(ctx context.Context, conn *pgconn.PgConn, slotName string, lsn LSN) (LSN, error) {
+	sql := fmt.Sprintf("SELECT end_lsn FROM pg_replication_slot_advance(%s, %s)", quoteLiteral(slotName), quoteLiteral(lsn.String()))
+	rows, err := queryRows(ctx, conn, sql, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) != 1 {
+		return 0, fmt.Errorf("expected 1 row, got %d", len(rows))
+	}
+	endLSN, err := ParseLSN(string(rows[0][0]))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse end_lsn as LSN: %w", err)
+	}
+	return endLSN, nil
+}
+
+// AlterReplicationSlotOptions are the options of the ALTER_REPLICATION_SLOT command. Options left
+// nil are not changed.
+type AlterReplicationSlotOptions struct {
+	// Failover enables the synchronization of a logical slot to standbys. It requires PostgreSQL
+	// 17 or newer.
+	Failover *bool
+	// TwoPhase enables the decoding of prepared transactions. It requires PostgreSQL 18 or newer.
+	TwoPhase *bool
+}
+
+// AlterReplicationSlot changes the options of a replication slot with the ALTER_REPLICATION_SLOT
+// command. It is only supported since PG17.
+func AlterReplicationSlot(ctx context.Context, conn *pgconn.PgConn, slotName string, options AlterReplicationSlotOptions) error {
+	var settings []string
+	if options.Failover != nil {
+		settings = append(settings, fmt.Sprintf("FAILOVER %t", *options.Failover))
+	}
+	if options.TwoPhase != nil {
+		settings = append(settings, fmt.Sprintf("TWO_PHASE %t", *options.TwoPhase))
+	}
+	if len(settings) == 0 {
+		return fmt.Errorf("no replication slot options to alter")
+	}
+	sql := fmt.Sprintf("ALTER_REPLICATION_SLOT %s ( %s )", slotName, strings.Join(settings, ", "))
+	_, err := conn.Exec(ctx, sql).ReadAll()
+	return err
+}
+
+// ReadReplicationSlotResult is the parsed result of the READ_REPLICATION_SLOT command.
+type ReadReplicationSlotResult struct {
+	// SlotType is "physical" or "logical". It is empty if the slot does not exist.
+	SlotType string
+	// RestartLSN is the replication slot's restart_lsn. It is 0 if the slot has not reserved WAL.
+	RestartLSN LSN
+	// RestartTimeline is the timeline of RestartLSN. It is 0 if RestartLSN is 0.
+	RestartTimeline int32
+}
+
+// ReadReplicationSlot executes the READ_REPLICATION_SLOT command. It is only supported since PG15
+// and only for physical replication slots.
+func ReadReplicationSlot(ctx context.Context, conn *pgconn.PgConn, slotName string) (ReadReplicationSlotResult, error) {
+	sql := fmt.Sprintf("READ_REPLICATION_SLOT %s", slotName)
+	return ParseReadReplicationSlot(conn.Exec(ctx, sql))
+}
+
+// ParseReadReplicationSlot parses the result of the READ_REPLICATION_SLOT command.
+func ParseReadReplicationSlot(mrr *pgconn.MultiResultReader) (ReadReplicationSlotResult, error) {
+	var rrsr ReadReplicationSlotResult
+	results, err := mrr.ReadAll()
+	if err != nil {
+		return rrsr, err
+	}
+
+	if len(results) != 1 {
+		return rrsr, fmt.Errorf("expected 1 result set, got %d", len(results))
+	}
+
+	result := results[0]
+	if len(result.Rows) != 1 {
+		return rrsr, fmt.Errorf("expected 1 result row, got %d", len(result.Rows))
+	}
+
+	row := result.Rows[0]
+	if len(row) != 3 {
+		return rrsr, fmt.Errorf("expected 3 result columns, got %d", len(row))
+	}
+
+	// MultiResultReader.ReadAll returns NULL values as empty slices.
+	rrsr.SlotType = string(row[0])
+
+	if len(row[1]) > 0 {
+		rrsr.RestartLSN, err = ParseLSN(string(row[1]))
+		if err != nil {
+			return rrsr, fmt.Errorf("failed to parse restart_lsn: %w", err)
+		}
+	}
+
+	if len(row[2]) > 0 {
+		timeline, err := strconv.ParseInt(string(row[2]), 10, 32)
+		if err != nil {
+			return rrsr, fmt.Errorf("failed to parse restart_tli: %w", err)
+		}
+		rrsr.RestartTimeline = int32(timeline)
+	}
+
+	return rrsr, nil
+}
+
 type StartReplicationOptions struct {
 	Timeline   int32 // 0 means current server timeline
 	Mode       ReplicationMode
 	PluginArgs []string
+	// Pgoutput, if set, holds the options of the pgoutput plugin. They are sent before
+	// PluginArgs.
+	Pgoutput *PgoutputOptions
 }
 
 type errEndTimeline struct {
@@ -303,14 +744,42 @@ func IsErrEndTimeline(err error) (int64, LSN, bool) {
 }
 
 // StartReplication begins the replication process by executing the START_REPLICATION command.
-func StartReplication(ctx context.Context, conn *pgconn.PgConn, slotName string, startLSN LSN, options StartReplicationOptions) error {
+//
+// For physical replication slotName may be empty to stream without a replication slot, and
+// options.Timeline selects the timeline to stream from. If the requested timeline is a historic
+// timeline that ends at startLSN, the server does not enter copy-both mode and the returned error
+// satisfies IsErrEndTimeline with the next timeline and its start position.
+func StartReplication(ctx context.Context, conn *pgconn.PgConn, slotName string, startLSN LSN, options StartReplicationOptions) (err error) {
+	ctx, span := StartSpan(ctx, "pglogrepl.StartReplication",
+		StringAttribute(AttributeSlotName, slotName),
+		StringAttribute(AttributeMode, options.Mode.String()),
+		LSNAttribute(AttributeStartLSN, startLSN),
+	)
+	if options.Timeline > 0 {
+		span.SetAttributes(Int64Attribute(AttributeTimeline, int64(options.Timeline)))
+	}
+	defer func() { span.End(err) }()
+
+	if options.Pgoutput != nil {
+		pluginArgs, err := options.Pgoutput.PluginArgs()
+		if err != nil {
+			return err
+		}
+		options.PluginArgs = append(pluginArgs, options.PluginArgs...)
+	}
+
 	var timelineString string
 	if options.Timeline > 0 {
 		timelineString = fmt.Sprintf("TIMELINE %d", options.Timeline)
 		options.PluginArgs = append(options.PluginArgs, timelineString)
 	}
 
-	sql := fmt.Sprintf("START_REPLICATION SLOT %s %s %s ", slotName, options.Mode, startLSN)
+	var sql string
+	if slotName == "" && options.Mode == PhysicalReplication {
+		sql = fmt.Sprintf("START_REPLICATION %s %s ", options.Mode, startLSN)
+	} else {
+		sql = fmt.Sprintf("START_REPLICATION SLOT %s %s %s ", slotName, options.Mode, startLSN)
+	}
 	if options.Mode == LogicalReplication {
 		if len(options.PluginArgs) > 0 {
 			sql += fmt.Sprintf("(%s)", strings.Join(options.PluginArgs, ", "))
@@ -319,8 +788,10 @@ func StartReplication(ctx context.Context, conn *pgconn.PgConn, slotName string,
 		sql += timelineString
 	}
 
-	conn.Frontend().SendQuery(&pgproto3.Query{String: sql})
-	err := conn.Frontend().Flush()
+	err = sendContext(ctx, conn, func() error {
+		conn.Frontend().SendQuery(&pgproto3.Query{String: sql})
+		return conn.Frontend().Flush()
+	})
 	if err != nil {
 		return fmt.Errorf("failed to send START_REPLICATION: %w", err)
 	}
@@ -393,6 +864,12 @@ type BaseBackupOptions struct {
 	// Disable checksums being verified during a base backup.
 	// Note that NoVerifyChecksums=true is only supported since PG11
 	NoVerifyChecksums bool
+	// Request a backup manifest. Valid values are "yes" and "force-encode". An empty string does not
+	// request a manifest. Only supported since PG13.
+	Manifest string
+	// Checksum algorithm for the files listed in the backup manifest, e.g. "CRC32C" or "SHA256".
+	// Only used if Manifest is set.
+	ManifestChecksums string
 }
 
 func (bbo BaseBackupOptions) sql(serverVersion int) string {
@@ -433,6 +910,12 @@ func (bbo BaseBackupOptions) sql(serverVersion int) string {
 			parts = append(parts, "NOVERIFY_CHECKSUMS")
 		}
 	}
+	if bbo.Manifest != "" && serverVersion >= 13 {
+		parts = append(parts, "MANIFEST '"+strings.ReplaceAll(bbo.Manifest, "'", "''")+"'")
+		if bbo.ManifestChecksums != "" {
+			parts = append(parts, "MANIFEST_CHECKSUMS '"+strings.ReplaceAll(bbo.ManifestChecksums, "'", "''")+"'")
+		}
+	}
 	if serverVersion >= 15 {
 		return "BASE_BACKUP(" + strings.Join(parts, ", ") + ")"
 	}
@@ -443,7 +926,9 @@ func (bbo BaseBackupOptions) sql(serverVersion int) string {
 type BaseBackupTablespace struct {
 	OID      int32
 	Location string
-	Size     int8
+	// Size is the approximate size of the tablespace in kilobytes. It is only set if the Progress
+	// option was requested.
+	Size int64
 }
 
 // BaseBackupResult will hold the return values  of the BaseBackup command
@@ -454,12 +939,20 @@ type BaseBackupResult struct {
 }
 
 func serverMajorVersion(conn *pgconn.PgConn) (int, error) {
-	verString := conn.ParameterStatus("server_version")
-	dot := strings.IndexByte(verString, '.')
-	if dot == -1 {
+	return parseServerMajorVersion(conn.ParameterStatus("server_version"))
+}
+
+// parseServerMajorVersion returns the major version of a server_version such as "16.2",
+// "17beta1" or "15.4 (Debian 15.4-1)".
+func parseServerMajorVersion(verString string) (int, error) {
+	end := 0
+	for end < len(verString) && verString[end] >= '0' && verString[end] <= '9' {
+		end++
+	}
+	if end == 0 {
 		return 0, fmt.Errorf("bad server version string: '%s'", verString)
 	}
-	return strconv.Atoi(verString[:dot])
+	return strconv.Atoi(verString[:end])
 }
 
 // StartBaseBackup begins the process for copying a basebackup by executing the BASE_BACKUP command.
@@ -470,8 +963,10 @@ func StartBaseBackup(ctx context.Context, conn *pgconn.PgConn, options BaseBacku
 	}
 	sql := options.sql(serverVersion)
 
-	conn.Frontend().SendQuery(&pgproto3.Query{String: sql})
-	err = conn.Frontend().Flush()
+	err = sendContext(ctx, conn, func() error {
+		conn.Frontend().SendQuery(&pgproto3.Query{String: sql})
+		return conn.Frontend().Flush()
+	})
 	if err != nil {
 		return result, fmt.Errorf("failed to send BASE_BACKUP: %w", err)
 	}
@@ -572,11 +1067,11 @@ func getTableSpaceInfo(ctx context.Context, conn *pgconn.PgConn) (tbss []BaseBac
 			tbs.Location = string(msg.Values[1])
 			if msg.Values[2] != nil {
 				colData := string(msg.Values[2])
-				size, err := strconv.Atoi(colData)
+				size, err := strconv.ParseInt(colData, 10, 64)
 				if err != nil {
 					return tbss, fmt.Errorf("cannot convert size to int: %s", colData)
 				}
-				tbs.Size = int8(size)
+				tbs.Size = size
 			}
 			tbss = append(tbss, tbs)
 		case *pgproto3.CommandComplete:
@@ -665,8 +1160,11 @@ type PrimaryKeepaliveMessage struct {
 // ParsePrimaryKeepaliveMessage parses a Primary keepalive message from the server.
 func ParsePrimaryKeepaliveMessage(buf []byte) (PrimaryKeepaliveMessage, error) {
 	var pkm PrimaryKeepaliveMessage
-	if len(buf) != 17 {
-		return pkm, fmt.Errorf("PrimaryKeepaliveMessage must be 17 bytes, got %d", len(buf))
+	if len(buf) < 17 {
+		return pkm, truncatedError("PrimaryKeepaliveMessage", 17, len(buf))
+	}
+	if len(buf) > 17 {
+		return pkm, &ParseError{Message: "PrimaryKeepaliveMessage", Offset: 17, Err: fmt.Errorf("must be 17 bytes, got %d bytes", len(buf))}
 	}
 
 	pkm.ServerWALEnd = LSN(binary.BigEndian.Uint64(buf))
@@ -687,7 +1185,7 @@ type XLogData struct {
 func ParseXLogData(buf []byte) (XLogData, error) {
 	var xld XLogData
 	if len(buf) < 24 {
-		return xld, fmt.Errorf("XLogData must be at least 24 bytes, got %d", len(buf))
+		return xld, truncatedError("XLogData", 24, len(buf))
 	}
 
 	xld.WALStart = LSN(binary.BigEndian.Uint64(buf))
@@ -712,7 +1210,7 @@ type StandbyStatusUpdate struct {
 // The only required field in ssu is WALWritePosition. If WALFlushPosition is 0 then WALWritePosition will be assigned
 // to it. If WALApplyPosition is 0 then WALWritePosition will be assigned to it. If ClientTime is the zero value then
 // the current time will be assigned to it.
-func SendStandbyStatusUpdate(_ context.Context, conn *pgconn.PgConn, ssu StandbyStatusUpdate) error {
+func SendStandbyStatusUpdate(ctx context.Context, conn *pgconn.PgConn, ssu StandbyStatusUpdate) error {
 	if ssu.WALFlushPosition == 0 {
 		ssu.WALFlushPosition = ssu.WALWritePosition
 	}
@@ -741,7 +1239,51 @@ func SendStandbyStatusUpdate(_ context.Context, conn *pgconn.PgConn, ssu Standby
 		return err
 	}
 
-	return conn.Frontend().SendUnbufferedEncodedCopyData(buf)
+	return sendContext(ctx, conn, func() error {
+		return conn.Frontend().SendUnbufferedEncodedCopyData(buf)
+	})
+}
+
+// HotStandbyFeedback is a message sent from a physical standby client that reports the oldest
+// transactions whose rows it still needs, so that the primary does not vacuum them away.
+//
+// A transaction ID of 0 reports that the standby has no such transaction, which stops the
+// primary from holding back vacuum for it.
+type HotStandbyFeedback struct {
+	ClientTime       time.Time // Client system clock time
+	Xmin             uint32    // The standby's current global xmin
+	XminEpoch        uint32    // The epoch of Xmin
+	CatalogXmin      uint32    // The lowest catalog_xmin of any replication slot on the standby
+	CatalogXminEpoch uint32    // The epoch of CatalogXmin
+}
+
+// SendStandbyHotStandbyFeedback sends a HotStandbyFeedback to the PostgreSQL server. The server
+// only takes it into account if the replication connection was started with a physical
+// replication slot or hot_standby_feedback is otherwise enabled for it.
+//
+// If ClientTime is the zero value then the current time will be assigned to it.
+func SendStandbyHotStandbyFeedback(ctx context.Context, conn *pgconn.PgConn, hsf HotStandbyFeedback) error {
+	if hsf.ClientTime == (time.Time{}) {
+		hsf.ClientTime = time.Now()
+	}
+
+	data := make([]byte, 0, 25)
+	data = append(data, HotStandbyFeedbackByteID)
+	data = pgio.AppendInt64(data, timeToPgTime(hsf.ClientTime))
+	data = pgio.AppendUint32(data, hsf.Xmin)
+	data = pgio.AppendUint32(data, hsf.XminEpoch)
+	data = pgio.AppendUint32(data, hsf.CatalogXmin)
+	data = pgio.AppendUint32(data, hsf.CatalogXminEpoch)
+
+	cd := &pgproto3.CopyData{Data: data}
+	buf, err := cd.Encode(nil)
+	if err != nil {
+		return err
+	}
+
+	return sendContext(ctx, conn, func() error {
+		return conn.Frontend().SendUnbufferedEncodedCopyData(buf)
+	})
 }
 
 // CopyDoneResult is the parsed result as returned by the server after the client
@@ -753,18 +1295,28 @@ type CopyDoneResult struct {
 
 // SendStandbyCopyDone sends a StandbyCopyDone to the PostgreSQL server
 // to confirm ending the copy-both mode.
-func SendStandbyCopyDone(_ context.Context, conn *pgconn.PgConn) (cdr *CopyDoneResult, err error) {
+//
+// When physical replication reaches the end of the streamed timeline the server ends the
+// copy-both mode by itself and, once the client confirms it with SendStandbyCopyDone, reports the
+// next timeline and the position at which it starts. Streaming can then be resumed by passing
+// CopyDoneResult.Timeline and CopyDoneResult.LSN to StartReplication. The result is zero if the
+// server did not report a next timeline.
+func SendStandbyCopyDone(ctx context.Context, conn *pgconn.PgConn) (cdr *CopyDoneResult, err error) {
+	cdr = &CopyDoneResult{}
+
 	// I am suspicious that this is wildly wrong, but I'm pretty sure the previous
 	// code was wildly wrong too -- wttw <steve@blighty.com>
-	conn.Frontend().Send(&pgproto3.CopyDone{})
-	err = conn.Frontend().Flush()
+	err = sendContext(ctx, conn, func() error {
+		conn.Frontend().Send(&pgproto3.CopyDone{})
+		return conn.Frontend().Flush()
+	})
 	if err != nil {
 		return
 	}
 
 	for {
 		var msg pgproto3.BackendMessage
-		msg, err = conn.Frontend().Receive()
+		msg, err = conn.ReceiveMessage(ctx)
 		if err != nil {
 			return cdr, err
 		}
@@ -778,14 +1330,16 @@ func SendStandbyCopyDone(_ context.Context, conn *pgconn.PgConn) (cdr *CopyDoneR
 			// We are expecting just one row returned, with two columns timeline and LSN
 			// We should pay attention to RowDescription, but we'll take it on trust.
 			if len(m.Values) == 2 {
-				timeline, lerr := strconv.Atoi(string(m.Values[0]))
-				if lerr == nil {
-					lsn, lerr := ParseLSN(string(m.Values[1]))
-					if lerr == nil {
-						cdr.Timeline = int32(timeline)
-						cdr.LSN = lsn
-					}
+				timeline, lerr := strconv.ParseInt(string(m.Values[0]), 10, 32)
+				if lerr != nil {
+					return cdr, fmt.Errorf("failed to parse next timeline: %w", lerr)
 				}
+				lsn, lerr := ParseLSN(string(m.Values[1]))
+				if lerr != nil {
+					return cdr, fmt.Errorf("failed to parse next timeline start position: %w", lerr)
+				}
+				cdr.Timeline = int32(timeline)
+				cdr.LSN = lsn
 			}
 		case *pgproto3.EmptyQueryResponse:
 		case *pgproto3.ErrorResponse:
@@ -797,6 +1351,65 @@ func SendStandbyCopyDone(_ context.Context, conn *pgconn.PgConn) (cdr *CopyDoneR
 	}
 }
 
+// DrainStream ends the copy-both mode of conn and reads the responses of the server until the
+// connection is ready for another command, so that it can be reused. It can be called both when
+// the client wants to stop streaming and after the server ended it, which ReplicationStream.Next
+// reports with io.EOF.
+//
+// DrainStream sends CopyDone and discards the data the server sends until it ends the copy-both
+// mode as well. If the server ended the copy-both mode at the end of a timeline, the returned
+// result holds the next timeline and the position at which it starts, to be passed to
+// StartReplication. It is zero otherwise. An error reported by the server is returned once the
+// connection is ready again.
+func DrainStream(ctx context.Context, conn *pgconn.PgConn) (CopyDoneResult, error) {
+	var cdr CopyDoneResult
+	err := sendContext(ctx, conn, func() error {
+		conn.Frontend().Send(&pgproto3.CopyDone{})
+		return conn.Frontend().Flush()
+	})
+	if err != nil {
+		return cdr, fmt.Errorf("failed to send CopyDone: %w", err)
+	}
+
+	var serverErr error
+	for {
+		msg, err := conn.ReceiveMessage(ctx)
+		if err != nil {
+			return cdr, fmt.Errorf("failed to receive message: %w", err)
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData, *pgproto3.CopyDone:
+		case *pgproto3.ParameterStatus, *pgproto3.NoticeResponse:
+		case *pgproto3.RowDescription:
+			if len(msg.Fields) != 2 || string(msg.Fields[0].Name) != "next_tli" || string(msg.Fields[1].Name) != "next_tli_startpos" {
+				return cdr, fmt.Errorf("expected next timeline row description message")
+			}
+		case *pgproto3.DataRow:
+			if len(msg.Values) != 2 {
+				return cdr, fmt.Errorf("expected next_tli and next_tli_startpos, got %d fields", len(msg.Values))
+			}
+			timeline, err := strconv.ParseInt(string(msg.Values[0]), 10, 32)
+			if err != nil {
+				return cdr, fmt.Errorf("failed to parse next timeline: %w", err)
+			}
+			lsn, err := ParseLSN(string(msg.Values[1]))
+			if err != nil {
+				return cdr, fmt.Errorf("failed to parse next timeline start position: %w", err)
+			}
+			cdr.Timeline = int32(timeline)
+			cdr.LSN = lsn
+		case *pgproto3.CommandComplete:
+		case *pgproto3.ErrorResponse:
+			serverErr = pgconn.ErrorResponseToPgError(msg)
+		case *pgproto3.ReadyForQuery:
+			return cdr, serverErr
+		default:
+			return cdr, fmt.Errorf("unexpected response type: %T", msg)
+		}
+	}
+}
+
 const microsecFromUnixEpochToY2K = 946684800 * 1000000
 
 func pgTimeToTime(microsecSinceY2K int64) time.Time {
@@ -808,3 +1421,39 @@ func timeToPgTime(t time.Time) int64 {
 	microsecSinceUnixEpoch := t.Unix()*1000000 + int64(t.Nanosecond())/1000
 	return microsecSinceUnixEpoch - microsecFromUnixEpochToY2K
 }
+
+// sendContext calls send, which writes to conn through its Frontend, and interrupts the write once
+// ctx is done, as pgconn does for its own operations. A write interrupted midway leaves the
+// protocol stream in an unknown state, so conn is closed then.
+func sendContext(ctx context.Context, conn *pgconn.PgConn, send func() error) error {
+	if ctx.Done() == nil {
+		return send()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sent := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			conn.Conn().SetWriteDeadline(time.Unix(1, 0))
+		case <-sent:
+		}
+	}()
+	err := send()
+	close(sent)
+	<-watcherDone
+
+	if ctx.Err() == nil {
+		return err
+	}
+	if err != nil {
+		conn.Close(ctx)
+		return fmt.Errorf("%w: %v", ctx.Err(), err)
+	}
+	conn.Conn().SetWriteDeadline(time.Time{})
+	return nil
+}
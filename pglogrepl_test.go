@@ -2,9 +2,13 @@ package pglogrepl_test
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -75,6 +79,49 @@ func (s *lsnSuite) TestValueInterface() {
 	s.Equal("16/B374D848", lsnStr)
 }
 
+func (s *lsnSuite) TestArithmetic() {
+	lsn := pglogrepl.LSN(0x1_0000_0000)
+	s.Equal(pglogrepl.LSN(0x1_0000_0010), lsn.Add(0x10))
+	s.Equal(pglogrepl.LSN(0xFFFF_FFF0), lsn.Sub(0x10))
+	s.Equal(pglogrepl.LSN(0), lsn.Sub(0x2_0000_0000))
+	s.Equal(int64(0x10), lsn.Add(0x10).Diff(lsn))
+	s.Equal(int64(-0x10), lsn.Diff(lsn.Add(0x10)))
+}
+
+func (s *lsnSuite) TestFlagValue() {
+	var lsn pglogrepl.LSN
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Var(&lsn, "start-lsn", "start position")
+	s.NoError(flags.Parse([]string{"-start-lsn", "16/B374D848"}))
+	s.Equal(pglogrepl.LSN(97500059720), lsn)
+	s.Error(lsn.Set("invalid"))
+}
+
+func (s *lsnSuite) TestJSON() {
+	type checkpoint struct {
+		LSN  pglogrepl.LSN  `json:"lsn"`
+		Last *pglogrepl.LSN `json:"last"`
+	}
+	data, err := json.Marshal(checkpoint{LSN: 97500059720})
+	s.NoError(err)
+	s.Equal(`{"lsn":"16/B374D848","last":null}`, string(data))
+
+	var c checkpoint
+	s.NoError(json.Unmarshal(data, &c))
+	s.Equal(checkpoint{LSN: 97500059720}, c)
+
+	s.NoError(json.Unmarshal([]byte(`{"lsn":97500059720}`), &c))
+	s.Equal(pglogrepl.LSN(97500059720), c.LSN)
+
+	s.Error(json.Unmarshal([]byte(`{"lsn":"invalid"}`), &c))
+	s.Error(json.Unmarshal([]byte(`{"lsn":true}`), &c))
+
+	m := map[pglogrepl.LSN]string{0x1700: "commit"}
+	data, err = json.Marshal(m)
+	s.NoError(err)
+	s.Equal(`{"0/1700":"commit"}`, string(data))
+}
+
 const slotName = "pglogrepl_test"
 const outputPlugin = "test_decoding"
 
@@ -130,6 +177,35 @@ func TestGetHistoryFile(t *testing.T) {
 		expectedFileName := fmt.Sprintf("%08X.history", sysident.Timeline)
 		assert.Equal(t, expectedFileName, tlh.FileName)
 		assert.Greater(t, len(tlh.Content), 0)
+		assert.Equal(t, int(sysident.Timeline-1), len(tlh.Entries))
+	}
+}
+
+func TestParseTimelineHistoryFile(t *testing.T) {
+	content := []byte("1\t0/3000000\tno recovery target specified\n" +
+		"\n" +
+		"# a comment\n" +
+		"2\t0/5000A28\tbefore 2024-02-26 10:15:30.123456+00\n")
+
+	entries, err := pglogrepl.ParseTimelineHistoryFile(content)
+	require.NoError(t, err)
+	assert.Equal(t, []pglogrepl.TimelineHistoryEntry{
+		{Timeline: 1, SwitchPoint: 0x3000000, Reason: "no recovery target specified"},
+		{Timeline: 2, SwitchPoint: 0x5000A28, Reason: "before 2024-02-26 10:15:30.123456+00"},
+	}, entries)
+
+	entries, err = pglogrepl.ParseTimelineHistoryFile(nil)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	for _, content := range []string{
+		"1\n",
+		"x\t0/3000000\treason\n",
+		"1\tnope\treason\n",
+		"2\t0/3000000\treason\n1\t0/4000000\treason\n",
+	} {
+		_, err = pglogrepl.ParseTimelineHistoryFile([]byte(content))
+		assert.Error(t, err, content)
 	}
 }
 
@@ -148,6 +224,160 @@ func TestCreateReplicationSlot(t *testing.T) {
 	assert.Equal(t, outputPlugin, result.OutputPlugin)
 }
 
+func TestCreateReplicationSlotPhysicalReserveWAL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	result, err := pglogrepl.CreateReplicationSlot(ctx, conn, slotName, "", pglogrepl.CreateReplicationSlotOptions{Temporary: true, Mode: pglogrepl.PhysicalReplication, ReserveWAL: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, slotName, result.SlotName)
+	_, err = pglogrepl.ParseLSN(result.ConsistentPoint)
+	assert.NoError(t, err)
+}
+
+func TestReadReplicationSlot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	serverVersion, err := strconv.Atoi(strings.Split(conn.ParameterStatus("server_version"), ".")[0])
+	require.NoError(t, err)
+	if serverVersion < 15 {
+		t.Skip("READ_REPLICATION_SLOT requires PostgreSQL 15 or newer")
+	}
+
+	sysident, err := pglogrepl.IdentifySystem(ctx, conn)
+	require.NoError(t, err)
+
+	_, err = pglogrepl.CreateReplicationSlot(ctx, conn, slotName, "", pglogrepl.CreateReplicationSlotOptions{Temporary: true, Mode: pglogrepl.PhysicalReplication, ReserveWAL: true})
+	require.NoError(t, err)
+
+	result, err := pglogrepl.ReadReplicationSlot(ctx, conn, slotName)
+	require.NoError(t, err)
+	assert.Equal(t, "physical", result.SlotType)
+	assert.NotZero(t, result.RestartLSN)
+	assert.Equal(t, sysident.Timeline, result.RestartTimeline)
+
+	result, err = pglogrepl.ReadReplicationSlot(ctx, conn, "pglogrepl_missing_slot")
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.ReadReplicationSlotResult{}, result)
+}
+
+func TestReadReplicationSlotFake(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	fields := []pgproto3.FieldDescription{{Name: []byte("slot_type")}, {Name: []byte("restart_lsn")}, {Name: []byte("restart_tli")}}
+	queries := ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: fields},
+		&pgproto3.DataRow{Values: [][]byte{[]byte("physical"), []byte("0/3000028"), []byte("2")}},
+		&pgproto3.CommandComplete{CommandTag: []byte("READ_REPLICATION_SLOT")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	result, err := pglogrepl.ReadReplicationSlot(ctx, conn, slotName)
+	require.NoError(t, err)
+	assert.Equal(t, "READ_REPLICATION_SLOT "+slotName, <-queries)
+	assert.Equal(t, pglogrepl.ReadReplicationSlotResult{SlotType: "physical", RestartLSN: 0x3000028, RestartTimeline: 2}, result)
+
+	ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: fields},
+		&pgproto3.DataRow{Values: [][]byte{nil, nil, nil}},
+		&pgproto3.CommandComplete{CommandTag: []byte("READ_REPLICATION_SLOT")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	result, err = pglogrepl.ReadReplicationSlot(ctx, conn, "missing")
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.ReadReplicationSlotResult{}, result)
+}
+
+func TestAlterReplicationSlot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	serverVersion, err := strconv.Atoi(strings.Split(conn.ParameterStatus("server_version"), ".")[0])
+	require.NoError(t, err)
+	if serverVersion < 17 {
+		t.Skip("ALTER_REPLICATION_SLOT requires PostgreSQL 17 or newer")
+	}
+
+	_, err = pglogrepl.CreateReplicationSlot(ctx, conn, slotName, outputPlugin, pglogrepl.CreateReplicationSlotOptions{})
+	require.NoError(t, err)
+	defer pglogrepl.DropReplicationSlot(context.Background(), conn, slotName, pglogrepl.DropReplicationSlotOptions{})
+
+	failover := true
+	err = pglogrepl.AlterReplicationSlot(ctx, conn, slotName, pglogrepl.AlterReplicationSlotOptions{Failover: &failover})
+	require.NoError(t, err)
+}
+
+func TestAlterReplicationSlotFake(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	queries := ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.CommandComplete{CommandTag: []byte("ALTER_REPLICATION_SLOT")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	failover, twoPhase := true, false
+	err := pglogrepl.AlterReplicationSlot(ctx, conn, slotName, pglogrepl.AlterReplicationSlotOptions{Failover: &failover, TwoPhase: &twoPhase})
+	require.NoError(t, err)
+	assert.Equal(t, "ALTER_REPLICATION_SLOT "+slotName+" ( FAILOVER true, TWO_PHASE false )", <-queries)
+
+	err = pglogrepl.AlterReplicationSlot(ctx, conn, slotName, pglogrepl.AlterReplicationSlotOptions{})
+	require.Error(t, err)
+}
+
+func TestCreateReplicationSlotFake(t *testing.T) {
+	for _, tt := range []struct {
+		serverVersion string
+		options       pglogrepl.CreateReplicationSlotOptions
+		expected      string
+	}{
+		{"14.0", pglogrepl.CreateReplicationSlotOptions{Temporary: true, SnapshotAction: "NOEXPORT_SNAPSHOT", TwoPhase: true}, "CREATE_REPLICATION_SLOT " + slotName + " TEMPORARY LOGICAL test_decoding NOEXPORT_SNAPSHOT TWO_PHASE"},
+		{"14.0", pglogrepl.CreateReplicationSlotOptions{Mode: pglogrepl.PhysicalReplication, ReserveWAL: true}, "CREATE_REPLICATION_SLOT " + slotName + " PHYSICAL RESERVE_WAL"},
+		{"16.0", pglogrepl.CreateReplicationSlotOptions{}, "CREATE_REPLICATION_SLOT " + slotName + " LOGICAL test_decoding"},
+		{"16.0", pglogrepl.CreateReplicationSlotOptions{SnapshotAction: "USE_SNAPSHOT", TwoPhase: true}, "CREATE_REPLICATION_SLOT " + slotName + " LOGICAL test_decoding (SNAPSHOT 'use', TWO_PHASE)"},
+		{"16.0", pglogrepl.CreateReplicationSlotOptions{Mode: pglogrepl.PhysicalReplication, ReserveWAL: true}, "CREATE_REPLICATION_SLOT " + slotName + " PHYSICAL (RESERVE_WAL)"},
+		{"17.0", pglogrepl.CreateReplicationSlotOptions{TwoPhase: true, Failover: true}, "CREATE_REPLICATION_SLOT " + slotName + " LOGICAL test_decoding (TWO_PHASE, FAILOVER)"},
+	} {
+		t.Run(tt.expected, func(t *testing.T) {
+			conn, ws := newFakeWalSenderVersion(t, tt.serverVersion)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			queries := ws.serveQuery([]pgproto3.BackendMessage{
+				&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("slot_name")}, {Name: []byte("consistent_point")}, {Name: []byte("snapshot_name")}, {Name: []byte("output_plugin")}}},
+				&pgproto3.DataRow{Values: [][]byte{[]byte(slotName), []byte("0/100"), nil, []byte(outputPlugin)}},
+				&pgproto3.CommandComplete{CommandTag: []byte("CREATE_REPLICATION_SLOT")},
+				&pgproto3.ReadyForQuery{TxStatus: 'I'},
+			})
+			_, err := pglogrepl.CreateReplicationSlot(ctx, conn, slotName, outputPlugin, tt.options)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, <-queries)
+		})
+	}
+
+	conn, _ := newFakeWalSenderVersion(t, "16.0")
+	_, err := pglogrepl.CreateReplicationSlot(context.Background(), conn, slotName, outputPlugin, pglogrepl.CreateReplicationSlotOptions{Failover: true})
+	assert.EqualError(t, err, "failover replication slots require PostgreSQL 17 or newer")
+}
+
 func TestDropReplicationSlot(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
@@ -166,6 +396,121 @@ func TestDropReplicationSlot(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestDropReplicationSlotFake(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	sideConn, sideWS := newFakeWalSender(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	terminateQueries := sideWS.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("pg_terminate_backend")}}},
+		&pgproto3.DataRow{Values: [][]byte{[]byte("t")}},
+		&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	queries := ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.CommandComplete{CommandTag: []byte("DROP_REPLICATION_SLOT")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	err := pglogrepl.DropReplicationSlot(ctx, conn, slotName, pglogrepl.DropReplicationSlotOptions{TerminateConn: sideConn})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT pg_terminate_backend(active_pid) FROM pg_replication_slots WHERE slot_name = '"+slotName+"' AND active_pid IS NOT NULL", <-terminateQueries)
+	assert.Equal(t, "DROP_REPLICATION_SLOT "+slotName+" WAIT", <-queries)
+
+	queries = ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42704", Message: "replication slot does not exist"},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	err = pglogrepl.DropReplicationSlotIfExists(ctx, conn, slotName, pglogrepl.DropReplicationSlotOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "DROP_REPLICATION_SLOT "+slotName, strings.TrimSpace(<-queries))
+
+	queries = ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.ErrorResponse{Severity: "ERROR", Code: "55006", Message: "replication slot is active"},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	err = pglogrepl.DropReplicationSlotIfExists(ctx, conn, slotName, pglogrepl.DropReplicationSlotOptions{})
+	require.Error(t, err)
+	assert.Equal(t, "DROP_REPLICATION_SLOT "+slotName, strings.TrimSpace(<-queries))
+}
+
+func TestCreateReplicationSlotSQL(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	slotRow := func(lsn string) []pgproto3.BackendMessage {
+		return []pgproto3.BackendMessage{
+			&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("slot_name")}, {Name: []byte("lsn")}}},
+			&pgproto3.DataRow{Values: [][]byte{[]byte(slotName), []byte(lsn)}},
+			&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")},
+			&pgproto3.ReadyForQuery{TxStatus: 'I'},
+		}
+	}
+
+	queries := ws.serveQuery(slotRow("0/1500000"))
+	result, err := pglogrepl.CreateReplicationSlotSQL(ctx, conn, slotName, outputPlugin, pglogrepl.CreateReplicationSlotOptions{Failover: true})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT slot_name, lsn FROM pg_create_logical_replication_slot('"+slotName+"', '"+outputPlugin+"', temporary => false, failover => true)", <-queries)
+	assert.Equal(t, pglogrepl.CreateReplicationSlotResult{SlotName: slotName, ConsistentPoint: "0/1500000", OutputPlugin: outputPlugin}, result)
+
+	queries = ws.serveQuery(slotRow(""))
+	result, err = pglogrepl.CreateReplicationSlotSQL(ctx, conn, slotName, "", pglogrepl.CreateReplicationSlotOptions{Mode: pglogrepl.PhysicalReplication, Temporary: true})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT slot_name, lsn FROM pg_create_physical_replication_slot('"+slotName+"', immediately_reserve => false, temporary => true)", <-queries)
+	assert.Equal(t, "", result.ConsistentPoint)
+
+	_, err = pglogrepl.CreateReplicationSlotSQL(ctx, conn, slotName, outputPlugin, pglogrepl.CreateReplicationSlotOptions{SnapshotAction: pglogrepl.SnapshotActionExport})
+	assert.Error(t, err)
+}
+
+func TestDropReplicationSlotSQL(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	active := []pgproto3.BackendMessage{
+		&pgproto3.ErrorResponse{Severity: "ERROR", Code: "55006", Message: "replication slot is active"},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	}
+	queries := ws.serveQuery(active)
+	err := pglogrepl.DropReplicationSlotSQL(ctx, conn, slotName, pglogrepl.DropReplicationSlotOptions{})
+	require.Error(t, err)
+	assert.Equal(t, "SELECT pg_drop_replication_slot('"+slotName+"')", <-queries)
+
+	// The drop is retried until the terminated consumer released the slot.
+	done := make(chan error, 1)
+	go func() {
+		done <- pglogrepl.DropReplicationSlotSQL(ctx, conn, slotName, pglogrepl.DropReplicationSlotOptions{TerminateConn: conn})
+	}()
+	assert.Equal(t, "SELECT pg_terminate_backend(active_pid) FROM pg_replication_slots WHERE slot_name = '"+slotName+"' AND active_pid IS NOT NULL",
+		<-ws.serveQuery(commandCompleteResponse("SELECT 0")))
+	<-ws.serveQuery(active)
+	assert.Equal(t, "SELECT pg_drop_replication_slot('"+slotName+"')", <-ws.serveQuery(commandCompleteResponse("SELECT 1")))
+	require.NoError(t, <-done)
+}
+
+func TestAdvanceReplicationSlotFake(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	queries := ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("end_lsn")}}},
+		&pgproto3.DataRow{Values: [][]byte{[]byte("0/1700")}},
+		&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	lsn, err := pglogrepl.AdvanceReplicationSlot(ctx, conn, slotName, 0x1800)
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT end_lsn FROM pg_replication_slot_advance('"+slotName+"', '0/1800')", <-queries)
+	assert.Equal(t, pglogrepl.LSN(0x1700), lsn)
+}
+
 func TestStartReplication(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
@@ -404,3 +749,28 @@ func TestSendStandbyStatusUpdate(t *testing.T) {
 	err = pglogrepl.SendStandbyStatusUpdate(ctx, conn, pglogrepl.StandbyStatusUpdate{WALWritePosition: sysident.XLogPos})
 	require.NoError(t, err)
 }
+
+func TestSendStandbyHotStandbyFeedback(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	clientTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	err := pglogrepl.SendStandbyHotStandbyFeedback(context.Background(), conn, pglogrepl.HotStandbyFeedback{
+		ClientTime:       clientTime,
+		Xmin:             750,
+		XminEpoch:        1,
+		CatalogXmin:      740,
+		CatalogXminEpoch: 2,
+	})
+	require.NoError(t, err)
+
+	msg := ws.receive()
+	cd, ok := msg.(*pgproto3.CopyData)
+	require.True(t, ok, "expected CopyData, got %T", msg)
+	require.Len(t, cd.Data, 25)
+	assert.Equal(t, byte(pglogrepl.HotStandbyFeedbackByteID), cd.Data[0])
+	assert.Equal(t, uint64(clientTime.Sub(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).Microseconds()), binary.BigEndian.Uint64(cd.Data[1:]))
+	assert.Equal(t, uint32(750), binary.BigEndian.Uint32(cd.Data[9:]))
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(cd.Data[13:]))
+	assert.Equal(t, uint32(740), binary.BigEndian.Uint32(cd.Data[17:]))
+	assert.Equal(t, uint32(2), binary.BigEndian.Uint32(cd.Data[21:]))
+}
@@ -0,0 +1,287 @@
+package pglogrepltest
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+)
+
+// Table describes a table for building the pgoutput messages of its changes.
+type Table struct {
+	RelationID uint32
+	Namespace  string
+	Name       string
+	// ReplicaIdentity is one of the pglogrepl.ReplicaIdentity constants. If it is 0
+	// pglogrepl.ReplicaIdentityDefault is used.
+	ReplicaIdentity uint8
+	Columns         []Column
+}
+
+// Column describes a column of a Table.
+type Column struct {
+	Name         string
+	DataType     uint32
+	TypeModifier int32
+	// Key marks the column as part of the replica identity.
+	Key bool
+}
+
+// Unchanged is the value of an unchanged TOAST column in the new tuple of an update.
+var Unchanged = unchangedValue{}
+
+type unchangedValue struct{}
+
+// RelationMessage returns the relation message describing t.
+func (t *Table) RelationMessage() *pglogrepl.RelationMessage {
+	rel := &pglogrepl.RelationMessage{
+		RelationID:      t.RelationID,
+		Namespace:       t.Namespace,
+		RelationName:    t.Name,
+		ReplicaIdentity: t.replicaIdentity(),
+		ColumnNum:       uint16(len(t.Columns)),
+	}
+	for _, column := range t.Columns {
+		var flags uint8
+		if column.Key {
+			flags = 1
+		}
+		rel.Columns = append(rel.Columns, &pglogrepl.RelationMessageColumn{
+			Flags:        flags,
+			Name:         column.Name,
+			DataType:     column.DataType,
+			TypeModifier: column.TypeModifier,
+		})
+	}
+	return rel
+}
+
+func (t *Table) replicaIdentity() uint8 {
+	if t.ReplicaIdentity == 0 {
+		return pglogrepl.ReplicaIdentityDefault
+	}
+	return t.ReplicaIdentity
+}
+
+// Tuple returns the tuple of a row of t with values in the text format. A value is NULL if it is
+// nil, an unchanged TOAST value if it is Unchanged, and otherwise formatted as text: strings and
+// byte slices as they are, time.Time values as timestamptz and other values with fmt.Sprint.
+func (t *Table) Tuple(values ...interface{}) (*pglogrepl.TupleData, error) {
+	if len(values) != len(t.Columns) {
+		return nil, fmt.Errorf("table %s.%s has %d columns, got %d values", t.Namespace, t.Name, len(t.Columns), len(values))
+	}
+	tuple := &pglogrepl.TupleData{ColumnNum: uint16(len(values))}
+	for _, value := range values {
+		tuple.Columns = append(tuple.Columns, tupleColumn(value))
+	}
+	return tuple, nil
+}
+
+// keyTuple returns the tuple of the replica identity of a row of t. Only the key columns are set
+// unless t has the full replica identity, as pgoutput does.
+func (t *Table) keyTuple(values []interface{}) (*pglogrepl.TupleData, uint8, error) {
+	tuple, err := t.Tuple(values...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if t.replicaIdentity() == pglogrepl.ReplicaIdentityFull {
+		return tuple, pglogrepl.UpdateMessageTupleTypeOld, nil
+	}
+	for i, column := range t.Columns {
+		if !column.Key {
+			tuple.Columns[i] = &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeNull}
+		}
+	}
+	return tuple, pglogrepl.UpdateMessageTupleTypeKey, nil
+}
+
+func tupleColumn(value interface{}) *pglogrepl.TupleDataColumn {
+	var text string
+	switch value := value.(type) {
+	case nil:
+		return &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeNull}
+	case unchangedValue:
+		return &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeToast}
+	case string:
+		text = value
+	case []byte:
+		text = string(value)
+	case bool:
+		text = "f"
+		if value {
+			text = "t"
+		}
+	case time.Time:
+		text = value.Format("2006-01-02 15:04:05.999999Z07:00")
+	case float64:
+		text = strconv.FormatFloat(value, 'g', -1, 64)
+	default:
+		text = fmt.Sprint(value)
+	}
+	return &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(text)), Data: []byte(text)}
+}
+
+// Builder builds the WAL data of pgoutput transactions, for example to script a Server:
+//
+//	b := pglogrepltest.NewBuilder(0x1000)
+//	b.Begin(1000, time.Now())
+//	b.Insert(users, 1, "alice")
+//	b.Update(users, nil, []interface{}{1, "bob"})
+//	b.Commit()
+//	msgs, err := b.Messages()
+//	...
+//	err = srv.SendMessages(msgs)
+//
+// Each message starts at the LSN following the end of the previous message. The relation message
+// of a table is built before its first change.
+type Builder struct {
+	lsn       pglogrepl.LSN
+	msgs      []pglogrepl.XLogData
+	relations map[uint32]bool
+	// begin is the index of the begin message of the open transaction, which is encoded at commit
+	// once the final LSN is known.
+	begin      int
+	inTx       bool
+	beginMsg   pglogrepl.BeginMessage
+	err        error
+	serverTime time.Time
+}
+
+// NewBuilder returns a Builder whose first message starts at startLSN.
+func NewBuilder(startLSN pglogrepl.LSN) *Builder {
+	return &Builder{lsn: startLSN, relations: map[uint32]bool{}, serverTime: time.Now()}
+}
+
+// LSN returns the LSN the next message starts at.
+func (b *Builder) LSN() pglogrepl.LSN {
+	return b.lsn
+}
+
+// Begin starts a transaction with the ID xid committed at commitTime.
+func (b *Builder) Begin(xid uint32, commitTime time.Time) {
+	if b.inTx {
+		b.fail(fmt.Errorf("transaction %d is already open", b.beginMsg.Xid))
+		return
+	}
+	b.inTx = true
+	b.begin = len(b.msgs)
+	b.beginMsg = pglogrepl.BeginMessage{CommitTime: commitTime, Xid: xid}
+	b.add(&b.beginMsg)
+}
+
+// Relation builds the relation message of t, as the server does again after the table is altered.
+func (b *Builder) Relation(t *Table) {
+	b.relations[t.RelationID] = true
+	b.add(t.RelationMessage())
+}
+
+// Insert builds an insert of a row of t, see Table.Tuple for the values.
+func (b *Builder) Insert(t *Table, values ...interface{}) {
+	tuple, err := t.Tuple(values...)
+	if err != nil {
+		b.fail(err)
+		return
+	}
+	b.change(t, &pglogrepl.InsertMessage{RelationID: t.RelationID, Tuple: tuple})
+}
+
+// Update builds an update of a row of t from oldValues to newValues. If oldValues is nil the update
+// has no old tuple, as when the replica identity is unchanged. Otherwise the old tuple holds the
+// key columns of oldValues, or all of them if t has the full replica identity.
+func (b *Builder) Update(t *Table, oldValues, newValues []interface{}) {
+	msg := &pglogrepl.UpdateMessage{RelationID: t.RelationID}
+	var err error
+	if oldValues != nil {
+		if msg.OldTuple, msg.OldTupleType, err = t.keyTuple(oldValues); err != nil {
+			b.fail(err)
+			return
+		}
+	}
+	if msg.NewTuple, err = t.Tuple(newValues...); err != nil {
+		b.fail(err)
+		return
+	}
+	b.change(t, msg)
+}
+
+// Delete builds a delete of a row of t. The old tuple holds the key columns of values, or all of
+// them if t has the full replica identity.
+func (b *Builder) Delete(t *Table, values ...interface{}) {
+	tuple, tupleType, err := t.keyTuple(values)
+	if err != nil {
+		b.fail(err)
+		return
+	}
+	b.change(t, &pglogrepl.DeleteMessage{RelationID: t.RelationID, OldTupleType: tupleType, OldTuple: tuple})
+}
+
+// Commit commits the open transaction.
+func (b *Builder) Commit() {
+	if !b.inTx {
+		b.fail(fmt.Errorf("no transaction is open"))
+		return
+	}
+	b.inTx = false
+	commitLSN := b.lsn
+	b.beginMsg.FinalLSN = commitLSN
+	walData, err := b.beginMsg.Encode(nil)
+	if err != nil {
+		b.fail(err)
+		return
+	}
+	b.msgs[b.begin].WALData = walData
+
+	commit := &pglogrepl.CommitMessage{CommitLSN: commitLSN, CommitTime: b.beginMsg.CommitTime}
+	// The commit message has a fixed size, so its end is known before it is encoded.
+	commitData, err := commit.Encode(nil)
+	if err != nil {
+		b.fail(err)
+		return
+	}
+	commit.TransactionEndLSN = commitLSN.Add(uint64(len(commitData)))
+	b.add(commit)
+}
+
+// Messages returns the messages built, or the first error building them. It reports an error if a
+// transaction is still open.
+func (b *Builder) Messages() ([]pglogrepl.XLogData, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.inTx {
+		return nil, fmt.Errorf("transaction %d is not committed", b.beginMsg.Xid)
+	}
+	return append([]pglogrepl.XLogData(nil), b.msgs...), nil
+}
+
+func (b *Builder) change(t *Table, msg pglogrepl.MessageEncoder) {
+	if !b.inTx {
+		b.fail(fmt.Errorf("changes of table %s.%s must be built in a transaction", t.Namespace, t.Name))
+		return
+	}
+	if !b.relations[t.RelationID] {
+		b.Relation(t)
+	}
+	b.add(msg)
+}
+
+func (b *Builder) add(msg pglogrepl.MessageEncoder) {
+	if b.err != nil {
+		return
+	}
+	walData, err := msg.Encode(nil)
+	if err != nil {
+		b.fail(err)
+		return
+	}
+	end := b.lsn.Add(uint64(len(walData)))
+	b.msgs = append(b.msgs, pglogrepl.XLogData{WALStart: b.lsn, ServerWALEnd: end, ServerTime: b.serverTime, WALData: walData})
+	b.lsn = end
+}
+
+func (b *Builder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
@@ -0,0 +1,122 @@
+package pglogrepltest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/pglogrepltest"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var users = &pglogrepltest.Table{
+	RelationID: 16384,
+	Namespace:  "public",
+	Name:       "users",
+	Columns: []pglogrepltest.Column{
+		{Name: "id", DataType: pgtype.Int4OID, TypeModifier: -1, Key: true},
+		{Name: "name", DataType: pgtype.TextOID, TypeModifier: -1},
+	},
+}
+
+func TestBuilder(t *testing.T) {
+	commitTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	b := pglogrepltest.NewBuilder(0x1000)
+	b.Begin(1000, commitTime)
+	b.Insert(users, 1, "alice")
+	b.Update(users, []interface{}{1, "alice"}, []interface{}{2, pglogrepltest.Unchanged})
+	b.Delete(users, 2, nil)
+	b.Commit()
+	msgs, err := b.Messages()
+	require.NoError(t, err)
+	require.Len(t, msgs, 6)
+
+	var parsed []pglogrepl.Message
+	for i, msg := range msgs {
+		if i > 0 {
+			assert.Equal(t, msgs[i-1].ServerWALEnd, msg.WALStart)
+		}
+		m, err := pglogrepl.Parse(msg.WALData)
+		require.NoError(t, err)
+		parsed = append(parsed, m)
+	}
+	assert.Equal(t, b.LSN(), msgs[5].ServerWALEnd)
+
+	begin := parsed[0].(*pglogrepl.BeginMessage)
+	commit := parsed[5].(*pglogrepl.CommitMessage)
+	assert.Equal(t, uint32(1000), begin.Xid)
+	assert.True(t, begin.CommitTime.Equal(commitTime))
+	assert.Equal(t, msgs[5].WALStart, begin.FinalLSN)
+	assert.Equal(t, msgs[5].WALStart, commit.CommitLSN)
+	assert.Equal(t, msgs[5].ServerWALEnd, commit.TransactionEndLSN)
+
+	rel := parsed[1].(*pglogrepl.RelationMessage)
+	assert.Equal(t, "users", rel.RelationName)
+	assert.Equal(t, pglogrepl.ReplicaIdentityDefault, rel.ReplicaIdentity)
+	assert.Equal(t, uint8(1), rel.Columns[0].Flags)
+
+	m := pgtype.NewMap()
+	values, err := pglogrepl.DecodeTuple(rel, parsed[2].(*pglogrepl.InsertMessage).Tuple, m)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": int32(1), "name": "alice"}, values)
+
+	update := parsed[3].(*pglogrepl.UpdateMessage)
+	assert.Equal(t, pglogrepl.UpdateMessageTupleTypeKey, update.OldTupleType)
+	assert.Equal(t, pglogrepl.TupleDataTypeNull, update.OldTuple.Columns[1].DataType)
+	assert.Equal(t, pglogrepl.TupleDataTypeToast, update.NewTuple.Columns[1].DataType)
+
+	del := parsed[4].(*pglogrepl.DeleteMessage)
+	assert.Equal(t, pglogrepl.DeleteMessageTupleTypeKey, del.OldTupleType)
+	assert.Equal(t, []byte("2"), del.OldTuple.Columns[0].Data)
+}
+
+func TestBuilderErrors(t *testing.T) {
+	b := pglogrepltest.NewBuilder(0)
+	b.Insert(users, 1, "alice")
+	_, err := b.Messages()
+	assert.Error(t, err)
+
+	b = pglogrepltest.NewBuilder(0)
+	b.Begin(1, time.Now())
+	b.Insert(users, 1)
+	b.Commit()
+	_, err = b.Messages()
+	assert.Error(t, err)
+
+	b = pglogrepltest.NewBuilder(0)
+	b.Begin(1, time.Now())
+	_, err = b.Messages()
+	assert.Error(t, err)
+}
+
+func TestBuilderServer(t *testing.T) {
+	srv, err := pglogrepltest.NewServer(pglogrepltest.ServerOptions{})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, srv.ConnString())
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+
+	b := pglogrepltest.NewBuilder(0x1000)
+	b.Begin(1000, time.Now())
+	b.Insert(users, 1, "alice")
+	b.Commit()
+	msgs, err := b.Messages()
+	require.NoError(t, err)
+	require.NoError(t, srv.SendMessages(msgs))
+
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, "slot", 0x1000, pglogrepl.ReplicationStreamOptions{ProtoVersion: 1})
+	require.NoError(t, err)
+	tx, err := pglogrepl.NewTransactionReader(stream, pglogrepl.TransactionAssemblerOptions{}).Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1000), tx.Xid)
+	require.Len(t, tx.Changes, 2)
+}
@@ -0,0 +1,533 @@
+// Package pglogrepltest provides a fake PostgreSQL walsender for testing replication clients
+// without a database.
+//
+// A Server accepts replication connections and answers IDENTIFY_SYSTEM, CREATE_REPLICATION_SLOT,
+// DROP_REPLICATION_SLOT and START_REPLICATION. Once a client has started replication, the WAL data
+// and keepalives scripted by the test with SendXLogData and SendKeepalive are streamed to it, and
+// the standby status updates it sends are recorded:
+//
+//	srv, err := pglogrepltest.NewServer(pglogrepltest.ServerOptions{})
+//	...
+//	defer srv.Close()
+//	conn, err := pgconn.Connect(ctx, srv.ConnString())
+//	...
+//	srv.SendXLogData(0x1000, walData)
+//	ssu, err := srv.WaitStandbyStatusUpdate(ctx, 0x1000)
+//
+// A Builder builds the WAL data of pgoutput transactions from Table descriptions and row values,
+// to be streamed with SendMessages.
+package pglogrepltest
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// ServerOptions configures a Server.
+type ServerOptions struct {
+	// ServerVersion is the server_version reported to clients. If it is empty "16.0" is used.
+	ServerVersion string
+
+	// SystemID, Timeline, XLogPos and DBName are the result of IDENTIFY_SYSTEM. If SystemID is
+	// empty "7000000000000000001" is used, if Timeline is 0 then 1 is used and if DBName is empty
+	// "postgres" is used.
+	SystemID string
+	Timeline int32
+	XLogPos  pglogrepl.LSN
+	DBName   string
+
+	// WALSegmentSize is the result of SHOW wal_segment_size. If it is empty "16MB" is used.
+	WALSegmentSize string
+}
+
+// Server is a fake walsender listening on a local TCP port.
+type Server struct {
+	ln      net.Listener
+	options ServerOptions
+	// outgoing are the scripted messages streamed to the replicating client, and endOfStream.
+	outgoing chan *pgproto3.CopyData
+
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	queries []string
+	slots   map[string]bool
+	updates []pglogrepl.StandbyStatusUpdate
+	// unsent is a scripted message that could not be sent to a client that disconnected.
+	unsent *pgproto3.CopyData
+	// updated is closed and replaced when a standby status update is received.
+	updated chan struct{}
+	// timelineEnds are the next timelines reported by the ends of timelines scripted with
+	// EndTimeline, in order, and history the lines of the timeline history file.
+	timelineEnds []timelineEnd
+	history      []string
+	closed       bool
+	wg           sync.WaitGroup
+}
+
+type timelineEnd struct {
+	timeline int32
+	startLSN pglogrepl.LSN
+}
+
+// endOfStream is the scripted message of EndStream and endOfTimeline the one of EndTimeline.
+var (
+	endOfStream   = &pgproto3.CopyData{}
+	endOfTimeline = &pgproto3.CopyData{}
+)
+
+// maxScriptedMessages is the number of scripted messages that can be queued before the client
+// starts replication.
+const maxScriptedMessages = 1024
+
+// NewServer starts a Server.
+func NewServer(options ServerOptions) (*Server, error) {
+	if options.ServerVersion == "" {
+		options.ServerVersion = "16.0"
+	}
+	if options.SystemID == "" {
+		options.SystemID = "7000000000000000001"
+	}
+	if options.Timeline == 0 {
+		options.Timeline = 1
+	}
+	if options.DBName == "" {
+		options.DBName = "postgres"
+	}
+	if options.WALSegmentSize == "" {
+		options.WALSegmentSize = "16MB"
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	s := &Server{
+		ln:       ln,
+		options:  options,
+		outgoing: make(chan *pgproto3.CopyData, maxScriptedMessages),
+		conns:    map[net.Conn]struct{}{},
+		slots:    map[string]bool{},
+		updated:  make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// ConnString returns the connection string of a replication connection to the server.
+func (s *Server) ConnString() string {
+	return fmt.Sprintf("postgres://pglogrepl@%s/%s?sslmode=disable&replication=database", s.ln.Addr(), s.options.DBName)
+}
+
+// Close stops the server and closes all connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Disconnect closes the connections of all clients, as if the server had crashed, so that a
+// client's reconnect logic can be tested. Scripted messages that have not been sent yet are sent to
+// the next replicating client.
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// SendXLogData streams walData as an XLogData message starting at walStart. The server WAL end of
+// the message is the end of walData.
+func (s *Server) SendXLogData(walStart pglogrepl.LSN, walData []byte) error {
+	data := make([]byte, 1+24, 1+24+len(walData))
+	data[0] = pglogrepl.XLogDataByteID
+	binary.BigEndian.PutUint64(data[1:], uint64(walStart))
+	binary.BigEndian.PutUint64(data[9:], uint64(walStart)+uint64(len(walData)))
+	binary.BigEndian.PutUint64(data[17:], uint64(pgTime(time.Now())))
+	return s.enqueue(&pgproto3.CopyData{Data: append(data, walData...)})
+}
+
+// SendMessages streams msgs, as built by a Builder, with SendXLogData.
+func (s *Server) SendMessages(msgs []pglogrepl.XLogData) error {
+	for _, msg := range msgs {
+		if err := s.SendXLogData(msg.WALStart, msg.WALData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendKeepalive streams a primary keepalive message with the server WAL end walEnd.
+func (s *Server) SendKeepalive(walEnd pglogrepl.LSN, replyRequested bool) error {
+	data := make([]byte, 1+17)
+	data[0] = pglogrepl.PrimaryKeepaliveMessageByteID
+	binary.BigEndian.PutUint64(data[1:], uint64(walEnd))
+	binary.BigEndian.PutUint64(data[9:], uint64(pgTime(time.Now())))
+	if replyRequested {
+		data[17] = 1
+	}
+	return s.enqueue(&pgproto3.CopyData{Data: data})
+}
+
+// EndStream ends the copy-both mode of the replicating client with CopyDone once the scripted
+// messages before it have been sent, as the server does when the end of a timeline is reached.
+func (s *Server) EndStream() error {
+	return s.enqueue(endOfStream)
+}
+
+// EndTimeline ends the copy-both mode like EndStream, as the server does when a physical
+// replication client reaches the end of a timeline, and then reports that the timeline following
+// it is the next timeline, starting at startLSN. IDENTIFY_SYSTEM reports the next timeline from
+// then on, and TIMELINE_HISTORY returns a history file recording the switch.
+func (s *Server) EndTimeline(startLSN pglogrepl.LSN) error {
+	s.mu.Lock()
+	timeline := s.options.Timeline + int32(len(s.timelineEnds)) + 1
+	s.timelineEnds = append(s.timelineEnds, timelineEnd{timeline: timeline, startLSN: startLSN})
+	s.mu.Unlock()
+	return s.enqueue(endOfTimeline)
+}
+
+func (s *Server) enqueue(msg *pgproto3.CopyData) error {
+	select {
+	case s.outgoing <- msg:
+		return nil
+	default:
+		return fmt.Errorf("more than %d scripted messages are queued", maxScriptedMessages)
+	}
+}
+
+// Queries returns the queries received by the server in order.
+func (s *Server) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.queries...)
+}
+
+// Slots returns the names of the replication slots created and not dropped.
+func (s *Server) Slots() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var slots []string
+	for slot := range s.slots {
+		slots = append(slots, slot)
+	}
+	return slots
+}
+
+// StandbyStatusUpdates returns the standby status updates received by the server in order.
+func (s *Server) StandbyStatusUpdates() []pglogrepl.StandbyStatusUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]pglogrepl.StandbyStatusUpdate(nil), s.updates...)
+}
+
+// WaitStandbyStatusUpdate waits until the server receives a standby status update with a flush
+// position of at least flushLSN and returns it.
+func (s *Server) WaitStandbyStatusUpdate(ctx context.Context, flushLSN pglogrepl.LSN) (pglogrepl.StandbyStatusUpdate, error) {
+	for {
+		s.mu.Lock()
+		for _, ssu := range s.updates {
+			if ssu.WALFlushPosition >= flushLSN {
+				s.mu.Unlock()
+				return ssu, nil
+			}
+		}
+		updated := s.updated
+		s.mu.Unlock()
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return pglogrepl.StandbyStatusUpdate{}, ctx.Err()
+		}
+	}
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+			conn.Close()
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// serve runs the session of a client until it disconnects.
+func (s *Server) serve(conn net.Conn) {
+	backend := pgproto3.NewBackend(conn, conn)
+	if _, err := backend.ReceiveStartupMessage(); err != nil {
+		return
+	}
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ParameterStatus{Name: "server_version", Value: s.options.ServerVersion})
+	backend.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 1})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if err := backend.Flush(); err != nil {
+		return
+	}
+
+	for {
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.Query:
+			s.mu.Lock()
+			s.queries = append(s.queries, msg.String)
+			s.mu.Unlock()
+			if err := s.query(backend, msg.String); err != nil {
+				return
+			}
+		case *pgproto3.Terminate:
+			return
+		default:
+			sendError(backend, "08P01", fmt.Sprintf("unexpected message %T", msg))
+			if err := backend.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) query(backend *pgproto3.Backend, query string) error {
+	fields := strings.Fields(query)
+	command := ""
+	if len(fields) > 0 {
+		command = strings.ToUpper(fields[0])
+	}
+
+	switch {
+	case command == "IDENTIFY_SYSTEM":
+		s.mu.Lock()
+		timeline := s.options.Timeline
+		s.mu.Unlock()
+		sendRow(backend, []string{"systemid", "timeline", "xlogpos", "dbname"}, []string{
+			s.options.SystemID,
+			strconv.Itoa(int(timeline)),
+			s.options.XLogPos.String(),
+			s.options.DBName,
+		})
+	case command == "SHOW" && len(fields) == 2 && strings.ToLower(fields[1]) == "wal_segment_size":
+		sendRow(backend, []string{"wal_segment_size"}, []string{s.options.WALSegmentSize})
+	case command == "TIMELINE_HISTORY" && len(fields) == 2:
+		timeline, err := strconv.Atoi(fields[1])
+		s.mu.Lock()
+		var history []string
+		if err == nil && timeline > 1 && timeline-1 <= len(s.history) {
+			history = s.history[:timeline-1]
+		}
+		s.mu.Unlock()
+		if history == nil {
+			sendError(backend, "58P01", fmt.Sprintf("could not open file \"pg_wal/%08X.history\"", timeline))
+			break
+		}
+		sendRow(backend, []string{"filename", "content"}, []string{fmt.Sprintf("%08X.history", timeline), strings.Join(history, "")})
+	case command == "CREATE_REPLICATION_SLOT" && len(fields) >= 2:
+		slot := fields[1]
+		plugin := ""
+		for i, field := range fields {
+			if strings.ToUpper(field) == "LOGICAL" && i+1 < len(fields) {
+				plugin = strings.Trim(fields[i+1], "()")
+			}
+		}
+		s.mu.Lock()
+		exists := s.slots[slot]
+		s.slots[slot] = true
+		s.mu.Unlock()
+		if exists {
+			sendError(backend, "42710", fmt.Sprintf("replication slot %q already exists", slot))
+			break
+		}
+		sendRow(backend, []string{"slot_name", "consistent_point", "snapshot_name", "output_plugin"}, []string{
+			slot, s.options.XLogPos.String(), "00000003-00000002-1", plugin,
+		})
+	case command == "DROP_REPLICATION_SLOT" && len(fields) >= 2:
+		slot := fields[1]
+		s.mu.Lock()
+		exists := s.slots[slot]
+		delete(s.slots, slot)
+		s.mu.Unlock()
+		if !exists {
+			sendError(backend, "42704", fmt.Sprintf("replication slot %q does not exist", slot))
+			break
+		}
+		backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("DROP_REPLICATION_SLOT")})
+	case command == "START_REPLICATION":
+		return s.stream(backend)
+	default:
+		sendError(backend, "0A000", fmt.Sprintf("pglogrepltest does not support %q", query))
+	}
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	return backend.Flush()
+}
+
+// stream runs the copy-both mode of START_REPLICATION until the client or the script ends it.
+func (s *Server) stream(backend *pgproto3.Backend) error {
+	backend.Send(&pgproto3.CopyBothResponse{})
+	if err := backend.Flush(); err != nil {
+		return err
+	}
+	// The client messages are received concurrently with the scripted messages being sent.
+	clientDone := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := backend.Receive()
+			if err != nil {
+				clientDone <- err
+				return
+			}
+			switch msg := msg.(type) {
+			case *pgproto3.CopyData:
+				s.receiveCopyData(msg.Data)
+			case *pgproto3.CopyDone:
+				clientDone <- nil
+				return
+			default:
+				clientDone <- fmt.Errorf("unexpected message %T in copy-both mode", msg)
+				return
+			}
+		}
+	}()
+
+	for {
+		s.mu.Lock()
+		msg := s.unsent
+		s.unsent = nil
+		s.mu.Unlock()
+		if msg == nil {
+			select {
+			case msg = <-s.outgoing:
+			case err := <-clientDone:
+				if err != nil {
+					return err
+				}
+				backend.Send(&pgproto3.CopyDone{})
+				return s.endCopy(backend)
+			}
+		}
+
+		if msg == endOfStream || msg == endOfTimeline {
+			backend.Send(&pgproto3.CopyDone{})
+			if err := backend.Flush(); err != nil {
+				return err
+			}
+			if err := <-clientDone; err != nil {
+				return err
+			}
+			if msg == endOfTimeline {
+				s.endTimeline(backend)
+			}
+			return s.endCopy(backend)
+		}
+		backend.Send(msg)
+		if err := backend.Flush(); err != nil {
+			s.mu.Lock()
+			s.unsent = msg
+			s.mu.Unlock()
+			return err
+		}
+	}
+}
+
+// endTimeline reports the next timeline of the first scripted timeline end and switches to it.
+func (s *Server) endTimeline(backend *pgproto3.Backend) {
+	s.mu.Lock()
+	end := s.timelineEnds[0]
+	s.timelineEnds = s.timelineEnds[1:]
+	s.history = append(s.history, fmt.Sprintf("%d\t%s\tpglogrepltest timeline switch\n", s.options.Timeline, end.startLSN))
+	s.options.Timeline = end.timeline
+	s.mu.Unlock()
+	backend.Send(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
+		{Name: []byte("next_tli"), DataTypeOID: 20, DataTypeSize: 8},
+		{Name: []byte("next_tli_startpos"), DataTypeOID: 25, DataTypeSize: -1},
+	}})
+	backend.Send(&pgproto3.DataRow{Values: [][]byte{[]byte(strconv.Itoa(int(end.timeline))), []byte(end.startLSN.String())}})
+	backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")})
+}
+
+func (s *Server) endCopy(backend *pgproto3.Backend) error {
+	backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("START_REPLICATION")})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	return backend.Flush()
+}
+
+func (s *Server) receiveCopyData(data []byte) {
+	if len(data) != 34 || data[0] != pglogrepl.StandbyStatusUpdateByteID {
+		return
+	}
+	ssu := pglogrepl.StandbyStatusUpdate{
+		WALWritePosition: pglogrepl.LSN(binary.BigEndian.Uint64(data[1:])),
+		WALFlushPosition: pglogrepl.LSN(binary.BigEndian.Uint64(data[9:])),
+		WALApplyPosition: pglogrepl.LSN(binary.BigEndian.Uint64(data[17:])),
+		ClientTime:       pgTimeToTime(int64(binary.BigEndian.Uint64(data[25:]))),
+		ReplyRequested:   data[33] != 0,
+	}
+	s.mu.Lock()
+	s.updates = append(s.updates, ssu)
+	close(s.updated)
+	s.updated = make(chan struct{})
+	s.mu.Unlock()
+}
+
+func sendRow(backend *pgproto3.Backend, columns, values []string) {
+	fields := make([]pgproto3.FieldDescription, len(columns))
+	row := make([][]byte, len(values))
+	for i, column := range columns {
+		fields[i] = pgproto3.FieldDescription{Name: []byte(column), DataTypeOID: 25, DataTypeSize: -1}
+		row[i] = []byte(values[i])
+	}
+	backend.Send(&pgproto3.RowDescription{Fields: fields})
+	backend.Send(&pgproto3.DataRow{Values: row})
+	backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")})
+}
+
+func sendError(backend *pgproto3.Backend, code, message string) {
+	backend.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: code, Message: message})
+}
+
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// pgTime returns t in microseconds since the PostgreSQL epoch.
+func pgTime(t time.Time) int64 {
+	return t.Sub(pgEpoch).Microseconds()
+}
+
+func pgTimeToTime(us int64) time.Time {
+	return pgEpoch.Add(time.Duration(us) * time.Microsecond).Local()
+}
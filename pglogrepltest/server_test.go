@@ -0,0 +1,160 @@
+package pglogrepltest_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/pglogrepltest"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	srv, err := pglogrepltest.NewServer(pglogrepltest.ServerOptions{XLogPos: 0x1000})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, srv.ConnString())
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+
+	sysident, err := pglogrepl.IdentifySystem(ctx, conn)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.IdentifySystemResult{SystemID: "7000000000000000001", Timeline: 1, XLogPos: 0x1000, DBName: "postgres"}, sysident)
+
+	slot, err := pglogrepl.CreateReplicationSlot(ctx, conn, "slot", "pgoutput", pglogrepl.CreateReplicationSlotOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "0/1000", slot.ConsistentPoint)
+	assert.Equal(t, []string{"slot"}, srv.Slots())
+	_, err = pglogrepl.CreateReplicationSlot(ctx, conn, "slot", "pgoutput", pglogrepl.CreateReplicationSlotOptions{})
+	require.Error(t, err)
+
+	insert := &pglogrepl.InsertMessage{RelationID: 1, Tuple: &pglogrepl.TupleData{
+		ColumnNum: 1,
+		Columns:   []*pglogrepl.TupleDataColumn{{DataType: pglogrepl.TupleDataTypeText, Length: 1, Data: []byte("1")}},
+	}}
+	walData, err := insert.Encode(nil)
+	require.NoError(t, err)
+	require.NoError(t, srv.SendXLogData(0x1100, walData))
+
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, "slot", 0x1000, pglogrepl.ReplicationStreamOptions{
+		StartReplicationOptions: pglogrepl.StartReplicationOptions{PluginArgs: []string{"proto_version '1'"}},
+		ProtoVersion:            1,
+	})
+	require.NoError(t, err)
+	msg, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x1100), msg.WALStart)
+	assert.IsType(t, &pglogrepl.InsertMessage{}, msg.Message)
+
+	require.NoError(t, srv.SendKeepalive(0x1200, true))
+	// The stream replies to the keepalive while waiting for the next message.
+	nextErr := make(chan error, 1)
+	go func() {
+		_, err := stream.Next(ctx)
+		nextErr <- err
+	}()
+	ssu, err := srv.WaitStandbyStatusUpdate(ctx, 0x1200)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x1200), ssu.WALFlushPosition)
+	require.NoError(t, srv.EndStream())
+	require.ErrorIs(t, <-nextErr, io.EOF)
+	assert.Contains(t, srv.Queries(), "START_REPLICATION SLOT slot LOGICAL 0/1000 (proto_version '1')")
+}
+
+func TestServerEndStream(t *testing.T) {
+	srv, err := pglogrepltest.NewServer(pglogrepltest.ServerOptions{})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, srv.ConnString())
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+
+	require.NoError(t, srv.EndStream())
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, "slot", 0, pglogrepl.ReplicationStreamOptions{})
+	require.NoError(t, err)
+	_, err = stream.Next(ctx)
+	require.ErrorIs(t, err, io.EOF)
+	_, err = pglogrepl.SendStandbyCopyDone(ctx, conn)
+	require.NoError(t, err)
+
+	_, err = pglogrepl.IdentifySystem(ctx, conn)
+	require.NoError(t, err)
+}
+
+func TestServerEndTimeline(t *testing.T) {
+	srv, err := pglogrepltest.NewServer(pglogrepltest.ServerOptions{WALSegmentSize: "1MB"})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, srv.ConnString())
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+
+	results, err := conn.Exec(ctx, "SHOW wal_segment_size").ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, "1MB", string(results[0].Rows[0][0]))
+	_, err = pglogrepl.TimelineHistory(ctx, conn, 2)
+	assert.Error(t, err)
+
+	require.NoError(t, srv.EndTimeline(0x2000000))
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, "", 0x1000000, pglogrepl.ReplicationStreamOptions{
+		StartReplicationOptions: pglogrepl.StartReplicationOptions{Mode: pglogrepl.PhysicalReplication},
+	})
+	require.NoError(t, err)
+	_, err = stream.Next(ctx)
+	require.ErrorIs(t, err, io.EOF)
+	cdr, err := pglogrepl.DrainStream(ctx, conn)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.CopyDoneResult{Timeline: 2, LSN: 0x2000000}, cdr)
+
+	sysident, err := pglogrepl.IdentifySystem(ctx, conn)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), sysident.Timeline)
+	history, err := pglogrepl.TimelineHistory(ctx, conn, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "00000002.history", history.FileName)
+	assert.Equal(t, []pglogrepl.TimelineHistoryEntry{{Timeline: 1, SwitchPoint: 0x2000000, Reason: "pglogrepltest timeline switch"}}, history.Entries)
+}
+
+func TestServerDisconnect(t *testing.T) {
+	srv, err := pglogrepltest.NewServer(pglogrepltest.ServerOptions{})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	connect := func(ctx context.Context) (*pgconn.PgConn, error) {
+		return pgconn.Connect(ctx, srv.ConnString())
+	}
+	conn, err := connect(ctx)
+	require.NoError(t, err)
+
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, "slot", 0x100, pglogrepl.ReplicationStreamOptions{
+		Reconnect: &pglogrepl.ReconnectPolicy{Connect: connect, InitialBackoff: time.Millisecond},
+	})
+	require.NoError(t, err)
+	defer func() { stream.Conn().Close(ctx) }()
+
+	srv.Disconnect()
+	require.NoError(t, srv.SendXLogData(0x200, []byte("data")))
+	msg, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), msg.WALData)
+	assert.Equal(t, 1, stream.Reconnects())
+}
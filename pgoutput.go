@@ -0,0 +1,203 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// PgoutputStreaming is the value of the pgoutput streaming option.
+type PgoutputStreaming string
+
+const (
+	// PgoutputStreamingOff does not stream transactions in progress. It is the default.
+	PgoutputStreamingOff PgoutputStreaming = ""
+	// PgoutputStreamingOn streams large transactions in progress. It requires protocol version 2.
+	PgoutputStreamingOn PgoutputStreaming = "on"
+	// PgoutputStreamingParallel streams large transactions in progress and sends the information
+	// needed to apply them in parallel. It requires protocol version 4.
+	PgoutputStreamingParallel PgoutputStreaming = "parallel"
+)
+
+// PgoutputOptions are the options of the pgoutput plugin. They are serialized into the plugin
+// arguments of START_REPLICATION with PluginArgs.
+type PgoutputOptions struct {
+	// ProtoVersion is the pgoutput protocol version. If it is 0 then 1 is used.
+	ProtoVersion int
+	// PublicationNames are the publications to subscribe to. At least one is required.
+	PublicationNames []string
+	// Binary requests the column data in binary format. It requires PostgreSQL 14 or newer.
+	Binary bool
+	// Messages enables logical decoding messages. It requires PostgreSQL 14 or newer.
+	Messages bool
+	// Streaming enables streaming of transactions in progress.
+	Streaming PgoutputStreaming
+	// TwoPhase enables the decoding of prepared transactions. It requires protocol version 3.
+	TwoPhase bool
+	// Origin is "none" to only send changes without a replication origin or "any" to send all
+	// changes. If it is empty the server default, "any", is used. It requires PostgreSQL 16 or
+	// newer.
+	Origin string
+	// Sequences enables the replication of sequence changes as SequenceMessages. No released
+	// server supports it yet.
+	Sequences bool
+}
+
+// PluginArgs returns the options as plugin arguments for StartReplicationOptions.PluginArgs.
+func (o PgoutputOptions) PluginArgs() ([]string, error) {
+	if len(o.PublicationNames) == 0 {
+		return nil, fmt.Errorf("pgoutput options have no publication names")
+	}
+	protoVersion := o.ProtoVersion
+	if protoVersion == 0 {
+		protoVersion = 1
+	}
+
+	names := make([]string, len(o.PublicationNames))
+	for i, name := range o.PublicationNames {
+		names[i] = quotePublicationName(name)
+	}
+	args := []string{
+		fmt.Sprintf("proto_version '%d'", protoVersion),
+		"publication_names " + quoteLiteral(strings.Join(names, ",")),
+	}
+	if o.Binary {
+		args = append(args, "binary 'true'")
+	}
+	if o.Messages {
+		args = append(args, "messages 'true'")
+	}
+	if o.Streaming != PgoutputStreamingOff {
+		args = append(args, "streaming "+quoteLiteral(string(o.Streaming)))
+	}
+	if o.TwoPhase {
+		args = append(args, "two_phase 'true'")
+	}
+	if o.Origin != "" {
+		args = append(args, "origin "+quoteLiteral(o.Origin))
+	}
+	if o.Sequences {
+		args = append(args, "sequences 'true'")
+	}
+	return args, nil
+}
+
+// PgoutputCapabilities are the pgoutput features supported by a server.
+type PgoutputCapabilities struct {
+	// ServerVersion is the major version of the server, such as 16.
+	ServerVersion int
+	// MaxProtoVersion is the highest protocol version supported: 1 up to PostgreSQL 13, 2 with
+	// PostgreSQL 14, 3 with PostgreSQL 15 and 4 since PostgreSQL 16. It is 0 before PostgreSQL 10,
+	// which has no pgoutput plugin.
+	MaxProtoVersion int
+	// Streaming reports whether transactions in progress can be streamed, ParallelStreaming
+	// whether they can be streamed with PgoutputStreamingParallel.
+	Streaming         bool
+	ParallelStreaming bool
+	// TwoPhase, Binary, Messages, Origin and Sequences report whether the options of the same
+	// names of PgoutputOptions are supported. Sequences is false for every released version.
+	TwoPhase  bool
+	Binary    bool
+	Messages  bool
+	Origin    bool
+	Sequences bool
+}
+
+// PgoutputCapabilitiesForVersion returns the pgoutput features supported by the major server
+// version serverVersion.
+func PgoutputCapabilitiesForVersion(serverVersion int) PgoutputCapabilities {
+	c := PgoutputCapabilities{ServerVersion: serverVersion}
+	switch {
+	case serverVersion >= 16:
+		c.MaxProtoVersion = 4
+	case serverVersion == 15:
+		c.MaxProtoVersion = 3
+	case serverVersion == 14:
+		c.MaxProtoVersion = 2
+	case serverVersion >= 10:
+		c.MaxProtoVersion = 1
+	}
+	c.Streaming = c.MaxProtoVersion >= 2
+	c.ParallelStreaming = c.MaxProtoVersion >= 4
+	c.TwoPhase = c.MaxProtoVersion >= 3
+	c.Binary = serverVersion >= 14
+	c.Messages = serverVersion >= 14
+	c.Origin = serverVersion >= 16
+	return c
+}
+
+// DetectPgoutputCapabilities returns the pgoutput features supported by the server of conn. The
+// server version is taken from the server_version parameter reported when connecting or, if it
+// is missing, queried with SHOW server_version_num.
+func DetectPgoutputCapabilities(ctx context.Context, conn *pgconn.PgConn) (PgoutputCapabilities, error) {
+	serverVersion, err := parseServerMajorVersion(conn.ParameterStatus("server_version"))
+	if err != nil {
+		rows, qerr := queryRows(ctx, conn, "SHOW server_version_num", 1)
+		if qerr != nil {
+			return PgoutputCapabilities{}, fmt.Errorf("failed to query server version: %w", qerr)
+		}
+		if len(rows) != 1 {
+			return PgoutputCapabilities{}, fmt.Errorf("expected 1 server_version_num row, got %d", len(rows))
+		}
+		num, err := strconv.Atoi(string(rows[0][0]))
+		if err != nil {
+			return PgoutputCapabilities{}, fmt.Errorf("bad server_version_num '%s'", rows[0][0])
+		}
+		// The number is 160002 for 16.2, and 90624 for 9.6.24 whose major version 9.6 is reported
+		// as 9.
+		serverVersion = num / 10000
+	}
+	return PgoutputCapabilitiesForVersion(serverVersion), nil
+}
+
+// Negotiate returns o restricted to the features supported: the protocol version is
+// MaxProtoVersion if o.ProtoVersion is 0 or greater, and the options that are not supported are
+// reset, with PgoutputStreamingParallel falling back to PgoutputStreamingOn. Callers can compare
+// the result to o to report the features they requested but do not get, such as an Origin that
+// is needed to avoid replication loops.
+func (c PgoutputCapabilities) Negotiate(o PgoutputOptions) PgoutputOptions {
+	if o.ProtoVersion == 0 || o.ProtoVersion > c.MaxProtoVersion {
+		o.ProtoVersion = c.MaxProtoVersion
+	}
+	if o.Streaming == PgoutputStreamingParallel && (!c.ParallelStreaming || o.ProtoVersion < 4) {
+		o.Streaming = PgoutputStreamingOn
+	}
+	if o.Streaming == PgoutputStreamingOn && (!c.Streaming || o.ProtoVersion < 2) {
+		o.Streaming = PgoutputStreamingOff
+	}
+	if o.TwoPhase && (!c.TwoPhase || o.ProtoVersion < 3) {
+		o.TwoPhase = false
+	}
+	if !c.Binary {
+		o.Binary = false
+	}
+	if !c.Messages {
+		o.Messages = false
+	}
+	if !c.Origin {
+		o.Origin = ""
+	}
+	if !c.Sequences {
+		o.Sequences = false
+	}
+	return o
+}
+
+var simpleIdentifier = regexp.MustCompile(`^[a-z_][a-z0-9_$]*$`)
+
+// quotePublicationName quotes a publication name for the publication_names list, which the
+// server splits and downcases like unquoted SQL identifiers.
+func quotePublicationName(name string) string {
+	if simpleIdentifier.MatchString(name) {
+		return name
+	}
+	return quoteIdentifier(name)
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
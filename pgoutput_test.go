@@ -0,0 +1,143 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/pglogrepltest"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPgoutputOptionsPluginArgs(t *testing.T) {
+	args, err := pglogrepl.PgoutputOptions{PublicationNames: []string{"pub"}}.PluginArgs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"proto_version '1'", "publication_names 'pub'"}, args)
+
+	args, err = pglogrepl.PgoutputOptions{
+		ProtoVersion:     4,
+		PublicationNames: []string{"pub", "My Pub", `it's "quoted"`},
+		Binary:           true,
+		Messages:         true,
+		Streaming:        pglogrepl.PgoutputStreamingParallel,
+		TwoPhase:         true,
+		Origin:           "none",
+		Sequences:        true,
+	}.PluginArgs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"proto_version '4'",
+		`publication_names 'pub,"My Pub","it''s ""quoted"""'`,
+		"binary 'true'",
+		"messages 'true'",
+		"streaming 'parallel'",
+		"two_phase 'true'",
+		"origin 'none'",
+		"sequences 'true'",
+	}, args)
+
+	_, err = pglogrepl.PgoutputOptions{}.PluginArgs()
+	assert.Error(t, err)
+}
+
+func TestStartReplicationPgoutput(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	queries := ws.serveStartReplication()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, slotName, 0x100, pglogrepl.ReplicationStreamOptions{
+		StartReplicationOptions: pglogrepl.StartReplicationOptions{
+			Pgoutput:   &pglogrepl.PgoutputOptions{ProtoVersion: 2, PublicationNames: []string{"pub"}, Streaming: pglogrepl.PgoutputStreamingOn},
+			PluginArgs: []string{"binary 'false'"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "START_REPLICATION SLOT "+slotName+" LOGICAL 0/100 (proto_version '2', publication_names 'pub', streaming 'on', binary 'false')", <-queries)
+
+	// The WAL data is decoded with the protocol version of the pgoutput options.
+	ws.sendXLogData(0x200, streamStartMessageData(42))
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.IsType(t, &pglogrepl.StreamStartMessageV2{}, rm.Message)
+}
+
+func TestPgoutputCapabilitiesForVersion(t *testing.T) {
+	assert.Equal(t, pglogrepl.PgoutputCapabilities{ServerVersion: 9}, pglogrepl.PgoutputCapabilitiesForVersion(9))
+	assert.Equal(t, pglogrepl.PgoutputCapabilities{ServerVersion: 13, MaxProtoVersion: 1}, pglogrepl.PgoutputCapabilitiesForVersion(13))
+	assert.Equal(t, pglogrepl.PgoutputCapabilities{
+		ServerVersion:   14,
+		MaxProtoVersion: 2,
+		Streaming:       true,
+		Binary:          true,
+		Messages:        true,
+	}, pglogrepl.PgoutputCapabilitiesForVersion(14))
+	assert.Equal(t, 3, pglogrepl.PgoutputCapabilitiesForVersion(15).MaxProtoVersion)
+	assert.True(t, pglogrepl.PgoutputCapabilitiesForVersion(15).TwoPhase)
+	assert.Equal(t, pglogrepl.PgoutputCapabilities{
+		ServerVersion:     17,
+		MaxProtoVersion:   4,
+		Streaming:         true,
+		ParallelStreaming: true,
+		TwoPhase:          true,
+		Binary:            true,
+		Messages:          true,
+		Origin:            true,
+	}, pglogrepl.PgoutputCapabilitiesForVersion(17))
+}
+
+func TestPgoutputCapabilitiesNegotiate(t *testing.T) {
+	want := pglogrepl.PgoutputOptions{
+		PublicationNames: []string{"pub"},
+		Binary:           true,
+		Messages:         true,
+		Streaming:        pglogrepl.PgoutputStreamingParallel,
+		TwoPhase:         true,
+		Origin:           "none",
+	}
+
+	assert.Equal(t, pglogrepl.PgoutputOptions{PublicationNames: []string{"pub"}, ProtoVersion: 1},
+		pglogrepl.PgoutputCapabilitiesForVersion(13).Negotiate(want))
+
+	got := pglogrepl.PgoutputCapabilitiesForVersion(14).Negotiate(want)
+	assert.Equal(t, 2, got.ProtoVersion)
+	assert.Equal(t, pglogrepl.PgoutputStreamingOn, got.Streaming)
+	assert.False(t, got.TwoPhase)
+	assert.True(t, got.Binary)
+	assert.Empty(t, got.Origin)
+
+	got = pglogrepl.PgoutputCapabilitiesForVersion(16).Negotiate(want)
+	want.ProtoVersion = 4
+	assert.Equal(t, want, got)
+
+	// An explicit protocol version limits the features.
+	want.ProtoVersion = 2
+	got = pglogrepl.PgoutputCapabilitiesForVersion(16).Negotiate(want)
+	assert.Equal(t, 2, got.ProtoVersion)
+	assert.Equal(t, pglogrepl.PgoutputStreamingOn, got.Streaming)
+	assert.False(t, got.TwoPhase)
+
+	// No released server replicates sequences.
+	assert.False(t, pglogrepl.PgoutputCapabilitiesForVersion(17).Negotiate(pglogrepl.PgoutputOptions{Sequences: true}).Sequences)
+}
+
+func TestDetectPgoutputCapabilities(t *testing.T) {
+	for version, protoVersion := range map[string]int{"13.4": 1, "15.4 (Debian 15.4-1.pgdg120+1)": 3, "17beta1": 4} {
+		srv, err := pglogrepltest.NewServer(pglogrepltest.ServerOptions{ServerVersion: version})
+		require.NoError(t, err)
+		defer srv.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := pgconn.Connect(ctx, srv.ConnString())
+		require.NoError(t, err)
+		defer conn.Close(ctx)
+
+		c, err := pglogrepl.DetectPgoutputCapabilities(ctx, conn)
+		require.NoError(t, err)
+		assert.Equal(t, protoVersion, c.MaxProtoVersion, version)
+	}
+}
@@ -0,0 +1,288 @@
+package pglogrepl
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// PipelineOptions configures a Pipeline.
+type PipelineOptions struct {
+	// QueueSize is the number of received messages the pipeline buffers. If it is 0 then 128 is
+	// used.
+	QueueSize int
+	// QueueBytes, if positive, also limits the WAL data buffered to about QueueBytes bytes: the
+	// receiver waits once it is reached, so a single larger message is still delivered.
+	QueueBytes int
+
+	// SpillDir, if set, makes the receiver spill the messages received while the queue is full to
+	// segment files in SpillDir instead of waiting. This only keeps the receive loop and the
+	// keepalives running during a slow consumer; the server still retains the WAL that is not
+	// confirmed. Positions must not be confirmed beyond what the consumer applied, as the
+	// segments do not survive a restart: they are named after the WALStart of their first
+	// message, removed once they are consumed, and Close removes the remaining ones. The
+	// messages read back are decoded again, without the Decoder of the stream's LargeColumnSize
+	// and outside of the pool of its PoolBuffers.
+	SpillDir string
+	// SpillSegmentSize is the size at which a new segment file is started. If it is 0 then 16 MiB
+	// is used.
+	SpillSegmentSize int64
+	// SpillLimit, if positive, is the size of the segment files above which the receiver waits
+	// as if SpillDir were not set.
+	SpillLimit int64
+	// SpillCodec, if set, compresses the WAL data of the spilled messages. The sizes of
+	// SpillSegmentSize, SpillLimit and the spill depth are those of the compressed data.
+	SpillCodec Codec
+
+	// Metrics, if set, receives the measurements of the pipeline.
+	Metrics PipelineMetrics
+}
+
+const defaultPipelineQueueSize = 128
+
+// PipelineMetrics receives the measurements of a Pipeline configured with it. The methods are
+// called synchronously by the receiver and by Next and must be fast.
+type PipelineMetrics interface {
+	// QueueDepth is called whenever a message is queued in memory or taken from the memory queue
+	// with the number of messages queued and the size of their WAL data.
+	QueueDepth(messages, walDataSize int)
+	// SpillDepth is called whenever a message is spilled or read back with the number of
+	// messages spilled and the size of the segment files holding them.
+	SpillDepth(messages int, size int64)
+}
+
+// NopPipelineMetrics is a PipelineMetrics ignoring all measurements. Implementations can embed it
+// to only implement the methods they need.
+type NopPipelineMetrics struct{}
+
+func (NopPipelineMetrics) QueueDepth(int, int)   {}
+func (NopPipelineMetrics) SpillDepth(int, int64) {}
+
+// Pipeline receives the messages of a ReplicationStream in its own goroutine into a bounded queue
+// the application consumes with Next, so that receiving and processing overlap.
+//
+// When the queue is full, or the pipeline is paused, the receiver stops reading from the
+// connection, which makes TCP apply backpressure to the server instead of buffering WAL in
+// memory. The standby status updates are still sent while it waits so that the server does not
+// time out the connection. With a SpillDir the messages are spilled to disk instead, up to the
+// SpillLimit. The stream must not be used directly while the pipeline runs, except
+// for the methods setting and returning its positions.
+type Pipeline struct {
+	stream  *ReplicationStream
+	options PipelineOptions
+	cancel  context.CancelFunc
+	done    chan struct{}
+	// ready is signaled when a message is queued or the receiver fails, room when a message is
+	// taken from the queue or the pipeline resumed.
+	ready chan struct{}
+	room  chan struct{}
+
+	mu     sync.Mutex
+	queue  []*ReplicationMessage
+	bytes  int
+	paused bool
+	err    error
+	// spill holds the messages following the queue when the options have a SpillDir.
+	spill *walSpill
+}
+
+// StartPipeline starts receiving from stream until ctx is canceled, Close is called or Next of
+// stream fails.
+func StartPipeline(ctx context.Context, stream *ReplicationStream, options PipelineOptions) *Pipeline {
+	if options.QueueSize <= 0 {
+		options.QueueSize = defaultPipelineQueueSize
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Pipeline{
+		stream:  stream,
+		options: options,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		ready:   make(chan struct{}, 1),
+		room:    make(chan struct{}, 1),
+	}
+	if options.SpillDir != "" {
+		p.spill = newWALSpill(options.SpillDir, options.SpillSegmentSize, stream.options.ProtoVersion, options.SpillCodec)
+	}
+	go p.receive(ctx)
+	return p
+}
+
+// Next returns the next message received. Once the queued messages are consumed it returns the
+// error that stopped the receiver, such as io.EOF if the server ended replication.
+func (p *Pipeline) Next(ctx context.Context) (*ReplicationMessage, error) {
+	for {
+		p.mu.Lock()
+		if len(p.queue) > 0 {
+			msg := p.queue[0]
+			p.queue[0] = nil
+			p.queue = p.queue[1:]
+			p.bytes -= len(msg.WALData)
+			p.queueDepth()
+			p.mu.Unlock()
+			signal(p.room)
+			return msg, nil
+		}
+		if p.spill != nil && p.spill.records > 0 {
+			msg, err := p.spill.next()
+			p.spillDepth()
+			p.mu.Unlock()
+			signal(p.room)
+			return msg, err
+		}
+		err := p.err
+		p.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-p.ready:
+		}
+	}
+}
+
+// Len returns the number of messages queued, including the messages spilled.
+func (p *Pipeline) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.spill != nil {
+		return len(p.queue) + p.spill.records
+	}
+	return len(p.queue)
+}
+
+// Pause makes the receiver stop reading from the connection, as when the queue is full, until
+// Resume is called. A message the receiver is already waiting for is still queued, and the
+// messages queued are still returned by Next.
+func (p *Pipeline) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+}
+
+// Resume resumes receiving after Pause.
+func (p *Pipeline) Resume() {
+	p.mu.Lock()
+	p.paused = false
+	p.mu.Unlock()
+	signal(p.room)
+}
+
+// Close stops the receiver and waits for it to return. The messages still queued are released
+// and the spill files removed. The stream can be used directly again afterwards, for example to
+// Stop it.
+func (p *Pipeline) Close() error {
+	p.cancel()
+	<-p.done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, msg := range p.queue {
+		msg.Release()
+	}
+	p.queue = nil
+	p.bytes = 0
+	if p.spill != nil {
+		return p.spill.close()
+	}
+	return nil
+}
+
+func (p *Pipeline) receive(ctx context.Context) {
+	defer close(p.done)
+	for {
+		err := p.waitForRoom(ctx)
+		var msg *ReplicationMessage
+		if err == nil {
+			msg, err = p.stream.Next(ctx)
+		}
+
+		p.mu.Lock()
+		if err == nil {
+			err = p.push(msg)
+		}
+		if err != nil {
+			p.err = err
+			p.mu.Unlock()
+			signal(p.ready)
+			return
+		}
+		p.mu.Unlock()
+		signal(p.ready)
+	}
+}
+
+// push queues msg, spilling it if the queue is full or already spilling. p.mu must be held.
+func (p *Pipeline) push(msg *ReplicationMessage) error {
+	if p.spill != nil && (p.spill.records > 0 || p.queueFull()) {
+		err := p.spill.write(msg, p.stream.messageInStream(msg.Message))
+		msg.Release()
+		p.spillDepth()
+		return err
+	}
+	p.queue = append(p.queue, msg)
+	p.bytes += len(msg.WALData)
+	p.queueDepth()
+	return nil
+}
+
+// queueFull reports whether the memory queue is full. p.mu must be held.
+func (p *Pipeline) queueFull() bool {
+	return len(p.queue) >= p.options.QueueSize ||
+		(p.options.QueueBytes > 0 && len(p.queue) > 0 && p.bytes >= p.options.QueueBytes)
+}
+
+// waitForRoom waits until the pipeline is not paused and its queue not full, sending the standby
+// status updates that are due meanwhile.
+func (p *Pipeline) waitForRoom(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		full := p.paused || p.queueFull()
+		if full && p.spill != nil {
+			full = p.paused || (p.options.SpillLimit > 0 && p.spill.size >= p.options.SpillLimit)
+		}
+		p.mu.Unlock()
+		if !full {
+			return nil
+		}
+
+		deadline, err := p.stream.keepAlive(ctx)
+		if err != nil {
+			return err
+		}
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-p.room:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// queueDepth reports the queue depth to the metrics. p.mu must be held.
+func (p *Pipeline) queueDepth() {
+	if p.options.Metrics != nil {
+		p.options.Metrics.QueueDepth(len(p.queue), p.bytes)
+	}
+}
+
+// spillDepth reports the spill depth to the metrics. p.mu must be held.
+func (p *Pipeline) spillDepth() {
+	if p.options.Metrics != nil {
+		p.options.Metrics.SpillDepth(p.spill.records, p.spill.size)
+	}
+}
+
+// signal wakes up the goroutine waiting on c, if any, without blocking.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
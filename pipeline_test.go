@@ -0,0 +1,286 @@
+package pglogrepl_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type queueDepthMetrics struct {
+	mu          sync.Mutex
+	depths      []int
+	spillDepths []int
+	spillSizes  []int64
+}
+
+func (m *queueDepthMetrics) QueueDepth(messages, walDataSize int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.depths = append(m.depths, messages)
+}
+
+func (m *queueDepthMetrics) SpillDepth(messages int, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spillDepths = append(m.spillDepths, messages)
+	m.spillSizes = append(m.spillSizes, size)
+}
+
+func waitQueueLen(t *testing.T, p *pglogrepl.Pipeline, n int) {
+	require.Eventually(t, func() bool { return p.Len() == n }, 5*time.Second, time.Millisecond)
+}
+
+func TestPipeline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	metrics := &queueDepthMetrics{}
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{StandbyMessageTimeout: 50 * time.Millisecond})
+	p := pglogrepl.StartPipeline(ctx, stream, pglogrepl.PipelineOptions{QueueSize: 1, Metrics: metrics})
+	defer p.Close()
+
+	ws.sendXLogData(0x200, []byte("first"))
+	ws.sendXLogData(0x300, []byte("second"))
+	ws.send(&pgproto3.CopyDone{})
+	waitQueueLen(t, p, 1)
+
+	// The receiver keeps the connection alive while the queue is full and leaves the following
+	// messages unread.
+	ws.receiveStandbyStatusUpdate()
+	ssu := ws.receiveStandbyStatusUpdate()
+	assert.Equal(t, pglogrepl.LSN(0x200), ssu.WALFlushPosition)
+	assert.Equal(t, 1, p.Len())
+
+	msg, err := p.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), msg.WALData)
+	msg, err = p.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), msg.WALData)
+	_, err = p.Next(ctx)
+	assert.ErrorIs(t, err, io.EOF)
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	assert.Equal(t, []int{1, 0, 1, 0}, metrics.depths)
+}
+
+func TestPipelinePause(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{})
+	p := pglogrepl.StartPipeline(ctx, stream, pglogrepl.PipelineOptions{})
+	defer p.Close()
+
+	p.Pause()
+	// A message the receiver is already waiting for is still queued.
+	ws.sendXLogData(0x200, []byte("first"))
+	ws.sendXLogData(0x300, []byte("second"))
+	time.Sleep(50 * time.Millisecond)
+	assert.LessOrEqual(t, p.Len(), 1)
+
+	p.Resume()
+	waitQueueLen(t, p, 2)
+	for _, want := range []string{"first", "second"} {
+		msg, err := p.Next(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(msg.WALData))
+	}
+}
+
+func TestPipelineQueueBytes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{})
+	p := pglogrepl.StartPipeline(ctx, stream, pglogrepl.PipelineOptions{QueueBytes: 8})
+	defer p.Close()
+
+	ws.sendXLogData(0x200, []byte("1234"))
+	ws.sendXLogData(0x300, []byte("567890"))
+	ws.sendXLogData(0x400, []byte("unread"))
+	waitQueueLen(t, p, 2)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, p.Len())
+
+	_, err := p.Next(ctx)
+	require.NoError(t, err)
+	_, err = p.Next(ctx)
+	require.NoError(t, err)
+	msg, err := p.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "unread", string(msg.WALData))
+}
+
+func TestPipelineClose(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, _ := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{})
+	p := pglogrepl.StartPipeline(ctx, stream, pglogrepl.PipelineOptions{})
+	p.Close()
+
+	_, err := p.Next(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestPipelineSpill(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	metrics := &queueDepthMetrics{}
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{ProtoVersion: 1})
+	p := pglogrepl.StartPipeline(ctx, stream, pglogrepl.PipelineOptions{
+		QueueSize:        1,
+		SpillDir:         dir,
+		SpillSegmentSize: 64,
+		Metrics:          metrics,
+	})
+	defer p.Close()
+
+	ws.sendXLogData(0x200, beginMessageData(0x500, 7))
+	ws.sendXLogData(0x300, insertMessageData(t, "a"))
+	ws.sendXLogData(0x400, insertMessageData(t, "b"))
+	ws.sendXLogData(0x500, commitMessageData(0x500, 0x540))
+	waitQueueLen(t, p, 4)
+
+	// The messages following the full queue are spilled to segments of about 64 bytes.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "0000000000000300-1.spill", entries[0].Name())
+	assert.Len(t, entries, 2)
+
+	var types []pglogrepl.MessageType
+	for i := 0; i < 4; i++ {
+		msg, err := p.Next(ctx)
+		require.NoError(t, err)
+		types = append(types, msg.Message.Type())
+	}
+	assert.Equal(t, []pglogrepl.MessageType{pglogrepl.MessageTypeBegin, pglogrepl.MessageTypeInsert, pglogrepl.MessageTypeInsert, pglogrepl.MessageTypeCommit}, types)
+
+	// The segments are removed once consumed, and the queue is used again.
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	ws.sendXLogData(0x600, beginMessageData(0x700, 8))
+	msg, err := p.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x600), msg.WALStart)
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	assert.Equal(t, []int{1, 0, 1, 0}, metrics.depths)
+	assert.Equal(t, []int{1, 2, 3, 2, 1, 0}, metrics.spillDepths)
+}
+
+func TestPipelineSpillCodec(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	codec, err := pglogrepl.GzipCodec(gzip.DefaultCompression)
+	require.NoError(t, err)
+	metrics := &queueDepthMetrics{}
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{})
+	p := pglogrepl.StartPipeline(ctx, stream, pglogrepl.PipelineOptions{QueueSize: 1, SpillDir: t.TempDir(), SpillCodec: codec, Metrics: metrics})
+	defer p.Close()
+
+	large := bytes.Repeat([]byte("compressible"), 1000)
+	ws.sendXLogData(0x200, []byte("queued"))
+	ws.sendXLogData(0x300, large)
+	ws.sendXLogData(0x400, []byte("short"))
+	waitQueueLen(t, p, 3)
+
+	// The large message is stored compressed, the short one as is.
+	metrics.mu.Lock()
+	spilled := metrics.spillSizes[len(metrics.spillSizes)-1]
+	metrics.mu.Unlock()
+	assert.Less(t, spilled, int64(len(large)))
+
+	for _, want := range [][]byte{[]byte("queued"), large, []byte("short")} {
+		msg, err := p.Next(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, msg.WALData)
+	}
+}
+
+// failingCodec fails to decompress once fail is set.
+type failingCodec struct {
+	pglogrepl.Codec
+	fail bool
+}
+
+func (c *failingCodec) Decompress(dst, src []byte) ([]byte, error) {
+	if c.fail {
+		c.fail = false
+		return nil, errors.New("decompression failed")
+	}
+	return c.Codec.Decompress(dst, src)
+}
+
+func TestPipelineSpillReadError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gzipCodec, err := pglogrepl.GzipCodec(gzip.DefaultCompression)
+	require.NoError(t, err)
+	codec := &failingCodec{Codec: gzipCodec}
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{})
+	p := pglogrepl.StartPipeline(ctx, stream, pglogrepl.PipelineOptions{QueueSize: 1, SpillDir: t.TempDir(), SpillCodec: codec})
+	defer p.Close()
+
+	large := bytes.Repeat([]byte("compressible"), 1000)
+	ws.sendXLogData(0x200, []byte("queued"))
+	ws.sendXLogData(0x300, large)
+	ws.sendXLogData(0x400, []byte("short"))
+	waitQueueLen(t, p, 3)
+
+	msg, err := p.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("queued"), msg.WALData)
+	// Next is called by the consumer goroutine only, so setting fail does not race.
+	codec.fail = true
+	_, err = p.Next(ctx)
+	assert.Error(t, err)
+
+	// The message that failed is read again from its start.
+	for _, want := range [][]byte{large, []byte("short")} {
+		msg, err := p.Next(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, msg.WALData)
+	}
+}
+
+func TestPipelineSpillLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{})
+	p := pglogrepl.StartPipeline(ctx, stream, pglogrepl.PipelineOptions{QueueSize: 1, SpillDir: dir, SpillLimit: 1})
+
+	ws.sendXLogData(0x200, []byte("queued"))
+	ws.sendXLogData(0x300, []byte("spilled"))
+	ws.sendXLogData(0x400, []byte("unread"))
+	waitQueueLen(t, p, 2)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, p.Len())
+
+	// Close removes the spill files.
+	require.NoError(t, p.Close())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
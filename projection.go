@@ -0,0 +1,46 @@
+package pglogrepl
+
+// Projection is a per-table allowlist of the columns decoded by DecodeProjectedTuple and by a
+// TupleDecoder created with NewProjectedTupleDecoder. The other columns of a registered table are
+// neither converted nor assigned, which saves most of the decoding cost of wide tables of which
+// only a few columns are used. The columns of tables that are not registered are all decoded.
+//
+// A Projection must be set up with Register before it is used for decoding, after which it is safe
+// for concurrent use.
+type Projection struct {
+	tables map[string]map[string]struct{}
+}
+
+// NewProjection returns a Projection without registered tables.
+func NewProjection() *Projection {
+	return &Projection{tables: map[string]map[string]struct{}{}}
+}
+
+// Register sets the columns decoded for the table name in namespace, replacing the columns
+// registered before.
+func (p *Projection) Register(namespace, name string, columns ...string) {
+	allowed := make(map[string]struct{}, len(columns))
+	for _, column := range columns {
+		allowed[column] = struct{}{}
+	}
+	p.tables[relationName(namespace, name)] = allowed
+}
+
+// columns returns the allowlist of rel, or nil if all its columns are decoded. A nil Projection
+// decodes all columns.
+func (p *Projection) columns(rel *RelationMessage) map[string]struct{} {
+	if p == nil {
+		return nil
+	}
+	return p.tables[relationName(rel.Namespace, rel.RelationName)]
+}
+
+// Includes reports whether column of rel is decoded.
+func (p *Projection) Includes(rel *RelationMessage, column string) bool {
+	allowed := p.columns(rel)
+	if allowed == nil {
+		return true
+	}
+	_, ok := allowed[column]
+	return ok
+}
@@ -0,0 +1,318 @@
+// Package protobuf encodes change events in protocol buffers, following the schema of
+// changeevent.proto, so that they can be consumed by services in any language with generated
+// bindings. The package does not depend on a protobuf library.
+//
+// Column values are encoded by Go type: nil as null, booleans, integers and floating point numbers
+// as bool_value, int_value and double_value, strings and byte slices as string_value and
+// bytes_value and times as timestamp_micros. wal2json numbers are encoded as int_value if they
+// are integers and as string_value otherwise, so that numerics keep their precision. Values
+// implementing driver.Valuer, such as pgtype.Numeric, are encoded as the value they return, UUIDs
+// as their string representation and any other value as its JSON encoding in string_value.
+package protobuf
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/internal/protowire"
+)
+
+// Field numbers of ChangeEvent.
+const (
+	eventOperation        = 1
+	eventSchema           = 2
+	eventTable            = 3
+	eventKey              = 4
+	eventBefore           = 5
+	eventAfter            = 6
+	eventLSN              = 7
+	eventCommitTimeMicros = 8
+	eventXid              = 9
+)
+
+// Field numbers of Value.
+const (
+	valueNull            = 1
+	valueBool            = 2
+	valueInt             = 3
+	valueDouble          = 4
+	valueString          = 5
+	valueBytes           = 6
+	valueTimestampMicros = 7
+)
+
+// Marshal returns the protobuf encoding of event.
+func Marshal(event *pglogrepl.ChangeEvent) ([]byte, error) {
+	var b []byte
+	b = appendString(b, eventOperation, string(event.Operation))
+	b = appendString(b, eventSchema, event.Schema)
+	b = appendString(b, eventTable, event.Table)
+	for _, field := range []struct {
+		num int
+		row map[string]interface{}
+	}{{eventKey, event.Key}, {eventBefore, event.Before}, {eventAfter, event.After}} {
+		var err error
+		if b, err = appendRow(b, field.num, field.row); err != nil {
+			return nil, err
+		}
+	}
+	if event.LSN != 0 {
+		b = protowire.AppendTag(b, eventLSN, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(event.LSN))
+	}
+	if !event.CommitTime.IsZero() {
+		b = protowire.AppendTag(b, eventCommitTimeMicros, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(event.CommitTime.UnixMicro()))
+	}
+	if event.Xid != 0 {
+		b = protowire.AppendTag(b, eventXid, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(event.Xid))
+	}
+	return b, nil
+}
+
+func appendString(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendRow appends row as the entries of the map field num, sorted by column name.
+func appendRow(b []byte, num int, row map[string]interface{}) ([]byte, error) {
+	names := make([]string, 0, len(row))
+	for name := range row {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var entry, value []byte
+	for _, name := range names {
+		var err error
+		if value, err = appendValue(value[:0], row[name]); err != nil {
+			return nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		entry = protowire.AppendTag(entry[:0], 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, name)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, value)
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b, nil
+}
+
+// appendValue appends the fields of the Value message encoding v.
+func appendValue(b []byte, v interface{}) ([]byte, error) {
+	appendInt := func(n int64) []byte {
+		b = protowire.AppendTag(b, valueInt, protowire.VarintType)
+		return protowire.AppendVarint(b, uint64(n))
+	}
+	appendText := func(s string) []byte {
+		b = protowire.AppendTag(b, valueString, protowire.BytesType)
+		return protowire.AppendString(b, s)
+	}
+
+	switch v := v.(type) {
+	case nil:
+		b = protowire.AppendTag(b, valueNull, protowire.VarintType)
+		return protowire.AppendVarint(b, 1), nil
+	case bool:
+		b = protowire.AppendTag(b, valueBool, protowire.VarintType)
+		if v {
+			return protowire.AppendVarint(b, 1), nil
+		}
+		return protowire.AppendVarint(b, 0), nil
+	case int:
+		return appendInt(int64(v)), nil
+	case int8:
+		return appendInt(int64(v)), nil
+	case int16:
+		return appendInt(int64(v)), nil
+	case int32:
+		return appendInt(int64(v)), nil
+	case int64:
+		return appendInt(v), nil
+	case uint8:
+		return appendInt(int64(v)), nil
+	case uint16:
+		return appendInt(int64(v)), nil
+	case uint32:
+		return appendInt(int64(v)), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return appendText(fmt.Sprint(v)), nil
+		}
+		return appendInt(int64(v)), nil
+	case float32:
+		b = protowire.AppendTag(b, valueDouble, protowire.Fixed64Type)
+		return protowire.AppendDouble(b, float64(v)), nil
+	case float64:
+		b = protowire.AppendTag(b, valueDouble, protowire.Fixed64Type)
+		return protowire.AppendDouble(b, v), nil
+	case string:
+		return appendText(v), nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return appendInt(n), nil
+		}
+		return appendText(string(v)), nil
+	case []byte:
+		b = protowire.AppendTag(b, valueBytes, protowire.BytesType)
+		return protowire.AppendBytes(b, v), nil
+	case time.Time:
+		b = protowire.AppendTag(b, valueTimestampMicros, protowire.VarintType)
+		return protowire.AppendVarint(b, uint64(v.UnixMicro())), nil
+	case [16]byte:
+		return appendText(fmt.Sprintf("%x-%x-%x-%x-%x", v[0:4], v[4:6], v[6:8], v[8:10], v[10:16])), nil
+	case driver.Valuer:
+		value, err := v.Value()
+		if err != nil {
+			return nil, err
+		}
+		return appendValue(b, value)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return appendText(string(data)), nil
+}
+
+// Unmarshal decodes the protobuf encoding of a change event. The column values are nil, bool,
+// int64, float64, string, []byte or time.Time values.
+func Unmarshal(data []byte) (*pglogrepl.ChangeEvent, error) {
+	event := &pglogrepl.ChangeEvent{}
+	r := protowire.NewReader(data)
+	for !r.Done() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case num == eventOperation && typ == protowire.BytesType:
+			var s []byte
+			s, err = r.Bytes()
+			event.Operation = pglogrepl.Operation(s)
+		case num == eventSchema && typ == protowire.BytesType:
+			var s []byte
+			s, err = r.Bytes()
+			event.Schema = string(s)
+		case num == eventTable && typ == protowire.BytesType:
+			var s []byte
+			s, err = r.Bytes()
+			event.Table = string(s)
+		case num == eventKey && typ == protowire.BytesType:
+			event.Key, err = readEntry(r, event.Key)
+		case num == eventBefore && typ == protowire.BytesType:
+			event.Before, err = readEntry(r, event.Before)
+		case num == eventAfter && typ == protowire.BytesType:
+			event.After, err = readEntry(r, event.After)
+		case num == eventLSN && typ == protowire.VarintType:
+			var v uint64
+			v, err = r.Varint()
+			event.LSN = pglogrepl.LSN(v)
+		case num == eventCommitTimeMicros && typ == protowire.VarintType:
+			var v uint64
+			v, err = r.Varint()
+			event.CommitTime = time.UnixMicro(int64(v)).UTC()
+		case num == eventXid && typ == protowire.VarintType:
+			var v uint64
+			v, err = r.Varint()
+			event.Xid = uint32(v)
+		default:
+			err = r.Skip(typ)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode change event: %w", err)
+		}
+	}
+	return event, nil
+}
+
+// readEntry reads a map entry into row, which is allocated if it is nil.
+func readEntry(r *protowire.Reader, row map[string]interface{}) (map[string]interface{}, error) {
+	data, err := r.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		row = map[string]interface{}{}
+	}
+	var name string
+	var value interface{}
+	er := protowire.NewReader(data)
+	for !er.Done() {
+		num, typ, err := er.Next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			var s []byte
+			s, err = er.Bytes()
+			name = string(s)
+		case num == 2 && typ == protowire.BytesType:
+			var v []byte
+			if v, err = er.Bytes(); err == nil {
+				value, err = readValue(v)
+			}
+		default:
+			err = er.Skip(typ)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	row[name] = value
+	return row, nil
+}
+
+func readValue(data []byte) (interface{}, error) {
+	var value interface{}
+	r := protowire.NewReader(data)
+	for !r.Done() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		var v uint64
+		switch {
+		case num == valueNull && typ == protowire.VarintType:
+			_, err = r.Varint()
+			value = nil
+		case num == valueBool && typ == protowire.VarintType:
+			v, err = r.Varint()
+			value = v != 0
+		case num == valueInt && typ == protowire.VarintType:
+			v, err = r.Varint()
+			value = int64(v)
+		case num == valueDouble && typ == protowire.Fixed64Type:
+			v, err = r.Fixed64()
+			value = math.Float64frombits(v)
+		case num == valueString && typ == protowire.BytesType:
+			var s []byte
+			s, err = r.Bytes()
+			value = string(s)
+		case num == valueBytes && typ == protowire.BytesType:
+			var s []byte
+			s, err = r.Bytes()
+			value = append([]byte{}, s...)
+		case num == valueTimestampMicros && typ == protowire.VarintType:
+			v, err = r.Varint()
+			value = time.UnixMicro(int64(v)).UTC()
+		default:
+			err = r.Skip(typ)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,81 @@
+package protobuf_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/protobuf"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalUnmarshal(t *testing.T) {
+	commitTime := time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC)
+	var numeric pgtype.Numeric
+	require.NoError(t, numeric.Scan("12345678901234567890.5"))
+	event := &pglogrepl.ChangeEvent{
+		Operation: pglogrepl.OperationUpdate,
+		Schema:    "public",
+		Table:     "t",
+		Key:       map[string]interface{}{"id": int32(-1)},
+		Before:    map[string]interface{}{"id": int32(-1), "name": nil},
+		After: map[string]interface{}{
+			"id":     int32(-1),
+			"name":   "a",
+			"ok":     false,
+			"ratio":  float32(0.5),
+			"data":   []byte{1, 2},
+			"at":     commitTime,
+			"amount": numeric,
+			"n":      json.Number("42"),
+			"uuid":   [16]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 1, 2, 3, 4, 5, 6, 7, 8},
+			"doc":    map[string]interface{}{"k": "v"},
+		},
+		LSN:        0x16B2470,
+		CommitTime: commitTime,
+		Xid:        42,
+	}
+
+	data, err := protobuf.Marshal(event)
+	require.NoError(t, err)
+	again, err := protobuf.Marshal(event)
+	require.NoError(t, err)
+	assert.Equal(t, data, again, "the encoding is deterministic")
+
+	decoded, err := protobuf.Unmarshal(data)
+	require.NoError(t, err)
+	assert.Equal(t, &pglogrepl.ChangeEvent{
+		Operation: pglogrepl.OperationUpdate,
+		Schema:    "public",
+		Table:     "t",
+		Key:       map[string]interface{}{"id": int64(-1)},
+		Before:    map[string]interface{}{"id": int64(-1), "name": nil},
+		After: map[string]interface{}{
+			"id":     int64(-1),
+			"name":   "a",
+			"ok":     false,
+			"ratio":  0.5,
+			"data":   []byte{1, 2},
+			"at":     commitTime,
+			"amount": "12345678901234567890.5",
+			"n":      int64(42),
+			"uuid":   "12345678-9abc-def0-0102-030405060708",
+			"doc":    `{"k":"v"}`,
+		},
+		LSN:        0x16B2470,
+		CommitTime: commitTime,
+		Xid:        42,
+	}, decoded)
+
+	truncate, err := protobuf.Marshal(&pglogrepl.ChangeEvent{Operation: pglogrepl.OperationTruncate, Schema: "public", Table: "t"})
+	require.NoError(t, err)
+	decoded, err = protobuf.Unmarshal(truncate)
+	require.NoError(t, err)
+	assert.Equal(t, &pglogrepl.ChangeEvent{Operation: pglogrepl.OperationTruncate, Schema: "public", Table: "t"}, decoded)
+
+	_, err = protobuf.Unmarshal(data[:len(data)-1])
+	assert.Error(t, err)
+}
@@ -0,0 +1,290 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// PublicationTable is a table published by a publication.
+type PublicationTable struct {
+	// Name is the name of the table, optionally schema qualified such as "public.t".
+	Name string
+	// Only excludes the descendant tables of Name.
+	Only bool
+	// Columns, if set, is the column list of the table. It requires PostgreSQL 15.
+	Columns []string
+	// Where, if set, is the row filter expression of the table, such as "active AND id > 10". It
+	// is included in the command as is. It requires PostgreSQL 15.
+	Where string
+}
+
+func (t PublicationTable) sql() string {
+	var b strings.Builder
+	if t.Only {
+		b.WriteString("ONLY ")
+	}
+	b.WriteString(quoteQualifiedIdentifier(t.Name))
+	if len(t.Columns) > 0 {
+		columns := make([]string, len(t.Columns))
+		for i, column := range t.Columns {
+			columns[i] = quoteIdentifier(column)
+		}
+		fmt.Fprintf(&b, " (%s)", strings.Join(columns, ", "))
+	}
+	if t.Where != "" {
+		fmt.Fprintf(&b, " WHERE (%s)", t.Where)
+	}
+	return b.String()
+}
+
+// PublicationOptions configures the publication created by CreatePublication.
+type PublicationOptions struct {
+	// AllTables publishes all tables of the database, including the tables created later. It
+	// requires superuser privileges and excludes Tables and Schemas.
+	AllTables bool
+	// Tables lists the published tables.
+	Tables []PublicationTable
+	// Schemas lists the schemas all tables of which are published, including the tables created
+	// later. It requires PostgreSQL 15.
+	Schemas []string
+
+	// Publish lists the operations published among "insert", "update", "delete" and "truncate".
+	// If it is empty all of them are.
+	Publish []string
+	// PublishViaPartitionRoot publishes the changes of partitions as changes of their root
+	// partitioned table.
+	PublishViaPartitionRoot bool
+}
+
+func (o PublicationOptions) sql(name string) (string, error) {
+	sql := "CREATE PUBLICATION " + quoteIdentifier(name)
+	if o.AllTables {
+		if len(o.Tables) > 0 || len(o.Schemas) > 0 {
+			return "", fmt.Errorf("a publication of all tables cannot list tables or schemas")
+		}
+		sql += " FOR ALL TABLES"
+	} else if objects := publicationObjects(o.Tables, o.Schemas); objects != "" {
+		sql += " FOR " + objects
+	}
+
+	var params []string
+	if len(o.Publish) > 0 {
+		params = append(params, "publish = "+quoteLiteral(strings.Join(o.Publish, ", ")))
+	}
+	if o.PublishViaPartitionRoot {
+		params = append(params, "publish_via_partition_root = true")
+	}
+	if len(params) > 0 {
+		sql += " WITH (" + strings.Join(params, ", ") + ")"
+	}
+	return sql, nil
+}
+
+// publicationObjects formats the publication object list of tables and schemas, such as
+// TABLE a, b, TABLES IN SCHEMA s.
+func publicationObjects(tables []PublicationTable, schemas []string) string {
+	var objects []string
+	if len(tables) > 0 {
+		list := make([]string, len(tables))
+		for i, table := range tables {
+			list[i] = table.sql()
+		}
+		objects = append(objects, "TABLE "+strings.Join(list, ", "))
+	}
+	if len(schemas) > 0 {
+		list := make([]string, len(schemas))
+		for i, schema := range schemas {
+			list[i] = quoteIdentifier(schema)
+		}
+		objects = append(objects, "TABLES IN SCHEMA "+strings.Join(list, ", "))
+	}
+	return strings.Join(objects, ", ")
+}
+
+// CreatePublication executes CREATE PUBLICATION. conn is a regular connection or a logical
+// replication connection to the database of the published tables.
+func CreatePublication(ctx context.Context, conn *pgconn.PgConn, name string, options PublicationOptions) error {
+	sql, err := options.sql(name)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, sql).ReadAll(); err != nil {
+		return fmt.Errorf("failed to create publication: %w", err)
+	}
+	return nil
+}
+
+// AlterPublicationOptions lists the changes AlterPublication makes to a publication.
+type AlterPublicationOptions struct {
+	// AddTables and AddSchemas are added to the publication.
+	AddTables  []PublicationTable
+	AddSchemas []string
+	// DropTables and DropSchemas are removed from the publication. Only the Name and Only fields
+	// of the tables matter.
+	DropTables  []PublicationTable
+	DropSchemas []string
+	// SetTables and SetSchemas, if either is set, replace the tables and schemas of the
+	// publication. They exclude the lists of added and dropped tables and schemas.
+	SetTables  []PublicationTable
+	SetSchemas []string
+
+	// Publish, if set, replaces the published operations.
+	Publish []string
+	// PublishViaPartitionRoot, if set, changes whether the changes of partitions are published as
+	// changes of their root partitioned table.
+	PublishViaPartitionRoot *bool
+}
+
+func (o AlterPublicationOptions) sql(name string) ([]string, error) {
+	prefix := "ALTER PUBLICATION " + quoteIdentifier(name) + " "
+	var sqls []string
+	if len(o.SetTables) > 0 || len(o.SetSchemas) > 0 {
+		if len(o.AddTables) > 0 || len(o.AddSchemas) > 0 || len(o.DropTables) > 0 || len(o.DropSchemas) > 0 {
+			return nil, fmt.Errorf("publication tables cannot be set and added or dropped at once")
+		}
+		sqls = append(sqls, prefix+"SET "+publicationObjects(o.SetTables, o.SetSchemas))
+	}
+	if objects := publicationObjects(o.AddTables, o.AddSchemas); objects != "" {
+		sqls = append(sqls, prefix+"ADD "+objects)
+	}
+	dropTables := make([]PublicationTable, len(o.DropTables))
+	for i, table := range o.DropTables {
+		dropTables[i] = PublicationTable{Name: table.Name, Only: table.Only}
+	}
+	if objects := publicationObjects(dropTables, o.DropSchemas); objects != "" {
+		sqls = append(sqls, prefix+"DROP "+objects)
+	}
+
+	var params []string
+	if len(o.Publish) > 0 {
+		params = append(params, "publish = "+quoteLiteral(strings.Join(o.Publish, ", ")))
+	}
+	if o.PublishViaPartitionRoot != nil {
+		params = append(params, fmt.Sprintf("publish_via_partition_root = %t", *o.PublishViaPartitionRoot))
+	}
+	if len(params) > 0 {
+		sqls = append(sqls, prefix+"SET ("+strings.Join(params, ", ")+")")
+	}
+	return sqls, nil
+}
+
+// AlterPublication executes the ALTER PUBLICATION commands making the changes of options, one
+// command per kind of change in the order of the fields of AlterPublicationOptions. The commands
+// are sent as a single query, which PostgreSQL executes in an implicit transaction, so either
+// all changes are made or none.
+func AlterPublication(ctx context.Context, conn *pgconn.PgConn, name string, options AlterPublicationOptions) error {
+	sqls, err := options.sql(name)
+	if err != nil || len(sqls) == 0 {
+		return err
+	}
+	if _, err := conn.Exec(ctx, strings.Join(sqls, "; ")).ReadAll(); err != nil {
+		return fmt.Errorf("failed to alter publication: %w", err)
+	}
+	return nil
+}
+
+// DropPublicationOptions configures DropPublication.
+type DropPublicationOptions struct {
+	// IfExists does not fail if the publication does not exist.
+	IfExists bool
+}
+
+// DropPublication executes DROP PUBLICATION.
+func DropPublication(ctx context.Context, conn *pgconn.PgConn, name string, options DropPublicationOptions) error {
+	sql := "DROP PUBLICATION "
+	if options.IfExists {
+		sql += "IF EXISTS "
+	}
+	sql += quoteIdentifier(name)
+	if _, err := conn.Exec(ctx, sql).ReadAll(); err != nil {
+		return fmt.Errorf("failed to drop publication: %w", err)
+	}
+	return nil
+}
+
+// PublicationDiff is the difference between the tables of a publication and a desired set of
+// tables. Tables are schema qualified names such as "public.t".
+type PublicationDiff struct {
+	// Add lists the desired tables the publication does not publish.
+	Add []string
+	// Drop lists the tables the publication publishes that are not desired.
+	Drop []string
+}
+
+// Empty reports whether the publication publishes exactly the desired tables.
+func (d PublicationDiff) Empty() bool {
+	return len(d.Add) == 0 && len(d.Drop) == 0
+}
+
+// DiffPublication compares the tables the publication name publishes, as listed by
+// pg_publication_tables, with tables. Names in tables without a schema are in the public schema.
+// Names are compared as is, so they must be spelt as PostgreSQL stores them: unquoted
+// identifiers in lower case.
+func DiffPublication(ctx context.Context, conn *pgconn.PgConn, name string, tables []string) (PublicationDiff, error) {
+	var diff PublicationDiff
+	sql := "SELECT schemaname, tablename FROM pg_publication_tables WHERE pubname = " + quoteLiteral(name)
+	results, err := conn.Exec(ctx, sql).ReadAll()
+	if err != nil {
+		return diff, fmt.Errorf("failed to read publication tables: %w", err)
+	}
+	if len(results) != 1 {
+		return diff, fmt.Errorf("expected 1 result set, got %d", len(results))
+	}
+
+	published := make(map[string]bool)
+	for _, row := range results[0].Rows {
+		if len(row) != 2 {
+			return diff, fmt.Errorf("expected 2 result columns, got %d", len(row))
+		}
+		published[string(row[0])+"."+string(row[1])] = true
+	}
+	desired := make(map[string]bool)
+	for _, table := range tables {
+		if !strings.Contains(table, ".") {
+			table = "public." + table
+		}
+		if desired[table] {
+			continue
+		}
+		desired[table] = true
+		if !published[table] {
+			diff.Add = append(diff.Add, table)
+		}
+	}
+	for _, row := range results[0].Rows {
+		table := string(row[0]) + "." + string(row[1])
+		if !desired[table] {
+			diff.Drop = append(diff.Drop, table)
+		}
+	}
+	return diff, nil
+}
+
+// SyncPublication makes the publication name publish exactly tables, issuing the ALTER
+// PUBLICATION ADD TABLE and DROP TABLE commands computed by DiffPublication, in a single query.
+// It returns the changes made. This supports declarative configurations that list the
+// replicated tables and apply the list on startup.
+//
+// The publication must list its tables individually: the tables of a publication FOR ALL TABLES
+// or FOR TABLES IN SCHEMA cannot be dropped one by one. The column lists and row filters of the
+// tables kept are left alone.
+func SyncPublication(ctx context.Context, conn *pgconn.PgConn, name string, tables []string) (PublicationDiff, error) {
+	diff, err := DiffPublication(ctx, conn, name, tables)
+	if err != nil || diff.Empty() {
+		return diff, err
+	}
+	var options AlterPublicationOptions
+	for _, table := range diff.Add {
+		options.AddTables = append(options.AddTables, PublicationTable{Name: table})
+	}
+	for _, table := range diff.Drop {
+		options.DropTables = append(options.DropTables, PublicationTable{Name: table})
+	}
+	if err := AlterPublication(ctx, conn, name, options); err != nil {
+		return PublicationDiff{}, err
+	}
+	return diff, nil
+}
@@ -0,0 +1,153 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func commandCompleteResponse(tag string) []pgproto3.BackendMessage {
+	return []pgproto3.BackendMessage{
+		&pgproto3.CommandComplete{CommandTag: []byte(tag)},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	}
+}
+
+func TestCreatePublication(t *testing.T) {
+	tests := []struct {
+		name    string
+		options pglogrepl.PublicationOptions
+		sql     string
+	}{
+		{
+			name: "no tables",
+			sql:  `CREATE PUBLICATION "pub"`,
+		},
+		{
+			name:    "all tables",
+			options: pglogrepl.PublicationOptions{AllTables: true, PublishViaPartitionRoot: true},
+			sql:     `CREATE PUBLICATION "pub" FOR ALL TABLES WITH (publish_via_partition_root = true)`,
+		},
+		{
+			name: "tables and schemas",
+			options: pglogrepl.PublicationOptions{
+				Tables: []pglogrepl.PublicationTable{
+					{Name: "public.t", Columns: []string{"id", "Name"}, Where: "id > 10"},
+					{Name: "u", Only: true},
+				},
+				Schemas: []string{"sales"},
+				Publish: []string{"insert", "update"},
+			},
+			sql: `CREATE PUBLICATION "pub" FOR TABLE "public"."t" ("id", "Name") WHERE (id > 10), ONLY "u", TABLES IN SCHEMA "sales" WITH (publish = 'insert, update')`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, ws := newFakeWalSender(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			queries := ws.serveQuery(commandCompleteResponse("CREATE PUBLICATION"))
+			require.NoError(t, pglogrepl.CreatePublication(ctx, conn, "pub", tt.options))
+			assert.Equal(t, tt.sql, <-queries)
+		})
+	}
+
+	conn, _ := newFakeWalSender(t)
+	err := pglogrepl.CreatePublication(context.Background(), conn, "pub", pglogrepl.PublicationOptions{
+		AllTables: true,
+		Schemas:   []string{"sales"},
+	})
+	assert.Error(t, err)
+}
+
+func TestAlterPublication(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	viaRoot := false
+	queries := ws.serveQuery(commandCompleteResponse("ALTER PUBLICATION"))
+	err := pglogrepl.AlterPublication(ctx, conn, "pub", pglogrepl.AlterPublicationOptions{
+		AddTables:               []pglogrepl.PublicationTable{{Name: "public.t", Where: "active"}},
+		DropTables:              []pglogrepl.PublicationTable{{Name: "u", Columns: []string{"id"}}},
+		DropSchemas:             []string{"sales"},
+		Publish:                 []string{"insert"},
+		PublishViaPartitionRoot: &viaRoot,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `ALTER PUBLICATION "pub" ADD TABLE "public"."t" WHERE (active); `+
+		`ALTER PUBLICATION "pub" DROP TABLE "u", TABLES IN SCHEMA "sales"; `+
+		`ALTER PUBLICATION "pub" SET (publish = 'insert', publish_via_partition_root = false)`, <-queries)
+
+	queries = ws.serveQuery(commandCompleteResponse("ALTER PUBLICATION"))
+	err = pglogrepl.AlterPublication(ctx, conn, "pub", pglogrepl.AlterPublicationOptions{
+		SetTables: []pglogrepl.PublicationTable{{Name: "t", Columns: []string{"id"}}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `ALTER PUBLICATION "pub" SET TABLE "t" ("id")`, <-queries)
+
+	err = pglogrepl.AlterPublication(ctx, conn, "pub", pglogrepl.AlterPublicationOptions{
+		SetTables: []pglogrepl.PublicationTable{{Name: "t"}},
+		AddTables: []pglogrepl.PublicationTable{{Name: "u"}},
+	})
+	assert.Error(t, err)
+}
+
+func TestDropPublication(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	queries := ws.serveQuery(commandCompleteResponse("DROP PUBLICATION"))
+	require.NoError(t, pglogrepl.DropPublication(ctx, conn, "pub", pglogrepl.DropPublicationOptions{IfExists: true}))
+	assert.Equal(t, `DROP PUBLICATION IF EXISTS "pub"`, <-queries)
+
+	queries = ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42704", Message: `publication "pub" does not exist`},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	assert.Error(t, pglogrepl.DropPublication(ctx, conn, "pub", pglogrepl.DropPublicationOptions{}))
+	assert.Equal(t, `DROP PUBLICATION "pub"`, <-queries)
+}
+
+func TestSyncPublication(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows := func(tables ...[2]string) []pgproto3.BackendMessage {
+		msgs := []pgproto3.BackendMessage{&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
+			{Name: []byte("schemaname"), DataTypeOID: 19},
+			{Name: []byte("tablename"), DataTypeOID: 19},
+		}}}
+		for _, table := range tables {
+			msgs = append(msgs, &pgproto3.DataRow{Values: [][]byte{[]byte(table[0]), []byte(table[1])}})
+		}
+		return append(msgs, commandCompleteResponse("SELECT")...)
+	}
+
+	queries := ws.serveQuery(rows([2]string{"public", "a"}, [2]string{"public", "b"}, [2]string{"sales", "c"}))
+	done := make(chan pglogrepl.PublicationDiff, 1)
+	go func() {
+		diff, err := pglogrepl.SyncPublication(ctx, conn, "pub", []string{"a", "sales.c", "sales.d", "sales.d"})
+		assert.NoError(t, err)
+		done <- diff
+	}()
+	assert.Equal(t, `SELECT schemaname, tablename FROM pg_publication_tables WHERE pubname = 'pub'`, <-queries)
+	assert.Equal(t, `ALTER PUBLICATION "pub" ADD TABLE "sales"."d"; ALTER PUBLICATION "pub" DROP TABLE "public"."b"`,
+		<-ws.serveQuery(commandCompleteResponse("ALTER PUBLICATION")))
+	assert.Equal(t, pglogrepl.PublicationDiff{Add: []string{"sales.d"}, Drop: []string{"public.b"}}, <-done)
+
+	// A publication publishing the desired tables is left alone.
+	queries = ws.serveQuery(rows([2]string{"public", "a"}))
+	diff, err := pglogrepl.SyncPublication(ctx, conn, "pub", []string{"public.a"})
+	require.NoError(t, err)
+	assert.True(t, diff.Empty())
+	assert.Equal(t, `SELECT schemaname, tablename FROM pg_publication_tables WHERE pubname = 'pub'`, <-queries)
+}
@@ -0,0 +1,232 @@
+package pglogrepl
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// recordingMagic starts a recording, followed by the format version and the name of the codec.
+const recordingMagic = "PGLOGREPLREC"
+
+const recordingVersion = 1
+
+// recordHeaderSize is the size of the header of a recorded CopyData message: the time it was
+// received, its flags and the length of the data stored.
+const recordHeaderSize = 8 + 1 + 4
+
+// recordCompressed is set in the flags of a record whose data is compressed.
+const recordCompressed = 1
+
+// RecorderOptions configures a Recorder.
+type RecorderOptions struct {
+	// Codec, if set, compresses every recorded message on its own. The Replayer must be given a
+	// codec of the same name.
+	Codec Codec
+}
+
+// Recorder captures the raw CopyData messages a ReplicationStream receives, XLogData and primary
+// keepalive messages, with the time they were received, so that a Replayer can feed them through
+// the parser again. Recordings reproduce decoding bugs offline and load test sinks with
+// production traffic. A ReplicationStream records to the Recorder of its options.
+//
+// Recorder buffers its writes: Flush must be called once recording is done. A Recorder is not
+// safe for concurrent use.
+type Recorder struct {
+	w     *bufio.Writer
+	codec Codec
+	buf   []byte
+}
+
+// NewRecorder starts a recording written to w.
+func NewRecorder(w io.Writer, options RecorderOptions) (*Recorder, error) {
+	r := &Recorder{w: bufio.NewWriter(w), codec: options.Codec}
+	codecName := ""
+	if r.codec != nil {
+		codecName = r.codec.Name()
+	}
+	if len(codecName) > 255 {
+		return nil, fmt.Errorf("codec name %q is too long", codecName)
+	}
+	r.w.WriteString(recordingMagic)
+	r.w.WriteByte(recordingVersion)
+	r.w.WriteByte(byte(len(codecName)))
+	if _, err := r.w.WriteString(codecName); err != nil {
+		return nil, fmt.Errorf("failed to write recording: %w", err)
+	}
+	return r, nil
+}
+
+// Record records the CopyData message data received at t.
+func (r *Recorder) Record(t time.Time, data []byte) error {
+	buf := append(r.buf[:0], make([]byte, recordHeaderSize)...)
+	binary.BigEndian.PutUint64(buf, uint64(t.UnixNano()))
+	if r.codec != nil {
+		compressed, err := r.codec.Compress(buf, data)
+		if err != nil {
+			return fmt.Errorf("failed to record message: %w", err)
+		}
+		if len(compressed)-recordHeaderSize < len(data) {
+			buf = compressed
+			buf[8] = recordCompressed
+		}
+	}
+	if buf[8]&recordCompressed == 0 {
+		buf = append(buf[:recordHeaderSize], data...)
+	}
+	binary.BigEndian.PutUint32(buf[9:], uint32(len(buf)-recordHeaderSize))
+	r.buf = buf
+	if _, err := r.w.Write(buf); err != nil {
+		return fmt.Errorf("failed to write recording: %w", err)
+	}
+	return nil
+}
+
+// Flush writes the buffered messages to the underlying writer.
+func (r *Recorder) Flush() error {
+	if err := r.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write recording: %w", err)
+	}
+	return nil
+}
+
+// ReplayerOptions configures a Replayer.
+type ReplayerOptions struct {
+	// ProtoVersion is the pgoutput protocol version used to decode the WAL data, as in
+	// ReplicationStreamOptions. If it is 0 the WAL data is not decoded.
+	ProtoVersion int
+
+	// Speed, if positive, makes Next return the messages at the pace they were received, Speed
+	// times faster: 1 replays at the original speed, 10 ten times faster. If it is 0 the messages
+	// are returned as fast as they are consumed.
+	Speed float64
+
+	// Codec decompresses the messages of a recording made with a Codec. Its name must be the one
+	// of the codec of the recording.
+	Codec Codec
+}
+
+// Replayer reads a recording made by a Recorder and returns the XLogData messages it holds,
+// decoded as a ReplicationStream does, in the order they were received. Primary keepalive
+// messages are skipped but still pace the replay.
+type Replayer struct {
+	r       *bufio.Reader
+	options ReplayerOptions
+
+	inStream bool
+	// start is when the first message was returned and first the time it was received.
+	start time.Time
+	first time.Time
+}
+
+// NewReplayer reads the recording from r.
+func NewReplayer(r io.Reader, options ReplayerOptions) (*Replayer, error) {
+	rp := &Replayer{r: bufio.NewReader(r), options: options}
+	header := make([]byte, len(recordingMagic)+2)
+	if _, err := io.ReadFull(rp.r, header); err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	if string(header[:len(recordingMagic)]) != recordingMagic {
+		return nil, errors.New("not a recording")
+	}
+	if version := header[len(recordingMagic)]; version != recordingVersion {
+		return nil, fmt.Errorf("unsupported recording version %d", version)
+	}
+	codecName := make([]byte, header[len(recordingMagic)+1])
+	if _, err := io.ReadFull(rp.r, codecName); err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	if len(codecName) > 0 && (options.Codec == nil || options.Codec.Name() != string(codecName)) {
+		return nil, fmt.Errorf("recording is compressed with %s", codecName)
+	}
+	return rp, nil
+}
+
+// Next returns the next XLogData message of the recording, waiting until it is due if the options
+// have a Speed. It returns io.EOF at the end of the recording.
+func (rp *Replayer) Next(ctx context.Context) (*ReplicationMessage, error) {
+	for {
+		t, data, err := rp.read()
+		if err != nil {
+			return nil, err
+		}
+		if err := rp.wait(ctx, t); err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			return nil, fmt.Errorf("recorded empty CopyData message")
+		}
+		if data[0] != XLogDataByteID {
+			continue
+		}
+
+		xld, err := ParseXLogData(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		rm := &ReplicationMessage{XLogData: xld}
+		if rp.options.ProtoVersion > 0 {
+			if rm.Message, err = parseMessage(rp.options.ProtoVersion, xld.WALData, rp.inStream); err != nil {
+				return nil, fmt.Errorf("failed to parse logical replication message: %w", err)
+			}
+			switch rm.Message.(type) {
+			case *StreamStartMessageV2:
+				rp.inStream = true
+			case *StreamStopMessageV2:
+				rp.inStream = false
+			}
+		}
+		return rm, nil
+	}
+}
+
+// read reads the next record.
+func (rp *Replayer) read() (time.Time, []byte, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(rp.r, header); err != nil {
+		if err == io.EOF {
+			return time.Time{}, nil, io.EOF
+		}
+		return time.Time{}, nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[9:]))
+	if _, err := io.ReadFull(rp.r, data); err != nil {
+		return time.Time{}, nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	if header[8]&recordCompressed != 0 {
+		var err error
+		if data, err = rp.options.Codec.Decompress(nil, data); err != nil {
+			return time.Time{}, nil, fmt.Errorf("failed to read recorded message: %w", err)
+		}
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(header))), data, nil
+}
+
+// wait waits until the message received at t is due.
+func (rp *Replayer) wait(ctx context.Context, t time.Time) error {
+	if rp.options.Speed <= 0 {
+		return nil
+	}
+	if rp.start.IsZero() {
+		rp.start = time.Now()
+		rp.first = t
+		return nil
+	}
+	due := rp.start.Add(time.Duration(float64(t.Sub(rp.first)) / rp.options.Speed))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,84 @@
+package pglogrepl_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	codec, err := pglogrepl.GzipCodec(gzip.BestSpeed)
+	require.NoError(t, err)
+	var recording bytes.Buffer
+	recorder, err := pglogrepl.NewRecorder(&recording, pglogrepl.RecorderOptions{Codec: codec})
+	require.NoError(t, err)
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{ProtoVersion: 1, Recorder: recorder})
+
+	ws.sendXLogData(0x200, beginMessageData(0x500, 7))
+	ws.sendKeepalive(0x300, false)
+	ws.sendXLogData(0x300, insertMessageData(t, string(bytes.Repeat([]byte("a"), 1000))))
+	ws.sendXLogData(0x500, commitMessageData(0x500, 0x540))
+	var received []*pglogrepl.ReplicationMessage
+	for i := 0; i < 3; i++ {
+		msg, err := stream.Next(ctx)
+		require.NoError(t, err)
+		received = append(received, msg)
+	}
+	require.NoError(t, recorder.Flush())
+
+	_, err = pglogrepl.NewReplayer(bytes.NewReader(recording.Bytes()), pglogrepl.ReplayerOptions{})
+	assert.Error(t, err, "the codec is missing")
+
+	// The replayed messages are decoded again, the keepalive is skipped.
+	replayer, err := pglogrepl.NewReplayer(bytes.NewReader(recording.Bytes()), pglogrepl.ReplayerOptions{ProtoVersion: 1, Codec: codec})
+	require.NoError(t, err)
+	for _, want := range received {
+		msg, err := replayer.Next(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want.XLogData, msg.XLogData)
+		assert.Equal(t, want.Message, msg.Message)
+	}
+	_, err = replayer.Next(ctx)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReplayerSpeed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var recording bytes.Buffer
+	recorder, err := pglogrepl.NewRecorder(&recording, pglogrepl.RecorderOptions{})
+	require.NoError(t, err)
+	start := time.Now()
+	xld := []byte{pglogrepl.XLogDataByteID, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 'x'}
+	require.NoError(t, recorder.Record(start, xld))
+	require.NoError(t, recorder.Record(start.Add(time.Second), xld))
+	require.NoError(t, recorder.Flush())
+
+	// The second message was received a second after the first, replayed ten times faster.
+	replayer, err := pglogrepl.NewReplayer(&recording, pglogrepl.ReplayerOptions{Speed: 10})
+	require.NoError(t, err)
+	msg, err := replayer.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("x"), msg.WALData)
+	assert.Nil(t, msg.Message)
+	replayStart := time.Now()
+	_, err = replayer.Next(ctx)
+	require.NoError(t, err)
+	elapsed := time.Since(replayStart)
+	assert.GreaterOrEqual(t, elapsed, 90*time.Millisecond)
+	assert.Less(t, elapsed, 900*time.Millisecond)
+
+	_, err = pglogrepl.NewReplayer(bytes.NewReader([]byte("not a recording")), pglogrepl.ReplayerOptions{})
+	assert.Error(t, err)
+}
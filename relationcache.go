@@ -0,0 +1,387 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// RelationChange describes how the definition of a relation changed between two RelationMessage
+// messages for the same relation ID.
+type RelationChange struct {
+	Old *RelationMessage
+	New *RelationMessage
+
+	// Renamed reports whether the namespace or name of the relation changed.
+	Renamed bool
+	// AddedColumns and DroppedColumns are the names of the columns added to and dropped from the
+	// relation.
+	AddedColumns   []string
+	DroppedColumns []string
+	// AlteredColumns are the names of the columns whose type, type modifier or key flag changed.
+	AlteredColumns []string
+}
+
+// RelationCache keeps the latest RelationMessage of every relation received in a replication
+// stream. pgoutput sends a RelationMessage before the first change of a relation in a session
+// and again whenever its definition changed, so consumers need it to interpret the tuples of
+// later messages.
+//
+// RelationCache is safe for concurrent use.
+type RelationCache struct {
+	onChange func(change *RelationChange)
+
+	mu     sync.RWMutex
+	byID   map[uint32]*RelationMessage
+	byName map[string]uint32
+	roots  map[uint32]PartitionRoot
+	attrs  map[uint32][]ColumnAttribute
+}
+
+// ColumnAttribute is the catalog entry of a column of a relation, from pg_attribute.
+type ColumnAttribute struct {
+	Name   string
+	Number int16
+	// Generated is the attgenerated of the column: 's' for a stored generated column, 'v' for a
+	// virtual one and 0 for an ordinary column.
+	Generated byte
+	// Dropped reports that the column was dropped. The catalog keeps dropped columns, renamed to
+	// "........pg.dropped.N........", until the table is rewritten.
+	Dropped bool
+}
+
+// PartitionRoot is the partitioned table at the root of the partition tree of a partition.
+type PartitionRoot struct {
+	RelationID   uint32
+	Namespace    string
+	RelationName string
+}
+
+// NewRelationCache returns an empty RelationCache. If onChange is not nil it is called whenever
+// the definition of a cached relation changes.
+func NewRelationCache(onChange func(change *RelationChange)) *RelationCache {
+	return &RelationCache{
+		onChange: onChange,
+		byID:     map[uint32]*RelationMessage{},
+		byName:   map[string]uint32{},
+		attrs:    map[uint32][]ColumnAttribute{},
+	}
+}
+
+// Update caches msg if it is a RelationMessage or RelationMessageV2 and ignores any other message.
+// It returns the change if the relation was already cached with a different definition.
+func (c *RelationCache) Update(msg Message) *RelationChange {
+	var rel *RelationMessage
+	switch msg := msg.(type) {
+	case *RelationMessage:
+		rel = msg
+	case *RelationMessageV2:
+		rel = &msg.RelationMessage
+	default:
+		return nil
+	}
+
+	c.mu.Lock()
+	old := c.byID[rel.RelationID]
+	if old != nil {
+		delete(c.byName, relationName(old.Namespace, old.RelationName))
+	}
+	c.byID[rel.RelationID] = rel
+	c.byName[relationName(rel.Namespace, rel.RelationName)] = rel.RelationID
+	var change *RelationChange
+	if old != nil {
+		change = diffRelations(old, rel)
+	}
+	if change != nil {
+		// The definition changed, so the column attributes loaded before may be stale.
+		delete(c.attrs, rel.RelationID)
+	}
+	c.mu.Unlock()
+
+	if change == nil {
+		return nil
+	}
+	if c.onChange != nil {
+		c.onChange(change)
+	}
+	return change
+}
+
+// Relation returns the relation with relationID.
+func (c *RelationCache) Relation(relationID uint32) (*RelationMessage, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rel, ok := c.byID[relationID]
+	return rel, ok
+}
+
+// RelationByName returns the relation with the given namespace and name.
+func (c *RelationCache) RelationByName(namespace, name string) (*RelationMessage, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	relationID, ok := c.byName[relationName(namespace, name)]
+	if !ok {
+		return nil, false
+	}
+	return c.byID[relationID], true
+}
+
+// Relations returns all cached relations in no particular order.
+func (c *RelationCache) Relations() []*RelationMessage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rels := make([]*RelationMessage, 0, len(c.byID))
+	for _, rel := range c.byID {
+		rels = append(rels, rel)
+	}
+	return rels
+}
+
+// LoadPartitionRoots queries the catalog for the root of every partition of the database of conn
+// and replaces the partition roots used by RootRelation with the result. It requires PostgreSQL
+// 12 or later. Partitions created or attached later are not known until it is called again, for
+// instance when a RelationMessage of an unknown relation is received.
+func (c *RelationCache) LoadPartitionRoots(ctx context.Context, conn *pgconn.PgConn) error {
+	sql := `SELECT c.oid, r.oid, n.nspname, r.relname FROM pg_class c ` +
+		`JOIN pg_class r ON r.oid = pg_partition_root(c.oid) ` +
+		`JOIN pg_namespace n ON n.oid = r.relnamespace ` +
+		`WHERE c.relispartition AND c.relkind IN ('r', 'p')`
+	results, err := conn.Exec(ctx, sql).ReadAll()
+	if err != nil {
+		return fmt.Errorf("failed to read partition roots: %w", err)
+	}
+	if len(results) != 1 {
+		return fmt.Errorf("expected 1 result set, got %d", len(results))
+	}
+
+	roots := make(map[uint32]PartitionRoot, len(results[0].Rows))
+	for _, row := range results[0].Rows {
+		if len(row) != 4 {
+			return fmt.Errorf("expected 4 result columns, got %d", len(row))
+		}
+		partition, err := strconv.ParseUint(string(row[0]), 10, 32)
+		if err != nil {
+			return fmt.Errorf("failed to parse partition OID: %w", err)
+		}
+		root, err := strconv.ParseUint(string(row[1]), 10, 32)
+		if err != nil {
+			return fmt.Errorf("failed to parse partition root OID: %w", err)
+		}
+		roots[uint32(partition)] = PartitionRoot{RelationID: uint32(root), Namespace: string(row[2]), RelationName: string(row[3])}
+	}
+	c.SetPartitionRoots(roots)
+	return nil
+}
+
+// SetPartitionRoots replaces the partition roots used by RootRelation with roots, keyed by the
+// relation ID of the partitions.
+func (c *RelationCache) SetPartitionRoots(roots map[uint32]PartitionRoot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roots = roots
+}
+
+// RootRelation returns the relation with relationID attributed to the root of its partition tree.
+// When publish_via_partition_root is off the changes of a partitioned table are sent as changes
+// of its leaf partitions; RootRelation lets a sink present them as changes of a single table.
+//
+// If relationID is a partition with a root loaded by LoadPartitionRoots or SetPartitionRoots, the
+// result is a copy of the relation with the namespace and name of the root. It keeps the relation
+// ID and the columns of the partition, which describe the tuples of its changes and may be in an
+// order different from the root's. Otherwise the relation is returned unchanged.
+func (c *RelationCache) RootRelation(relationID uint32) (*RelationMessage, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rel, ok := c.byID[relationID]
+	if !ok {
+		return nil, false
+	}
+	if root, ok := c.roots[relationID]; ok {
+		rootRel := *rel
+		rootRel.Namespace = root.Namespace
+		rootRel.RelationName = root.RelationName
+		return &rootRel, true
+	}
+	return rel, true
+}
+
+// PartitionRoot returns the root of the partition tree of the partition with relationID.
+func (c *RelationCache) PartitionRoot(relationID uint32) (PartitionRoot, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	root, ok := c.roots[relationID]
+	return root, ok
+}
+
+// LoadColumnAttributes queries the catalog for the columns of the relations with relationIDs, or of
+// all cached relations if none is given, and caches them for DecodeTuple. It requires PostgreSQL
+// 12 or later. The attributes of a relation are discarded when a RelationMessage changing its
+// definition is received, so they should be loaded again after the relation changed.
+func (c *RelationCache) LoadColumnAttributes(ctx context.Context, conn *pgconn.PgConn, relationIDs ...uint32) error {
+	if len(relationIDs) == 0 {
+		for _, rel := range c.Relations() {
+			relationIDs = append(relationIDs, rel.RelationID)
+		}
+		if len(relationIDs) == 0 {
+			return nil
+		}
+	}
+	ids := make([]string, len(relationIDs))
+	for i, id := range relationIDs {
+		ids[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	sql := "SELECT attrelid, attname, attnum, attgenerated, attisdropped FROM pg_attribute " +
+		"WHERE attrelid IN (" + strings.Join(ids, ", ") + ") AND attnum > 0 ORDER BY attrelid, attnum"
+	results, err := conn.Exec(ctx, sql).ReadAll()
+	if err != nil {
+		return fmt.Errorf("failed to read column attributes: %w", err)
+	}
+	if len(results) != 1 {
+		return fmt.Errorf("expected 1 result set, got %d", len(results))
+	}
+
+	attrs := make(map[uint32][]ColumnAttribute, len(relationIDs))
+	for _, row := range results[0].Rows {
+		if len(row) != 5 {
+			return fmt.Errorf("expected 5 result columns, got %d", len(row))
+		}
+		relationID, err := strconv.ParseUint(string(row[0]), 10, 32)
+		if err != nil {
+			return fmt.Errorf("failed to parse attrelid: %w", err)
+		}
+		number, err := strconv.ParseInt(string(row[2]), 10, 16)
+		if err != nil {
+			return fmt.Errorf("failed to parse attnum: %w", err)
+		}
+		attr := ColumnAttribute{Name: string(row[1]), Number: int16(number), Dropped: string(row[4]) == "t"}
+		if len(row[3]) > 0 {
+			attr.Generated = row[3][0]
+		}
+		attrs[uint32(relationID)] = append(attrs[uint32(relationID)], attr)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range relationIDs {
+		c.attrs[id] = attrs[id]
+	}
+	return nil
+}
+
+// ColumnAttributes returns the column attributes of the relation with relationID loaded by
+// LoadColumnAttributes, in the order of their attnum.
+func (c *RelationCache) ColumnAttributes(relationID uint32) ([]ColumnAttribute, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	attrs, ok := c.attrs[relationID]
+	return attrs, ok
+}
+
+// DecodeTuple decodes tuple, a tuple of the relation with relationID, like the DecodeTuple
+// function. If the column attributes of the relation were loaded by LoadColumnAttributes, the
+// generated columns, which PostgreSQL 18 sends with the publish_generated_columns option, are left
+// out of the map, and an error is returned if a column of the cached relation is dropped or
+// missing in the catalog, which means the cached definition is stale and the values would be
+// attributed to the wrong columns.
+func (c *RelationCache) DecodeTuple(relationID uint32, tuple *TupleData, m *pgtype.Map) (map[string]interface{}, error) {
+	rel, ok := c.Relation(relationID)
+	if !ok {
+		return nil, fmt.Errorf("unknown relation ID %d", relationID)
+	}
+	return c.decodeTuple(rel, tuple, m)
+}
+
+func (c *RelationCache) decodeTuple(rel *RelationMessage, tuple *TupleData, m *pgtype.Map) (map[string]interface{}, error) {
+	values, err := DecodeTuple(rel, tuple, m)
+	if err != nil {
+		return nil, err
+	}
+	attrs, ok := c.ColumnAttributes(rel.RelationID)
+	if !ok {
+		return values, nil
+	}
+
+	byName := make(map[string]ColumnAttribute, len(attrs))
+	for _, attr := range attrs {
+		if !attr.Dropped {
+			byName[attr.Name] = attr
+		}
+	}
+	for _, col := range rel.Columns {
+		attr, ok := byName[col.Name]
+		if !ok {
+			return nil, fmt.Errorf("column %s of relation %s.%s is not in the catalog", col.Name, rel.Namespace, rel.RelationName)
+		}
+		if attr.Generated != 0 {
+			delete(values, col.Name)
+		}
+	}
+	return values, nil
+}
+
+// Reset removes all cached relations. The server sends the relations again in a new replication
+// session, so the cache should be reset when replication is restarted. The partition roots and
+// column attributes, which come from the catalog, are kept.
+func (c *RelationCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byID = map[uint32]*RelationMessage{}
+	c.byName = map[string]uint32{}
+}
+
+func relationName(namespace, name string) string {
+	return namespace + "\x00" + name
+}
+
+// diffRelations returns the differences between old and new or nil if they describe the same
+// relation.
+func diffRelations(old, new *RelationMessage) *RelationChange {
+	change := &RelationChange{
+		Old:     old,
+		New:     new,
+		Renamed: old.Namespace != new.Namespace || old.RelationName != new.RelationName,
+	}
+
+	oldColumns := make(map[string]*RelationMessageColumn, len(old.Columns))
+	for _, col := range old.Columns {
+		oldColumns[col.Name] = col
+	}
+	newColumns := make(map[string]struct{}, len(new.Columns))
+	for _, col := range new.Columns {
+		newColumns[col.Name] = struct{}{}
+		oldCol, ok := oldColumns[col.Name]
+		if !ok {
+			change.AddedColumns = append(change.AddedColumns, col.Name)
+		} else if oldCol.DataType != col.DataType || oldCol.TypeModifier != col.TypeModifier || oldCol.Flags != col.Flags {
+			change.AlteredColumns = append(change.AlteredColumns, col.Name)
+		}
+	}
+	for _, col := range old.Columns {
+		if _, ok := newColumns[col.Name]; !ok {
+			change.DroppedColumns = append(change.DroppedColumns, col.Name)
+		}
+	}
+
+	if !change.Renamed && len(change.AddedColumns) == 0 && len(change.DroppedColumns) == 0 && len(change.AlteredColumns) == 0 &&
+		old.ReplicaIdentity == new.ReplicaIdentity && sameColumnOrder(old, new) {
+		return nil
+	}
+	return change
+}
+
+func sameColumnOrder(old, new *RelationMessage) bool {
+	if len(old.Columns) != len(new.Columns) {
+		return false
+	}
+	for i := range old.Columns {
+		if old.Columns[i].Name != new.Columns[i].Name {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,184 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func relationMessage(namespace, name string, columns ...string) *pglogrepl.RelationMessage {
+	rel := &pglogrepl.RelationMessage{RelationID: 1, Namespace: namespace, RelationName: name, ReplicaIdentity: 'd'}
+	for _, col := range columns {
+		rel.Columns = append(rel.Columns, &pglogrepl.RelationMessageColumn{Name: col, DataType: 25, TypeModifier: -1})
+	}
+	rel.ColumnNum = uint16(len(rel.Columns))
+	return rel
+}
+
+func TestRelationCache(t *testing.T) {
+	var changes []*pglogrepl.RelationChange
+	cache := pglogrepl.NewRelationCache(func(change *pglogrepl.RelationChange) {
+		changes = append(changes, change)
+	})
+
+	rel := relationMessage("public", "t", "id", "name")
+	assert.Nil(t, cache.Update(rel))
+	assert.Nil(t, cache.Update(&pglogrepl.BeginMessage{}))
+
+	got, ok := cache.Relation(1)
+	require.True(t, ok)
+	assert.Same(t, rel, got)
+	got, ok = cache.RelationByName("public", "t")
+	require.True(t, ok)
+	assert.Same(t, rel, got)
+	_, ok = cache.Relation(2)
+	assert.False(t, ok)
+
+	// The same definition is sent again after a reconnect.
+	assert.Nil(t, cache.Update(&pglogrepl.RelationMessageV2{RelationMessage: *relationMessage("public", "t", "id", "name")}))
+	assert.Empty(t, changes)
+
+	altered := relationMessage("public", "t", "id", "email")
+	altered.Columns[0].DataType = 20
+	change := cache.Update(altered)
+	require.NotNil(t, change)
+	assert.Equal(t, []*pglogrepl.RelationChange{change}, changes)
+	assert.False(t, change.Renamed)
+	assert.Equal(t, []string{"email"}, change.AddedColumns)
+	assert.Equal(t, []string{"name"}, change.DroppedColumns)
+	assert.Equal(t, []string{"id"}, change.AlteredColumns)
+
+	renamed := relationMessage("public", "u", "id", "email")
+	renamed.Columns[0].DataType = 20
+	change = cache.Update(renamed)
+	require.NotNil(t, change)
+	assert.True(t, change.Renamed)
+	_, ok = cache.RelationByName("public", "t")
+	assert.False(t, ok)
+	_, ok = cache.RelationByName("public", "u")
+	assert.True(t, ok)
+	assert.Len(t, cache.Relations(), 1)
+
+	cache.Reset()
+	assert.Empty(t, cache.Relations())
+}
+
+func TestRelationCachePartitionRoots(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	queries := ws.serveQuery(append([]pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
+			{Name: []byte("oid"), DataTypeOID: 26},
+			{Name: []byte("oid"), DataTypeOID: 26},
+			{Name: []byte("nspname"), DataTypeOID: 19},
+			{Name: []byte("relname"), DataTypeOID: 19},
+		}},
+		&pgproto3.DataRow{Values: [][]byte{[]byte("1"), []byte("10"), []byte("public"), []byte("measurements")}},
+	}, commandCompleteResponse("SELECT 1")...))
+	cache := pglogrepl.NewRelationCache(nil)
+	require.NoError(t, cache.LoadPartitionRoots(ctx, conn))
+	assert.Contains(t, <-queries, "pg_partition_root(c.oid)")
+
+	leaf := relationMessage("public", "measurements_2024", "id", "value")
+	cache.Update(leaf)
+	cache.Update(&pglogrepl.RelationMessage{RelationID: 2, Namespace: "public", RelationName: "t"})
+
+	root, ok := cache.PartitionRoot(1)
+	require.True(t, ok)
+	assert.Equal(t, pglogrepl.PartitionRoot{RelationID: 10, Namespace: "public", RelationName: "measurements"}, root)
+
+	rel, ok := cache.RootRelation(1)
+	require.True(t, ok)
+	assert.Equal(t, "measurements", rel.RelationName)
+	assert.Equal(t, uint32(1), rel.RelationID)
+	assert.Equal(t, leaf.Columns, rel.Columns)
+	assert.Equal(t, "measurements_2024", leaf.RelationName)
+
+	rel, ok = cache.RootRelation(2)
+	require.True(t, ok)
+	assert.Equal(t, "t", rel.RelationName)
+	_, ok = cache.RootRelation(3)
+	assert.False(t, ok)
+
+	cache.Reset()
+	_, ok = cache.PartitionRoot(1)
+	assert.True(t, ok)
+}
+
+func TestRelationCacheColumnAttributes(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cache := pglogrepl.NewRelationCache(nil)
+	cache.Update(relationMessage("public", "t", "id", "total"))
+
+	row := func(values ...string) pgproto3.BackendMessage {
+		msg := &pgproto3.DataRow{}
+		for _, v := range values {
+			msg.Values = append(msg.Values, []byte(v))
+		}
+		return msg
+	}
+	queries := ws.serveQuery(append([]pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
+			{Name: []byte("attrelid"), DataTypeOID: 26},
+			{Name: []byte("attname"), DataTypeOID: 19},
+			{Name: []byte("attnum"), DataTypeOID: 21},
+			{Name: []byte("attgenerated"), DataTypeOID: 18},
+			{Name: []byte("attisdropped"), DataTypeOID: 16},
+		}},
+		row("1", "id", "1", "", "f"),
+		row("1", "........pg.dropped.2........", "2", "", "t"),
+		row("1", "total", "3", "s", "f"),
+	}, commandCompleteResponse("SELECT 3")...))
+	require.NoError(t, cache.LoadColumnAttributes(ctx, conn))
+	assert.Equal(t, "SELECT attrelid, attname, attnum, attgenerated, attisdropped FROM pg_attribute WHERE attrelid IN (1) AND attnum > 0 ORDER BY attrelid, attnum", <-queries)
+
+	attrs, ok := cache.ColumnAttributes(1)
+	require.True(t, ok)
+	assert.Equal(t, []pglogrepl.ColumnAttribute{
+		{Name: "id", Number: 1},
+		{Name: "........pg.dropped.2........", Number: 2, Dropped: true},
+		{Name: "total", Number: 3, Generated: 's'},
+	}, attrs)
+
+	tuple := &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{textColumn("1"), textColumn("10")}}
+	values, err := cache.DecodeTuple(1, tuple, pgtype.NewMap())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": "1"}, values)
+
+	// A stale definition with a column unknown to the catalog is rejected.
+	cache.Update(relationMessage("public", "t", "id", "name"))
+	_, ok = cache.ColumnAttributes(1)
+	assert.False(t, ok)
+	values, err = cache.DecodeTuple(1, tuple, pgtype.NewMap())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": "1", "name": "10"}, values)
+
+	queries = ws.serveQuery(append([]pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
+			{Name: []byte("attrelid"), DataTypeOID: 26},
+			{Name: []byte("attname"), DataTypeOID: 19},
+			{Name: []byte("attnum"), DataTypeOID: 21},
+			{Name: []byte("attgenerated"), DataTypeOID: 18},
+			{Name: []byte("attisdropped"), DataTypeOID: 16},
+		}},
+		row("1", "id", "1", "", "f"),
+	}, commandCompleteResponse("SELECT 1")...))
+	require.NoError(t, cache.LoadColumnAttributes(ctx, conn, 1))
+	<-queries
+	_, err = cache.DecodeTuple(1, tuple, pgtype.NewMap())
+	assert.EqualError(t, err, "column name of relation public.t is not in the catalog")
+
+	_, err = cache.DecodeTuple(2, tuple, pgtype.NewMap())
+	assert.Error(t, err)
+}
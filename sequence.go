@@ -0,0 +1,130 @@
+package pglogrepl
+
+import (
+	"encoding/binary"
+
+	"github.com/jackc/pgio"
+)
+
+// SequenceMessage is a sequence message, reporting a change of the state of a sequence.
+//
+// Sequence messages were added to pgoutput during PostgreSQL development and are only sent when
+// it is started with the sequences option, see PgoutputOptions.Sequences, which no released
+// server supports yet. They are parsed so that consumers of such servers do not fail with an
+// UnknownMessageTypeError.
+type SequenceMessage struct {
+	baseMessage
+	// Flags are currently unused (must be 0).
+	Flags uint8
+	// LSN is the LSN of the sequence change.
+	LSN LSN
+	// Namespace is the schema of the sequence, it is empty for pg_catalog. Name is the name of the
+	// sequence.
+	Namespace string
+	Name      string
+	// Transactional reports whether the change is part of a transaction, such as for a sequence
+	// created in it, or must be applied immediately.
+	Transactional bool
+	LastValue     int64
+	LogCount      int64
+	IsCalled      bool
+}
+
+// Decode decodes the message from src.
+func (m *SequenceMessage) Decode(src []byte) error {
+	if len(src) < 29 {
+		return m.lengthError("SequenceMessage", 29, len(src))
+	}
+
+	var low, used int
+	m.Flags = src[low]
+	low++
+	m.LSN, used = m.decodeLSN(src[low:])
+	low += used
+
+	m.Namespace, used = m.decodeString(src[low:])
+	if used < 0 {
+		return m.decodeStringError("SequenceMessage", "Namespace", low)
+	}
+	low += used
+	m.Name, used = m.decodeString(src[low:])
+	if used < 0 {
+		return m.decodeStringError("SequenceMessage", "Name", low)
+	}
+	low += used
+
+	if len(src)-low < 18 {
+		return m.lengthError("SequenceMessage", low+18, len(src))
+	}
+	m.Transactional = src[low] == 1
+	low++
+	m.LastValue = int64(binary.BigEndian.Uint64(src[low:]))
+	low += 8
+	m.LogCount = int64(binary.BigEndian.Uint64(src[low:]))
+	low += 8
+	m.IsCalled = src[low] == 1
+
+	m.SetType(MessageTypeSequence)
+
+	return nil
+}
+
+// Encode appends the wire format of the message to dst.
+func (m *SequenceMessage) Encode(dst []byte) (_ []byte, err error) {
+	dst = append(dst, byte(MessageTypeSequence), m.Flags)
+	dst = pgio.AppendUint64(dst, uint64(m.LSN))
+	if dst, err = encodeString(dst, "SequenceMessage", "Namespace", m.Namespace); err != nil {
+		return nil, err
+	}
+	if dst, err = encodeString(dst, "SequenceMessage", "Name", m.Name); err != nil {
+		return nil, err
+	}
+	dst = append(dst, encodeBool(m.Transactional))
+	dst = pgio.AppendInt64(dst, m.LastValue)
+	dst = pgio.AppendInt64(dst, m.LogCount)
+	return append(dst, encodeBool(m.IsCalled)), nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *SequenceMessage) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
+// SequenceMessageV2 is a sequence message of protocol version 2 and later.
+type SequenceMessageV2 struct {
+	SequenceMessage
+	InStreamMessageV2WithXid
+}
+
+// DecodeV2 decodes to message from V2 src.
+func (m *SequenceMessageV2) DecodeV2(src []byte, inStream bool) (err error) {
+	if !inStream {
+		return m.SequenceMessage.Decode(src)
+	}
+
+	if len(src) < 33 {
+		return m.lengthError("SequenceMessageV2", 33, len(src))
+	}
+
+	src = readXidAndAdvance(src, &m.InStreamMessageV2WithXid, inStream)
+
+	return nestedError(m.SequenceMessage.Decode(src), "SequenceMessageV2", "", 4)
+}
+
+// Encode appends the wire format of the message to dst. The Xid is only encoded if it is not 0,
+// which is the case for messages sent inside a streamed transaction.
+func (m *SequenceMessageV2) Encode(dst []byte) ([]byte, error) {
+	return encodeWithXid(dst, &m.SequenceMessage, m.Xid)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *SequenceMessageV2) MarshalBinary() ([]byte, error) {
+	return m.Encode(nil)
+}
+
+func encodeBool(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
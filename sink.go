@@ -0,0 +1,89 @@
+package pglogrepl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sink is the target of a replication stream driven by RunSink or a Subscription.
+//
+// The contract between the stream and a Sink is at least once delivery: every message received is
+// passed to WriteChange in order, and the position confirmed to the server as flushed is only ever
+// one returned by Flush. A Sink must therefore only return a position from Flush once every change
+// before it is durable in the target. After a restart replication resumes from the last confirmed
+// position, so changes written but not yet reported durable are received again, and the Sink
+// must tolerate them.
+type Sink interface {
+	// WriteChange writes msg to the sink, which may buffer it.
+	WriteChange(ctx context.Context, msg *ReplicationMessage) error
+	// Flush makes the written changes durable and returns the position up to which they are. It
+	// is usually the end of the last transaction the sink has completely written. It returns 0 if
+	// nothing is durable yet.
+	Flush(ctx context.Context) (LSN, error)
+}
+
+// RunSinkOptions configures RunSink.
+type RunSinkOptions struct {
+	// FlushInterval is the interval at which the sink is flushed. If it is 0 then 1 second is used.
+	FlushInterval time.Duration
+	// OnFlush, if set, is called with the durable position returned by every successful Flush
+	// before it is confirmed to the server.
+	OnFlush func(ctx context.Context, lsn LSN) error
+}
+
+const defaultSinkFlushInterval = time.Second
+
+// RunSink writes the messages received from stream to sink until ctx is canceled, the server ends
+// replication or an error occurs. The sink is flushed every FlushInterval and the returned
+// position is confirmed to the server with SetAppliedLSN. Nothing beyond the start position is
+// confirmed before the first Flush. It returns ctx.Err() if ctx was canceled and io.EOF if the
+// server ended replication.
+func RunSink(ctx context.Context, stream *ReplicationStream, sink Sink, options RunSinkOptions) error {
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = defaultSinkFlushInterval
+	}
+	stream.SetAppliedLSN(0)
+
+	flushDeadline := time.Now().Add(options.FlushInterval)
+	flush := func() error {
+		lsn, err := sink.Flush(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to flush sink: %w", err)
+		}
+		if lsn > 0 {
+			if options.OnFlush != nil {
+				if err := options.OnFlush(ctx, lsn); err != nil {
+					return err
+				}
+			}
+			stream.SetAppliedLSN(lsn)
+		}
+		flushDeadline = time.Now().Add(options.FlushInterval)
+		return nil
+	}
+
+	for {
+		nextCtx, cancel := context.WithDeadline(ctx, flushDeadline)
+		msg, err := stream.Next(nextCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+				if err := flush(); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+		if err := sink.WriteChange(ctx, msg); err != nil {
+			return err
+		}
+		if !time.Now().Before(flushDeadline) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSink makes the end of every committed transaction durable on Flush.
+type testSink struct {
+	mu        sync.Mutex
+	written   []pglogrepl.Message
+	committed pglogrepl.LSN
+	flushes   chan pglogrepl.LSN
+}
+
+func newTestSink() *testSink {
+	return &testSink{flushes: make(chan pglogrepl.LSN, 100)}
+}
+
+func (s *testSink) WriteChange(_ context.Context, msg *pglogrepl.ReplicationMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = append(s.written, msg.Message)
+	if commit, ok := msg.Message.(*pglogrepl.CommitMessage); ok {
+		s.committed = commit.TransactionEndLSN
+	}
+	return nil
+}
+
+func (s *testSink) Flush(context.Context) (pglogrepl.LSN, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case s.flushes <- s.committed:
+	default:
+	}
+	return s.committed, nil
+}
+
+func TestRunSink(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{ProtoVersion: 1, StandbyMessageTimeout: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sink := newTestSink()
+	flushed := make(chan pglogrepl.LSN, 1)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- pglogrepl.RunSink(ctx, stream, sink, pglogrepl.RunSinkOptions{
+			FlushInterval: 10 * time.Millisecond,
+			OnFlush: func(_ context.Context, lsn pglogrepl.LSN) error {
+				select {
+				case flushed <- lsn:
+				default:
+				}
+				return nil
+			},
+		})
+	}()
+
+	// The received position is not confirmed before the sink reports it durable.
+	ws.sendXLogData(0x200, beginMessageData(0x300, 42))
+	ws.sendKeepalive(0x250, true)
+	ssu := ws.receiveStandbyStatusUpdate()
+	assert.Equal(t, pglogrepl.LSN(0x250), ssu.WALWritePosition)
+	assert.Equal(t, pglogrepl.LSN(0x100), ssu.WALFlushPosition)
+
+	ws.sendXLogData(0x300, commitMessageData(0x300, 0x310))
+	assert.Equal(t, pglogrepl.LSN(0x310), <-flushed)
+	ws.sendKeepalive(0x400, true)
+	assert.Equal(t, pglogrepl.LSN(0x310), ws.receiveStandbyStatusUpdate().WALFlushPosition)
+
+	cancel()
+	assert.ErrorIs(t, <-runErr, context.Canceled)
+	require.Len(t, sink.written, 2)
+}
+
+func TestSubscriptionSink(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store := &memoryCheckpointStore{checkpoints: map[string]pglogrepl.LSN{}}
+	sink := newTestSink()
+	sub := pglogrepl.NewSubscription(conn, pglogrepl.SubscriptionOptions{
+		SlotName:          slotName,
+		PublicationName:   "pub",
+		CheckpointStore:   store,
+		Sink:              sink,
+		SinkFlushInterval: 10 * time.Millisecond,
+	})
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- sub.Run(ctx) }()
+
+	<-ws.serveQuery(duplicateObjectResponse())
+	<-ws.serveStartReplication()
+
+	ws.sendXLogData(0x200, beginMessageData(0x300, 42))
+	ws.sendXLogData(0x300, commitMessageData(0x300, 0x310))
+	for lsn := range sink.flushes {
+		if lsn == 0x310 {
+			break
+		}
+	}
+	ws.sendKeepalive(0x400, true)
+	assert.Equal(t, pglogrepl.LSN(0x310), ws.receiveStandbyStatusUpdate().WALFlushPosition)
+
+	cancel()
+	assert.ErrorIs(t, <-runErr, context.Canceled)
+	assert.Equal(t, pglogrepl.LSN(0x310), store.checkpoints[slotName])
+}
+
+func TestSubscriptionRequiresHandlerOrSink(t *testing.T) {
+	conn, _ := newFakeWalSender(t)
+	sub := pglogrepl.NewSubscription(conn, pglogrepl.SubscriptionOptions{
+		SlotName:        slotName,
+		PublicationName: "pub",
+		Handler:         func(context.Context, *pglogrepl.ReplicationMessage) error { return nil },
+		Sink:            newTestSink(),
+	})
+	assert.Error(t, sub.Run(context.Background()))
+}
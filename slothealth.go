@@ -0,0 +1,219 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ReplicationSlotInfo is the state of a replication slot as reported by pg_replication_slots.
+type ReplicationSlotInfo struct {
+	SlotName string
+	// Plugin is the output plugin of a logical slot. It is empty for a physical slot.
+	Plugin string
+	// SlotType is "logical" or "physical".
+	SlotType string
+	// Database is the database of a logical slot. It is empty for a physical slot.
+	Database  string
+	Temporary bool
+	Active    bool
+	// ActivePID is the process ID of the session using the slot, or 0 if it is not active.
+	ActivePID uint32
+	// RestartLSN is the oldest WAL position the slot still requires.
+	RestartLSN LSN
+	// ConfirmedFlushLSN is the position up to which the consumer of a logical slot has confirmed
+	// receiving data. It is 0 for a physical slot.
+	ConfirmedFlushLSN LSN
+	// WALStatus is the availability of the WAL required by the slot: "reserved", "extended",
+	// "unreserved" or "lost". It is empty before PostgreSQL 13.
+	WALStatus string
+	// SafeWALSize is the number of bytes of WAL that can be written before the slot is in danger
+	// of losing required WAL. It is -1 if max_slot_wal_keep_size is unlimited or before
+	// PostgreSQL 13.
+	SafeWALSize int64
+}
+
+// Lag returns the number of bytes of WAL between the position the consumer of the slot has
+// confirmed, the restart position for a physical slot, and current, typically the result of
+// CurrentWALLSN. It is 0 if the slot is not behind current.
+func (s ReplicationSlotInfo) Lag(current LSN) uint64 {
+	confirmed := s.ConfirmedFlushLSN
+	if s.SlotType == "physical" {
+		confirmed = s.RestartLSN
+	}
+	if current <= confirmed {
+		return 0
+	}
+	return uint64(current - confirmed)
+}
+
+// ListReplicationSlots reads the replication slots of the server from pg_replication_slots. conn
+// must be a regular connection; the connection of a pgx.Conn is returned by its PgConn method.
+func ListReplicationSlots(ctx context.Context, conn *pgconn.PgConn) ([]ReplicationSlotInfo, error) {
+	return listReplicationSlots(ctx, conn, "")
+}
+
+// ReadReplicationSlotInfo reads the state of the replication slot slotName from
+// pg_replication_slots. conn must be a regular connection.
+func ReadReplicationSlotInfo(ctx context.Context, conn *pgconn.PgConn, slotName string) (ReplicationSlotInfo, error) {
+	slots, err := listReplicationSlots(ctx, conn, " WHERE slot_name = "+quoteLiteral(slotName))
+	if err != nil {
+		return ReplicationSlotInfo{}, err
+	}
+	if len(slots) == 0 {
+		return ReplicationSlotInfo{}, fmt.Errorf("replication slot %s does not exist", slotName)
+	}
+	return slots[0], nil
+}
+
+func listReplicationSlots(ctx context.Context, conn *pgconn.PgConn, where string) ([]ReplicationSlotInfo, error) {
+	serverVersion, err := serverMajorVersion(conn)
+	if err != nil {
+		return nil, err
+	}
+	walColumns := "coalesce(wal_status, ''), coalesce(safe_wal_size, -1)"
+	if serverVersion < 13 {
+		walColumns = "'', -1"
+	}
+	sql := "SELECT slot_name, coalesce(plugin, ''), slot_type, coalesce(database, ''), temporary, active, coalesce(active_pid, 0), " +
+		"coalesce(restart_lsn, '0/0'), coalesce(confirmed_flush_lsn, '0/0'), " + walColumns + " FROM pg_replication_slots" + where
+	rows, err := queryRows(ctx, conn, sql, 11)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := make([]ReplicationSlotInfo, 0, len(rows))
+	for _, row := range rows {
+		slot := ReplicationSlotInfo{
+			SlotName:  string(row[0]),
+			Plugin:    string(row[1]),
+			SlotType:  string(row[2]),
+			Database:  string(row[3]),
+			Temporary: string(row[4]) == "t",
+			Active:    string(row[5]) == "t",
+			WALStatus: string(row[9]),
+		}
+		pid, err := strconv.ParseUint(string(row[6]), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse active_pid as uint32: %w", err)
+		}
+		slot.ActivePID = uint32(pid)
+		if slot.RestartLSN, err = ParseLSN(string(row[7])); err != nil {
+			return nil, fmt.Errorf("failed to parse restart_lsn as LSN: %w", err)
+		}
+		if slot.ConfirmedFlushLSN, err = ParseLSN(string(row[8])); err != nil {
+			return nil, fmt.Errorf("failed to parse confirmed_flush_lsn as LSN: %w", err)
+		}
+		if slot.SafeWALSize, err = strconv.ParseInt(string(row[10]), 10, 64); err != nil {
+			return nil, fmt.Errorf("failed to parse safe_wal_size as int64: %w", err)
+		}
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
+
+// ReplicationStat is the state of a WAL sender process as reported by pg_stat_replication.
+type ReplicationStat struct {
+	PID             uint32
+	ApplicationName string
+	// ClientAddr is the IP address of the client, or empty for a Unix socket connection.
+	ClientAddr string
+	// State is the state of the WAL sender, such as "streaming" or "catchup".
+	State string
+	// SentLSN, WriteLSN, FlushLSN and ReplayLSN are the positions sent to the client and reported
+	// by it as written, flushed and applied.
+	SentLSN   LSN
+	WriteLSN  LSN
+	FlushLSN  LSN
+	ReplayLSN LSN
+	// WriteLag, FlushLag and ReplayLag are the times between flushing WAL locally and the client
+	// reporting it as written, flushed and applied. They are 0 when the client is caught up.
+	WriteLag  time.Duration
+	FlushLag  time.Duration
+	ReplayLag time.Duration
+	// SyncState is the synchronous state of the client, such as "async" or "sync".
+	SyncState string
+}
+
+// ListReplicationStats reads the state of the WAL senders of the server from
+// pg_stat_replication. The WAL sender of an active slot has the slot's ActivePID. conn must be a
+// regular connection.
+func ListReplicationStats(ctx context.Context, conn *pgconn.PgConn) ([]ReplicationStat, error) {
+	lag := func(column string) string {
+		return "coalesce((extract(epoch from " + column + ") * 1000000)::bigint, 0)"
+	}
+	sql := "SELECT pid, coalesce(application_name, ''), coalesce(host(client_addr), ''), coalesce(state, ''), " +
+		"coalesce(sent_lsn, '0/0'), coalesce(write_lsn, '0/0'), coalesce(flush_lsn, '0/0'), coalesce(replay_lsn, '0/0'), " +
+		lag("write_lag") + ", " + lag("flush_lag") + ", " + lag("replay_lag") + ", coalesce(sync_state, '') FROM pg_stat_replication"
+	rows, err := queryRows(ctx, conn, sql, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]ReplicationStat, 0, len(rows))
+	for _, row := range rows {
+		stat := ReplicationStat{
+			ApplicationName: string(row[1]),
+			ClientAddr:      string(row[2]),
+			State:           string(row[3]),
+			SyncState:       string(row[11]),
+		}
+		pid, err := strconv.ParseUint(string(row[0]), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pid as uint32: %w", err)
+		}
+		stat.PID = uint32(pid)
+		for i, lsn := range []*LSN{&stat.SentLSN, &stat.WriteLSN, &stat.FlushLSN, &stat.ReplayLSN} {
+			if *lsn, err = ParseLSN(string(row[4+i])); err != nil {
+				return nil, fmt.Errorf("failed to parse LSN: %w", err)
+			}
+		}
+		for i, d := range []*time.Duration{&stat.WriteLag, &stat.FlushLag, &stat.ReplayLag} {
+			us, err := strconv.ParseInt(string(row[8+i]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse lag: %w", err)
+			}
+			*d = time.Duration(us) * time.Microsecond
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// CurrentWALLSN returns the current WAL write position of the server with pg_current_wal_lsn.
+// conn must be a regular connection to a primary.
+func CurrentWALLSN(ctx context.Context, conn *pgconn.PgConn) (LSN, error) {
+	rows, err := queryRows(ctx, conn, "SELECT pg_current_wal_lsn()", 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) != 1 {
+		return 0, fmt.Errorf("expected 1 row, got %d", len(rows))
+	}
+	lsn, err := ParseLSN(string(rows[0][0]))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse pg_current_wal_lsn as LSN: %w", err)
+	}
+	return lsn, nil
+}
+
+// queryRows runs a query returning a single result set of rows with the given number of
+// columns.
+func queryRows(ctx context.Context, conn *pgconn.PgConn, sql string, columns int) ([][][]byte, error) {
+	results, err := conn.Exec(ctx, sql).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("expected 1 result set, got %d", len(results))
+	}
+	for _, row := range results[0].Rows {
+		if len(row) != columns {
+			return nil, fmt.Errorf("expected %d result columns, got %d", columns, len(row))
+		}
+	}
+	return results[0].Rows, nil
+}
@@ -0,0 +1,109 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rowsResponse(columns int, rows ...[]string) []pgproto3.BackendMessage {
+	fields := make([]pgproto3.FieldDescription, columns)
+	for i := range fields {
+		fields[i].Name = []byte("column")
+	}
+	msgs := []pgproto3.BackendMessage{&pgproto3.RowDescription{Fields: fields}}
+	for _, row := range rows {
+		values := make([][]byte, len(row))
+		for i, value := range row {
+			values[i] = []byte(value)
+		}
+		msgs = append(msgs, &pgproto3.DataRow{Values: values})
+	}
+	return append(msgs,
+		&pgproto3.CommandComplete{CommandTag: []byte("SELECT")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	)
+}
+
+func TestReadReplicationSlotInfo(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	queries := ws.serveQuery(rowsResponse(11, []string{slotName, "pgoutput", "logical", "postgres", "f", "t", "4242", "0/1500", "0/1600", "extended", "-1"}))
+	slot, err := pglogrepl.ReadReplicationSlotInfo(ctx, conn, slotName)
+	require.NoError(t, err)
+	query := <-queries
+	assert.Contains(t, query, "coalesce(wal_status, '')")
+	assert.True(t, strings.HasSuffix(query, "FROM pg_replication_slots WHERE slot_name = '"+slotName+"'"), query)
+	assert.Equal(t, pglogrepl.ReplicationSlotInfo{
+		SlotName:          slotName,
+		Plugin:            "pgoutput",
+		SlotType:          "logical",
+		Database:          "postgres",
+		Active:            true,
+		ActivePID:         4242,
+		RestartLSN:        0x1500,
+		ConfirmedFlushLSN: 0x1600,
+		WALStatus:         "extended",
+		SafeWALSize:       -1,
+	}, slot)
+	assert.Equal(t, uint64(0x100), slot.Lag(0x1700))
+	assert.Equal(t, uint64(0), slot.Lag(0x1600))
+
+	ws.serveQuery(rowsResponse(11))
+	_, err = pglogrepl.ReadReplicationSlotInfo(ctx, conn, "missing")
+	require.Error(t, err)
+}
+
+func TestListReplicationSlotsBeforePG13(t *testing.T) {
+	conn, ws := newFakeWalSenderVersion(t, "12.4")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	queries := ws.serveQuery(rowsResponse(11, []string{"physical_slot", "", "physical", "", "f", "f", "0", "0/3000000", "0/0", "", "-1"}))
+	slots, err := pglogrepl.ListReplicationSlots(ctx, conn)
+	require.NoError(t, err)
+	assert.NotContains(t, <-queries, "wal_status")
+	require.Len(t, slots, 1)
+	assert.Equal(t, "physical", slots[0].SlotType)
+	assert.Equal(t, uint64(0x500), slots[0].Lag(0x3000500))
+}
+
+func TestListReplicationStats(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	ws.serveQuery(rowsResponse(12, []string{"4242", "subscriber", "10.0.0.1", "streaming", "0/1700", "0/1600", "0/1500", "0/1400", "1500", "0", "2000000", "async"}))
+	stats, err := pglogrepl.ListReplicationStats(ctx, conn)
+	require.NoError(t, err)
+	assert.Equal(t, []pglogrepl.ReplicationStat{{
+		PID:             4242,
+		ApplicationName: "subscriber",
+		ClientAddr:      "10.0.0.1",
+		State:           "streaming",
+		SentLSN:         0x1700,
+		WriteLSN:        0x1600,
+		FlushLSN:        0x1500,
+		ReplayLSN:       0x1400,
+		WriteLag:        1500 * time.Microsecond,
+		ReplayLag:       2 * time.Second,
+		SyncState:       "async",
+	}}, stats)
+
+	queries := ws.serveQuery(rowsResponse(1, []string{"0/1800"}))
+	lsn, err := pglogrepl.CurrentWALLSN(ctx, conn)
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT pg_current_wal_lsn()", <-queries)
+	assert.Equal(t, pglogrepl.LSN(0x1800), lsn)
+}
@@ -0,0 +1,94 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SnapshotTx is a transaction of a regular connection that imported the snapshot exported by the
+// creation of a logical replication slot. It sees the data exactly as of the slot's consistent
+// point, so a copy of the tables made in it followed by streaming from the consistent point
+// contains every change once, like the initial synchronization of CREATE SUBSCRIPTION.
+type SnapshotTx struct {
+	conn *pgconn.PgConn
+}
+
+// BeginSnapshot begins a read only REPEATABLE READ transaction on conn, which must be a regular
+// connection to the database of the slot, and imports the snapshot snapshotName. The connection
+// of a pgx.Conn is returned by its PgConn method.
+//
+// The snapshot is only exported until the replication connection that created the slot with
+// SnapshotActionExport runs another command, so BeginSnapshot must be called before replication
+// is started. Once imported, the snapshot remains valid until the transaction ends.
+func BeginSnapshot(ctx context.Context, conn *pgconn.PgConn, snapshotName string) (*SnapshotTx, error) {
+	if snapshotName == "" {
+		return nil, fmt.Errorf("no snapshot name")
+	}
+	sql := "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY; SET TRANSACTION SNAPSHOT " + quoteLiteral(snapshotName)
+	if _, err := conn.Exec(ctx, sql).ReadAll(); err != nil {
+		// The transaction has begun even if the snapshot could not be imported.
+		conn.Exec(ctx, "ROLLBACK").ReadAll()
+		return nil, fmt.Errorf("failed to import snapshot: %w", err)
+	}
+	return &SnapshotTx{conn: conn}, nil
+}
+
+// Conn returns the connection of the transaction.
+func (tx *SnapshotTx) Conn() *pgconn.PgConn {
+	return tx.conn
+}
+
+// CopyTableOptions configures SnapshotTx.CopyTable.
+type CopyTableOptions struct {
+	// Columns are the columns to copy. If it is empty all columns are copied.
+	Columns []string
+	// Where, if set, is an SQL condition restricting the rows to copy, such as "tenant_id = 42".
+	Where string
+	// Format is the COPY format: "text", "csv" or "binary". If it is empty "text" is used.
+	Format string
+}
+
+// CopyTable copies the contents of table, an optionally schema qualified name such as
+// "public.users", to w with COPY TO STDOUT. It returns the number of rows copied.
+func (tx *SnapshotTx) CopyTable(ctx context.Context, w io.Writer, table string, options CopyTableOptions) (int64, error) {
+	var columns string
+	if len(options.Columns) > 0 {
+		quoted := make([]string, len(options.Columns))
+		for i, column := range options.Columns {
+			quoted[i] = quoteIdentifier(column)
+		}
+		columns = strings.Join(quoted, ", ")
+	}
+
+	var sql string
+	if options.Where != "" {
+		// Rows can only be filtered by copying a query.
+		if columns == "" {
+			columns = "*"
+		}
+		sql = "COPY (SELECT " + columns + " FROM " + quoteQualifiedIdentifier(table) + " WHERE " + options.Where + ") TO STDOUT"
+	} else if columns != "" {
+		sql = "COPY " + quoteQualifiedIdentifier(table) + " (" + columns + ") TO STDOUT"
+	} else {
+		sql = "COPY " + quoteQualifiedIdentifier(table) + " TO STDOUT"
+	}
+	if options.Format != "" {
+		sql += " WITH (FORMAT " + quoteLiteral(options.Format) + ")"
+	}
+
+	tag, err := tx.conn.CopyTo(ctx, w, sql)
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy %s: %w", table, err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Commit ends the transaction.
+func (tx *SnapshotTx) Commit(ctx context.Context) error {
+	_, err := tx.conn.Exec(ctx, "COMMIT").ReadAll()
+	return err
+}
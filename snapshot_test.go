@@ -0,0 +1,91 @@
+package pglogrepl_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotTx(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	delete(config.RuntimeParams, "replication")
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	_, err = conn.Exec(ctx, "drop table if exists pglogrepl_snapshot; create table pglogrepl_snapshot (id int, name text); insert into pglogrepl_snapshot values (1, 'foo')").ReadAll()
+	require.NoError(t, err)
+	defer conn.Exec(context.Background(), "drop table if exists pglogrepl_snapshot").ReadAll()
+
+	replConn, err := pgconn.Connect(ctx, os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, replConn)
+	result, err := pglogrepl.CreateReplicationSlot(ctx, replConn, slotName, outputPlugin, pglogrepl.CreateReplicationSlotOptions{Temporary: true, SnapshotAction: pglogrepl.SnapshotActionExport})
+	require.NoError(t, err)
+	require.NotEmpty(t, result.SnapshotName)
+
+	// A row inserted after the consistent point is not part of the snapshot.
+	otherConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	defer closeConn(t, otherConn)
+	_, err = otherConn.Exec(ctx, "insert into pglogrepl_snapshot values (2, 'bar')").ReadAll()
+	require.NoError(t, err)
+
+	tx, err := pglogrepl.BeginSnapshot(ctx, conn, result.SnapshotName)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	n, err := tx.CopyTable(ctx, &buf, "public.pglogrepl_snapshot", pglogrepl.CopyTableOptions{Columns: []string{"name"}})
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit(ctx))
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, "foo\n", buf.String())
+}
+
+func TestSnapshotTxFake(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn, ws := newFakeWalSender(t)
+	queries := ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.CommandComplete{CommandTag: []byte("BEGIN")},
+		&pgproto3.CommandComplete{CommandTag: []byte("SET")},
+		&pgproto3.ReadyForQuery{TxStatus: 'T'},
+	})
+	tx, err := pglogrepl.BeginSnapshot(ctx, conn, "00000003-00000002-1")
+	require.NoError(t, err)
+	assert.Equal(t, "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY; SET TRANSACTION SNAPSHOT '00000003-00000002-1'", <-queries)
+
+	queries = ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.CopyOutResponse{OverallFormat: 0, ColumnFormatCodes: []uint16{0, 0}},
+		&pgproto3.CopyData{Data: []byte("1,foo\n")},
+		&pgproto3.CopyData{Data: []byte("2,bar\n")},
+		&pgproto3.CopyDone{},
+		&pgproto3.CommandComplete{CommandTag: []byte("COPY 2")},
+		&pgproto3.ReadyForQuery{TxStatus: 'T'},
+	})
+	var buf bytes.Buffer
+	n, err := tx.CopyTable(ctx, &buf, "public.Users", pglogrepl.CopyTableOptions{Columns: []string{"id", "name"}, Format: "csv"})
+	require.NoError(t, err)
+	assert.Equal(t, `COPY "public"."Users" ("id", "name") TO STDOUT WITH (FORMAT 'csv')`, <-queries)
+	assert.Equal(t, int64(2), n)
+	assert.Equal(t, "1,foo\n2,bar\n", buf.String())
+
+	queries = ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.CommandComplete{CommandTag: []byte("COMMIT")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	require.NoError(t, tx.Commit(ctx))
+	assert.Equal(t, "COMMIT", <-queries)
+}
@@ -0,0 +1,238 @@
+package pglogrepl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const defaultSpillSegmentSize = 16 << 20
+
+// spillRecordHeaderSize is the size of the header of a spilled message: its WALStart,
+// ServerWALEnd and ServerTime, its flags and the length of the WAL data stored.
+const spillRecordHeaderSize = 8 + 8 + 8 + 1 + 4
+
+// The flags of a spilled message.
+const (
+	// spillInStream is set if the message was received in a stream block.
+	spillInStream = 1 << iota
+	// spillCompressed is set if the WAL data is stored compressed by the codec of the spill.
+	spillCompressed
+)
+
+// spillSegment is a file of spilled messages named after the WALStart of its first message.
+type spillSegment struct {
+	name    string
+	records int
+	size    int64
+}
+
+// walSpill is the on-disk part of the queue of a Pipeline, a sequence of segment files the oldest
+// of which is read while the newest is appended to. Segments are removed once they are read.
+type walSpill struct {
+	dir          string
+	segmentSize  int64
+	protoVersion int
+	// codec, if set, compresses the WAL data of every message on its own.
+	codec Codec
+
+	segments []*spillSegment
+	// writer appends to the last segment.
+	writer *os.File
+	// reader reads the first segment, of which read records, the first readOffset bytes, have
+	// been read.
+	reader     *os.File
+	readBuffer *bufio.Reader
+	read       int
+	readOffset int64
+	// records and size are the totals of the unread segments.
+	records int
+	size    int64
+	seq     int
+}
+
+func newWALSpill(dir string, segmentSize int64, protoVersion int, codec Codec) *walSpill {
+	if segmentSize <= 0 {
+		segmentSize = defaultSpillSegmentSize
+	}
+	return &walSpill{dir: dir, segmentSize: segmentSize, protoVersion: protoVersion, codec: codec}
+}
+
+// write appends msg, received in a stream block if inStream is set, to the newest segment.
+func (w *walSpill) write(msg *ReplicationMessage, inStream bool) error {
+	if w.writer == nil || w.segments[len(w.segments)-1].size >= w.segmentSize {
+		if err := w.startSegment(msg.WALStart); err != nil {
+			return err
+		}
+	}
+
+	data := make([]byte, spillRecordHeaderSize, spillRecordHeaderSize+len(msg.WALData))
+	binary.BigEndian.PutUint64(data, uint64(msg.WALStart))
+	binary.BigEndian.PutUint64(data[8:], uint64(msg.ServerWALEnd))
+	binary.BigEndian.PutUint64(data[16:], uint64(timeToPgTime(msg.ServerTime)))
+	if inStream {
+		data[24] |= spillInStream
+	}
+	if w.codec != nil {
+		compressed, err := w.codec.Compress(data, msg.WALData)
+		if err != nil {
+			return fmt.Errorf("failed to spill message: %w", err)
+		}
+		// Data that compression does not make smaller, such as a short message, is stored as is.
+		if len(compressed)-spillRecordHeaderSize < len(msg.WALData) {
+			data = compressed
+			data[24] |= spillCompressed
+		}
+	}
+	if data[24]&spillCompressed == 0 {
+		data = append(data, msg.WALData...)
+	}
+	binary.BigEndian.PutUint32(data[25:], uint32(len(data)-spillRecordHeaderSize))
+	if _, err := w.writer.Write(data); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+
+	segment := w.segments[len(w.segments)-1]
+	segment.records++
+	segment.size += int64(len(data))
+	w.records++
+	w.size += int64(len(data))
+	return nil
+}
+
+func (w *walSpill) startSegment(lsn LSN) error {
+	if w.writer != nil {
+		if err := w.writer.Close(); err != nil {
+			return fmt.Errorf("failed to close spill file: %w", err)
+		}
+	}
+	w.seq++
+	name := filepath.Join(w.dir, fmt.Sprintf("%016X-%d.spill", uint64(lsn), w.seq))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create spill file: %w", err)
+	}
+	w.writer = f
+	w.segments = append(w.segments, &spillSegment{name: name})
+	return nil
+}
+
+// next reads the oldest message. There must be one. If it fails the reader is closed, so that
+// the next call reads the message again from its start.
+func (w *walSpill) next() (*ReplicationMessage, error) {
+	segment := w.segments[0]
+	if w.reader == nil {
+		f, err := os.Open(segment.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open spill file: %w", err)
+		}
+		if _, err := f.Seek(w.readOffset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to seek spill file: %w", err)
+		}
+		w.reader = f
+		w.readBuffer = bufio.NewReader(f)
+	}
+
+	msg, storedSize, err := w.readMessage()
+	if err != nil {
+		// The reader may have stopped within the message.
+		w.closeReader()
+		return nil, err
+	}
+	w.read++
+	w.readOffset += int64(spillRecordHeaderSize + storedSize)
+	w.records--
+	w.size -= int64(spillRecordHeaderSize + storedSize)
+	if w.read == segment.records && (len(w.segments) > 1 || w.records == 0) {
+		if err := w.removeSegment(); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// readMessage reads the message at the reader and returns it with the size of its WAL data as
+// stored.
+func (w *walSpill) readMessage() (*ReplicationMessage, int, error) {
+	header := make([]byte, spillRecordHeaderSize)
+	if _, err := io.ReadFull(w.readBuffer, header); err != nil {
+		return nil, 0, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	walData := make([]byte, binary.BigEndian.Uint32(header[25:]))
+	if _, err := io.ReadFull(w.readBuffer, walData); err != nil {
+		return nil, 0, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	storedSize := len(walData)
+	if header[24]&spillCompressed != 0 {
+		var err error
+		if walData, err = w.codec.Decompress(nil, walData); err != nil {
+			return nil, 0, fmt.Errorf("failed to read spilled message: %w", err)
+		}
+	}
+	msg := &ReplicationMessage{XLogData: XLogData{
+		WALStart:     LSN(binary.BigEndian.Uint64(header)),
+		ServerWALEnd: LSN(binary.BigEndian.Uint64(header[8:])),
+		ServerTime:   pgTimeToTime(int64(binary.BigEndian.Uint64(header[16:]))),
+		WALData:      walData,
+	}}
+	if w.protoVersion > 0 {
+		var err error
+		msg.Message, err = parseMessage(w.protoVersion, walData, header[24]&spillInStream != 0)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse spilled message: %w", err)
+		}
+	}
+	return msg, storedSize, nil
+}
+
+func (w *walSpill) closeReader() {
+	if w.reader != nil {
+		w.reader.Close()
+		w.reader = nil
+		w.readBuffer = nil
+	}
+}
+
+// removeSegment removes the oldest segment once it is read.
+func (w *walSpill) removeSegment() error {
+	w.closeReader()
+	w.read = 0
+	w.readOffset = 0
+	if len(w.segments) == 1 {
+		// The segment being written is read, the next message starts a new one.
+		w.writer.Close()
+		w.writer = nil
+	}
+	name := w.segments[0].name
+	w.segments[0] = nil
+	w.segments = w.segments[1:]
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("failed to remove spill file: %w", err)
+	}
+	return nil
+}
+
+// close removes all segments.
+func (w *walSpill) close() error {
+	w.closeReader()
+	w.read = 0
+	w.readOffset = 0
+	if w.writer != nil {
+		w.writer.Close()
+		w.writer = nil
+	}
+	var err error
+	for _, segment := range w.segments {
+		if rerr := os.Remove(segment.name); rerr != nil && err == nil {
+			err = fmt.Errorf("failed to remove spill file: %w", rerr)
+		}
+	}
+	w.segments = nil
+	w.records = 0
+	w.size = 0
+	return err
+}
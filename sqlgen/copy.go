@@ -0,0 +1,139 @@
+package sqlgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// CopyStatement is a COPY FROM STDIN statement with its data.
+type CopyStatement struct {
+	SQL  string
+	Data []byte
+}
+
+// Exec executes the statement on conn, a regular connection.
+func (s *CopyStatement) Exec(ctx context.Context, conn *pgconn.PgConn) (pgconn.CommandTag, error) {
+	return conn.CopyFrom(ctx, bytes.NewReader(s.Data), s.SQL)
+}
+
+// copyBinarySignature starts the header of the binary COPY format.
+const copyBinarySignature = "PGCOPY\n\377\r\n\000"
+
+// CopyFormat returns the COPY format tuple can be copied in, 0 for text and 1 for binary: its
+// values must all be in that format or NULL, as they are passed through without being decoded. A
+// tuple of NULLs only is copied in text format. It returns false if tuple mixes formats or has
+// unchanged TOAST values.
+func CopyFormat(tuple *pglogrepl.TupleData) (int16, bool) {
+	if tuple == nil {
+		return 0, false
+	}
+	format := int16(-1)
+	for _, col := range tuple.Columns {
+		var f int16
+		switch col.DataType {
+		case pglogrepl.TupleDataTypeNull:
+			continue
+		case pglogrepl.TupleDataTypeText:
+		case pglogrepl.TupleDataTypeBinary:
+			f = 1
+		default:
+			return 0, false
+		}
+		if format >= 0 && f != format {
+			return 0, false
+		}
+		format = f
+	}
+	if format < 0 {
+		format = 0
+	}
+	return format, true
+}
+
+// Copy returns the COPY statement inserting the tuples of msgs, inserts into rel, in one round
+// trip. The tuples must share their format, see CopyFormat, which is then the format of the
+// COPY. Unlike INSERT, COPY always writes the values of identity columns.
+func Copy(rel *pglogrepl.RelationMessage, msgs []*pglogrepl.InsertMessage, options Options) (*CopyStatement, error) {
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("copy into %s has no rows", QuoteTable(rel))
+	}
+	var format int16
+	for i, msg := range msgs {
+		if err := checkTuple(rel, msg.Tuple); err != nil {
+			return nil, err
+		}
+		f, ok := CopyFormat(msg.Tuple)
+		if !ok || (i > 0 && f != format) {
+			return nil, fmt.Errorf("tuples of %s cannot be copied in the same format", QuoteTable(rel))
+		}
+		format = f
+	}
+
+	columns := make([]string, len(rel.Columns))
+	for i, col := range rel.Columns {
+		columns[i] = QuoteIdentifier(col.Name)
+	}
+	sql := "COPY " + options.tableName(rel) + " (" + strings.Join(columns, ", ") + ") FROM STDIN"
+	if format == 1 {
+		return &CopyStatement{SQL: sql + " WITH (FORMAT binary)", Data: copyBinary(msgs)}, nil
+	}
+	return &CopyStatement{SQL: sql, Data: copyText(msgs)}, nil
+}
+
+func copyBinary(msgs []*pglogrepl.InsertMessage) []byte {
+	buf := make([]byte, 0, 64*len(msgs))
+	buf = append(buf, copyBinarySignature...)
+	// The flags and the length of the header extension.
+	buf = binary.BigEndian.AppendUint32(buf, 0)
+	buf = binary.BigEndian.AppendUint32(buf, 0)
+	for _, msg := range msgs {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(msg.Tuple.Columns)))
+		for _, col := range msg.Tuple.Columns {
+			if col.DataType == pglogrepl.TupleDataTypeNull {
+				buf = binary.BigEndian.AppendUint32(buf, 0xFFFFFFFF)
+				continue
+			}
+			buf = binary.BigEndian.AppendUint32(buf, uint32(len(col.Data)))
+			buf = append(buf, col.Data...)
+		}
+	}
+	// The trailer, a field count of -1.
+	return binary.BigEndian.AppendUint16(buf, 0xFFFF)
+}
+
+func copyText(msgs []*pglogrepl.InsertMessage) []byte {
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		for i, col := range msg.Tuple.Columns {
+			if i > 0 {
+				buf.WriteByte('\t')
+			}
+			if col.DataType == pglogrepl.TupleDataTypeNull {
+				buf.WriteString(`\N`)
+				continue
+			}
+			for _, b := range col.Data {
+				switch b {
+				case '\\':
+					buf.WriteString(`\\`)
+				case '\t':
+					buf.WriteString(`\t`)
+				case '\n':
+					buf.WriteString(`\n`)
+				case '\r':
+					buf.WriteString(`\r`)
+				default:
+					buf.WriteByte(b)
+				}
+			}
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,72 @@
+package sqlgen_test
+
+import (
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/sqlgen"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyFormat(t *testing.T) {
+	binary := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeBinary, Length: 4, Data: []byte{0, 0, 0, 1}}
+	for _, tt := range []struct {
+		tuple  *pglogrepl.TupleData
+		format int16
+		ok     bool
+	}{
+		{tuple(text("1"), text("a"), null), 0, true},
+		{tuple(binary, null, binary), 1, true},
+		{tuple(null, null, null), 0, true},
+		{tuple(binary, text("a"), null), 0, false},
+		{tuple(text("1"), toast, null), 0, false},
+		{nil, 0, false},
+	} {
+		format, ok := sqlgen.CopyFormat(tt.tuple)
+		assert.Equal(t, tt.ok, ok)
+		assert.Equal(t, tt.format, format)
+	}
+}
+
+func TestCopyText(t *testing.T) {
+	msgs := []*pglogrepl.InsertMessage{
+		{Tuple: tuple(text("1"), text("a\tb\\c\r\nd"), null)},
+		{Tuple: tuple(text("2"), text("e"), text(""))},
+	}
+	stmt, err := sqlgen.Copy(testRelation(), msgs, sqlgen.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, `COPY "public"."my""table" ("id", "name", "doc") FROM STDIN`, stmt.SQL)
+	assert.Equal(t, "1\ta\\tb\\\\c\\r\\nd\t\\N\n2\te\t\n", string(stmt.Data))
+}
+
+func TestCopyBinary(t *testing.T) {
+	binary := func(b ...byte) *pglogrepl.TupleDataColumn {
+		return &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeBinary, Length: uint32(len(b)), Data: b}
+	}
+	msgs := []*pglogrepl.InsertMessage{{Tuple: tuple(binary(0, 0, 0, 1), binary('a'), null)}}
+	stmt, err := sqlgen.Copy(testRelation(), msgs, sqlgen.Options{
+		TableName: func(rel *pglogrepl.RelationMessage) string { return `"archive"."t"` },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `COPY "archive"."t" ("id", "name", "doc") FROM STDIN WITH (FORMAT binary)`, stmt.SQL)
+	assert.Equal(t, []byte("PGCOPY\n\377\r\n\000"+
+		"\x00\x00\x00\x00\x00\x00\x00\x00"+
+		"\x00\x03"+
+		"\x00\x00\x00\x04\x00\x00\x00\x01"+
+		"\x00\x00\x00\x01a"+
+		"\xff\xff\xff\xff"+
+		"\xff\xff"), stmt.Data)
+}
+
+func TestCopyMixedFormats(t *testing.T) {
+	binary := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeBinary, Length: 4, Data: []byte{0, 0, 0, 1}}
+	msgs := []*pglogrepl.InsertMessage{
+		{Tuple: tuple(text("1"), text("a"), null)},
+		{Tuple: tuple(binary, null, null)},
+	}
+	_, err := sqlgen.Copy(testRelation(), msgs, sqlgen.Options{})
+	assert.Error(t, err)
+	_, err = sqlgen.Copy(testRelation(), nil, sqlgen.Options{})
+	assert.Error(t, err)
+}
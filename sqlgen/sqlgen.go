@@ -0,0 +1,325 @@
+// Package sqlgen generates the SQL statements that apply decoded pgoutput changes to another
+// database.
+//
+// The statements are parameterized with the column data of the change as sent by the server, so
+// text and binary formatted data is passed through without being decoded. The parameter types
+// are left to the server to infer from the target columns, which keeps the statements valid when
+// type OIDs differ between the source and the target database.
+package sqlgen
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Statement is a parameterized SQL statement.
+type Statement struct {
+	SQL string
+	// Params are the parameter values. A nil value is NULL.
+	Params [][]byte
+	// ParamFormats are the formats of the parameter values, 0 for text and 1 for binary.
+	ParamFormats []int16
+}
+
+// Exec executes the statement on conn, which must be a regular connection as statements are
+// executed with the extended query protocol.
+func (s *Statement) Exec(ctx context.Context, conn *pgconn.PgConn) *pgconn.ResultReader {
+	return conn.ExecParams(ctx, s.SQL, s.Params, nil, s.ParamFormats, nil)
+}
+
+func (s *Statement) addParam(col *pglogrepl.TupleDataColumn) string {
+	var format int16
+	if col.DataType == pglogrepl.TupleDataTypeBinary {
+		format = 1
+	}
+	var value []byte
+	if col.DataType != pglogrepl.TupleDataTypeNull {
+		value = col.Data
+	}
+	s.Params = append(s.Params, value)
+	s.ParamFormats = append(s.ParamFormats, format)
+	return "$" + strconv.Itoa(len(s.Params))
+}
+
+// RelationSource returns the relation with a relation ID, as described by the last
+// RelationMessage received for it. It is implemented by pglogrepl.RelationCache.
+type RelationSource interface {
+	Relation(relationID uint32) (*pglogrepl.RelationMessage, bool)
+}
+
+// RelationMap is a RelationSource keyed by relation ID.
+type RelationMap map[uint32]*pglogrepl.RelationMessage
+
+// Relation implements RelationSource.
+func (m RelationMap) Relation(relationID uint32) (*pglogrepl.RelationMessage, bool) {
+	rel, ok := m[relationID]
+	return rel, ok
+}
+
+// Options configures the generated statements.
+type Options struct {
+	// OverridingSystemValue adds OVERRIDING SYSTEM VALUE to INSERT statements so that values
+	// can be inserted into identity columns defined as GENERATED ALWAYS.
+	OverridingSystemValue bool
+	// Upsert makes inserts INSERT ... ON CONFLICT statements that update the row with the replica
+	// identity columns of the insert if it exists, so that changes delivered again after a crash
+	// are applied without conflicts. The target table must have a unique index on exactly the
+	// replica identity columns, such as its primary key. Inserts into relations without replica
+	// identity columns or with REPLICA IDENTITY FULL, which has no unique index to match, are
+	// plain inserts.
+	Upsert bool
+	// NoTruncateCascade leaves CASCADE out of TRUNCATE statements, like native subscriptions do:
+	// the tables referencing the truncated ones on the target are then not truncated, and the
+	// TRUNCATE fails if they are not truncated with them.
+	NoTruncateCascade bool
+	// TableName returns the target table of a relation. If it is nil the table with the same
+	// schema and name is used. The returned name must be quoted with QuoteTable or similar.
+	TableName func(rel *pglogrepl.RelationMessage) string
+}
+
+func (o Options) tableName(rel *pglogrepl.RelationMessage) string {
+	if o.TableName != nil {
+		return o.TableName(rel)
+	}
+	return QuoteTable(rel)
+}
+
+// Generate returns the statement applying msg, which must be an insert, update, delete or
+// truncate message of protocol version 1 or 2. It returns nil for other messages.
+func Generate(relations RelationSource, msg pglogrepl.Message, options Options) (*Statement, error) {
+	relation := func(relationID uint32) (*pglogrepl.RelationMessage, error) {
+		rel, ok := relations.Relation(relationID)
+		if !ok {
+			return nil, fmt.Errorf("unknown relation ID %d", relationID)
+		}
+		return rel, nil
+	}
+
+	switch msg := msg.(type) {
+	case *pglogrepl.InsertMessageV2:
+		return Generate(relations, &msg.InsertMessage, options)
+	case *pglogrepl.UpdateMessageV2:
+		return Generate(relations, &msg.UpdateMessage, options)
+	case *pglogrepl.DeleteMessageV2:
+		return Generate(relations, &msg.DeleteMessage, options)
+	case *pglogrepl.TruncateMessageV2:
+		return Generate(relations, &msg.TruncateMessage, options)
+
+	case *pglogrepl.InsertMessage:
+		rel, err := relation(msg.RelationID)
+		if err != nil {
+			return nil, err
+		}
+		return Insert(rel, msg, options)
+	case *pglogrepl.UpdateMessage:
+		rel, err := relation(msg.RelationID)
+		if err != nil {
+			return nil, err
+		}
+		return Update(rel, msg, options)
+	case *pglogrepl.DeleteMessage:
+		rel, err := relation(msg.RelationID)
+		if err != nil {
+			return nil, err
+		}
+		return Delete(rel, msg, options)
+	case *pglogrepl.TruncateMessage:
+		rels := make([]*pglogrepl.RelationMessage, len(msg.RelationIDs))
+		for i, relationID := range msg.RelationIDs {
+			rel, err := relation(relationID)
+			if err != nil {
+				return nil, err
+			}
+			rels[i] = rel
+		}
+		return Truncate(rels, msg, options)
+	}
+	return nil, nil
+}
+
+// Insert returns the INSERT statement for msg.
+func Insert(rel *pglogrepl.RelationMessage, msg *pglogrepl.InsertMessage, options Options) (*Statement, error) {
+	if err := checkTuple(rel, msg.Tuple); err != nil {
+		return nil, err
+	}
+
+	stmt := &Statement{}
+	var columns, values []string
+	for i, col := range msg.Tuple.Columns {
+		if col.DataType == pglogrepl.TupleDataTypeToast {
+			continue
+		}
+		columns = append(columns, QuoteIdentifier(rel.Columns[i].Name))
+		values = append(values, stmt.addParam(col))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(options.tableName(rel))
+	if len(columns) == 0 {
+		sb.WriteString(" DEFAULT VALUES")
+		stmt.SQL = sb.String()
+		return stmt, nil
+	}
+	sb.WriteString(" (")
+	sb.WriteString(strings.Join(columns, ", "))
+	sb.WriteString(")")
+	if options.OverridingSystemValue {
+		sb.WriteString(" OVERRIDING SYSTEM VALUE")
+	}
+	sb.WriteString(" VALUES (")
+	sb.WriteString(strings.Join(values, ", "))
+	sb.WriteString(")")
+	if options.Upsert {
+		sb.WriteString(upsertClause(rel, msg.Tuple))
+	}
+	stmt.SQL = sb.String()
+	return stmt, nil
+}
+
+// upsertClause returns the ON CONFLICT clause of an insert of tuple into rel with Options.Upsert,
+// or an empty string if rel has no replica identity columns to match.
+func upsertClause(rel *pglogrepl.RelationMessage, tuple *pglogrepl.TupleData) string {
+	if rel.ReplicaIdentity == pglogrepl.ReplicaIdentityFull {
+		return ""
+	}
+	var keys, set []string
+	for i, col := range tuple.Columns {
+		name := QuoteIdentifier(rel.Columns[i].Name)
+		if rel.Columns[i].Flags&1 != 0 {
+			keys = append(keys, name)
+		} else if col.DataType != pglogrepl.TupleDataTypeToast {
+			set = append(set, name+" = EXCLUDED."+name)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	clause := " ON CONFLICT (" + strings.Join(keys, ", ") + ")"
+	if len(set) == 0 {
+		return clause + " DO NOTHING"
+	}
+	return clause + " DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+// Update returns the UPDATE statement for msg. The row is identified by the old tuple if the
+// message has one, or else by the replica identity columns of the new tuple. Unchanged TOAST
+// columns are left out of the SET list.
+func Update(rel *pglogrepl.RelationMessage, msg *pglogrepl.UpdateMessage, options Options) (*Statement, error) {
+	if err := checkTuple(rel, msg.NewTuple); err != nil {
+		return nil, err
+	}
+
+	stmt := &Statement{}
+	var set []string
+	for i, col := range msg.NewTuple.Columns {
+		if col.DataType == pglogrepl.TupleDataTypeToast {
+			continue
+		}
+		set = append(set, QuoteIdentifier(rel.Columns[i].Name)+" = "+stmt.addParam(col))
+	}
+	if len(set) == 0 {
+		return nil, fmt.Errorf("update of %s has no changed columns", QuoteTable(rel))
+	}
+
+	where, err := whereClause(stmt, rel, msg.OldTupleType, msg.OldTuple, msg.NewTuple)
+	if err != nil {
+		return nil, err
+	}
+	stmt.SQL = "UPDATE " + options.tableName(rel) + " SET " + strings.Join(set, ", ") + " WHERE " + where
+	return stmt, nil
+}
+
+// Delete returns the DELETE statement for msg.
+func Delete(rel *pglogrepl.RelationMessage, msg *pglogrepl.DeleteMessage, options Options) (*Statement, error) {
+	stmt := &Statement{}
+	where, err := whereClause(stmt, rel, msg.OldTupleType, msg.OldTuple, nil)
+	if err != nil {
+		return nil, err
+	}
+	stmt.SQL = "DELETE FROM " + options.tableName(rel) + " WHERE " + where
+	return stmt, nil
+}
+
+// Truncate returns the TRUNCATE statement for msg, which truncates all of rels, the relations of
+// msg.RelationIDs, at once. The RESTART IDENTITY and CASCADE options of the source statement are
+// kept, see Options.NoTruncateCascade.
+func Truncate(rels []*pglogrepl.RelationMessage, msg *pglogrepl.TruncateMessage, options Options) (*Statement, error) {
+	if len(rels) == 0 {
+		return nil, fmt.Errorf("truncate has no relations")
+	}
+
+	tables := make([]string, len(rels))
+	for i, rel := range rels {
+		tables[i] = options.tableName(rel)
+	}
+	sql := "TRUNCATE TABLE " + strings.Join(tables, ", ")
+	if msg.Option&pglogrepl.TruncateOptionRestartIdentity != 0 {
+		sql += " RESTART IDENTITY"
+	}
+	if msg.Option&pglogrepl.TruncateOptionCascade != 0 && !options.NoTruncateCascade {
+		sql += " CASCADE"
+	}
+	return &Statement{SQL: sql}, nil
+}
+
+// whereClause returns the condition identifying the row of an update or delete. With REPLICA
+// IDENTITY FULL the old tuple holds every column, otherwise only the key columns are used.
+func whereClause(stmt *Statement, rel *pglogrepl.RelationMessage, oldTupleType uint8, oldTuple, newTuple *pglogrepl.TupleData) (string, error) {
+	tuple := oldTuple
+	if tuple == nil {
+		tuple = newTuple
+	}
+	if tuple == nil {
+		return "", fmt.Errorf("change of %s has no tuple identifying the row", QuoteTable(rel))
+	}
+	if err := checkTuple(rel, tuple); err != nil {
+		return "", err
+	}
+
+	var conditions []string
+	for i, col := range tuple.Columns {
+		relCol := rel.Columns[i]
+		if oldTupleType != pglogrepl.UpdateMessageTupleTypeOld && relCol.Flags&1 == 0 {
+			continue
+		}
+		name := QuoteIdentifier(relCol.Name)
+		switch col.DataType {
+		case pglogrepl.TupleDataTypeNull:
+			conditions = append(conditions, name+" IS NULL")
+		case pglogrepl.TupleDataTypeToast:
+			// An unchanged TOAST value is not sent, so the column cannot be compared.
+		default:
+			conditions = append(conditions, name+" = "+stmt.addParam(col))
+		}
+	}
+	if len(conditions) == 0 {
+		return "", fmt.Errorf("%s has no replica identity columns", QuoteTable(rel))
+	}
+	return strings.Join(conditions, " AND "), nil
+}
+
+func checkTuple(rel *pglogrepl.RelationMessage, tuple *pglogrepl.TupleData) error {
+	if tuple == nil {
+		return fmt.Errorf("change of %s has no tuple", QuoteTable(rel))
+	}
+	if len(tuple.Columns) != len(rel.Columns) {
+		return fmt.Errorf("tuple has %d columns but %s has %d", len(tuple.Columns), QuoteTable(rel), len(rel.Columns))
+	}
+	return nil
+}
+
+// QuoteIdentifier quotes an identifier for use in SQL.
+func QuoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// QuoteTable returns the quoted schema qualified name of rel.
+func QuoteTable(rel *pglogrepl.RelationMessage) string {
+	return QuoteIdentifier(rel.Namespace) + "." + QuoteIdentifier(rel.RelationName)
+}
@@ -0,0 +1,156 @@
+package sqlgen_test
+
+import (
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/sqlgen"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRelation() *pglogrepl.RelationMessage {
+	return &pglogrepl.RelationMessage{
+		RelationID:   1,
+		Namespace:    "public",
+		RelationName: `my"table`,
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Flags: 1, Name: "id", DataType: 23},
+			{Name: "name", DataType: 25},
+			{Name: "doc", DataType: 25},
+		},
+	}
+}
+
+func text(s string) *pglogrepl.TupleDataColumn {
+	return &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(s)), Data: []byte(s)}
+}
+
+func tuple(cols ...*pglogrepl.TupleDataColumn) *pglogrepl.TupleData {
+	return &pglogrepl.TupleData{ColumnNum: uint16(len(cols)), Columns: cols}
+}
+
+var (
+	null  = &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeNull}
+	toast = &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeToast}
+)
+
+func TestInsert(t *testing.T) {
+	binary := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeBinary, Length: 4, Data: []byte{0, 0, 0, 1}}
+	stmt, err := sqlgen.Insert(testRelation(), &pglogrepl.InsertMessage{Tuple: tuple(binary, text("a"), null)}, sqlgen.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."my""table" ("id", "name", "doc") VALUES ($1, $2, $3)`, stmt.SQL)
+	assert.Equal(t, [][]byte{{0, 0, 0, 1}, []byte("a"), nil}, stmt.Params)
+	assert.Equal(t, []int16{1, 0, 0}, stmt.ParamFormats)
+
+	stmt, err = sqlgen.Insert(testRelation(), &pglogrepl.InsertMessage{Tuple: tuple(text("1"), text("a"), null)}, sqlgen.Options{OverridingSystemValue: true})
+	require.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."my""table" ("id", "name", "doc") OVERRIDING SYSTEM VALUE VALUES ($1, $2, $3)`, stmt.SQL)
+}
+
+func TestInsertUpsert(t *testing.T) {
+	options := sqlgen.Options{Upsert: true}
+	stmt, err := sqlgen.Insert(testRelation(), &pglogrepl.InsertMessage{Tuple: tuple(text("1"), text("a"), null)}, options)
+	require.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."my""table" ("id", "name", "doc") VALUES ($1, $2, $3) ON CONFLICT ("id") DO UPDATE SET "name" = EXCLUDED."name", "doc" = EXCLUDED."doc"`, stmt.SQL)
+
+	// Every column is a key column.
+	rel := testRelation()
+	for _, col := range rel.Columns {
+		col.Flags = 1
+	}
+	stmt, err = sqlgen.Insert(rel, &pglogrepl.InsertMessage{Tuple: tuple(text("1"), text("a"), null)}, options)
+	require.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."my""table" ("id", "name", "doc") VALUES ($1, $2, $3) ON CONFLICT ("id", "name", "doc") DO NOTHING`, stmt.SQL)
+
+	// REPLICA IDENTITY FULL has no unique index to match.
+	rel.ReplicaIdentity = pglogrepl.ReplicaIdentityFull
+	stmt, err = sqlgen.Insert(rel, &pglogrepl.InsertMessage{Tuple: tuple(text("1"), text("a"), null)}, options)
+	require.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."my""table" ("id", "name", "doc") VALUES ($1, $2, $3)`, stmt.SQL)
+}
+
+func TestInsertColumnMismatch(t *testing.T) {
+	_, err := sqlgen.Insert(testRelation(), &pglogrepl.InsertMessage{Tuple: tuple(text("1"))}, sqlgen.Options{})
+	assert.Error(t, err)
+}
+
+func TestUpdate(t *testing.T) {
+	// The key did not change and the doc TOAST value is not sent.
+	stmt, err := sqlgen.Update(testRelation(), &pglogrepl.UpdateMessage{NewTuple: tuple(text("1"), text("b"), toast)}, sqlgen.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, `UPDATE "public"."my""table" SET "id" = $1, "name" = $2 WHERE "id" = $3`, stmt.SQL)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("b"), []byte("1")}, stmt.Params)
+
+	// The key changed.
+	stmt, err = sqlgen.Update(testRelation(), &pglogrepl.UpdateMessage{
+		OldTupleType: pglogrepl.UpdateMessageTupleTypeKey,
+		OldTuple:     tuple(text("1"), null, null),
+		NewTuple:     tuple(text("2"), text("b"), text("d")),
+	}, sqlgen.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, `UPDATE "public"."my""table" SET "id" = $1, "name" = $2, "doc" = $3 WHERE "id" = $4`, stmt.SQL)
+	assert.Equal(t, []byte("1"), stmt.Params[3])
+
+	// REPLICA IDENTITY FULL.
+	stmt, err = sqlgen.Update(testRelation(), &pglogrepl.UpdateMessage{
+		OldTupleType: pglogrepl.UpdateMessageTupleTypeOld,
+		OldTuple:     tuple(text("1"), null, text("d")),
+		NewTuple:     tuple(text("1"), text("b"), text("d")),
+	}, sqlgen.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, `UPDATE "public"."my""table" SET "id" = $1, "name" = $2, "doc" = $3 WHERE "id" = $4 AND "name" IS NULL AND "doc" = $5`, stmt.SQL)
+}
+
+func TestDelete(t *testing.T) {
+	stmt, err := sqlgen.Delete(testRelation(), &pglogrepl.DeleteMessage{
+		OldTupleType: pglogrepl.UpdateMessageTupleTypeKey,
+		OldTuple:     tuple(text("1"), null, null),
+	}, sqlgen.Options{TableName: func(rel *pglogrepl.RelationMessage) string {
+		return sqlgen.QuoteIdentifier("target")
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "target" WHERE "id" = $1`, stmt.SQL)
+	assert.Equal(t, [][]byte{[]byte("1")}, stmt.Params)
+
+	rel := testRelation()
+	rel.Columns[0].Flags = 0
+	_, err = sqlgen.Delete(rel, &pglogrepl.DeleteMessage{
+		OldTupleType: pglogrepl.UpdateMessageTupleTypeKey,
+		OldTuple:     tuple(text("1"), null, null),
+	}, sqlgen.Options{})
+	assert.Error(t, err)
+}
+
+func TestTruncate(t *testing.T) {
+	other := &pglogrepl.RelationMessage{RelationID: 2, Namespace: "s", RelationName: "t"}
+	stmt, err := sqlgen.Truncate([]*pglogrepl.RelationMessage{testRelation(), other}, &pglogrepl.TruncateMessage{
+		Option: pglogrepl.TruncateOptionCascade | pglogrepl.TruncateOptionRestartIdentity,
+	}, sqlgen.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, `TRUNCATE TABLE "public"."my""table", "s"."t" RESTART IDENTITY CASCADE`, stmt.SQL)
+	assert.Empty(t, stmt.Params)
+
+	stmt, err = sqlgen.Truncate([]*pglogrepl.RelationMessage{other}, &pglogrepl.TruncateMessage{
+		Option: pglogrepl.TruncateOptionCascade,
+	}, sqlgen.Options{NoTruncateCascade: true})
+	require.NoError(t, err)
+	assert.Equal(t, `TRUNCATE TABLE "s"."t"`, stmt.SQL)
+}
+
+func TestGenerate(t *testing.T) {
+	relations := sqlgen.RelationMap{1: testRelation()}
+
+	stmt, err := sqlgen.Generate(relations, &pglogrepl.InsertMessageV2{InsertMessage: pglogrepl.InsertMessage{RelationID: 1, Tuple: tuple(text("1"), text("a"), null)}}, sqlgen.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."my""table" ("id", "name", "doc") VALUES ($1, $2, $3)`, stmt.SQL)
+
+	_, err = sqlgen.Generate(relations, &pglogrepl.TruncateMessage{RelationNum: 1, RelationIDs: []uint32{2}}, sqlgen.Options{})
+	assert.Error(t, err)
+
+	stmt, err = sqlgen.Generate(relations, &pglogrepl.BeginMessage{}, sqlgen.Options{})
+	require.NoError(t, err)
+	assert.Nil(t, stmt)
+}
+
+var _ sqlgen.RelationSource = (*pglogrepl.RelationCache)(nil)
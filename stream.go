@@ -0,0 +1,1013 @@
+package pglogrepl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+const defaultStandbyMessageTimeout = 10 * time.Second
+
+// ErrStreamStopped is returned by ReplicationStream.Next once the stream is stopped.
+var ErrStreamStopped = errors.New("replication stream stopped")
+
+// ErrReceiveTimeout is wrapped by the error ReplicationStream.Next returns when nothing is received
+// from the server for ReplicationStreamOptions.ReceiveTimeout.
+var ErrReceiveTimeout = errors.New("replication receive timeout")
+
+// ReplicationStreamOptions configures a ReplicationStream.
+type ReplicationStreamOptions struct {
+	StartReplicationOptions
+
+	// ProtoVersion is the pgoutput protocol version used to decode the WAL data of received
+	// XLogData messages. If it is 0 the protocol version of Pgoutput is used, and if that is not
+	// set either the WAL data is not decoded, which is what is required for other output plugins
+	// such as test_decoding or wal2json and for physical replication.
+	ProtoVersion int
+
+	// StandbyMessageTimeout is the interval at which standby status updates are sent to the
+	// server. If it is 0 then 10 seconds is used.
+	StandbyMessageTimeout time.Duration
+
+	// ReceiveTimeout, if positive, makes Next fail with ErrReceiveTimeout when nothing, not even a
+	// keepalive, is received from the server for that long, like wal_receiver_timeout does for a
+	// standby. The connection is then closed, so a stream with a ReconnectPolicy reconnects. It
+	// should be greater than the wal_sender_timeout of the server, which sends keepalives at half
+	// that interval.
+	ReceiveTimeout time.Duration
+
+	// IdleHeartbeatInterval, if positive, makes the stream request a reply from the server with
+	// its standby status update when nothing has been received for that long, so that an idle
+	// connection has traffic in both directions and is not dropped by load balancers or NAT
+	// gateways. If it is 0 and ReceiveTimeout is set, half of ReceiveTimeout is used, so that the
+	// server is asked to answer before the timeout expires.
+	IdleHeartbeatInterval time.Duration
+
+	// Reconnect enables reconnecting when the connection is lost. If it is nil connection errors
+	// are returned by Next.
+	Reconnect *ReconnectPolicy
+
+	// PoolBuffers makes the stream copy the WAL data of received messages into buffers taken from
+	// a pool instead of allocating a new buffer for every message. The caller must then call
+	// Release on every message returned by Next once it is done with it.
+	PoolBuffers bool
+
+	// LargeColumnSize, if positive, makes the stream decode the column data of inserts, updates
+	// and deletes of at least LargeColumnSize bytes as sub-slices of WALData instead of copies, so
+	// that rows with multi-megabyte TOASTed values are only held in memory once. Smaller values
+	// are still copied, so retaining them does not retain the whole WAL data. With PoolBuffers
+	// these sub-slices are invalid once the message is released.
+	LargeColumnSize int
+
+	// Metrics, if set, receives the measurements of the stream.
+	Metrics StreamMetrics
+
+	// Logger, if set, receives the log records of the stream.
+	Logger Logger
+
+	// Filter, if set, selects the delivered data changes of a stream decoding pgoutput messages.
+	// Inserts, updates and deletes of excluded tables are dropped before their tuples are decoded,
+	// and their positions are still confirmed.
+	Filter *MessageFilter
+
+	// Recorder, if set, records every CopyData message received from the server, before it is
+	// handled. The caller flushes it once done.
+	Recorder *Recorder
+
+	// AckPolicy selects the positions confirmed to the server as flushed and applied.
+	AckPolicy AckPolicy
+
+	// WireDump, if set, receives a dump of every replication protocol message received from and
+	// sent to the server while streaming: a line with the time, the direction and the decoded
+	// header of the message, followed by a hex dump of its data. It diagnoses issues such as
+	// missing keepalives or misordered stream segments; the dump holds the row data replicated.
+	WireDump io.Writer
+	// WireDumpDataLimit, if positive, truncates the hex dumps to their first WireDumpDataLimit
+	// bytes.
+	WireDumpDataLimit int
+
+	// UnknownMessagePolicy selects what the stream does with a message of a type the parser of
+	// ProtoVersion does not support, such as one introduced by a newer server.
+	UnknownMessagePolicy UnknownMessagePolicy
+	// OnUnknownMessage is called with the messages of unsupported types under
+	// UnknownMessageCallback. An error it returns is returned by Next. With PoolBuffers the WAL
+	// data is only valid until it returns.
+	OnUnknownMessage func(xld XLogData) error
+
+	// TypeLoader, if set, registers the type of every TypeMessage received before the message is
+	// returned, so that the columns of the user-defined type are decoded with the type map of the
+	// loader without populating it beforehand.
+	TypeLoader *TypeLoader
+}
+
+// AckPolicy selects when a ReplicationStream advances the flush and apply positions of its
+// standby status updates, which is what the server discards the WAL of the slot up to. The write
+// position always reports what has been received.
+type AckPolicy int
+
+const (
+	// AckDefault confirms the position received until SetAppliedLSN is first called, and the
+	// position set with SetAppliedLSN afterwards.
+	AckDefault AckPolicy = iota
+	// AckOnReceive confirms every message as soon as it is received, whether or not
+	// SetAppliedLSN is called. The server retains as little WAL as possible, but the messages
+	// the application had not processed when it stops or crashes are not sent again: delivery
+	// is best effort. Reconnects resume from the position received.
+	AckOnReceive
+	// AckOnApply only confirms the positions set with SetAppliedLSN, and nothing beyond the start
+	// position before it is first called, so every message is delivered at least once. The server
+	// retains the WAL the application has not processed yet.
+	AckOnApply
+)
+
+func (p AckPolicy) String() string {
+	switch p {
+	case AckDefault:
+		return "default"
+	case AckOnReceive:
+		return "on-receive"
+	case AckOnApply:
+		return "on-apply"
+	}
+	return fmt.Sprintf("AckPolicy(%d)", int(p))
+}
+
+// UnknownMessagePolicy selects what a ReplicationStream does with a logical replication message
+// of a type its parser does not support. The position of a message that is not returned is still
+// confirmed.
+type UnknownMessagePolicy int
+
+const (
+	// UnknownMessageError makes Next fail with an error wrapping ErrUnknownMessageType.
+	UnknownMessageError UnknownMessagePolicy = iota
+	// UnknownMessageSkip drops the message, logging it as a warning.
+	UnknownMessageSkip
+	// UnknownMessageCallback drops the message after passing it to
+	// ReplicationStreamOptions.OnUnknownMessage.
+	UnknownMessageCallback
+)
+
+func (p UnknownMessagePolicy) String() string {
+	switch p {
+	case UnknownMessageError:
+		return "error"
+	case UnknownMessageSkip:
+		return "skip"
+	case UnknownMessageCallback:
+		return "callback"
+	}
+	return fmt.Sprintf("UnknownMessagePolicy(%d)", int(p))
+}
+
+// ReconnectPolicy configures how a ReplicationStream reconnects after losing its connection.
+//
+// Replication is resumed from the last position confirmed to the server, as selected by the
+// AckPolicy: the flush position set with SetFlushedLSN or SetAppliedLSN if it is confirmed,
+// otherwise the position received so far. Messages after that position that had already been
+// returned by Next may be received again.
+type ReconnectPolicy struct {
+	// Connect establishes a new replication connection. It is required, unless the stream is
+	// started with ConnectReplicationStream, which then uses its ConnectFunc.
+	Connect ConnectFunc
+
+	// InitialBackoff is the delay before the second reconnect attempt. The delay doubles with every
+	// attempt up to MaxBackoff. The first attempt is made immediately. If InitialBackoff is 0 then
+	// 1 second is used and if MaxBackoff is 0 then 1 minute is used.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// MaxAttempts is the number of consecutive failed attempts after which Next gives up and
+	// returns the last error. If it is 0 there is no limit.
+	MaxAttempts int
+
+	// OnReconnect is called before every reconnect attempt with the 1-based attempt number and the
+	// error that caused the reconnect or made the previous attempt fail.
+	OnReconnect func(attempt int, err error)
+
+	// OnSwitchover, if set, makes the stream identify the server with IDENTIFY_SYSTEM when it
+	// starts and after every reconnect. When the server reconnected to has another timeline or
+	// system identifier, typically because a standby the slot is synchronized to has been promoted
+	// (failover slots, PostgreSQL 17), OnSwitchover is called with the new connection before
+	// replication is resumed on it from the confirmed position. If it returns an error the
+	// connection is closed and Next fails with the error. ValidateFailoverSlot checks that the
+	// slot can be used on the new server.
+	OnSwitchover func(ctx context.Context, conn *pgconn.PgConn, switchover Switchover) error
+
+	// VerifySystemID makes the stream identify the server with IDENTIFY_SYSTEM when it starts and
+	// after every reconnect, and fail with a *SystemIDMismatchError if the server reconnected to
+	// does not belong to the cluster the stream started on, such as when DNS now points to another
+	// cluster. Resuming replication there could apply changes from a slot of the same name that
+	// has nothing to do with the original one. The check is made before OnSwitchover is called.
+	VerifySystemID bool
+
+	// FollowTimeline makes the stream follow the promotion of a standby the slot is synchronized
+	// to. When the server terminates the walsender, with a FATAL error or by ending the copy-both
+	// mode, the stream reconnects instead of failing. A promoted server is identified with
+	// IDENTIFY_SYSTEM: it must belong to the same cluster, as with VerifySystemID, and have a
+	// later timeline, and for logical replication the slot is checked with ValidateFailoverSlot
+	// before replication resumes from the confirmed position. Every timeline followed is recorded,
+	// see ReplicationStream.TimelineSwitches. OnSwitchover is called after these checks.
+	FollowTimeline bool
+}
+
+// identifies reports whether the policy requires identifying the server.
+func (p *ReconnectPolicy) identifies() bool {
+	return p != nil && (p.OnSwitchover != nil || p.VerifySystemID || p.FollowTimeline)
+}
+
+// followsTimeline reports whether the policy has FollowTimeline.
+func (p *ReconnectPolicy) followsTimeline() bool {
+	return p != nil && p.FollowTimeline
+}
+
+const (
+	defaultReconnectInitialBackoff = time.Second
+	defaultReconnectMaxBackoff     = time.Minute
+)
+
+// ReplicationMessage is a XLogData message received through a ReplicationStream.
+type ReplicationMessage struct {
+	XLogData
+
+	// Message is the decoded logical replication message. It is nil if the stream was not
+	// configured with a ProtoVersion.
+	Message Message
+
+	// buf holds WALData when the stream pools buffers.
+	buf *[]byte
+}
+
+// maxPooledWALDataSize is the capacity above which a buffer is not returned to the pool, so that
+// a few very large records do not keep their memory allocated.
+const maxPooledWALDataSize = 1 << 20
+
+var walDataPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 1024)
+		return &buf
+	},
+}
+
+// Release returns the buffer of WALData to the pool when the stream was configured with
+// PoolBuffers. Neither WALData nor Message may be used afterwards, as the decoded message can
+// refer to the WAL data. Release does nothing for messages of other streams and may be called
+// more than once.
+func (m *ReplicationMessage) Release() {
+	if m.buf == nil {
+		return
+	}
+	if cap(m.WALData) <= maxPooledWALDataSize {
+		*m.buf = m.WALData[:0]
+		walDataPool.Put(m.buf)
+	}
+	m.buf = nil
+	m.WALData = nil
+	m.Message = nil
+}
+
+// ReplicationStream wraps a connection in the copy-both mode started by START_REPLICATION. It
+// answers primary keepalive messages and periodically sends standby status updates so that the
+// caller only has to consume the replicated data.
+//
+// By default every received message is acknowledged as flushed and applied. Applications that
+// need to confirm only what they have durably processed report it with SetAppliedLSN, and can
+// choose another AckPolicy.
+//
+// A ReplicationStream is not safe for concurrent use, except for the methods setting and
+// returning the positions.
+type ReplicationStream struct {
+	conn     *pgconn.PgConn
+	slotName string
+	options  ReplicationStreamOptions
+	logger   Logger
+	// reconnects is the number of successful reconnects.
+	reconnects int
+	// system identifies the server when the ReconnectPolicy has OnSwitchover, VerifySystemID or
+	// FollowTimeline, and systemID is the system identifier of the server the stream was started
+	// on.
+	system   IdentifySystemResult
+	systemID string
+	// timelineSwitches are the timelines followed with FollowTimeline.
+	timelineSwitches []TimelineSwitch
+
+	// clientXLogPos is only written by Next, holding mu since the positions can be read
+	// concurrently.
+	clientXLogPos              LSN
+	nextStandbyMessageDeadline time.Time
+	inStream                   bool
+	// lastReceive is the time of the last message received from the server and heartbeatSent
+	// reports whether a reply has been requested since.
+	lastReceive   time.Time
+	heartbeatSent bool
+	// decoder decodes messages when the options have a LargeColumnSize.
+	decoder *Decoder
+	// filter applies the Filter of the options.
+	filter *relationFilter
+	// dump writes to the WireDump of the options.
+	dump *wireDump
+	// skipLSN is the commit position of the transaction to skip and skipping reports whether
+	// its messages are being received.
+	skipLSN  LSN
+	skipping bool
+	// stopped reports whether Stop has been called.
+	stopped bool
+
+	// The positions set by the application. trackApply reports whether the flush and apply
+	// positions are confirmed and trackWrite whether the write position is.
+	mu         sync.Mutex
+	writtenLSN LSN
+	flushedLSN LSN
+	appliedLSN LSN
+	trackWrite bool
+	trackApply bool
+}
+
+// StartReplicationStream starts replication on conn with StartReplication and returns a
+// ReplicationStream reading from it.
+func StartReplicationStream(ctx context.Context, conn *pgconn.PgConn, slotName string, startLSN LSN, options ReplicationStreamOptions) (*ReplicationStream, error) {
+	if options.StandbyMessageTimeout <= 0 {
+		options.StandbyMessageTimeout = defaultStandbyMessageTimeout
+	}
+	if options.IdleHeartbeatInterval <= 0 && options.ReceiveTimeout > 0 {
+		options.IdleHeartbeatInterval = options.ReceiveTimeout / 2
+	}
+	if options.ProtoVersion == 0 && options.Pgoutput != nil {
+		options.ProtoVersion = options.Pgoutput.ProtoVersion
+		if options.ProtoVersion == 0 {
+			options.ProtoVersion = 1
+		}
+	}
+	if options.ProtoVersion < 0 || options.ProtoVersion > 4 {
+		return nil, fmt.Errorf("unsupported pgoutput protocol version %d", options.ProtoVersion)
+	}
+	if options.Mode == PhysicalReplication && options.ProtoVersion != 0 {
+		return nil, fmt.Errorf("ProtoVersion must be 0 for physical replication")
+	}
+	if options.Reconnect != nil && options.Reconnect.Connect == nil {
+		return nil, fmt.Errorf("ReconnectPolicy.Connect is required")
+	}
+
+	var system IdentifySystemResult
+	if options.Reconnect.identifies() {
+		var err error
+		if system, err = IdentifySystem(ctx, conn); err != nil {
+			return nil, fmt.Errorf("failed to identify system: %w", err)
+		}
+	}
+
+	err := StartReplication(ctx, conn, slotName, startLSN, options.StartReplicationOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ReplicationStream{
+		conn:                       conn,
+		slotName:                   slotName,
+		options:                    options,
+		logger:                     options.Logger,
+		system:                     system,
+		systemID:                   system.SystemID,
+		clientXLogPos:              startLSN,
+		nextStandbyMessageDeadline: time.Now().Add(options.StandbyMessageTimeout),
+		lastReceive:                time.Now(),
+		writtenLSN:                 startLSN,
+		flushedLSN:                 startLSN,
+		appliedLSN:                 startLSN,
+		trackApply:                 options.AckPolicy == AckOnApply,
+	}
+	if s.logger == nil {
+		s.logger = nopLogger{}
+	}
+	s.dump = newWireDump(&s.options)
+	if options.ProtoVersion > 0 && options.Filter != nil {
+		s.filter = newRelationFilter(options.Filter)
+	}
+	if options.ProtoVersion > 0 && options.LargeColumnSize > 0 {
+		s.decoder = &Decoder{protoVersion: options.ProtoVersion, alloc: true, aliasMin: options.LargeColumnSize}
+	}
+	return s, nil
+}
+
+// System returns the result of IDENTIFY_SYSTEM for the server the stream is connected to. It is
+// only known if the ReconnectPolicy has OnSwitchover, VerifySystemID or FollowTimeline, and is
+// updated after every reconnect.
+func (s *ReplicationStream) System() (IdentifySystemResult, bool) {
+	return s.system, s.options.Reconnect.identifies()
+}
+
+// Conn returns the underlying connection. The connection is replaced when the stream reconnects.
+func (s *ReplicationStream) Conn() *pgconn.PgConn {
+	return s.conn
+}
+
+// Reconnects returns the number of times the stream has reconnected.
+func (s *ReplicationStream) Reconnects() int {
+	return s.reconnects
+}
+
+// TimelineSwitches returns the timelines the stream followed with ReconnectPolicy.FollowTimeline,
+// oldest first.
+func (s *ReplicationStream) TimelineSwitches() []TimelineSwitch {
+	return append([]TimelineSwitch(nil), s.timelineSwitches...)
+}
+
+// ClientXLogPos returns the WAL position the stream has received up to. This is the position
+// reported to the server in standby status updates.
+func (s *ReplicationStream) ClientXLogPos() LSN {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clientXLogPos
+}
+
+// SetAppliedLSN records that all WAL up to lsn has been processed by the application. Once it has
+// been called, standby status updates report lsn as the flush and apply positions instead of the
+// position received so far, so the server only discards WAL the application is done with, unless
+// the AckPolicy is AckOnReceive. An lsn lower than a previously set one is ignored.
+//
+// Applications that make the WAL durable before they apply it, such as a standby for
+// synchronous_commit = remote_apply, report the two positions separately with SetFlushedLSN and
+// SetAppliedLSN. Otherwise the applied position is also reported as flushed.
+func (s *ReplicationStream) SetAppliedLSN(lsn LSN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.options.AckPolicy != AckOnReceive {
+		s.trackApply = true
+	}
+	if lsn > s.appliedLSN {
+		s.appliedLSN = lsn
+	}
+	if lsn > s.flushedLSN {
+		s.flushedLSN = lsn
+	}
+}
+
+// SetFlushedLSN records that all WAL up to lsn has been durably stored by the application, which
+// standby status updates then report as the flush position, while the apply position is still
+// the one set with SetAppliedLSN. The server discards the WAL of a logical slot up to the flush
+// position, and replication resumes from it after a reconnect. An lsn lower than a previously set
+// one is ignored, and the call does nothing if the AckPolicy is AckOnReceive.
+func (s *ReplicationStream) SetFlushedLSN(lsn LSN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.options.AckPolicy != AckOnReceive {
+		s.trackApply = true
+	}
+	if lsn > s.flushedLSN {
+		s.flushedLSN = lsn
+	}
+}
+
+// SetWrittenLSN records that all WAL up to lsn has been written by the application, but not
+// necessarily flushed. Once it has been called, standby status updates report lsn as the write
+// position instead of the position received so far, as synchronous_commit = remote_write
+// expects. An lsn lower than a previously set one is ignored, and the call does nothing if the
+// AckPolicy is AckOnReceive.
+func (s *ReplicationStream) SetWrittenLSN(lsn LSN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.options.AckPolicy != AckOnReceive {
+		s.trackWrite = true
+	}
+	if lsn > s.writtenLSN {
+		s.writtenLSN = lsn
+	}
+}
+
+// FlushAndReport records with SetFlushedLSN that all WAL up to lsn has been durably stored and
+// immediately sends a standby status update reporting it, instead of waiting for the next update
+// to be due. A synchronous standby for synchronous_commit = on or remote_write calls it once the
+// WAL of a commit is stored, so that the commit on the primary does not wait for the
+// StandbyMessageTimeout. It must not be called concurrently with Next.
+func (s *ReplicationStream) FlushAndReport(ctx context.Context, lsn LSN) error {
+	s.SetFlushedLSN(lsn)
+	return s.ReportPositions(ctx)
+}
+
+// ReportPositions immediately sends a standby status update reporting the positions, for example
+// after SetAppliedLSN for synchronous_commit = remote_apply. It must not be called concurrently
+// with Next.
+func (s *ReplicationStream) ReportPositions(ctx context.Context) error {
+	if s.stopped {
+		return ErrStreamStopped
+	}
+	return s.sendStandbyStatusUpdate(ctx, false)
+}
+
+// AppliedLSN returns the position last reported with SetAppliedLSN, or the start position if
+// SetAppliedLSN has not been called.
+func (s *ReplicationStream) AppliedLSN() LSN {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appliedLSN
+}
+
+// Positions returns the write, flush and apply positions the next standby status update reports.
+func (s *ReplicationStream) Positions() (write, flush, apply LSN) {
+	ssu := s.standbyStatusUpdate()
+	return ssu.WALWritePosition, ssu.WALFlushPosition, ssu.WALApplyPosition
+}
+
+// Stop stops streaming: it sends a final standby status update reporting the positions set by
+// the application, or received if they have not been set, then ends the copy-both mode with
+// DrainStream so the connection can be reused for other commands. The server processes the
+// update before the end of the copy-both mode, so the confirmed position is not lost when
+// replication is restarted. A reply requested by the server and not sent yet is answered by the
+// final update.
+//
+// Stop can be called after Next returned io.EOF. Next returns ErrStreamStopped afterwards, and
+// further calls to Stop do nothing.
+func (s *ReplicationStream) Stop(ctx context.Context) error {
+	if s.stopped {
+		return nil
+	}
+	s.stopped = true
+	if s.conn.IsClosed() {
+		return fmt.Errorf("failed to stop replication: connection is closed")
+	}
+
+	if err := s.sendStandbyStatusUpdate(ctx, false); err != nil {
+		return err
+	}
+	s.logger.Info("stopping replication", "lsn", s.resumeLSN())
+	if _, err := DrainStream(ctx, s.conn); err != nil {
+		return fmt.Errorf("failed to stop replication: %w", err)
+	}
+	return nil
+}
+
+// Close stops the stream with Stop and closes the connection. The connection is closed even if
+// Stop fails, and the error of Stop is returned.
+func (s *ReplicationStream) Close(ctx context.Context) error {
+	err := s.Stop(ctx)
+	if cerr := s.conn.Close(ctx); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Next returns the next XLogData message from the server. Keepalive messages are handled
+// internally and standby status updates are sent whenever they are due while waiting for data.
+//
+// If the server ends the copy-both mode Next returns io.EOF. For physical replication this
+// happens at the end of a timeline; use DrainStream to confirm it and learn where the next
+// timeline starts. If the ReconnectPolicy has FollowTimeline, Next reconnects instead.
+//
+// If the stream has a ReconnectPolicy and the connection is lost, Next reconnects and resumes
+// replication before returning the next message.
+func (s *ReplicationStream) Next(ctx context.Context) (*ReplicationMessage, error) {
+	if s.stopped {
+		return nil, ErrStreamStopped
+	}
+	for {
+		rm, err := s.next(ctx)
+		if err == nil || s.options.Reconnect == nil || ctx.Err() != nil || !s.conn.IsClosed() {
+			return rm, err
+		}
+		if err := s.reconnect(ctx, err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *ReplicationStream) next(ctx context.Context) (*ReplicationMessage, error) {
+	for {
+		now := time.Now()
+		idle := now.Sub(s.lastReceive)
+		if s.options.ReceiveTimeout > 0 && idle >= s.options.ReceiveTimeout {
+			s.logger.Warn("receive timeout", "idle", idle)
+			s.conn.Close(ctx)
+			return nil, fmt.Errorf("%w: nothing received for %s", ErrReceiveTimeout, idle.Round(time.Millisecond))
+		}
+		heartbeat := s.options.IdleHeartbeatInterval > 0 && !s.heartbeatSent && idle >= s.options.IdleHeartbeatInterval
+		if heartbeat || !now.Before(s.nextStandbyMessageDeadline) {
+			if err := s.sendStandbyStatusUpdate(ctx, heartbeat); err != nil {
+				return nil, err
+			}
+			if heartbeat {
+				s.heartbeatSent = true
+			}
+		}
+
+		receiveCtx, cancel := context.WithDeadline(ctx, s.receiveDeadline())
+		rawMsg, err := s.conn.ReceiveMessage(receiveCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if pgconn.Timeout(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to receive message: %w", err)
+		}
+		s.lastReceive = time.Now()
+		s.heartbeatSent = false
+		if s.dump != nil {
+			s.dump.received(s.lastReceive, rawMsg)
+		}
+
+		switch msg := rawMsg.(type) {
+		case *pgproto3.CopyData:
+			if s.options.Recorder != nil {
+				if err := s.options.Recorder.Record(s.lastReceive, msg.Data); err != nil {
+					return nil, err
+				}
+			}
+			rm, err := s.handleCopyData(msg.Data)
+			if err != nil {
+				return nil, err
+			}
+			if rm != nil && s.options.TypeLoader != nil {
+				if err := s.options.TypeLoader.Register(ctx, rm.Message); err != nil {
+					rm.Release()
+					return nil, err
+				}
+			}
+			if rm != nil {
+				return rm, nil
+			}
+		case *pgproto3.CopyDone:
+			if s.options.Reconnect.followsTimeline() {
+				// Closing the connection makes Next reconnect to follow the timeline.
+				s.logger.Warn("server ended replication")
+				s.conn.Close(ctx)
+			}
+			return nil, io.EOF
+		case *pgproto3.ErrorResponse:
+			if s.options.Reconnect.followsTimeline() && (msg.Severity == "FATAL" || msg.Severity == "PANIC") {
+				s.logger.Warn("server terminated walsender", "code", msg.Code, "message", msg.Message)
+				s.conn.Close(ctx)
+			}
+			return nil, pgconn.ErrorResponseToPgError(msg)
+		case *pgproto3.NoticeResponse:
+			s.logger.Warn("server notice", "severity", msg.Severity, "code", msg.Code, "message", msg.Message)
+		case *pgproto3.ParameterStatus:
+		default:
+			s.logger.Error("unexpected response type", "type", fmt.Sprintf("%T", msg))
+			return nil, fmt.Errorf("unexpected response type: %T", msg)
+		}
+	}
+}
+
+// receiveDeadline returns the time at which waiting for a message must be interrupted to send a
+// standby status update or a heartbeat, or to report a receive timeout.
+func (s *ReplicationStream) receiveDeadline() time.Time {
+	deadline := s.nextStandbyMessageDeadline
+	if s.options.IdleHeartbeatInterval > 0 && !s.heartbeatSent {
+		if t := s.lastReceive.Add(s.options.IdleHeartbeatInterval); t.Before(deadline) {
+			deadline = t
+		}
+	}
+	if s.options.ReceiveTimeout > 0 {
+		if t := s.lastReceive.Add(s.options.ReceiveTimeout); t.Before(deadline) {
+			deadline = t
+		}
+	}
+	return deadline
+}
+
+func (s *ReplicationStream) handleCopyData(data []byte) (*ReplicationMessage, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("received empty CopyData message")
+	}
+
+	switch data[0] {
+	case PrimaryKeepaliveMessageByteID:
+		pkm, err := ParsePrimaryKeepaliveMessage(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		s.logger.Debug("primary keepalive received", "server_wal_end", pkm.ServerWALEnd, "reply_requested", pkm.ReplyRequested)
+		if s.options.Metrics != nil {
+			s.options.Metrics.KeepaliveReceived()
+		}
+		if pkm.ServerWALEnd > s.clientXLogPos {
+			s.setClientXLogPos(pkm.ServerWALEnd)
+		}
+		if pkm.ReplyRequested {
+			s.nextStandbyMessageDeadline = time.Time{}
+		}
+		return nil, nil
+	case XLogDataByteID:
+		xld, err := ParseXLogData(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		var rm *ReplicationMessage
+		if s.filter != nil && s.filter.drop(xld.WALData, s.inStream) {
+			// The change of an excluded relation is neither copied nor decoded.
+			if s.options.Metrics != nil {
+				s.options.Metrics.MessageReceived(MessageType(xld.WALData[0]), len(xld.WALData))
+			}
+		} else if rm, err = s.receiveXLogData(xld); err != nil {
+			return nil, err
+		}
+
+		// Physical replication acknowledges the end of the received WAL, logical replication the
+		// start of the last received message.
+		pos := xld.WALStart
+		if s.options.Mode == PhysicalReplication {
+			pos += LSN(len(xld.WALData))
+		}
+		if pos > s.clientXLogPos {
+			s.setClientXLogPos(pos)
+		}
+		return rm, nil
+	default:
+		s.logger.Error("unexpected CopyData message type", "type", string(data[0]))
+		return nil, fmt.Errorf("unexpected CopyData message type: %c", data[0])
+	}
+}
+
+// receiveXLogData returns the message delivering xld, or nil if it is dropped.
+func (s *ReplicationStream) receiveXLogData(xld XLogData) (*ReplicationMessage, error) {
+	// The buffer of the CopyData message is reused by the connection for the next message.
+	rm := &ReplicationMessage{}
+	if s.options.PoolBuffers {
+		rm.buf = walDataPool.Get().(*[]byte)
+		xld.WALData = append((*rm.buf)[:0], xld.WALData...)
+	} else {
+		xld.WALData = append([]byte(nil), xld.WALData...)
+	}
+	rm.XLogData = xld
+
+	if s.options.ProtoVersion > 0 {
+		var err error
+		rm.Message, err = s.parse(xld.WALData)
+		if err != nil && errors.Is(err, ErrUnknownMessageType) && s.options.UnknownMessagePolicy != UnknownMessageError {
+			err = s.unknownMessage(rm.XLogData)
+			rm.Release()
+			return nil, err
+		}
+		if err != nil {
+			s.logger.Error("received invalid logical replication message", "wal_start", xld.WALStart, "error", err)
+			rm.Release()
+			return nil, err
+		}
+	}
+	if s.options.Metrics != nil {
+		var msgType MessageType
+		if rm.Message != nil {
+			msgType = rm.Message.Type()
+		}
+		s.options.Metrics.MessageReceived(msgType, len(xld.WALData))
+	}
+	if (s.skipLSN != 0 && s.skip(rm.Message)) || (s.filter != nil && s.filter.update(rm.Message)) {
+		rm.Release()
+		rm = nil
+	}
+	return rm, nil
+}
+
+// unknownMessage handles xld, a message of an unsupported type, according to the
+// UnknownMessagePolicy.
+func (s *ReplicationStream) unknownMessage(xld XLogData) error {
+	msgType := MessageType(xld.WALData[0])
+	if s.options.Metrics != nil {
+		s.options.Metrics.MessageReceived(msgType, len(xld.WALData))
+	}
+	if s.options.UnknownMessagePolicy == UnknownMessageCallback && s.options.OnUnknownMessage != nil {
+		return s.options.OnUnknownMessage(xld)
+	}
+	s.logger.Warn("skipped message of unsupported type", "wal_start", xld.WALStart, "type", string(msgType))
+	return nil
+}
+
+// SkipTransaction makes the stream drop the messages of the transaction committed at
+// commitLSN, the FinalLSN of its BeginMessage, like ALTER SUBSCRIPTION ... SKIP does for a native
+// subscription. It is meant to get past a transaction that cannot be applied: the LSN is
+// typically reported by the failing apply and replication is restarted with the skip set.
+//
+// The relation and type messages of the transaction are still returned, since later messages need
+// them. Transactions streamed while in progress are already returned when they are committed, so
+// they cannot be skipped by the stream; the apply package's Options.SkipLSN skips them. If the
+// caller confirms positions with SetAppliedLSN, the skipped transaction is confirmed together with
+// the next transaction it applies.
+func (s *ReplicationStream) SkipTransaction(commitLSN LSN) {
+	s.skipLSN = commitLSN
+	s.skipping = false
+}
+
+// skip reports whether msg belongs to the transaction to skip.
+func (s *ReplicationStream) skip(msg Message) bool {
+	switch msg := msg.(type) {
+	case *BeginMessage:
+		s.skipping = msg.FinalLSN == s.skipLSN
+	case *RelationMessage, *RelationMessageV2, *TypeMessage, *TypeMessageV2:
+		return false
+	case *CommitMessage:
+		if s.skipping {
+			s.skipping = false
+			s.skipLSN = 0
+			return true
+		}
+	}
+	return s.skipping
+}
+
+func (s *ReplicationStream) parse(walData []byte) (Message, error) {
+	var (
+		msg Message
+		err error
+	)
+	if s.decoder != nil {
+		s.decoder.inStream = s.inStream
+		s.decoder.Reset(walData)
+		msg, err = s.decoder.Decode()
+	} else {
+		msg, err = parseMessage(s.options.ProtoVersion, walData, s.inStream)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse logical replication message: %w", err)
+	}
+
+	switch msg.(type) {
+	case *StreamStartMessageV2:
+		s.inStream = true
+	case *StreamStopMessageV2:
+		s.inStream = false
+	}
+
+	return msg, nil
+}
+
+// parseMessage parses walData with the parser of protocol version protoVersion.
+func parseMessage(protoVersion int, walData []byte, inStream bool) (Message, error) {
+	switch protoVersion {
+	case 1:
+		return Parse(walData)
+	case 2:
+		return ParseV2(walData, inStream)
+	case 3:
+		return ParseV3(walData, inStream)
+	default:
+		return ParseV4(walData, inStream)
+	}
+}
+
+// messageInStream reports whether msg, just returned by Next, was received in a stream block.
+func (s *ReplicationStream) messageInStream(msg Message) bool {
+	switch msg.(type) {
+	case *StreamStartMessageV2, *StreamStopMessageV2:
+		return !s.inStream
+	}
+	return s.inStream
+}
+
+func (s *ReplicationStream) standbyStatusUpdate() StandbyStatusUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ssu := StandbyStatusUpdate{
+		WALWritePosition: s.clientXLogPos,
+		WALFlushPosition: s.clientXLogPos,
+		WALApplyPosition: s.clientXLogPos,
+	}
+	if s.trackWrite {
+		ssu.WALWritePosition = s.writtenLSN
+	}
+	if s.trackApply {
+		ssu.WALFlushPosition = s.flushedLSN
+		ssu.WALApplyPosition = s.appliedLSN
+	}
+	// What is flushed has been written.
+	if ssu.WALFlushPosition > ssu.WALWritePosition {
+		ssu.WALWritePosition = ssu.WALFlushPosition
+	}
+	return ssu
+}
+
+func (s *ReplicationStream) setClientXLogPos(lsn LSN) {
+	s.mu.Lock()
+	s.clientXLogPos = lsn
+	s.mu.Unlock()
+	if s.options.Metrics != nil {
+		s.options.Metrics.ClientXLogPos(lsn)
+	}
+}
+
+// sendStandbyStatusUpdate sends the standby status update, asking the server to reply immediately
+// if replyRequested is set.
+func (s *ReplicationStream) sendStandbyStatusUpdate(ctx context.Context, replyRequested bool) error {
+	start := time.Now()
+	ssu := s.standbyStatusUpdate()
+	ssu.ReplyRequested = replyRequested
+	s.logger.Debug("sending standby status update", "write", ssu.WALWritePosition, "flush", ssu.WALFlushPosition, "apply", ssu.WALApplyPosition, "reply_requested", replyRequested)
+	err := SendStandbyStatusUpdate(ctx, s.conn, ssu)
+	if err != nil {
+		return fmt.Errorf("failed to send standby status update: %w", err)
+	}
+	if s.dump != nil {
+		s.dump.sent(time.Now(), ssu)
+	}
+	if s.options.Metrics != nil {
+		s.options.Metrics.StandbyStatusUpdateSent(time.Since(start))
+	}
+	s.nextStandbyMessageDeadline = time.Now().Add(s.options.StandbyMessageTimeout)
+	return nil
+}
+
+// keepAlive sends the standby status update if it is due while the caller is not receiving, and
+// returns the time the next one is due. Nothing is read from the connection meanwhile, so the
+// ReceiveTimeout is restarted rather than expiring because of the caller.
+func (s *ReplicationStream) keepAlive(ctx context.Context) (time.Time, error) {
+	if !time.Now().Before(s.nextStandbyMessageDeadline) {
+		if err := s.sendStandbyStatusUpdate(ctx, false); err != nil {
+			return time.Time{}, err
+		}
+	}
+	s.lastReceive = time.Now()
+	s.heartbeatSent = false
+	return s.nextStandbyMessageDeadline, nil
+}
+
+// resumeLSN returns the position replication is resumed from after a reconnect.
+func (s *ReplicationStream) resumeLSN() LSN {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.trackApply {
+		return s.flushedLSN
+	}
+	return s.clientXLogPos
+}
+
+func (s *ReplicationStream) reconnect(ctx context.Context, cause error) error {
+	policy := s.options.Reconnect
+	backoff := policy.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultReconnectInitialBackoff
+	}
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultReconnectMaxBackoff
+	}
+
+	s.conn.Close(ctx)
+	startLSN := s.resumeLSN()
+
+	err := cause
+	for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		s.logger.Warn("reconnecting", "attempt", attempt, "error", err)
+		if policy.OnReconnect != nil {
+			policy.OnReconnect(attempt, err)
+		}
+
+		var conn *pgconn.PgConn
+		conn, err = policy.Connect(ctx)
+		if err != nil {
+			continue
+		}
+		if policy.identifies() {
+			var rejected bool
+			if rejected, err = s.identifySwitchover(ctx, conn); err != nil {
+				conn.Close(ctx)
+				if rejected {
+					return err
+				}
+				continue
+			}
+		}
+		err = StartReplication(ctx, conn, s.slotName, startLSN, s.options.StartReplicationOptions)
+		if err != nil {
+			conn.Close(ctx)
+			continue
+		}
+
+		s.conn = conn
+		s.mu.Lock()
+		s.clientXLogPos = startLSN
+		s.mu.Unlock()
+		s.inStream = false
+		s.skipping = false
+		s.nextStandbyMessageDeadline = time.Now().Add(s.options.StandbyMessageTimeout)
+		s.lastReceive = time.Now()
+		s.heartbeatSent = false
+		s.reconnects++
+		s.logger.Info("reconnected", "attempt", attempt, "start_lsn", startLSN)
+		if s.options.Metrics != nil {
+			s.options.Metrics.Reconnected()
+		}
+		return nil
+	}
+	s.logger.Error("giving up reconnecting", "error", err)
+	return fmt.Errorf("failed to reconnect: %w", err)
+}
@@ -0,0 +1,1309 @@
+package pglogrepl_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWalSender is the server side of a replication connection, driven by the test.
+type fakeWalSender struct {
+	t             testing.TB
+	conn          net.Conn
+	backend       *pgproto3.Backend
+	serverVersion string
+
+	// served is closed once the last goroutine started by serve is done.
+	mu     sync.Mutex
+	served chan struct{}
+}
+
+func newFakeWalSender(t testing.TB) (*pgconn.PgConn, *fakeWalSender) {
+	return newFakeWalSenderVersion(t, "16.0")
+}
+
+func newFakeWalSenderVersion(t testing.TB, serverVersion string) (*pgconn.PgConn, *fakeWalSender) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	accepted := make(chan error, 1)
+	ws := &fakeWalSender{t: t, serverVersion: serverVersion}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			accepted <- err
+			return
+		}
+		ws.conn = conn
+		ws.backend = pgproto3.NewBackend(conn, conn)
+		accepted <- ws.handshake()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := pgconn.Connect(ctx, fmt.Sprintf("postgres://pglogrepl@%s/pglogrepl?sslmode=disable&replication=database", ln.Addr()))
+	require.NoError(t, err)
+	require.NoError(t, <-accepted)
+
+	t.Cleanup(func() {
+		conn.Close(context.Background())
+		ws.conn.Close()
+	})
+	return conn, ws
+}
+
+func (ws *fakeWalSender) handshake() error {
+	if _, err := ws.backend.ReceiveStartupMessage(); err != nil {
+		return err
+	}
+	ws.backend.Send(&pgproto3.AuthenticationOk{})
+	ws.backend.Send(&pgproto3.ParameterStatus{Name: "server_version", Value: ws.serverVersion})
+	ws.backend.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 1})
+	ws.backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	return ws.backend.Flush()
+}
+
+func (ws *fakeWalSender) send(msgs ...pgproto3.BackendMessage) {
+	for _, msg := range msgs {
+		ws.backend.Send(msg)
+	}
+	require.NoError(ws.t, ws.backend.Flush())
+}
+
+func (ws *fakeWalSender) receive() pgproto3.FrontendMessage {
+	require.NoError(ws.t, ws.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	msg, err := ws.backend.Receive()
+	require.NoError(ws.t, err)
+	return msg
+}
+
+// serve runs f in a goroutine once the goroutines started before by serve are done, so that
+// consecutive scripted replies never receive from the backend concurrently.
+func (ws *fakeWalSender) serve(f func()) {
+	ws.mu.Lock()
+	prev := ws.served
+	done := make(chan struct{})
+	ws.served = done
+	ws.mu.Unlock()
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		f()
+	}()
+}
+
+// receiveQuery receives the next message and returns its query string, copied before the backend
+// reuses the message.
+func (ws *fakeWalSender) receiveQuery() (string, bool) {
+	msg, err := ws.backend.Receive()
+	if err != nil {
+		return "", false
+	}
+	query, ok := msg.(*pgproto3.Query)
+	if !ok {
+		return "", false
+	}
+	return query.String, true
+}
+
+// serveStartReplication answers the next START_REPLICATION query by entering copy-both mode.
+// It returns a channel receiving the query string.
+func (ws *fakeWalSender) serveStartReplication() <-chan string {
+	queries := make(chan string, 1)
+	ws.serve(func() {
+		defer close(queries)
+		query, ok := ws.receiveQuery()
+		if !ok {
+			return
+		}
+		ws.backend.Send(&pgproto3.CopyBothResponse{})
+		if err := ws.backend.Flush(); err != nil {
+			return
+		}
+		// The query is received once the backend is no longer used, so the test can go on
+		// driving it.
+		queries <- query
+	})
+	return queries
+}
+
+// serveQuery answers the next query with msgs and returns a channel receiving the query.
+func (ws *fakeWalSender) serveQuery(msgs []pgproto3.BackendMessage) <-chan string {
+	queries := make(chan string, 1)
+	ws.serve(func() {
+		defer close(queries)
+		query, ok := ws.receiveQuery()
+		if !ok {
+			return
+		}
+		for _, msg := range msgs {
+			ws.backend.Send(msg)
+		}
+		if err := ws.backend.Flush(); err != nil {
+			return
+		}
+		queries <- query
+	})
+	return queries
+}
+
+func (ws *fakeWalSender) sendXLogData(walStart pglogrepl.LSN, walData []byte) {
+	data := make([]byte, 1+24, 1+24+len(walData))
+	data[0] = pglogrepl.XLogDataByteID
+	binary.BigEndian.PutUint64(data[1:], uint64(walStart))
+	binary.BigEndian.PutUint64(data[9:], uint64(walStart)+uint64(len(walData)))
+	data = append(data, walData...)
+	ws.send(&pgproto3.CopyData{Data: data})
+}
+
+func (ws *fakeWalSender) sendKeepalive(walEnd pglogrepl.LSN, replyRequested bool) {
+	data := make([]byte, 1+17)
+	data[0] = pglogrepl.PrimaryKeepaliveMessageByteID
+	binary.BigEndian.PutUint64(data[1:], uint64(walEnd))
+	if replyRequested {
+		data[17] = 1
+	}
+	ws.send(&pgproto3.CopyData{Data: data})
+}
+
+func (ws *fakeWalSender) receiveStandbyStatusUpdate() pglogrepl.StandbyStatusUpdate {
+	msg := ws.receive()
+	cd, ok := msg.(*pgproto3.CopyData)
+	require.True(ws.t, ok, "expected CopyData, got %T", msg)
+	require.Equal(ws.t, byte(pglogrepl.StandbyStatusUpdateByteID), cd.Data[0])
+	require.Len(ws.t, cd.Data, 34)
+	return pglogrepl.StandbyStatusUpdate{
+		WALWritePosition: pglogrepl.LSN(binary.BigEndian.Uint64(cd.Data[1:])),
+		WALFlushPosition: pglogrepl.LSN(binary.BigEndian.Uint64(cd.Data[9:])),
+		WALApplyPosition: pglogrepl.LSN(binary.BigEndian.Uint64(cd.Data[17:])),
+		ReplyRequested:   cd.Data[33] != 0,
+	}
+}
+
+func beginMessageData(finalLSN pglogrepl.LSN, xid uint32) []byte {
+	data := make([]byte, 1+8+8+4)
+	data[0] = 'B'
+	binary.BigEndian.PutUint64(data[1:], uint64(finalLSN))
+	binary.BigEndian.PutUint32(data[17:], xid)
+	return data
+}
+
+func streamStartMessageData(xid uint32) []byte {
+	data := make([]byte, 1+4+1)
+	data[0] = 'S'
+	binary.BigEndian.PutUint32(data[1:], xid)
+	data[5] = 1
+	return data
+}
+
+func streamLogicalMessageData(xid uint32, prefix, content string) []byte {
+	data := []byte{'M'}
+	data = binary.BigEndian.AppendUint32(data, xid)
+	data = append(data, 1)
+	data = binary.BigEndian.AppendUint64(data, 0)
+	data = append(data, prefix...)
+	data = append(data, 0)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(content)))
+	return append(data, content...)
+}
+
+func startTestReplicationStream(t *testing.T, options pglogrepl.ReplicationStreamOptions) (*pglogrepl.ReplicationStream, *fakeWalSender) {
+	conn, ws := newFakeWalSender(t)
+	queries := ws.serveStartReplication()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, slotName, pglogrepl.LSN(0x100), options)
+	require.NoError(t, err)
+	query := <-queries
+	require.True(t, strings.HasPrefix(query, "START_REPLICATION SLOT "+slotName+" LOGICAL 0/100"), query)
+
+	return stream, ws
+}
+
+func TestReplicationStreamDecodesMessages(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{ProtoVersion: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws.sendXLogData(0x200, beginMessageData(0x300, 42))
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x200), rm.WALStart)
+	begin, ok := rm.Message.(*pglogrepl.BeginMessage)
+	require.True(t, ok)
+	assert.Equal(t, pglogrepl.LSN(0x300), begin.FinalLSN)
+	assert.Equal(t, uint32(42), begin.Xid)
+	assert.Equal(t, pglogrepl.LSN(0x200), stream.ClientXLogPos())
+}
+
+func TestReplicationStreamUndecoded(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws.sendXLogData(0x200, []byte("BEGIN 42"))
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, rm.Message)
+	assert.Equal(t, "BEGIN 42", string(rm.WALData))
+}
+
+func TestReplicationStreamTracksInStream(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{ProtoVersion: 2})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws.sendXLogData(0x200, streamStartMessageData(7))
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	require.IsType(t, &pglogrepl.StreamStartMessageV2{}, rm.Message)
+
+	ws.sendXLogData(0x210, streamLogicalMessageData(7, "prefix", "content"))
+	rm, err = stream.Next(ctx)
+	require.NoError(t, err)
+	ldm, ok := rm.Message.(*pglogrepl.LogicalDecodingMessageV2)
+	require.True(t, ok)
+	assert.Equal(t, uint32(7), ldm.Xid)
+	assert.Equal(t, "prefix", ldm.Prefix)
+	assert.Equal(t, "content", string(ldm.Content))
+
+	ws.sendXLogData(0x220, []byte{'E'})
+	rm, err = stream.Next(ctx)
+	require.NoError(t, err)
+	require.IsType(t, &pglogrepl.StreamStopMessageV2{}, rm.Message)
+}
+
+func TestReplicationStreamRepliesToKeepalive(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{StandbyMessageTimeout: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws.sendKeepalive(0x500, true)
+	next := make(chan error, 1)
+	go func() {
+		_, err := stream.Next(ctx)
+		next <- err
+	}()
+
+	ssu := ws.receiveStandbyStatusUpdate()
+	assert.Equal(t, pglogrepl.LSN(0x500), ssu.WALWritePosition)
+	assert.Equal(t, pglogrepl.LSN(0x500), ssu.WALFlushPosition)
+	assert.Equal(t, pglogrepl.LSN(0x500), ssu.WALApplyPosition)
+
+	ws.sendXLogData(0x600, []byte("data"))
+	require.NoError(t, <-next)
+}
+
+func TestReplicationStreamSendsPeriodicStatusUpdates(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{StandbyMessageTimeout: 50 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	next := make(chan error, 1)
+	go func() {
+		_, err := stream.Next(ctx)
+		next <- err
+	}()
+
+	ssu := ws.receiveStandbyStatusUpdate()
+	assert.Equal(t, pglogrepl.LSN(0x100), ssu.WALWritePosition)
+	ssu = ws.receiveStandbyStatusUpdate()
+	assert.Equal(t, pglogrepl.LSN(0x100), ssu.WALWritePosition)
+
+	ws.send(&pgproto3.CopyDone{})
+	assert.ErrorIs(t, <-next, io.EOF)
+}
+
+func TestReplicationStreamIdleHeartbeat(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{StandbyMessageTimeout: time.Hour, IdleHeartbeatInterval: 50 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	next := make(chan error, 1)
+	go func() {
+		_, err := stream.Next(ctx)
+		next <- err
+	}()
+
+	// The idle stream asks the server for a reply.
+	ssu := ws.receiveStandbyStatusUpdate()
+	assert.True(t, ssu.ReplyRequested)
+	assert.Equal(t, pglogrepl.LSN(0x100), ssu.WALFlushPosition)
+
+	// The keepalive answering it starts another idle interval.
+	ws.sendKeepalive(0x180, false)
+	ssu = ws.receiveStandbyStatusUpdate()
+	assert.True(t, ssu.ReplyRequested)
+	assert.Equal(t, pglogrepl.LSN(0x180), ssu.WALFlushPosition)
+
+	ws.sendXLogData(0x200, []byte("data"))
+	require.NoError(t, <-next)
+}
+
+func TestReplicationStreamReceiveTimeout(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{StandbyMessageTimeout: time.Hour, ReceiveTimeout: 100 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updates := make(chan pglogrepl.StandbyStatusUpdate, 1)
+	go func() {
+		updates <- ws.receiveStandbyStatusUpdate()
+	}()
+
+	start := time.Now()
+	_, err := stream.Next(ctx)
+	require.ErrorIs(t, err, pglogrepl.ErrReceiveTimeout)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.True(t, stream.Conn().IsClosed())
+
+	// The server was asked to reply at half the timeout.
+	assert.True(t, (<-updates).ReplyRequested)
+}
+
+func TestReplicationStreamErrorResponse(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws.send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "58P01", Message: "requested WAL segment has already been removed"})
+	_, err := stream.Next(ctx)
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr))
+	assert.Equal(t, "58P01", pgErr.Code)
+}
+
+func TestReplicationStreamContextCancel(t *testing.T) {
+	stream, _ := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := stream.Next(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestReplicationStreamSetAppliedLSN(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{StandbyMessageTimeout: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws.sendXLogData(0x200, []byte("first"))
+	_, err := stream.Next(ctx)
+	require.NoError(t, err)
+	ws.sendXLogData(0x300, []byte("second"))
+	_, err = stream.Next(ctx)
+	require.NoError(t, err)
+
+	stream.SetAppliedLSN(0x200)
+	stream.SetAppliedLSN(0x150)
+	assert.Equal(t, pglogrepl.LSN(0x200), stream.AppliedLSN())
+
+	ws.sendKeepalive(0x400, true)
+	next := make(chan error, 1)
+	go func() {
+		_, err := stream.Next(ctx)
+		next <- err
+	}()
+
+	ssu := ws.receiveStandbyStatusUpdate()
+	assert.Equal(t, pglogrepl.LSN(0x400), ssu.WALWritePosition)
+	assert.Equal(t, pglogrepl.LSN(0x200), ssu.WALFlushPosition)
+	assert.Equal(t, pglogrepl.LSN(0x200), ssu.WALApplyPosition)
+
+	ws.sendXLogData(0x500, []byte("third"))
+	require.NoError(t, <-next)
+}
+
+func TestReplicationStreamPositions(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{StandbyMessageTimeout: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws.sendXLogData(0x400, []byte("data"))
+	_, err := stream.Next(ctx)
+	require.NoError(t, err)
+	write, flush, apply := stream.Positions()
+	assert.Equal(t, []pglogrepl.LSN{0x400, 0x400, 0x400}, []pglogrepl.LSN{write, flush, apply})
+
+	// The three positions are reported separately once set.
+	stream.SetWrittenLSN(0x300)
+	stream.SetFlushedLSN(0x200)
+	write, flush, apply = stream.Positions()
+	assert.Equal(t, []pglogrepl.LSN{0x300, 0x200, 0x100}, []pglogrepl.LSN{write, flush, apply})
+	stream.SetAppliedLSN(0x180)
+	stream.SetFlushedLSN(0x150)
+	write, flush, apply = stream.Positions()
+	assert.Equal(t, []pglogrepl.LSN{0x300, 0x200, 0x180}, []pglogrepl.LSN{write, flush, apply})
+	// Applying implies flushing, flushing writing.
+	stream.SetAppliedLSN(0x380)
+	write, flush, apply = stream.Positions()
+	assert.Equal(t, []pglogrepl.LSN{0x380, 0x380, 0x380}, []pglogrepl.LSN{write, flush, apply})
+
+	stream.SetFlushedLSN(0x400)
+	ws.sendKeepalive(0x500, true)
+	next := make(chan error, 1)
+	go func() {
+		_, err := stream.Next(ctx)
+		next <- err
+	}()
+	ssu := ws.receiveStandbyStatusUpdate()
+	assert.Equal(t, pglogrepl.LSN(0x400), ssu.WALWritePosition)
+	assert.Equal(t, pglogrepl.LSN(0x400), ssu.WALFlushPosition)
+	assert.Equal(t, pglogrepl.LSN(0x380), ssu.WALApplyPosition)
+	ws.sendXLogData(0x600, []byte("more"))
+	require.NoError(t, <-next)
+}
+
+// TestReplicationStreamConcurrentPositions reads the positions while Next receives, which the race
+// detector checks.
+func TestReplicationStreamConcurrentPositions(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{StandbyMessageTimeout: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	read := make(chan pglogrepl.LSN)
+	go func() {
+		var last pglogrepl.LSN
+		for {
+			select {
+			case <-done:
+				read <- last
+				return
+			default:
+			}
+			write, _, _ := stream.Positions()
+			if pos := stream.ClientXLogPos(); pos > write {
+				write = pos
+			}
+			last = write
+		}
+	}()
+
+	for i := 1; i <= 50; i++ {
+		ws.sendXLogData(pglogrepl.LSN(0x100+i*0x10), []byte("data"))
+		_, err := stream.Next(ctx)
+		require.NoError(t, err)
+	}
+	close(done)
+	assert.LessOrEqual(t, <-read, pglogrepl.LSN(0x100+50*0x10))
+	assert.Equal(t, pglogrepl.LSN(0x100+50*0x10), stream.ClientXLogPos())
+}
+
+func TestReplicationStreamFlushAndReport(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{StandbyMessageTimeout: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws.sendXLogData(0x400, []byte("commit"))
+	_, err := stream.Next(ctx)
+	require.NoError(t, err)
+
+	// The update is sent right away although the next one is only due in an hour.
+	require.NoError(t, stream.FlushAndReport(ctx, 0x400))
+	ssu := ws.receiveStandbyStatusUpdate()
+	assert.Equal(t, pglogrepl.LSN(0x400), ssu.WALWritePosition)
+	assert.Equal(t, pglogrepl.LSN(0x400), ssu.WALFlushPosition)
+	assert.Equal(t, pglogrepl.LSN(0x100), ssu.WALApplyPosition)
+
+	stream.SetAppliedLSN(0x400)
+	require.NoError(t, stream.ReportPositions(ctx))
+	ssu = ws.receiveStandbyStatusUpdate()
+	assert.Equal(t, pglogrepl.LSN(0x400), ssu.WALApplyPosition)
+}
+
+func TestReplicationStreamAckPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy    pglogrepl.AckPolicy
+		setLSN    bool
+		wantFlush pglogrepl.LSN
+	}{
+		{policy: pglogrepl.AckDefault, wantFlush: 0x400},
+		{policy: pglogrepl.AckDefault, setLSN: true, wantFlush: 0x200},
+		{policy: pglogrepl.AckOnReceive, setLSN: true, wantFlush: 0x400},
+		{policy: pglogrepl.AckOnApply, wantFlush: 0x100},
+		{policy: pglogrepl.AckOnApply, setLSN: true, wantFlush: 0x200},
+	} {
+		t.Run(fmt.Sprintf("%s/%v", tt.policy, tt.setLSN), func(t *testing.T) {
+			stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{StandbyMessageTimeout: time.Hour, AckPolicy: tt.policy})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			ws.sendXLogData(0x200, []byte("first"))
+			_, err := stream.Next(ctx)
+			require.NoError(t, err)
+			if tt.setLSN {
+				stream.SetAppliedLSN(0x200)
+			}
+
+			ws.sendKeepalive(0x400, true)
+			next := make(chan error, 1)
+			go func() {
+				_, err := stream.Next(ctx)
+				next <- err
+			}()
+			ssu := ws.receiveStandbyStatusUpdate()
+			assert.Equal(t, pglogrepl.LSN(0x400), ssu.WALWritePosition)
+			assert.Equal(t, tt.wantFlush, ssu.WALFlushPosition)
+			assert.Equal(t, tt.wantFlush, ssu.WALApplyPosition)
+
+			ws.sendXLogData(0x500, []byte("second"))
+			require.NoError(t, <-next)
+		})
+	}
+	assert.Equal(t, "on-apply", pglogrepl.AckOnApply.String())
+}
+
+func TestReplicationStreamPhysical(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	queries := ws.serveStartReplication()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, "", pglogrepl.LSN(0x100), pglogrepl.ReplicationStreamOptions{
+		StartReplicationOptions: pglogrepl.StartReplicationOptions{Mode: pglogrepl.PhysicalReplication, Timeline: 1},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "START_REPLICATION PHYSICAL 0/100 TIMELINE 1", <-queries)
+
+	ws.sendXLogData(0x100, make([]byte, 0x80))
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, rm.Message)
+	assert.Equal(t, pglogrepl.LSN(0x180), stream.ClientXLogPos())
+
+	// The server ends the timeline and reports the next one once the client confirms.
+	ws.send(&pgproto3.CopyDone{})
+	_, err = stream.Next(ctx)
+	require.ErrorIs(t, err, io.EOF)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, ok := ws.receive().(*pgproto3.CopyDone)
+		assert.True(t, ok)
+		ws.send(
+			&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("next_tli")}, {Name: []byte("next_tli_startpos")}}},
+			&pgproto3.DataRow{Values: [][]byte{[]byte("2"), []byte("0/180")}},
+			&pgproto3.CommandComplete{CommandTag: []byte("SELECT")},
+			&pgproto3.CommandComplete{CommandTag: []byte("START_STREAMING")},
+			&pgproto3.ReadyForQuery{TxStatus: 'I'},
+		)
+	}()
+
+	cdr, err := pglogrepl.SendStandbyCopyDone(ctx, conn)
+	require.NoError(t, err)
+	<-done
+	assert.Equal(t, &pglogrepl.CopyDoneResult{Timeline: 2, LSN: 0x180}, cdr)
+}
+
+func TestDrainStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The client stops streaming while the server is still sending data.
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ws.sendXLogData(0x100, []byte("in flight"))
+		_, ok := ws.receive().(*pgproto3.CopyDone)
+		assert.True(t, ok)
+		ws.sendXLogData(0x200, []byte("discarded"))
+		ws.send(
+			&pgproto3.CopyDone{},
+			&pgproto3.CommandComplete{CommandTag: []byte("COPY 0")},
+			&pgproto3.ReadyForQuery{TxStatus: 'I'},
+		)
+	}()
+	cdr, err := pglogrepl.DrainStream(ctx, stream.Conn())
+	require.NoError(t, err)
+	<-done
+	assert.Equal(t, pglogrepl.CopyDoneResult{}, cdr)
+
+	// The connection can be used again.
+	queries := ws.serveQuery(identifySystemResponse("7000", 1))
+	_, err = pglogrepl.IdentifySystem(ctx, stream.Conn())
+	require.NoError(t, err)
+	assert.Equal(t, "IDENTIFY_SYSTEM", <-queries)
+
+	// The server ends a timeline.
+	stream, ws = startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{})
+	ws.send(&pgproto3.CopyDone{})
+	_, err = stream.Next(ctx)
+	require.ErrorIs(t, err, io.EOF)
+	go func() {
+		ws.receive()
+		ws.send(
+			&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("next_tli")}, {Name: []byte("next_tli_startpos")}}},
+			&pgproto3.DataRow{Values: [][]byte{[]byte("3"), []byte("0/5000000")}},
+			&pgproto3.CommandComplete{CommandTag: []byte("SELECT")},
+			&pgproto3.ErrorResponse{Severity: "ERROR", Code: "XX000", Message: "boom"},
+			&pgproto3.ReadyForQuery{TxStatus: 'I'},
+		)
+	}()
+	cdr, err = pglogrepl.DrainStream(ctx, stream.Conn())
+	assert.ErrorContains(t, err, "boom")
+	assert.Equal(t, pglogrepl.CopyDoneResult{Timeline: 3, LSN: 0x5000000}, cdr)
+}
+
+func TestReplicationStreamStop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{StandbyMessageTimeout: time.Hour})
+	ws.sendXLogData(0x200, []byte("data"))
+	_, err := stream.Next(ctx)
+	require.NoError(t, err)
+	stream.SetAppliedLSN(0x180)
+
+	updates := make(chan pglogrepl.StandbyStatusUpdate, 1)
+	go func() {
+		updates <- ws.receiveStandbyStatusUpdate()
+		_, ok := ws.receive().(*pgproto3.CopyDone)
+		assert.True(t, ok)
+		ws.sendXLogData(0x300, []byte("discarded"))
+		ws.send(
+			&pgproto3.CopyDone{},
+			&pgproto3.CommandComplete{CommandTag: []byte("START_REPLICATION")},
+			&pgproto3.ReadyForQuery{TxStatus: 'I'},
+		)
+	}()
+	require.NoError(t, stream.Stop(ctx))
+	ssu := <-updates
+	assert.Equal(t, pglogrepl.LSN(0x200), ssu.WALWritePosition)
+	assert.Equal(t, pglogrepl.LSN(0x180), ssu.WALFlushPosition)
+	assert.Equal(t, pglogrepl.LSN(0x180), ssu.WALApplyPosition)
+
+	require.NoError(t, stream.Stop(ctx))
+	_, err = stream.Next(ctx)
+	assert.ErrorIs(t, err, pglogrepl.ErrStreamStopped)
+
+	// The connection can be used again.
+	queries := ws.serveQuery(identifySystemResponse("7000", 1))
+	_, err = pglogrepl.IdentifySystem(ctx, stream.Conn())
+	require.NoError(t, err)
+	assert.Equal(t, "IDENTIFY_SYSTEM", <-queries)
+}
+
+func TestReplicationStreamClose(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The server ended the copy-both mode before the stream is closed.
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{})
+	ws.sendKeepalive(0x400, false)
+	ws.send(&pgproto3.CopyDone{})
+	_, err := stream.Next(ctx)
+	require.ErrorIs(t, err, io.EOF)
+
+	updates := make(chan pglogrepl.StandbyStatusUpdate, 1)
+	go func() {
+		updates <- ws.receiveStandbyStatusUpdate()
+		ws.receive()
+		ws.send(
+			&pgproto3.CommandComplete{CommandTag: []byte("START_REPLICATION")},
+			&pgproto3.ReadyForQuery{TxStatus: 'I'},
+		)
+	}()
+	require.NoError(t, stream.Close(ctx))
+	assert.Equal(t, pglogrepl.LSN(0x400), (<-updates).WALFlushPosition)
+	assert.True(t, stream.Conn().IsClosed())
+}
+
+func TestReplicationStreamPhysicalRejectsProtoVersion(t *testing.T) {
+	conn, _ := newFakeWalSender(t)
+	_, err := pglogrepl.StartReplicationStream(context.Background(), conn, slotName, 0, pglogrepl.ReplicationStreamOptions{
+		StartReplicationOptions: pglogrepl.StartReplicationOptions{Mode: pglogrepl.PhysicalReplication},
+		ProtoVersion:            1,
+	})
+	require.Error(t, err)
+}
+
+func TestReplicationStreamReconnect(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	conn2, ws2 := newFakeWalSender(t)
+	queries := ws.serveStartReplication()
+
+	type attempt struct {
+		n   int
+		err error
+	}
+	attempts := make(chan attempt, 10)
+	connects := 0
+	options := pglogrepl.ReplicationStreamOptions{
+		ProtoVersion: 1,
+		Reconnect: &pglogrepl.ReconnectPolicy{
+			InitialBackoff: time.Millisecond,
+			Connect: func(ctx context.Context) (*pgconn.PgConn, error) {
+				connects++
+				if connects == 1 {
+					return nil, errors.New("connection refused")
+				}
+				return conn2, nil
+			},
+			OnReconnect: func(n int, err error) { attempts <- attempt{n, err} },
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, slotName, pglogrepl.LSN(0x100), options)
+	require.NoError(t, err)
+	<-queries
+	stream.SetAppliedLSN(0x180)
+
+	ws.sendXLogData(0x200, beginMessageData(0x300, 42))
+	_, err = stream.Next(ctx)
+	require.NoError(t, err)
+
+	// The server goes away. The stream resumes on the new connection from the applied position.
+	queries2 := ws2.serveStartReplication()
+	resumed := make(chan string, 1)
+	go func() {
+		query := <-queries2
+		resumed <- query
+		ws2.sendXLogData(0x200, beginMessageData(0x300, 42))
+	}()
+	ws.conn.Close()
+
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x200), rm.WALStart)
+	assert.True(t, strings.HasPrefix(<-resumed, "START_REPLICATION SLOT "+slotName+" LOGICAL 0/180"))
+	assert.Same(t, conn2, stream.Conn())
+	assert.Equal(t, 1, stream.Reconnects())
+
+	first := <-attempts
+	assert.Equal(t, 1, first.n)
+	assert.Error(t, first.err)
+	second := <-attempts
+	assert.Equal(t, 2, second.n)
+	assert.EqualError(t, second.err, "connection refused")
+}
+
+func TestReplicationStreamReconnectGivesUp(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	queries := ws.serveStartReplication()
+
+	options := pglogrepl.ReplicationStreamOptions{
+		Reconnect: &pglogrepl.ReconnectPolicy{
+			InitialBackoff: time.Millisecond,
+			MaxAttempts:    3,
+			Connect: func(ctx context.Context) (*pgconn.PgConn, error) {
+				return nil, errors.New("connection refused")
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, slotName, pglogrepl.LSN(0x100), options)
+	require.NoError(t, err)
+	<-queries
+
+	ws.conn.Close()
+	_, err = stream.Next(ctx)
+	assert.ErrorContains(t, err, "connection refused")
+	assert.Equal(t, 0, stream.Reconnects())
+}
+
+func identifySystemResponse(systemID string, timeline int) []pgproto3.BackendMessage {
+	return []pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("systemid")}, {Name: []byte("timeline")}, {Name: []byte("xlogpos")}, {Name: []byte("dbname")}}},
+		&pgproto3.DataRow{Values: [][]byte{[]byte(systemID), []byte(strconv.Itoa(timeline)), []byte("0/3000000"), []byte("postgres")}},
+		&pgproto3.CommandComplete{CommandTag: []byte("IDENTIFY_SYSTEM")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	}
+}
+
+func TestReplicationStreamSwitchover(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	conn2, ws2 := newFakeWalSender(t)
+	conn3, ws3 := newFakeWalSender(t)
+
+	var switchovers []pglogrepl.Switchover
+	connects := []*pgconn.PgConn{conn2, conn3}
+	options := pglogrepl.ReplicationStreamOptions{
+		ProtoVersion: 1,
+		Reconnect: &pglogrepl.ReconnectPolicy{
+			InitialBackoff: time.Millisecond,
+			Connect: func(ctx context.Context) (*pgconn.PgConn, error) {
+				conn := connects[0]
+				connects = connects[1:]
+				return conn, nil
+			},
+			OnSwitchover: func(ctx context.Context, conn *pgconn.PgConn, switchover pglogrepl.Switchover) error {
+				switchovers = append(switchovers, switchover)
+				if switchover.SystemChanged() {
+					return errors.New("unknown cluster")
+				}
+				return nil
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	identified := ws.serveQuery(identifySystemResponse("7000", 1))
+	queries := make(chan string, 1)
+	go func() {
+		<-identified
+		queries <- <-ws.serveStartReplication()
+	}()
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, slotName, pglogrepl.LSN(0x100), options)
+	require.NoError(t, err)
+	<-queries
+
+	// The standby is promoted. The switchover is accepted and replication resumes.
+	identified2 := ws2.serveQuery(identifySystemResponse("7000", 2))
+	go func() {
+		<-identified2
+		<-ws2.serveStartReplication()
+		ws2.sendXLogData(0x200, beginMessageData(0x300, 42))
+	}()
+	ws.conn.Close()
+
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x200), rm.WALStart)
+	require.Len(t, switchovers, 1)
+	assert.True(t, switchovers[0].Promoted())
+	assert.Equal(t, int32(1), switchovers[0].Previous.Timeline)
+	assert.Equal(t, int32(2), switchovers[0].Current.Timeline)
+
+	// A server of another cluster is rejected.
+	ws3.serveQuery(identifySystemResponse("8000", 1))
+	ws2.conn.Close()
+
+	_, err = stream.Next(ctx)
+	assert.ErrorContains(t, err, "switchover rejected: unknown cluster")
+	require.Len(t, switchovers, 2)
+	assert.True(t, switchovers[1].SystemChanged())
+	assert.Equal(t, 1, stream.Reconnects())
+}
+
+func TestReplicationStreamVerifySystemID(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	conn2, ws2 := newFakeWalSender(t)
+	conn3, ws3 := newFakeWalSender(t)
+
+	connects := []*pgconn.PgConn{conn2, conn3}
+	options := pglogrepl.ReplicationStreamOptions{
+		ProtoVersion: 1,
+		Reconnect: &pglogrepl.ReconnectPolicy{
+			InitialBackoff: time.Millisecond,
+			Connect: func(ctx context.Context) (*pgconn.PgConn, error) {
+				conn := connects[0]
+				connects = connects[1:]
+				return conn, nil
+			},
+			VerifySystemID: true,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	identified := ws.serveQuery(identifySystemResponse("7000", 1))
+	queries := make(chan string, 1)
+	go func() {
+		<-identified
+		queries <- <-ws.serveStartReplication()
+	}()
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, slotName, pglogrepl.LSN(0x100), options)
+	require.NoError(t, err)
+	<-queries
+	system, ok := stream.System()
+	require.True(t, ok)
+	assert.Equal(t, "7000", system.SystemID)
+
+	// A promoted standby of the same cluster is accepted.
+	identified2 := ws2.serveQuery(identifySystemResponse("7000", 2))
+	go func() {
+		<-identified2
+		<-ws2.serveStartReplication()
+		ws2.sendXLogData(0x200, beginMessageData(0x300, 42))
+	}()
+	ws.conn.Close()
+	_, err = stream.Next(ctx)
+	require.NoError(t, err)
+	system, _ = stream.System()
+	assert.Equal(t, int32(2), system.Timeline)
+
+	ws3.serveQuery(identifySystemResponse("8000", 1))
+	ws2.conn.Close()
+	_, err = stream.Next(ctx)
+	assert.ErrorIs(t, err, pglogrepl.ErrSystemIDMismatch)
+	var mismatch *pglogrepl.SystemIDMismatchError
+	require.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, pglogrepl.SystemIDMismatchError{Expected: "7000", Actual: "8000"}, *mismatch)
+	assert.Equal(t, 1, stream.Reconnects())
+}
+
+func TestReplicationStreamFollowTimeline(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+	conn2, ws2 := newFakeWalSender(t)
+	conn3, ws3 := newFakeWalSender(t)
+
+	connects := []*pgconn.PgConn{conn2, conn3}
+	options := pglogrepl.ReplicationStreamOptions{
+		ProtoVersion: 1,
+		Reconnect: &pglogrepl.ReconnectPolicy{
+			InitialBackoff: time.Millisecond,
+			Connect: func(ctx context.Context) (*pgconn.PgConn, error) {
+				conn := connects[0]
+				connects = connects[1:]
+				return conn, nil
+			},
+			FollowTimeline: true,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	identified := ws.serveQuery(identifySystemResponse("7000", 1))
+	queries := make(chan string, 1)
+	go func() {
+		<-identified
+		queries <- <-ws.serveStartReplication()
+	}()
+	stream, err := pglogrepl.StartReplicationStream(ctx, conn, slotName, pglogrepl.LSN(0x100), options)
+	require.NoError(t, err)
+	<-queries
+
+	// The walsender is terminated by the promotion of the standby the slot is synchronized to.
+	identified2 := ws2.serveQuery(identifySystemResponse("7000", 2))
+	slotQueries := make(chan string, 1)
+	go func() {
+		<-identified2
+		slotQueries <- <-ws2.serveQuery(rowsResponse(4, []string{"t", "t", "", "0/100"}))
+		<-ws2.serveStartReplication()
+		ws2.sendXLogData(0x200, beginMessageData(0x300, 42))
+	}()
+	ws.send(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "57P01", Message: "terminating walsender process due to administrator command"})
+
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x200), rm.WALStart)
+	assert.Contains(t, <-slotQueries, "slot_name = '"+slotName+"'")
+	switches := stream.TimelineSwitches()
+	require.Len(t, switches, 1)
+	assert.Equal(t, int32(1), switches[0].PreviousTimeline)
+	assert.Equal(t, int32(2), switches[0].Timeline)
+	assert.Equal(t, pglogrepl.LSN(0x100), switches[0].LSN)
+	assert.False(t, switches[0].Time.IsZero())
+
+	// The server ends replication and the stream reconnects to a server on an earlier timeline.
+	ws3.serveQuery(identifySystemResponse("7000", 1))
+	ws2.send(&pgproto3.CopyDone{})
+
+	_, err = stream.Next(ctx)
+	assert.ErrorContains(t, err, "reconnected to timeline 1, earlier than timeline 2")
+	assert.Len(t, stream.TimelineSwitches(), 1)
+	assert.Equal(t, 1, stream.Reconnects())
+}
+
+func TestReplicationStreamPoolBuffers(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{ProtoVersion: 1, PoolBuffers: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws.sendXLogData(0x200, beginMessageData(0x300, 42))
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	begin, ok := rm.Message.(*pglogrepl.BeginMessage)
+	require.True(t, ok)
+	assert.Equal(t, uint32(42), begin.Xid)
+
+	rm.Release()
+	assert.Nil(t, rm.WALData)
+	assert.Nil(t, rm.Message)
+	rm.Release()
+
+	ws.sendXLogData(0x210, beginMessageData(0x310, 43))
+	rm, err = stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x210), rm.WALStart)
+	assert.Equal(t, uint32(43), rm.Message.(*pglogrepl.BeginMessage).Xid)
+	assert.Equal(t, beginMessageData(0x310, 43), rm.WALData)
+	rm.Release()
+}
+
+func TestReplicationStreamLargeColumnSize(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{ProtoVersion: 1, LargeColumnSize: 8})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	insert := &pglogrepl.InsertMessage{RelationID: 1, Tuple: &pglogrepl.TupleData{ColumnNum: 2, Columns: []*pglogrepl.TupleDataColumn{
+		{DataType: pglogrepl.TupleDataTypeText, Length: 1, Data: []byte("1")},
+		{DataType: pglogrepl.TupleDataTypeText, Length: 10, Data: []byte("0123456789")},
+	}}}
+	walData, err := insert.Encode(nil)
+	require.NoError(t, err)
+	ws.sendXLogData(0x200, walData)
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	columns := rm.Message.(*pglogrepl.InsertMessage).Tuple.Columns
+
+	// Only the large column refers to the WAL data.
+	rm.WALData[len(rm.WALData)-1] = 'x'
+	rm.WALData[1+4+1+2+1+4] = 'x'
+	assert.Equal(t, "1", string(columns[0].Data))
+	large, err := io.ReadAll(columns[1].Reader())
+	require.NoError(t, err)
+	assert.Equal(t, "012345678x", string(large))
+}
+
+func TestReplicationStreamSkipTransaction(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{ProtoVersion: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	relation, err := (&pglogrepl.RelationMessage{RelationID: 1, Namespace: "public", RelationName: "t"}).Encode(nil)
+	require.NoError(t, err)
+	stream.SkipTransaction(0x300)
+	ws.sendXLogData(0x200, beginMessageData(0x300, 42))
+	ws.sendXLogData(0x210, relation)
+	ws.sendXLogData(0x220, insertMessageData(t, "poison"))
+	ws.sendXLogData(0x300, commitMessageData(0x300, 0x310))
+	ws.sendXLogData(0x310, beginMessageData(0x400, 43))
+
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.IsType(t, &pglogrepl.RelationMessage{}, rm.Message)
+	rm, err = stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(43), rm.Message.(*pglogrepl.BeginMessage).Xid)
+	assert.Equal(t, pglogrepl.LSN(0x310), stream.ClientXLogPos())
+}
+
+type recordingMetrics struct {
+	pglogrepl.NopStreamMetrics
+	messages      []pglogrepl.MessageType
+	walDataSize   int
+	keepalives    int
+	clientXLogPos pglogrepl.LSN
+	updates       int
+}
+
+func (m *recordingMetrics) MessageReceived(msgType pglogrepl.MessageType, walDataSize int) {
+	m.messages = append(m.messages, msgType)
+	m.walDataSize += walDataSize
+}
+
+func (m *recordingMetrics) KeepaliveReceived()                    { m.keepalives++ }
+func (m *recordingMetrics) ClientXLogPos(lsn pglogrepl.LSN)       { m.clientXLogPos = lsn }
+func (m *recordingMetrics) StandbyStatusUpdateSent(time.Duration) { m.updates++ }
+
+func TestReplicationStreamMetrics(t *testing.T) {
+	metrics := &recordingMetrics{}
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{ProtoVersion: 1, Metrics: metrics})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws.sendXLogData(0x200, beginMessageData(0x300, 42))
+	ws.sendKeepalive(0x280, true)
+	ws.sendXLogData(0x300, commitMessageData(0x300, 0x310))
+	_, err := stream.Next(ctx)
+	require.NoError(t, err)
+	next := make(chan error, 1)
+	go func() {
+		_, err := stream.Next(ctx)
+		next <- err
+	}()
+	ws.receiveStandbyStatusUpdate()
+	require.NoError(t, <-next)
+
+	assert.Equal(t, []pglogrepl.MessageType{pglogrepl.MessageTypeBegin, pglogrepl.MessageTypeCommit}, metrics.messages)
+	assert.Equal(t, len(beginMessageData(0x300, 42))+len(commitMessageData(0x300, 0x310)), metrics.walDataSize)
+	assert.Equal(t, 1, metrics.keepalives)
+	assert.Equal(t, pglogrepl.LSN(0x300), metrics.clientXLogPos)
+	assert.Equal(t, 1, metrics.updates)
+}
+
+type logRecord struct {
+	level string
+	msg   string
+	args  []interface{}
+}
+
+type recordingLogger struct {
+	records []logRecord
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) {
+	l.records = append(l.records, logRecord{"DEBUG", msg, args})
+}
+
+func (l *recordingLogger) Info(msg string, args ...interface{}) {
+	l.records = append(l.records, logRecord{"INFO", msg, args})
+}
+
+func (l *recordingLogger) Warn(msg string, args ...interface{}) {
+	l.records = append(l.records, logRecord{"WARN", msg, args})
+}
+
+func (l *recordingLogger) Error(msg string, args ...interface{}) {
+	l.records = append(l.records, logRecord{"ERROR", msg, args})
+}
+
+func TestReplicationStreamLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{ProtoVersion: 1, Logger: logger})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws.sendKeepalive(0x180, false)
+	ws.send(&pgproto3.NoticeResponse{Severity: "WARNING", Code: "01000", Message: "slot is lagging"})
+	ws.sendXLogData(0x200, []byte{'?'})
+	_, err := stream.Next(ctx)
+	require.Error(t, err)
+
+	assert.Equal(t, []logRecord{
+		{"DEBUG", "primary keepalive received", []interface{}{"server_wal_end", pglogrepl.LSN(0x180), "reply_requested", false}},
+		{"WARN", "server notice", []interface{}{"severity", "WARNING", "code", "01000", "message", "slot is lagging"}},
+		{"ERROR", "received invalid logical replication message", []interface{}{"wal_start", pglogrepl.LSN(0x200), "error", err}},
+	}, logger.records)
+}
+
+func TestReplicationStreamTypeLoader(t *testing.T) {
+	connects := 0
+	loader := pglogrepl.NewLazyTypeLoader(func(ctx context.Context) (*pgx.Conn, error) {
+		connects++
+		return nil, errors.New("no database")
+	}, pgtype.NewMap())
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{ProtoVersion: 1, TypeLoader: loader})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	typeMessage := func(oid uint32, name string) []byte {
+		data := binary.BigEndian.AppendUint32([]byte{'Y'}, oid)
+		data = append(data, "public\x00"...)
+		return append(data, name+"\x00"...)
+	}
+
+	// Built-in types are registered without connecting.
+	ws.sendXLogData(0x200, typeMessage(pgtype.Int4OID, "int4"))
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.IsType(t, &pglogrepl.TypeMessage{}, rm.Message)
+	assert.Equal(t, 0, connects)
+
+	ws.sendXLogData(0x300, typeMessage(90000, "mood"))
+	_, err = stream.Next(ctx)
+	assert.ErrorContains(t, err, "no database")
+	assert.Equal(t, 1, connects)
+}
+
+func TestReplicationStreamUnknownMessagePolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{ProtoVersion: 1})
+	ws.sendXLogData(0x200, []byte("Zdata"))
+	_, err := stream.Next(ctx)
+	assert.ErrorIs(t, err, pglogrepl.ErrUnknownMessageType)
+
+	stream, ws = startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{
+		ProtoVersion:         1,
+		UnknownMessagePolicy: pglogrepl.UnknownMessageSkip,
+	})
+	ws.sendXLogData(0x200, []byte("Zdata"))
+	ws.sendXLogData(0x300, beginMessageData(0x400, 42))
+	rm, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x300), rm.WALStart)
+
+	var unknown []pglogrepl.XLogData
+	stream, ws = startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{
+		ProtoVersion:         1,
+		UnknownMessagePolicy: pglogrepl.UnknownMessageCallback,
+		OnUnknownMessage: func(xld pglogrepl.XLogData) error {
+			unknown = append(unknown, xld)
+			if string(xld.WALData) == "Zfail" {
+				return errors.New("callback failed")
+			}
+			return nil
+		},
+	})
+	ws.sendXLogData(0x200, []byte("Zdata"))
+	ws.sendXLogData(0x300, beginMessageData(0x400, 42))
+	rm, err = stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x300), rm.WALStart)
+	require.Len(t, unknown, 1)
+	assert.Equal(t, pglogrepl.LSN(0x200), unknown[0].WALStart)
+	assert.Equal(t, "Zdata", string(unknown[0].WALData))
+
+	ws.sendXLogData(0x500, []byte("Zfail"))
+	_, err = stream.Next(ctx)
+	assert.EqualError(t, err, "callback failed")
+
+	assert.Equal(t, "skip", pglogrepl.UnknownMessageSkip.String())
+}
+
+func TestReplicationStreamWireDump(t *testing.T) {
+	var dump bytes.Buffer
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{
+		ProtoVersion:      1,
+		WireDump:          &dump,
+		WireDumpDataLimit: 4,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws.sendKeepalive(0x180, true)
+	ws.sendXLogData(0x200, beginMessageData(0x300, 42))
+	_, err := stream.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x180), ws.receiveStandbyStatusUpdate().WALWritePosition)
+
+	lines := strings.Split(strings.TrimSuffix(dump.String(), "\n"), "\n")
+	require.Len(t, lines, 5, dump.String())
+	assert.Regexp(t, `^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z recv PrimaryKeepaliveMessage server_wal_end=0/180 server_time=\S+ reply_requested=true$`, lines[0])
+	assert.Regexp(t, ` send StandbyStatusUpdate write=0/180 flush=0/180 apply=0/180 reply_requested=false$`, lines[1])
+	assert.Regexp(t, ` recv XLogData wal_start=0/200 server_wal_end=0/\w+ server_time=\S+ type=Begin len=21$`, lines[2])
+	assert.Equal(t, "00000000  42 00 00 00                                       |B...|", lines[3])
+	assert.Equal(t, "...", lines[4])
+}
@@ -0,0 +1,311 @@
+package pglogrepl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SubscriptionHandler is called by a Subscription for every logical replication message received.
+// If it returns an error the subscription stops and the message is not acknowledged.
+type SubscriptionHandler func(ctx context.Context, msg *ReplicationMessage) error
+
+// SubscriptionOptions configures a Subscription.
+type SubscriptionOptions struct {
+	// SlotName is the name of the logical replication slot to stream from. It is created with the
+	// pgoutput plugin if it does not exist.
+	SlotName string
+	// TemporarySlot creates the slot as a temporary slot which is dropped when the connection is
+	// closed.
+	TemporarySlot bool
+	// SlotConn, if set, is a regular connection to the database the slot is created on with
+	// CreateReplicationSlotSQL rather than with the CREATE_REPLICATION_SLOT command, for managed
+	// environments restricting the replication commands. A temporary slot is held by the session
+	// creating it, so SlotConn cannot be used with TemporarySlot.
+	SlotConn *pgconn.PgConn
+
+	// PublicationName is the name of the publication to subscribe to.
+	PublicationName string
+	// CreatePublication creates the publication if it does not exist. It publishes the tables in
+	// PublicationTables, or all tables if PublicationTables is empty, which requires superuser
+	// privileges.
+	CreatePublication bool
+	// PublicationTables lists the tables published by a publication created by the
+	// subscription.
+	PublicationTables []string
+
+	// CheckEnvironment makes Run detect the server environment with DetectServerEnvironment
+	// first, fail early with the error of its CheckLogicalReplication, and explain the errors of
+	// creating the slot and starting replication with the fix for the service of the server, such
+	// as granting rds_replication on RDS and Aurora, where no user is a superuser.
+	CheckEnvironment bool
+
+	// ProtoVersion is the pgoutput protocol version. If it is 0 then 1 is used.
+	ProtoVersion int
+	// PluginArgs are additional pgoutput plugin arguments such as "messages 'true'" or
+	// "streaming 'true'". proto_version and publication_names are added by the subscription.
+	PluginArgs []string
+
+	// StartLSN is the position to start streaming from. If it is 0 the position stored in
+	// CheckpointStore is used, and if there is none the server starts from the slot's confirmed
+	// flush position.
+	StartLSN LSN
+	// CheckpointStore, if set, stores the end position of every handled transaction so that
+	// replication resumes after it even if the slot's confirmed position was not updated.
+	CheckpointStore CheckpointStore
+	// StandbyMessageTimeout is the interval at which standby status updates are sent. If it is 0
+	// then 10 seconds is used.
+	StandbyMessageTimeout time.Duration
+	// Reconnect enables reconnecting when the connection is lost. Replication resumes from the
+	// last confirmed transaction.
+	Reconnect *ReconnectPolicy
+	// Logger, if set, receives the log records of the subscription and its stream.
+	Logger Logger
+	// Metrics, if set, receives the measurements of the stream.
+	Metrics StreamMetrics
+
+	// Handler is called for every message received.
+	Handler SubscriptionHandler
+	// Sink, if set instead of Handler, receives the messages and the positions it reports durable
+	// are confirmed, see RunSink.
+	Sink Sink
+	// SinkFlushInterval is the interval at which Sink is flushed. If it is 0 then 1 second is used.
+	SinkFlushInterval time.Duration
+}
+
+// Subscription consumes a pgoutput publication through a logical replication slot. It sets up
+// the slot and optionally the publication, starts replication, dispatches the decoded messages to
+// a handler and confirms to the server the positions of the transactions the handler has
+// processed.
+//
+// Progress is confirmed at transaction boundaries only: once the handler returns successfully for
+// a commit, the end of the transaction is confirmed as flushed so the server can discard the WAL
+// it no longer needs for the slot. A restarted subscription therefore receives again any
+// transaction that was not completely handled. With a Sink the positions returned by its Flush
+// are confirmed instead.
+type Subscription struct {
+	conn    *pgconn.PgConn
+	options SubscriptionOptions
+	logger  Logger
+	// env is the environment detected by Run with CheckEnvironment.
+	env *ServerEnvironment
+
+	mu     sync.Mutex
+	stream *ReplicationStream
+}
+
+// NewSubscription returns a Subscription using conn, which must be a replication connection to
+// the database to replicate.
+func NewSubscription(conn *pgconn.PgConn, options SubscriptionOptions) *Subscription {
+	if options.ProtoVersion == 0 {
+		options.ProtoVersion = 1
+	}
+	logger := options.Logger
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	return &Subscription{conn: conn, options: options, logger: logger}
+}
+
+// Run sets up the subscription and dispatches messages to the handler until ctx is canceled, the
+// server ends replication or the handler returns an error. It returns ctx.Err() if ctx was
+// canceled and io.EOF if the server ended replication.
+func (s *Subscription) Run(ctx context.Context) error {
+	if (s.options.Handler == nil) == (s.options.Sink == nil) {
+		return fmt.Errorf("subscription must have either a handler or a sink")
+	}
+	if s.options.SlotName == "" {
+		return fmt.Errorf("subscription has no slot name")
+	}
+	if s.options.PublicationName == "" {
+		return fmt.Errorf("subscription has no publication name")
+	}
+	if s.options.SlotConn != nil && s.options.TemporarySlot {
+		return fmt.Errorf("subscription cannot create a temporary slot on SlotConn")
+	}
+
+	if s.options.CheckEnvironment {
+		env, err := DetectServerEnvironment(ctx, s.conn)
+		if err != nil {
+			return err
+		}
+		s.logger.Debug("detected server environment", "service", env.Service, "superuser", env.Superuser, "replication", env.Replication)
+		if err := env.CheckLogicalReplication(); err != nil {
+			return err
+		}
+		s.env = &env
+	}
+
+	if s.options.CreatePublication {
+		if err := s.createPublication(ctx); err != nil {
+			return err
+		}
+	}
+	if err := s.createSlot(ctx); err != nil {
+		return err
+	}
+
+	startLSN := s.options.StartLSN
+	if startLSN == 0 && s.options.CheckpointStore != nil {
+		lsn, err := s.options.CheckpointStore.Load(ctx, s.options.SlotName)
+		if err != nil {
+			return fmt.Errorf("failed to load checkpoint: %w", err)
+		}
+		startLSN = lsn
+	}
+	s.logger.Info("starting replication", "slot", s.options.SlotName, "publication", s.options.PublicationName, "start_lsn", startLSN)
+
+	stream, err := StartReplicationStream(ctx, s.conn, s.options.SlotName, startLSN, ReplicationStreamOptions{
+		StartReplicationOptions: StartReplicationOptions{
+			PluginArgs: s.options.PluginArgs,
+			Pgoutput: &PgoutputOptions{
+				ProtoVersion:     s.options.ProtoVersion,
+				PublicationNames: []string{s.options.PublicationName},
+			},
+		},
+		StandbyMessageTimeout: s.options.StandbyMessageTimeout,
+		Reconnect:             s.options.Reconnect,
+		Logger:                s.options.Logger,
+		Metrics:               s.options.Metrics,
+		// Nothing beyond the start position is confirmed until a transaction has been handled.
+		AckPolicy: AckOnApply,
+	})
+	if err != nil {
+		return s.explain(err)
+	}
+	s.mu.Lock()
+	s.stream = stream
+	s.mu.Unlock()
+
+	if s.options.Sink != nil {
+		return RunSink(ctx, stream, s.options.Sink, RunSinkOptions{
+			FlushInterval: s.options.SinkFlushInterval,
+			OnFlush:       s.storeCheckpoint,
+		})
+	}
+
+	for {
+		msg, err := stream.Next(ctx)
+		if err != nil {
+			return err
+		}
+		if err := s.options.Handler(ctx, msg); err != nil {
+			return err
+		}
+		if lsn, ok := transactionEndLSN(msg.Message); ok {
+			if err := s.storeCheckpoint(ctx, lsn); err != nil {
+				return err
+			}
+			stream.SetAppliedLSN(lsn)
+		}
+	}
+}
+
+func (s *Subscription) storeCheckpoint(ctx context.Context, lsn LSN) error {
+	if s.options.CheckpointStore == nil {
+		return nil
+	}
+	if err := s.options.CheckpointStore.Store(ctx, s.options.SlotName, lsn); err != nil {
+		return fmt.Errorf("failed to store checkpoint: %w", err)
+	}
+	s.logger.Debug("stored checkpoint", "slot", s.options.SlotName, "lsn", lsn)
+	return nil
+}
+
+// ConfirmedLSN returns the position up to which the handled transactions have been confirmed.
+// It is 0 before Run has started replication.
+func (s *Subscription) ConfirmedLSN() LSN {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stream == nil {
+		return 0
+	}
+	return s.stream.AppliedLSN()
+}
+
+func (s *Subscription) createPublication(ctx context.Context) error {
+	options := PublicationOptions{AllTables: len(s.options.PublicationTables) == 0}
+	for _, table := range s.options.PublicationTables {
+		options.Tables = append(options.Tables, PublicationTable{Name: table})
+	}
+
+	err := CreatePublication(ctx, s.conn, s.options.PublicationName, options)
+	switch {
+	case err == nil:
+		s.logger.Info("created publication", "publication", s.options.PublicationName)
+	case isDuplicateObject(err):
+		s.logger.Debug("publication exists", "publication", s.options.PublicationName)
+	default:
+		return err
+	}
+	return nil
+}
+
+func (s *Subscription) createSlot(ctx context.Context) error {
+	options := CreateReplicationSlotOptions{Temporary: s.options.TemporarySlot, Mode: LogicalReplication}
+	var err error
+	if s.options.SlotConn != nil {
+		_, err = CreateReplicationSlotSQL(ctx, s.options.SlotConn, s.options.SlotName, "pgoutput", options)
+	} else {
+		_, err = CreateReplicationSlot(ctx, s.conn, s.options.SlotName, "pgoutput", options)
+	}
+	switch {
+	case err == nil:
+		s.logger.Info("created replication slot", "slot", s.options.SlotName, "temporary", s.options.TemporarySlot)
+	case isDuplicateObject(err):
+		s.logger.Debug("replication slot exists", "slot", s.options.SlotName)
+	default:
+		return fmt.Errorf("failed to create replication slot: %w", s.explain(err))
+	}
+	return nil
+}
+
+// explain returns err explained by the environment detected with CheckEnvironment.
+func (s *Subscription) explain(err error) error {
+	if s.env == nil {
+		return err
+	}
+	return s.env.ExplainReplicationError(err)
+}
+
+// transactionEndLSN returns the position following msg if msg ends a transaction.
+func transactionEndLSN(msg Message) (LSN, bool) {
+	switch msg := msg.(type) {
+	case *CommitMessage:
+		return msg.TransactionEndLSN, true
+	case *StreamCommitMessageV2:
+		return msg.TransactionEndLSN, true
+	case *PrepareMessageV3:
+		return msg.EndPrepareLSN, true
+	case *StreamPrepareMessageV3:
+		return msg.EndPrepareLSN, true
+	case *CommitPreparedMessageV3:
+		return msg.EndCommitLSN, true
+	case *RollbackPreparedMessageV3:
+		return msg.EndRollbackLSN, true
+	}
+	return 0, false
+}
+
+func isDuplicateObject(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "42710"
+}
+
+func quoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// quoteQualifiedIdentifier quotes an optionally schema qualified name such as "public.t".
+func quoteQualifiedIdentifier(s string) string {
+	parts := strings.SplitN(s, ".", 2)
+	for i := range parts {
+		parts[i] = quoteIdentifier(parts[i])
+	}
+	return strings.Join(parts, ".")
+}
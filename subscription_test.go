@@ -0,0 +1,175 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+)
+
+func commitMessageData(commitLSN, endLSN pglogrepl.LSN) []byte {
+	data := make([]byte, 1+1+8+8+8)
+	data[0] = 'C'
+	binary.BigEndian.PutUint64(data[2:], uint64(commitLSN))
+	binary.BigEndian.PutUint64(data[10:], uint64(endLSN))
+	return data
+}
+
+func duplicateObjectResponse() []pgproto3.BackendMessage {
+	return []pgproto3.BackendMessage{
+		&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42710", Message: "already exists"},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	received := make(chan pglogrepl.Message, 10)
+	sub := pglogrepl.NewSubscription(conn, pglogrepl.SubscriptionOptions{
+		SlotName:          slotName,
+		PublicationName:   "pub",
+		CreatePublication: true,
+		PublicationTables: []string{"public.t"},
+		PluginArgs:        []string{"messages 'true'"},
+		Handler: func(ctx context.Context, msg *pglogrepl.ReplicationMessage) error {
+			received <- msg.Message
+			return nil
+		},
+	})
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- sub.Run(ctx) }()
+
+	// Both the publication and the slot already exist and are reused.
+	assert.Equal(t, `CREATE PUBLICATION "pub" FOR TABLE "public"."t"`, <-ws.serveQuery(duplicateObjectResponse()))
+	assert.Equal(t, "CREATE_REPLICATION_SLOT "+slotName+" LOGICAL pgoutput", <-ws.serveQuery(duplicateObjectResponse()))
+	assert.Equal(t, "START_REPLICATION SLOT "+slotName+" LOGICAL 0/0 (proto_version '1', publication_names 'pub', messages 'true')", <-ws.serveStartReplication())
+
+	ws.sendXLogData(0x200, beginMessageData(0x300, 42))
+	ws.sendXLogData(0x300, commitMessageData(0x300, 0x310))
+	_, ok := (<-received).(*pglogrepl.BeginMessage)
+	assert.True(t, ok)
+	_, ok = (<-received).(*pglogrepl.CommitMessage)
+	assert.True(t, ok)
+
+	ws.sendKeepalive(0x400, true)
+	ssu := ws.receiveStandbyStatusUpdate()
+	assert.Equal(t, pglogrepl.LSN(0x400), ssu.WALWritePosition)
+	assert.Equal(t, pglogrepl.LSN(0x310), ssu.WALFlushPosition)
+	assert.Equal(t, pglogrepl.LSN(0x310), sub.ConfirmedLSN())
+
+	cancel()
+	assert.ErrorIs(t, <-runErr, context.Canceled)
+}
+
+func TestSubscriptionHandlerError(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	handlerErr := errors.New("handler failed")
+	sub := pglogrepl.NewSubscription(conn, pglogrepl.SubscriptionOptions{
+		SlotName:        slotName,
+		PublicationName: "pub",
+		Handler: func(ctx context.Context, msg *pglogrepl.ReplicationMessage) error {
+			if _, ok := msg.Message.(*pglogrepl.CommitMessage); ok {
+				return handlerErr
+			}
+			return nil
+		},
+	})
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- sub.Run(ctx) }()
+
+	<-ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("slot_name")}, {Name: []byte("consistent_point")}, {Name: []byte("snapshot_name")}, {Name: []byte("output_plugin")}}},
+		&pgproto3.DataRow{Values: [][]byte{[]byte(slotName), []byte("0/100"), nil, []byte("pgoutput")}},
+		&pgproto3.CommandComplete{CommandTag: []byte("CREATE_REPLICATION_SLOT")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	<-ws.serveStartReplication()
+
+	ws.sendXLogData(0x200, beginMessageData(0x300, 42))
+	ws.sendXLogData(0x300, commitMessageData(0x300, 0x310))
+	assert.ErrorIs(t, <-runErr, handlerErr)
+	assert.Equal(t, pglogrepl.LSN(0), sub.ConfirmedLSN())
+}
+
+func TestSubscriptionValidatesOptions(t *testing.T) {
+	conn, _ := newFakeWalSender(t)
+	sub := pglogrepl.NewSubscription(conn, pglogrepl.SubscriptionOptions{SlotName: slotName, PublicationName: "pub"})
+	assert.Error(t, sub.Run(context.Background()))
+
+	sub = pglogrepl.NewSubscription(conn, pglogrepl.SubscriptionOptions{
+		SlotName:        slotName,
+		PublicationName: "pub",
+		TemporarySlot:   true,
+		SlotConn:        conn,
+		Handler:         func(ctx context.Context, msg *pglogrepl.ReplicationMessage) error { return nil },
+	})
+	assert.EqualError(t, sub.Run(context.Background()), "subscription cannot create a temporary slot on SlotConn")
+}
+
+type memoryCheckpointStore struct {
+	checkpoints map[string]pglogrepl.LSN
+}
+
+func (s *memoryCheckpointStore) Load(_ context.Context, slotName string) (pglogrepl.LSN, error) {
+	return s.checkpoints[slotName], nil
+}
+
+func (s *memoryCheckpointStore) Store(_ context.Context, slotName string, lsn pglogrepl.LSN) error {
+	s.checkpoints[slotName] = lsn
+	return nil
+}
+
+func TestSubscriptionCheckpointStore(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store := &memoryCheckpointStore{checkpoints: map[string]pglogrepl.LSN{slotName: 0x250}}
+	committed := make(chan struct{}, 1)
+	sub := pglogrepl.NewSubscription(conn, pglogrepl.SubscriptionOptions{
+		SlotName:        slotName,
+		PublicationName: "pub",
+		CheckpointStore: store,
+		Handler: func(ctx context.Context, msg *pglogrepl.ReplicationMessage) error {
+			if _, ok := msg.Message.(*pglogrepl.CommitMessage); ok {
+				committed <- struct{}{}
+			}
+			return nil
+		},
+	})
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- sub.Run(ctx) }()
+
+	<-ws.serveQuery(duplicateObjectResponse())
+	assert.True(t, strings.HasPrefix(<-ws.serveStartReplication(), "START_REPLICATION SLOT "+slotName+" LOGICAL 0/250 "))
+
+	ws.sendXLogData(0x300, beginMessageData(0x300, 42))
+	ws.sendXLogData(0x300, commitMessageData(0x300, 0x310))
+	<-committed
+
+	// The checkpoint is stored before the position is confirmed, so once the keepalive reply
+	// confirms it the store has been updated.
+	ws.sendKeepalive(0x400, true)
+	assert.Equal(t, pglogrepl.LSN(0x310), ws.receiveStandbyStatusUpdate().WALFlushPosition)
+	cancel()
+	<-runErr
+	assert.Equal(t, pglogrepl.LSN(0x310), store.checkpoints[slotName])
+}
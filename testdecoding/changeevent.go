@@ -0,0 +1,74 @@
+package testdecoding
+
+import (
+	"github.com/jackc/pglogrepl"
+)
+
+// ChangeEventDecoder converts test_decoding events into pglogrepl.ChangeEvents. Every event of the
+// stream must be passed to Decode in order, as the decoder tracks the xid of the transaction in
+// progress. The commit time of the events is zero, as test_decoding only reports it on COMMIT.
+type ChangeEventDecoder struct {
+	xid uint32
+}
+
+// NewChangeEventDecoder returns a new ChangeEventDecoder.
+func NewChangeEventDecoder() *ChangeEventDecoder {
+	return &ChangeEventDecoder{}
+}
+
+// Decode returns the change events for e, which was received at lsn. It returns no events for
+// events that do not change rows. A truncate returns an event for every truncated table.
+//
+// The column values are strings, nil for NULL columns. The key of an update or delete is its old
+// key; test_decoding does not identify the key of an insert.
+func (d *ChangeEventDecoder) Decode(lsn pglogrepl.LSN, e *Event) []*pglogrepl.ChangeEvent {
+	newEvent := func(op pglogrepl.Operation, table Table) *pglogrepl.ChangeEvent {
+		return &pglogrepl.ChangeEvent{Operation: op, Schema: table.Schema, Table: table.Name, LSN: lsn, Xid: d.xid}
+	}
+
+	switch e.Kind {
+	case KindBegin:
+		d.xid = e.Xid
+	case KindCommit:
+		d.xid = 0
+	case KindInsert:
+		event := newEvent(pglogrepl.OperationInsert, e.Table)
+		event.After = columnValues(e.Columns)
+		return []*pglogrepl.ChangeEvent{event}
+	case KindUpdate:
+		event := newEvent(pglogrepl.OperationUpdate, e.Table)
+		event.Before = columnValues(e.OldKey)
+		event.Key = event.Before
+		event.After = columnValues(e.Columns)
+		return []*pglogrepl.ChangeEvent{event}
+	case KindDelete:
+		event := newEvent(pglogrepl.OperationDelete, e.Table)
+		event.Before = columnValues(e.OldKey)
+		event.Key = event.Before
+		return []*pglogrepl.ChangeEvent{event}
+	case KindTruncate:
+		events := make([]*pglogrepl.ChangeEvent, len(e.Tables))
+		for i, table := range e.Tables {
+			events[i] = newEvent(pglogrepl.OperationTruncate, table)
+		}
+		return events
+	}
+	return nil
+}
+
+func columnValues(columns []Column) map[string]interface{} {
+	if columns == nil {
+		return nil
+	}
+	values := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		switch {
+		case column.UnchangedToast:
+		case column.Null:
+			values[column.Name] = nil
+		default:
+			values[column.Name] = column.Value
+		}
+	}
+	return values
+}
@@ -0,0 +1,50 @@
+package testdecoding_test
+
+import (
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/testdecoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeEventDecoder(t *testing.T) {
+	d := testdecoding.NewChangeEventDecoder()
+	decode := func(lsn pglogrepl.LSN, line string) []*pglogrepl.ChangeEvent {
+		e, err := testdecoding.Parse([]byte(line))
+		require.NoError(t, err)
+		return d.Decode(lsn, e)
+	}
+
+	assert.Empty(t, decode(0x100, "BEGIN 529"))
+	assert.Equal(t, []*pglogrepl.ChangeEvent{{
+		Operation: pglogrepl.OperationInsert,
+		Schema:    "public",
+		Table:     "t",
+		After:     map[string]interface{}{"id": "1", "name": nil},
+		LSN:       0x200,
+		Xid:       529,
+	}}, decode(0x200, "table public.t: INSERT: id[integer]:1 name[text]:null"))
+	assert.Equal(t, []*pglogrepl.ChangeEvent{{
+		Operation: pglogrepl.OperationUpdate,
+		Schema:    "public",
+		Table:     "t",
+		Key:       map[string]interface{}{"id": "1"},
+		Before:    map[string]interface{}{"id": "1"},
+		After:     map[string]interface{}{"id": "2"},
+		LSN:       0x300,
+		Xid:       529,
+	}}, decode(0x300, "table public.t: UPDATE: old-key: id[integer]:1 new-tuple: id[integer]:2 name[text]:unchanged-toast-datum"))
+
+	events := decode(0x400, "table public.a, public.b: TRUNCATE: (no-flags)")
+	require.Len(t, events, 2)
+	assert.Equal(t, pglogrepl.OperationTruncate, events[1].Operation)
+	assert.Equal(t, "b", events[1].Table)
+
+	assert.Empty(t, decode(0x500, "COMMIT 529"))
+	events = decode(0x600, "table public.t: DELETE: id[integer]:2")
+	require.Len(t, events, 1)
+	assert.Equal(t, map[string]interface{}{"id": "2"}, events[0].Key)
+	assert.Zero(t, events[0].Xid)
+}
@@ -0,0 +1,443 @@
+// Package testdecoding parses the textual output of the test_decoding logical decoding output
+// plugin that ships with PostgreSQL.
+//
+// test_decoding is meant for testing and its output format is not a stable interface, but it is
+// available wherever logical decoding is, which makes it useful where neither pgoutput
+// publications nor wal2json can be used.
+package testdecoding
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the kind of an Event.
+type Kind int
+
+// List of event kinds.
+const (
+	KindBegin Kind = iota + 1
+	KindCommit
+	KindInsert
+	KindUpdate
+	KindDelete
+	KindTruncate
+	KindMessage
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindBegin:
+		return "BEGIN"
+	case KindCommit:
+		return "COMMIT"
+	case KindInsert:
+		return "INSERT"
+	case KindUpdate:
+		return "UPDATE"
+	case KindDelete:
+		return "DELETE"
+	case KindTruncate:
+		return "TRUNCATE"
+	case KindMessage:
+		return "MESSAGE"
+	}
+	return "Unknown"
+}
+
+// Table is a schema qualified table name.
+type Table struct {
+	Schema string
+	Name   string
+}
+
+// Column is a column of a row.
+type Column struct {
+	Name string
+	// Type is the type name as formatted by format_type, e.g. "integer" or "character varying(20)".
+	Type string
+	// Value is the text representation of the column value with the quoting added by
+	// test_decoding removed. It is empty if Null or UnchangedToast is true.
+	Value string
+	Null  bool
+	// UnchangedToast reports that the value is a TOASTed value that was not changed and therefore
+	// not included in the output.
+	UnchangedToast bool
+}
+
+// Event is a single line of test_decoding output.
+type Event struct {
+	Kind Kind
+
+	// Xid is set for KindBegin and KindCommit if the include-xids option is enabled, which is the
+	// default.
+	Xid uint32
+	// CommitTime is set for KindCommit if the include-timestamp option is enabled.
+	CommitTime time.Time
+
+	// Table is set for KindInsert, KindUpdate and KindDelete.
+	Table Table
+	// Columns is the new row of an insert or update.
+	Columns []Column
+	// OldKey is the old row of an update or delete. It is empty for an update which did not
+	// change the replica identity.
+	OldKey []Column
+
+	// Tables, RestartSeqs and Cascade are set for KindTruncate.
+	Tables      []Table
+	RestartSeqs bool
+	Cascade     bool
+
+	// Transactional, Prefix and Content are set for KindMessage.
+	Transactional bool
+	Prefix        string
+	Content       []byte
+}
+
+// Parse parses a single line of test_decoding output as received in the WALData of a XLogData
+// message.
+func Parse(data []byte) (*Event, error) {
+	s := string(data)
+	switch {
+	case s == "BEGIN" || strings.HasPrefix(s, "BEGIN "):
+		return parseBegin(s)
+	case s == "COMMIT" || strings.HasPrefix(s, "COMMIT "):
+		return parseCommit(s)
+	case strings.HasPrefix(s, "table "):
+		return parseTable(s[len("table "):])
+	case strings.HasPrefix(s, "message: "):
+		return parseMessage(data[len("message: "):])
+	}
+	return nil, fmt.Errorf("unrecognized test_decoding output: %q", truncate(s))
+}
+
+func parseBegin(s string) (*Event, error) {
+	e := &Event{Kind: KindBegin}
+	rest := strings.TrimPrefix(strings.TrimPrefix(s, "BEGIN"), " ")
+	if rest == "" {
+		return e, nil
+	}
+	xid, err := strconv.ParseUint(rest, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse BEGIN xid: %w", err)
+	}
+	e.Xid = uint32(xid)
+	return e, nil
+}
+
+// commitTimeLayouts are the layouts of timestamptz values in the ISO DateStyle.
+var commitTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07:00:00",
+}
+
+func parseCommit(s string) (*Event, error) {
+	e := &Event{Kind: KindCommit}
+	rest := strings.TrimPrefix(strings.TrimPrefix(s, "COMMIT"), " ")
+
+	if i := strings.Index(rest, "(at "); i != -1 {
+		if !strings.HasSuffix(rest, ")") {
+			return nil, fmt.Errorf("failed to parse COMMIT: unterminated timestamp")
+		}
+		ts := rest[i+len("(at ") : len(rest)-1]
+		var err error
+		for _, layout := range commitTimeLayouts {
+			e.CommitTime, err = time.Parse(layout, ts)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse COMMIT timestamp: %w", err)
+		}
+		rest = strings.TrimSpace(rest[:i])
+	}
+
+	if rest != "" {
+		xid, err := strconv.ParseUint(rest, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse COMMIT xid: %w", err)
+		}
+		e.Xid = uint32(xid)
+	}
+	return e, nil
+}
+
+func parseTable(s string) (*Event, error) {
+	p := &parser{s: s}
+
+	var tables []Table
+	for {
+		table, err := p.qualifiedName()
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+		if !p.consume(", ") {
+			break
+		}
+	}
+	if !p.consume(": ") {
+		return nil, p.errorf("expected ': ' after table name")
+	}
+
+	e := &Event{}
+	switch {
+	case p.consume("INSERT: "):
+		e.Kind = KindInsert
+	case p.consume("UPDATE: "):
+		e.Kind = KindUpdate
+	case p.consume("DELETE: "):
+		e.Kind = KindDelete
+	case p.consume("TRUNCATE: "):
+		e.Kind = KindTruncate
+		e.Tables = tables
+		return e, p.truncateFlags(e)
+	default:
+		return nil, p.errorf("unknown action")
+	}
+	if len(tables) != 1 {
+		return nil, p.errorf("%s of %d tables", e.Kind, len(tables))
+	}
+	e.Table = tables[0]
+
+	if p.consume("(no-tuple-data)") {
+		return e, p.end()
+	}
+
+	var err error
+	switch e.Kind {
+	case KindInsert:
+		e.Columns, err = p.columns("")
+	case KindUpdate:
+		if p.consume("old-key: ") {
+			if e.OldKey, err = p.columns("new-tuple: "); err != nil {
+				return nil, err
+			}
+			if !p.consume("new-tuple: ") {
+				return nil, p.errorf("expected new-tuple after old-key")
+			}
+		}
+		e.Columns, err = p.columns("")
+	case KindDelete:
+		e.OldKey, err = p.columns("")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, p.end()
+}
+
+func parseMessage(data []byte) (*Event, error) {
+	e := &Event{Kind: KindMessage}
+
+	rest, ok := cutPrefix(data, "transactional: ")
+	if !ok || len(rest) < 1 {
+		return nil, fmt.Errorf("failed to parse message: missing transactional")
+	}
+	e.Transactional = rest[0] == '1'
+
+	rest, ok = cutPrefix(rest[1:], " prefix: ")
+	if !ok {
+		return nil, fmt.Errorf("failed to parse message: missing prefix")
+	}
+	i := bytes.Index(rest, []byte(", sz: "))
+	if i == -1 {
+		return nil, fmt.Errorf("failed to parse message: missing size")
+	}
+	e.Prefix = string(rest[:i])
+	rest = rest[i+len(", sz: "):]
+
+	i = bytes.Index(rest, []byte(" content:"))
+	if i == -1 {
+		return nil, fmt.Errorf("failed to parse message: missing content")
+	}
+	sz, err := strconv.Atoi(string(rest[:i]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message size: %w", err)
+	}
+	rest = rest[i+len(" content:"):]
+	if sz != len(rest) {
+		return nil, fmt.Errorf("failed to parse message: size %d does not match content length %d", sz, len(rest))
+	}
+	e.Content = append([]byte(nil), rest...)
+	return e, nil
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("failed to parse test_decoding output at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) rest() string {
+	return p.s[p.pos:]
+}
+
+func (p *parser) consume(prefix string) bool {
+	if strings.HasPrefix(p.rest(), prefix) {
+		p.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+func (p *parser) end() error {
+	if p.pos != len(p.s) {
+		return p.errorf("unexpected trailing data %q", truncate(p.rest()))
+	}
+	return nil
+}
+
+// identifier reads an identifier as quoted by quote_identifier. An unquoted identifier ends at
+// the first byte in stop.
+func (p *parser) identifier(stop string) (string, error) {
+	if !p.consume(`"`) {
+		i := strings.IndexAny(p.rest(), stop)
+		if i <= 0 {
+			return "", p.errorf("expected identifier")
+		}
+		ident := p.rest()[:i]
+		p.pos += i
+		return ident, nil
+	}
+
+	var sb strings.Builder
+	for {
+		i := strings.IndexByte(p.rest(), '"')
+		if i == -1 {
+			return "", p.errorf("unterminated quoted identifier")
+		}
+		sb.WriteString(p.rest()[:i])
+		p.pos += i + 1
+		if !p.consume(`"`) {
+			return sb.String(), nil
+		}
+		sb.WriteByte('"')
+	}
+}
+
+func (p *parser) qualifiedName() (Table, error) {
+	schema, err := p.identifier(".")
+	if err != nil {
+		return Table{}, err
+	}
+	if !p.consume(".") {
+		return Table{}, p.errorf("expected schema qualified table name")
+	}
+	name, err := p.identifier(",:")
+	if err != nil {
+		return Table{}, err
+	}
+	return Table{Schema: schema, Name: name}, nil
+}
+
+// columns reads space separated columns until the end of the input or until the input starts with
+// stop.
+func (p *parser) columns(stop string) ([]Column, error) {
+	var columns []Column
+	for p.pos < len(p.s) && (stop == "" || !strings.HasPrefix(p.rest(), stop)) {
+		c, err := p.column()
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+		p.consume(" ")
+	}
+	return columns, nil
+}
+
+func (p *parser) column() (Column, error) {
+	var c Column
+	var err error
+	if c.Name, err = p.identifier("["); err != nil {
+		return c, err
+	}
+	if !p.consume("[") {
+		return c, p.errorf("expected '[' after column name")
+	}
+	// Type names can contain brackets themselves, e.g. integer[].
+	i := strings.Index(p.rest(), "]:")
+	if i == -1 {
+		return c, p.errorf("unterminated column type")
+	}
+	c.Type = p.rest()[:i]
+	p.pos += i + len("]:")
+
+	switch {
+	case p.consume("'"):
+		c.Value, err = p.quoted()
+	case p.consume("B'"):
+		c.Value, err = p.quoted()
+	default:
+		i := strings.IndexByte(p.rest(), ' ')
+		if i == -1 {
+			i = len(p.rest())
+		}
+		c.Value = p.rest()[:i]
+		p.pos += i
+		switch c.Value {
+		case "null":
+			c.Value, c.Null = "", true
+		case "unchanged-toast-datum":
+			c.Value, c.UnchangedToast = "", true
+		}
+	}
+	return c, err
+}
+
+// quoted reads the rest of a single quoted literal with embedded quotes doubled.
+func (p *parser) quoted() (string, error) {
+	var sb strings.Builder
+	for {
+		i := strings.IndexByte(p.rest(), '\'')
+		if i == -1 {
+			return "", p.errorf("unterminated quoted value")
+		}
+		sb.WriteString(p.rest()[:i])
+		p.pos += i + 1
+		if !p.consume("'") {
+			return sb.String(), nil
+		}
+		sb.WriteByte('\'')
+	}
+}
+
+func (p *parser) truncateFlags(e *Event) error {
+	if p.consume("(no-flags)") {
+		return p.end()
+	}
+	for _, flag := range strings.Fields(p.rest()) {
+		switch flag {
+		case "restart_seqs":
+			e.RestartSeqs = true
+		case "cascade":
+			e.Cascade = true
+		default:
+			return p.errorf("unknown truncate flag %q", flag)
+		}
+	}
+	return nil
+}
+
+func cutPrefix(b []byte, prefix string) ([]byte, bool) {
+	if !bytes.HasPrefix(b, []byte(prefix)) {
+		return b, false
+	}
+	return b[len(prefix):], true
+}
+
+func truncate(s string) string {
+	const maxLen = 64
+	if len(s) > maxLen {
+		return s[:maxLen] + "..."
+	}
+	return s
+}
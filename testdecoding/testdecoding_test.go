@@ -0,0 +1,135 @@
+package testdecoding_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl/testdecoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBeginCommit(t *testing.T) {
+	e, err := testdecoding.Parse([]byte("BEGIN 529"))
+	require.NoError(t, err)
+	assert.Equal(t, &testdecoding.Event{Kind: testdecoding.KindBegin, Xid: 529}, e)
+
+	e, err = testdecoding.Parse([]byte("BEGIN"))
+	require.NoError(t, err)
+	assert.Equal(t, &testdecoding.Event{Kind: testdecoding.KindBegin}, e)
+
+	e, err = testdecoding.Parse([]byte("COMMIT 529"))
+	require.NoError(t, err)
+	assert.Equal(t, &testdecoding.Event{Kind: testdecoding.KindCommit, Xid: 529}, e)
+
+	e, err = testdecoding.Parse([]byte("COMMIT 529 (at 2024-02-26 10:15:30.123456+00)"))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(529), e.Xid)
+	assert.True(t, time.Date(2024, 2, 26, 10, 15, 30, 123456000, time.UTC).Equal(e.CommitTime))
+
+	e, err = testdecoding.Parse([]byte("COMMIT (at 2024-02-26 10:15:30+05:30)"))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), e.Xid)
+	assert.True(t, time.Date(2024, 2, 26, 4, 45, 30, 0, time.UTC).Equal(e.CommitTime))
+}
+
+func TestParseInsert(t *testing.T) {
+	e, err := testdecoding.Parse([]byte(`table public.t: INSERT: id[integer]:1 name[text]:'it''s foo' ok[boolean]:true tags[text[]]:'{a,b}' b[bit(3)]:B'101' n[numeric]:null`))
+	require.NoError(t, err)
+	assert.Equal(t, testdecoding.KindInsert, e.Kind)
+	assert.Equal(t, testdecoding.Table{Schema: "public", Name: "t"}, e.Table)
+	assert.Equal(t, []testdecoding.Column{
+		{Name: "id", Type: "integer", Value: "1"},
+		{Name: "name", Type: "text", Value: "it's foo"},
+		{Name: "ok", Type: "boolean", Value: "true"},
+		{Name: "tags", Type: "text[]", Value: "{a,b}"},
+		{Name: "b", Type: "bit(3)", Value: "101"},
+		{Name: "n", Type: "numeric", Null: true},
+	}, e.Columns)
+	assert.Nil(t, e.OldKey)
+}
+
+func TestParseQuotedIdentifiers(t *testing.T) {
+	e, err := testdecoding.Parse([]byte(`table "My Schema"."Some ""T""": INSERT: "Col A"[character varying(20)]:'x y'`))
+	require.NoError(t, err)
+	assert.Equal(t, testdecoding.Table{Schema: "My Schema", Name: `Some "T"`}, e.Table)
+	assert.Equal(t, []testdecoding.Column{{Name: "Col A", Type: "character varying(20)", Value: "x y"}}, e.Columns)
+}
+
+func TestParseUpdate(t *testing.T) {
+	e, err := testdecoding.Parse([]byte(`table public.t: UPDATE: id[integer]:1 data[text]:unchanged-toast-datum`))
+	require.NoError(t, err)
+	assert.Equal(t, testdecoding.KindUpdate, e.Kind)
+	assert.Nil(t, e.OldKey)
+	assert.Equal(t, []testdecoding.Column{
+		{Name: "id", Type: "integer", Value: "1"},
+		{Name: "data", Type: "text", UnchangedToast: true},
+	}, e.Columns)
+
+	e, err = testdecoding.Parse([]byte(`table public.t: UPDATE: old-key: id[integer]:1 new-tuple: id[integer]:2 name[text]:'bar'`))
+	require.NoError(t, err)
+	assert.Equal(t, []testdecoding.Column{{Name: "id", Type: "integer", Value: "1"}}, e.OldKey)
+	assert.Equal(t, []testdecoding.Column{
+		{Name: "id", Type: "integer", Value: "2"},
+		{Name: "name", Type: "text", Value: "bar"},
+	}, e.Columns)
+}
+
+func TestParseDelete(t *testing.T) {
+	e, err := testdecoding.Parse([]byte(`table public.t: DELETE: id[integer]:2`))
+	require.NoError(t, err)
+	assert.Equal(t, testdecoding.KindDelete, e.Kind)
+	assert.Equal(t, []testdecoding.Column{{Name: "id", Type: "integer", Value: "2"}}, e.OldKey)
+
+	e, err = testdecoding.Parse([]byte(`table public.t: DELETE: (no-tuple-data)`))
+	require.NoError(t, err)
+	assert.Equal(t, testdecoding.KindDelete, e.Kind)
+	assert.Nil(t, e.OldKey)
+}
+
+func TestParseTruncate(t *testing.T) {
+	e, err := testdecoding.Parse([]byte(`table public.a, public.b: TRUNCATE: restart_seqs cascade`))
+	require.NoError(t, err)
+	assert.Equal(t, &testdecoding.Event{
+		Kind:        testdecoding.KindTruncate,
+		Tables:      []testdecoding.Table{{Schema: "public", Name: "a"}, {Schema: "public", Name: "b"}},
+		RestartSeqs: true,
+		Cascade:     true,
+	}, e)
+
+	e, err = testdecoding.Parse([]byte(`table public.a: TRUNCATE: (no-flags)`))
+	require.NoError(t, err)
+	assert.False(t, e.RestartSeqs)
+	assert.False(t, e.Cascade)
+}
+
+func TestParseMessage(t *testing.T) {
+	e, err := testdecoding.Parse([]byte("message: transactional: 1 prefix: app, sz: 11 content:hello world"))
+	require.NoError(t, err)
+	assert.Equal(t, &testdecoding.Event{
+		Kind:          testdecoding.KindMessage,
+		Transactional: true,
+		Prefix:        "app",
+		Content:       []byte("hello world"),
+	}, e)
+
+	_, err = testdecoding.Parse([]byte("message: transactional: 0 prefix: app, sz: 3 content:hello"))
+	assert.Error(t, err)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"BEGIN x",
+		"COMMIT 1 (at 2024",
+		"table t: INSERT: id[integer]:1",
+		"table public.t: MERGE: id[integer]:1",
+		"table public.t: INSERT: id[integer:1",
+		"table public.t: INSERT: name[text]:'unterminated",
+		"table public.a, public.b: INSERT: id[integer]:1",
+		"table public.t: TRUNCATE: only",
+	} {
+		_, err := testdecoding.Parse([]byte(s))
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,98 @@
+package pglogrepl
+
+import (
+	"context"
+)
+
+// Tracer starts the spans of the traced operations. The package does not depend on a tracing
+// library: an OpenTelemetry implementation starts a span with a trace.Tracer, converting the
+// attributes with attribute.String, attribute.Int64 and attribute.Bool, and its Span records the
+// error of End with RecordError and SetStatus before ending the span.
+//
+// A Tracer is attached to a context with ContextWithTracer. IdentifySystem,
+// CreateReplicationSlot and StartReplication, also when called by a ReplicationStream, and the
+// appliers of the apply package trace their operations with the Tracer of their context.
+type Tracer interface {
+	// Start starts a span named name as a child of the span of ctx, if any, and returns a context
+	// carrying the new span.
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttributes adds attributes to the span.
+	SetAttributes(attrs ...Attribute)
+	// End ends the span with the error of the operation, or nil if it succeeded.
+	End(err error)
+}
+
+// Attribute is an attribute of a span. Value is a string, an int64 or a bool.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// The keys of the attributes of the traced operations.
+const (
+	AttributeSlotName     = "pglogrepl.slot.name"
+	AttributeOutputPlugin = "pglogrepl.slot.output_plugin"
+	AttributeMode         = "pglogrepl.replication.mode"
+	AttributeTimeline     = "pglogrepl.timeline"
+	AttributeSystemID     = "pglogrepl.system_id"
+	// AttributeStartLSN and AttributeEndLSN are the positions of the streamed or applied WAL, in
+	// the textual form of LSN.String.
+	AttributeStartLSN  = "pglogrepl.lsn.start"
+	AttributeEndLSN    = "pglogrepl.lsn.end"
+	AttributeCommitLSN = "pglogrepl.lsn.commit"
+	AttributeXid       = "pglogrepl.xid"
+	AttributeChanges   = "pglogrepl.changes"
+	AttributeSkipped   = "pglogrepl.skipped"
+)
+
+// StringAttribute returns a string attribute.
+func StringAttribute(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int64Attribute returns an int64 attribute.
+func Int64Attribute(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// BoolAttribute returns a bool attribute.
+func BoolAttribute(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// LSNAttribute returns a string attribute of lsn.
+func LSNAttribute(key string, lsn LSN) Attribute {
+	return Attribute{Key: key, Value: lsn.String()}
+}
+
+type tracerKey struct{}
+
+// ContextWithTracer returns a context whose operations are traced by tracer.
+func ContextWithTracer(ctx context.Context, tracer Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, tracer)
+}
+
+// TracerFromContext returns the Tracer of ctx, or nil if it has none.
+func TracerFromContext(ctx context.Context) Tracer {
+	tracer, _ := ctx.Value(tracerKey{}).(Tracer)
+	return tracer
+}
+
+// StartSpan starts a span with the Tracer of ctx. If ctx has no Tracer it returns ctx and a span
+// doing nothing.
+func StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	tracer := TracerFromContext(ctx)
+	if tracer == nil {
+		return ctx, nopSpan{}
+	}
+	return tracer.Start(ctx, name, attrs...)
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(...Attribute) {}
+func (nopSpan) End(error)                  {}
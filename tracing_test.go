@@ -0,0 +1,115 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedSpan struct {
+	name  string
+	attrs map[string]interface{}
+	ended bool
+	err   error
+}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attrs ...pglogrepl.Attribute) (context.Context, pglogrepl.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &recordingSpan{tracer: t, span: &recordedSpan{name: name, attrs: map[string]interface{}{}}}
+	span.SetAttributes(attrs...)
+	t.spans = append(t.spans, span.span)
+	return ctx, span
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+	span   *recordedSpan
+}
+
+func (s *recordingSpan) SetAttributes(attrs ...pglogrepl.Attribute) {
+	for _, attr := range attrs {
+		s.span.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordingSpan) End(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.span.ended = true
+	s.span.err = err
+}
+
+func TestTracing(t *testing.T) {
+	conn, ws := newFakeWalSender(t)
+
+	tracer := &recordingTracer{}
+	ctx, cancel := context.WithTimeout(pglogrepl.ContextWithTracer(context.Background(), tracer), time.Second*5)
+	defer cancel()
+
+	queries := ws.serveQuery(rowsResponse(4, []string{"7000000000000000001", "1", "0/1700", "postgres"}))
+	_, err := pglogrepl.IdentifySystem(ctx, conn)
+	require.NoError(t, err)
+	<-queries
+
+	queries = ws.serveQuery([]pgproto3.BackendMessage{
+		&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42710", Message: "replication slot already exists"},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	})
+	_, err = pglogrepl.CreateReplicationSlot(ctx, conn, slotName, outputPlugin, pglogrepl.CreateReplicationSlotOptions{})
+	require.Error(t, err)
+	<-queries
+
+	queries = ws.serveStartReplication()
+	err = pglogrepl.StartReplication(ctx, conn, slotName, 0x1800, pglogrepl.StartReplicationOptions{})
+	require.NoError(t, err)
+	<-queries
+
+	require.Len(t, tracer.spans, 3)
+	assert.Equal(t, &recordedSpan{
+		name: "pglogrepl.IdentifySystem",
+		attrs: map[string]interface{}{
+			pglogrepl.AttributeSystemID: "7000000000000000001",
+			pglogrepl.AttributeTimeline: int64(1),
+			pglogrepl.AttributeEndLSN:   "0/1700",
+		},
+		ended: true,
+	}, tracer.spans[0])
+
+	assert.Equal(t, "pglogrepl.CreateReplicationSlot", tracer.spans[1].name)
+	assert.Equal(t, slotName, tracer.spans[1].attrs[pglogrepl.AttributeSlotName])
+	assert.Equal(t, outputPlugin, tracer.spans[1].attrs[pglogrepl.AttributeOutputPlugin])
+	assert.True(t, tracer.spans[1].ended)
+	assert.Error(t, tracer.spans[1].err)
+
+	assert.Equal(t, &recordedSpan{
+		name: "pglogrepl.StartReplication",
+		attrs: map[string]interface{}{
+			pglogrepl.AttributeSlotName: slotName,
+			pglogrepl.AttributeMode:     "LOGICAL",
+			pglogrepl.AttributeStartLSN: "0/1800",
+		},
+		ended: true,
+	}, tracer.spans[2])
+}
+
+func TestStartSpanWithoutTracer(t *testing.T) {
+	ctx := context.Background()
+	spanCtx, span := pglogrepl.StartSpan(ctx, "span")
+	assert.Equal(t, ctx, spanCtx)
+	span.SetAttributes(pglogrepl.StringAttribute("key", "value"))
+	span.End(errors.New("error"))
+	assert.Nil(t, pglogrepl.TracerFromContext(ctx))
+}
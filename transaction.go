@@ -0,0 +1,406 @@
+package pglogrepl
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Transaction is a committed transaction assembled from logical replication messages.
+type Transaction struct {
+	// Xid of the transaction. It is 0 for a non-transactional logical decoding message.
+	Xid uint32
+	// CommitLSN is the LSN of the commit.
+	CommitLSN LSN
+	// EndLSN is the end LSN of the transaction.
+	EndLSN LSN
+	// CommitTime is the commit timestamp of the transaction.
+	CommitTime time.Time
+	// Streamed reports whether the transaction was streamed while in progress.
+	Streamed bool
+	// Changes are the messages of the transaction in order. Besides the InsertMessage,
+	// UpdateMessage, DeleteMessage and TruncateMessage data changes they include the
+	// RelationMessage and TypeMessage messages describing the relations and types used, as well as
+	// OriginMessage, LogicalDecodingMessage and SequenceMessage messages. Protocol version 2
+	// messages are unwrapped, e.g. an InsertMessageV2 is stored as its InsertMessage.
+	Changes []Message
+}
+
+// TransactionAssemblerOptions configures a TransactionAssembler.
+type TransactionAssemblerOptions struct {
+	// MemoryLimit is the approximate number of bytes of streamed transaction changes buffered in
+	// memory. When it is exceeded the changes of the transaction being streamed are spilled to a
+	// temporary file until it is committed. If it is 0 all changes are kept in memory.
+	MemoryLimit int
+	// SpillDir is the directory of the spill files. If it is empty the default directory for
+	// temporary files is used.
+	SpillDir string
+}
+
+type streamedChange struct {
+	xid  uint32
+	msg  Message
+	size int
+}
+
+// streamBuffer buffers the changes of a streamed transaction. The oldest changes are in the
+// spill file, if any, followed by the changes in memory.
+type streamBuffer struct {
+	changes []streamedChange
+	size    int
+
+	file *os.File
+	// aborted holds the subtransactions aborted after some of their changes were spilled.
+	aborted map[uint32]struct{}
+}
+
+// TransactionAssembler groups logical replication messages into transactions. Messages are passed
+// to Add in the order they are received and Add returns every transaction once it is committed.
+//
+// Streamed transactions are buffered per Xid until they are committed, and dropped or trimmed
+// when they or one of their subtransactions are aborted, so stream blocks of concurrent
+// transactions may be interleaved. Above the MemoryLimit the buffered changes are spilled to disk.
+// Close must be called to remove the spill files of transactions that are still in progress.
+//
+// Two-phase commit messages are not supported.
+type TransactionAssembler struct {
+	options TransactionAssemblerOptions
+	current *Transaction
+
+	// streamXid is the Xid of the streamed transaction of the current stream block.
+	streamXid uint32
+	inStream  bool
+	streams   map[uint32]*streamBuffer
+	memory    int
+}
+
+// NewTransactionAssembler returns a new TransactionAssembler.
+func NewTransactionAssembler(options TransactionAssemblerOptions) *TransactionAssembler {
+	return &TransactionAssembler{options: options, streams: map[uint32]*streamBuffer{}}
+}
+
+// Add adds msg to the transaction in progress. It returns the transaction when msg completes it and
+// nil otherwise. A non-transactional logical decoding or sequence message received outside of a
+// transaction is returned immediately as a Transaction of its own.
+func (a *TransactionAssembler) Add(msg Message) (*Transaction, error) {
+	switch msg := msg.(type) {
+	case *BeginMessage:
+		if a.current != nil {
+			return nil, fmt.Errorf("received begin of transaction %d while transaction %d is in progress", msg.Xid, a.current.Xid)
+		}
+		a.current = &Transaction{Xid: msg.Xid, CommitLSN: msg.FinalLSN, CommitTime: msg.CommitTime}
+		return nil, nil
+	case *CommitMessage:
+		if a.current == nil {
+			return nil, fmt.Errorf("received commit without transaction")
+		}
+		tx := a.current
+		a.current = nil
+		tx.CommitLSN = msg.CommitLSN
+		tx.EndLSN = msg.TransactionEndLSN
+		tx.CommitTime = msg.CommitTime
+		return tx, nil
+
+	case *StreamStartMessageV2:
+		if a.inStream {
+			return nil, fmt.Errorf("received stream start of transaction %d in stream of transaction %d", msg.Xid, a.streamXid)
+		}
+		a.inStream = true
+		a.streamXid = msg.Xid
+		if _, ok := a.streams[msg.Xid]; !ok {
+			a.streams[msg.Xid] = &streamBuffer{}
+		}
+		return nil, nil
+	case *StreamStopMessageV2:
+		if !a.inStream {
+			return nil, fmt.Errorf("received stream stop outside of stream")
+		}
+		a.inStream = false
+		return nil, nil
+	case *StreamCommitMessageV2:
+		buf, ok := a.streams[msg.Xid]
+		if !ok {
+			return nil, fmt.Errorf("received stream commit of unknown transaction %d", msg.Xid)
+		}
+		changes, err := a.release(msg.Xid, buf)
+		if err != nil {
+			return nil, err
+		}
+		return &Transaction{
+			Xid:        msg.Xid,
+			CommitLSN:  msg.CommitLSN,
+			EndLSN:     msg.TransactionEndLSN,
+			CommitTime: msg.CommitTime,
+			Streamed:   true,
+			Changes:    changes,
+		}, nil
+	case *StreamAbortMessageV4:
+		return nil, a.abort(msg.Xid, msg.SubXid)
+	case *StreamAbortMessageV2:
+		return nil, a.abort(msg.Xid, msg.SubXid)
+
+	case *BeginPrepareMessageV3, *PrepareMessageV3, *CommitPreparedMessageV3, *RollbackPreparedMessageV3, *StreamPrepareMessageV3:
+		return nil, fmt.Errorf("TransactionAssembler does not support two-phase commit messages")
+	}
+
+	xid, change := unwrapChange(msg)
+	if a.inStream {
+		if xid == 0 {
+			xid = a.streamXid
+		}
+		return nil, a.buffer(a.streams[a.streamXid], streamedChange{xid: xid, msg: change, size: changeSize(change)})
+	}
+	if a.current != nil {
+		a.current.Changes = append(a.current.Changes, change)
+		return nil, nil
+	}
+	if ldm, ok := change.(*LogicalDecodingMessage); ok && !ldm.Transactional {
+		return &Transaction{CommitLSN: ldm.LSN, EndLSN: ldm.LSN, Changes: []Message{change}}, nil
+	}
+	if seq, ok := change.(*SequenceMessage); ok && !seq.Transactional {
+		return &Transaction{CommitLSN: seq.LSN, EndLSN: seq.LSN, Changes: []Message{change}}, nil
+	}
+	return nil, fmt.Errorf("received %s message outside of transaction", msg.Type())
+}
+
+// Close removes the spill files of the streamed transactions that are still in progress.
+func (a *TransactionAssembler) Close() error {
+	var firstErr error
+	for xid, buf := range a.streams {
+		if err := a.discard(xid, buf); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (a *TransactionAssembler) buffer(buf *streamBuffer, change streamedChange) error {
+	buf.changes = append(buf.changes, change)
+	buf.size += change.size
+	a.memory += change.size
+	if a.options.MemoryLimit > 0 && a.memory > a.options.MemoryLimit {
+		return a.spill(buf)
+	}
+	return nil
+}
+
+// spill appends the changes buf holds in memory to its spill file. Every change is written as its
+// subtransaction Xid and length followed by its protocol version 1 encoding.
+func (a *TransactionAssembler) spill(buf *streamBuffer) error {
+	if buf.file == nil {
+		f, err := os.CreateTemp(a.options.SpillDir, "pglogrepl-stream-*")
+		if err != nil {
+			return fmt.Errorf("failed to create spill file: %w", err)
+		}
+		buf.file = f
+	}
+
+	var data []byte
+	for _, change := range buf.changes {
+		encoder, ok := change.msg.(MessageEncoder)
+		if !ok {
+			return fmt.Errorf("cannot spill %s message", change.msg.Type())
+		}
+		start := len(data)
+		data = append(data, make([]byte, 8)...)
+		var err error
+		data, err = encoder.Encode(data)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s message: %w", change.msg.Type(), err)
+		}
+		binary.BigEndian.PutUint32(data[start:], change.xid)
+		binary.BigEndian.PutUint32(data[start+4:], uint32(len(data)-start-8))
+	}
+	if _, err := buf.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+
+	a.memory -= buf.size
+	buf.changes = nil
+	buf.size = 0
+	return nil
+}
+
+// release removes the streamed transaction xid and returns its changes.
+func (a *TransactionAssembler) release(xid uint32, buf *streamBuffer) ([]Message, error) {
+	var changes []Message
+	if buf.file != nil {
+		if _, err := buf.file.Seek(0, io.SeekStart); err != nil {
+			a.discard(xid, buf)
+			return nil, fmt.Errorf("failed to read spill file: %w", err)
+		}
+		r := bufio.NewReader(buf.file)
+		header := make([]byte, 8)
+		for {
+			_, err := io.ReadFull(r, header)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				a.discard(xid, buf)
+				return nil, fmt.Errorf("failed to read spill file: %w", err)
+			}
+			data := make([]byte, binary.BigEndian.Uint32(header[4:]))
+			if _, err := io.ReadFull(r, data); err != nil {
+				a.discard(xid, buf)
+				return nil, fmt.Errorf("failed to read spill file: %w", err)
+			}
+			if _, ok := buf.aborted[binary.BigEndian.Uint32(header)]; ok {
+				continue
+			}
+			msg, err := Parse(data)
+			if err != nil {
+				a.discard(xid, buf)
+				return nil, fmt.Errorf("failed to parse spilled message: %w", err)
+			}
+			changes = append(changes, msg)
+		}
+	}
+	for _, change := range buf.changes {
+		changes = append(changes, change.msg)
+	}
+	if err := a.discard(xid, buf); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// discard removes the streamed transaction xid and its spill file.
+func (a *TransactionAssembler) discard(xid uint32, buf *streamBuffer) error {
+	delete(a.streams, xid)
+	a.memory -= buf.size
+	if buf.file == nil {
+		return nil
+	}
+	buf.file.Close()
+	if err := os.Remove(buf.file.Name()); err != nil {
+		return fmt.Errorf("failed to remove spill file: %w", err)
+	}
+	return nil
+}
+
+// abort discards a streamed transaction or, if subXid is a subtransaction, its changes.
+func (a *TransactionAssembler) abort(xid, subXid uint32) error {
+	buf, ok := a.streams[xid]
+	if !ok {
+		return nil
+	}
+	if xid == subXid {
+		return a.discard(xid, buf)
+	}
+	kept := buf.changes[:0]
+	for _, change := range buf.changes {
+		if change.xid == subXid {
+			buf.size -= change.size
+			a.memory -= change.size
+		} else {
+			kept = append(kept, change)
+		}
+	}
+	buf.changes = kept
+	if buf.file != nil {
+		if buf.aborted == nil {
+			buf.aborted = map[uint32]struct{}{}
+		}
+		buf.aborted[subXid] = struct{}{}
+	}
+	return nil
+}
+
+// changeSize returns the approximate memory used by msg.
+func changeSize(msg Message) int {
+	size := 64
+	tupleSize := func(tuple *TupleData) {
+		if tuple == nil {
+			return
+		}
+		for _, col := range tuple.Columns {
+			size += 16 + len(col.Data)
+		}
+	}
+	switch msg := msg.(type) {
+	case *RelationMessage:
+		size += len(msg.Namespace) + len(msg.RelationName)
+		for _, col := range msg.Columns {
+			size += 16 + len(col.Name)
+		}
+	case *InsertMessage:
+		tupleSize(msg.Tuple)
+	case *UpdateMessage:
+		tupleSize(msg.OldTuple)
+		tupleSize(msg.NewTuple)
+	case *DeleteMessage:
+		tupleSize(msg.OldTuple)
+	case *TruncateMessage:
+		size += 4 * len(msg.RelationIDs)
+	case *LogicalDecodingMessage:
+		size += len(msg.Prefix) + len(msg.Content)
+	}
+	return size
+}
+
+// unwrapChange returns the Xid of a protocol version 2 message along with the protocol version 1
+// message it wraps, or msg itself.
+func unwrapChange(msg Message) (uint32, Message) {
+	switch msg := msg.(type) {
+	case *RelationMessageV2:
+		return msg.Xid, &msg.RelationMessage
+	case *TypeMessageV2:
+		return msg.Xid, &msg.TypeMessage
+	case *InsertMessageV2:
+		return msg.Xid, &msg.InsertMessage
+	case *UpdateMessageV2:
+		return msg.Xid, &msg.UpdateMessage
+	case *DeleteMessageV2:
+		return msg.Xid, &msg.DeleteMessage
+	case *TruncateMessageV2:
+		return msg.Xid, &msg.TruncateMessage
+	case *LogicalDecodingMessageV2:
+		return msg.Xid, &msg.LogicalDecodingMessage
+	case *SequenceMessageV2:
+		return msg.Xid, &msg.SequenceMessage
+	}
+	return 0, msg
+}
+
+// TransactionReader reads whole transactions from a ReplicationStream.
+type TransactionReader struct {
+	stream    *ReplicationStream
+	assembler *TransactionAssembler
+}
+
+// NewTransactionReader returns a TransactionReader reading from stream, which must have been
+// started with a pgoutput ProtoVersion.
+func NewTransactionReader(stream *ReplicationStream, options TransactionAssemblerOptions) *TransactionReader {
+	return &TransactionReader{stream: stream, assembler: NewTransactionAssembler(options)}
+}
+
+// Next returns the next committed transaction.
+func (r *TransactionReader) Next(ctx context.Context) (*Transaction, error) {
+	if r.stream.options.ProtoVersion == 0 {
+		return nil, fmt.Errorf("TransactionReader requires a ReplicationStream decoding pgoutput messages")
+	}
+	for {
+		rm, err := r.stream.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tx, err := r.assembler.Add(rm.Message)
+		if err != nil {
+			return nil, err
+		}
+		if tx != nil {
+			return tx, nil
+		}
+	}
+}
+
+// Close removes the spill files of the streamed transactions that are still in progress. It does
+// not close the stream.
+func (r *TransactionReader) Close() error {
+	return r.assembler.Close()
+}
@@ -0,0 +1,236 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func addMessages(t *testing.T, a *pglogrepl.TransactionAssembler, msgs ...pglogrepl.Message) []*pglogrepl.Transaction {
+	var txs []*pglogrepl.Transaction
+	for _, msg := range msgs {
+		tx, err := a.Add(msg)
+		require.NoError(t, err)
+		if tx != nil {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+func TestTransactionAssembler(t *testing.T) {
+	commitTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	relation := &pglogrepl.RelationMessage{RelationID: 1, RelationName: "t"}
+	insert := &pglogrepl.InsertMessage{RelationID: 1}
+	deleteMsg := &pglogrepl.DeleteMessage{RelationID: 1}
+
+	txs := addMessages(t, pglogrepl.NewTransactionAssembler(pglogrepl.TransactionAssemblerOptions{}),
+		&pglogrepl.BeginMessage{FinalLSN: 0x300, Xid: 42},
+		relation,
+		insert,
+		deleteMsg,
+		&pglogrepl.CommitMessage{CommitLSN: 0x300, TransactionEndLSN: 0x310, CommitTime: commitTime},
+	)
+	require.Len(t, txs, 1)
+	assert.Equal(t, &pglogrepl.Transaction{
+		Xid:        42,
+		CommitLSN:  0x300,
+		EndLSN:     0x310,
+		CommitTime: commitTime,
+		Changes:    []pglogrepl.Message{relation, insert, deleteMsg},
+	}, txs[0])
+}
+
+func TestTransactionAssemblerStreamed(t *testing.T) {
+	insert := func(xid, relationID uint32) *pglogrepl.InsertMessageV2 {
+		return &pglogrepl.InsertMessageV2{
+			InStreamMessageV2WithXid: pglogrepl.InStreamMessageV2WithXid{Xid: xid},
+			InsertMessage:            pglogrepl.InsertMessage{RelationID: relationID},
+		}
+	}
+
+	a := pglogrepl.NewTransactionAssembler(pglogrepl.TransactionAssemblerOptions{})
+	txs := addMessages(t, a,
+		&pglogrepl.StreamStartMessageV2{Xid: 42, FirstSegment: 1},
+		insert(42, 1),
+		insert(43, 2),
+		&pglogrepl.StreamStopMessageV2{},
+		// A regular transaction can be decoded between stream blocks.
+		&pglogrepl.BeginMessage{FinalLSN: 0x300, Xid: 50},
+		&pglogrepl.InsertMessageV2{InsertMessage: pglogrepl.InsertMessage{RelationID: 5}},
+		&pglogrepl.CommitMessage{CommitLSN: 0x300, TransactionEndLSN: 0x310},
+		&pglogrepl.StreamStartMessageV2{Xid: 42},
+		insert(44, 3),
+		insert(42, 4),
+		&pglogrepl.StreamStopMessageV2{},
+		&pglogrepl.StreamAbortMessageV2{Xid: 42, SubXid: 43},
+		&pglogrepl.StreamCommitMessageV2{Xid: 42, CommitLSN: 0x400, TransactionEndLSN: 0x410},
+	)
+	require.Len(t, txs, 2)
+
+	assert.Equal(t, uint32(50), txs[0].Xid)
+	assert.False(t, txs[0].Streamed)
+	assert.Equal(t, []pglogrepl.Message{&pglogrepl.InsertMessage{RelationID: 5}}, txs[0].Changes)
+
+	assert.Equal(t, uint32(42), txs[1].Xid)
+	assert.True(t, txs[1].Streamed)
+	assert.Equal(t, pglogrepl.LSN(0x400), txs[1].CommitLSN)
+	assert.Equal(t, pglogrepl.LSN(0x410), txs[1].EndLSN)
+	var relationIDs []uint32
+	for _, change := range txs[1].Changes {
+		relationIDs = append(relationIDs, change.(*pglogrepl.InsertMessage).RelationID)
+	}
+	assert.Equal(t, []uint32{1, 3, 4}, relationIDs)
+}
+
+func TestTransactionAssemblerStreamAborted(t *testing.T) {
+	a := pglogrepl.NewTransactionAssembler(pglogrepl.TransactionAssemblerOptions{})
+	txs := addMessages(t, a,
+		&pglogrepl.StreamStartMessageV2{Xid: 42, FirstSegment: 1},
+		&pglogrepl.InsertMessageV2{InStreamMessageV2WithXid: pglogrepl.InStreamMessageV2WithXid{Xid: 42}},
+		&pglogrepl.StreamStopMessageV2{},
+		&pglogrepl.StreamAbortMessageV4{StreamAbortMessageV2: pglogrepl.StreamAbortMessageV2{Xid: 42, SubXid: 42}},
+	)
+	assert.Empty(t, txs)
+
+	_, err := a.Add(&pglogrepl.StreamCommitMessageV2{Xid: 42})
+	assert.Error(t, err)
+}
+
+func TestTransactionAssemblerNonTransactionalMessage(t *testing.T) {
+	msg := &pglogrepl.LogicalDecodingMessage{LSN: 0x200, Prefix: "p", Content: []byte("c")}
+	txs := addMessages(t, pglogrepl.NewTransactionAssembler(pglogrepl.TransactionAssemblerOptions{}), msg)
+	require.Len(t, txs, 1)
+	assert.Equal(t, &pglogrepl.Transaction{CommitLSN: 0x200, EndLSN: 0x200, Changes: []pglogrepl.Message{msg}}, txs[0])
+
+	seq := &pglogrepl.SequenceMessageV2{SequenceMessage: pglogrepl.SequenceMessage{LSN: 0x300, Name: "t_id_seq", LastValue: 33}}
+	txs = addMessages(t, pglogrepl.NewTransactionAssembler(pglogrepl.TransactionAssemblerOptions{}), seq)
+	require.Len(t, txs, 1)
+	assert.Equal(t, &pglogrepl.Transaction{CommitLSN: 0x300, EndLSN: 0x300, Changes: []pglogrepl.Message{&seq.SequenceMessage}}, txs[0])
+}
+
+func TestTransactionAssemblerErrors(t *testing.T) {
+	_, err := pglogrepl.NewTransactionAssembler(pglogrepl.TransactionAssemblerOptions{}).Add(&pglogrepl.InsertMessage{})
+	assert.Error(t, err)
+
+	_, err = pglogrepl.NewTransactionAssembler(pglogrepl.TransactionAssemblerOptions{}).Add(&pglogrepl.CommitMessage{})
+	assert.Error(t, err)
+
+	a := pglogrepl.NewTransactionAssembler(pglogrepl.TransactionAssemblerOptions{})
+	addMessages(t, a, &pglogrepl.BeginMessage{Xid: 1})
+	_, err = a.Add(&pglogrepl.BeginMessage{Xid: 2})
+	assert.Error(t, err)
+
+	_, err = pglogrepl.NewTransactionAssembler(pglogrepl.TransactionAssemblerOptions{}).Add(&pglogrepl.BeginPrepareMessageV3{})
+	assert.Error(t, err)
+}
+
+func TestTransactionReader(t *testing.T) {
+	stream, ws := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{ProtoVersion: 1})
+	reader := pglogrepl.NewTransactionReader(stream, pglogrepl.TransactionAssemblerOptions{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ws.sendXLogData(0x200, beginMessageData(0x300, 42))
+	ws.sendXLogData(0x300, commitMessageData(0x300, 0x310))
+	tx, err := reader.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(42), tx.Xid)
+	assert.Equal(t, pglogrepl.LSN(0x300), tx.CommitLSN)
+	assert.Equal(t, pglogrepl.LSN(0x310), tx.EndLSN)
+	assert.Empty(t, tx.Changes)
+}
+
+func TestTransactionReaderRequiresProtoVersion(t *testing.T) {
+	stream, _ := startTestReplicationStream(t, pglogrepl.ReplicationStreamOptions{})
+	_, err := pglogrepl.NewTransactionReader(stream, pglogrepl.TransactionAssemblerOptions{}).Next(context.Background())
+	assert.Error(t, err)
+}
+
+func insertV2(xid, relationID uint32, value string) *pglogrepl.InsertMessageV2 {
+	return &pglogrepl.InsertMessageV2{
+		InStreamMessageV2WithXid: pglogrepl.InStreamMessageV2WithXid{Xid: xid},
+		InsertMessage: pglogrepl.InsertMessage{
+			RelationID: relationID,
+			Tuple: &pglogrepl.TupleData{
+				ColumnNum: 1,
+				Columns:   []*pglogrepl.TupleDataColumn{{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(value)), Data: []byte(value)}},
+			},
+		},
+	}
+}
+
+func insertedValues(t *testing.T, tx *pglogrepl.Transaction) []string {
+	var values []string
+	for _, change := range tx.Changes {
+		insert, ok := change.(*pglogrepl.InsertMessage)
+		require.True(t, ok, "expected InsertMessage, got %T", change)
+		values = append(values, string(insert.Tuple.Columns[0].Data))
+	}
+	return values
+}
+
+func TestTransactionAssemblerSpill(t *testing.T) {
+	dir := t.TempDir()
+	a := pglogrepl.NewTransactionAssembler(pglogrepl.TransactionAssemblerOptions{MemoryLimit: 200, SpillDir: dir})
+	defer a.Close()
+
+	txs := addMessages(t, a,
+		&pglogrepl.StreamStartMessageV2{Xid: 42, FirstSegment: 1},
+		insertV2(42, 1, "a"),
+		insertV2(43, 1, "b"),
+		insertV2(42, 1, "c"),
+		&pglogrepl.StreamStopMessageV2{},
+		&pglogrepl.StreamStartMessageV2{Xid: 50, FirstSegment: 1},
+		insertV2(50, 2, "x"),
+		&pglogrepl.StreamStopMessageV2{},
+		&pglogrepl.StreamStartMessageV2{Xid: 42},
+		insertV2(44, 1, "d"),
+		insertV2(42, 1, "e"),
+		&pglogrepl.StreamStopMessageV2{},
+	)
+	assert.Empty(t, txs)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	// Only the transaction exceeding the limit is spilled.
+	assert.Len(t, files, 1)
+
+	txs = addMessages(t, a,
+		&pglogrepl.StreamAbortMessageV2{Xid: 42, SubXid: 43},
+		&pglogrepl.StreamCommitMessageV2{Xid: 42, CommitLSN: 0x400, TransactionEndLSN: 0x410},
+	)
+	require.Len(t, txs, 1)
+	assert.Equal(t, []string{"a", "c", "d", "e"}, insertedValues(t, txs[0]))
+
+	txs = addMessages(t, a, &pglogrepl.StreamCommitMessageV2{Xid: 50, CommitLSN: 0x500, TransactionEndLSN: 0x510})
+	require.Len(t, txs, 1)
+	assert.Equal(t, []string{"x"}, insertedValues(t, txs[0]))
+
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestTransactionAssemblerCloseRemovesSpillFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := pglogrepl.NewTransactionAssembler(pglogrepl.TransactionAssemblerOptions{MemoryLimit: 1, SpillDir: dir})
+	addMessages(t, a,
+		&pglogrepl.StreamStartMessageV2{Xid: 42, FirstSegment: 1},
+		insertV2(42, 1, "a"),
+		&pglogrepl.StreamStopMessageV2{},
+	)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	require.NoError(t, a.Close())
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
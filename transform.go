@@ -0,0 +1,221 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// Transformer transforms the messages written to a sink wrapped with TransformSink, for example
+// to redact personal data before it leaves the replication client.
+type Transformer interface {
+	// Transform returns the message to write in place of msg, or nil to drop it. rel is the
+	// relation of a relation message, insert, update or delete as transformed by the previous
+	// transformers, and nil for other messages. Transform may modify the tuples of msg but not rel,
+	// it must return a copy of a relation message it changes.
+	Transform(rel *RelationMessage, msg Message) (Message, error)
+}
+
+// TransformerFunc is a function implementing Transformer.
+type TransformerFunc func(rel *RelationMessage, msg Message) (Message, error)
+
+// Transform implements Transformer.
+func (f TransformerFunc) Transform(rel *RelationMessage, msg Message) (Message, error) {
+	return f(rel, msg)
+}
+
+// TransformSink returns a Sink passing the decoded messages through transformers, in order, before
+// writing them to sink. The WAL data of a transformed relation message, insert, update or delete
+// is encoded from the transformed message, so that sinks writing the WAL data do not leak what
+// the transformers removed.
+func TransformSink(sink Sink, transformers ...Transformer) Sink {
+	s := &transformSink{sink: sink, transformers: transformers, relations: make([]*RelationCache, len(transformers))}
+	for i := range s.relations {
+		s.relations[i] = NewRelationCache(nil)
+	}
+	return s
+}
+
+type transformSink struct {
+	sink         Sink
+	transformers []Transformer
+	// relations are the relations received by every transformer.
+	relations []*RelationCache
+}
+
+func (s *transformSink) WriteChange(ctx context.Context, msg *ReplicationMessage) error {
+	if msg.Message == nil || len(s.transformers) == 0 {
+		return s.sink.WriteChange(ctx, msg)
+	}
+
+	out := msg.Message
+	for i, transformer := range s.transformers {
+		s.relations[i].Update(out)
+		var rel *RelationMessage
+		if id, ok := messageRelationID(out); ok {
+			rel, _ = s.relations[i].Relation(id)
+		}
+		var err error
+		if out, err = transformer.Transform(rel, out); err != nil {
+			return err
+		}
+		if out == nil {
+			return nil
+		}
+	}
+	if _, ok := messageRelationID(out); !ok && out == msg.Message {
+		return s.sink.WriteChange(ctx, msg)
+	}
+
+	encoder, ok := out.(MessageEncoder)
+	if !ok {
+		return fmt.Errorf("transformed message %T cannot be encoded", out)
+	}
+	walData, err := encoder.Encode(nil)
+	if err != nil {
+		return fmt.Errorf("failed to encode transformed message: %w", err)
+	}
+	transformed := &ReplicationMessage{XLogData: msg.XLogData, Message: out}
+	transformed.WALData = walData
+	return s.sink.WriteChange(ctx, transformed)
+}
+
+func (s *transformSink) Flush(ctx context.Context) (LSN, error) {
+	return s.sink.Flush(ctx)
+}
+
+// messageRelationID returns the relation of a relation message, insert, update or delete.
+func messageRelationID(msg Message) (uint32, bool) {
+	switch msg := msg.(type) {
+	case *RelationMessage:
+		return msg.RelationID, true
+	case *RelationMessageV2:
+		return msg.RelationID, true
+	case *InsertMessage:
+		return msg.RelationID, true
+	case *InsertMessageV2:
+		return msg.RelationID, true
+	case *UpdateMessage:
+		return msg.RelationID, true
+	case *UpdateMessageV2:
+		return msg.RelationID, true
+	case *DeleteMessage:
+		return msg.RelationID, true
+	case *DeleteMessageV2:
+		return msg.RelationID, true
+	}
+	return 0, false
+}
+
+// messageTuples returns the tuples of an insert, update or delete.
+func messageTuples(msg Message) []*TupleData {
+	switch msg := msg.(type) {
+	case *InsertMessage:
+		return []*TupleData{msg.Tuple}
+	case *InsertMessageV2:
+		return []*TupleData{msg.Tuple}
+	case *UpdateMessage:
+		return []*TupleData{msg.OldTuple, msg.NewTuple}
+	case *UpdateMessageV2:
+		return []*TupleData{msg.OldTuple, msg.NewTuple}
+	case *DeleteMessage:
+		return []*TupleData{msg.OldTuple}
+	case *DeleteMessageV2:
+		return []*TupleData{msg.OldTuple}
+	}
+	return nil
+}
+
+// ColumnMatcher selects the columns of a relation a transformer applies to.
+type ColumnMatcher func(rel *RelationMessage, column *RelationMessageColumn) bool
+
+// MatchColumnNames returns a ColumnMatcher selecting the columns whose name matches pattern, in
+// every relation.
+func MatchColumnNames(pattern *regexp.Regexp) ColumnMatcher {
+	return func(_ *RelationMessage, column *RelationMessageColumn) bool {
+		return pattern.MatchString(column.Name)
+	}
+}
+
+// MaskColumns returns a Transformer replacing the values of the matched columns of inserts,
+// updates and deletes with the text mask. NULL and unchanged TOAST values are kept. The mask must
+// be a valid text value for the type of the matched columns, such as "***" for text columns.
+func MaskColumns(match ColumnMatcher, mask string) Transformer {
+	return TransformerFunc(func(rel *RelationMessage, msg Message) (Message, error) {
+		tuples := messageTuples(msg)
+		if rel == nil || len(tuples) == 0 {
+			return msg, nil
+		}
+		for i, column := range rel.Columns {
+			if !match(rel, column) {
+				continue
+			}
+			for _, tuple := range tuples {
+				if tuple == nil || i >= len(tuple.Columns) {
+					continue
+				}
+				col := tuple.Columns[i]
+				if col.DataType == TupleDataTypeText || col.DataType == TupleDataTypeBinary {
+					tuple.Columns[i] = &TupleDataColumn{DataType: TupleDataTypeText, Length: uint32(len(mask)), Data: []byte(mask)}
+				}
+			}
+		}
+		return msg, nil
+	})
+}
+
+// DropColumns returns a Transformer removing the matched columns from relation messages and from
+// the tuples of inserts, updates and deletes, so the changes are written as if the columns did not
+// exist.
+func DropColumns(match ColumnMatcher) Transformer {
+	return TransformerFunc(func(rel *RelationMessage, msg Message) (Message, error) {
+		if rel == nil {
+			return msg, nil
+		}
+		var dropped []bool
+		for i, column := range rel.Columns {
+			if match(rel, column) {
+				if dropped == nil {
+					dropped = make([]bool, len(rel.Columns))
+				}
+				dropped[i] = true
+			}
+		}
+		if dropped == nil {
+			return msg, nil
+		}
+
+		switch msg := msg.(type) {
+		case *RelationMessage:
+			return dropRelationColumns(msg, dropped), nil
+		case *RelationMessageV2:
+			return &RelationMessageV2{RelationMessage: *dropRelationColumns(&msg.RelationMessage, dropped), InStreamMessageV2WithXid: msg.InStreamMessageV2WithXid}, nil
+		}
+		for _, tuple := range messageTuples(msg) {
+			if tuple == nil || len(tuple.Columns) != len(dropped) {
+				continue
+			}
+			columns := make([]*TupleDataColumn, 0, len(tuple.Columns))
+			for i, col := range tuple.Columns {
+				if !dropped[i] {
+					columns = append(columns, col)
+				}
+			}
+			tuple.Columns = columns
+			tuple.ColumnNum = uint16(len(columns))
+		}
+		return msg, nil
+	})
+}
+
+func dropRelationColumns(rel *RelationMessage, dropped []bool) *RelationMessage {
+	out := *rel
+	out.Columns = make([]*RelationMessageColumn, 0, len(rel.Columns))
+	for i, column := range rel.Columns {
+		if !dropped[i] {
+			out.Columns = append(out.Columns, column)
+		}
+	}
+	out.ColumnNum = uint16(len(out.Columns))
+	return &out
+}
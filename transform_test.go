@@ -0,0 +1,85 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type walDataSink struct {
+	written []*pglogrepl.ReplicationMessage
+}
+
+func (s *walDataSink) WriteChange(_ context.Context, msg *pglogrepl.ReplicationMessage) error {
+	s.written = append(s.written, msg)
+	return nil
+}
+
+func (s *walDataSink) Flush(context.Context) (pglogrepl.LSN, error) {
+	return 0, nil
+}
+
+func decodedMessage(t *testing.T, msg pglogrepl.MessageEncoder) *pglogrepl.ReplicationMessage {
+	walData := encodeMessage(t, msg)
+	decoded, err := pglogrepl.Parse(walData)
+	require.NoError(t, err)
+	return &pglogrepl.ReplicationMessage{XLogData: pglogrepl.XLogData{WALData: walData}, Message: decoded}
+}
+
+func TestTransformSink(t *testing.T) {
+	ctx := context.Background()
+	target := &walDataSink{}
+	sink := pglogrepl.TransformSink(target,
+		pglogrepl.MaskColumns(pglogrepl.MatchColumnNames(regexp.MustCompile(`^email$`)), "***"),
+		pglogrepl.DropColumns(pglogrepl.MatchColumnNames(regexp.MustCompile(`^ssn$`))),
+		pglogrepl.TransformerFunc(func(rel *pglogrepl.RelationMessage, msg pglogrepl.Message) (pglogrepl.Message, error) {
+			if _, ok := msg.(*pglogrepl.DeleteMessage); ok {
+				return nil, nil
+			}
+			return msg, nil
+		}),
+	)
+
+	rel := &pglogrepl.RelationMessage{RelationID: 1, Namespace: "public", RelationName: "users", ColumnNum: 3, Columns: []*pglogrepl.RelationMessageColumn{
+		{Name: "id", DataType: 23},
+		{Name: "email", DataType: 25},
+		{Name: "ssn", DataType: 25},
+	}}
+	insert := &pglogrepl.InsertMessage{RelationID: 1, Tuple: &pglogrepl.TupleData{ColumnNum: 3, Columns: []*pglogrepl.TupleDataColumn{
+		textColumn("1"),
+		textColumn("alice@example.com"),
+		textColumn("123-45-6789"),
+	}}}
+	del := &pglogrepl.DeleteMessage{RelationID: 1, OldTupleType: pglogrepl.DeleteMessageTupleTypeKey, OldTuple: &pglogrepl.TupleData{ColumnNum: 3, Columns: []*pglogrepl.TupleDataColumn{
+		textColumn("1"),
+		{DataType: pglogrepl.TupleDataTypeNull},
+		{DataType: pglogrepl.TupleDataTypeNull},
+	}}}
+	begin := &pglogrepl.BeginMessage{FinalLSN: 0x300, Xid: 42}
+
+	for _, msg := range []pglogrepl.MessageEncoder{begin, rel, insert, del} {
+		require.NoError(t, sink.WriteChange(ctx, decodedMessage(t, msg)))
+	}
+	require.Len(t, target.written, 3)
+
+	transformedRel, ok := target.written[1].Message.(*pglogrepl.RelationMessage)
+	require.True(t, ok)
+	assert.Equal(t, uint16(2), transformedRel.ColumnNum)
+	assert.Equal(t, "email", transformedRel.Columns[1].Name)
+
+	// The WAL data is encoded from the transformed message.
+	decoded, err := pglogrepl.Parse(target.written[2].WALData)
+	require.NoError(t, err)
+	transformedInsert := decoded.(*pglogrepl.InsertMessage)
+	require.Len(t, transformedInsert.Tuple.Columns, 2)
+	assert.Equal(t, "1", string(transformedInsert.Tuple.Columns[0].Data))
+	assert.Equal(t, "***", string(transformedInsert.Tuple.Columns[1].Data))
+	assert.Equal(t, transformedInsert.Tuple, target.written[2].Message.(*pglogrepl.InsertMessage).Tuple)
+
+	assert.NotContains(t, string(target.written[2].WALData), "alice")
+	assert.NotContains(t, string(target.written[2].WALData), "6789")
+}
@@ -0,0 +1,295 @@
+package pglogrepl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// DecodeValue decodes the column data into a Go value with the codec registered in m for
+// dataType, which is the DataType of the matching RelationMessageColumn. Text and binary formatted
+// data are supported and a NULL column is decoded as nil. An unchanged TOAST column carries no data
+// and returns an error.
+//
+// If m has no codec for dataType, text data is returned as a string and binary data as a []byte.
+func (c *TupleDataColumn) DecodeValue(m *pgtype.Map, dataType uint32) (interface{}, error) {
+	switch c.DataType {
+	case TupleDataTypeNull:
+		return nil, nil
+	case TupleDataTypeText:
+		return c.DecodeText(m, dataType)
+	case TupleDataTypeBinary:
+		return c.DecodeBinary(m, dataType)
+	case TupleDataTypeToast:
+		return nil, fmt.Errorf("unchanged TOAST column has no data")
+	default:
+		return nil, fmt.Errorf("invalid column's data type %c", c.DataType)
+	}
+}
+
+// DecodeText decodes text formatted column data. See DecodeValue.
+func (c *TupleDataColumn) DecodeText(m *pgtype.Map, dataType uint32) (interface{}, error) {
+	if c.DataType != TupleDataTypeText {
+		return nil, fmt.Errorf("invalid column's data type, expect %c, actual %c", TupleDataTypeText, c.DataType)
+	}
+	if dt, ok := m.TypeForOID(dataType); ok {
+		return dt.Codec.DecodeValue(m, dataType, pgtype.TextFormatCode, c.Data)
+	}
+	return string(c.Data), nil
+}
+
+// DecodeBinary decodes binary formatted column data, which is sent when pgoutput is started with
+// binary 'true'. See DecodeValue.
+func (c *TupleDataColumn) DecodeBinary(m *pgtype.Map, dataType uint32) (interface{}, error) {
+	if c.DataType != TupleDataTypeBinary {
+		return nil, fmt.Errorf("invalid column's data type, expect %c, actual %c", TupleDataTypeBinary, c.DataType)
+	}
+	if dt, ok := m.TypeForOID(dataType); ok {
+		return dt.Codec.DecodeValue(m, dataType, pgtype.BinaryFormatCode, c.Data)
+	}
+	return c.Data, nil
+}
+
+// Reader returns a reader of the column data, so that large values can be streamed to a
+// destination such as a file without another copy. NULL and unchanged TOAST columns read as empty.
+func (c *TupleDataColumn) Reader() io.Reader {
+	return bytes.NewReader(c.Data)
+}
+
+// DecodeTuple decodes tuple, a tuple of rel, into a map keyed by column name. NULL columns are
+// nil and unchanged TOAST columns, which carry no data, are left out of the map.
+func DecodeTuple(rel *RelationMessage, tuple *TupleData, m *pgtype.Map) (map[string]interface{}, error) {
+	return DecodeProjectedTuple(rel, tuple, m, nil)
+}
+
+// DecodeProjectedTuple is DecodeTuple decoding only the columns of rel included by p.
+func DecodeProjectedTuple(rel *RelationMessage, tuple *TupleData, m *pgtype.Map, p *Projection) (map[string]interface{}, error) {
+	if tuple == nil {
+		return nil, fmt.Errorf("tuple is nil")
+	}
+	if len(tuple.Columns) != len(rel.Columns) {
+		return nil, fmt.Errorf("tuple has %d columns but relation %s.%s has %d", len(tuple.Columns), rel.Namespace, rel.RelationName, len(rel.Columns))
+	}
+
+	allowed := p.columns(rel)
+	values := make(map[string]interface{}, len(tuple.Columns))
+	for i, col := range tuple.Columns {
+		if col.DataType == TupleDataTypeToast {
+			continue
+		}
+		if allowed != nil {
+			if _, ok := allowed[rel.Columns[i].Name]; !ok {
+				continue
+			}
+		}
+		val, err := col.DecodeValue(m, rel.Columns[i].DataType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode column %s: %w", rel.Columns[i].Name, err)
+		}
+		values[rel.Columns[i].Name] = val
+	}
+	return values, nil
+}
+
+// ColumnDiff is a column changed by an update.
+type ColumnDiff struct {
+	Name string
+	// Old is the column before the update. It is nil if the old value was not sent.
+	Old *TupleDataColumn
+	New *TupleDataColumn
+}
+
+// DiffUpdate returns the columns of rel changed by an update from the OldTuple and NewTuple of an
+// UpdateMessage. old is nil if the update did not send an old tuple.
+//
+// The server sends the complete old row only if the relation has REPLICA IDENTITY FULL. Otherwise
+// old holds only the replica identity columns, and only if one of them changed, so the previous
+// values of the other columns are unknown: every column that is not an unchanged TOAST value is
+// then reported with a nil Old unless it is a replica identity column whose value is known.
+// Unchanged TOAST columns are never reported.
+func DiffUpdate(rel *RelationMessage, old, new *TupleData) ([]ColumnDiff, error) {
+	if new == nil {
+		return nil, fmt.Errorf("new tuple is nil")
+	}
+	if len(new.Columns) != len(rel.Columns) {
+		return nil, fmt.Errorf("new tuple has %d columns but relation %s.%s has %d", len(new.Columns), rel.Namespace, rel.RelationName, len(rel.Columns))
+	}
+	if old != nil && len(old.Columns) != len(rel.Columns) {
+		return nil, fmt.Errorf("old tuple has %d columns but relation %s.%s has %d", len(old.Columns), rel.Namespace, rel.RelationName, len(rel.Columns))
+	}
+
+	var diffs []ColumnDiff
+	for i, newCol := range new.Columns {
+		if newCol.DataType == TupleDataTypeToast {
+			continue
+		}
+		relCol := rel.Columns[i]
+		var oldCol *TupleDataColumn
+		switch {
+		case old != nil && (rel.ReplicaIdentity == ReplicaIdentityFull || relCol.Flags&1 != 0):
+			oldCol = old.Columns[i]
+		case old == nil && rel.ReplicaIdentity != ReplicaIdentityFull && relCol.Flags&1 != 0:
+			// The replica identity did not change, otherwise the old key would have been sent.
+			continue
+		}
+		if oldCol != nil && oldCol.DataType != TupleDataTypeToast && sameColumnData(oldCol, newCol) {
+			continue
+		}
+		diffs = append(diffs, ColumnDiff{Name: relCol.Name, Old: oldCol, New: newCol})
+	}
+	return diffs, nil
+}
+
+func sameColumnData(a, b *TupleDataColumn) bool {
+	return a.DataType == b.DataType && bytes.Equal(a.Data, b.Data)
+}
+
+// TupleDecoder decodes tuples into Go structs. A tuple column is assigned to the struct field
+// with a matching `db` tag, or without a tag to the field whose name matches the column name case
+// insensitively. Fields tagged `db:"-"` are ignored and so are columns without a matching field.
+// The fields of embedded structs are matched as if they were fields of the outer struct.
+//
+// Column data is scanned into the fields with the pgtype.Map, so a field must be of a type that
+// can hold a NULL, such as a pointer or pgtype.Text, if its column can be NULL. Unchanged TOAST
+// columns carry no data and leave their field unchanged, which allows decoding the new tuple of an
+// update into the previous state of the row.
+//
+// TupleDecoder is safe for concurrent use.
+type TupleDecoder struct {
+	typeMap    *pgtype.Map
+	projection *Projection
+	fields     sync.Map // reflect.Type -> map[string][]int
+}
+
+// NewTupleDecoder returns a TupleDecoder scanning column data with m.
+func NewTupleDecoder(m *pgtype.Map) *TupleDecoder {
+	return &TupleDecoder{typeMap: m}
+}
+
+// NewProjectedTupleDecoder returns a TupleDecoder scanning column data with m that only decodes
+// the columns included by p. The fields of the other columns are left unchanged.
+func NewProjectedTupleDecoder(m *pgtype.Map, p *Projection) *TupleDecoder {
+	return &TupleDecoder{typeMap: m, projection: p}
+}
+
+// Decode decodes tuple, a tuple of rel, into dst, which must be a pointer to a struct.
+func (d *TupleDecoder) Decode(rel *RelationMessage, tuple *TupleData, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("dst must be a non-nil pointer to a struct, got %T", dst)
+	}
+	if tuple == nil {
+		return fmt.Errorf("tuple is nil")
+	}
+	if len(tuple.Columns) != len(rel.Columns) {
+		return fmt.Errorf("tuple has %d columns but relation %s.%s has %d", len(tuple.Columns), rel.Namespace, rel.RelationName, len(rel.Columns))
+	}
+
+	v = v.Elem()
+	fields := d.structFields(v.Type())
+	allowed := d.projection.columns(rel)
+	for i, col := range tuple.Columns {
+		relCol := rel.Columns[i]
+		index, ok := fields[strings.ToLower(relCol.Name)]
+		if !ok || col.DataType == TupleDataTypeToast {
+			continue
+		}
+		if allowed != nil {
+			if _, ok := allowed[relCol.Name]; !ok {
+				continue
+			}
+		}
+
+		var format int16
+		var data []byte
+		switch col.DataType {
+		case TupleDataTypeNull:
+		case TupleDataTypeText:
+			format, data = pgtype.TextFormatCode, col.Data
+		case TupleDataTypeBinary:
+			format, data = pgtype.BinaryFormatCode, col.Data
+		default:
+			return fmt.Errorf("invalid column's data type %c", col.DataType)
+		}
+
+		field, err := fieldByIndex(v, index)
+		if err != nil {
+			return err
+		}
+		if err := d.typeMap.Scan(relCol.DataType, format, data, field.Addr().Interface()); err != nil {
+			return fmt.Errorf("failed to decode column %s: %w", relCol.Name, err)
+		}
+	}
+	return nil
+}
+
+// fieldByIndex returns the field with index, allocating the embedded struct pointers it goes
+// through.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// structFields returns the index of the field of every column name of t, keyed by the lower case
+// column name.
+func (d *TupleDecoder) structFields(t reflect.Type) map[string][]int {
+	if fields, ok := d.fields.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+	fields := map[string][]int{}
+	collectStructFields(t, nil, fields)
+	d.fields.Store(t, fields)
+	return fields
+}
+
+func collectStructFields(t reflect.Type, parent []int, fields map[string][]int) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		index := append(append([]int{}, parent...), i)
+
+		tag, hasTag := sf.Tag.Lookup("db")
+		if tag == "-" {
+			continue
+		}
+		if sf.Anonymous && !hasTag {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				collectStructFields(ft, index, fields)
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+
+		name := sf.Name
+		if hasTag {
+			name = tag
+		}
+		name = strings.ToLower(name)
+		// Fields of the outer struct take precedence over the fields of embedded structs.
+		if existing, ok := fields[name]; ok && len(existing) <= len(index) {
+			continue
+		}
+		fields[name] = index
+	}
+}
@@ -0,0 +1,248 @@
+package pglogrepl_test
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTupleDataColumnDecodeValue(t *testing.T) {
+	typeMap := pgtype.NewMap()
+
+	textCol := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeText, Data: []byte("42")}
+	val, err := textCol.DecodeValue(typeMap, pgtype.Int4OID)
+	require.NoError(t, err)
+	assert.Equal(t, int32(42), val)
+
+	binCol := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeBinary, Data: binary.BigEndian.AppendUint32(nil, 42)}
+	val, err = binCol.DecodeValue(typeMap, pgtype.Int4OID)
+	require.NoError(t, err)
+	assert.Equal(t, int32(42), val)
+
+	ts := time.Date(2024, 3, 7, 12, 0, 0, 0, time.UTC)
+	tsData, err := typeMap.Encode(pgtype.TimestamptzOID, pgtype.BinaryFormatCode, ts, nil)
+	require.NoError(t, err)
+	binCol = &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeBinary, Data: tsData}
+	val, err = binCol.DecodeValue(typeMap, pgtype.TimestamptzOID)
+	require.NoError(t, err)
+	assert.True(t, ts.Equal(val.(time.Time)))
+
+	nullCol := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeNull}
+	val, err = nullCol.DecodeValue(typeMap, pgtype.Int4OID)
+	require.NoError(t, err)
+	assert.Nil(t, val)
+
+	toastCol := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeToast}
+	_, err = toastCol.DecodeValue(typeMap, pgtype.TextOID)
+	assert.Error(t, err)
+}
+
+func TestTupleDataColumnDecodeUnknownType(t *testing.T) {
+	typeMap := pgtype.NewMap()
+	const unknownOID = 999999
+
+	textCol := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeText, Data: []byte("(1,2)")}
+	val, err := textCol.DecodeValue(typeMap, unknownOID)
+	require.NoError(t, err)
+	assert.Equal(t, "(1,2)", val)
+
+	binCol := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeBinary, Data: []byte{1, 2}}
+	val, err = binCol.DecodeValue(typeMap, unknownOID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, val)
+
+	_, err = textCol.DecodeBinary(typeMap, unknownOID)
+	assert.Error(t, err)
+	_, err = binCol.DecodeText(typeMap, unknownOID)
+	assert.Error(t, err)
+}
+
+func TestTupleDataColumnInt64Binary(t *testing.T) {
+	for _, data := range [][]byte{
+		binary.BigEndian.AppendUint16(nil, uint16(0xFFFF)),
+		binary.BigEndian.AppendUint32(nil, uint32(0xFFFFFFFF)),
+		binary.BigEndian.AppendUint64(nil, uint64(0xFFFFFFFFFFFFFFFF)),
+	} {
+		col := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeBinary, Data: data}
+		n, err := col.Int64()
+		require.NoError(t, err)
+		assert.Equal(t, int64(-1), n)
+	}
+
+	col := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeBinary, Data: []byte{1, 2, 3}}
+	_, err := col.Int64()
+	assert.Error(t, err)
+}
+
+type testRowBase struct {
+	ID int64 `db:"id"`
+}
+
+type testRow struct {
+	testRowBase
+	Name    *string
+	Payload string `db:"doc"`
+	Ignored string `db:"-"`
+}
+
+func TestTupleDecoder(t *testing.T) {
+	rel := &pglogrepl.RelationMessage{
+		Namespace:    "public",
+		RelationName: "t",
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Name: "id", DataType: pgtype.Int8OID},
+			{Name: "name", DataType: pgtype.TextOID},
+			{Name: "doc", DataType: pgtype.TextOID},
+			{Name: "extra", DataType: pgtype.TextOID},
+		},
+	}
+	decoder := pglogrepl.NewTupleDecoder(pgtype.NewMap())
+
+	var row testRow
+	err := decoder.Decode(rel, &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{
+		{DataType: pglogrepl.TupleDataTypeBinary, Data: binary.BigEndian.AppendUint64(nil, 7)},
+		{DataType: pglogrepl.TupleDataTypeText, Data: []byte("a")},
+		{DataType: pglogrepl.TupleDataTypeText, Data: []byte("big")},
+		{DataType: pglogrepl.TupleDataTypeText, Data: []byte("x")},
+	}}, &row)
+	require.NoError(t, err)
+	require.NotNil(t, row.Name)
+	assert.Equal(t, testRow{testRowBase: testRowBase{ID: 7}, Name: row.Name, Payload: "big"}, row)
+	assert.Equal(t, "a", *row.Name)
+
+	// The unchanged TOAST value keeps the previous value.
+	err = decoder.Decode(rel, &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{
+		{DataType: pglogrepl.TupleDataTypeText, Data: []byte("8")},
+		{DataType: pglogrepl.TupleDataTypeNull},
+		{DataType: pglogrepl.TupleDataTypeToast},
+		{DataType: pglogrepl.TupleDataTypeNull},
+	}}, &row)
+	require.NoError(t, err)
+	assert.Equal(t, testRow{testRowBase: testRowBase{ID: 8}, Payload: "big"}, row)
+
+	// NULL cannot be assigned to a non-pointer field.
+	err = decoder.Decode(rel, &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{
+		{DataType: pglogrepl.TupleDataTypeNull},
+		{DataType: pglogrepl.TupleDataTypeNull},
+		{DataType: pglogrepl.TupleDataTypeNull},
+		{DataType: pglogrepl.TupleDataTypeNull},
+	}}, &row)
+	assert.Error(t, err)
+
+	assert.Error(t, decoder.Decode(rel, &pglogrepl.TupleData{}, &row))
+	assert.Error(t, decoder.Decode(rel, &pglogrepl.TupleData{}, row))
+}
+
+func textColumn(s string) *pglogrepl.TupleDataColumn {
+	return &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeText, Length: uint32(len(s)), Data: []byte(s)}
+}
+
+func diffRelation(replicaIdentity uint8) *pglogrepl.RelationMessage {
+	return &pglogrepl.RelationMessage{
+		Namespace:       "public",
+		RelationName:    "t",
+		ReplicaIdentity: replicaIdentity,
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Flags: 1, Name: "id", DataType: pgtype.Int4OID},
+			{Name: "name", DataType: pgtype.TextOID},
+			{Name: "doc", DataType: pgtype.TextOID},
+		},
+	}
+}
+
+func diffNames(diffs []pglogrepl.ColumnDiff) []string {
+	var names []string
+	for _, diff := range diffs {
+		names = append(names, diff.Name)
+	}
+	return names
+}
+
+func TestDecodeTuple(t *testing.T) {
+	values, err := pglogrepl.DecodeTuple(diffRelation(pglogrepl.ReplicaIdentityDefault), &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{
+		textColumn("1"),
+		{DataType: pglogrepl.TupleDataTypeNull},
+		{DataType: pglogrepl.TupleDataTypeToast},
+	}}, pgtype.NewMap())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": int32(1), "name": nil}, values)
+
+	_, err = pglogrepl.DecodeTuple(diffRelation(pglogrepl.ReplicaIdentityDefault), &pglogrepl.TupleData{}, pgtype.NewMap())
+	assert.Error(t, err)
+}
+
+func TestDecodeProjectedTuple(t *testing.T) {
+	p := pglogrepl.NewProjection()
+	p.Register("public", "t", "id")
+	p.Register("public", "other", "name")
+
+	// The projected out column is invalid, so it fails if it is decoded.
+	tuple := &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{
+		textColumn("1"),
+		{DataType: 'x'},
+		textColumn("d"),
+	}}
+	values, err := pglogrepl.DecodeProjectedTuple(diffRelation(pglogrepl.ReplicaIdentityDefault), tuple, pgtype.NewMap(), p)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": int32(1)}, values)
+
+	rel := diffRelation(pglogrepl.ReplicaIdentityDefault)
+	assert.True(t, p.Includes(rel, "id"))
+	assert.False(t, p.Includes(rel, "name"))
+	rel.RelationName = "unregistered"
+	assert.True(t, p.Includes(rel, "name"))
+}
+
+func TestProjectedTupleDecoder(t *testing.T) {
+	p := pglogrepl.NewProjection()
+	p.Register("public", "t", "id", "doc")
+	decoder := pglogrepl.NewProjectedTupleDecoder(pgtype.NewMap(), p)
+
+	name := "unchanged"
+	row := testRow{Name: &name}
+	err := decoder.Decode(diffRelation(pglogrepl.ReplicaIdentityDefault), &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{
+		textColumn("1"),
+		textColumn("a"),
+		textColumn("d"),
+	}}, &row)
+	require.NoError(t, err)
+	assert.Equal(t, testRow{testRowBase: testRowBase{ID: 1}, Name: &name, Payload: "d"}, row)
+	assert.Equal(t, "unchanged", name)
+}
+
+func TestDiffUpdateFull(t *testing.T) {
+	rel := diffRelation(pglogrepl.ReplicaIdentityFull)
+	old := &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{textColumn("1"), textColumn("a"), textColumn("d")}}
+	new := &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{textColumn("1"), textColumn("b"), {DataType: pglogrepl.TupleDataTypeToast}}}
+
+	diffs, err := pglogrepl.DiffUpdate(rel, old, new)
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+	assert.Equal(t, pglogrepl.ColumnDiff{Name: "name", Old: old.Columns[1], New: new.Columns[1]}, diffs[0])
+}
+
+func TestDiffUpdateDefault(t *testing.T) {
+	rel := diffRelation(pglogrepl.ReplicaIdentityDefault)
+	new := &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{textColumn("2"), textColumn("b"), {DataType: pglogrepl.TupleDataTypeToast}}}
+
+	// Without old key the key did not change and the old values of the other columns are unknown.
+	diffs, err := pglogrepl.DiffUpdate(rel, nil, new)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"name"}, diffNames(diffs))
+	assert.Nil(t, diffs[0].Old)
+
+	old := &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{textColumn("1"), {DataType: pglogrepl.TupleDataTypeNull}, {DataType: pglogrepl.TupleDataTypeNull}}}
+	diffs, err = pglogrepl.DiffUpdate(rel, old, new)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id", "name"}, diffNames(diffs))
+	assert.Equal(t, old.Columns[0], diffs[0].Old)
+	assert.Nil(t, diffs[1].Old)
+
+	_, err = pglogrepl.DiffUpdate(rel, old, nil)
+	assert.Error(t, err)
+}
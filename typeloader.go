@@ -0,0 +1,172 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// TypeLoader registers the user-defined types of the columns of a replication stream in a
+// pgtype.Map, so that their values are decoded like those of built-in types instead of being
+// returned as strings. pgoutput sends a TypeMessage before the first change of a relation with a
+// column of a type that is not built in; passing it to Register loads the definition of the type
+// from the catalog through a regular connection to the database.
+//
+// Composite, enum, domain, array, range and multirange types are supported. The types a type
+// depends on, such as the element type of an array or the field types of a composite, are loaded
+// first. Other types, such as base types defined by extensions, are left unregistered, and their
+// values keep being decoded as strings.
+//
+// The definitions are cached by m, and the types that cannot be registered are remembered, so the
+// catalog is only queried once per type. TypeLoader is safe for concurrent use, but its connection
+// must not be used concurrently by other goroutines.
+type TypeLoader struct {
+	typeMap *pgtype.Map
+	connect func(ctx context.Context) (*pgx.Conn, error)
+
+	mu          sync.Mutex
+	conn        *pgx.Conn
+	unsupported map[uint32]struct{}
+}
+
+// NewTypeLoader returns a TypeLoader loading types through conn, a regular connection to the
+// database of the replication stream, into m. The types are also registered in the type map of
+// conn, which resolves the types they depend on.
+func NewTypeLoader(conn *pgx.Conn, m *pgtype.Map) *TypeLoader {
+	return &TypeLoader{typeMap: m, conn: conn, unsupported: map[uint32]struct{}{}}
+}
+
+// NewLazyTypeLoader returns a TypeLoader like NewTypeLoader that opens its connection with
+// connect when it first needs to query the catalog, so that streams whose tables only use
+// built-in types never open it. Close closes the connection.
+func NewLazyTypeLoader(connect func(ctx context.Context) (*pgx.Conn, error), m *pgtype.Map) *TypeLoader {
+	return &TypeLoader{typeMap: m, connect: connect, unsupported: map[uint32]struct{}{}}
+}
+
+// Close closes the connection opened by a TypeLoader returned by NewLazyTypeLoader. The
+// connection given to NewTypeLoader is left open.
+func (l *TypeLoader) Close(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.connect == nil || l.conn == nil {
+		return nil
+	}
+	err := l.conn.Close(ctx)
+	l.conn = nil
+	return err
+}
+
+// Register loads the type of msg if it is a TypeMessage or TypeMessageV2 and ignores any other
+// message, so that every message of the stream can be passed to it.
+func (l *TypeLoader) Register(ctx context.Context, msg Message) error {
+	switch m := msg.(type) {
+	case *TypeMessage:
+		return l.Load(ctx, m.DataType)
+	case *TypeMessageV2:
+		return l.Load(ctx, m.DataType)
+	}
+	return nil
+}
+
+// RegisterRelation loads the types of the columns of rel that are not registered yet. It covers
+// the columns whose TypeMessage was received in an earlier session, as pgoutput sends the type
+// of a column only once per session.
+func (l *TypeLoader) RegisterRelation(ctx context.Context, rel *RelationMessage) error {
+	for _, col := range rel.Columns {
+		if err := l.Load(ctx, col.DataType); err != nil {
+			return fmt.Errorf("failed to load type of column %s: %w", col.Name, err)
+		}
+	}
+	return nil
+}
+
+// Load loads the type with oid and the types it depends on, unless it is already registered.
+func (l *TypeLoader) Load(ctx context.Context, oid uint32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.load(ctx, oid, map[uint32]bool{})
+}
+
+func (l *TypeLoader) load(ctx context.Context, oid uint32, loading map[uint32]bool) error {
+	if _, ok := l.typeMap.TypeForOID(oid); ok {
+		return nil
+	}
+	if _, ok := l.unsupported[oid]; ok {
+		return nil
+	}
+	if l.conn == nil {
+		conn, err := l.connect(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to connect to load type %d: %w", oid, err)
+		}
+		l.conn = conn
+	}
+	if t, ok := l.conn.TypeMap().TypeForOID(oid); ok {
+		l.typeMap.RegisterType(t)
+		return nil
+	}
+	if loading[oid] {
+		return fmt.Errorf("type %d depends on itself", oid)
+	}
+	loading[oid] = true
+
+	var typtype, name string
+	var elem, base, relid uint32
+	err := l.conn.QueryRow(ctx,
+		"SELECT t.typtype::text, format('%I.%I', n.nspname, t.typname), t.typelem, t.typbasetype, t.typrelid "+
+			"FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace WHERE t.oid = $1", oid,
+	).Scan(&typtype, &name, &elem, &base, &relid)
+	if err != nil {
+		return fmt.Errorf("failed to read type %d: %w", oid, err)
+	}
+
+	var deps []uint32
+	switch typtype {
+	case "b":
+		if elem == 0 {
+			// A base type without a codec, such as one defined by an extension.
+			l.unsupported[oid] = struct{}{}
+			return nil
+		}
+		deps = []uint32{elem}
+	case "c":
+		deps, err = l.queryOIDs(ctx, "SELECT atttypid FROM pg_attribute WHERE attrelid = $1 AND attnum > 0 AND NOT attisdropped", relid)
+	case "d":
+		deps = []uint32{base}
+	case "e":
+	case "r":
+		deps, err = l.queryOIDs(ctx, "SELECT rngsubtype FROM pg_range WHERE rngtypid = $1", oid)
+	case "m":
+		deps, err = l.queryOIDs(ctx, "SELECT rngtypid FROM pg_range WHERE rngmultitypid = $1", oid)
+	default:
+		l.unsupported[oid] = struct{}{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read dependencies of type %s: %w", name, err)
+	}
+	for _, dep := range deps {
+		if err := l.load(ctx, dep, loading); err != nil {
+			return err
+		}
+	}
+
+	t, err := l.conn.LoadType(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to load type %s: %w", name, err)
+	}
+	l.conn.TypeMap().RegisterType(t)
+	l.typeMap.RegisterType(t)
+	return nil
+}
+
+func (l *TypeLoader) queryOIDs(ctx context.Context, sql string, arg uint32) ([]uint32, error) {
+	rows, err := l.conn.Query(ctx, sql, arg)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[uint32])
+}
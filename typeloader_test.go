@@ -0,0 +1,51 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypeLoader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	config, err := pgx.ParseConfig(os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	delete(config.RuntimeParams, "replication")
+	conn, err := pgx.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, `drop type if exists pglogrepl_item, pglogrepl_mood;
+create type pglogrepl_mood as enum ('sad', 'happy');
+create type pglogrepl_item as (name text, mood pglogrepl_mood)`)
+	require.NoError(t, err)
+	defer conn.Exec(context.Background(), "drop type if exists pglogrepl_item, pglogrepl_mood")
+
+	var arrayOID uint32
+	require.NoError(t, conn.QueryRow(ctx, "select '_pglogrepl_item'::regtype::oid").Scan(&arrayOID))
+
+	m := pgtype.NewMap()
+	loader := pglogrepl.NewTypeLoader(conn, m)
+	require.NoError(t, loader.Register(ctx, &pglogrepl.TypeMessage{DataType: arrayOID, Namespace: "public", Name: "_pglogrepl_item"}))
+	_, ok := m.TypeForOID(arrayOID)
+	require.True(t, ok)
+
+	col := &pglogrepl.TupleDataColumn{DataType: pglogrepl.TupleDataTypeText, Data: []byte(`{"(a,happy)"}`)}
+	val, err := col.DecodeValue(m, arrayOID)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "a", "mood": "happy"}}, val)
+
+	// Built-in types are already registered.
+	require.NoError(t, loader.RegisterRelation(ctx, &pglogrepl.RelationMessage{
+		Columns: []*pglogrepl.RelationMessageColumn{{Name: "id", DataType: pgtype.Int4OID}},
+	}))
+}
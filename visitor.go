@@ -0,0 +1,239 @@
+package pglogrepl
+
+import (
+	"fmt"
+)
+
+// Visitor has a method for every message type decoded by the Parse functions. A message passes
+// itself to the method of its type with Accept, so that a Visitor handles messages without a type
+// switch. Since a method is added to Visitor when a new message type is supported, an
+// implementation that does not embed NopVisitor fails to compile until it handles the new type.
+//
+// The messages of protocol version 2 and later that wrap a protocol version 1 message, such as
+// InsertMessageV2, have methods of their own.
+type Visitor interface {
+	VisitBegin(m *BeginMessage) error
+	VisitCommit(m *CommitMessage) error
+	VisitOrigin(m *OriginMessage) error
+	VisitRelation(m *RelationMessage) error
+	VisitType(m *TypeMessage) error
+	VisitInsert(m *InsertMessage) error
+	VisitUpdate(m *UpdateMessage) error
+	VisitDelete(m *DeleteMessage) error
+	VisitTruncate(m *TruncateMessage) error
+	VisitLogicalDecodingMessage(m *LogicalDecodingMessage) error
+	VisitStreamStart(m *StreamStartMessageV2) error
+	VisitStreamStop(m *StreamStopMessageV2) error
+	VisitStreamCommit(m *StreamCommitMessageV2) error
+	VisitStreamAbort(m *StreamAbortMessageV2) error
+	VisitRelationV2(m *RelationMessageV2) error
+	VisitTypeV2(m *TypeMessageV2) error
+	VisitInsertV2(m *InsertMessageV2) error
+	VisitUpdateV2(m *UpdateMessageV2) error
+	VisitDeleteV2(m *DeleteMessageV2) error
+	VisitTruncateV2(m *TruncateMessageV2) error
+	VisitLogicalDecodingMessageV2(m *LogicalDecodingMessageV2) error
+	VisitBeginPrepare(m *BeginPrepareMessageV3) error
+	VisitPrepare(m *PrepareMessageV3) error
+	VisitCommitPrepared(m *CommitPreparedMessageV3) error
+	VisitRollbackPrepared(m *RollbackPreparedMessageV3) error
+	VisitStreamPrepare(m *StreamPrepareMessageV3) error
+	VisitStreamAbortV4(m *StreamAbortMessageV4) error
+	VisitSequence(m *SequenceMessage) error
+	VisitSequenceV2(m *SequenceMessageV2) error
+}
+
+// NopVisitor is a Visitor ignoring all messages. Implementations can embed it to only implement
+// the methods they need.
+type NopVisitor struct{}
+
+func (NopVisitor) VisitBegin(*BeginMessage) error                                { return nil }
+func (NopVisitor) VisitCommit(*CommitMessage) error                              { return nil }
+func (NopVisitor) VisitOrigin(*OriginMessage) error                              { return nil }
+func (NopVisitor) VisitRelation(*RelationMessage) error                          { return nil }
+func (NopVisitor) VisitType(*TypeMessage) error                                  { return nil }
+func (NopVisitor) VisitInsert(*InsertMessage) error                              { return nil }
+func (NopVisitor) VisitUpdate(*UpdateMessage) error                              { return nil }
+func (NopVisitor) VisitDelete(*DeleteMessage) error                              { return nil }
+func (NopVisitor) VisitTruncate(*TruncateMessage) error                          { return nil }
+func (NopVisitor) VisitLogicalDecodingMessage(*LogicalDecodingMessage) error     { return nil }
+func (NopVisitor) VisitStreamStart(*StreamStartMessageV2) error                  { return nil }
+func (NopVisitor) VisitStreamStop(*StreamStopMessageV2) error                    { return nil }
+func (NopVisitor) VisitStreamCommit(*StreamCommitMessageV2) error                { return nil }
+func (NopVisitor) VisitStreamAbort(*StreamAbortMessageV2) error                  { return nil }
+func (NopVisitor) VisitRelationV2(*RelationMessageV2) error                      { return nil }
+func (NopVisitor) VisitTypeV2(*TypeMessageV2) error                              { return nil }
+func (NopVisitor) VisitInsertV2(*InsertMessageV2) error                          { return nil }
+func (NopVisitor) VisitUpdateV2(*UpdateMessageV2) error                          { return nil }
+func (NopVisitor) VisitDeleteV2(*DeleteMessageV2) error                          { return nil }
+func (NopVisitor) VisitTruncateV2(*TruncateMessageV2) error                      { return nil }
+func (NopVisitor) VisitLogicalDecodingMessageV2(*LogicalDecodingMessageV2) error { return nil }
+func (NopVisitor) VisitBeginPrepare(*BeginPrepareMessageV3) error                { return nil }
+func (NopVisitor) VisitPrepare(*PrepareMessageV3) error                          { return nil }
+func (NopVisitor) VisitCommitPrepared(*CommitPreparedMessageV3) error            { return nil }
+func (NopVisitor) VisitRollbackPrepared(*RollbackPreparedMessageV3) error        { return nil }
+func (NopVisitor) VisitStreamPrepare(*StreamPrepareMessageV3) error              { return nil }
+func (NopVisitor) VisitStreamAbortV4(*StreamAbortMessageV4) error                { return nil }
+func (NopVisitor) VisitSequence(*SequenceMessage) error                          { return nil }
+func (NopVisitor) VisitSequenceV2(*SequenceMessageV2) error                      { return nil }
+
+// Visit passes msg to the method of v for its type. It fails for a message type that Visitor does
+// not have a method for.
+func Visit(msg Message, v Visitor) error {
+	acceptor, ok := msg.(interface{ Accept(v Visitor) error })
+	if !ok {
+		return fmt.Errorf("no visitor method for message type %T", msg)
+	}
+	return acceptor.Accept(v)
+}
+
+// Accept calls v.VisitBegin with the message.
+func (m *BeginMessage) Accept(v Visitor) error { return v.VisitBegin(m) }
+
+// Accept calls v.VisitCommit with the message.
+func (m *CommitMessage) Accept(v Visitor) error { return v.VisitCommit(m) }
+
+// Accept calls v.VisitOrigin with the message.
+func (m *OriginMessage) Accept(v Visitor) error { return v.VisitOrigin(m) }
+
+// Accept calls v.VisitRelation with the message.
+func (m *RelationMessage) Accept(v Visitor) error { return v.VisitRelation(m) }
+
+// Accept calls v.VisitType with the message.
+func (m *TypeMessage) Accept(v Visitor) error { return v.VisitType(m) }
+
+// Accept calls v.VisitInsert with the message.
+func (m *InsertMessage) Accept(v Visitor) error { return v.VisitInsert(m) }
+
+// Accept calls v.VisitUpdate with the message.
+func (m *UpdateMessage) Accept(v Visitor) error { return v.VisitUpdate(m) }
+
+// Accept calls v.VisitDelete with the message.
+func (m *DeleteMessage) Accept(v Visitor) error { return v.VisitDelete(m) }
+
+// Accept calls v.VisitTruncate with the message.
+func (m *TruncateMessage) Accept(v Visitor) error { return v.VisitTruncate(m) }
+
+// Accept calls v.VisitLogicalDecodingMessage with the message.
+func (m *LogicalDecodingMessage) Accept(v Visitor) error { return v.VisitLogicalDecodingMessage(m) }
+
+// Accept calls v.VisitStreamStart with the message.
+func (m *StreamStartMessageV2) Accept(v Visitor) error { return v.VisitStreamStart(m) }
+
+// Accept calls v.VisitStreamStop with the message.
+func (m *StreamStopMessageV2) Accept(v Visitor) error { return v.VisitStreamStop(m) }
+
+// Accept calls v.VisitStreamCommit with the message.
+func (m *StreamCommitMessageV2) Accept(v Visitor) error { return v.VisitStreamCommit(m) }
+
+// Accept calls v.VisitStreamAbort with the message.
+func (m *StreamAbortMessageV2) Accept(v Visitor) error { return v.VisitStreamAbort(m) }
+
+// Accept calls v.VisitRelationV2 with the message.
+func (m *RelationMessageV2) Accept(v Visitor) error { return v.VisitRelationV2(m) }
+
+// Accept calls v.VisitTypeV2 with the message.
+func (m *TypeMessageV2) Accept(v Visitor) error { return v.VisitTypeV2(m) }
+
+// Accept calls v.VisitInsertV2 with the message.
+func (m *InsertMessageV2) Accept(v Visitor) error { return v.VisitInsertV2(m) }
+
+// Accept calls v.VisitUpdateV2 with the message.
+func (m *UpdateMessageV2) Accept(v Visitor) error { return v.VisitUpdateV2(m) }
+
+// Accept calls v.VisitDeleteV2 with the message.
+func (m *DeleteMessageV2) Accept(v Visitor) error { return v.VisitDeleteV2(m) }
+
+// Accept calls v.VisitTruncateV2 with the message.
+func (m *TruncateMessageV2) Accept(v Visitor) error { return v.VisitTruncateV2(m) }
+
+// Accept calls v.VisitLogicalDecodingMessageV2 with the message.
+func (m *LogicalDecodingMessageV2) Accept(v Visitor) error { return v.VisitLogicalDecodingMessageV2(m) }
+
+// Accept calls v.VisitBeginPrepare with the message.
+func (m *BeginPrepareMessageV3) Accept(v Visitor) error { return v.VisitBeginPrepare(m) }
+
+// Accept calls v.VisitPrepare with the message.
+func (m *PrepareMessageV3) Accept(v Visitor) error { return v.VisitPrepare(m) }
+
+// Accept calls v.VisitCommitPrepared with the message.
+func (m *CommitPreparedMessageV3) Accept(v Visitor) error { return v.VisitCommitPrepared(m) }
+
+// Accept calls v.VisitRollbackPrepared with the message.
+func (m *RollbackPreparedMessageV3) Accept(v Visitor) error { return v.VisitRollbackPrepared(m) }
+
+// Accept calls v.VisitStreamPrepare with the message.
+func (m *StreamPrepareMessageV3) Accept(v Visitor) error { return v.VisitStreamPrepare(m) }
+
+// Accept calls v.VisitStreamAbortV4 with the message.
+func (m *StreamAbortMessageV4) Accept(v Visitor) error { return v.VisitStreamAbortV4(m) }
+
+// Accept calls v.VisitSequence with the message.
+func (m *SequenceMessage) Accept(v Visitor) error { return v.VisitSequence(m) }
+
+// Accept calls v.VisitSequenceV2 with the message.
+func (m *SequenceMessageV2) Accept(v Visitor) error { return v.VisitSequenceV2(m) }
+
+// The type of a message is fixed by its Go type, so that it is also set for messages created by
+// applications rather than decoded. A message wrapping another message has the type of the
+// wrapped message.
+
+// Type returns MessageTypeBegin.
+func (m *BeginMessage) Type() MessageType { return MessageTypeBegin }
+
+// Type returns MessageTypeCommit.
+func (m *CommitMessage) Type() MessageType { return MessageTypeCommit }
+
+// Type returns MessageTypeOrigin.
+func (m *OriginMessage) Type() MessageType { return MessageTypeOrigin }
+
+// Type returns MessageTypeRelation.
+func (m *RelationMessage) Type() MessageType { return MessageTypeRelation }
+
+// Type returns MessageTypeType.
+func (m *TypeMessage) Type() MessageType { return MessageTypeType }
+
+// Type returns MessageTypeInsert.
+func (m *InsertMessage) Type() MessageType { return MessageTypeInsert }
+
+// Type returns MessageTypeUpdate.
+func (m *UpdateMessage) Type() MessageType { return MessageTypeUpdate }
+
+// Type returns MessageTypeDelete.
+func (m *DeleteMessage) Type() MessageType { return MessageTypeDelete }
+
+// Type returns MessageTypeTruncate.
+func (m *TruncateMessage) Type() MessageType { return MessageTypeTruncate }
+
+// Type returns MessageTypeMessage.
+func (m *LogicalDecodingMessage) Type() MessageType { return MessageTypeMessage }
+
+// Type returns MessageTypeStreamStart.
+func (m *StreamStartMessageV2) Type() MessageType { return MessageTypeStreamStart }
+
+// Type returns MessageTypeStreamStop.
+func (m *StreamStopMessageV2) Type() MessageType { return MessageTypeStreamStop }
+
+// Type returns MessageTypeStreamCommit.
+func (m *StreamCommitMessageV2) Type() MessageType { return MessageTypeStreamCommit }
+
+// Type returns MessageTypeStreamAbort.
+func (m *StreamAbortMessageV2) Type() MessageType { return MessageTypeStreamAbort }
+
+// Type returns MessageTypeBeginPrepare.
+func (m *BeginPrepareMessageV3) Type() MessageType { return MessageTypeBeginPrepare }
+
+// Type returns MessageTypePrepare.
+func (m *PrepareMessageV3) Type() MessageType { return MessageTypePrepare }
+
+// Type returns MessageTypeCommitPrepared.
+func (m *CommitPreparedMessageV3) Type() MessageType { return MessageTypeCommitPrepared }
+
+// Type returns MessageTypeRollbackPrepared.
+func (m *RollbackPreparedMessageV3) Type() MessageType { return MessageTypeRollbackPrepared }
+
+// Type returns MessageTypeStreamPrepare.
+func (m *StreamPrepareMessageV3) Type() MessageType { return MessageTypeStreamPrepare }
+
+// Type returns MessageTypeSequence.
+func (m *SequenceMessage) Type() MessageType { return MessageTypeSequence }
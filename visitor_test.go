@@ -0,0 +1,254 @@
+package pglogrepl_test
+
+import (
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingVisitor implements every method so that it fails to compile when a message type is
+// added without a test.
+type recordingVisitor struct {
+	visited []string
+}
+
+func (v *recordingVisitor) VisitBegin(*pglogrepl.BeginMessage) error {
+	v.visited = append(v.visited, "VisitBegin")
+	return nil
+}
+
+func (v *recordingVisitor) VisitCommit(*pglogrepl.CommitMessage) error {
+	v.visited = append(v.visited, "VisitCommit")
+	return nil
+}
+
+func (v *recordingVisitor) VisitOrigin(*pglogrepl.OriginMessage) error {
+	v.visited = append(v.visited, "VisitOrigin")
+	return nil
+}
+
+func (v *recordingVisitor) VisitRelation(*pglogrepl.RelationMessage) error {
+	v.visited = append(v.visited, "VisitRelation")
+	return nil
+}
+
+func (v *recordingVisitor) VisitType(*pglogrepl.TypeMessage) error {
+	v.visited = append(v.visited, "VisitType")
+	return nil
+}
+
+func (v *recordingVisitor) VisitInsert(*pglogrepl.InsertMessage) error {
+	v.visited = append(v.visited, "VisitInsert")
+	return nil
+}
+
+func (v *recordingVisitor) VisitUpdate(*pglogrepl.UpdateMessage) error {
+	v.visited = append(v.visited, "VisitUpdate")
+	return nil
+}
+
+func (v *recordingVisitor) VisitDelete(*pglogrepl.DeleteMessage) error {
+	v.visited = append(v.visited, "VisitDelete")
+	return nil
+}
+
+func (v *recordingVisitor) VisitTruncate(*pglogrepl.TruncateMessage) error {
+	v.visited = append(v.visited, "VisitTruncate")
+	return nil
+}
+
+func (v *recordingVisitor) VisitLogicalDecodingMessage(*pglogrepl.LogicalDecodingMessage) error {
+	v.visited = append(v.visited, "VisitLogicalDecodingMessage")
+	return nil
+}
+
+func (v *recordingVisitor) VisitStreamStart(*pglogrepl.StreamStartMessageV2) error {
+	v.visited = append(v.visited, "VisitStreamStart")
+	return nil
+}
+
+func (v *recordingVisitor) VisitStreamStop(*pglogrepl.StreamStopMessageV2) error {
+	v.visited = append(v.visited, "VisitStreamStop")
+	return nil
+}
+
+func (v *recordingVisitor) VisitStreamCommit(*pglogrepl.StreamCommitMessageV2) error {
+	v.visited = append(v.visited, "VisitStreamCommit")
+	return nil
+}
+
+func (v *recordingVisitor) VisitStreamAbort(*pglogrepl.StreamAbortMessageV2) error {
+	v.visited = append(v.visited, "VisitStreamAbort")
+	return nil
+}
+
+func (v *recordingVisitor) VisitRelationV2(*pglogrepl.RelationMessageV2) error {
+	v.visited = append(v.visited, "VisitRelationV2")
+	return nil
+}
+
+func (v *recordingVisitor) VisitTypeV2(*pglogrepl.TypeMessageV2) error {
+	v.visited = append(v.visited, "VisitTypeV2")
+	return nil
+}
+
+func (v *recordingVisitor) VisitInsertV2(*pglogrepl.InsertMessageV2) error {
+	v.visited = append(v.visited, "VisitInsertV2")
+	return nil
+}
+
+func (v *recordingVisitor) VisitUpdateV2(*pglogrepl.UpdateMessageV2) error {
+	v.visited = append(v.visited, "VisitUpdateV2")
+	return nil
+}
+
+func (v *recordingVisitor) VisitDeleteV2(*pglogrepl.DeleteMessageV2) error {
+	v.visited = append(v.visited, "VisitDeleteV2")
+	return nil
+}
+
+func (v *recordingVisitor) VisitTruncateV2(*pglogrepl.TruncateMessageV2) error {
+	v.visited = append(v.visited, "VisitTruncateV2")
+	return nil
+}
+
+func (v *recordingVisitor) VisitLogicalDecodingMessageV2(*pglogrepl.LogicalDecodingMessageV2) error {
+	v.visited = append(v.visited, "VisitLogicalDecodingMessageV2")
+	return nil
+}
+
+func (v *recordingVisitor) VisitBeginPrepare(*pglogrepl.BeginPrepareMessageV3) error {
+	v.visited = append(v.visited, "VisitBeginPrepare")
+	return nil
+}
+
+func (v *recordingVisitor) VisitPrepare(*pglogrepl.PrepareMessageV3) error {
+	v.visited = append(v.visited, "VisitPrepare")
+	return nil
+}
+
+func (v *recordingVisitor) VisitCommitPrepared(*pglogrepl.CommitPreparedMessageV3) error {
+	v.visited = append(v.visited, "VisitCommitPrepared")
+	return nil
+}
+
+func (v *recordingVisitor) VisitRollbackPrepared(*pglogrepl.RollbackPreparedMessageV3) error {
+	v.visited = append(v.visited, "VisitRollbackPrepared")
+	return nil
+}
+
+func (v *recordingVisitor) VisitStreamPrepare(*pglogrepl.StreamPrepareMessageV3) error {
+	v.visited = append(v.visited, "VisitStreamPrepare")
+	return nil
+}
+
+func (v *recordingVisitor) VisitStreamAbortV4(*pglogrepl.StreamAbortMessageV4) error {
+	v.visited = append(v.visited, "VisitStreamAbortV4")
+	return nil
+}
+
+func (v *recordingVisitor) VisitSequence(*pglogrepl.SequenceMessage) error {
+	v.visited = append(v.visited, "VisitSequence")
+	return nil
+}
+
+func (v *recordingVisitor) VisitSequenceV2(*pglogrepl.SequenceMessageV2) error {
+	v.visited = append(v.visited, "VisitSequenceV2")
+	return nil
+}
+
+func TestVisit(t *testing.T) {
+	msgs := []pglogrepl.Message{
+		&pglogrepl.BeginMessage{},
+		&pglogrepl.CommitMessage{},
+		&pglogrepl.OriginMessage{},
+		&pglogrepl.RelationMessage{},
+		&pglogrepl.TypeMessage{},
+		&pglogrepl.InsertMessage{},
+		&pglogrepl.UpdateMessage{},
+		&pglogrepl.DeleteMessage{},
+		&pglogrepl.TruncateMessage{},
+		&pglogrepl.LogicalDecodingMessage{},
+		&pglogrepl.StreamStartMessageV2{},
+		&pglogrepl.StreamStopMessageV2{},
+		&pglogrepl.StreamCommitMessageV2{},
+		&pglogrepl.StreamAbortMessageV2{},
+		&pglogrepl.RelationMessageV2{},
+		&pglogrepl.TypeMessageV2{},
+		&pglogrepl.InsertMessageV2{},
+		&pglogrepl.UpdateMessageV2{},
+		&pglogrepl.DeleteMessageV2{},
+		&pglogrepl.TruncateMessageV2{},
+		&pglogrepl.LogicalDecodingMessageV2{},
+		&pglogrepl.BeginPrepareMessageV3{},
+		&pglogrepl.PrepareMessageV3{},
+		&pglogrepl.CommitPreparedMessageV3{},
+		&pglogrepl.RollbackPreparedMessageV3{},
+		&pglogrepl.StreamPrepareMessageV3{},
+		&pglogrepl.StreamAbortMessageV4{},
+		&pglogrepl.SequenceMessage{},
+		&pglogrepl.SequenceMessageV2{},
+	}
+	v := &recordingVisitor{}
+	for _, msg := range msgs {
+		require.NoError(t, pglogrepl.Visit(msg, v))
+	}
+	assert.Equal(t, []string{
+		"VisitBegin",
+		"VisitCommit",
+		"VisitOrigin",
+		"VisitRelation",
+		"VisitType",
+		"VisitInsert",
+		"VisitUpdate",
+		"VisitDelete",
+		"VisitTruncate",
+		"VisitLogicalDecodingMessage",
+		"VisitStreamStart",
+		"VisitStreamStop",
+		"VisitStreamCommit",
+		"VisitStreamAbort",
+		"VisitRelationV2",
+		"VisitTypeV2",
+		"VisitInsertV2",
+		"VisitUpdateV2",
+		"VisitDeleteV2",
+		"VisitTruncateV2",
+		"VisitLogicalDecodingMessageV2",
+		"VisitBeginPrepare",
+		"VisitPrepare",
+		"VisitCommitPrepared",
+		"VisitRollbackPrepared",
+		"VisitStreamPrepare",
+		"VisitStreamAbortV4",
+		"VisitSequence",
+		"VisitSequenceV2",
+	}, v.visited)
+}
+
+func TestMessageTypeOfCreatedMessages(t *testing.T) {
+	assert.Equal(t, pglogrepl.MessageTypeInsert, (&pglogrepl.InsertMessage{}).Type())
+	assert.Equal(t, pglogrepl.MessageTypeInsert, (&pglogrepl.InsertMessageV2{}).Type())
+	assert.Equal(t, pglogrepl.MessageTypeStreamAbort, (&pglogrepl.StreamAbortMessageV4{}).Type())
+	assert.Equal(t, pglogrepl.MessageTypeStreamPrepare, (&pglogrepl.StreamPrepareMessageV3{}).Type())
+	assert.Equal(t, pglogrepl.MessageTypeSequence, (&pglogrepl.SequenceMessageV2{}).Type())
+}
+
+type insertCounter struct {
+	pglogrepl.NopVisitor
+	inserts int
+}
+
+func (c *insertCounter) VisitInsertV2(*pglogrepl.InsertMessageV2) error {
+	c.inserts++
+	return nil
+}
+
+func TestNopVisitor(t *testing.T) {
+	c := &insertCounter{}
+	require.NoError(t, pglogrepl.Visit(&pglogrepl.BeginMessage{}, c))
+	require.NoError(t, pglogrepl.Visit(&pglogrepl.InsertMessageV2{}, c))
+	assert.Equal(t, 1, c.inserts)
+}
@@ -0,0 +1,83 @@
+package wal2json
+
+import (
+	"github.com/jackc/pglogrepl"
+)
+
+var operations = map[Kind]pglogrepl.Operation{
+	KindInsert:   pglogrepl.OperationInsert,
+	KindUpdate:   pglogrepl.OperationUpdate,
+	KindDelete:   pglogrepl.OperationDelete,
+	KindTruncate: pglogrepl.OperationTruncate,
+}
+
+// ChangeEvent converts c into a pglogrepl.ChangeEvent without transaction metadata. It returns nil
+// for a KindMessage change. The key is the old keys of an update or delete, or the primary key
+// columns of the new row if the include-pk option is enabled.
+func (c *Change) ChangeEvent() *pglogrepl.ChangeEvent {
+	op, ok := operations[c.Kind]
+	if !ok {
+		return nil
+	}
+	event := &pglogrepl.ChangeEvent{
+		Operation: op,
+		Schema:    c.Schema,
+		Table:     c.Name,
+		Before:    columnValues(c.OldKeys),
+		After:     columnValues(c.Columns),
+	}
+	switch {
+	case event.Before != nil:
+		event.Key = event.Before
+	case len(c.PrimaryKey) > 0 && event.After != nil:
+		event.Key = make(map[string]interface{}, len(c.PrimaryKey))
+		for _, pk := range c.PrimaryKey {
+			event.Key[pk.Name] = event.After[pk.Name]
+		}
+	}
+	return event
+}
+
+// ChangeEvents converts the row changes of tx into pglogrepl.ChangeEvents. Their LSN is the
+// NextLSN of the transaction.
+func (tx *Transaction) ChangeEvents() []*pglogrepl.ChangeEvent {
+	events := make([]*pglogrepl.ChangeEvent, 0, len(tx.Changes))
+	for i := range tx.Changes {
+		event := tx.Changes[i].ChangeEvent()
+		if event == nil {
+			continue
+		}
+		event.LSN = tx.NextLSN
+		event.CommitTime = tx.Timestamp
+		event.Xid = tx.Xid
+		events = append(events, event)
+	}
+	return events
+}
+
+// ChangeEvent converts r into a pglogrepl.ChangeEvent. It returns nil for begin, commit and
+// message records.
+func (r *Record) ChangeEvent() *pglogrepl.ChangeEvent {
+	if r.Change == nil {
+		return nil
+	}
+	event := r.Change.ChangeEvent()
+	if event == nil {
+		return nil
+	}
+	event.LSN = r.LSN
+	event.CommitTime = r.Timestamp
+	event.Xid = r.Xid
+	return event
+}
+
+func columnValues(columns []Column) map[string]interface{} {
+	if columns == nil {
+		return nil
+	}
+	values := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		values[column.Name] = column.Value
+	}
+	return values
+}
@@ -0,0 +1,68 @@
+package wal2json_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/wal2json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionChangeEvents(t *testing.T) {
+	tx, err := wal2json.ParseV1([]byte(`{"xid":771,"nextlsn":"0/16B2470","timestamp":"2024-02-26 10:15:30.5+00","change":[
+		{"kind":"insert","schema":"public","table":"t","columnnames":["id","name"],"columnvalues":[1,null],"pk":{"pknames":["id"],"pktypes":["integer"]}},
+		{"kind":"delete","schema":"public","table":"t","oldkeys":{"keynames":["id"],"keytypes":["integer"],"keyvalues":[1]}},
+		{"kind":"message","transactional":true,"prefix":"app","content":"hi"}
+	]}`))
+	require.NoError(t, err)
+
+	commitTime := time.Date(2024, 2, 26, 10, 15, 30, 500000000, time.UTC)
+	events := tx.ChangeEvents()
+	require.Len(t, events, 2)
+	assert.True(t, commitTime.Equal(events[0].CommitTime))
+	events[0].CommitTime, events[1].CommitTime = time.Time{}, time.Time{}
+	assert.Equal(t, []*pglogrepl.ChangeEvent{
+		{
+			Operation: pglogrepl.OperationInsert,
+			Schema:    "public",
+			Table:     "t",
+			Key:       map[string]interface{}{"id": json.Number("1")},
+			After:     map[string]interface{}{"id": json.Number("1"), "name": nil},
+			LSN:       0x16B2470,
+			Xid:       771,
+		},
+		{
+			Operation: pglogrepl.OperationDelete,
+			Schema:    "public",
+			Table:     "t",
+			Key:       map[string]interface{}{"id": json.Number("1")},
+			Before:    map[string]interface{}{"id": json.Number("1")},
+			LSN:       0x16B2470,
+			Xid:       771,
+		},
+	}, events)
+}
+
+func TestRecordChangeEvent(t *testing.T) {
+	update, err := wal2json.ParseV2([]byte(`{"action":"U","xid":772,"lsn":"0/16B2400","schema":"public","table":"t","columns":[{"name":"id","type":"integer","value":2}],"identity":[{"name":"id","type":"integer","value":1}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, &pglogrepl.ChangeEvent{
+		Operation: pglogrepl.OperationUpdate,
+		Schema:    "public",
+		Table:     "t",
+		Key:       map[string]interface{}{"id": json.Number("1")},
+		Before:    map[string]interface{}{"id": json.Number("1")},
+		After:     map[string]interface{}{"id": json.Number("2")},
+		LSN:       0x16B2400,
+		Xid:       772,
+	}, update.ChangeEvent())
+
+	for _, data := range []string{`{"action":"B","xid":772}`, `{"action":"M","transactional":false,"prefix":"app","content":"hi"}`} {
+		r, err := wal2json.ParseV2([]byte(data))
+		require.NoError(t, err)
+		assert.Nil(t, r.ChangeEvent())
+	}
+}
@@ -0,0 +1,373 @@
+// Package wal2json parses the output of the wal2json logical decoding output plugin.
+//
+// Both versions of the wal2json output format are supported. Format version 1 emits one JSON
+// object per transaction and is parsed with ParseV1. Format version 2 emits one JSON object per
+// tuple or transaction boundary and is parsed with ParseV2.
+package wal2json
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+)
+
+// Kind is the kind of a Change.
+type Kind string
+
+// List of change kinds.
+const (
+	KindInsert   Kind = "insert"
+	KindUpdate   Kind = "update"
+	KindDelete   Kind = "delete"
+	KindTruncate Kind = "truncate"
+	KindMessage  Kind = "message"
+)
+
+// Action is the action of a format version 2 Record.
+type Action string
+
+// List of format version 2 actions.
+const (
+	ActionBegin    Action = "B"
+	ActionCommit   Action = "C"
+	ActionInsert   Action = "I"
+	ActionUpdate   Action = "U"
+	ActionDelete   Action = "D"
+	ActionTruncate Action = "T"
+	ActionMessage  Action = "M"
+)
+
+func (a Action) kind() (Kind, bool) {
+	switch a {
+	case ActionInsert:
+		return KindInsert, true
+	case ActionUpdate:
+		return KindUpdate, true
+	case ActionDelete:
+		return KindDelete, true
+	case ActionTruncate:
+		return KindTruncate, true
+	case ActionMessage:
+		return KindMessage, true
+	}
+	return "", false
+}
+
+// Table identifies the table a Change applies to.
+type Table struct {
+	Schema string
+	Name   string
+}
+
+// Column is a column of a row. Value is the column value as decoded from JSON: nil for SQL NULL,
+// a json.Number for numeric types, a bool for booleans and a string for everything else.
+type Column struct {
+	Name string
+	// Type is the type name as formatted by format_type, e.g. "integer" or "character varying(20)".
+	Type string
+	// TypeOID is only set if the include-type-oids option is enabled.
+	TypeOID uint32
+	// Optional reports whether the column accepts NULL. It is only set if the include-not-null
+	// option is enabled.
+	Optional bool
+	Value    interface{}
+}
+
+// Change is a row change or a logical decoding message.
+type Change struct {
+	Kind Kind
+	Table
+	// Columns is the new row of an insert or update.
+	Columns []Column
+	// OldKeys is the replica identity of the old row of an update or delete. It is nil if the
+	// replica identity was not changed by an update.
+	OldKeys []Column
+	// PrimaryKey lists the names and types of the primary key columns. It is only set if the
+	// include-pk option is enabled.
+	PrimaryKey []Column
+
+	// Transactional, Prefix and Content are only set for KindMessage.
+	Transactional bool
+	Prefix        string
+	Content       string
+}
+
+// Transaction is a transaction in format version 1.
+type Transaction struct {
+	// Xid is only set if the include-xids option is enabled.
+	Xid uint32
+	// NextLSN is only set if the include-lsn option is enabled.
+	NextLSN pglogrepl.LSN
+	// Timestamp is the commit time. It is only set if the include-timestamp option is enabled.
+	Timestamp time.Time
+	Changes   []Change
+}
+
+// Record is a single output object in format version 2. Begin and commit records mark the
+// transaction boundaries and have no Change.
+type Record struct {
+	Action Action
+	// Xid is only set if the include-xids option is enabled.
+	Xid uint32
+	// Timestamp is the commit time. It is only set if the include-timestamp option is enabled.
+	Timestamp time.Time
+	// LSN and NextLSN are only set if the include-lsn option is enabled.
+	LSN     pglogrepl.LSN
+	NextLSN pglogrepl.LSN
+	Change  *Change
+}
+
+type jsonV1Transaction struct {
+	Xid       uint32         `json:"xid"`
+	NextLSN   string         `json:"nextlsn"`
+	Timestamp string         `json:"timestamp"`
+	Change    []jsonV1Change `json:"change"`
+}
+
+type jsonV1Change struct {
+	Kind            Kind          `json:"kind"`
+	Schema          string        `json:"schema"`
+	Table           string        `json:"table"`
+	ColumnNames     []string      `json:"columnnames"`
+	ColumnTypes     []string      `json:"columntypes"`
+	ColumnTypeOIDs  []uint32      `json:"columntypeoids"`
+	ColumnOptionals []bool        `json:"columnoptionals"`
+	ColumnValues    []interface{} `json:"columnvalues"`
+	OldKeys         *struct {
+		KeyNames    []string      `json:"keynames"`
+		KeyTypes    []string      `json:"keytypes"`
+		KeyTypeOIDs []uint32      `json:"keytypeoids"`
+		KeyValues   []interface{} `json:"keyvalues"`
+	} `json:"oldkeys"`
+	PK *struct {
+		PKNames []string `json:"pknames"`
+		PKTypes []string `json:"pktypes"`
+	} `json:"pk"`
+	Transactional bool   `json:"transactional"`
+	Prefix        string `json:"prefix"`
+	Content       string `json:"content"`
+}
+
+type jsonV2Column struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"`
+	TypeOID  uint32      `json:"typeoid"`
+	Optional bool        `json:"optional"`
+	Value    interface{} `json:"value"`
+}
+
+type jsonV2Record struct {
+	Action        Action         `json:"action"`
+	Xid           uint32         `json:"xid"`
+	Timestamp     string         `json:"timestamp"`
+	LSN           string         `json:"lsn"`
+	NextLSN       string         `json:"nextlsn"`
+	Schema        string         `json:"schema"`
+	Table         string         `json:"table"`
+	Columns       []jsonV2Column `json:"columns"`
+	Identity      []jsonV2Column `json:"identity"`
+	PK            []jsonV2Column `json:"pk"`
+	Transactional bool           `json:"transactional"`
+	Prefix        string         `json:"prefix"`
+	Content       string         `json:"content"`
+}
+
+// ParseV1 parses a transaction emitted by wal2json with format-version 1.
+func ParseV1(data []byte) (*Transaction, error) {
+	var jt jsonV1Transaction
+	if err := unmarshal(data, &jt); err != nil {
+		return nil, fmt.Errorf("failed to parse wal2json transaction: %w", err)
+	}
+
+	tx := &Transaction{Xid: jt.Xid}
+	var err error
+	if tx.NextLSN, err = parseLSN("nextlsn", jt.NextLSN); err != nil {
+		return nil, err
+	}
+	if tx.Timestamp, err = parseTimestamp(jt.Timestamp); err != nil {
+		return nil, err
+	}
+
+	tx.Changes = make([]Change, 0, len(jt.Change))
+	for i := range jt.Change {
+		change, err := jt.Change[i].change()
+		if err != nil {
+			return nil, fmt.Errorf("change[%d]: %w", i, err)
+		}
+		tx.Changes = append(tx.Changes, change)
+	}
+	return tx, nil
+}
+
+func (jc *jsonV1Change) change() (Change, error) {
+	c := Change{
+		Kind:          jc.Kind,
+		Table:         Table{Schema: jc.Schema, Name: jc.Table},
+		Transactional: jc.Transactional,
+		Prefix:        jc.Prefix,
+		Content:       jc.Content,
+	}
+	switch c.Kind {
+	case KindInsert, KindUpdate, KindDelete, KindTruncate, KindMessage:
+	default:
+		return Change{}, fmt.Errorf("unknown change kind %q", jc.Kind)
+	}
+
+	var err error
+	c.Columns, err = v1Columns("column", jc.ColumnNames, jc.ColumnTypes, jc.ColumnTypeOIDs, jc.ColumnOptionals, jc.ColumnValues)
+	if err != nil {
+		return Change{}, err
+	}
+	if jc.OldKeys != nil {
+		c.OldKeys, err = v1Columns("key", jc.OldKeys.KeyNames, jc.OldKeys.KeyTypes, jc.OldKeys.KeyTypeOIDs, nil, jc.OldKeys.KeyValues)
+		if err != nil {
+			return Change{}, err
+		}
+	}
+	if jc.PK != nil {
+		c.PrimaryKey, err = v1Columns("pk", jc.PK.PKNames, jc.PK.PKTypes, nil, nil, nil)
+		if err != nil {
+			return Change{}, err
+		}
+	}
+	return c, nil
+}
+
+// v1Columns zips the parallel arrays format version 1 uses to describe columns. types, oids,
+// optionals and values may be nil if the corresponding option is not enabled.
+func v1Columns(prefix string, names, types []string, oids []uint32, optionals []bool, values []interface{}) ([]Column, error) {
+	if names == nil {
+		return nil, nil
+	}
+	check := func(field string, n int) error {
+		if n != 0 && n != len(names) {
+			return fmt.Errorf("%s%s has %d entries, expected %d", prefix, field, n, len(names))
+		}
+		return nil
+	}
+	if err := check("types", len(types)); err != nil {
+		return nil, err
+	}
+	if err := check("typeoids", len(oids)); err != nil {
+		return nil, err
+	}
+	if err := check("optionals", len(optionals)); err != nil {
+		return nil, err
+	}
+	if err := check("values", len(values)); err != nil {
+		return nil, err
+	}
+
+	columns := make([]Column, len(names))
+	for i, name := range names {
+		columns[i].Name = name
+		if types != nil {
+			columns[i].Type = types[i]
+		}
+		if oids != nil {
+			columns[i].TypeOID = oids[i]
+		}
+		if optionals != nil {
+			columns[i].Optional = optionals[i]
+		}
+		if values != nil {
+			columns[i].Value = values[i]
+		}
+	}
+	return columns, nil
+}
+
+// ParseV2 parses a single object emitted by wal2json with format-version 2.
+func ParseV2(data []byte) (*Record, error) {
+	var jr jsonV2Record
+	if err := unmarshal(data, &jr); err != nil {
+		return nil, fmt.Errorf("failed to parse wal2json record: %w", err)
+	}
+
+	r := &Record{Action: jr.Action, Xid: jr.Xid}
+	var err error
+	if r.Timestamp, err = parseTimestamp(jr.Timestamp); err != nil {
+		return nil, err
+	}
+	if r.LSN, err = parseLSN("lsn", jr.LSN); err != nil {
+		return nil, err
+	}
+	if r.NextLSN, err = parseLSN("nextlsn", jr.NextLSN); err != nil {
+		return nil, err
+	}
+
+	if jr.Action == ActionBegin || jr.Action == ActionCommit {
+		return r, nil
+	}
+	kind, ok := jr.Action.kind()
+	if !ok {
+		return nil, fmt.Errorf("unknown wal2json action %q", jr.Action)
+	}
+	r.Change = &Change{
+		Kind:          kind,
+		Table:         Table{Schema: jr.Schema, Name: jr.Table},
+		Columns:       v2Columns(jr.Columns),
+		OldKeys:       v2Columns(jr.Identity),
+		PrimaryKey:    v2Columns(jr.PK),
+		Transactional: jr.Transactional,
+		Prefix:        jr.Prefix,
+		Content:       jr.Content,
+	}
+	return r, nil
+}
+
+func v2Columns(jcs []jsonV2Column) []Column {
+	if jcs == nil {
+		return nil
+	}
+	columns := make([]Column, len(jcs))
+	for i, jc := range jcs {
+		columns[i] = Column(jc)
+	}
+	return columns
+}
+
+// unmarshal decodes data keeping numbers as json.Number so that int8 and numeric values do not
+// lose precision.
+func unmarshal(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func parseLSN(field, s string) (pglogrepl.LSN, error) {
+	if s == "" {
+		return 0, nil
+	}
+	lsn, err := pglogrepl.ParseLSN(s)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", field, err)
+	}
+	return lsn, nil
+}
+
+// timestampLayouts are the layouts of timestamptz values in the ISO DateStyle. The offset is
+// printed with minutes only when it is not a whole number of hours.
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07:00:00",
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	var err error
+	for _, layout := range timestampLayouts {
+		var t time.Time
+		t, err = time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("failed to parse timestamp: %w", err)
+}
@@ -0,0 +1,166 @@
+package wal2json_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pglogrepl/wal2json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseV1(t *testing.T) {
+	data := `{
+	"xid": 771,
+	"nextlsn": "0/16B2470",
+	"timestamp": "2024-02-26 10:15:30.123456+00",
+	"change": [
+		{
+			"kind": "insert",
+			"schema": "public",
+			"table": "t",
+			"columnnames": ["id", "name", "big"],
+			"columntypes": ["integer", "text", "bigint"],
+			"columnvalues": [1, "foo", 9007199254740993],
+			"pk": {"pknames": ["id"], "pktypes": ["integer"]}
+		},
+		{
+			"kind": "update",
+			"schema": "public",
+			"table": "t",
+			"columnnames": ["id", "name", "big"],
+			"columntypes": ["integer", "text", "bigint"],
+			"columnvalues": [2, null, 3],
+			"oldkeys": {"keynames": ["id"], "keytypes": ["integer"], "keyvalues": [1]}
+		},
+		{
+			"kind": "delete",
+			"schema": "public",
+			"table": "t",
+			"oldkeys": {"keynames": ["id"], "keytypes": ["integer"], "keyvalues": [2]}
+		},
+		{
+			"kind": "message",
+			"transactional": true,
+			"prefix": "app",
+			"content": "hello"
+		}
+	]
+}`
+
+	tx, err := wal2json.ParseV1([]byte(data))
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(771), tx.Xid)
+	assert.Equal(t, pglogrepl.LSN(0x16B2470), tx.NextLSN)
+	assert.True(t, time.Date(2024, 2, 26, 10, 15, 30, 123456000, time.UTC).Equal(tx.Timestamp))
+	require.Len(t, tx.Changes, 4)
+
+	insert := tx.Changes[0]
+	assert.Equal(t, wal2json.KindInsert, insert.Kind)
+	assert.Equal(t, wal2json.Table{Schema: "public", Name: "t"}, insert.Table)
+	assert.Equal(t, []wal2json.Column{
+		{Name: "id", Type: "integer", Value: json.Number("1")},
+		{Name: "name", Type: "text", Value: "foo"},
+		{Name: "big", Type: "bigint", Value: json.Number("9007199254740993")},
+	}, insert.Columns)
+	assert.Nil(t, insert.OldKeys)
+	assert.Equal(t, []wal2json.Column{{Name: "id", Type: "integer"}}, insert.PrimaryKey)
+
+	update := tx.Changes[1]
+	assert.Equal(t, wal2json.KindUpdate, update.Kind)
+	assert.Nil(t, update.Columns[1].Value)
+	assert.Equal(t, []wal2json.Column{{Name: "id", Type: "integer", Value: json.Number("1")}}, update.OldKeys)
+
+	del := tx.Changes[2]
+	assert.Equal(t, wal2json.KindDelete, del.Kind)
+	assert.Nil(t, del.Columns)
+	assert.Equal(t, []wal2json.Column{{Name: "id", Type: "integer", Value: json.Number("2")}}, del.OldKeys)
+
+	msg := tx.Changes[3]
+	assert.Equal(t, wal2json.KindMessage, msg.Kind)
+	assert.True(t, msg.Transactional)
+	assert.Equal(t, "app", msg.Prefix)
+	assert.Equal(t, "hello", msg.Content)
+}
+
+func TestParseV1WithoutOptionalFields(t *testing.T) {
+	tx, err := wal2json.ParseV1([]byte(`{"change":[]}`))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), tx.Xid)
+	assert.Equal(t, pglogrepl.LSN(0), tx.NextLSN)
+	assert.True(t, tx.Timestamp.IsZero())
+	assert.Empty(t, tx.Changes)
+}
+
+func TestParseV1TypeOIDsAndOptionals(t *testing.T) {
+	data := `{"change":[{"kind":"insert","schema":"s","table":"t","columnnames":["a"],"columntypes":["integer"],"columntypeoids":[23],"columnoptionals":[true],"columnvalues":[5]}]}`
+	tx, err := wal2json.ParseV1([]byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, []wal2json.Column{{Name: "a", Type: "integer", TypeOID: 23, Optional: true, Value: json.Number("5")}}, tx.Changes[0].Columns)
+}
+
+func TestParseV1Errors(t *testing.T) {
+	for name, data := range map[string]string{
+		"invalid json":      `{"change":`,
+		"unknown kind":      `{"change":[{"kind":"upsert"}]}`,
+		"mismatched arrays": `{"change":[{"kind":"insert","columnnames":["a","b"],"columnvalues":[1]}]}`,
+		"invalid lsn":       `{"nextlsn":"nope","change":[]}`,
+		"invalid timestamp": `{"timestamp":"yesterday","change":[]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := wal2json.ParseV1([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseV2(t *testing.T) {
+	begin, err := wal2json.ParseV2([]byte(`{"action":"B","xid":772,"timestamp":"2024-02-26 10:15:30.5+05:30","lsn":"0/16B2400","nextlsn":"0/16B2470"}`))
+	require.NoError(t, err)
+	assert.Equal(t, wal2json.ActionBegin, begin.Action)
+	assert.Equal(t, uint32(772), begin.Xid)
+	assert.True(t, time.Date(2024, 2, 26, 4, 45, 30, 500000000, time.UTC).Equal(begin.Timestamp))
+	assert.Equal(t, pglogrepl.LSN(0x16B2400), begin.LSN)
+	assert.Equal(t, pglogrepl.LSN(0x16B2470), begin.NextLSN)
+	assert.Nil(t, begin.Change)
+
+	insert, err := wal2json.ParseV2([]byte(`{"action":"I","schema":"public","table":"t","columns":[{"name":"id","type":"integer","typeoid":23,"value":1},{"name":"flag","type":"boolean","value":true}],"pk":[{"name":"id","type":"integer"}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, &wal2json.Change{
+		Kind:  wal2json.KindInsert,
+		Table: wal2json.Table{Schema: "public", Name: "t"},
+		Columns: []wal2json.Column{
+			{Name: "id", Type: "integer", TypeOID: 23, Value: json.Number("1")},
+			{Name: "flag", Type: "boolean", Value: true},
+		},
+		PrimaryKey: []wal2json.Column{{Name: "id", Type: "integer"}},
+	}, insert.Change)
+
+	update, err := wal2json.ParseV2([]byte(`{"action":"U","schema":"public","table":"t","columns":[{"name":"id","type":"integer","value":2}],"identity":[{"name":"id","type":"integer","value":1}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, wal2json.KindUpdate, update.Change.Kind)
+	assert.Equal(t, []wal2json.Column{{Name: "id", Type: "integer", Value: json.Number("1")}}, update.Change.OldKeys)
+
+	truncate, err := wal2json.ParseV2([]byte(`{"action":"T","schema":"public","table":"t"}`))
+	require.NoError(t, err)
+	assert.Equal(t, wal2json.KindTruncate, truncate.Change.Kind)
+
+	msg, err := wal2json.ParseV2([]byte(`{"action":"M","transactional":false,"prefix":"app","content":"hi"}`))
+	require.NoError(t, err)
+	assert.Equal(t, wal2json.KindMessage, msg.Change.Kind)
+	assert.Equal(t, "app", msg.Change.Prefix)
+	assert.Equal(t, "hi", msg.Change.Content)
+
+	commit, err := wal2json.ParseV2([]byte(`{"action":"C","xid":772}`))
+	require.NoError(t, err)
+	assert.Equal(t, wal2json.ActionCommit, commit.Action)
+	assert.Nil(t, commit.Change)
+}
+
+func TestParseV2UnknownAction(t *testing.T) {
+	_, err := wal2json.ParseV2([]byte(`{"action":"X"}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,109 @@
+package pglogrepl
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// wireDump writes the replication protocol messages of a ReplicationStream to the WireDump writer
+// of its options, one line per message followed by a hex dump of its data:
+//
+//	2024-01-02T03:04:05.000006Z recv XLogData wal_start=0/200 server_wal_end=0/300 server_time=... type=Begin len=21
+//	00000000  42 00 00 00 00 00 00 03  00 ...
+//	2024-01-02T03:04:05.000007Z send StandbyStatusUpdate write=0/200 flush=0/200 apply=0/200 reply_requested=false
+//
+// Write errors are ignored so that diagnostics never stop replication.
+type wireDump struct {
+	w io.Writer
+	// dataLimit is the size the hex dumps are truncated to, 0 for no limit.
+	dataLimit int
+	// logical reports whether the WAL data holds pgoutput messages whose type can be reported.
+	logical bool
+
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func newWireDump(options *ReplicationStreamOptions) *wireDump {
+	if options.WireDump == nil {
+		return nil
+	}
+	return &wireDump{w: options.WireDump, dataLimit: options.WireDumpDataLimit, logical: options.ProtoVersion > 0}
+}
+
+// received dumps msg, received from the server at t.
+func (d *wireDump) received(t time.Time, msg pgproto3.BackendMessage) {
+	cd, ok := msg.(*pgproto3.CopyData)
+	if !ok {
+		d.write(t, "recv", fmt.Sprintf("%T", msg)[len("*pgproto3."):], nil)
+		return
+	}
+	if len(cd.Data) == 0 {
+		d.write(t, "recv", "CopyData len=0", nil)
+		return
+	}
+
+	switch cd.Data[0] {
+	case PrimaryKeepaliveMessageByteID:
+		pkm, err := ParsePrimaryKeepaliveMessage(cd.Data[1:])
+		if err != nil {
+			d.write(t, "recv", "PrimaryKeepaliveMessage error="+err.Error(), cd.Data)
+			return
+		}
+		d.write(t, "recv", fmt.Sprintf("PrimaryKeepaliveMessage server_wal_end=%s server_time=%s reply_requested=%t",
+			pkm.ServerWALEnd, formatDumpTime(pkm.ServerTime), pkm.ReplyRequested), nil)
+	case XLogDataByteID:
+		xld, err := ParseXLogData(cd.Data[1:])
+		if err != nil {
+			d.write(t, "recv", "XLogData error="+err.Error(), cd.Data)
+			return
+		}
+		header := fmt.Sprintf("XLogData wal_start=%s server_wal_end=%s server_time=%s",
+			xld.WALStart, xld.ServerWALEnd, formatDumpTime(xld.ServerTime))
+		if d.logical && len(xld.WALData) > 0 {
+			header += " type=" + MessageType(xld.WALData[0]).String()
+		}
+		d.write(t, "recv", fmt.Sprintf("%s len=%d", header, len(xld.WALData)), xld.WALData)
+	default:
+		d.write(t, "recv", fmt.Sprintf("CopyData type=%q len=%d", cd.Data[0], len(cd.Data)), cd.Data)
+	}
+}
+
+// sent dumps ssu, sent to the server at t.
+func (d *wireDump) sent(t time.Time, ssu StandbyStatusUpdate) {
+	d.write(t, "send", fmt.Sprintf("StandbyStatusUpdate write=%s flush=%s apply=%s reply_requested=%t",
+		ssu.WALWritePosition, ssu.WALFlushPosition, ssu.WALApplyPosition, ssu.ReplyRequested), nil)
+}
+
+func (d *wireDump) write(t time.Time, direction, header string, data []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.buf.Reset()
+	d.buf.WriteString(formatDumpTime(t))
+	d.buf.WriteByte(' ')
+	d.buf.WriteString(direction)
+	d.buf.WriteByte(' ')
+	d.buf.WriteString(header)
+	d.buf.WriteByte('\n')
+	if len(data) > 0 {
+		truncated := d.dataLimit > 0 && len(data) > d.dataLimit
+		if truncated {
+			data = data[:d.dataLimit]
+		}
+		d.buf.WriteString(hex.Dump(data))
+		if truncated {
+			d.buf.WriteString("...\n")
+		}
+	}
+	io.WriteString(d.w, d.buf.String())
+}
+
+func formatDumpTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000Z")
+}